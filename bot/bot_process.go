@@ -97,6 +97,7 @@ type configuration struct {
 	encryptionKey        string              // Key for encrypting data (unlocks "real" key in brain)
 	historyProvider      string              // Name of the history provider to use
 	queueProviders       []string            // Queue providers to start after full robot initialization
+	queueTriggers        QueueTriggersConfig // Queue trigger dedupe window and maximum timestamp age
 	workSpace            string              // Read/Write directory where the robot does work
 	readyMessage         string              // optional channel message sent after startup readiness
 	readyChannel         string              // channel for readyMessage; defaults to defaultJobChannel
@@ -118,6 +119,7 @@ type configuration struct {
 	logDest              string          // log to stdout, stderr, or <filename>
 	defaultJobChannel    string          // where job statuses will post if not otherwise specified
 	timeOuts             runtimeTimeOutsConfig
+	webhookListener      WebhookListenerConfig
 }
 
 // The current configuration and task list
@@ -369,6 +371,7 @@ func initCrypt() bool {
 						cryptKey.Unlock()
 						encryptionInitialized = true
 						Log(robot.Info, "Successfully decrypted binary encryption key '%s'", keyFile)
+						loadRetiredEncryptionKey(keyFile, ik)
 					} else {
 						Log(robot.Error, "Decrypting binary encryption key '%s' from environment key '%s': %v", keyFile, keyEnv, err)
 					}
@@ -461,6 +464,7 @@ func run() {
 	waitForPluginInitQuiescence()
 	initializeRuntimeGitState()
 	startQueueProviderRuntimes()
+	startWebhookListener()
 	releaseStartupGate()
 	sendReadyMessageIfConfigured()
 	Log(robot.Info, "Robot is initialized and running")
@@ -501,7 +505,9 @@ func stop() {
 	Log(robot.Info, "Stop called with %d pipelines running", pr)
 	triggerPromptShutdownSignal()
 	shutdownQueueProviderRuntimes()
+	shutdownWebhookListener()
 	state.Wait()
+	saveTriggerDedupe()
 	brainFlushed := false
 	if interfaces.brain != nil {
 		if err := interfaces.brain.Flush(); err != nil {
@@ -19,6 +19,7 @@ import (
 // For aes brain encryption
 var cryptKey = struct {
 	key                       []byte
+	retired                   [][]byte // still readable during an unfinished key rotation
 	initializing, initialized bool
 	sync.RWMutex
 }{}
@@ -74,6 +75,7 @@ type updateRequest struct {
 	key   string
 	token string
 	datum *[]byte
+	ttl   *time.Duration // nil leaves any existing expiry alone
 	reply chan robot.RetVal
 }
 
@@ -117,6 +119,7 @@ var brainLocks = struct {
 func runBrain() {
 	// map key to status
 	memories := make(map[string]*memstatus)
+	expiries := &datumExpiries{}
 	brainTicker := time.NewTicker(memCycle)
 loop:
 	for {
@@ -139,6 +142,9 @@ loop:
 			case checkOutRequest:
 				creq := evt.(checkOutRequest)
 				memStat, exists := memories[creq.key]
+				if !exists {
+					expiries.expireIfDue(creq.key, time.Now())
+				}
 				if !exists {
 					lt, d, e, r := getDatum(creq.key, creq.rw)
 					if r != robot.Ok {
@@ -198,12 +204,18 @@ loop:
 					ur.reply <- robot.DatumLockExpired
 					continue
 				}
-				ur.reply <- storeDatum(ur.key, ur.datum)
+				ret := storeDatum(ur.key, ur.datum)
+				if ret == robot.Ok {
+					expiries.updated(ur.key, ur.ttl, time.Now())
+				}
+				ur.reply <- ret
 				if len(m.waiters) > 0 {
 					replyToWaiter(m)
 					continue
 				}
 				delete(memories, ur.key)
+			case forgetExpiryRequest:
+				expiries.updated(evt.(forgetExpiryRequest).key, &noExpiry, time.Now())
 			case quitRequest:
 				qr := evt.(quitRequest)
 				qr.reply <- struct{}{}
@@ -212,6 +224,7 @@ loop:
 		case <-brainTicker.C:
 			now := time.Now()
 			expireUserValidationRequests(now)
+			expiries.sweep(now, memories)
 			// Expire thread subscriptions - see thread_subscriptions.go
 			expiredSubscriptions, isDirty := expireSubscriptions(now)
 			if isDirty {
@@ -240,6 +253,9 @@ loop:
 			if isDirty {
 				go saveEphemeralMemories()
 			}
+			if triggerDedupeDirty() {
+				go saveTriggerDedupe()
+			}
 			for _, m := range memories {
 				switch m.state {
 				case newMemory:
@@ -296,7 +312,19 @@ func update(d, lt string, datum *[]byte) (ret robot.RetVal) {
 	}
 	reply := make(chan robot.RetVal)
 	Log(robot.Trace, "Updating datum %s, token: %s", d, lt)
-	brainChanEvents <- updateRequest{d, lt, datum, reply}
+	brainChanEvents <- updateRequest{d, lt, datum, nil, reply}
+	return <-reply
+}
+
+// updateWithTTL is update for a datum that expires after ttl; see
+// brain_expiry.go.
+func updateWithTTL(d, lt string, datum *[]byte, ttl time.Duration) (ret robot.RetVal) {
+	if lt == "" {
+		return robot.Ok
+	}
+	reply := make(chan robot.RetVal)
+	Log(robot.Trace, "Updating datum %s with ttl %s, token: %s", d, ttl, lt)
+	brainChanEvents <- updateRequest{d, lt, datum, &ttl, reply}
 	return <-reply
 }
 
@@ -340,6 +368,17 @@ func updateDatum(key, locktoken string, datum interface{}) (ret robot.RetVal) {
 	return update(key, locktoken, &dbytes)
 }
 
+// updateDatumWithTTL is the internal version of UpdateDatumWithTTL that uses
+// the key as-is
+func updateDatumWithTTL(key, locktoken string, datum interface{}, ttl time.Duration) (ret robot.RetVal) {
+	dbytes, err := json.Marshal(datum)
+	if err != nil {
+		Log(robot.Error, "Marshalling datum %s: %v", key, err)
+		return robot.DataFormatError
+	}
+	return updateWithTTL(key, locktoken, &dbytes, ttl)
+}
+
 // deleteDatum is the internal version of DeleteDatum that uses the key as-is
 func deleteDatum(key string) (ret robot.RetVal) {
 	locktoken, _, _, ret := checkout(key, true)
@@ -357,6 +396,7 @@ func deleteDatum(key string) (ret robot.RetVal) {
 		Log(robot.Error, "Deleting datum %s: %v", key, err)
 		return robot.BrainFailed
 	}
+	brainChanEvents <- forgetExpiryRequest{key}
 	return robot.Ok
 }
 
@@ -410,6 +450,15 @@ func (r Robot) UpdateDatum(key, locktoken string, datum interface{}) (ret robot.
 	return updateDatum(key, locktoken, datum)
 }
 
+// see robot/robot.go
+func (r Robot) UpdateDatumWithTTL(key, locktoken string, datum interface{}, ttl time.Duration) (ret robot.RetVal) {
+	w := getLockedWorker(r.tid)
+	w.Unlock()
+	ns := w.getNameSpace(r.currentTask)
+	key = ns + ":" + key
+	return updateDatumWithTTL(key, locktoken, datum, ttl)
+}
+
 // see robot/robot.go
 func (r Robot) DeleteDatum(key string) (ret robot.RetVal) {
 	w := getLockedWorker(r.tid)
@@ -616,7 +665,7 @@ func getDatum(dkey string, rw bool) (token string, databytes *[]byte, exists boo
 		return token, db, true, robot.Ok
 	}
 	if initialized {
-		decrypted, err = decrypt(*db, key)
+		decrypted, _, err = decryptWithKeyring(*db)
 		if err != nil {
 			// This should only ever happen with the CLI, but could corrupt
 			// the binary key.
@@ -664,7 +713,11 @@ func storeDatum(dkey string, datum *[]byte) robot.RetVal {
 		return robot.BrainFailed
 	}
 	datum = &encrypted
-	err = brain.Store(dkey, datum)
+	if kb, ok := brain.(keyedBrainStore); ok {
+		err = kb.StoreWithKeyID(dkey, datum, encryptionKeyID(key))
+	} else {
+		err = brain.Store(dkey, datum)
+	}
 	if err != nil {
 		Log(robot.Error, "Storing datum %s: %v", dkey, err)
 		return robot.BrainFailed
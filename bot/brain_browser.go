@@ -0,0 +1,328 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+// The brain browser lets an administrator see what plugins have stored
+// without writing a throwaway plugin: 'brain keys' lists memories by
+// namespace, and 'brain show' pretty-prints one with fields whose names
+// look secret replaced. Both are RequiredPrivateCommands in builtin-admin.
+
+const (
+	brainRedacted      = "<redacted>"
+	brainShowMaxLines  = 400
+	brainKeysMaxListed = 200
+)
+
+// defaultBrainRedactPatterns apply when builtin-admin has no
+// BrainRedactPatterns configured; redaction should never silently turn off.
+var defaultBrainRedactPatterns = []string{
+	`pass(word|wd|phrase)?`,
+	`secret`,
+	`token`,
+	`api[_-]?key`,
+	`private[_-]?key`,
+	`credential`,
+}
+
+type adminPluginConfig struct {
+	BrainRedactPatterns []string `json:"BrainRedactPatterns"`
+}
+
+// brainKeyStat is the listing information for one memory.
+type brainKeyStat struct {
+	Key       string
+	Size      int
+	Version   uint64
+	UpdatedAt time.Time
+}
+
+// brainKeyStats is implemented by brains that keep per-key metadata.
+type brainKeyStats interface {
+	KeyStat(key string) (brainKeyStat, bool, error)
+}
+
+// KeyStat reports the current size and version of key from its cache
+// metadata, without decrypting or reading the remote.
+func (b *cachedBrain) KeyStat(key string) (brainKeyStat, bool, error) {
+	if !keyRe.MatchString(key) {
+		return brainKeyStat{}, false, fmt.Errorf("invalid memory key %q", key)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	meta, exists, err := b.readMeta(key)
+	if err != nil || !exists || meta.Deleted {
+		return brainKeyStat{}, false, err
+	}
+	stat := brainKeyStat{Key: key, Version: meta.Version, UpdatedAt: meta.UpdatedAt}
+	if info, err := os.Stat(b.payloadPath(key)); err == nil {
+		stat.Size = int(info.Size())
+	}
+	return stat, true, nil
+}
+
+// brainKeyStatFor falls back to retrieving the payload for brains without
+// metadata, in which case only the size is known.
+func brainKeyStatFor(brain robot.SimpleBrain, key string) (brainKeyStat, bool, error) {
+	if stats, ok := brain.(brainKeyStats); ok {
+		return stats.KeyStat(key)
+	}
+	data, exists, err := brain.Retrieve(key)
+	if err != nil || !exists {
+		return brainKeyStat{}, false, err
+	}
+	return brainKeyStat{Key: key, Size: len(*data)}, true, nil
+}
+
+// brainNamespace returns the part of a memory key before the first ':',
+// which is the owning task's namespace for plugin memories.
+func brainNamespace(key string) string {
+	if i := strings.Index(key, ":"); i > 0 {
+		return key[:i]
+	}
+	return key
+}
+
+// brainKeyMatchesPrefix treats a bare prefix as a namespace, so "links"
+// matches "links:links" but not "linkshare:data".
+func brainKeyMatchesPrefix(key, prefix string) bool {
+	if strings.Contains(prefix, ":") {
+		return strings.HasPrefix(key, prefix)
+	}
+	return brainNamespace(key) == prefix
+}
+
+// compileRedactPatterns builds case-insensitive field name matchers.
+func compileRedactPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	if len(patterns) == 0 {
+		patterns = defaultBrainRedactPatterns
+	}
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile("(?i)" + p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BrainRedactPatterns entry %q: %v", p, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// redactMemory walks decoded JSON and replaces the value of every object
+// field whose name matches a pattern, returning the number redacted.
+func redactMemory(v interface{}, patterns []*regexp.Regexp) (interface{}, int) {
+	count := 0
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for field, child := range val {
+			if redactField(field, patterns) {
+				val[field] = brainRedacted
+				count++
+				continue
+			}
+			var n int
+			val[field], n = redactMemory(child, patterns)
+			count += n
+		}
+	case []interface{}:
+		for i, child := range val {
+			var n int
+			val[i], n = redactMemory(child, patterns)
+			count += n
+		}
+	}
+	return v, count
+}
+
+func redactField(field string, patterns []*regexp.Regexp) bool {
+	for _, re := range patterns {
+		if re.MatchString(field) {
+			return true
+		}
+	}
+	return false
+}
+
+// renderRedactedMemory pretty-prints a decrypted memory with secrets
+// redacted. Non-JSON memories can't be inspected field by field, so they
+// are withheld entirely.
+func renderRedactedMemory(data []byte, patterns []*regexp.Regexp) (string, int, error) {
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return "", 0, fmt.Errorf("memory is not JSON (%d bytes); not displaying it", len(data))
+	}
+	decoded, count := redactMemory(decoded, patterns)
+	// Encode without HTML escaping, so the redaction marker reads as
+	// "<redacted>" rather than "\u003credacted\u003e".
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(decoded); err != nil {
+		return "", 0, err
+	}
+	return strings.TrimRight(out.String(), "\n"), count, nil
+}
+
+func adminRedactPatterns(r Robot) ([]*regexp.Regexp, error) {
+	var cfg adminPluginConfig
+	if ret := r.GetTaskConfig(&cfg); ret != robot.Ok && ret != robot.NoConfigFound {
+		Log(robot.Warn, "builtin-admin failed to load configuration, using default redaction patterns: %s", ret)
+	}
+	return compileRedactPatterns(cfg.BrainRedactPatterns)
+}
+
+// adminBrainInternalKey refuses to display the engine's own memories.
+func adminBrainInternalKey(r Robot, key string) bool {
+	if key == botEncryptionKey || key == brainLockKey {
+		r.Say("'%s' is internal to the robot and isn't displayed.", key)
+		return true
+	}
+	return false
+}
+
+func adminBrainKeys(r Robot, args []string) {
+	brain := interfaces.brain
+	if brain == nil {
+		r.Say("No brain is configured.")
+		return
+	}
+	prefix := ""
+	if len(args) > 0 {
+		prefix = strings.TrimSpace(args[0])
+	}
+	keys, err := brain.List()
+	if err != nil {
+		r.Say("Error: %v", err)
+		return
+	}
+	var stats []brainKeyStat
+	for _, key := range keys {
+		if key == brainLockKey || (prefix != "" && !brainKeyMatchesPrefix(key, prefix)) {
+			continue
+		}
+		stat, exists, err := brainKeyStatFor(brain, key)
+		if err != nil {
+			r.Say("Error: %v", err)
+			return
+		}
+		if exists {
+			stats = append(stats, stat)
+		}
+	}
+	if len(stats) == 0 {
+		if prefix == "" {
+			r.Say("The brain is empty.")
+		} else {
+			r.Say("I don't have any memories matching '%s'.", prefix)
+		}
+		return
+	}
+	if prefix == "" {
+		r.Fixed().Say("%s", strings.Join(brainNamespaceSummary(stats), "\n"))
+		return
+	}
+	lines := []string{fmt.Sprintf("Memories matching '%s':", prefix)}
+	for i, stat := range stats {
+		if i == brainKeysMaxListed {
+			lines = append(lines, fmt.Sprintf("... and %d more; use a longer prefix", len(stats)-i))
+			break
+		}
+		line := fmt.Sprintf("%s  %d bytes", stat.Key, stat.Size)
+		if stat.Version > 0 {
+			line += fmt.Sprintf("  v%d  %s", stat.Version, stat.UpdatedAt.Local().Format(time.RFC3339))
+		}
+		lines = append(lines, line)
+	}
+	r.Fixed().Say("%s", strings.Join(lines, "\n"))
+}
+
+// brainNamespaceSummary totals keys and bytes per namespace.
+func brainNamespaceSummary(stats []brainKeyStat) []string {
+	type total struct {
+		keys, size int
+		updated    time.Time
+	}
+	totals := make(map[string]*total)
+	for _, stat := range stats {
+		ns := brainNamespace(stat.Key)
+		t, ok := totals[ns]
+		if !ok {
+			t = &total{}
+			totals[ns] = t
+		}
+		t.keys++
+		t.size += stat.Size
+		if stat.UpdatedAt.After(t.updated) {
+			t.updated = stat.UpdatedAt
+		}
+	}
+	namespaces := make([]string, 0, len(totals))
+	for ns := range totals {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	lines := []string{"Brain namespaces (use 'brain keys <namespace>' for details):"}
+	for _, ns := range namespaces {
+		t := totals[ns]
+		line := fmt.Sprintf("%s  %d keys  %d bytes", ns, t.keys, t.size)
+		if !t.updated.IsZero() {
+			line += "  last updated " + t.updated.Local().Format(time.RFC3339)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func adminBrainShow(r Robot, args []string) {
+	if len(args) == 0 || args[0] == "" {
+		r.Say("Usage: brain show <key>")
+		return
+	}
+	key := args[0]
+	if adminBrainInternalKey(r, key) {
+		return
+	}
+	patterns, err := adminRedactPatterns(r)
+	if err != nil {
+		r.Say("Error: %v", err)
+		return
+	}
+	_, data, exists, ret := getDatum(key, false)
+	if ret != robot.Ok {
+		r.Say("Unable to retrieve '%s': %s", key, ret)
+		return
+	}
+	if !exists {
+		r.Say("I don't have a memory named '%s'.", key)
+		return
+	}
+	rendered, redacted, err := renderRedactedMemory(*data, patterns)
+	if err != nil {
+		r.Say("Error: %v", err)
+		return
+	}
+	header := fmt.Sprintf("'%s', %d bytes", key, len(*data))
+	if stat, ok, _ := brainKeyStatFor(interfaces.brain, key); ok && stat.Version > 0 {
+		header += fmt.Sprintf(", version %d updated %s", stat.Version, stat.UpdatedAt.Local().Format(time.RFC3339))
+	}
+	if redacted > 0 {
+		header += fmt.Sprintf(", %d field(s) redacted", redacted)
+	}
+	lines := strings.Split(rendered, "\n")
+	if len(lines) > brainShowMaxLines {
+		lines = append(lines[:brainShowMaxLines], fmt.Sprintf("... %d more lines not shown", len(lines)-brainShowMaxLines))
+	}
+	Log(robot.Audit, "Memory '%s' displayed by %s", key, r.User)
+	r.Fixed().Say("%s:\n%s", header, strings.Join(lines, "\n"))
+}
@@ -0,0 +1,82 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderRedactedMemoryRedactsNestedSecretFields(t *testing.T) {
+	patterns, err := compileRedactPatterns(nil)
+	if err != nil {
+		t.Fatalf("compileRedactPatterns(defaults) error = %v", err)
+	}
+	data := []byte(`{"user":"alice","APIKey":"abc","hosts":[{"name":"db","Password":"hunter2"}],"auth":{"refresh_token":"xyz","scope":"read"}}`)
+	out, count, err := renderRedactedMemory(data, patterns)
+	if err != nil {
+		t.Fatalf("renderRedactedMemory() error = %v", err)
+	}
+	if count != 3 {
+		t.Fatalf("redacted %d fields, want 3:\n%s", count, out)
+	}
+	for _, secret := range []string{"abc", "hunter2", "xyz"} {
+		if strings.Contains(out, secret) {
+			t.Fatalf("output still contains %q:\n%s", secret, out)
+		}
+	}
+	for _, kept := range []string{`"user": "alice"`, `"scope": "read"`, `"name": "db"`} {
+		if !strings.Contains(out, kept) {
+			t.Fatalf("output missing %s:\n%s", kept, out)
+		}
+	}
+	if _, _, err := renderRedactedMemory([]byte("opaque value"), patterns); err == nil {
+		t.Fatal("non-JSON memory should be withheld")
+	}
+}
+
+func TestCompileRedactPatternsUsesConfiguredList(t *testing.T) {
+	patterns, err := compileRedactPatterns([]string{"^pin$"})
+	if err != nil {
+		t.Fatalf("compileRedactPatterns() error = %v", err)
+	}
+	out, count, _ := renderRedactedMemory([]byte(`{"PIN":"1234","token":"t"}`), patterns)
+	if count != 1 || strings.Contains(out, "1234") || !strings.Contains(out, `"t"`) {
+		t.Fatalf("configured patterns not applied: count=%d\n%s", count, out)
+	}
+	if _, err := compileRedactPatterns([]string{"("}); err == nil {
+		t.Fatal("invalid pattern should be an error rather than disabling redaction")
+	}
+}
+
+func TestBrainKeyStatsAndNamespaces(t *testing.T) {
+	brain, err := newLocalCachedBrain(BrainCacheConfig{Directory: t.TempDir()})
+	if err != nil {
+		t.Fatalf("newLocalCachedBrain: %v", err)
+	}
+	defer brain.Shutdown()
+	for key, value := range map[string]string{"links:links": "12345", "links:tags": "12", "linkshare:data": "1"} {
+		payload := []byte(value)
+		if err := brain.Store(key, &payload); err != nil {
+			t.Fatalf("Store(%s): %v", key, err)
+		}
+	}
+	stat, exists, err := brainKeyStatFor(brain, "links:links")
+	if err != nil || !exists || stat.Size != 5 || stat.Version == 0 || stat.UpdatedAt.IsZero() {
+		t.Fatalf("KeyStat(links:links) = %+v exists=%v err=%v", stat, exists, err)
+	}
+	if !brainKeyMatchesPrefix("links:tags", "links") || brainKeyMatchesPrefix("linkshare:data", "links") {
+		t.Fatal("bare prefix should match a whole namespace only")
+	}
+	if !brainKeyMatchesPrefix("links:tags", "links:t") {
+		t.Fatal("prefix containing ':' should match key prefixes")
+	}
+	keys, _ := brain.List()
+	var stats []brainKeyStat
+	for _, key := range keys {
+		s, _, _ := brainKeyStatFor(brain, key)
+		stats = append(stats, s)
+	}
+	summary := brainNamespaceSummary(stats)
+	if len(summary) != 3 || !strings.HasPrefix(summary[1], "links  2 keys  7 bytes") || !strings.HasPrefix(summary[2], "linkshare  1 keys") {
+		t.Fatalf("brainNamespaceSummary() = %q", summary)
+	}
+}
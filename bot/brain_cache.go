@@ -22,13 +22,18 @@ import (
 const brainCacheFormat = "gopherbot-brain-v3"
 
 type BrainCacheConfig struct {
-	Directory string `yaml:"Directory"`
+	Directory         string `yaml:"Directory"`
+	SnapshotDirectory string `yaml:"SnapshotDirectory"`
+	KeepVersions      int    `yaml:"KeepVersions"` // prior versions retained per key; 0 disables key history
 }
 
 func defaultBrainCacheConfig(cfg BrainCacheConfig) BrainCacheConfig {
 	if strings.TrimSpace(cfg.Directory) == "" {
 		cfg.Directory = filepath.Join("state", "brain-cache")
 	}
+	if strings.TrimSpace(cfg.SnapshotDirectory) == "" {
+		cfg.SnapshotDirectory = defaultBrainSnapshotDirectory(cfg.Directory)
+	}
 	return cfg
 }
 
@@ -63,6 +68,7 @@ type brainCacheMeta struct {
 	Deleted   bool      `json:"deleted"`
 	UpdatedAt time.Time `json:"updated_at"`
 	SyncedAt  time.Time `json:"synced_at,omitempty"`
+	KeyID     string    `json:"key_id,omitempty"` // data key that encrypted the payload; see key_rotation.go
 }
 
 type brainCacheOutboxEntry struct {
@@ -292,6 +298,7 @@ func (b *cachedBrain) hydrateFromV3Remote() error {
 			Deleted:   record.Deleted,
 			UpdatedAt: record.UpdatedAt,
 			SyncedAt:  time.Now().UTC(),
+			KeyID:     record.KeyID,
 		}
 		if localMeta.UpdatedAt.IsZero() {
 			localMeta.UpdatedAt = time.Now().UTC()
@@ -350,6 +357,11 @@ func (b *cachedBrain) loadControl() error {
 }
 
 func (b *cachedBrain) Store(key string, blob *[]byte) error {
+	return b.StoreWithKeyID(key, blob, "")
+}
+
+// StoreWithKeyID stores a memory, recording which data key encrypted it.
+func (b *cachedBrain) StoreWithKeyID(key string, blob *[]byte, keyID string) error {
 	if blob == nil {
 		empty := []byte{}
 		blob = &empty
@@ -359,6 +371,9 @@ func (b *cachedBrain) Store(key string, blob *[]byte) error {
 	if b.stopped {
 		return fmt.Errorf("brain is shutting down; no new writes accepted")
 	}
+	if err := b.archiveCurrentLocked(key); err != nil {
+		return err
+	}
 	version := b.reserveVersionLocked()
 	now := time.Now().UTC()
 	meta := brainCacheMeta{
@@ -367,6 +382,7 @@ func (b *cachedBrain) Store(key string, blob *[]byte) error {
 		Version:   version,
 		Checksum:  checksumBytes(*blob),
 		UpdatedAt: now,
+		KeyID:     keyID,
 	}
 	if err := b.writePayload(key, *blob); err != nil {
 		return err
@@ -438,6 +454,14 @@ func (b *cachedBrain) importV3Record(record robot.RemoteBrainRecord) error {
 		Deleted:   record.Deleted,
 		UpdatedAt: record.UpdatedAt,
 		SyncedAt:  now,
+		KeyID:     record.KeyID,
+	}
+	if current, exists, err := b.readMeta(record.Key); err != nil {
+		return err
+	} else if exists && current.Version != record.Version {
+		if err := b.archiveCurrentLocked(record.Key); err != nil {
+			return err
+		}
 	}
 	if record.Deleted {
 		_ = os.Remove(b.payloadPath(record.Key))
@@ -513,6 +537,9 @@ func (b *cachedBrain) List() ([]string, error) {
 func (b *cachedBrain) Delete(key string) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
+	if err := b.archiveCurrentLocked(key); err != nil {
+		return err
+	}
 	version := b.reserveVersionLocked()
 	now := time.Now().UTC()
 	meta := brainCacheMeta{
@@ -709,6 +736,7 @@ func (b *cachedBrain) syncOutboxEntryLocked(entry brainCacheOutboxEntry, enforce
 		Checksum:  meta.Checksum,
 		Deleted:   meta.Deleted,
 		UpdatedAt: meta.UpdatedAt,
+		KeyID:     meta.KeyID,
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -972,11 +1000,15 @@ func (b *cachedBrain) writePayload(key string, payload []byte) error {
 }
 
 func (b *cachedBrain) writeMeta(meta brainCacheMeta) error {
+	return b.writeMetaFile(b.metaPath(meta.Key), meta)
+}
+
+func (b *cachedBrain) writeMetaFile(path string, meta brainCacheMeta) error {
 	data, err := json.MarshalIndent(meta, "", "  ")
 	if err != nil {
 		return err
 	}
-	return writeAtomicFile(b.metaPath(meta.Key), data, 0600)
+	return writeAtomicFile(path, data, 0600)
 }
 
 func (b *cachedBrain) readMeta(key string) (brainCacheMeta, bool, error) {
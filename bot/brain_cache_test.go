@@ -177,6 +177,53 @@ func TestRemoteBrainCacheHydratesV3ThenUsesCheckpointOnRestart(t *testing.T) {
 	}
 }
 
+func TestRemoteBrainCacheRoundTripsKeyID(t *testing.T) {
+	payload := []byte("one")
+	remote := newTestRemote(map[string]robot.RemoteBrainRecord{
+		"alpha": {
+			Key:       "alpha",
+			Payload:   payload,
+			Format:    brainCacheFormat,
+			Version:   7,
+			Checksum:  checksumBytes(payload),
+			UpdatedAt: time.Now().UTC(),
+			KeyID:     "0ld0ld00",
+		},
+	})
+	brain, err := newRemoteCachedBrain(BrainCacheConfig{Directory: t.TempDir()}, remote)
+	if err != nil {
+		t.Fatalf("newRemoteCachedBrain: %v", err)
+	}
+	defer brain.Shutdown()
+	if meta, _, _ := brain.readMeta("alpha"); meta.KeyID != "0ld0ld00" {
+		t.Fatalf("hydrated KeyID = %q, want 0ld0ld00", meta.KeyID)
+	}
+	beta := []byte("two")
+	if err := brain.StoreWithKeyID("beta", &beta, "0e110e11"); err != nil {
+		t.Fatalf("StoreWithKeyID: %v", err)
+	}
+	if err := brain.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if got := remote.records["beta"].KeyID; got != "0e110e11" {
+		t.Fatalf("pushed KeyID = %q, want 0e110e11", got)
+	}
+	imported := []byte("three")
+	if err := brain.importV3Record(robot.RemoteBrainRecord{
+		Key:      "gamma",
+		Payload:  imported,
+		Format:   brainCacheFormat,
+		Version:  20,
+		Checksum: checksumBytes(imported),
+		KeyID:    "0e110e11",
+	}); err != nil {
+		t.Fatalf("importV3Record: %v", err)
+	}
+	if meta, _, _ := brain.readMeta("gamma"); meta.KeyID != "0e110e11" {
+		t.Fatalf("imported KeyID = %q, want 0e110e11", meta.KeyID)
+	}
+}
+
 func TestRemoteBrainCacheCheckpointUsesProviderRetryPolicy(t *testing.T) {
 	payload := []byte("one")
 	remote := newTestRemote(map[string]robot.RemoteBrainRecord{
@@ -140,6 +140,7 @@ func cliPullBrain(opts brainPullOptions) error {
 				Version:   localMeta.Version,
 				Checksum:  localMeta.Checksum,
 				UpdatedAt: localMeta.UpdatedAt,
+				KeyID:     localMeta.KeyID,
 			}); err != nil {
 				return err
 			}
@@ -296,6 +297,7 @@ func cliRestoreBrain(opts brainRestoreOptions) error {
 				Version:   meta.Version,
 				Checksum:  meta.Checksum,
 				UpdatedAt: meta.UpdatedAt,
+				KeyID:     meta.KeyID,
 			}); err != nil {
 				return err
 			}
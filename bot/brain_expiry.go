@@ -0,0 +1,152 @@
+package bot
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+// Expiring memories. UpdateDatumWithTTL records an expiry time for the key
+// in an engine-owned index datum, which is encrypted and synced like any
+// other memory, so expiries survive restarts, cache rebuilds and snapshots.
+// runBrain owns the in-memory copy: it sweeps expired keys every memCycle,
+// deleting them through the brain (for a cached brain, a tombstone that is
+// written through to the remote), and treats a checkout of an expired key
+// as a miss even if the sweep hasn't reached it yet.
+
+const brainExpiryIndexKey = "bot:expiries"
+
+// noExpiry is passed to datumExpiries.updated to clear an expiry.
+var noExpiry time.Duration
+
+// forgetExpiryRequest tells runBrain a datum was deleted, so a later write
+// of the same key doesn't inherit the old expiry.
+type forgetExpiryRequest struct {
+	key string
+}
+
+// datumExpiries is only used from the runBrain goroutine.
+type datumExpiries struct {
+	at     map[string]time.Time
+	loaded bool
+}
+
+// load reads the expiry index the first time it's needed; it fails until
+// the brain and encryption are initialized.
+func (e *datumExpiries) load() bool {
+	if e.loaded {
+		return true
+	}
+	if interfaces.brain == nil {
+		return false
+	}
+	cryptKey.RLock()
+	initialized := cryptKey.initialized
+	cryptKey.RUnlock()
+	if !initialized {
+		return false
+	}
+	_, data, exists, ret := getDatum(brainExpiryIndexKey, false)
+	if ret != robot.Ok {
+		return false
+	}
+	e.at = make(map[string]time.Time)
+	if exists {
+		if err := json.Unmarshal(*data, &e.at); err != nil {
+			Log(robot.Error, "Discarding unreadable memory expiry index '%s': %v", brainExpiryIndexKey, err)
+			e.at = make(map[string]time.Time)
+		}
+	}
+	e.loaded = true
+	return true
+}
+
+func (e *datumExpiries) save() {
+	data, err := json.Marshal(e.at)
+	if err != nil {
+		Log(robot.Error, "Marshalling memory expiry index: %v", err)
+		return
+	}
+	if ret := storeDatum(brainExpiryIndexKey, &data); ret != robot.Ok {
+		Log(robot.Error, "Storing memory expiry index: %s", ret)
+	}
+}
+
+// updated records a successful write of key. A nil ttl (plain UpdateDatum)
+// keeps a pending expiry, but a write after the expiry has passed creates a
+// new, permanent datum.
+func (e *datumExpiries) updated(key string, ttl *time.Duration, now time.Time) {
+	if !e.load() {
+		if ttl != nil && *ttl > 0 {
+			Log(robot.Error, "Unable to record expiry for memory '%s'; it will not expire", key)
+		}
+		return
+	}
+	old, had := e.at[key]
+	switch {
+	case ttl == nil:
+		if had && !old.After(now) {
+			delete(e.at, key)
+			e.save()
+		}
+	case *ttl <= 0:
+		if had {
+			delete(e.at, key)
+			e.save()
+		}
+	default:
+		e.at[key] = now.Add(*ttl)
+		e.save()
+	}
+}
+
+// expire deletes key from the brain if it's still there.
+func (e *datumExpiries) expire(key string) bool {
+	brain := interfaces.brain
+	if _, exists, err := brain.Retrieve(key); err != nil {
+		Log(robot.Error, "Checking expired memory '%s': %v", key, err)
+		return false
+	} else if exists {
+		if err := brain.Delete(key); err != nil {
+			Log(robot.Error, "Deleting expired memory '%s': %v", key, err)
+			return false
+		}
+		Log(robot.Debug, "Expired memory '%s'", key)
+	}
+	delete(e.at, key)
+	return true
+}
+
+// expireIfDue is called before a checkout that doesn't hold the lock.
+func (e *datumExpiries) expireIfDue(key string, now time.Time) {
+	if !e.load() {
+		return
+	}
+	if at, ok := e.at[key]; ok && !at.After(now) && e.expire(key) {
+		e.save()
+	}
+}
+
+// sweep expires every due key that isn't currently checked out; locked keys
+// are picked up on a later pass.
+func (e *datumExpiries) sweep(now time.Time, locked map[string]*memstatus) {
+	if !e.load() || len(e.at) == 0 {
+		return
+	}
+	changed := false
+	for key, at := range e.at {
+		if at.After(now) {
+			continue
+		}
+		if _, busy := locked[key]; busy {
+			continue
+		}
+		if e.expire(key) {
+			changed = true
+		}
+	}
+	if changed {
+		e.save()
+	}
+}
@@ -0,0 +1,68 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+func TestDatumExpiriesSweepAndTombstone(t *testing.T) {
+	f := newKeyRotationFixture(t)
+	now := time.Now()
+	expiries := &datumExpiries{}
+	ttl := time.Minute
+	expiries.updated("links:links", &ttl, now)
+	expiries.updated("lists:lists", &ttl, now)
+
+	// A plain update keeps the pending expiry.
+	expiries.updated("links:links", nil, now)
+	if _, ok := expiries.at["links:links"]; !ok {
+		t.Fatal("UpdateDatum dropped a pending expiry")
+	}
+	// The index is persisted, so a fresh runBrain picks it up.
+	reloaded := &datumExpiries{}
+	if !reloaded.load() || len(reloaded.at) != 2 {
+		t.Fatalf("reloaded expiry index = %v", reloaded.at)
+	}
+
+	later := now.Add(2 * time.Minute)
+	expiries.sweep(later, map[string]*memstatus{"lists:lists": {}})
+	if _, exists, _ := f.brain.Retrieve("links:links"); exists {
+		t.Fatal("expired memory links:links was not deleted")
+	}
+	meta, _, _ := f.brain.readMeta("links:links")
+	if !meta.Deleted {
+		t.Fatalf("expired memory has no tombstone: %+v", meta)
+	}
+	if _, exists, _ := f.brain.Retrieve("lists:lists"); !exists {
+		t.Fatal("checked-out memory was expired by the sweep")
+	}
+
+	expiries.expireIfDue("lists:lists", later)
+	if _, data, exists, ret := getDatum("lists:lists", false); ret != robot.Ok || exists {
+		t.Fatalf("getDatum(lists:lists) after expiry = %q exists=%v ret=%s", stringValue(data), exists, ret)
+	}
+	if len(expiries.at) != 0 {
+		t.Fatalf("expiry index not emptied: %v", expiries.at)
+	}
+}
+
+func TestDatumExpiriesPermanentAndRewrittenKeys(t *testing.T) {
+	newKeyRotationFixture(t)
+	now := time.Now()
+	expiries := &datumExpiries{}
+	ttl := time.Minute
+	expiries.updated("links:links", &ttl, now)
+	expiries.updated("links:links", &noExpiry, now)
+	if _, ok := expiries.at["links:links"]; ok {
+		t.Fatal("ttl <= 0 should make the memory permanent")
+	}
+
+	// A write after the expiry passed creates a new, permanent memory.
+	expiries.updated("lists:lists", &ttl, now)
+	expiries.updated("lists:lists", nil, now.Add(2*time.Minute))
+	if _, ok := expiries.at["lists:lists"]; ok {
+		t.Fatal("rewriting an expired memory should not inherit its expiry")
+	}
+}
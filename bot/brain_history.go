@@ -0,0 +1,463 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+// Key history keeps the last BrainCache.KeepVersions superseded versions of
+// each memory under history/<encoded key>/, so an administrator can inspect
+// and undo a bad write. History is local to the cache directory; it is not
+// synced to the remote brain and is discarded when the cache is re-imported.
+
+// brainHistoryVersion describes one retained version of a memory.
+type brainHistoryVersion struct {
+	Meta    brainCacheMeta
+	Size    int
+	Current bool
+}
+
+// brainKeyHistory is implemented by brains that retain prior versions.
+type brainKeyHistory interface {
+	KeyHistory(key string) ([]brainHistoryVersion, error)
+	KeyVersion(key string, version uint64) ([]byte, brainCacheMeta, error)
+}
+
+var errBrainVersionNotFound = errors.New("version not found")
+
+func (b *cachedBrain) historyDir() string { return filepath.Join(b.cfg.Directory, "history") }
+
+func (b *cachedBrain) historyKeyDir(key string) string {
+	return filepath.Join(b.historyDir(), encodeBrainCacheKey(key))
+}
+
+func (b *cachedBrain) historyPath(key string, version uint64, ext string) string {
+	return filepath.Join(b.historyKeyDir(key), fmt.Sprintf("%020d%s", version, ext))
+}
+
+// engineBookkeepingPrefix marks memories the engine rewrites for its own
+// state (dedupe, schedules, reminders); they aren't worth versioning.
+const engineBookkeepingPrefix = "bot:_"
+
+// versionedKey reports whether key gets history. The instance lock, data
+// key, expiry index and engine bookkeeping change too often, or are too
+// sensitive, to keep.
+func versionedKey(key string) bool {
+	switch key {
+	case brainLockKey, botEncryptionKey, brainExpiryIndexKey:
+		return false
+	}
+	return !strings.HasPrefix(key, engineBookkeepingPrefix)
+}
+
+// archiveCurrentLocked copies the current version of key into its history
+// before it is replaced, then prunes history to KeepVersions entries.
+func (b *cachedBrain) archiveCurrentLocked(key string) error {
+	if b.cfg.KeepVersions <= 0 || !versionedKey(key) {
+		return nil
+	}
+	meta, exists, err := b.readMeta(key)
+	if err != nil || !exists {
+		return err
+	}
+	if _, err := os.Stat(b.historyPath(key, meta.Version, ".json")); err == nil {
+		return b.pruneHistoryLocked(key)
+	}
+	if !meta.Deleted {
+		payload, err := os.ReadFile(b.payloadPath(key))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if err := writeAtomicFile(b.historyPath(key, meta.Version, ".blob"), payload, 0600); err != nil {
+			return err
+		}
+	}
+	if err := b.writeMetaFile(b.historyPath(key, meta.Version, ".json"), meta); err != nil {
+		return err
+	}
+	return b.pruneHistoryLocked(key)
+}
+
+func (b *cachedBrain) pruneHistoryLocked(key string) error {
+	versions, err := b.archivedVersions(key)
+	if err != nil {
+		return err
+	}
+	for len(versions) > b.cfg.KeepVersions {
+		oldest := versions[len(versions)-1]
+		versions = versions[:len(versions)-1]
+		for _, ext := range []string{".blob", ".json"} {
+			if err := os.Remove(b.historyPath(key, oldest.Version, ext)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// archivedVersions returns archived metadata for key, newest first.
+func (b *cachedBrain) archivedVersions(key string) ([]brainCacheMeta, error) {
+	entries, err := os.ReadDir(b.historyKeyDir(key))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	metas := make([]brainCacheMeta, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		meta, err := b.readMetaFile(filepath.Join(b.historyKeyDir(key), entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		metas = append(metas, meta)
+	}
+	sort.Slice(metas, func(i, j int) bool { return metas[i].Version > metas[j].Version })
+	return metas, nil
+}
+
+// KeyHistory lists the current version of key followed by retained prior
+// versions, newest first.
+func (b *cachedBrain) KeyHistory(key string) ([]brainHistoryVersion, error) {
+	if !keyRe.MatchString(key) {
+		return nil, fmt.Errorf("invalid memory key %q", key)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var versions []brainHistoryVersion
+	meta, exists, err := b.readMeta(key)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		current := brainHistoryVersion{Meta: meta, Current: true}
+		if info, err := os.Stat(b.payloadPath(key)); err == nil && !meta.Deleted {
+			current.Size = int(info.Size())
+		}
+		versions = append(versions, current)
+	}
+	archived, err := b.archivedVersions(key)
+	if err != nil {
+		return nil, err
+	}
+	for _, old := range archived {
+		if exists && old.Version == meta.Version {
+			continue
+		}
+		version := brainHistoryVersion{Meta: old}
+		if info, err := os.Stat(b.historyPath(key, old.Version, ".blob")); err == nil && !old.Deleted {
+			version.Size = int(info.Size())
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// KeyVersion returns the stored (still encrypted) payload for one retained
+// version of key.
+func (b *cachedBrain) KeyVersion(key string, version uint64) ([]byte, brainCacheMeta, error) {
+	if !keyRe.MatchString(key) {
+		return nil, brainCacheMeta{}, fmt.Errorf("invalid memory key %q", key)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	meta, exists, err := b.readMeta(key)
+	if err != nil {
+		return nil, meta, err
+	}
+	payloadPath := b.payloadPath(key)
+	if !exists || meta.Version != version {
+		meta, err = b.readMetaFile(b.historyPath(key, version, ".json"))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, meta, fmt.Errorf("%s version %d: %w", key, version, errBrainVersionNotFound)
+		}
+		if err != nil {
+			return nil, meta, err
+		}
+		payloadPath = b.historyPath(key, version, ".blob")
+	}
+	if meta.Deleted {
+		return nil, meta, nil
+	}
+	payload, err := os.ReadFile(payloadPath)
+	if err != nil {
+		return nil, meta, err
+	}
+	if checksumBytes(payload) != meta.Checksum {
+		return nil, meta, fmt.Errorf("%s version %d checksum mismatch", key, version)
+	}
+	return payload, meta, nil
+}
+
+// decryptedKeyVersion returns the plaintext for a retained version, or nil
+// for a tombstone.
+func decryptedKeyVersion(history brainKeyHistory, key string, version uint64) ([]byte, brainCacheMeta, error) {
+	payload, meta, err := history.KeyVersion(key, version)
+	if err != nil || meta.Deleted {
+		return nil, meta, err
+	}
+	plain, err := decryptMemoryPayload(payload)
+	if err != nil {
+		return nil, meta, err
+	}
+	return plain, meta, nil
+}
+
+// redactedMemoryLines renders a memory as indented JSON lines for diffing,
+// with the same redaction as 'brain show'; a tombstone (nil) has no lines.
+// Non-JSON memories are withheld, as they are by 'brain show'.
+func redactedMemoryLines(data []byte, patterns []*regexp.Regexp) ([]string, int, error) {
+	if data == nil {
+		return nil, 0, nil
+	}
+	rendered, redacted, err := renderRedactedMemory(data, patterns)
+	if err != nil {
+		return nil, 0, err
+	}
+	return strings.Split(rendered, "\n"), redacted, nil
+}
+
+// redactedDiff diffs two versions of a memory with secrets redacted,
+// returning the diff, whether anything visible changed and how many fields
+// were redacted.
+func redactedDiff(before, after []byte, patterns []*regexp.Regexp) ([]string, bool, int, error) {
+	a, redactedA, err := redactedMemoryLines(before, patterns)
+	if err != nil {
+		return nil, false, 0, err
+	}
+	b, redactedB, err := redactedMemoryLines(after, patterns)
+	if err != nil {
+		return nil, false, 0, err
+	}
+	diff := diffLines(a, b)
+	changed := false
+	for _, line := range diff {
+		if !strings.HasPrefix(line, "  ") {
+			changed = true
+			break
+		}
+	}
+	return diff, changed, redactedA + redactedB, nil
+}
+
+// diffLines produces a minimal unified-style line diff from a to b, without
+// hunk headers; memories are small enough that whole-file output is fine.
+func diffLines(a, b []string) []string {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	out := make([]string, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, "  "+a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, "- "+a[i])
+			i++
+		default:
+			out = append(out, "+ "+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		out = append(out, "- "+a[i])
+	}
+	for ; j < len(b); j++ {
+		out = append(out, "+ "+b[j])
+	}
+	return out
+}
+
+func adminBrainHistoryBackend(r Robot) (brainKeyHistory, bool) {
+	history, ok := interfaces.brain.(brainKeyHistory)
+	if !ok {
+		r.Say("Key history isn't available for this robot's brain.")
+		return nil, false
+	}
+	return history, true
+}
+
+func adminBrainHistory(r Robot, args []string) {
+	if len(args) == 0 || args[0] == "" {
+		r.Say("Usage: brain history <key>")
+		return
+	}
+	history, ok := adminBrainHistoryBackend(r)
+	if !ok {
+		return
+	}
+	key := args[0]
+	if adminBrainInternalKey(r, key) {
+		return
+	}
+	versions, err := history.KeyHistory(key)
+	if err != nil {
+		r.Say("Error: %v", err)
+		return
+	}
+	if len(versions) == 0 {
+		r.Say("I don't have any versions of '%s'.", key)
+		return
+	}
+	lines := []string{fmt.Sprintf("Versions of '%s', newest first:", key)}
+	for _, v := range versions {
+		line := fmt.Sprintf("%d  %s  ", v.Meta.Version, v.Meta.UpdatedAt.Local().Format(time.RFC3339))
+		if v.Meta.Deleted {
+			line += "deleted"
+		} else {
+			line += fmt.Sprintf("%d bytes", v.Size)
+		}
+		if v.Current {
+			line += " (current)"
+		}
+		lines = append(lines, line)
+	}
+	r.Fixed().Say(strings.Join(lines, "\n"))
+}
+
+func adminBrainDiff(r Robot, args []string) {
+	if len(args) < 3 {
+		r.Say("Usage: brain diff <key> <version> <version>")
+		return
+	}
+	history, ok := adminBrainHistoryBackend(r)
+	if !ok {
+		return
+	}
+	key := args[0]
+	if adminBrainInternalKey(r, key) {
+		return
+	}
+	from, err1 := strconv.ParseUint(args[1], 10, 64)
+	to, err2 := strconv.ParseUint(args[2], 10, 64)
+	if err1 != nil || err2 != nil {
+		r.Say("Versions must be positive integers, as shown by 'brain history %s'.", key)
+		return
+	}
+	patterns, err := adminRedactPatterns(r)
+	if err != nil {
+		r.Say("Error: %v", err)
+		return
+	}
+	before, _, err := decryptedKeyVersion(history, key, from)
+	if err != nil {
+		r.Say("Error: %v", err)
+		return
+	}
+	after, _, err := decryptedKeyVersion(history, key, to)
+	if err != nil {
+		r.Say("Error: %v", err)
+		return
+	}
+	diff, changed, redacted, err := redactedDiff(before, after, patterns)
+	if err != nil {
+		r.Say("Error: %v", err)
+		return
+	}
+	if !changed {
+		if redacted > 0 {
+			r.Say("Versions %d and %d of '%s' don't differ outside redacted fields.", from, to, key)
+		} else {
+			r.Say("Versions %d and %d of '%s' are identical.", from, to, key)
+		}
+		return
+	}
+	header := fmt.Sprintf("--- %s@%d\n+++ %s@%d\n", key, from, key, to)
+	if redacted > 0 {
+		header += fmt.Sprintf("(%d field(s) redacted)\n", redacted)
+	}
+	Log(robot.Audit, "Versions %d and %d of memory '%s' diffed by %s", from, to, key, r.User)
+	r.Fixed().Say(header + strings.Join(diff, "\n"))
+}
+
+func adminBrainRevert(r Robot, args []string) {
+	if len(args) < 2 {
+		r.Say("Usage: brain revert <key> <version>")
+		return
+	}
+	history, ok := adminBrainHistoryBackend(r)
+	if !ok {
+		return
+	}
+	key := args[0]
+	if adminBrainInternalKey(r, key) {
+		return
+	}
+	version, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		r.Say("Versions must be positive integers, as shown by 'brain history %s'.", key)
+		return
+	}
+	patterns, err := adminRedactPatterns(r)
+	if err != nil {
+		r.Say("Error: %v", err)
+		return
+	}
+	plain, meta, err := decryptedKeyVersion(history, key, version)
+	if err != nil {
+		r.Say("Error: %v", err)
+		return
+	}
+	if meta.Deleted {
+		if ret := deleteDatum(key); ret != robot.Ok {
+			r.Say("Unable to delete '%s': %s", key, ret)
+			return
+		}
+		Log(robot.Audit, "Memory '%s' reverted to deleted version %d by %s", key, version, r.User)
+		r.Say("Ok, '%s' was deleted at version %d, so I deleted it again.", key, version)
+		return
+	}
+	// Check the datum out so the revert waits for (and can't be clobbered by)
+	// any plugin currently holding the lock.
+	locktoken, current, _, ret := checkout(key, true)
+	if ret != robot.Ok {
+		r.Say("Unable to check out '%s': %s", key, ret)
+		return
+	}
+	if ret := update(key, locktoken, &plain); ret != robot.Ok {
+		r.Say("Unable to revert '%s': %s", key, ret)
+		return
+	}
+	Log(robot.Audit, "Memory '%s' reverted to version %d by %s", key, version, r.User)
+	msg := fmt.Sprintf("Ok, I restored '%s' to the contents of version %d; the previous value is kept in its history.", key, version)
+	var previous []byte
+	if current != nil {
+		previous = *current
+	}
+	diff, changed, _, err := redactedDiff(previous, plain, patterns)
+	if err != nil || !changed {
+		r.Say("%s", msg)
+		return
+	}
+	r.Fixed().Say("%s\n%s", msg, strings.Join(diff, "\n"))
+}
@@ -0,0 +1,152 @@
+package bot
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCachedBrainKeepsPriorVersionsUpToLimit(t *testing.T) {
+	brain, err := newLocalCachedBrain(BrainCacheConfig{Directory: t.TempDir(), KeepVersions: 2})
+	if err != nil {
+		t.Fatalf("newLocalCachedBrain: %v", err)
+	}
+	defer brain.Shutdown()
+	for _, value := range []string{"one", "two", "three", "four"} {
+		payload := []byte(value)
+		if err := brain.Store("links:links", &payload); err != nil {
+			t.Fatalf("Store(%s): %v", value, err)
+		}
+	}
+	versions, err := brain.KeyHistory("links:links")
+	if err != nil {
+		t.Fatalf("KeyHistory() error = %v", err)
+	}
+	if len(versions) != 3 || !versions[0].Current || versions[0].Size != 4 {
+		t.Fatalf("KeyHistory() = %+v, want current plus two prior versions", versions)
+	}
+	for i := 1; i < len(versions); i++ {
+		if versions[i].Current || versions[i].Meta.Version >= versions[i-1].Meta.Version {
+			t.Fatalf("KeyHistory() not newest-first: %+v", versions)
+		}
+	}
+	payload, meta, err := brain.KeyVersion("links:links", versions[2].Meta.Version)
+	if err != nil || string(payload) != "two" || meta.Deleted {
+		t.Fatalf("KeyVersion(oldest) = %q %+v err=%v, want two", payload, meta, err)
+	}
+	payload, _, err = brain.KeyVersion("links:links", versions[0].Meta.Version)
+	if err != nil || string(payload) != "four" {
+		t.Fatalf("KeyVersion(current) = %q err=%v, want four", payload, err)
+	}
+	if _, _, err := brain.KeyVersion("links:links", 1); !errors.Is(err, errBrainVersionNotFound) {
+		t.Fatalf("KeyVersion(pruned) error = %v, want not found", err)
+	}
+}
+
+func TestCachedBrainHistoryRecordsDeletesAndSkipsEngineKeys(t *testing.T) {
+	brain, err := newLocalCachedBrain(BrainCacheConfig{Directory: t.TempDir(), KeepVersions: 5})
+	if err != nil {
+		t.Fatalf("newLocalCachedBrain: %v", err)
+	}
+	defer brain.Shutdown()
+	payload := []byte("value")
+	for _, key := range []string{"lists:lists", botEncryptionKey, queueDedupeKey, webhookDedupeKey, pausedJobsKey} {
+		if err := brain.Store(key, &payload); err != nil {
+			t.Fatalf("Store(%s): %v", key, err)
+		}
+		if err := brain.Store(key, &payload); err != nil {
+			t.Fatalf("Store(%s): %v", key, err)
+		}
+	}
+	if err := brain.Delete("lists:lists"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	versions, err := brain.KeyHistory("lists:lists")
+	if err != nil || len(versions) != 3 || !versions[0].Meta.Deleted || !versions[0].Current {
+		t.Fatalf("KeyHistory(lists) = %+v err=%v, want tombstone plus two versions", versions, err)
+	}
+	for _, key := range []string{botEncryptionKey, queueDedupeKey, webhookDedupeKey, pausedJobsKey} {
+		if got, err := brain.KeyHistory(key); err != nil || len(got) != 1 {
+			t.Fatalf("KeyHistory(%s) = %+v err=%v, want only the current version", key, got, err)
+		}
+	}
+	keys, err := brain.List()
+	if err != nil || len(keys) != 4 {
+		t.Fatalf("List() = %v err=%v, history must not leak into the key list", keys, err)
+	}
+}
+
+func TestCachedBrainHistoryDisabledByDefault(t *testing.T) {
+	brain, err := newLocalCachedBrain(BrainCacheConfig{Directory: t.TempDir()})
+	if err != nil {
+		t.Fatalf("newLocalCachedBrain: %v", err)
+	}
+	defer brain.Shutdown()
+	for _, value := range []string{"one", "two"} {
+		payload := []byte(value)
+		if err := brain.Store("alpha", &payload); err != nil {
+			t.Fatalf("Store: %v", err)
+		}
+	}
+	if versions, err := brain.KeyHistory("alpha"); err != nil || len(versions) != 1 {
+		t.Fatalf("KeyHistory() = %+v err=%v, want only the current version", versions, err)
+	}
+}
+
+func TestDiffLinesOnPrettyJSON(t *testing.T) {
+	patterns, err := compileRedactPatterns(nil)
+	if err != nil {
+		t.Fatalf("compileRedactPatterns(defaults) error = %v", err)
+	}
+	before, _, _ := redactedMemoryLines([]byte(`{"a":1,"b":[1,2],"c":"x"}`), patterns)
+	after, _, _ := redactedMemoryLines([]byte(`{"a":1,"b":[1,3],"c":"x"}`), patterns)
+	got := strings.Join(diffLines(before, after), "\n")
+	want := strings.Join([]string{
+		"  {",
+		`    "a": 1,`,
+		`    "b": [`,
+		"      1,",
+		"-     2",
+		"+     3",
+		"    ],",
+		`    "c": "x"`,
+		"  }",
+	}, "\n")
+	if got != want {
+		t.Fatalf("diffLines() =\n%s\nwant\n%s", got, want)
+	}
+	if got := diffLines(nil, []string{"x"}); len(got) != 1 || got[0] != "+ x" {
+		t.Fatalf("diffLines(nil, x) = %v", got)
+	}
+	if got, _, err := redactedMemoryLines([]byte("plain text\n"), patterns); err == nil {
+		t.Fatalf("redactedMemoryLines(non-JSON) = %v, want it withheld", got)
+	}
+}
+
+func TestRedactedDiffKeepsSecretsMasked(t *testing.T) {
+	patterns, err := compileRedactPatterns(nil)
+	if err != nil {
+		t.Fatalf("compileRedactPatterns(defaults) error = %v", err)
+	}
+	before := []byte(`{"user":"alice","password":"hunter2"}`)
+	after := []byte(`{"user":"bob","password":"correct-horse"}`)
+	diff, changed, redacted, err := redactedDiff(before, after, patterns)
+	if err != nil || !changed || redacted != 2 {
+		t.Fatalf("redactedDiff() changed=%v redacted=%d err=%v", changed, redacted, err)
+	}
+	out := strings.Join(diff, "\n")
+	for _, secret := range []string{"hunter2", "correct-horse"} {
+		if strings.Contains(out, secret) {
+			t.Fatalf("diff exposes %q:\n%s", secret, out)
+		}
+	}
+	if !strings.Contains(out, `    "password": "<redacted>",`) || !strings.Contains(out, `+   "user": "bob"`) {
+		t.Fatalf("unexpected diff:\n%s", out)
+	}
+	if _, changed, _, _ := redactedDiff([]byte(`{"token":"a"}`), []byte(`{"token":"b"}`), patterns); changed {
+		t.Fatal("a change only to a redacted field should not be shown")
+	}
+	if diff, _, _, _ := redactedDiff(nil, after, patterns); strings.Contains(strings.Join(diff, "\n"), "correct-horse") {
+		t.Fatal("diff against a deleted version exposes the secret")
+	}
+}
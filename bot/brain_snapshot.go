@@ -0,0 +1,715 @@
+package bot
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+// Brain snapshots are portable, point-in-time copies of every memory with its
+// v3 cache metadata. The file is a gzipped tar (manifest.json plus one
+// data/<key>.blob per live memory), sealed with the robot encryption key
+// and prefixed with brainSnapshotMagic. Payloads are stored exactly as the
+// brain holds them, so memory-level encryption is preserved as well.
+const (
+	brainSnapshotFormat       = "gopherbot-brain-snapshot-v1"
+	brainSnapshotMagic        = "GOPHERBOT-BRAIN-SNAPSHOT-1\n"
+	brainSnapshotManifestFile = "manifest.json"
+	brainSnapshotTimeLayout   = "20060102T150405Z"
+	brainSnapshotPrefix       = "brain-"
+	brainSnapshotSuffix       = ".snapshot"
+)
+
+type brainSnapshotManifest struct {
+	Format          string               `json:"format"`
+	CreatedAt       time.Time            `json:"created_at"`
+	Source          string               `json:"source"`
+	DatabaseVersion uint64               `json:"database_version"`
+	Entries         []brainSnapshotEntry `json:"entries"`
+}
+
+type brainSnapshotEntry struct {
+	Meta brainCacheMeta `json:"meta"`
+	File string         `json:"file,omitempty"`
+	Size int            `json:"size"`
+}
+
+type brainSnapshot struct {
+	Manifest brainSnapshotManifest
+	Payloads map[string][]byte
+}
+
+type brainSnapshotFile struct {
+	Path      string
+	CreatedAt time.Time
+	Size      int64
+}
+
+type brainSnapshotOptions struct {
+	output string
+	cloud  bool
+}
+
+type brainSnapshotRestoreOptions struct {
+	file   string
+	at     string
+	force  bool
+	dryRun bool
+	budget int
+}
+
+func defaultBrainSnapshotDirectory(cacheDir string) string {
+	return filepath.Join(filepath.Dir(filepath.Clean(cacheDir)), "brain-snapshots")
+}
+
+func brainSnapshotFileName(createdAt time.Time) string {
+	return brainSnapshotPrefix + createdAt.UTC().Format(brainSnapshotTimeLayout) + brainSnapshotSuffix
+}
+
+func (s *brainSnapshot) addEntry(meta brainCacheMeta, payload []byte) {
+	entry := brainSnapshotEntry{Meta: meta}
+	if !meta.Deleted {
+		entry.File = "data/" + encodeBrainCacheKey(meta.Key) + ".blob"
+		entry.Size = len(payload)
+		s.Payloads[meta.Key] = payload
+	}
+	s.Manifest.Entries = append(s.Manifest.Entries, entry)
+	if meta.Version > s.Manifest.DatabaseVersion {
+		s.Manifest.DatabaseVersion = meta.Version
+	}
+}
+
+func (s *brainSnapshot) sortEntries() {
+	sort.Slice(s.Manifest.Entries, func(i, j int) bool {
+		return s.Manifest.Entries[i].Meta.Key < s.Manifest.Entries[j].Meta.Key
+	})
+}
+
+func newBrainSnapshot(source string) brainSnapshot {
+	return brainSnapshot{
+		Manifest: brainSnapshotManifest{
+			Format:    brainSnapshotFormat,
+			CreatedAt: time.Now().UTC(),
+			Source:    source,
+		},
+		Payloads: make(map[string][]byte),
+	}
+}
+
+// collectLocalBrainSnapshot captures every memory and tombstone in the local
+// cache, including writes still queued for cloud sync. The instance lock is
+// per-deployment state and is never captured.
+func collectLocalBrainSnapshot(cache *cachedBrain) (brainSnapshot, error) {
+	snap := newBrainSnapshot("local-cache")
+	entries, err := os.ReadDir(cache.metaDir())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return brainSnapshot{}, err
+	}
+	for _, dirEntry := range entries {
+		if dirEntry.IsDir() || !strings.HasSuffix(dirEntry.Name(), ".json") {
+			continue
+		}
+		meta, err := cache.readMetaFile(filepath.Join(cache.metaDir(), dirEntry.Name()))
+		if err != nil {
+			return brainSnapshot{}, err
+		}
+		if meta.Key == brainLockKey {
+			continue
+		}
+		var payload []byte
+		if !meta.Deleted {
+			payload, err = os.ReadFile(cache.payloadPath(meta.Key))
+			if err != nil {
+				return brainSnapshot{}, fmt.Errorf("reading local memory %s: %w", meta.Key, err)
+			}
+			if checksumBytes(payload) != meta.Checksum {
+				return brainSnapshot{}, fmt.Errorf("local memory %s checksum mismatch", meta.Key)
+			}
+		}
+		meta.SyncedAt = time.Time{}
+		snap.addEntry(meta, payload)
+	}
+	if latest := cache.latestDatabaseVersion(); latest > snap.Manifest.DatabaseVersion {
+		snap.Manifest.DatabaseVersion = latest
+	}
+	snap.sortEntries()
+	return snap, nil
+}
+
+func collectRemoteBrainSnapshot(ctx context.Context, remote robot.RemoteBrainBackend, providerName string) (brainSnapshot, error) {
+	snap := newBrainSnapshot("cloud:" + providerName)
+	metas, err := listAllRemoteBrainMetadata(ctx, remote)
+	if err != nil {
+		return brainSnapshot{}, err
+	}
+	for _, listed := range metas {
+		if listed.Key == brainLockKey {
+			continue
+		}
+		if listed.Format != brainCacheFormat {
+			return brainSnapshot{}, fmt.Errorf("remote brain contains v2/unversioned memory %s; %s", listed.Key, v2RemoteBrainMigrationHint)
+		}
+		record, exists, err := remote.Get(ctx, listed.Key)
+		if err != nil {
+			return brainSnapshot{}, fmt.Errorf("reading remote memory %s: %w", listed.Key, err)
+		}
+		if !exists {
+			continue
+		}
+		if !record.Deleted && checksumBytes(record.Payload) != record.Checksum {
+			return brainSnapshot{}, fmt.Errorf("remote memory %s checksum mismatch", record.Key)
+		}
+		snap.addEntry(brainCacheMeta{
+			Format:    brainCacheFormat,
+			Key:       record.Key,
+			Version:   record.Version,
+			Checksum:  record.Checksum,
+			Deleted:   record.Deleted,
+			UpdatedAt: record.UpdatedAt,
+			KeyID:     record.KeyID,
+		}, record.Payload)
+	}
+	snap.sortEntries()
+	return snap, nil
+}
+
+// encodeBrainSnapshot returns the sealed snapshot file contents.
+func encodeBrainSnapshot(snap brainSnapshot, key []byte) ([]byte, error) {
+	manifest, err := json.MarshalIndent(snap.Manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	addFile := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0600,
+			Size:     int64(len(data)),
+			ModTime:  snap.Manifest.CreatedAt,
+			Typeflag: tar.TypeReg,
+		}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := addFile(brainSnapshotManifestFile, manifest); err != nil {
+		return nil, err
+	}
+	for _, entry := range snap.Manifest.Entries {
+		if entry.File == "" {
+			continue
+		}
+		if err := addFile(entry.File, snap.Payloads[entry.Meta.Key]); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	sealed, err := encrypt(buf.Bytes(), key)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting brain snapshot: %w", err)
+	}
+	return append([]byte(brainSnapshotMagic), sealed...), nil
+}
+
+// decodeBrainSnapshot opens a sealed snapshot and verifies that every
+// manifest entry is present with a matching size and checksum.
+func decodeBrainSnapshot(data []byte, key []byte) (brainSnapshot, error) {
+	if !bytes.HasPrefix(data, []byte(brainSnapshotMagic)) {
+		return brainSnapshot{}, errors.New("not a gopherbot brain snapshot")
+	}
+	plain, err := decrypt(data[len(brainSnapshotMagic):], key)
+	if err != nil {
+		return brainSnapshot{}, fmt.Errorf("decrypting brain snapshot (wrong encryption key or corrupt file): %w", err)
+	}
+	return readBrainSnapshotArchive(plain)
+}
+
+// decodeBrainSnapshotWithKeyring is decodeBrainSnapshot for snapshots that
+// may still be sealed with the retired key of an unfinished rotation; it
+// also returns the ID of the key that opened it.
+func decodeBrainSnapshotWithKeyring(data []byte) (brainSnapshot, string, error) {
+	if !bytes.HasPrefix(data, []byte(brainSnapshotMagic)) {
+		return brainSnapshot{}, "", errors.New("not a gopherbot brain snapshot")
+	}
+	plain, keyID, err := decryptWithKeyring(data[len(brainSnapshotMagic):])
+	if err != nil {
+		return brainSnapshot{}, "", fmt.Errorf("decrypting brain snapshot (wrong encryption key or corrupt file): %w", err)
+	}
+	snap, err := readBrainSnapshotArchive(plain)
+	return snap, keyID, err
+}
+
+func readBrainSnapshotArchive(plain []byte) (brainSnapshot, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(plain))
+	if err != nil {
+		return brainSnapshot{}, err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	files := make(map[string][]byte)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return brainSnapshot{}, fmt.Errorf("reading brain snapshot archive: %w", err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return brainSnapshot{}, err
+		}
+		files[hdr.Name] = content
+	}
+	manifestData, ok := files[brainSnapshotManifestFile]
+	if !ok {
+		return brainSnapshot{}, errors.New("brain snapshot is missing its manifest")
+	}
+	snap := brainSnapshot{Payloads: make(map[string][]byte)}
+	if err := json.Unmarshal(manifestData, &snap.Manifest); err != nil {
+		return brainSnapshot{}, fmt.Errorf("parsing brain snapshot manifest: %w", err)
+	}
+	if snap.Manifest.Format != brainSnapshotFormat {
+		return brainSnapshot{}, fmt.Errorf("unsupported brain snapshot format %q", snap.Manifest.Format)
+	}
+	for _, entry := range snap.Manifest.Entries {
+		if entry.Meta.Format != brainCacheFormat {
+			return brainSnapshot{}, fmt.Errorf("brain snapshot memory %s has unsupported format %q", entry.Meta.Key, entry.Meta.Format)
+		}
+		if entry.Meta.Deleted {
+			continue
+		}
+		payload, ok := files[entry.File]
+		if !ok {
+			return brainSnapshot{}, fmt.Errorf("brain snapshot is missing data for memory %s", entry.Meta.Key)
+		}
+		if len(payload) != entry.Size || checksumBytes(payload) != entry.Meta.Checksum {
+			return brainSnapshot{}, fmt.Errorf("brain snapshot memory %s checksum mismatch", entry.Meta.Key)
+		}
+		snap.Payloads[entry.Meta.Key] = payload
+	}
+	return snap, nil
+}
+
+func listBrainSnapshots(dir string) ([]brainSnapshotFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var out []brainSnapshotFile
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, brainSnapshotPrefix) || !strings.HasSuffix(name, brainSnapshotSuffix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, brainSnapshotPrefix), brainSnapshotSuffix)
+		createdAt, err := time.Parse(brainSnapshotTimeLayout, stamp)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, brainSnapshotFile{Path: filepath.Join(dir, name), CreatedAt: createdAt, Size: info.Size()})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+// findBrainSnapshotAt returns the newest snapshot in dir taken at or before at.
+func findBrainSnapshotAt(dir string, at time.Time) (brainSnapshotFile, error) {
+	snapshots, err := listBrainSnapshots(dir)
+	if err != nil {
+		return brainSnapshotFile{}, err
+	}
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if !snapshots[i].CreatedAt.After(at) {
+			return snapshots[i], nil
+		}
+	}
+	if len(snapshots) == 0 {
+		return brainSnapshotFile{}, fmt.Errorf("no brain snapshots found in %s", dir)
+	}
+	return brainSnapshotFile{}, fmt.Errorf("no brain snapshot in %s was taken at or before %s; the oldest is from %s",
+		dir, at.Format(time.RFC3339), snapshots[0].CreatedAt.Local().Format(time.RFC3339))
+}
+
+// parseBrainSnapshotTime accepts RFC 3339 timestamps, a snapshot file
+// timestamp, or local "YYYY-MM-DD[ HH:MM[:SS]]" forms.
+func parseBrainSnapshotTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(brainSnapshotTimeLayout, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized timestamp %q; use RFC 3339 or YYYY-MM-DD[ HH:MM[:SS]]", value)
+}
+
+// brainSnapshotRestorePlan maps each snapshot memory to the version it will
+// carry after restore. Restoring into an empty remote keeps the snapshot
+// versions; otherwise changed memories are renumbered above everything the
+// remote has seen so version compare-and-set backends accept the writes.
+// Memories whose content already matches the remote keep the remote version
+// and cost no write, so re-running an interrupted restore only writes what
+// is still different.
+type brainSnapshotRestorePlan struct {
+	records     []robot.RemoteBrainRecord
+	writes      []robot.RemoteBrainRecord
+	tombstones  []robot.RemoteBrainRecord
+	nextVersion uint64
+}
+
+func planBrainSnapshotRestore(snap brainSnapshot, remoteMetas []robot.RemoteBrainRecord) brainSnapshotRestorePlan {
+	remoteByKey := make(map[string]robot.RemoteBrainRecord, len(remoteMetas))
+	var remoteMax uint64
+	for _, meta := range remoteMetas {
+		remoteByKey[meta.Key] = meta
+		if meta.Version > remoteMax {
+			remoteMax = meta.Version
+		}
+	}
+	renumber := remoteMax > 0
+	next := remoteMax
+	if snap.Manifest.DatabaseVersion > next {
+		next = snap.Manifest.DatabaseVersion
+	}
+	next++
+	entries := append([]brainSnapshotEntry(nil), snap.Manifest.Entries...)
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Meta.Version == entries[j].Meta.Version {
+			return entries[i].Meta.Key < entries[j].Meta.Key
+		}
+		return entries[i].Meta.Version < entries[j].Meta.Version
+	})
+	var plan brainSnapshotRestorePlan
+	inSnapshot := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		meta := entry.Meta
+		if meta.Key == brainLockKey {
+			continue
+		}
+		inSnapshot[meta.Key] = true
+		current, onRemote := remoteByKey[meta.Key]
+		if meta.Deleted && (!onRemote || current.Deleted) {
+			continue
+		}
+		record := robot.RemoteBrainRecord{
+			Key:       meta.Key,
+			Format:    brainCacheFormat,
+			Version:   meta.Version,
+			Checksum:  meta.Checksum,
+			Deleted:   meta.Deleted,
+			UpdatedAt: meta.UpdatedAt,
+			KeyID:     meta.KeyID,
+		}
+		if !meta.Deleted {
+			record.Payload = snap.Payloads[meta.Key]
+		}
+		if onRemote && current.Format == brainCacheFormat && !current.Deleted && !meta.Deleted && current.Checksum == meta.Checksum {
+			record.Version = current.Version
+			record.UpdatedAt = current.UpdatedAt
+			plan.records = append(plan.records, record)
+			continue
+		}
+		if renumber {
+			record.Version = next
+			next++
+		}
+		plan.records = append(plan.records, record)
+		plan.writes = append(plan.writes, record)
+	}
+	keys := make([]string, 0, len(remoteByKey))
+	for key := range remoteByKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		current := remoteByKey[key]
+		if key == brainLockKey || inSnapshot[key] || current.Deleted {
+			continue
+		}
+		plan.tombstones = append(plan.tombstones, robot.RemoteBrainRecord{
+			Key:       key,
+			Format:    brainCacheFormat,
+			Version:   next,
+			Deleted:   true,
+			UpdatedAt: time.Now().UTC(),
+		})
+		next++
+	}
+	for _, record := range plan.records {
+		if record.Version >= next {
+			next = record.Version + 1
+		}
+	}
+	plan.nextVersion = next
+	return plan
+}
+
+// liveRemoteBrainMemories counts non-deleted remote memories other than the
+// instance lock.
+func liveRemoteBrainMemories(metas []robot.RemoteBrainRecord) int {
+	count := 0
+	for _, meta := range metas {
+		if meta.Key != brainLockKey && !meta.Deleted {
+			count++
+		}
+	}
+	return count
+}
+
+// rebuildBrainCacheFromSnapshot replaces the local cache with the restored
+// records, marked as already synced.
+func rebuildBrainCacheFromSnapshot(cfg BrainCacheConfig, plan brainSnapshotRestorePlan) error {
+	cache, err := openBrainCacheForImport(cfg, "snapshot", true)
+	if err != nil {
+		return err
+	}
+	for _, record := range plan.records {
+		if err := cache.importV3Record(record); err != nil {
+			return err
+		}
+	}
+	cache.mu.Lock()
+	if plan.nextVersion > cache.control.NextVersion {
+		cache.control.NextVersion = plan.nextVersion
+	}
+	cache.mu.Unlock()
+	return cache.finalizeImport("snapshot")
+}
+
+func checkBrainLockReleasedForRestore(ctx context.Context, remote robot.RemoteBrainBackend) error {
+	record, exists, err := remote.Get(ctx, brainLockKey)
+	if err != nil {
+		return fmt.Errorf("reading brain instance lock: %w", err)
+	}
+	if !exists || record.Deleted {
+		return nil
+	}
+	plain, err := decryptMemoryPayload(record.Payload)
+	if err != nil {
+		return fmt.Errorf("reading brain instance lock: %w", err)
+	}
+	var lock instanceLockData
+	if err := json.Unmarshal(plain, &lock); err != nil {
+		return fmt.Errorf("parsing brain instance lock: %w", err)
+	}
+	if lock.State == "" || lock.State == brainLockHeld {
+		return fmt.Errorf("brain instance lock is held by %s on %s (pid %d); stop that robot before restoring a snapshot",
+			lock.RobotName, lock.Hostname, lock.PID)
+	}
+	return nil
+}
+
+func currentBrainSnapshotKey() ([]byte, error) {
+	cryptKey.RLock()
+	defer cryptKey.RUnlock()
+	if !cryptKey.initialized {
+		return nil, fmt.Errorf("encryption not initialized; set GOPHER_ENCRYPTION_KEY or load a .env file first")
+	}
+	return cryptKey.key, nil
+}
+
+func cliBrainSnapshot(opts brainSnapshotOptions) error {
+	initCLIConfigOnly()
+	key, err := currentBrainSnapshotKey()
+	if err != nil {
+		return err
+	}
+	provider := currentCfg.brainProvider
+	if provider == "" || provider == "mem" {
+		return fmt.Errorf("configured Brain %q has no persistent memories to snapshot", provider)
+	}
+	var snap brainSnapshot
+	pending := 0
+	if opts.cloud {
+		remote, _, providerName, err := initRemoteBrainForCLI()
+		if err != nil {
+			return err
+		}
+		defer remote.Shutdown()
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+		defer cancel()
+		if snap, err = collectRemoteBrainSnapshot(ctx, remote, providerName); err != nil {
+			return err
+		}
+	} else {
+		cache, err := openExistingBrainCacheAny(currentCfg.brainCache)
+		if err != nil {
+			return fmt.Errorf("opening local brain cache: %w; run gopherbot pull-brain or use brain snapshot -cloud", err)
+		}
+		if snap, err = collectLocalBrainSnapshot(cache); err != nil {
+			return err
+		}
+		outbox, err := cache.outboxEntries()
+		if err != nil {
+			return err
+		}
+		pending = len(outbox)
+	}
+	data, err := encodeBrainSnapshot(snap, key)
+	if err != nil {
+		return err
+	}
+	target := opts.output
+	if target == "" {
+		target = filepath.Join(currentCfg.brainCache.SnapshotDirectory, brainSnapshotFileName(snap.Manifest.CreatedAt))
+	}
+	if err := writeAtomicFile(target, data, 0600); err != nil {
+		return fmt.Errorf("writing brain snapshot: %w", err)
+	}
+	live := len(snap.Payloads)
+	fmt.Printf("Wrote brain snapshot %s\n", target)
+	fmt.Printf("Source: %s\nMemories: %d (%d tombstones)\nDatabase version: %d\nSHA-256: %s\n",
+		snap.Manifest.Source, live, len(snap.Manifest.Entries)-live, snap.Manifest.DatabaseVersion, checksumBytes(data))
+	if pending > 0 {
+		fmt.Printf("Includes %d local change(s) not yet synced to cloud\n", pending)
+	}
+	return nil
+}
+
+func cliBrainSnapshots() error {
+	initCLIConfigOnly()
+	dir := currentCfg.brainCache.SnapshotDirectory
+	snapshots, err := listBrainSnapshots(dir)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) == 0 {
+		fmt.Printf("No brain snapshots in %s\n", dir)
+		return nil
+	}
+	fmt.Printf("Brain snapshots in %s:\n", dir)
+	for _, snapshot := range snapshots {
+		fmt.Printf("  %s  %8d bytes  %s\n", snapshot.CreatedAt.Local().Format(time.RFC3339), snapshot.Size, filepath.Base(snapshot.Path))
+	}
+	return nil
+}
+
+func cliBrainRestore(opts brainSnapshotRestoreOptions) error {
+	initCLIConfigOnly()
+	if _, err := currentBrainSnapshotKey(); err != nil {
+		return err
+	}
+	path := opts.file
+	if opts.at != "" {
+		at, err := parseBrainSnapshotTime(opts.at)
+		if err != nil {
+			return err
+		}
+		found, err := findBrainSnapshotAt(currentCfg.brainCache.SnapshotDirectory, at)
+		if err != nil {
+			return err
+		}
+		path = found.Path
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	snap, _, err := decodeBrainSnapshotWithKeyring(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	fmt.Printf("Restoring brain snapshot %s taken %s from %s (%d memories)\n",
+		path, snap.Manifest.CreatedAt.Local().Format(time.RFC3339), snap.Manifest.Source, len(snap.Payloads))
+
+	provider := currentCfg.brainProvider
+	switch provider {
+	case "", "mem":
+		return fmt.Errorf("configured Brain %q has no persistent storage to restore into", provider)
+	case "file":
+		if existing, err := openExistingBrainCacheAny(currentCfg.brainCache); err == nil && !opts.force {
+			if keys, err := existing.List(); err == nil && len(keys) > 0 {
+				return fmt.Errorf("local brain cache at %s already holds %d memories; rerun with -force to replace them with the snapshot", currentCfg.brainCache.Directory, len(keys))
+			}
+		}
+		plan := planBrainSnapshotRestore(snap, nil)
+		if opts.dryRun {
+			fmt.Printf("Would restore %d memories into the local brain cache\n", len(plan.records))
+			return nil
+		}
+		if err := rebuildBrainCacheFromSnapshot(currentCfg.brainCache, plan); err != nil {
+			return err
+		}
+		fmt.Printf("Restored %d memories into the local brain cache\n", len(plan.records))
+		return nil
+	}
+
+	remote, _, providerName, err := initRemoteBrainForCLI()
+	if err != nil {
+		return err
+	}
+	defer remote.Shutdown()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	if err := checkBrainLockReleasedForRestore(ctx, remote); err != nil {
+		return err
+	}
+	remoteMetas, err := listAllRemoteBrainMetadata(ctx, remote)
+	if err != nil {
+		return err
+	}
+	if live := liveRemoteBrainMemories(remoteMetas); live > 0 && !opts.force {
+		return fmt.Errorf("remote brain %s already holds %d memories; rerun with -force to replace them with the snapshot", providerName, live)
+	}
+	plan := planBrainSnapshotRestore(snap, remoteMetas)
+	if opts.dryRun {
+		fmt.Printf("Would write %d memories and %d tombstones to %s; %d memories already match\n",
+			len(plan.writes), len(plan.tombstones), providerName, len(plan.records)-len(plan.writes))
+		return nil
+	}
+	budget := effectiveCloudWriteBudget(remote, opts.budget)
+	writes := 0
+	for _, record := range append(append([]robot.RemoteBrainRecord(nil), plan.writes...), plan.tombstones...) {
+		if budget > 0 && writes >= budget {
+			return fmt.Errorf("write budget exhausted after %d writes; local cache was not changed; rerun brain restore to continue", writes)
+		}
+		if record.Deleted {
+			err = remote.Delete(ctx, record)
+		} else {
+			err = remote.Put(ctx, record)
+		}
+		if err != nil {
+			return fmt.Errorf("restoring memory %s: %w", record.Key, err)
+		}
+		writes++
+	}
+	if err := rebuildBrainCacheFromSnapshot(currentCfg.brainCache, plan); err != nil {
+		return fmt.Errorf("remote restore complete, but rebuilding local cache failed: %w; run gopherbot pull-brain -force", err)
+	}
+	fmt.Printf("Restored %d memories to %s (%d writes, %d tombstones) and rebuilt the local brain cache\n",
+		len(plan.records), providerName, writes, len(plan.tombstones))
+	return nil
+}
@@ -0,0 +1,241 @@
+package bot
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+var testSnapshotKey = []byte("0123456789abcdef0123456789abcdef")
+
+func testSnapshotRecord(key, payload string, version uint64) robot.RemoteBrainRecord {
+	return robot.RemoteBrainRecord{
+		Key:       key,
+		Payload:   []byte(payload),
+		Format:    brainCacheFormat,
+		Version:   version,
+		Checksum:  checksumBytes([]byte(payload)),
+		UpdatedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+func TestBrainSnapshotCapturesLocalCacheAndRoundTrips(t *testing.T) {
+	brain, err := newLocalCachedBrain(BrainCacheConfig{Directory: t.TempDir()})
+	if err != nil {
+		t.Fatalf("newLocalCachedBrain: %v", err)
+	}
+	defer brain.Shutdown()
+	for key, value := range map[string]string{"alpha": "one", "beta": "two", brainLockKey: "lock", "gone": "x"} {
+		payload := []byte(value)
+		if err := brain.Store(key, &payload); err != nil {
+			t.Fatalf("Store(%s): %v", key, err)
+		}
+	}
+	if err := brain.Delete("gone"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	snap, err := collectLocalBrainSnapshot(brain)
+	if err != nil {
+		t.Fatalf("collectLocalBrainSnapshot() error = %v", err)
+	}
+	var keys []string
+	for _, entry := range snap.Manifest.Entries {
+		keys = append(keys, entry.Meta.Key)
+	}
+	if got := strings.Join(keys, ","); got != "alpha,beta,gone" {
+		t.Fatalf("snapshot keys = %s, want lock excluded and tombstone kept", got)
+	}
+	if snap.Manifest.DatabaseVersion != brain.latestDatabaseVersion() {
+		t.Fatalf("DatabaseVersion = %d, want %d", snap.Manifest.DatabaseVersion, brain.latestDatabaseVersion())
+	}
+
+	data, err := encodeBrainSnapshot(snap, testSnapshotKey)
+	if err != nil {
+		t.Fatalf("encodeBrainSnapshot() error = %v", err)
+	}
+	if strings.Contains(string(data), "alpha") {
+		t.Fatal("encoded snapshot contains plaintext key names")
+	}
+	decoded, err := decodeBrainSnapshot(data, testSnapshotKey)
+	if err != nil {
+		t.Fatalf("decodeBrainSnapshot() error = %v", err)
+	}
+	if string(decoded.Payloads["beta"]) != "two" || len(decoded.Manifest.Entries) != 3 || !decoded.Manifest.Entries[2].Meta.Deleted {
+		t.Fatalf("decoded snapshot = %+v", decoded.Manifest)
+	}
+	if decoded.Manifest.Entries[0].Meta.Version != snap.Manifest.Entries[0].Meta.Version {
+		t.Fatalf("decoded version = %d, want %d", decoded.Manifest.Entries[0].Meta.Version, snap.Manifest.Entries[0].Meta.Version)
+	}
+
+	if _, err := decodeBrainSnapshot(data, []byte("fedcba9876543210fedcba9876543210")); err == nil {
+		t.Fatal("decodeBrainSnapshot() with wrong key succeeded")
+	}
+	tampered := append([]byte(nil), data...)
+	tampered[len(tampered)-1] ^= 0xff
+	if _, err := decodeBrainSnapshot(tampered, testSnapshotKey); err == nil {
+		t.Fatal("decodeBrainSnapshot() accepted a tampered snapshot")
+	}
+}
+
+func TestDecodeBrainSnapshotRejectsChecksumMismatch(t *testing.T) {
+	snap := newBrainSnapshot("test")
+	record := testSnapshotRecord("alpha", "one", 1)
+	snap.addEntry(brainCacheMeta{Format: brainCacheFormat, Key: "alpha", Version: 1, Checksum: record.Checksum}, []byte("uno"))
+	data, err := encodeBrainSnapshot(snap, testSnapshotKey)
+	if err != nil {
+		t.Fatalf("encodeBrainSnapshot() error = %v", err)
+	}
+	if _, err := decodeBrainSnapshot(data, testSnapshotKey); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("decodeBrainSnapshot() error = %v, want checksum mismatch", err)
+	}
+}
+
+func TestFindBrainSnapshotAtPicksNewestNotAfterTimestamp(t *testing.T) {
+	dir := t.TempDir()
+	for _, stamp := range []string{"20261001T080000Z", "20261002T080000Z", "20261003T080000Z"} {
+		if err := os.WriteFile(filepath.Join(dir, "brain-"+stamp+".snapshot"), []byte("x"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("x"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	at, err := parseBrainSnapshotTime("2026-10-02T12:00:00Z")
+	if err != nil {
+		t.Fatalf("parseBrainSnapshotTime() error = %v", err)
+	}
+	found, err := findBrainSnapshotAt(dir, at)
+	if err != nil || filepath.Base(found.Path) != "brain-20261002T080000Z.snapshot" {
+		t.Fatalf("findBrainSnapshotAt() = %+v err=%v", found, err)
+	}
+	exact, _ := parseBrainSnapshotTime("20261003T080000Z")
+	if found, err := findBrainSnapshotAt(dir, exact); err != nil || filepath.Base(found.Path) != "brain-20261003T080000Z.snapshot" {
+		t.Fatalf("findBrainSnapshotAt(exact) = %+v err=%v", found, err)
+	}
+	early, _ := parseBrainSnapshotTime("2026-09-30T00:00:00Z")
+	if _, err := findBrainSnapshotAt(dir, early); err == nil || !strings.Contains(err.Error(), "oldest") {
+		t.Fatalf("findBrainSnapshotAt(early) error = %v", err)
+	}
+	if _, err := parseBrainSnapshotTime("2026-10-02 09:30"); err != nil {
+		t.Fatalf("parseBrainSnapshotTime(local) error = %v", err)
+	}
+	if _, err := parseBrainSnapshotTime("yesterday"); err == nil {
+		t.Fatal("parseBrainSnapshotTime(yesterday) succeeded")
+	}
+}
+
+func snapshotFromRecords(records ...robot.RemoteBrainRecord) brainSnapshot {
+	snap := newBrainSnapshot("test")
+	for _, record := range records {
+		snap.addEntry(brainCacheMeta{
+			Format:    brainCacheFormat,
+			Key:       record.Key,
+			Version:   record.Version,
+			Checksum:  record.Checksum,
+			Deleted:   record.Deleted,
+			UpdatedAt: record.UpdatedAt,
+		}, record.Payload)
+	}
+	snap.sortEntries()
+	return snap
+}
+
+func TestPlanBrainSnapshotRestoreKeepsVersionsForEmptyRemote(t *testing.T) {
+	snap := snapshotFromRecords(
+		testSnapshotRecord("alpha", "one", 4),
+		testSnapshotRecord("beta", "two", 7),
+		robot.RemoteBrainRecord{Key: "gone", Format: brainCacheFormat, Version: 5, Deleted: true},
+	)
+	plan := planBrainSnapshotRestore(snap, nil)
+	if len(plan.writes) != 2 || len(plan.tombstones) != 0 {
+		t.Fatalf("plan writes=%d tombstones=%d, want 2 and 0", len(plan.writes), len(plan.tombstones))
+	}
+	if plan.writes[0].Key != "alpha" || plan.writes[0].Version != 4 || plan.writes[1].Version != 7 {
+		t.Fatalf("plan writes = %+v, want snapshot versions", plan.writes)
+	}
+	if plan.nextVersion != 8 {
+		t.Fatalf("nextVersion = %d, want 8", plan.nextVersion)
+	}
+}
+
+func TestPlanBrainSnapshotRestoreRenumbersAboveRemoteAndTombstonesExtras(t *testing.T) {
+	snap := snapshotFromRecords(
+		testSnapshotRecord("alpha", "one", 4),
+		testSnapshotRecord("beta", "two-good", 7),
+	)
+	remote := []robot.RemoteBrainRecord{
+		testSnapshotRecord("alpha", "one", 12),
+		testSnapshotRecord("beta", "two-corrupted", 20),
+		testSnapshotRecord("added-later", "x", 15),
+		testSnapshotRecord(brainLockKey, "lock", 21),
+	}
+	plan := planBrainSnapshotRestore(snap, remote)
+	if len(plan.writes) != 1 || plan.writes[0].Key != "beta" || plan.writes[0].Version != 22 {
+		t.Fatalf("plan writes = %+v, want beta renumbered to 22", plan.writes)
+	}
+	if len(plan.tombstones) != 1 || plan.tombstones[0].Key != "added-later" || plan.tombstones[0].Version != 23 {
+		t.Fatalf("plan tombstones = %+v, want added-later at 23", plan.tombstones)
+	}
+	for _, record := range plan.records {
+		if record.Key == "alpha" && record.Version != 12 {
+			t.Fatalf("unchanged alpha version = %d, want remote version 12", record.Version)
+		}
+	}
+	if plan.nextVersion != 24 {
+		t.Fatalf("nextVersion = %d, want 24", plan.nextVersion)
+	}
+}
+
+func TestBrainSnapshotRestoreLeavesCacheUsableWithRemote(t *testing.T) {
+	remote := newTestRemote(map[string]robot.RemoteBrainRecord{
+		"alpha": testSnapshotRecord("alpha", "corrupted", 9),
+		"extra": testSnapshotRecord("extra", "x", 10),
+	})
+	snap := snapshotFromRecords(testSnapshotRecord("alpha", "good", 3), testSnapshotRecord("beta", "two", 5))
+	metas, err := listAllRemoteBrainMetadata(nil, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := planBrainSnapshotRestore(snap, metas)
+	for _, record := range append(plan.writes, plan.tombstones...) {
+		if record.Deleted {
+			_ = remote.Delete(nil, record)
+		} else {
+			_ = remote.Put(nil, record)
+		}
+	}
+	cfg := BrainCacheConfig{Directory: t.TempDir()}
+	if err := rebuildBrainCacheFromSnapshot(cfg, plan); err != nil {
+		t.Fatalf("rebuildBrainCacheFromSnapshot() error = %v", err)
+	}
+	brain, err := newRemoteCachedBrain(cfg, remote)
+	if err != nil {
+		t.Fatalf("newRemoteCachedBrain() after restore error = %v", err)
+	}
+	defer brain.ShutdownWithoutFlush()
+	got, exists, err := brain.Retrieve("alpha")
+	if err != nil || !exists || string(*got) != "good" {
+		t.Fatalf("Retrieve(alpha) = %q exists=%v err=%v", stringValue(got), exists, err)
+	}
+	if _, exists, _ := brain.Retrieve("extra"); exists {
+		t.Fatal("memory missing from snapshot survived restore")
+	}
+	if next := brain.latestDatabaseVersion() + 1; next <= 12 {
+		t.Fatalf("next local version = %d, want above every remote version", next)
+	}
+	payload := []byte("after")
+	if err := brain.Store("alpha", &payload); err != nil {
+		t.Fatalf("Store after restore: %v", err)
+	}
+	if err := brain.Flush(); err != nil {
+		t.Fatalf("Flush after restore: %v", err)
+	}
+	if string(remote.records["alpha"].Payload) != "after" {
+		t.Fatalf("remote alpha = %q after flush", remote.records["alpha"].Payload)
+	}
+}
@@ -0,0 +1,514 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+// remindersKey holds pending reminders and scheduled commands.
+const remindersKey = "bot:_reminders"
+
+const (
+	maxRemindersPerUser = 50
+	maxReminderDelay    = 366 * 24 * time.Hour
+	reminderTimeFormat  = "Mon Jan 2 15:04 MST"
+	// reminderLateAfter is how overdue a reminder has to be, e.g. after the
+	// robot was down, before it's delivered with a note.
+	reminderLateAfter = time.Minute
+)
+
+var reminderDelayRe = regexp.MustCompile(`(\d+)\s*([a-z]+)`)
+
+var reminderClockRe = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?(am|pm)?$`)
+
+// reminder is a one-shot reminder or command for a user. The connector
+// fields come from the message that created it, so it's delivered - or
+// the command is dispatched - where and as whom it was requested.
+type reminder struct {
+	ID      int       `json:"id"`
+	User    string    `json:"user"`
+	Due     time.Time `json:"due"`
+	Created time.Time `json:"created"`
+	Text    string    `json:"text,omitempty"`
+	Command string    `json:"command,omitempty"`
+
+	Protocol        string `json:"protocol"`
+	UserName        string `json:"userName,omitempty"`
+	UserID          string `json:"userID"`
+	ValidatedUser   bool   `json:"validatedUser,omitempty"`
+	ChannelName     string `json:"channelName,omitempty"`
+	ChannelID       string `json:"channelID,omitempty"`
+	ThreadID        string `json:"threadID,omitempty"`
+	ThreadedMessage bool   `json:"threadedMessage,omitempty"`
+	DirectMessage   bool   `json:"directMessage,omitempty"`
+}
+
+type reminderStore struct {
+	NextID    int        `json:"nextID"`
+	Reminders []reminder `json:"reminders"`
+}
+
+var reminders = struct {
+	sync.Mutex
+	store  reminderStore
+	loaded bool
+	timer  *time.Timer
+}{}
+
+func init() {
+	robot.RegisterPlugin("builtin-reminders", robot.PluginHandler{Handler: reminderCommands})
+}
+
+// parseReminderDelay parses the "2h" of "in 2h", also "90 minutes",
+// "1 hour and 30 minutes" or "an hour".
+func parseReminderDelay(spec string) (time.Duration, error) {
+	words := strings.Fields(strings.ReplaceAll(spec, ",", " "))
+	for i, w := range words {
+		if w == "a" || w == "an" {
+			words[i] = "1"
+		}
+	}
+	spec = strings.Join(words, " ")
+	matches := reminderDelayRe.FindAllStringSubmatchIndex(spec, -1)
+	if matches == nil {
+		return 0, fmt.Errorf("I don't understand the delay '%s'", spec)
+	}
+	var total time.Duration
+	rest := spec
+	for _, m := range matches {
+		n, _ := strconv.Atoi(spec[m[2]:m[3]])
+		unit, ok := searchWindowUnits[spec[m[4]:m[5]]]
+		if !ok {
+			return 0, fmt.Errorf("I don't know the time unit '%s'", spec[m[4]:m[5]])
+		}
+		total += time.Duration(n) * unit
+		rest = strings.Replace(rest, spec[m[0]:m[1]], "", 1)
+	}
+	for _, w := range strings.Fields(rest) {
+		if w != "and" {
+			return 0, fmt.Errorf("I don't understand '%s' in the delay '%s'", w, spec)
+		}
+	}
+	if total <= 0 {
+		return 0, fmt.Errorf("the delay has to be more than zero")
+	}
+	return total, nil
+}
+
+func parseReminderClock(clock string) (hour, minute int, err error) {
+	m := reminderClockRe.FindStringSubmatch(clock)
+	if m == nil {
+		return 0, 0, fmt.Errorf("I don't understand the time '%s'", clock)
+	}
+	hour, _ = strconv.Atoi(m[1])
+	if m[2] != "" {
+		minute, _ = strconv.Atoi(m[2])
+	}
+	switch m[3] {
+	case "":
+		if hour > 23 {
+			return 0, 0, fmt.Errorf("invalid time '%s'", clock)
+		}
+	default:
+		if hour < 1 || hour > 12 {
+			return 0, 0, fmt.Errorf("invalid time '%s'", clock)
+		}
+		hour %= 12
+		if m[3] == "pm" {
+			hour += 12
+		}
+	}
+	if minute > 59 {
+		return 0, 0, fmt.Errorf("invalid time '%s'", clock)
+	}
+	return hour, minute, nil
+}
+
+// parseReminderTime turns "in 2h", "at 09:00 tomorrow", "friday at 5pm"
+// or "on 2026-12-24 at 9:30" into a time after now, in now's location. A
+// time without a day is today, or tomorrow once it has passed.
+func parseReminderTime(spec string, now time.Time) (time.Time, error) {
+	words := strings.Fields(strings.ToLower(spec))
+	if len(words) == 0 {
+		return time.Time{}, fmt.Errorf("I need a time, like 'in 2h' or 'at 09:00 tomorrow'")
+	}
+	if words[0] == "in" {
+		delay, err := parseReminderDelay(strings.Join(words[1:], " "))
+		if err != nil {
+			return time.Time{}, err
+		}
+		if delay > maxReminderDelay {
+			return time.Time{}, fmt.Errorf("that's too far in the future")
+		}
+		return now.Add(delay), nil
+	}
+	var clock, day string
+	for _, w := range words {
+		switch {
+		case w == "at" || w == "on":
+		case (w == "am" || w == "pm") && clock != "" && !strings.HasSuffix(clock, "m"):
+			clock += w
+		case clock == "" && reminderClockRe.MatchString(w):
+			clock = w
+		case day == "":
+			day = w
+		default:
+			return time.Time{}, fmt.Errorf("I don't understand '%s' in '%s'", w, spec)
+		}
+	}
+	if clock == "" {
+		return time.Time{}, fmt.Errorf("I need a time of day, like 'at 09:00'")
+	}
+	hour, minute, err := parseReminderClock(clock)
+	if err != nil {
+		return time.Time{}, err
+	}
+	date := now
+	weekday := false
+	switch day {
+	case "", "today":
+	case "tomorrow":
+		date = now.AddDate(0, 0, 1)
+	default:
+		if len(day) >= 3 {
+			if wd, ok := weekdayNames[day[:3]]; ok && strings.HasPrefix(strings.ToLower(wd.String()), day) {
+				weekday = true
+				date = now.AddDate(0, 0, (int(wd)-int(now.Weekday())+7)%7)
+				break
+			}
+		}
+		d, err := time.ParseInLocation("2006-01-02", day, now.Location())
+		if err != nil {
+			return time.Time{}, fmt.Errorf("I don't understand the day '%s'; try today, tomorrow, a weekday or YYYY-MM-DD", day)
+		}
+		date = d
+	}
+	due := time.Date(date.Year(), date.Month(), date.Day(), hour, minute, 0, 0, now.Location())
+	if !due.After(now) {
+		switch {
+		case day == "":
+			due = due.AddDate(0, 0, 1)
+		case weekday:
+			due = due.AddDate(0, 0, 7)
+		default:
+			return time.Time{}, fmt.Errorf("%s has already passed", due.Format(reminderTimeFormat))
+		}
+	}
+	if due.Sub(now) > maxReminderDelay {
+		return time.Time{}, fmt.Errorf("that's too far in the future")
+	}
+	return due, nil
+}
+
+// loadReminders reads pending reminders the first time the brain is
+// available; the caller holds reminders.
+func loadReminders() {
+	if reminders.loaded || !internalMemoryReady() {
+		return
+	}
+	_, data, exists, ret := getDatum(remindersKey, false)
+	if ret != robot.Ok {
+		return
+	}
+	reminders.loaded = true
+	if !exists {
+		return
+	}
+	if err := json.Unmarshal(*data, &reminders.store); err != nil {
+		Log(robot.Error, "Discarding unreadable reminders '%s': %v", remindersKey, err)
+		reminders.store = reminderStore{}
+		return
+	}
+	Log(robot.Debug, "Loaded %d pending reminder(s) from long-term memory", len(reminders.store.Reminders))
+}
+
+// saveReminders persists reminders; the caller holds reminders.
+func saveReminders() bool {
+	data, err := json.Marshal(reminders.store)
+	if err != nil {
+		Log(robot.Error, "Marshalling reminders: %v", err)
+		return false
+	}
+	if ret := storeDatum(remindersKey, &data); ret != robot.Ok {
+		Log(robot.Error, "Storing reminders: %s", ret)
+		return false
+	}
+	return true
+}
+
+// armReminderTimer sets the timer for the next reminder due; the caller
+// holds reminders.
+func armReminderTimer() {
+	if reminders.timer != nil {
+		reminders.timer.Stop()
+		reminders.timer = nil
+	}
+	if len(reminders.store.Reminders) == 0 {
+		return
+	}
+	next := reminders.store.Reminders[0].Due
+	for _, rem := range reminders.store.Reminders[1:] {
+		if rem.Due.Before(next) {
+			next = rem.Due
+		}
+	}
+	wait := time.Until(next)
+	if wait < 0 {
+		wait = 0
+	}
+	reminders.timer = time.AfterFunc(wait, fireDueReminders)
+}
+
+// addReminder stores a new reminder and returns its ID.
+func addReminder(rem reminder) (int, error) {
+	reminders.Lock()
+	defer reminders.Unlock()
+	loadReminders()
+	if !reminders.loaded {
+		return 0, fmt.Errorf("my brain isn't available right now")
+	}
+	pending := 0
+	for _, r := range reminders.store.Reminders {
+		if r.User == rem.User {
+			pending++
+		}
+	}
+	if pending >= maxRemindersPerUser {
+		return 0, fmt.Errorf("you already have %d pending reminders", pending)
+	}
+	reminders.store.NextID++
+	rem.ID = reminders.store.NextID
+	reminders.store.Reminders = append(reminders.store.Reminders, rem)
+	if !saveReminders() {
+		reminders.store.Reminders = reminders.store.Reminders[:len(reminders.store.Reminders)-1]
+		return 0, fmt.Errorf("I couldn't store the reminder")
+	}
+	armReminderTimer()
+	return rem.ID, nil
+}
+
+// userReminders lists a user's pending reminders, soonest first.
+func userReminders(user string) []reminder {
+	reminders.Lock()
+	defer reminders.Unlock()
+	loadReminders()
+	var out []reminder
+	for _, rem := range reminders.store.Reminders {
+		if rem.User == user {
+			out = append(out, rem)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Due.Before(out[j].Due) })
+	return out
+}
+
+// cancelReminder removes one of user's reminders, reporting whether it
+// was found.
+func cancelReminder(user string, id int) (bool, error) {
+	reminders.Lock()
+	defer reminders.Unlock()
+	loadReminders()
+	for i, rem := range reminders.store.Reminders {
+		if rem.ID != id || rem.User != user {
+			continue
+		}
+		kept := append(append([]reminder{}, reminders.store.Reminders[:i]...), reminders.store.Reminders[i+1:]...)
+		prev := reminders.store.Reminders
+		reminders.store.Reminders = kept
+		if !saveReminders() {
+			reminders.store.Reminders = prev
+			return true, fmt.Errorf("I couldn't update my reminders")
+		}
+		armReminderTimer()
+		return true, nil
+	}
+	return false, nil
+}
+
+// takeDueReminders removes and returns reminders due by now.
+func takeDueReminders(now time.Time) []reminder {
+	reminders.Lock()
+	defer reminders.Unlock()
+	loadReminders()
+	var due, pending []reminder
+	for _, rem := range reminders.store.Reminders {
+		if rem.Due.After(now) {
+			pending = append(pending, rem)
+		} else {
+			due = append(due, rem)
+		}
+	}
+	if len(due) > 0 {
+		reminders.store.Reminders = pending
+		if !saveReminders() {
+			// Deliver them anyway; a restart may repeat them.
+			Log(robot.Warn, "Delivering %d reminder(s) that couldn't be removed from long-term memory", len(due))
+		}
+	}
+	armReminderTimer()
+	sort.Slice(due, func(i, j int) bool { return due[i].Due.Before(due[j].Due) })
+	return due
+}
+
+func fireDueReminders() {
+	state.RLock()
+	stopping := state.shuttingDown
+	state.RUnlock()
+	if stopping {
+		return
+	}
+	now := time.Now()
+	for _, rem := range takeDueReminders(now) {
+		deliverReminder(rem, now)
+	}
+}
+
+// deliverReminder sends a reminder to the user where it was requested, or
+// dispatches a scheduled command as if the user had just sent it, so it
+// goes through the normal authorization and elevation checks.
+func deliverReminder(rem reminder, now time.Time) {
+	currentCfg.RLock()
+	format := currentCfg.defaultMessageFormat
+	currentCfg.RUnlock()
+	inc := &robot.ConnectorMessage{
+		Protocol:        rem.Protocol,
+		UserName:        rem.UserName,
+		UserID:          rem.UserID,
+		ValidatedUser:   rem.ValidatedUser,
+		ChannelName:     rem.ChannelName,
+		ChannelID:       rem.ChannelID,
+		ThreadID:        rem.ThreadID,
+		ThreadedMessage: rem.ThreadedMessage,
+		DirectMessage:   rem.DirectMessage,
+	}
+	var msg string
+	if rem.Command != "" {
+		msg = "Running your scheduled command: " + rem.Command
+	} else {
+		msg = "Reminder: " + rem.Text
+	}
+	if late := now.Sub(rem.Due); late > reminderLateAfter {
+		msg += fmt.Sprintf(" (late; was due %s)", rem.Due.In(now.Location()).Format(reminderTimeFormat))
+	}
+	var ret robot.RetVal
+	if rem.DirectMessage {
+		ret = interfaces.SendProtocolUserMessage(bracket(rem.UserID), msg, format, inc)
+	} else {
+		thread := ""
+		if rem.ThreadedMessage {
+			thread = rem.ThreadID
+		}
+		ret = interfaces.SendProtocolUserChannelThreadMessage(bracket(rem.UserID), rem.User, bracket(rem.ChannelID), thread, msg, format, inc)
+	}
+	if ret != robot.Ok {
+		Log(robot.Error, "Delivering reminder #%d for user '%s' on protocol '%s': %s", rem.ID, rem.User, rem.Protocol, ret)
+	}
+	if rem.Command == "" {
+		return
+	}
+	Log(robot.Info, "Dispatching scheduled command #%d for user '%s': %s", rem.ID, rem.User, rem.Command)
+	inc.BotMessage = true
+	inc.MessageText = rem.Command
+	handler{}.IncomingMessage(inc)
+}
+
+// newReminder captures the requesting user and where they asked.
+func (r Robot) newReminder(due time.Time) reminder {
+	inc := r.Incoming
+	return reminder{
+		User:            r.User,
+		Due:             due,
+		Created:         time.Now(),
+		Protocol:        inc.Protocol,
+		UserName:        inc.UserName,
+		UserID:          inc.UserID,
+		ValidatedUser:   inc.ValidatedUser,
+		ChannelName:     inc.ChannelName,
+		ChannelID:       inc.ChannelID,
+		ThreadID:        inc.ThreadID,
+		ThreadedMessage: inc.ThreadedMessage,
+		DirectMessage:   inc.DirectMessage,
+	}
+}
+
+func reminderCommands(m robot.Robot, command string, args ...string) (retval robot.TaskRetVal) {
+	r := m.(Robot)
+	if command == "_init" {
+		reminders.Lock()
+		loadReminders()
+		armReminderTimer()
+		reminders.Unlock()
+		return
+	}
+	now := time.Now().In(scheduleLocation(r.cfg))
+	switch command {
+	case "remind", "runat":
+		due, err := parseReminderTime(args[0], now)
+		if err != nil {
+			r.Say("Sorry, %v", err)
+			return
+		}
+		if r.Incoming == nil || r.Incoming.UserID == "" {
+			r.Say("Sorry, I can only schedule reminders from chat")
+			return
+		}
+		rem := r.newReminder(due)
+		var what string
+		if command == "remind" {
+			rem.Text = strings.TrimSpace(args[1])
+			what = "remind you to " + rem.Text
+		} else {
+			rem.Command = strings.TrimSpace(args[1])
+			what = "run '" + rem.Command + "' for you"
+		}
+		id, err := addReminder(rem)
+		if err != nil {
+			r.Say("Sorry, %v", err)
+			return
+		}
+		r.Say("Ok, I'll %s at %s (reminder #%d)", what, due.Format(reminderTimeFormat), id)
+	case "reminders":
+		pending := userReminders(r.User)
+		if len(pending) == 0 {
+			r.Say("You don't have any pending reminders")
+			return
+		}
+		lines := make([]string, 0, len(pending)+1)
+		lines = append(lines, "Your pending reminders:")
+		for _, rem := range pending {
+			what := rem.Text
+			if rem.Command != "" {
+				what = "run: " + rem.Command
+			}
+			where := "direct message"
+			if !rem.DirectMessage {
+				where = rem.ChannelName
+				if where == "" {
+					where = bracket(rem.ChannelID)
+				}
+			}
+			lines = append(lines, fmt.Sprintf("#%d %s (%s) - %s", rem.ID, rem.Due.In(now.Location()).Format(reminderTimeFormat), where, what))
+		}
+		r.Say(strings.Join(lines, "\n"))
+	case "cancelreminder":
+		id, _ := strconv.Atoi(args[0])
+		found, err := cancelReminder(r.User, id)
+		switch {
+		case err != nil:
+			r.Say("Sorry, %v", err)
+		case !found:
+			r.Say("I don't see a pending reminder #%d for you", id)
+		default:
+			r.Say("Ok, I cancelled reminder #%d", id)
+		}
+	}
+	return
+}
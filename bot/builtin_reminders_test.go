@@ -0,0 +1,112 @@
+package bot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseReminderTime(t *testing.T) {
+	// Thursday, 14:30
+	now := time.Date(2026, 10, 15, 14, 30, 0, 0, time.UTC)
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 10, day, hour, minute, 0, 0, time.UTC)
+	}
+	for spec, want := range map[string]time.Time{
+		"in 2h":                     now.Add(2 * time.Hour),
+		"in 90 minutes":             now.Add(90 * time.Minute),
+		"in an hour and 30 minutes": now.Add(90 * time.Minute),
+		"in 1d 2h":                  now.Add(26 * time.Hour),
+		"at 09:00 tomorrow":         at(16, 9, 0),
+		"tomorrow at 9am":           at(16, 9, 0),
+		"at 16:00":                  at(15, 16, 0),
+		"at 9:15":                   at(16, 9, 15),
+		"at 5 pm":                   at(15, 17, 0),
+		"friday at 12am":            at(16, 0, 0),
+		"thursday at 14:00":         at(22, 14, 0),
+		"on 2026-12-24 at 9:30":     time.Date(2026, 12, 24, 9, 30, 0, 0, time.UTC),
+	} {
+		got, err := parseReminderTime(spec, now)
+		if err != nil {
+			t.Errorf("parseReminderTime(%q) error: %v", spec, err)
+			continue
+		}
+		if !got.Equal(want) {
+			t.Errorf("parseReminderTime(%q) = %s, want %s", spec, got, want)
+		}
+	}
+	for _, spec := range []string{
+		"",
+		"in a while",
+		"in 2 fortnights",
+		"in 0m",
+		"in 400 days",
+		"tomorrow",
+		"at 25:00",
+		"at 13pm",
+		"today at 09:00",
+		"at 09:00 someday",
+		"on 2026-01-01 at 09:00",
+	} {
+		if got, err := parseReminderTime(spec, now); err == nil {
+			t.Errorf("parseReminderTime(%q) = %s, want an error", spec, got)
+		}
+	}
+}
+
+func TestReminderStore(t *testing.T) {
+	withInternalMemory(t)
+	reminders.Lock()
+	reminders.store = reminderStore{}
+	reminders.loaded = false
+	reminders.Unlock()
+	t.Cleanup(func() {
+		reminders.Lock()
+		if reminders.timer != nil {
+			reminders.timer.Stop()
+		}
+		reminders.store = reminderStore{}
+		reminders.loaded = false
+		reminders.timer = nil
+		reminders.Unlock()
+	})
+
+	now := time.Now()
+	add := func(user string, due time.Time, text string) int {
+		t.Helper()
+		id, err := addReminder(reminder{User: user, Due: due, Text: text, UserID: user})
+		if err != nil {
+			t.Fatalf("addReminder: %v", err)
+		}
+		return id
+	}
+	later := add("alice", now.Add(2*time.Hour), "later")
+	soon := add("alice", now.Add(time.Hour), "soon")
+	bobs := add("bob", now.Add(time.Hour), "bob's")
+
+	pending := userReminders("alice")
+	if len(pending) != 2 || pending[0].ID != soon || pending[1].ID != later {
+		t.Fatalf("alice's reminders = %+v, want #%d then #%d", pending, soon, later)
+	}
+	if found, _ := cancelReminder("alice", bobs); found {
+		t.Fatal("alice cancelled bob's reminder")
+	}
+	if found, err := cancelReminder("bob", bobs); !found || err != nil {
+		t.Fatalf("cancelReminder(bob) = %t, %v", found, err)
+	}
+
+	// Reload from the brain, as after a restart.
+	reminders.Lock()
+	reminders.store = reminderStore{}
+	reminders.loaded = false
+	reminders.Unlock()
+	due := takeDueReminders(now.Add(90 * time.Minute))
+	if len(due) != 1 || due[0].ID != soon || due[0].Text != "soon" {
+		t.Fatalf("due reminders = %+v, want #%d", due, soon)
+	}
+	if pending := userReminders("alice"); len(pending) != 1 || pending[0].ID != later {
+		t.Fatalf("alice's reminders after delivery = %+v", pending)
+	}
+	if id := add("bob", now.Add(time.Hour), "new"); id <= later {
+		t.Fatalf("reminder IDs reused: got #%d after #%d", id, later)
+	}
+}
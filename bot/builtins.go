@@ -391,20 +391,130 @@ func helpPluginSummary(task *Task, summaries ...string) string {
 	return ""
 }
 
+// pluginHelpCommands returns the help metadata for each of a plugin's
+// commands, in the order they're first matched, merging matchers that share
+// a command; the first non-empty Usage, SimpleMatcher and Summary win.
+// Commands rejected by include are skipped.
+func pluginHelpCommands(task *Task, plugin *Plugin, include func(command string) bool) []helpCommandMetadata {
+	byCommand := make(map[string]int)
+	entries := make([]helpCommandMetadata, 0, len(plugin.Commands))
+	for _, matcher := range plugin.Commands {
+		command := strings.TrimSpace(strings.ToLower(matcher.Command))
+		if len(command) == 0 {
+			continue
+		}
+		if include != nil && !include(command) {
+			continue
+		}
+		idx, ok := byCommand[command]
+		if !ok {
+			idx = len(entries)
+			byCommand[command] = idx
+			entries = append(entries, helpCommandMetadata{
+				PluginName:    task.name,
+				Command:       command,
+				Scope:         helpScopeText(task),
+				Channels:      append([]string(nil), task.Channels...),
+				AllChannels:   task.AllChannels,
+				PluginSummary: helpPluginSummary(task),
+			})
+		}
+		entry := &entries[idx]
+		if len(entry.Usage) == 0 && len(strings.TrimSpace(matcher.Usage)) > 0 {
+			entry.Usage = strings.TrimSpace(matcher.Usage)
+		}
+		if len(entry.SimpleMatcher) == 0 && len(strings.TrimSpace(matcher.SimpleMatcher)) > 0 {
+			entry.SimpleMatcher = strings.TrimSpace(matcher.SimpleMatcher)
+		}
+		if len(entry.Summary) == 0 && len(strings.TrimSpace(matcher.Summary)) > 0 {
+			entry.Summary = strings.TrimSpace(matcher.Summary)
+		}
+		if entry.PluginSummary == "" {
+			entry.PluginSummary = helpPluginSummary(task, matcher.Summary)
+		}
+		if commandAllowsPrivate(plugin, command) {
+			entry.PrivateOK = true
+			entry.PrivateRequired = commandRequiresPrivate(plugin, command)
+		}
+		entry.Examples = appendUniqueStrings(entry.Examples, matcher.Examples...)
+		entry.Keywords = appendUniqueStrings(entry.Keywords, matcher.Keywords...)
+	}
+	for i := range entries {
+		if len(entries[i].Usage) == 0 {
+			entries[i].Usage = "(alias) " + entries[i].Command
+		}
+	}
+	return entries
+}
+
+// addPrivateHelp fills in how a private-capable command can be run privately
+// over protocol.
+func (e *helpCommandMetadata) addPrivateHelp(protocol, hint, alias, botName string) {
+	if !e.PrivateOK {
+		return
+	}
+	if hint = strings.TrimSpace(hint); hint != "" {
+		e.PrivateHint = hint
+	}
+	if !hiddenCommandsSupportedForProtocol(protocol) {
+		return
+	}
+	e.PrivateSupported = true
+	for _, example := range e.Examples {
+		commandText := helpSurfaceCommandText(example, alias, botName)
+		hidden := strings.TrimSpace(formatHiddenCommand(protocol, commandText))
+		if hidden == "" {
+			continue
+		}
+		e.PrivateExamples = appendUniqueStrings(e.PrivateExamples, hidden)
+	}
+}
+
+// helpAuthorizer hides commands the user isn't authorized for from help,
+// looking up the user's groups once per authorizer.
+type helpAuthorizer struct {
+	r      Robot
+	w      *worker
+	groups map[string]authorizerGroupLookup
+}
+
+type authorizerGroupLookup struct {
+	groups map[string]struct{}
+	known  bool
+}
+
+func newHelpAuthorizer(r Robot, w *worker) *helpAuthorizer {
+	return &helpAuthorizer{r: r, w: w, groups: make(map[string]authorizerGroupLookup)}
+}
+
+// filter returns the include function pluginHelpCommands takes for a
+// plugin's commands. When the authorizer can't report the user's groups,
+// commands are shown and authorization is left to run time.
+func (a *helpAuthorizer) filter(task *Task, plugin *Plugin) func(command string) bool {
+	return func(command string) bool {
+		if !commandRequiresAuthorization(plugin, command) || strings.TrimSpace(task.AuthRequire) == "" {
+			return true
+		}
+		authorizer := effectiveAuthorizerName(task, a.w.cfg.defaultAuthorizer)
+		cached, ok := a.groups[authorizer]
+		if !ok {
+			groups, known := a.r.getAuthorizerUserGroups(a.w, authorizer, a.w.User)
+			cached = authorizerGroupLookup{groups: groups, known: known}
+			a.groups[authorizer] = cached
+		}
+		return !cached.known || userHasRequiredGroup(cached.groups, task.AuthRequire)
+	}
+}
+
 func (r Robot) collectHelpCommandMetadata(includeGlobal bool) []helpCommandMetadata {
 	w := getLockedWorker(r.tid)
 	w.Unlock()
 
-	type authorizerGroupLookup struct {
-		groups map[string]struct{}
-		known  bool
-	}
-	groupCache := make(map[string]authorizerGroupLookup)
+	authorized := newHelpAuthorizer(r, w)
 	protocol := protocolFromIncoming(r.Incoming, r.Protocol)
-	hiddenSupported := hiddenCommandsSupportedForProtocol(protocol)
 	hiddenHint := hiddenCommandHintForProtocol(protocol)
 
-	byCommand := make(map[string]*helpCommandMetadata)
+	results := make([]helpCommandMetadata, 0)
 	for _, t := range r.tasks.t[1:] {
 		task, plugin, _ := getTask(t)
 		if task == nil || plugin == nil || task.Disabled {
@@ -417,79 +527,10 @@ func (r Robot) collectHelpCommandMetadata(includeGlobal bool) []helpCommandMetad
 		if !includeGlobal && !specific {
 			continue
 		}
-		for _, matcher := range plugin.Commands {
-			command := strings.TrimSpace(strings.ToLower(matcher.Command))
-			if len(command) == 0 {
-				continue
-			}
-			if commandRequiresAuthorization(plugin, command) && strings.TrimSpace(task.AuthRequire) != "" {
-				authorizer := effectiveAuthorizerName(task, w.cfg.defaultAuthorizer)
-				cached, ok := groupCache[authorizer]
-				if !ok {
-					groups, known := r.getAuthorizerUserGroups(w, authorizer, w.User)
-					cached = authorizerGroupLookup{groups: groups, known: known}
-					groupCache[authorizer] = cached
-				}
-				if cached.known && !userHasRequiredGroup(cached.groups, task.AuthRequire) {
-					continue
-				}
-			}
-			key := task.name + "|" + command
-			entry, ok := byCommand[key]
-			if !ok {
-				entry = &helpCommandMetadata{
-					PluginName:    task.name,
-					Command:       command,
-					Scope:         helpScopeText(task),
-					Channels:      append([]string(nil), task.Channels...),
-					AllChannels:   task.AllChannels,
-					PluginSummary: helpPluginSummary(task),
-				}
-				byCommand[key] = entry
-			}
-			if len(entry.Usage) == 0 && len(strings.TrimSpace(matcher.Usage)) > 0 {
-				entry.Usage = strings.TrimSpace(matcher.Usage)
-			}
-			if len(entry.SimpleMatcher) == 0 && len(strings.TrimSpace(matcher.SimpleMatcher)) > 0 {
-				entry.SimpleMatcher = strings.TrimSpace(matcher.SimpleMatcher)
-			}
-			if len(entry.Summary) == 0 && len(strings.TrimSpace(matcher.Summary)) > 0 {
-				entry.Summary = strings.TrimSpace(matcher.Summary)
-			}
-			if entry.PluginSummary == "" {
-				entry.PluginSummary = helpPluginSummary(task, matcher.Summary)
-			}
-			if commandAllowsPrivate(plugin, command) {
-				entry.PrivateOK = true
-				entry.PrivateRequired = commandRequiresPrivate(plugin, command)
-				if hiddenSupported {
-					entry.PrivateSupported = true
-				}
-				if entry.PrivateHint == "" && strings.TrimSpace(hiddenHint) != "" {
-					entry.PrivateHint = strings.TrimSpace(hiddenHint)
-				}
-			}
-			entry.Examples = appendUniqueStrings(entry.Examples, matcher.Examples...)
-			if entry.PrivateOK && hiddenSupported {
-				for _, example := range matcher.Examples {
-					commandText := helpSurfaceCommandText(example, aliasString(w.cfg.alias), w.cfg.botinfo.UserName)
-					hidden := strings.TrimSpace(formatHiddenCommand(protocol, commandText))
-					if hidden == "" {
-						continue
-					}
-					entry.PrivateExamples = appendUniqueStrings(entry.PrivateExamples, hidden)
-				}
-			}
-			entry.Keywords = appendUniqueStrings(entry.Keywords, matcher.Keywords...)
-		}
-	}
-
-	results := make([]helpCommandMetadata, 0, len(byCommand))
-	for _, entry := range byCommand {
-		if len(entry.Usage) == 0 {
-			entry.Usage = "(alias) " + entry.Command
+		for _, entry := range pluginHelpCommands(task, plugin, authorized.filter(task, plugin)) {
+			entry.addPrivateHelp(protocol, hiddenHint, aliasString(w.cfg.alias), w.cfg.botinfo.UserName)
+			results = append(results, entry)
 		}
-		results = append(results, *entry)
 	}
 	return results
 }
@@ -1692,6 +1733,20 @@ func handleAdminInspectCommand(r Robot, command string, args []string) bool {
 		adminDumpPlugin(r, args)
 	case "listplugins":
 		adminListPlugins(r, args)
+	case "brainkeys":
+		adminBrainKeys(r, args)
+	case "brainshow":
+		adminBrainShow(r, args)
+	case "brainhistory":
+		adminBrainHistory(r, args)
+	case "braindiff":
+		adminBrainDiff(r, args)
+	case "brainrevert":
+		adminBrainRevert(r, args)
+	case "rotatekey":
+		adminRotateKey(r)
+	case "rotatekeystatus":
+		adminKeyRotationStatus(r)
 	default:
 		return false
 	}
@@ -1994,6 +2049,23 @@ func admin(m robot.Robot, command string, args ...string) (retval robot.TaskRetV
 			lines = append(lines, line)
 		}
 		r.Say(strings.Join(lines, "\n"))
+	case "queuestatus":
+		statuses := listQueueProviderStatus()
+		if len(statuses) == 0 {
+			r.Say("No queue providers are configured")
+			return
+		}
+		lines := make([]string, 0, len(statuses)+1)
+		lines = append(lines, "Queue provider status (trigger counts since start):")
+		for _, status := range statuses {
+			c := status.counts
+			line := fmt.Sprintf("%s: %s; accepted %d, duplicate %d, rejected %d, retried %d", status.provider, status.state, c.accepted, c.duplicate, c.rejected, c.retried)
+			if status.err != "" {
+				line += " (" + status.err + ")"
+			}
+			lines = append(lines, line)
+		}
+		r.Say(strings.Join(lines, "\n"))
 	case "protocolstart":
 		sourceProtocol := protocolFromIncoming(r.Incoming, r.Protocol)
 		primaryProtocol, _ := getRuntimePrimaryProtocol()
@@ -2257,6 +2329,7 @@ func admin(m robot.Robot, command string, args ...string) (retval robot.TaskRetV
 		}
 		pausedJobs.Lock()
 		defer pausedJobs.Unlock()
+		loadPausedJobs()
 		_, ok := pausedJobs.jobs[name]
 		if ok {
 			r.Say("That job has already been paused")
@@ -2264,6 +2337,7 @@ func admin(m robot.Robot, command string, args ...string) (retval robot.TaskRetV
 		}
 		m := r.GetMessage()
 		pausedJobs.jobs[name] = m.User
+		savePausedJobs()
 		r.Say("Ok, I'll stop running '%s' as a scheduled task", name)
 		return
 	case "resume":
@@ -2279,17 +2353,20 @@ func admin(m robot.Robot, command string, args ...string) (retval robot.TaskRetV
 		}
 		pausedJobs.Lock()
 		defer pausedJobs.Unlock()
+		loadPausedJobs()
 		_, ok := pausedJobs.jobs[name]
 		if !ok {
 			r.Say("That job isn't paused")
 			return
 		}
 		delete(pausedJobs.jobs, name)
+		savePausedJobs()
 		r.Say("Ok, I'll resume running '%s' as a scheduled task", name)
 		return
 	case "pauselist":
 		pausedJobs.Lock()
 		defer pausedJobs.Unlock()
+		loadPausedJobs()
 		if len(pausedJobs.jobs) == 0 {
 			r.Say("There are no paused jobs")
 			return
@@ -428,6 +428,9 @@ func (w *worker) callTaskThread(rchan chan<- taskReturn, opts taskCallOptions, t
 	} else {
 		desc = fmt.Sprintf("Starting task '%s'", task.name)
 	}
+	if tl, ok := logger.(robot.TaskHistoryLogger); ok {
+		tl.SetTask(task.name)
+	}
 	w.section(taskinfo, desc)
 
 	if !(task.name == "builtin-admin" && command == "abort") {
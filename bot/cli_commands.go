@@ -123,6 +123,29 @@ func cliCommands() []cliCommandSpec {
 			},
 			RunsBeforeInit: true,
 		},
+		{
+			Name:         "rotate-key",
+			SummaryUsage: "rotate-key [-status]",
+			Summary:      "replace the robot data key and re-encrypt brain and secrets",
+			HelpLines: []string{
+				"Usage: gopherbot rotate-key [-status]",
+				"",
+				"Generates a new robot data key, installs it in binary-encrypted-key[.<environment>],",
+				"then re-encrypts every brain memory, retained key history, and the Secrets in",
+				"custom/conf/variables/*.yaml. The robot must be stopped; use the",
+				"'rotate encryption key' admin command to rotate a running robot.",
+				"",
+				"Options:",
+				"  -status  report the current key ID and any unfinished rotation, without changing anything",
+				"",
+				"Notes:",
+				"  The old key is kept in <key file>.retired until everything is re-encrypted,",
+				"  so an interrupted rotation stays readable; rerun rotate-key to resume it.",
+				"  GOPHER_ENCRYPTION_KEY is unchanged. Commit the new key file and variables.",
+				"  Brain snapshots taken before the rotation need the old key to restore.",
+			},
+			RunsBeforeInit: true,
+		},
 		{
 			Name:         "uuid",
 			SummaryUsage: "uuid",
@@ -140,6 +163,38 @@ func cliCommands() []cliCommandSpec {
 			},
 			RunsBeforeInit: true,
 		},
+		{
+			Name:         "brain",
+			SummaryUsage: "brain <snapshot|snapshots|restore>",
+			Summary:      "write, list or restore encrypted brain snapshots",
+			HelpLines: []string{
+				"Usage: gopherbot brain snapshot [options]",
+				"   or: gopherbot brain snapshots",
+				"   or: gopherbot brain restore [options] [snapshot-file]",
+				"",
+				"snapshot writes an encrypted, checksummed archive of every memory with",
+				"its cache version to BrainCache.SnapshotDirectory. snapshots lists them.",
+				"restore loads a snapshot into the configured brain (any remote provider,",
+				"or the local cache for the file brain) and rebuilds the local cache.",
+				"",
+				"Snapshot options:",
+				"  -o, -output <path>    write to path instead of the snapshot directory",
+				"      -cloud            read from the configured cloud brain instead of",
+				"                        the local cache",
+				"",
+				"Restore options:",
+				"  -at <timestamp>       restore the newest snapshot taken at or before",
+				"                        timestamp (RFC 3339 or YYYY-MM-DD[ HH:MM[:SS]])",
+				"  -dry-run              report planned work without writing",
+				"  -force                replace memories already in the target brain",
+				"  -budget <n>           maximum cloud writes for this run",
+				"",
+				"Notes:",
+				"  Snapshots are sealed with the robot encryption key; stop the robot",
+				"  before restoring.",
+			},
+			RunsBeforeInit: true,
+		},
 		{
 			Name:         "delete",
 			SummaryUsage: "delete <key>",
@@ -276,6 +331,26 @@ func cliCommands() []cliCommandSpec {
 				"  gopherbot -log stderr run",
 			},
 		},
+		{
+			Name:         "sign-trigger",
+			SummaryUsage: "sign-trigger [options] <uuid> [args...]",
+			Summary:      "print a signed queue trigger body",
+			HelpLines: []string{
+				"Usage: gopherbot sign-trigger [options] <uuid> [args...]",
+				"",
+				"Prints a queue body for the job with the given UUIDTrigger, with a fresh",
+				"timestamp, shell-escaped arguments and an HMAC signature made with the",
+				"job's UUIDTriggerKey.",
+				"",
+				"Options:",
+				"  -k, -key-file <path|->   file holding the UUIDTriggerKey; use - for stdin",
+				"  -t, -timestamp <digits>  timestamp to sign instead of the current time",
+				"",
+				"Notes:",
+				"  Without -key-file, the key is read from GOPHER_TRIGGER_KEY.",
+			},
+			RunsBeforeInit: true,
+		},
 		{
 			Name:         "store",
 			SummaryUsage: "store <key> [file]",
@@ -420,6 +495,15 @@ func processCLI(command string, args []string) int {
 	var fetchOpts cliFetchOptions
 	var listCloud bool
 
+	var triggerKeyFile string
+	var triggerTimestamp string
+
+	signFlags := newCLIFlagSet("sign-trigger")
+	signFlags.StringVar(&triggerKeyFile, "key-file", "", "file holding the UUIDTriggerKey (or - for stdin)")
+	signFlags.StringVar(&triggerKeyFile, "k", "", "")
+	signFlags.StringVar(&triggerTimestamp, "timestamp", "", "timestamp to sign")
+	signFlags.StringVar(&triggerTimestamp, "t", "", "")
+
 	encFlags := newCLIFlagSet("encrypt")
 	encFlags.StringVar(&fileName, "file", "", "file to encrypt (or - for stdin)")
 	encFlags.StringVar(&fileName, "f", "", "")
@@ -441,6 +525,10 @@ func processCLI(command string, args []string) int {
 	genkeyFlags.BoolVar(&genkeyWrite, "w", false, "")
 	genkeyFlags.BoolVar(&genkeyForce, "force", false, "replace existing encrypted key file")
 
+	var rotateKeyStatus bool
+	rotateKeyFlags := newCLIFlagSet("rotate-key")
+	rotateKeyFlags.BoolVar(&rotateKeyStatus, "status", false, "report rotation status only")
+
 	fetchFlags := newCLIFlagSet("fetch")
 	fetchFlags.BoolVar(&fetchOpts.base64, "base64", false, "encode memory as base64")
 	fetchFlags.BoolVar(&fetchOpts.base64, "b", false, "")
@@ -465,6 +553,19 @@ func processCLI(command string, args []string) int {
 	restoreBrainFlags.BoolVar(&restoreBrainOpts.v2, "v2", false, "write v2-compatible cloud data instead of v3")
 	restoreBrainFlags.IntVar(&restoreBrainOpts.budget, "budget", 0, "maximum cloud writes")
 
+	snapshotFlags := newCLIFlagSet("brain snapshot")
+	var snapshotOpts brainSnapshotOptions
+	snapshotFlags.StringVar(&snapshotOpts.output, "output", "", "snapshot file to write")
+	snapshotFlags.StringVar(&snapshotOpts.output, "o", "", "")
+	snapshotFlags.BoolVar(&snapshotOpts.cloud, "cloud", false, "read from cloud brain")
+
+	snapshotRestoreFlags := newCLIFlagSet("brain restore")
+	var snapshotRestoreOpts brainSnapshotRestoreOptions
+	snapshotRestoreFlags.StringVar(&snapshotRestoreOpts.at, "at", "", "restore the newest snapshot at or before timestamp")
+	snapshotRestoreFlags.BoolVar(&snapshotRestoreOpts.force, "force", false, "replace memories already in the target brain")
+	snapshotRestoreFlags.BoolVar(&snapshotRestoreOpts.dryRun, "dry-run", false, "report planned work without writing")
+	snapshotRestoreFlags.IntVar(&snapshotRestoreOpts.budget, "budget", 0, "maximum cloud writes")
+
 	switch command {
 	case "help":
 		switch len(args) {
@@ -572,6 +673,26 @@ func processCLI(command string, args []string) int {
 			fmt.Printf("Error: %v\n", err)
 			return 1
 		}
+	case "rotate-key":
+		if err := rotateKeyFlags.Parse(args); err != nil {
+			if err == flag.ErrHelp {
+				printCLICommandHelp(command)
+				return 0
+			}
+			fmt.Printf("Error: %v\n\n", err)
+			printCLICommandHelp(command)
+			return 2
+		}
+		if len(rotateKeyFlags.Args()) > 0 {
+			fmt.Println("Error: rotate-key does not take positional arguments")
+			fmt.Println()
+			printCLICommandHelp(command)
+			return 2
+		}
+		if err := cliRotateKey(rotateKeyStatus); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
 	case "uuid":
 		if len(args) > 0 {
 			fmt.Println("Error: uuid does not take arguments")
@@ -668,6 +789,26 @@ func processCLI(command string, args []string) int {
 			}
 		}
 		return 0
+	case "sign-trigger":
+		if err := signFlags.Parse(args); err != nil {
+			if err == flag.ErrHelp {
+				printCLICommandHelp(command)
+				return 0
+			}
+			fmt.Printf("Error: %v\n\n", err)
+			printCLICommandHelp(command)
+			return 2
+		}
+		if len(signFlags.Args()) == 0 {
+			fmt.Println("Error: sign-trigger requires a job UUID")
+			fmt.Println()
+			printCLICommandHelp(command)
+			return 2
+		}
+		if err := cliSignTrigger(triggerKeyFile, triggerTimestamp, signFlags.Arg(0), signFlags.Args()[1:]); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
 	case "store":
 		if len(args) == 0 || len(args) > 2 {
 			if len(args) == 0 {
@@ -784,6 +925,71 @@ func processCLI(command string, args []string) int {
 			fmt.Printf("Error: %v\n", err)
 			return 1
 		}
+	case "brain":
+		if len(args) == 0 {
+			fmt.Println("Error: brain requires a subcommand: snapshot, snapshots or restore")
+			fmt.Println()
+			printCLICommandHelp(command)
+			return 2
+		}
+		subcommand, subargs := args[0], args[1:]
+		var subflags *flag.FlagSet
+		switch subcommand {
+		case "snapshot":
+			subflags = snapshotFlags
+		case "restore":
+			subflags = snapshotRestoreFlags
+		case "snapshots":
+			subflags = newCLIFlagSet("brain snapshots")
+		default:
+			fmt.Printf("Error: unknown brain subcommand %q\n\n", subcommand)
+			printCLICommandHelp(command)
+			return 2
+		}
+		if err := subflags.Parse(subargs); err != nil {
+			if err == flag.ErrHelp {
+				printCLICommandHelp(command)
+				return 0
+			}
+			fmt.Printf("Error: %v\n\n", err)
+			printCLICommandHelp(command)
+			return 2
+		}
+		switch subcommand {
+		case "snapshot", "snapshots":
+			if len(subflags.Args()) > 0 {
+				fmt.Printf("Error: brain %s does not take positional arguments\n\n", subcommand)
+				printCLICommandHelp(command)
+				return 2
+			}
+		case "restore":
+			if len(subflags.Args()) > 1 {
+				fmt.Println("Error: brain restore accepts at most one snapshot file")
+				fmt.Println()
+				printCLICommandHelp(command)
+				return 2
+			}
+			snapshotRestoreOpts.file = subflags.Arg(0)
+			if (snapshotRestoreOpts.file == "") == (snapshotRestoreOpts.at == "") {
+				fmt.Println("Error: brain restore requires exactly one of a snapshot file or -at <timestamp>")
+				fmt.Println()
+				printCLICommandHelp(command)
+				return 2
+			}
+		}
+		var err error
+		switch subcommand {
+		case "snapshot":
+			err = cliBrainSnapshot(snapshotOpts)
+		case "snapshots":
+			err = cliBrainSnapshots()
+		case "restore":
+			err = cliBrainRestore(snapshotRestoreOpts)
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
 	case "validate":
 		if len(args) != 1 {
 			fmt.Println("Error: validate requires a path to a robot repository")
@@ -982,6 +1188,41 @@ func cliUUID() error {
 	return nil
 }
 
+func cliSignTrigger(keyFile, timestamp, jobUUID string, args []string) error {
+	id, err := uuid.Parse(jobUUID)
+	if err != nil {
+		return fmt.Errorf("invalid job UUID: %w", err)
+	}
+	var key string
+	switch keyFile {
+	case "":
+		key = os.Getenv("GOPHER_TRIGGER_KEY")
+	case "-":
+		raw, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("reading key from stdin: %w", err)
+		}
+		key = string(raw)
+	default:
+		raw, err := os.ReadFile(keyFile)
+		if err != nil {
+			return fmt.Errorf("reading key file: %w", err)
+		}
+		key = string(raw)
+	}
+	key = strings.TrimSpace(key)
+	if len(key) < minQueueTriggerKeyLen {
+		return fmt.Errorf("UUIDTriggerKey must be at least %d characters; use -key-file or GOPHER_TRIGGER_KEY", minQueueTriggerKeyLen)
+	}
+	if timestamp == "" {
+		timestamp = newQueueTimestamp(time.Now())
+	} else if _, ok := queueTimestampTime(timestamp); !ok {
+		return fmt.Errorf("timestamp must be %d-%d digits", queueTimestampMinLen, queueTimestampMaxLen)
+	}
+	fmt.Println(signQueueBody(key, id.String(), timestamp, args))
+	return nil
+}
+
 func cliTOTPgen(user string) {
 	initCLIConfigOnly()
 	if !cryptKey.initialized {
@@ -1335,12 +1576,11 @@ func validateRemoteMemoryRecord(record robot.RemoteBrainRecord) error {
 func decryptMemoryPayload(payload []byte) ([]byte, error) {
 	cryptKey.RLock()
 	initialized := cryptKey.initialized
-	key := cryptKey.key
 	cryptKey.RUnlock()
 	if !initialized {
 		return nil, fmt.Errorf("brain encryption is not initialized")
 	}
-	plain, err := decrypt(payload, key)
+	plain, _, err := decryptWithKeyring(payload)
 	if err != nil {
 		return nil, fmt.Errorf("decrypting cloud memory: %w", err)
 	}
@@ -98,6 +98,7 @@ func TestCLIUUIDRunsBeforeFullInit(t *testing.T) {
 
 func TestUserCLICommandsRunBeforeFullInit(t *testing.T) {
 	for _, command := range []string{
+		"brain",
 		"delete",
 		"decrypt",
 		"dump",
@@ -109,6 +110,8 @@ func TestUserCLICommandsRunBeforeFullInit(t *testing.T) {
 		"help",
 		"init",
 		"list",
+		"rotate-key",
+		"sign-trigger",
 		"store",
 		"uuid",
 		"validate",
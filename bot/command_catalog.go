@@ -0,0 +1,66 @@
+package bot
+
+import (
+	"sort"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+// pluginCommandCatalog lists the commands of every enabled plugin, with the
+// Usage and Summary the help metadata reports, for connectors that register
+// commands with their transport. A transport shows registered commands to
+// everyone everywhere, so plugins limited to some users or channels and
+// admin-only commands are left out.
+func pluginCommandCatalog() []robot.CommandHelp {
+	currentCfg.RLock()
+	tasks := currentCfg.taskList
+	currentCfg.RUnlock()
+
+	catalog := make([]robot.CommandHelp, 0)
+	for _, t := range tasks.t[1:] {
+		task, plugin, _ := getTask(t)
+		if task == nil || plugin == nil || task.Disabled || !catalogVisible(task) {
+			continue
+		}
+		unrestricted := func(command string) bool {
+			return !commandRequiresAdmin(plugin, command)
+		}
+		for _, entry := range pluginHelpCommands(task, plugin, unrestricted) {
+			summary := entry.Summary
+			if len(summary) == 0 {
+				summary = entry.PluginSummary
+			}
+			catalog = append(catalog, robot.CommandHelp{
+				Plugin:  entry.PluginName,
+				Command: entry.Command,
+				Usage:   entry.Usage,
+				Summary: summary,
+			})
+		}
+	}
+	sort.Slice(catalog, func(i, j int) bool {
+		if catalog[i].Plugin != catalog[j].Plugin {
+			return catalog[i].Plugin < catalog[j].Plugin
+		}
+		return catalog[i].Command < catalog[j].Command
+	})
+	return catalog
+}
+
+// catalogVisible reports whether a plugin is available to every user in
+// every channel.
+func catalogVisible(task *Task) bool {
+	return !task.RequireAdmin && len(task.Users) == 0 && len(task.Channels) == 0 && task.AllChannels
+}
+
+// registerConnectorCommands hands the plugin command catalog to a connector
+// that implements robot.CommandRegistrar.
+func registerConnectorCommands(protocol string, connector robot.Connector) {
+	registrar, ok := connector.(robot.CommandRegistrar)
+	if !ok {
+		return
+	}
+	catalog := pluginCommandCatalog()
+	registrar.RegisterCommands(catalog)
+	Log(robot.Debug, "Registered %d plugin command(s) with connector '%s'", len(catalog), protocol)
+}
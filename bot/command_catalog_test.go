@@ -0,0 +1,133 @@
+package bot
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+type commandRegistrarTestConnector struct {
+	hiddenHelpTestConnector
+	registered [][]robot.CommandHelp
+}
+
+func (c *commandRegistrarTestConnector) RegisterCommands(commands []robot.CommandHelp) {
+	c.registered = append(c.registered, commands)
+}
+
+func setCommandCatalogTasks(t *testing.T, tasks *taskList) {
+	t.Helper()
+	currentCfg.Lock()
+	saved := currentCfg.taskList
+	currentCfg.taskList = tasks
+	currentCfg.Unlock()
+	t.Cleanup(func() {
+		currentCfg.Lock()
+		currentCfg.taskList = saved
+		currentCfg.Unlock()
+	})
+}
+
+func TestPluginCommandCatalog(t *testing.T) {
+	setCommandCatalogTasks(t, &taskList{
+		t: []interface{}{
+			&Task{name: "namespace"},
+			&Plugin{
+				Task: &Task{name: "lists", Description: "Manages lists.", AllChannels: true},
+				Commands: []InputMatcher{
+					{Command: "Add", Usage: "(alias) add <item> to list"},
+					{Command: "add", Usage: "(alias) put <item> on list", Summary: "Adds an item to a list."},
+					{Command: "show", Summary: "Shows a list."},
+					{Command: " "},
+				},
+			},
+			&Plugin{
+				Task:     &Task{name: "disabled", AllChannels: true, Disabled: true},
+				Commands: []InputMatcher{{Command: "hidden"}},
+			},
+			&Job{Task: &Task{name: "nightly"}},
+			&Plugin{
+				Task:          &Task{name: "admin", AllChannels: true},
+				Commands:      []InputMatcher{{Command: "reload", Usage: "reload"}, {Command: "quit"}},
+				AdminCommands: []string{"quit"},
+			},
+		},
+	})
+
+	want := []robot.CommandHelp{
+		{Plugin: "admin", Command: "reload", Usage: "reload"},
+		{Plugin: "lists", Command: "add", Usage: "(alias) add <item> to list", Summary: "Adds an item to a list."},
+		{Plugin: "lists", Command: "show", Usage: "(alias) show", Summary: "Shows a list."},
+	}
+	if got := pluginCommandCatalog(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("pluginCommandCatalog() = %#v, want %#v", got, want)
+	}
+}
+
+func TestPluginCommandCatalogSkipsRestrictedPlugins(t *testing.T) {
+	setCommandCatalogTasks(t, &taskList{
+		t: []interface{}{
+			&Task{name: "namespace"},
+			&Plugin{
+				Task:     &Task{name: "open", AllChannels: true},
+				Commands: []InputMatcher{{Command: "ping"}},
+			},
+			&Plugin{
+				Task:     &Task{name: "ops", Channels: []string{"ops"}},
+				Commands: []InputMatcher{{Command: "deploy"}},
+			},
+			&Plugin{
+				Task:     &Task{name: "private", Users: []string{"alice"}, AllChannels: true},
+				Commands: []InputMatcher{{Command: "vault"}},
+			},
+			&Plugin{
+				Task:     &Task{name: "admins", RequireAdmin: true, AllChannels: true},
+				Commands: []InputMatcher{{Command: "shutdown"}},
+			},
+		},
+	})
+
+	got := pluginCommandCatalog()
+	if len(got) != 1 || got[0].Plugin != "open" || got[0].Command != "ping" {
+		t.Fatalf("pluginCommandCatalog() = %#v, want only the unrestricted ping command", got)
+	}
+}
+
+func TestPluginCommandCatalogFallsBackToPluginDescription(t *testing.T) {
+	setCommandCatalogTasks(t, &taskList{
+		t: []interface{}{
+			&Task{name: "namespace"},
+			&Plugin{
+				Task:     &Task{name: "weather", Description: "Reports the weather.", AllChannels: true},
+				Commands: []InputMatcher{{Command: "forecast", Usage: "(alias) forecast <city>"}},
+			},
+		},
+	})
+
+	got := pluginCommandCatalog()
+	if len(got) != 1 || got[0].Summary != "Reports the weather." {
+		t.Fatalf("pluginCommandCatalog() = %#v, want the plugin description as summary", got)
+	}
+}
+
+func TestRegisterConnectorCommands(t *testing.T) {
+	setCommandCatalogTasks(t, &taskList{
+		t: []interface{}{
+			&Task{name: "namespace"},
+			&Plugin{
+				Task:     &Task{name: "ping", AllChannels: true},
+				Commands: []InputMatcher{{Command: "ping", Usage: "(alias) ping", Summary: "Replies with pong."}},
+			},
+		},
+	})
+
+	conn := &commandRegistrarTestConnector{}
+	registerConnectorCommands("test", conn)
+	if len(conn.registered) != 1 || len(conn.registered[0]) != 1 || conn.registered[0][0].Command != "ping" {
+		t.Fatalf("registered = %#v, want one catalog with the ping command", conn.registered)
+	}
+
+	// connectors without the optional contract are skipped
+	registerConnectorCommands("test", &hiddenHelpTestConnector{})
+}
@@ -113,6 +113,8 @@ type ConfigLoader struct {
 	EncryptionKey        string                            `yaml:"EncryptionKey"`        // Used to decrypt the "real" encryption key
 	HistoryProvider      string                            `yaml:"HistoryProvider"`      // Name of provider to use for storing and retrieving job/plugin histories
 	QueueProviders       []string                          `yaml:"QueueProviders"`       // Optional queue providers to initialize after startup
+	QueueTriggers        QueueTriggersConfig               `yaml:"QueueTriggers"`        // Dedupe window and maximum age for queue trigger timestamps
+	WebhookListener      WebhookListenerConfig             `yaml:"WebhookListener"`      // Optional HTTP listener for signed job webhooks
 	HttpDebug            bool                              `yaml:"HttpDebug"`            // Whether to turn on debug logging of local http API calls
 	WorkSpace            string                            `yaml:"WorkSpace"`            // Read/Write area the robot uses to do work
 	ReadyMessage         string                            `yaml:"ReadyMessage"`         // Optional channel message sent after startup readiness
@@ -448,6 +450,8 @@ func loadConfig(preConnect bool) error {
 		var tval map[string]TaskSettings
 		var identityVal map[string]IdentityProviderConfig
 		var brainCacheVal BrainCacheConfig
+		var queueTriggersVal QueueTriggersConfig
+		var webhookListenerVal WebhookListenerConfig
 		var stval []ScheduledTask
 		var mailval botMailer
 		var boolval bool
@@ -471,6 +475,10 @@ func loadConfig(preConnect bool) error {
 			val = &crval
 		case "BrainCache":
 			val = &brainCacheVal
+		case "QueueTriggers":
+			val = &queueTriggersVal
+		case "WebhookListener":
+			val = &webhookListenerVal
 		case "LocalPort":
 			val = &intval
 		case "ExternalJobs", "ExternalPlugins", "ExternalTasks", "GoJobs", "GoPlugins", "GoTasks", "NameSpaces", "ParameterSets":
@@ -525,6 +533,10 @@ func loadConfig(preConnect bool) error {
 			newconfig.HistoryProvider = *(val.(*string))
 		case "QueueProviders":
 			newconfig.QueueProviders = *(val.(*[]string))
+		case "QueueTriggers":
+			newconfig.QueueTriggers = *(val.(*QueueTriggersConfig))
+		case "WebhookListener":
+			newconfig.WebhookListener = *(val.(*WebhookListenerConfig))
 		case "WorkSpace":
 			newconfig.WorkSpace = *(val.(*string))
 		case "ReadyMessage":
@@ -681,6 +693,8 @@ func loadConfig(preConnect bool) error {
 		}
 	}
 	setQueueConfigs(queueProviderConfigs)
+	processed.queueTriggers = newconfig.QueueTriggers
+	processed.webhookListener = normalizeWebhookListenerConfig(newconfig.WebhookListener)
 	if newconfig.Brain != "" {
 		processed.brainProvider = newconfig.Brain
 		if cfg, loaded, err := loadProviderFileData("brains", newconfig.Brain, true); err != nil {
@@ -900,12 +914,29 @@ func loadConfig(preConnect bool) error {
 	}
 	st := make([]ScheduledTask, 0, len(newconfig.ScheduledJobs))
 	for _, s := range newconfig.ScheduledJobs {
-		if len(s.Name) == 0 || len(s.Schedule) == 0 {
+		if len(s.Name) == 0 || (len(s.Schedule) == 0 && !s.hasDependencies()) {
 			Log(robot.Error, "Zero-length Name (%s) or Schedule (%s) in ScheduledTask, skipping", s.Name, s.Schedule)
+		} else if len(s.Schedule) > 0 && s.hasDependencies() {
+			Log(robot.Error, "ScheduledTask '%s' has both a Schedule and After/OnFailure jobs, skipping", s.Name)
+		} else if s.Overlap = strings.ToLower(s.Overlap); !validOverlapPolicy(s.Overlap) {
+			Log(robot.Error, "ScheduledTask '%s' has invalid Overlap '%s', must be one of skip, queue or allow; skipping", s.Name, s.Overlap)
+		} else if err := s.prepareGates(); err != nil {
+			Log(robot.Error, "ScheduledTask '%s': %v; skipping", s.Name, err)
 		} else {
+			if s.CatchUp && (len(s.Schedule) == 0 || s.Schedule == "@init") {
+				Log(robot.Warn, "Ignoring CatchUp for ScheduledTask '%s', which doesn't run on a cron Schedule", s.Name)
+				s.CatchUp = false
+			}
+			if s.Schedule == "@init" && (s.JitterSeconds > 0 || len(s.Blackouts) > 0 || len(s.HolidayCalendar) > 0) {
+				Log(robot.Warn, "Ignoring JitterSeconds, Blackouts and HolidayCalendar for @init ScheduledTask '%s'", s.Name)
+			}
 			st = append(st, s)
 		}
 	}
+	st, graphErrs := checkScheduledJobGraph(st)
+	for _, err := range graphErrs {
+		Log(robot.Error, "%v", err)
+	}
 	processed.ScheduledJobs = st
 	if newconfig.IgnoreUsers != nil {
 		processed.ignoreUsers = newconfig.IgnoreUsers
@@ -1067,6 +1098,7 @@ func loadConfig(preConnect bool) error {
 	if !preConnect {
 		reconcileSecondaryConnectorRuntimes(processed.secondaryProtocols)
 		reconcileQueueProviderRuntimes(processed.queueProviders)
+		reconcileWebhookListener()
 		if err := reloadActiveConnectorRuntimes(); err != nil {
 			Log(robot.Error, "Reloading active connectors: %v", err)
 		}
@@ -214,7 +214,6 @@ func decryptTpl(encval string) (string, error) {
 func secretTpl(name string) (string, error) {
 	cryptKey.RLock()
 	initialized := cryptKey.initialized
-	key := cryptKey.key
 	cryptKey.RUnlock()
 	if !initialized {
 		return "", fmt.Errorf("template secret %q requested but encryption is not initialized", name)
@@ -229,7 +228,7 @@ func secretTpl(name string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("base64 decoding template secret %q: %w", name, err)
 	}
-	secret, decerr := decrypt(encbytes, key)
+	secret, _, decerr := decryptWithKeyring(encbytes)
 	if decerr != nil {
 		return "", fmt.Errorf("decrypting template secret %q: %w", name, decerr)
 	}
@@ -63,6 +63,16 @@ func protocolNameFromEnum(protocol robot.Protocol) string {
 		return "nullconn"
 	case robot.SSH:
 		return "ssh"
+	case robot.Matrix:
+		return "matrix"
+	case robot.Mattermost:
+		return "mattermost"
+	case robot.Teams:
+		return "teams"
+	case robot.Discord:
+		return "discord"
+	case robot.IRC:
+		return "irc"
 	default:
 		return "test"
 	}
@@ -365,6 +375,7 @@ func startConnectorRuntime(protocol string, required bool) error {
 		close(done)
 	}(p, conn, stop, done)
 	Log(robot.Info, "Connector '%s' started", p)
+	registerConnectorCommands(p, conn)
 	return nil
 }
 
@@ -451,6 +462,7 @@ func reloadActiveConnectorRuntimes() error {
 			continue
 		}
 		Log(robot.Debug, "Connector '%s' reloaded", item.protocol)
+		registerConnectorCommands(item.protocol, item.connector)
 	}
 	if len(errs) > 0 {
 		return fmt.Errorf("%s", strings.Join(errs, "; "))
@@ -40,6 +40,7 @@ const (
 	initJob    // scheduled job schedule: @init
 	jobCommand // i.e. run job xx
 	queuedJob  // job triggered by a queue provider
+	webhookJob // job triggered by the webhook listener
 )
 
 //go:generate stringer -type=pipeAddFlavor constants.go
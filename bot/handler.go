@@ -219,6 +219,7 @@ type worker struct {
 	automaticTask           bool                    // set for scheduled & triggers jobs, where user security restrictions don't apply
 	queueProvider           string                  // queue provider that started a queued job
 	queueMessageID          string                  // provider-local queue message ID for a queued job
+	webhook                 *webhookOrigin          // delivery details for a webhook-triggered job
 	*pipeContext                                    // pointer to the pipeline context
 	serializeAPICalls       sync.Mutex              // serializes external HTTP/RPC Robot API calls for this worker
 	externalKillPending     bool                    // timeout/admin kill is waiting for serialized external API calls to drain
@@ -286,6 +287,7 @@ func (w *worker) clone() *worker {
 		fmsg:            w.fmsg,
 		queueProvider:   w.queueProvider,
 		queueMessageID:  w.queueMessageID,
+		webhook:         w.webhook,
 	}
 	if w.pipeContext != nil {
 		w.Lock()
@@ -109,6 +109,27 @@ type fallbackMatchSignal struct {
 	MeaningfulTerms int
 }
 
+func helpMetadataEntryFrom(e helpCommandMetadata) helpMetadataEntry {
+	return helpMetadataEntry{
+		PluginName:       e.PluginName,
+		Command:          e.Command,
+		SimpleMatcher:    e.SimpleMatcher,
+		Usage:            e.Usage,
+		Summary:          e.Summary,
+		Examples:         e.Examples,
+		PrivateExamples:  e.PrivateExamples,
+		Keywords:         e.Keywords,
+		Scope:            e.Scope,
+		PrivateOK:        e.PrivateOK,
+		PrivateRequired:  e.PrivateRequired,
+		PrivateSupported: e.PrivateSupported,
+		PrivateHint:      e.PrivateHint,
+		Channels:         e.Channels,
+		AllChannels:      e.AllChannels,
+		PluginSummary:    e.PluginSummary,
+	}
+}
+
 func (e helpMetadataEntry) toHelpCommandMetadata() helpCommandMetadata {
 	return helpCommandMetadata{
 		PluginName:       e.PluginName,
@@ -213,11 +234,7 @@ func (r Robot) collectHelpMetadata(query string) helpMetadataResponse {
 	w := getLockedWorker(r.tid)
 	w.Unlock()
 
-	type authorizerGroupLookup struct {
-		groups map[string]struct{}
-		known  bool
-	}
-	groupCache := make(map[string]authorizerGroupLookup)
+	authorized := newHelpAuthorizer(r, w)
 	byCommand := make(map[string]*helpMetadataEntry)
 
 	for _, t := range r.tasks.t[1:] {
@@ -230,89 +247,21 @@ func (r Robot) collectHelpMetadata(query string) helpMetadataResponse {
 		if !browseable {
 			continue
 		}
-		for _, matcher := range plugin.Commands {
-			command := strings.TrimSpace(strings.ToLower(matcher.Command))
-			if len(command) == 0 {
-				continue
-			}
-			if commandRequiresAuthorization(plugin, command) && strings.TrimSpace(task.AuthRequire) != "" {
-				authorizer := effectiveAuthorizerName(task, w.cfg.defaultAuthorizer)
-				cached, ok := groupCache[authorizer]
-				if !ok {
-					groups, known := r.getAuthorizerUserGroups(w, authorizer, w.User)
-					cached = authorizerGroupLookup{groups: groups, known: known}
-					groupCache[authorizer] = cached
-				}
-				if cached.known && !userHasRequiredGroup(cached.groups, task.AuthRequire) {
-					continue
-				}
-			}
-			commandVisibleHere := visibleHere
+		for _, command := range pluginHelpCommands(task, plugin, authorized.filter(task, plugin)) {
+			command.addPrivateHelp(protocol, result.Context.PrivateCommandHint, alias, botName)
+			entry := helpMetadataEntryFrom(command)
+			entry.VisibleHere = visibleHere
 			if privateCommandContext(w.Incoming) {
-				commandVisibleHere = commandAllowsPrivate(plugin, command) && w.privateContextSatisfiesChannels(task, plugin)
-			} else if commandRequiresPrivate(plugin, command) {
-				commandVisibleHere = false
-			}
-
-			key := task.name + "|" + command
-			entry, ok := byCommand[key]
-			if !ok {
-				entry = &helpMetadataEntry{
-					PluginName:    task.name,
-					Command:       command,
-					Scope:         helpScopeText(task),
-					VisibleHere:   commandVisibleHere,
-					Channels:      append([]string(nil), task.Channels...),
-					AllChannels:   task.AllChannels,
-					PluginSummary: helpPluginSummary(task),
-				}
-				byCommand[key] = entry
-			}
-			if commandVisibleHere {
-				entry.VisibleHere = true
+				entry.VisibleHere = commandAllowsPrivate(plugin, command.Command) && w.privateContextSatisfiesChannels(task, plugin)
+			} else if commandRequiresPrivate(plugin, command.Command) {
+				entry.VisibleHere = false
 			}
-			if len(entry.Usage) == 0 && len(strings.TrimSpace(matcher.Usage)) > 0 {
-				entry.Usage = strings.TrimSpace(matcher.Usage)
-			}
-			if len(entry.SimpleMatcher) == 0 && len(strings.TrimSpace(matcher.SimpleMatcher)) > 0 {
-				entry.SimpleMatcher = strings.TrimSpace(matcher.SimpleMatcher)
-			}
-			if len(entry.Summary) == 0 && len(strings.TrimSpace(matcher.Summary)) > 0 {
-				entry.Summary = strings.TrimSpace(matcher.Summary)
-			}
-			if entry.PluginSummary == "" {
-				entry.PluginSummary = helpPluginSummary(task, matcher.Summary)
-			}
-			if commandAllowsPrivate(plugin, command) {
-				entry.PrivateOK = true
-				entry.PrivateRequired = commandRequiresPrivate(plugin, command)
-				if result.Context.PrivateCommandsSupported {
-					entry.PrivateSupported = true
-				}
-				if entry.PrivateHint == "" && strings.TrimSpace(result.Context.PrivateCommandHint) != "" {
-					entry.PrivateHint = strings.TrimSpace(result.Context.PrivateCommandHint)
-				}
-			}
-			entry.Examples = appendUniqueStrings(entry.Examples, matcher.Examples...)
-			if entry.PrivateOK && result.Context.PrivateCommandsSupported {
-				for _, example := range matcher.Examples {
-					commandText := helpSurfaceCommandText(example, alias, botName)
-					hidden := strings.TrimSpace(formatHiddenCommand(protocol, commandText))
-					if hidden == "" {
-						continue
-					}
-					entry.PrivateExamples = appendUniqueStrings(entry.PrivateExamples, hidden)
-				}
-			}
-			entry.Keywords = appendUniqueStrings(entry.Keywords, matcher.Keywords...)
+			byCommand[task.name+"|"+command.Command] = &entry
 		}
 	}
 
 	result.Browseable = make([]helpMetadataEntry, 0, len(byCommand))
 	for _, entry := range byCommand {
-		if len(entry.Usage) == 0 {
-			entry.Usage = "(alias) " + entry.Command
-		}
 		result.Browseable = append(result.Browseable, *entry)
 		if entry.VisibleHere {
 			result.VisibleHere = append(result.VisibleHere, *entry)
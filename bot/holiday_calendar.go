@@ -0,0 +1,191 @@
+package bot
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// holidayCalendar is the set of dates from an iCalendar file; scheduled
+// runs on these dates are skipped. Dates are taken as written in the file,
+// in the scheduler's time zone.
+type holidayCalendar struct {
+	dates  map[string]string // "2006-01-02" -> event summary
+	yearly map[string]string // "01-02" -> event summary, for FREQ=YEARLY events
+}
+
+func (c *holidayCalendar) holiday(t time.Time) (string, bool) {
+	if name, ok := c.dates[t.Format("2006-01-02")]; ok {
+		return name, true
+	}
+	name, ok := c.yearly[t.Format("01-02")]
+	return name, ok
+}
+
+// icsLines unfolds the content lines of an iCalendar file (RFC 5545 3.1).
+func icsLines(data []byte) []string {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// icsDate parses the date part of a DATE or DATE-TIME value, reporting
+// whether it had a time of day other than midnight.
+func icsDate(value string) (date time.Time, timed bool, err error) {
+	if len(value) < 8 {
+		return time.Time{}, false, fmt.Errorf("invalid date '%s'", value)
+	}
+	date, err = time.Parse("20060102", value[:8])
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid date '%s'", value)
+	}
+	if len(value) > 8 {
+		timed = !strings.HasPrefix(value[8:], "T000000")
+	}
+	return date, timed, nil
+}
+
+type icsEvent struct {
+	summary  string
+	start    string
+	end      string
+	rrule    string
+	startSet bool
+}
+
+// parseHolidayCalendar reads the VEVENTs of an iCalendar file. An all-day
+// event covers its DTSTART up to, not including, DTEND; a timed event
+// covers every date it touches. A yearly RRULE on a fixed date repeats the
+// event's dates every year; other recurrence rules only count the first
+// occurrence, and are returned as warnings.
+func parseHolidayCalendar(data []byte) (*holidayCalendar, []string, error) {
+	cal := &holidayCalendar{
+		dates:  map[string]string{},
+		yearly: map[string]string{},
+	}
+	var warnings []string
+	var ev *icsEvent
+	for _, line := range icsLines(data) {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		prop, _, _ := strings.Cut(name, ";")
+		prop = strings.ToUpper(prop)
+		switch {
+		case prop == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			ev = &icsEvent{}
+		case prop == "END" && strings.EqualFold(value, "VEVENT"):
+			if ev == nil {
+				continue
+			}
+			warning, err := cal.add(ev)
+			if err != nil {
+				return nil, nil, err
+			}
+			if warning != "" {
+				warnings = append(warnings, warning)
+			}
+			ev = nil
+		case ev == nil:
+		case prop == "SUMMARY":
+			ev.summary = strings.ReplaceAll(value, `\,`, ",")
+		case prop == "DTSTART":
+			ev.start = value
+			ev.startSet = true
+		case prop == "DTEND":
+			ev.end = value
+		case prop == "RRULE":
+			ev.rrule = strings.ToUpper(value)
+		}
+	}
+	return cal, warnings, nil
+}
+
+func (c *holidayCalendar) add(ev *icsEvent) (warning string, err error) {
+	if !ev.startSet {
+		return "", fmt.Errorf("event '%s' has no DTSTART", ev.summary)
+	}
+	start, _, err := icsDate(ev.start)
+	if err != nil {
+		return "", fmt.Errorf("event '%s': %v", ev.summary, err)
+	}
+	end := start.AddDate(0, 0, 1)
+	if ev.end != "" {
+		e, timed, err := icsDate(ev.end)
+		if err != nil {
+			return "", fmt.Errorf("event '%s': %v", ev.summary, err)
+		}
+		if timed {
+			e = e.AddDate(0, 0, 1)
+		}
+		if e.After(start) {
+			end = e
+		}
+	}
+	yearly := false
+	if ev.rrule != "" {
+		yearly = fixedYearlyRule(ev.rrule, start)
+		if !yearly {
+			warning = fmt.Sprintf("event '%s' has unsupported RRULE '%s', only its first occurrence is used", ev.summary, ev.rrule)
+		}
+	}
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		if yearly {
+			c.yearly[d.Format("01-02")] = ev.summary
+		} else {
+			c.dates[d.Format("2006-01-02")] = ev.summary
+		}
+	}
+	return warning, nil
+}
+
+// fixedYearlyRule reports whether rrule repeats on start's month and day
+// every year; COUNT and UNTIL are ignored.
+func fixedYearlyRule(rrule string, start time.Time) bool {
+	yearly := false
+	for _, part := range strings.Split(rrule, ";") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "FREQ":
+			yearly = value == "YEARLY"
+		case "INTERVAL":
+			if value != "1" {
+				return false
+			}
+		case "BYMONTH":
+			if value != strconv.Itoa(int(start.Month())) {
+				return false
+			}
+		case "BYMONTHDAY":
+			if value != strconv.Itoa(start.Day()) {
+				return false
+			}
+		case "COUNT", "UNTIL", "WKST":
+		default:
+			return false
+		}
+	}
+	return yearly
+}
+
+func loadHolidayCalendar(path string) (*holidayCalendar, []string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return parseHolidayCalendar(data)
+}
@@ -10,6 +10,7 @@ import (
 	"encoding/json"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/lnxjedi/gopherbot/robot"
 )
@@ -105,6 +106,7 @@ type memory struct {
 	Key   string
 	Token string
 	Datum json.RawMessage
+	TTL   float64 // seconds, UpdateDatumWithTTL only
 }
 
 // Something to be recalled from long term memory
@@ -464,6 +466,18 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		ret = update(key, m.Token, (*[]byte)(&m.Datum))
 		sendReturn(r, rw, &botretvalresponse{int(ret)})
 		return
+	case "UpdateDatumWithTTL":
+		var m memory
+		if !getArgs(rw, &f.FuncArgs, &m) {
+			return
+		}
+		w := getLockedWorker(r.tid)
+		w.Unlock()
+		key := w.getNameSpace(r.currentTask) + ":" + m.Key
+		ttl := time.Duration(m.TTL * float64(time.Second))
+		ret = updateWithTTL(key, m.Token, (*[]byte)(&m.Datum), ttl)
+		sendReturn(r, rw, &botretvalresponse{int(ret)})
+		return
 	case "DeleteDatum":
 		var d datumdelete
 		if !getArgs(rw, &f.FuncArgs, &d) {
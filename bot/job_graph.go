@@ -0,0 +1,291 @@
+package bot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+// hasDependencies reports whether a ScheduledJobs entry is started by other
+// jobs finishing rather than by a Schedule.
+func (st ScheduledTask) hasDependencies() bool {
+	return len(st.After) > 0 || len(st.OnFailure) > 0
+}
+
+// upstream lists the jobs named in an entry's After and OnFailure edges.
+func (st ScheduledTask) upstream() []string {
+	seen := make(map[string]bool, len(st.After)+len(st.OnFailure))
+	up := make([]string, 0, len(st.After)+len(st.OnFailure))
+	for _, name := range append(append([]string{}, st.After...), st.OnFailure...) {
+		if !seen[name] {
+			seen[name] = true
+			up = append(up, name)
+		}
+	}
+	return up
+}
+
+// checkScheduledJobGraph drops ScheduledJobs entries whose After or OnFailure
+// edges form a cycle, or name a job with no ScheduledJobs entry of its own;
+// dependents of a dropped entry are dropped in turn.
+func checkScheduledJobGraph(jobs []ScheduledTask) ([]ScheduledTask, []error) {
+	var errs []error
+	names := make(map[string]bool, len(jobs))
+	edges := make(map[string][]string)
+	for _, st := range jobs {
+		names[st.Name] = true
+		edges[st.Name] = append(edges[st.Name], st.upstream()...)
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	cyclic := make(map[string]bool)
+	state := make(map[string]int, len(names))
+	var stack []string
+	var visit func(name string)
+	visit = func(name string) {
+		state[name] = visiting
+		stack = append(stack, name)
+		for _, up := range edges[name] {
+			if !names[up] {
+				continue
+			}
+			switch state[up] {
+			case unvisited:
+				visit(up)
+			case visiting:
+				// Walk back down the stack to up; reversed, that's run order.
+				path := []string{up}
+				for i := len(stack) - 1; i >= 0; i-- {
+					cyclic[stack[i]] = true
+					path = append(path, stack[i])
+					if stack[i] == up {
+						break
+					}
+				}
+				errs = append(errs, fmt.Errorf("dependency cycle in ScheduledJobs: %s", strings.Join(path, " -> ")))
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = visited
+	}
+	for _, name := range sorted {
+		if state[name] == unvisited {
+			visit(name)
+		}
+	}
+
+	kept := jobs
+	for {
+		present := make(map[string]bool, len(kept))
+		for _, st := range kept {
+			present[st.Name] = true
+		}
+		next := make([]ScheduledTask, 0, len(kept))
+		for _, st := range kept {
+			if !st.hasDependencies() {
+				next = append(next, st)
+				continue
+			}
+			if cyclic[st.Name] {
+				errs = append(errs, fmt.Errorf("ignoring ScheduledJobs entry for '%s': part of a dependency cycle", st.Name))
+				continue
+			}
+			missing := ""
+			for _, up := range st.upstream() {
+				if !present[up] {
+					missing = up
+					break
+				}
+			}
+			if missing != "" {
+				errs = append(errs, fmt.Errorf("ignoring ScheduledJobs entry for '%s': depends on '%s', which has no usable ScheduledJobs entry", st.Name, missing))
+				continue
+			}
+			next = append(next, st)
+		}
+		if len(next) == len(kept) {
+			return next, errs
+		}
+		kept = next
+	}
+}
+
+// scheduledJobLevels gives the depth of each job in the graph: 0 for jobs
+// that only run on a Schedule, otherwise one more than the deepest job it
+// waits on. jobs must already have passed checkScheduledJobGraph.
+func scheduledJobLevels(jobs []ScheduledTask) map[string]int {
+	edges := make(map[string][]string)
+	for _, st := range jobs {
+		edges[st.Name] = append(edges[st.Name], st.upstream()...)
+	}
+	levels := make(map[string]int, len(edges))
+	var level func(name string) int
+	level = func(name string) int {
+		if l, ok := levels[name]; ok {
+			return l
+		}
+		l := 0
+		for _, up := range edges[name] {
+			if ul := level(up) + 1; ul > l {
+				l = ul
+			}
+		}
+		levels[name] = l
+		return l
+	}
+	for name := range edges {
+		level(name)
+	}
+	return levels
+}
+
+// scheduledJobGraphLines renders the ScheduledJobs graph for 'jobs graph',
+// upstream jobs first and each entry indented by its depth.
+func scheduledJobGraphLines(jobs []ScheduledTask) []string {
+	levels := scheduledJobLevels(jobs)
+	ordered := append([]ScheduledTask{}, jobs...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return levels[ordered[i].Name] < levels[ordered[j].Name]
+	})
+	lines := make([]string, 0, len(ordered))
+	for _, st := range ordered {
+		var edges []string
+		if len(st.Schedule) > 0 {
+			edges = append(edges, "schedule: "+st.Schedule)
+		}
+		if len(st.After) > 0 {
+			edges = append(edges, "after: "+strings.Join(st.After, ", "))
+		}
+		if len(st.OnFailure) > 0 {
+			edges = append(edges, "on failure: "+strings.Join(st.OnFailure, ", "))
+		}
+		name := st.Name
+		if len(st.Arguments) > 0 {
+			name += " " + strings.Join(st.Arguments, " ")
+		}
+		lines = append(lines, fmt.Sprintf("%s%s - %s", strings.Repeat("  ", levels[st.Name]), name, strings.Join(edges, "; ")))
+	}
+	return lines
+}
+
+// scheduledJobNode is a dependent ScheduledJobs entry and the After jobs
+// it's still waiting on in the current cycle.
+type scheduledJobNode struct {
+	st      ScheduledTask
+	t       interface{}
+	pending map[string]bool
+}
+
+func (n *scheduledJobNode) reset() {
+	n.pending = make(map[string]bool, len(n.st.After))
+	for _, name := range n.st.After {
+		n.pending[name] = true
+	}
+}
+
+// scheduledJobGraph holds the dependent entries registered by scheduleTasks,
+// along with the config they run under.
+type scheduledJobGraph struct {
+	sync.Mutex
+	nodes []*scheduledJobNode
+	cfg   *configuration
+	tasks *taskList
+}
+
+var jobGraph = &scheduledJobGraph{}
+
+func (g *scheduledJobGraph) set(nodes []*scheduledJobNode, cfg *configuration, tasks *taskList) {
+	for _, n := range nodes {
+		n.reset()
+	}
+	g.Lock()
+	g.nodes = nodes
+	g.cfg = cfg
+	g.tasks = tasks
+	g.Unlock()
+}
+
+// jobStarted puts job back on the After list of every entry waiting on it,
+// so a success from an earlier run can't satisfy an entry while the new run
+// is still going, or after it fails.
+func (g *scheduledJobGraph) jobStarted(job string) {
+	g.Lock()
+	defer g.Unlock()
+	for _, n := range g.nodes {
+		for _, name := range n.st.After {
+			if name == job {
+				n.pending[job] = true
+				break
+			}
+		}
+	}
+}
+
+// jobCompleted advances every entry waiting on job and returns those now
+// ready to run. A success clears job from an entry's After list, and the
+// entry runs once the list is empty; a failure starts the cycle over, and
+// runs entries naming job in OnFailure.
+func (g *scheduledJobGraph) jobCompleted(job string, succeeded bool) (ready []*scheduledJobNode, cfg *configuration, tasks *taskList) {
+	g.Lock()
+	defer g.Unlock()
+	for _, n := range g.nodes {
+		run := false
+		for _, name := range n.st.After {
+			if name != job {
+				continue
+			}
+			if succeeded {
+				delete(n.pending, job)
+				if len(n.pending) == 0 {
+					run = true
+					n.reset()
+				}
+			} else {
+				n.reset()
+			}
+			break
+		}
+		if !succeeded {
+			for _, name := range n.st.OnFailure {
+				if name == job {
+					run = true
+					break
+				}
+			}
+		}
+		if run {
+			ready = append(ready, n)
+		}
+	}
+	return ready, g.cfg, g.tasks
+}
+
+// scheduledJobStarting discards earlier successes of job that entries
+// waiting on it haven't used yet.
+func scheduledJobStarting(job string) {
+	jobGraph.jobStarted(job)
+}
+
+// scheduledJobFinished starts any entries waiting on a scheduled run of job.
+func scheduledJobFinished(job string, ret robot.TaskRetVal) {
+	if ret == robot.RobotStopping {
+		return
+	}
+	ready, cfg, tasks := jobGraph.jobCompleted(job, ret == robot.Normal)
+	for _, n := range ready {
+		Log(robot.Info, "Starting job '%s', args '%v' after '%s' finished with status: %s", n.st.Name, n.st.Arguments, job, ret)
+		go gatedScheduledRun(n.st, n.t, cfg, tasks)
+	}
+}
@@ -0,0 +1,128 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+)
+
+func scheduledNames(jobs []ScheduledTask) []string {
+	names := make([]string, 0, len(jobs))
+	for _, st := range jobs {
+		names = append(names, st.Name)
+	}
+	return names
+}
+
+func TestCheckScheduledJobGraph(t *testing.T) {
+	jobs := []ScheduledTask{
+		{Schedule: "@daily", TaskSpec: TaskSpec{Name: "backup"}},
+		{After: []string{"backup"}, TaskSpec: TaskSpec{Name: "verify"}},
+		{After: []string{"verify"}, OnFailure: []string{"backup"}, TaskSpec: TaskSpec{Name: "report"}},
+		{After: []string{"pong"}, TaskSpec: TaskSpec{Name: "ping"}},
+		{After: []string{"ping"}, TaskSpec: TaskSpec{Name: "pong"}},
+		{After: []string{"self"}, TaskSpec: TaskSpec{Name: "self"}},
+		{After: []string{"missing"}, TaskSpec: TaskSpec{Name: "orphan"}},
+		{After: []string{"orphan"}, TaskSpec: TaskSpec{Name: "grandchild"}},
+	}
+	kept, errs := checkScheduledJobGraph(jobs)
+	if got, want := strings.Join(scheduledNames(kept), ","), "backup,verify,report"; got != want {
+		t.Fatalf("kept = %s, want %s", got, want)
+	}
+	var msgs []string
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	all := strings.Join(msgs, "\n")
+	for _, want := range []string{
+		"dependency cycle in ScheduledJobs: ping -> pong -> ping",
+		"dependency cycle in ScheduledJobs: self -> self",
+		"'orphan': depends on 'missing'",
+		"'grandchild': depends on 'orphan'",
+	} {
+		if !strings.Contains(all, want) {
+			t.Errorf("errors missing %q:\n%s", want, all)
+		}
+	}
+}
+
+func TestScheduledJobGraphCycles(t *testing.T) {
+	g := &scheduledJobGraph{}
+	report := &scheduledJobNode{st: ScheduledTask{After: []string{"backup", "verify"}, TaskSpec: TaskSpec{Name: "report"}}}
+	alert := &scheduledJobNode{st: ScheduledTask{OnFailure: []string{"backup"}, TaskSpec: TaskSpec{Name: "alert"}}}
+	g.set([]*scheduledJobNode{report, alert}, nil, nil)
+
+	ready := func(job string, ok bool) string {
+		nodes, _, _ := g.jobCompleted(job, ok)
+		var names []string
+		for _, n := range nodes {
+			names = append(names, n.st.Name)
+		}
+		return strings.Join(names, ",")
+	}
+	if got := ready("backup", true); got != "" {
+		t.Fatalf("after backup succeeded, ready = %q", got)
+	}
+	if got := ready("verify", true); got != "report" {
+		t.Fatalf("after verify succeeded, ready = %q, want report", got)
+	}
+	// A new cycle needs both jobs again, and a failure starts it over.
+	if got := ready("verify", true); got != "" {
+		t.Fatalf("verify alone started %q", got)
+	}
+	if got := ready("backup", false); got != "alert" {
+		t.Fatalf("after backup failed, ready = %q, want alert", got)
+	}
+	if got := ready("backup", true); got != "" {
+		t.Fatalf("backup without a fresh verify started %q", got)
+	}
+	if got := ready("verify", true); got != "report" {
+		t.Fatalf("second cycle ready = %q, want report", got)
+	}
+}
+
+func TestScheduledJobGraphIgnoresStaleSuccess(t *testing.T) {
+	g := &scheduledJobGraph{}
+	report := &scheduledJobNode{st: ScheduledTask{After: []string{"backup", "verify"}, TaskSpec: TaskSpec{Name: "report"}}}
+	g.set([]*scheduledJobNode{report}, nil, nil)
+
+	ready := func(job string) int {
+		nodes, _, _ := g.jobCompleted(job, true)
+		return len(nodes)
+	}
+	if ready("backup") != 0 {
+		t.Fatal("backup alone started report")
+	}
+	// backup starts its next run before verify finishes; the earlier
+	// success no longer counts.
+	g.jobStarted("backup")
+	if ready("verify") != 0 {
+		t.Fatal("verify started report with a stale backup success")
+	}
+	if ready("backup") != 1 {
+		t.Fatal("report didn't run once the new backup run succeeded")
+	}
+	// Starting a job that hasn't succeeded this cycle, or that nothing
+	// waits on, changes nothing.
+	g.jobStarted("verify")
+	g.jobStarted("cleanup")
+	if ready("backup") != 0 || ready("verify") != 1 {
+		t.Fatal("next cycle didn't need both jobs")
+	}
+}
+
+func TestScheduledJobGraphLines(t *testing.T) {
+	jobs := []ScheduledTask{
+		{After: []string{"verify"}, TaskSpec: TaskSpec{Name: "report"}},
+		{After: []string{"backup"}, TaskSpec: TaskSpec{Name: "verify", Arguments: []string{"full"}}},
+		{Schedule: "@daily", TaskSpec: TaskSpec{Name: "backup"}},
+	}
+	got := strings.Join(scheduledJobGraphLines(jobs), "\n")
+	want := strings.Join([]string{
+		"backup - schedule: @daily",
+		"  verify full - after: backup",
+		"    report - after: verify",
+	}, "\n")
+	if got != want {
+		t.Fatalf("graph lines:\n%s\nwant:\n%s", got, want)
+	}
+}
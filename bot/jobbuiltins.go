@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"fmt"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/lnxjedi/gopherbot/robot"
 )
@@ -20,6 +22,39 @@ Job builtins are special:
 
 const histPageSize = 2048 // how much history to display at a time
 
+const searchLogsMaxResults = 50 // most recent matching lines shown by 'search logs'
+
+var searchWindowRe = regexp.MustCompile(`^(\d*)\s*([a-z]+)$`)
+
+var searchWindowUnits = map[string]time.Duration{
+	"s": time.Second, "sec": time.Second, "secs": time.Second, "second": time.Second, "seconds": time.Second,
+	"m": time.Minute, "min": time.Minute, "mins": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "hrs": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "wk": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour,
+}
+
+// parseSearchWindow parses the "last <window>" of 'search logs', e.g.
+// "week", "2d" or "12 hours".
+func parseSearchWindow(window string) (time.Duration, error) {
+	m := searchWindowRe.FindStringSubmatch(strings.ToLower(strings.TrimSpace(window)))
+	if m == nil {
+		return 0, fmt.Errorf("unrecognized time window '%s'", window)
+	}
+	unit, ok := searchWindowUnits[m[2]]
+	if !ok {
+		return 0, fmt.Errorf("unrecognized time unit '%s'", m[2])
+	}
+	n := 1
+	if m[1] != "" {
+		n, _ = strconv.Atoi(m[1])
+		if n <= 0 {
+			return 0, fmt.Errorf("time window must be positive")
+		}
+	}
+	return time.Duration(n) * unit, nil
+}
+
 func init() {
 	robot.RegisterPlugin("builtin-history", robot.PluginHandler{Handler: jobhistory})
 	robot.RegisterPlugin("builtin-jobcmd", robot.PluginHandler{Handler: jobcommands})
@@ -40,6 +75,10 @@ func jobcommands(m robot.Robot, command string, args ...string) (retval robot.Ta
 		} else {
 			jl = []string{"Here's a list of jobs for this channel:"}
 		}
+		currentCfg.RLock()
+		scheduled := currentCfg.ScheduledJobs
+		currentCfg.RUnlock()
+		nextRuns := nextScheduledRuns(scheduled, time.Now().In(scheduleLocation(r.cfg)))
 		for _, t := range tasks.t[1:] {
 			if ok, _ := r.jobVisible(t, alljobs, true); !ok {
 				continue
@@ -48,6 +87,8 @@ func jobcommands(m robot.Robot, command string, args ...string) (retval robot.Ta
 			after := ""
 			if task.Disabled {
 				after = fmt.Sprintf(" (disabled: %s)", task.reason)
+			} else if next, ok := nextRuns[task.name]; ok {
+				after = fmt.Sprintf(" (%s)", next)
 			}
 			if alljobs {
 				jl = append(jl, fmt.Sprintf("%s (channel: %s)%s", task.name, task.Channel, after))
@@ -64,6 +105,25 @@ func jobcommands(m robot.Robot, command string, args ...string) (retval robot.Ta
 			return
 		}
 		r.Say(strings.Join(jl, "\n"))
+	case "jobgraph":
+		currentCfg.RLock()
+		scheduled := currentCfg.ScheduledJobs
+		currentCfg.RUnlock()
+		visible := make([]ScheduledTask, 0, len(scheduled))
+		for _, st := range scheduled {
+			t := tasks.getTaskByName(st.Name)
+			if t == nil {
+				continue
+			}
+			if ok, _ := r.jobVisible(t, true, true); ok {
+				visible = append(visible, st)
+			}
+		}
+		if len(visible) == 0 {
+			r.Say("I don't have any scheduled jobs you can see")
+			return
+		}
+		r.Fixed().Say("Scheduled jobs, dependent jobs indented below what they wait on:\n%s", strings.Join(scheduledJobGraphLines(visible), "\n"))
 	}
 	return
 }
@@ -114,7 +174,7 @@ func jobhistory(m robot.Robot, command string, args ...string) (retval robot.Tas
 		address = args[2]
 	case "taillog", "linklog":
 		histRef = args[0]
-	case "joblogs":
+	case "joblogs", "searchlogs":
 		jobName = args[0]
 	}
 
@@ -195,6 +255,8 @@ func jobhistory(m robot.Robot, command string, args ...string) (retval robot.Tas
 			return
 		}
 		r.Say("Here you go: %s", url)
+	case "searchlogs":
+		return searchlogs(r, jobName, args[1], args[2], args[3])
 	case "joblogs":
 		var loglines []string
 		loglines = []string{fmt.Sprintf("Logs for job '%s':", jobName)}
@@ -209,6 +271,56 @@ func jobhistory(m robot.Robot, command string, args ...string) (retval robot.Tas
 	return
 }
 
+// searchlogs queries a searchable history provider across runs of a job.
+func searchlogs(r Robot, jobName, stream, window, text string) (retval robot.TaskRetVal) {
+	searcher, ok := getHistoryProvider().(robot.HistorySearcher)
+	if !ok {
+		r.Say("Sorry, the configured history provider doesn't support searching logs")
+		return
+	}
+	q := robot.HistoryQuery{
+		Pipeline: jobName,
+		Stream:   strings.ToUpper(stream),
+		Contains: strings.TrimSpace(text),
+		Limit:    searchLogsMaxResults,
+	}
+	if len(window) > 0 {
+		d, err := parseSearchWindow(window)
+		if err != nil {
+			r.Say("Sorry, %v; try e.g. 'last week' or 'last 12h'", err)
+			return
+		}
+		q.Since = time.Now().Add(-d)
+	}
+	entries, err := searcher.SearchLogs(q)
+	if err != nil {
+		r.Say("There was an error searching logs for '%s'", jobName)
+		r.Log(robot.Error, "Searching logs for '%s': %v", jobName, err)
+		return
+	}
+	if len(entries) == 0 {
+		r.Say("No matching log lines found for '%s'", jobName)
+		return
+	}
+	currentCfg.RLock()
+	tz := currentCfg.timeZone
+	currentCfg.RUnlock()
+	lines := []string{fmt.Sprintf("Most recent %d matching line(s) for '%s':", len(entries), jobName)}
+	for _, e := range entries {
+		ts := e.Time
+		if tz != nil {
+			ts = ts.In(tz)
+		}
+		stream := e.Stream
+		if e.Level != "" {
+			stream += " " + e.Level
+		}
+		lines = append(lines, fmt.Sprintf("%s run %d %s %s: %s", ts.Format("Jan 2 15:04:05"), e.Run, e.Task, stream, e.Line))
+	}
+	r.Fixed().Say("%s", strings.Join(lines, "\n"))
+	return
+}
+
 // jobSecurityCheck performs all security checks - RequireAdmin, Authorization
 // and Elevation - and returns true if passed. It will message the user and
 // return false if a check fails.
@@ -0,0 +1,25 @@
+package bot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSearchWindow(t *testing.T) {
+	for in, want := range map[string]time.Duration{
+		"week":     7 * 24 * time.Hour,
+		"2d":       48 * time.Hour,
+		"12 hours": 12 * time.Hour,
+		"30M":      30 * time.Minute,
+	} {
+		got, err := parseSearchWindow(in)
+		if err != nil || got != want {
+			t.Errorf("parseSearchWindow(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"0d", "fortnight", "2 d ago"} {
+		if _, err := parseSearchWindow(bad); err == nil {
+			t.Errorf("parseSearchWindow(%q) should fail", bad)
+		}
+	}
+}
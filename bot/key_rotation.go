@@ -0,0 +1,722 @@
+package bot
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lnxjedi/gopherbot/robot"
+	"gopkg.in/yaml.v3"
+)
+
+// Key rotation replaces the robot data key (the key wrapped by
+// GOPHER_ENCRYPTION_KEY in binary-encrypted-key[.<environment>]) and then
+// re-encrypts every brain memory and conf/variables secret with it.
+//
+// Rotation is journaled by a sibling "<key file>.retired" holding the old
+// data key, wrapped the same way. While it exists the old key stays in the
+// keyring so mixed-key brains and variables remain readable; re-running the
+// rotation resumes from wherever it stopped, and the retired file is only
+// removed once nothing readable is left on the old key.
+const retiredKeyFileSuffix = ".retired"
+
+// keyedBrainStore is implemented by brains that record which data key
+// encrypted each memory.
+type keyedBrainStore interface {
+	StoreWithKeyID(key string, blob *[]byte, keyID string) error
+}
+
+// encryptionKeyID is a short, non-secret fingerprint of a data key.
+func encryptionKeyID(key []byte) string {
+	if len(key) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(append([]byte("gopherbot-key-id:"), key...))
+	return hex.EncodeToString(sum[:6])
+}
+
+func currentEncryptionKeyID() string {
+	cryptKey.RLock()
+	defer cryptKey.RUnlock()
+	return encryptionKeyID(cryptKey.key)
+}
+
+// decryptWithKeyring decrypts with the current data key, falling back to a
+// retired key during an unfinished rotation. It returns the ID of the key
+// that worked.
+func decryptWithKeyring(ciphertext []byte) ([]byte, string, error) {
+	cryptKey.RLock()
+	keys := append([][]byte{cryptKey.key}, cryptKey.retired...)
+	cryptKey.RUnlock()
+	var firstErr error
+	for _, key := range keys {
+		plain, err := decrypt(ciphertext, key)
+		if err == nil {
+			return plain, encryptionKeyID(key), nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, "", firstErr
+}
+
+func readWrappedKeyFile(path string, wrapping []byte) ([]byte, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil {
+		return nil, fmt.Errorf("base64 decoding %s: %w", path, err)
+	}
+	key, err := decrypt(wrapped, wrapping)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting %s with %s: %w", path, keyEnv, err)
+	}
+	return key, nil
+}
+
+func writeWrappedKeyFile(path string, key, wrapping []byte) error {
+	wrapped, err := encrypt(key, wrapping)
+	if err != nil {
+		return err
+	}
+	encoded := base64.StdEncoding.EncodeToString(wrapped)
+	if err := writeAtomicFile(path, []byte(encoded), encryptedKeyFileMode); err != nil {
+		return err
+	}
+	return enforceEncryptedKeyFilePermissions(path)
+}
+
+// loadRetiredEncryptionKey adds the key from an interrupted rotation to the
+// keyring; called by initCrypt once the current key is loaded.
+func loadRetiredEncryptionKey(keyFile string, wrapping []byte) {
+	retiredFile := keyFile + retiredKeyFileSuffix
+	if _, err := os.Stat(retiredFile); err != nil {
+		return
+	}
+	retired, err := readWrappedKeyFile(retiredFile, wrapping)
+	if err != nil {
+		Log(robot.Error, "Reading retired encryption key: %v", err)
+		return
+	}
+	cryptKey.Lock()
+	if !bytes.Equal(retired, cryptKey.key) {
+		cryptKey.retired = [][]byte{retired}
+	}
+	cryptKey.Unlock()
+	Log(robot.Warn, "Encryption key rotation from key %s is unfinished; run 'gopherbot rotate-key' or the 'rotate encryption key' admin command to complete it", encryptionKeyID(retired))
+}
+
+func wrappingKeyFromEnv() ([]byte, error) {
+	ek, ok := lookupEnv(keyEnv)
+	if !ok || len(ek) < 32 {
+		return nil, fmt.Errorf("%s must be set and at least 32 bytes long", keyEnv)
+	}
+	return []byte(ek)[:32], nil
+}
+
+// keyRotationStatus describes the on-disk rotation state.
+type keyRotationStatus struct {
+	KeyFile      string
+	CurrentKeyID string
+	RetiredKeyID string
+}
+
+func (s keyRotationStatus) inProgress() bool { return s.RetiredKeyID != "" }
+
+func currentKeyRotationStatus() (keyRotationStatus, error) {
+	keyFile, _, _, err := resolveEncryptedKeyFile()
+	if err != nil {
+		return keyRotationStatus{}, err
+	}
+	if keyFile == "" {
+		return keyRotationStatus{}, fmt.Errorf("no binary encryption key file found; nothing to rotate")
+	}
+	status := keyRotationStatus{KeyFile: keyFile, CurrentKeyID: currentEncryptionKeyID()}
+	cryptKey.RLock()
+	if len(cryptKey.retired) > 0 {
+		status.RetiredKeyID = encryptionKeyID(cryptKey.retired[0])
+	}
+	cryptKey.RUnlock()
+	return status, nil
+}
+
+// beginKeyRotation installs a new data key, journaling the old one, or
+// picks up an interrupted rotation. It returns true when resuming.
+func beginKeyRotation() (bool, error) {
+	wrapping, err := wrappingKeyFromEnv()
+	if err != nil {
+		return false, err
+	}
+	status, err := currentKeyRotationStatus()
+	if err != nil {
+		return false, err
+	}
+	retiredFile := status.KeyFile + retiredKeyFileSuffix
+	cryptKey.RLock()
+	current := append([]byte(nil), cryptKey.key...)
+	cryptKey.RUnlock()
+
+	old := current
+	resumed := false
+	if _, err := os.Stat(retiredFile); err == nil {
+		if old, err = readWrappedKeyFile(retiredFile, wrapping); err != nil {
+			return false, err
+		}
+		if !bytes.Equal(old, current) {
+			// Both keys are already installed; only the re-encryption is left.
+			cryptKey.Lock()
+			cryptKey.retired = [][]byte{old}
+			cryptKey.Unlock()
+			return true, nil
+		}
+		// Interrupted after journaling the old key but before installing
+		// the new one.
+		resumed = true
+	} else if err := writeWrappedKeyFile(retiredFile, current, wrapping); err != nil {
+		return false, fmt.Errorf("journaling retired key: %w", err)
+	}
+	next := make([]byte, 32)
+	if _, err := crand.Read(next); err != nil {
+		return false, fmt.Errorf("generating new data key: %w", err)
+	}
+	if err := writeWrappedKeyFile(status.KeyFile, next, wrapping); err != nil {
+		return false, fmt.Errorf("installing new data key: %w", err)
+	}
+	cryptKey.Lock()
+	cryptKey.key = next
+	cryptKey.retired = [][]byte{old}
+	cryptKey.Unlock()
+	Log(robot.Audit, "Encryption key rotation started: key %s replaced by %s", encryptionKeyID(old), encryptionKeyID(next))
+	return resumed, nil
+}
+
+// finishKeyRotation drops the retired key once everything is re-encrypted.
+func finishKeyRotation() error {
+	status, err := currentKeyRotationStatus()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(status.KeyFile + retiredKeyFileSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	cryptKey.Lock()
+	cryptKey.retired = nil
+	cryptKey.Unlock()
+	Log(robot.Audit, "Encryption key rotation to key %s complete", status.CurrentKeyID)
+	return nil
+}
+
+// keyRotationReport summarizes one rotation pass.
+type keyRotationReport struct {
+	Resumed          bool
+	Memories         int
+	Rekeyed          int
+	HistoryRekeyed   int
+	SnapshotsRekeyed int
+	SecretsRekeyed   int
+	VariablesFiles   []string
+	Unreadable       []string
+	ForeignSecrets   []string
+	ForeignSnapshots []string
+	CurrentKeyID     string
+	RetiredKeyID     string
+	RetiredRemoved   bool
+}
+
+func (r keyRotationReport) lines() []string {
+	lines := []string{}
+	if r.Resumed {
+		lines = append(lines, fmt.Sprintf("Resumed rotation from key %s to key %s.", r.RetiredKeyID, r.CurrentKeyID))
+	} else {
+		lines = append(lines, fmt.Sprintf("Rotated from key %s to key %s.", r.RetiredKeyID, r.CurrentKeyID))
+	}
+	lines = append(lines, fmt.Sprintf("Memories: %d checked, %d re-encrypted; %d history versions re-encrypted.", r.Memories, r.Rekeyed, r.HistoryRekeyed))
+	lines = append(lines, fmt.Sprintf("Brain snapshots: %d re-sealed.", r.SnapshotsRekeyed))
+	for _, name := range r.ForeignSnapshots {
+		lines = append(lines, "  left unchanged (not sealed with this robot's key): "+name)
+	}
+	lines = append(lines, fmt.Sprintf("Secrets: %d re-encrypted in %d variables files.", r.SecretsRekeyed, len(r.VariablesFiles)))
+	for _, name := range r.ForeignSecrets {
+		lines = append(lines, "  left unchanged (not encrypted with this robot's key): "+name)
+	}
+	for _, name := range r.Unreadable {
+		lines = append(lines, "  FAILED: "+name)
+	}
+	if r.RetiredRemoved {
+		lines = append(lines, fmt.Sprintf("Retired key %s removed; rotation complete.", r.RetiredKeyID))
+	} else {
+		lines = append(lines, fmt.Sprintf("Retired key %s kept because of failures; fix them and run the rotation again to resume.", r.RetiredKeyID))
+	}
+	return lines
+}
+
+// datumRekeyer re-encrypts one memory with the current key.
+type datumRekeyer func(key string) error
+
+// rekeyDatumDirect is used by the CLI, where the brain loop isn't running.
+func rekeyDatumDirect(key string) error {
+	_, data, exists, ret := getDatum(key, false)
+	if ret != robot.Ok {
+		return fmt.Errorf("reading: %s", ret)
+	}
+	if !exists {
+		return nil
+	}
+	if ret := storeDatum(key, data); ret != robot.Ok {
+		return fmt.Errorf("storing: %s", ret)
+	}
+	return nil
+}
+
+// rekeyDatumThroughBrainLoop checks the memory out read-write so a plugin
+// holding it can't have its update overwritten by the rotation.
+func rekeyDatumThroughBrainLoop(key string) error {
+	token, data, exists, ret := checkout(key, true)
+	if ret != robot.Ok {
+		return fmt.Errorf("checking out: %s", ret)
+	}
+	if !exists {
+		checkinDatum(key, token)
+		return nil
+	}
+	if ret := update(key, token, data); ret != robot.Ok {
+		return fmt.Errorf("updating: %s", ret)
+	}
+	return nil
+}
+
+// datumNeedsRekey reports whether a memory isn't yet on the current key,
+// using cache key IDs when present and a trial decryption otherwise.
+func datumNeedsRekey(brain robot.SimpleBrain, key, currentID string) (bool, error) {
+	if cb, ok := brain.(*cachedBrain); ok {
+		meta, exists, err := cb.readMeta(key)
+		if err != nil || !exists || meta.Deleted {
+			return false, err
+		}
+		if meta.KeyID != "" {
+			return meta.KeyID != currentID, nil
+		}
+	}
+	raw, exists, err := brain.Retrieve(key)
+	if err != nil || !exists {
+		return false, err
+	}
+	if _, keyID, err := decryptWithKeyring(*raw); err != nil {
+		return false, fmt.Errorf("not readable with the current or retired key")
+	} else {
+		return keyID != currentID, nil
+	}
+}
+
+// rotateEncryptionKey runs or resumes a full rotation.
+func rotateEncryptionKey(rekey datumRekeyer) (keyRotationReport, error) {
+	var report keyRotationReport
+	brain := interfaces.brain
+	if brain == nil {
+		return report, fmt.Errorf("no brain configured")
+	}
+	resumed, err := beginKeyRotation()
+	if err != nil {
+		return report, err
+	}
+	status, err := currentKeyRotationStatus()
+	if err != nil {
+		return report, err
+	}
+	report.Resumed = resumed
+	report.CurrentKeyID = status.CurrentKeyID
+	report.RetiredKeyID = status.RetiredKeyID
+
+	keys, err := brain.List()
+	if err != nil {
+		return report, fmt.Errorf("listing memories: %w", err)
+	}
+	for _, key := range keys {
+		if key == botEncryptionKey {
+			// Legacy v1 key, wrapped with the configured EncryptionKey
+			continue
+		}
+		report.Memories++
+		needs, err := datumNeedsRekey(brain, key, status.CurrentKeyID)
+		if err == nil && needs {
+			err = rekey(key)
+			if err == nil {
+				report.Rekeyed++
+			}
+		}
+		if err != nil {
+			report.Unreadable = append(report.Unreadable, fmt.Sprintf("memory %s: %v", key, err))
+		}
+	}
+	if cb, ok := brain.(*cachedBrain); ok {
+		count, failures := cb.rekeyHistory(status.CurrentKeyID)
+		report.HistoryRekeyed = count
+		report.Unreadable = append(report.Unreadable, failures...)
+		count, foreign, failures := rekeyBrainSnapshots(cb.cfg.SnapshotDirectory, status.CurrentKeyID)
+		report.SnapshotsRekeyed = count
+		report.ForeignSnapshots = foreign
+		report.Unreadable = append(report.Unreadable, failures...)
+	}
+	secrets, files, foreign, err := rekeyVariableSecrets(filepath.Join(configPath, "conf", "variables"), status.CurrentKeyID)
+	report.SecretsRekeyed = secrets
+	report.VariablesFiles = files
+	report.ForeignSecrets = foreign
+	if err != nil {
+		report.Unreadable = append(report.Unreadable, fmt.Sprintf("variables: %v", err))
+	}
+	if len(report.Unreadable) == 0 {
+		if err := finishKeyRotation(); err != nil {
+			return report, fmt.Errorf("removing retired key: %w", err)
+		}
+		report.RetiredRemoved = true
+	}
+	return report, nil
+}
+
+// rekeyHistory re-encrypts retained key history blobs; history isn't synced
+// so it is rewritten in place rather than through Store.
+func (b *cachedBrain) rekeyHistory(currentID string) (int, []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var failures []string
+	count := 0
+	dirs, err := os.ReadDir(b.historyDir())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, []string{fmt.Sprintf("history: %v", err)}
+	}
+	cryptKey.RLock()
+	key := cryptKey.key
+	cryptKey.RUnlock()
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(b.historyDir(), dir.Name()))
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("history %s: %v", dir.Name(), err))
+			continue
+		}
+		for _, entry := range entries {
+			if !strings.HasSuffix(entry.Name(), ".json") {
+				continue
+			}
+			metaPath := filepath.Join(b.historyDir(), dir.Name(), entry.Name())
+			meta, err := b.readMetaFile(metaPath)
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("history %s: %v", entry.Name(), err))
+				continue
+			}
+			if meta.Deleted || meta.KeyID == currentID {
+				continue
+			}
+			blobPath := strings.TrimSuffix(metaPath, ".json") + ".blob"
+			payload, err := os.ReadFile(blobPath)
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("history %s@%d: %v", meta.Key, meta.Version, err))
+				continue
+			}
+			plain, keyID, err := decryptWithKeyring(payload)
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("history %s@%d: not readable with the current or retired key", meta.Key, meta.Version))
+				continue
+			}
+			if keyID != currentID {
+				if payload, err = encrypt(plain, key); err != nil {
+					failures = append(failures, fmt.Sprintf("history %s@%d: %v", meta.Key, meta.Version, err))
+					continue
+				}
+				if err := writeAtomicFile(blobPath, payload, 0600); err != nil {
+					failures = append(failures, fmt.Sprintf("history %s@%d: %v", meta.Key, meta.Version, err))
+					continue
+				}
+				count++
+			}
+			meta.Checksum = checksumBytes(payload)
+			meta.KeyID = currentID
+			if err := b.writeMetaFile(metaPath, meta); err != nil {
+				failures = append(failures, fmt.Sprintf("history %s@%d: %v", meta.Key, meta.Version, err))
+			}
+		}
+	}
+	return count, failures
+}
+
+// rekeyBrainSnapshots re-seals the snapshots in dir, and the memory payloads
+// inside them, with the current key so they can still be restored once the
+// retired key is gone. Snapshots neither key opens were taken by another
+// environment and are left alone.
+func rekeyBrainSnapshots(dir, currentID string) (int, []string, []string) {
+	snapshots, err := listBrainSnapshots(dir)
+	if err != nil {
+		return 0, nil, []string{fmt.Sprintf("snapshots: %v", err)}
+	}
+	cryptKey.RLock()
+	key := cryptKey.key
+	cryptKey.RUnlock()
+	var foreign, failures []string
+	count := 0
+	for _, file := range snapshots {
+		name := filepath.Base(file.Path)
+		data, err := os.ReadFile(file.Path)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("snapshot %s: %v", name, err))
+			continue
+		}
+		snap, keyID, err := decodeBrainSnapshotWithKeyring(data)
+		if err != nil {
+			foreign = append(foreign, name)
+			continue
+		}
+		changed := keyID != currentID
+		failed := false
+		for i := range snap.Manifest.Entries {
+			entry := &snap.Manifest.Entries[i]
+			if entry.Meta.Deleted || entry.Meta.Key == botEncryptionKey {
+				continue
+			}
+			plain, payloadKeyID, err := decryptWithKeyring(snap.Payloads[entry.Meta.Key])
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("snapshot %s memory %s: not readable with the current or retired key", name, entry.Meta.Key))
+				failed = true
+				break
+			}
+			if payloadKeyID == currentID {
+				continue
+			}
+			payload, err := encrypt(plain, key)
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("snapshot %s memory %s: %v", name, entry.Meta.Key, err))
+				failed = true
+				break
+			}
+			snap.Payloads[entry.Meta.Key] = payload
+			entry.Size = len(payload)
+			entry.Meta.Checksum = checksumBytes(payload)
+			entry.Meta.KeyID = currentID
+			changed = true
+		}
+		if failed || !changed {
+			continue
+		}
+		sealed, err := encodeBrainSnapshot(snap, key)
+		if err == nil {
+			err = writeAtomicFile(file.Path, sealed, 0600)
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("snapshot %s: %v", name, err))
+			continue
+		}
+		count++
+	}
+	return count, foreign, failures
+}
+
+// rekeyVariableSecrets re-encrypts Secrets in every conf/variables file.
+// Values are replaced textually so comments and layout survive. Secrets that
+// neither key can decrypt belong to another environment's key and are left
+// alone.
+func rekeyVariableSecrets(dir, currentID string) (int, []string, []string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil, nil, nil
+		}
+		return 0, nil, nil, err
+	}
+	cryptKey.RLock()
+	key := cryptKey.key
+	cryptKey.RUnlock()
+	count := 0
+	var files, foreign []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".yaml") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return count, files, foreign, err
+		}
+		var loaded configVariablesFile
+		if err := yaml.Unmarshal(raw, &loaded); err != nil {
+			return count, files, foreign, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		names := make([]string, 0, len(loaded.Secrets))
+		for name := range loaded.Secrets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		updated := raw
+		changed := 0
+		for _, name := range names {
+			value := loaded.Secrets[name]
+			if value == nil || *value == "" {
+				continue
+			}
+			ciphertext, err := base64.StdEncoding.DecodeString(*value)
+			if err != nil {
+				foreign = append(foreign, entry.Name()+":"+name)
+				continue
+			}
+			plain, keyID, err := decryptWithKeyring(ciphertext)
+			if err != nil {
+				foreign = append(foreign, entry.Name()+":"+name)
+				continue
+			}
+			if keyID == currentID {
+				continue
+			}
+			sealed, err := encrypt(plain, key)
+			if err != nil {
+				return count, files, foreign, err
+			}
+			if bytes.Count(updated, []byte(*value)) != 1 {
+				return count, files, foreign, fmt.Errorf("%s: secret %s value is not unique in the file; re-encrypt it by hand", path, name)
+			}
+			updated = bytes.Replace(updated, []byte(*value), []byte(base64.StdEncoding.EncodeToString(sealed)), 1)
+			changed++
+		}
+		if changed == 0 {
+			continue
+		}
+		var check configVariablesFile
+		if err := yaml.Unmarshal(updated, &check); err != nil || len(check.Secrets) != len(loaded.Secrets) {
+			return count, files, foreign, fmt.Errorf("%s: re-encrypted file failed to parse; left unchanged", path)
+		}
+		mode := os.FileMode(0600)
+		if info, err := os.Stat(path); err == nil {
+			mode = info.Mode().Perm()
+		}
+		if err := writeAtomicFile(path, updated, mode); err != nil {
+			return count, files, foreign, err
+		}
+		count += changed
+		files = append(files, entry.Name())
+	}
+	return count, files, foreign, nil
+}
+
+// pendingRekeyCount counts memories not yet on the current key.
+func pendingRekeyCount(brain robot.SimpleBrain, currentID string) (int, int, error) {
+	keys, err := brain.List()
+	if err != nil {
+		return 0, 0, err
+	}
+	pending, unreadable := 0, 0
+	for _, key := range keys {
+		if key == botEncryptionKey {
+			continue
+		}
+		needs, err := datumNeedsRekey(brain, key, currentID)
+		switch {
+		case err != nil:
+			unreadable++
+		case needs:
+			pending++
+		}
+	}
+	return pending, unreadable, nil
+}
+
+func keyRotationStatusLines(status keyRotationStatus, pending, unreadable int) []string {
+	lines := []string{
+		fmt.Sprintf("Key file: %s", status.KeyFile),
+		fmt.Sprintf("Current key: %s", status.CurrentKeyID),
+	}
+	if status.inProgress() {
+		lines = append(lines, fmt.Sprintf("Rotation from key %s is unfinished; %d memories still use the retired key.", status.RetiredKeyID, pending))
+	} else {
+		lines = append(lines, "No rotation in progress.")
+	}
+	if unreadable > 0 {
+		lines = append(lines, fmt.Sprintf("%d memories can't be decrypted with any known key.", unreadable))
+	}
+	return lines
+}
+
+func cliRotateKey(statusOnly bool) error {
+	initCLIBrainProvider()
+	status, err := currentKeyRotationStatus()
+	if err != nil {
+		shutdownCLIBrainProvider(false)
+		return err
+	}
+	if statusOnly {
+		pending, unreadable, err := pendingRekeyCount(interfaces.brain, status.CurrentKeyID)
+		shutdownCLIBrainProvider(false)
+		if err != nil {
+			return err
+		}
+		fmt.Println(strings.Join(keyRotationStatusLines(status, pending, unreadable), "\n"))
+		return nil
+	}
+	if lock, exists, err := readBrainLockForStartup(); err != nil {
+		shutdownCLIBrainProvider(false)
+		return fmt.Errorf("checking brain instance lock: %w", err)
+	} else if exists && (lock.State == "" || lock.State == brainLockHeld) && !canReclaimHeldBrainLock(lock) {
+		shutdownCLIBrainProvider(false)
+		return fmt.Errorf("brain instance lock is held by %s on %s (pid %d); stop that robot or use the 'rotate encryption key' admin command",
+			lock.RobotName, lock.Hostname, lock.PID)
+	}
+	report, err := rotateEncryptionKey(rekeyDatumDirect)
+	if err != nil {
+		shutdownCLIBrainProvider(false)
+		return err
+	}
+	fmt.Println(strings.Join(report.lines(), "\n"))
+	if err := interfaces.brain.Flush(); err != nil {
+		shutdownCLIBrainProvider(false)
+		return fmt.Errorf("flushing re-encrypted memories: %w; run gopherbot flush-brain", err)
+	}
+	reportLocalCloudOutboxStatus()
+	shutdownCLIBrainProvider(false)
+	if !report.RetiredRemoved {
+		return fmt.Errorf("rotation incomplete")
+	}
+	return nil
+}
+
+func adminKeyRotationStatus(r Robot) {
+	status, err := currentKeyRotationStatus()
+	if err != nil {
+		r.Say("Error: %v", err)
+		return
+	}
+	pending, unreadable, err := pendingRekeyCount(interfaces.brain, status.CurrentKeyID)
+	if err != nil {
+		r.Say("Error: %v", err)
+		return
+	}
+	r.Fixed().Say(strings.Join(keyRotationStatusLines(status, pending, unreadable), "\n"))
+}
+
+func adminRotateKey(r Robot) {
+	r.Say("Ok, rotating the encryption key; this re-encrypts every memory and secret and may take a while.")
+	report, err := rotateEncryptionKey(rekeyDatumThroughBrainLoop)
+	if err != nil {
+		Log(robot.Error, "Encryption key rotation requested by %s failed: %v", r.User, err)
+		r.Say("Key rotation failed: %v", err)
+		return
+	}
+	Log(robot.Audit, "Encryption key rotation run by %s: %d memories and %d secrets re-encrypted", r.User, report.Rekeyed, report.SecretsRekeyed)
+	r.Fixed().Say(strings.Join(report.lines(), "\n"))
+	if report.SecretsRekeyed > 0 {
+		if err := loadConfig(false); err != nil {
+			r.Say("Re-encrypted secrets were written, but reloading configuration failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const testWrappingKey = "wrapping-key-0123456789abcdefghij"
+
+type keyRotationFixture struct {
+	dir     string
+	keyFile string
+	oldKey  []byte
+	brain   *cachedBrain
+}
+
+func newKeyRotationFixture(t *testing.T) *keyRotationFixture {
+	t.Helper()
+	dir := t.TempDir()
+	origConfigPath := configPath
+	origBrain := interfaces.brain
+	cryptKey.Lock()
+	origKey, origRetired, origInitialized := cryptKey.key, cryptKey.retired, cryptKey.initialized
+	cryptKey.Unlock()
+	t.Cleanup(func() {
+		configPath = origConfigPath
+		interfaces.brain = origBrain
+		cryptKey.Lock()
+		cryptKey.key, cryptKey.retired, cryptKey.initialized = origKey, origRetired, origInitialized
+		cryptKey.Unlock()
+	})
+	t.Setenv(keyEnv, testWrappingKey)
+	t.Setenv("GOPHER_ENVIRONMENT", "")
+	configPath = dir
+
+	f := &keyRotationFixture{
+		dir:     dir,
+		keyFile: filepath.Join(dir, encryptedKeyFile),
+		oldKey:  []byte("old-data-key-0123456789abcdefghi"),
+	}
+	if err := writeWrappedKeyFile(f.keyFile, f.oldKey, []byte(testWrappingKey)[:32]); err != nil {
+		t.Fatalf("writeWrappedKeyFile: %v", err)
+	}
+	cryptKey.Lock()
+	cryptKey.key, cryptKey.retired, cryptKey.initialized = f.oldKey, nil, true
+	cryptKey.Unlock()
+
+	brain, err := newLocalCachedBrain(BrainCacheConfig{Directory: filepath.Join(dir, "cache"), KeepVersions: 3})
+	if err != nil {
+		t.Fatalf("newLocalCachedBrain: %v", err)
+	}
+	t.Cleanup(brain.Shutdown)
+	f.brain = brain
+	interfaces.brain = brain
+	for _, item := range []struct{ key, value string }{
+		{"links:links", `{"v":1}`},
+		{"links:links", `{"v":2}`},
+		{"lists:lists", `["a"]`},
+	} {
+		data := []byte(item.value)
+		if ret := storeDatum(item.key, &data); ret != robot.Ok {
+			t.Fatalf("storeDatum(%s) = %s", item.key, ret)
+		}
+	}
+	return f
+}
+
+func (f *keyRotationFixture) writeVariables(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(f.dir, "conf", "variables", "common.yaml")
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0640); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func sealForTest(t *testing.T, plain string, key []byte) string {
+	t.Helper()
+	ct, err := encrypt([]byte(plain), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(ct)
+}
+
+func TestRotateEncryptionKeyReencryptsBrainHistoryAndSecrets(t *testing.T) {
+	f := newKeyRotationFixture(t)
+	foreignKey := []byte("another-environments-key-0123456")
+	varsPath := f.writeVariables(t, "# managed by ops\nSecrets:\n  SLACK_TOKEN: \""+sealForTest(t, "xoxb-123", f.oldKey)+
+		"\" # bot token\n  OTHER_ENV: \""+sealForTest(t, "theirs", foreignKey)+"\"\nVariables:\n  TEAM: blue\n")
+
+	report, err := rotateEncryptionKey(rekeyDatumDirect)
+	if err != nil {
+		t.Fatalf("rotateEncryptionKey() error = %v", err)
+	}
+	if !report.RetiredRemoved || report.Rekeyed != 2 || report.SecretsRekeyed != 1 || len(report.Unreadable) != 0 {
+		t.Fatalf("report = %+v", report)
+	}
+	// The original links:links version, plus the pre-rotation copies of both
+	// memories archived when they were rewritten.
+	if report.HistoryRekeyed != 3 {
+		t.Fatalf("HistoryRekeyed = %d, want 3", report.HistoryRekeyed)
+	}
+	if len(report.ForeignSecrets) != 1 || !strings.HasSuffix(report.ForeignSecrets[0], "OTHER_ENV") {
+		t.Fatalf("ForeignSecrets = %v", report.ForeignSecrets)
+	}
+	newKey, err := readWrappedKeyFile(f.keyFile, []byte(testWrappingKey)[:32])
+	if err != nil || bytes.Equal(newKey, f.oldKey) {
+		t.Fatalf("key file holds old key or is unreadable: err=%v", err)
+	}
+	if _, err := os.Stat(f.keyFile + retiredKeyFileSuffix); !os.IsNotExist(err) {
+		t.Fatalf("retired key file still present: %v", err)
+	}
+
+	raw, _, _ := f.brain.Retrieve("links:links")
+	if _, err := decrypt(*raw, f.oldKey); err == nil {
+		t.Fatal("memory is still readable with the old key")
+	}
+	_, data, exists, ret := getDatum("links:links", false)
+	if ret != robot.Ok || !exists || string(*data) != `{"v":2}` {
+		t.Fatalf("getDatum after rotation = %q exists=%v ret=%s", stringValue(data), exists, ret)
+	}
+	meta, _, _ := f.brain.readMeta("links:links")
+	if meta.KeyID != encryptionKeyID(newKey) {
+		t.Fatalf("meta KeyID = %q, want %q", meta.KeyID, encryptionKeyID(newKey))
+	}
+	versions, _ := f.brain.KeyHistory("links:links")
+	old, _, err := decryptedKeyVersion(f.brain, "links:links", versions[len(versions)-1].Meta.Version)
+	if err != nil || string(old) != `{"v":1}` {
+		t.Fatalf("history version after rotation = %q err=%v", old, err)
+	}
+
+	vars, _ := os.ReadFile(varsPath)
+	if !strings.Contains(string(vars), "# managed by ops") || !strings.Contains(string(vars), "# bot token") || !strings.Contains(string(vars), "TEAM: blue") {
+		t.Fatalf("variables file lost formatting:\n%s", vars)
+	}
+	loaded := newConfigVariableSet()
+	if err := mergeConfigVariablesFile(varsPath, loaded); err != nil {
+		t.Fatal(err)
+	}
+	ct, _ := base64.StdEncoding.DecodeString(loaded.Secrets["SLACK_TOKEN"])
+	if plain, err := decrypt(ct, newKey); err != nil || string(plain) != "xoxb-123" {
+		t.Fatalf("SLACK_TOKEN not re-encrypted with new key: %q err=%v", plain, err)
+	}
+	ct, _ = base64.StdEncoding.DecodeString(loaded.Secrets["OTHER_ENV"])
+	if _, err := decrypt(ct, foreignKey); err != nil {
+		t.Fatal("foreign secret was modified")
+	}
+}
+
+func TestBrainSnapshotRestoresAfterKeyRotation(t *testing.T) {
+	f := newKeyRotationFixture(t)
+	snap, err := collectLocalBrainSnapshot(f.brain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := encodeBrainSnapshot(snap, f.oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := f.brain.cfg.SnapshotDirectory
+	snapPath := filepath.Join(dir, brainSnapshotFileName(snap.Manifest.CreatedAt))
+	if err := writeAtomicFile(snapPath, sealed, 0600); err != nil {
+		t.Fatal(err)
+	}
+	foreign, err := encodeBrainSnapshot(snap, []byte("another-environments-key-0123456"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreignPath := filepath.Join(dir, brainSnapshotFileName(snap.Manifest.CreatedAt.Add(-time.Hour)))
+	if err := writeAtomicFile(foreignPath, foreign, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := rotateEncryptionKey(rekeyDatumDirect)
+	if err != nil {
+		t.Fatalf("rotateEncryptionKey() error = %v", err)
+	}
+	if !report.RetiredRemoved || report.SnapshotsRekeyed != 1 || len(report.Unreadable) != 0 {
+		t.Fatalf("report = %+v", report)
+	}
+	if len(report.ForeignSnapshots) != 1 || report.ForeignSnapshots[0] != filepath.Base(foreignPath) {
+		t.Fatalf("ForeignSnapshots = %v", report.ForeignSnapshots)
+	}
+	if data, _ := os.ReadFile(foreignPath); !bytes.Equal(data, foreign) {
+		t.Fatal("foreign snapshot was modified")
+	}
+	newKey, err := readWrappedKeyFile(f.keyFile, []byte(testWrappingKey)[:32])
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(snapPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := decodeBrainSnapshot(data, newKey)
+	if err != nil {
+		t.Fatalf("decodeBrainSnapshot() with the new key error = %v", err)
+	}
+	for _, entry := range restored.Manifest.Entries {
+		if entry.Meta.KeyID != encryptionKeyID(newKey) {
+			t.Fatalf("snapshot entry %s KeyID = %q", entry.Meta.Key, entry.Meta.KeyID)
+		}
+	}
+
+	cfg := BrainCacheConfig{Directory: filepath.Join(f.dir, "restored")}
+	if err := rebuildBrainCacheFromSnapshot(cfg, planBrainSnapshotRestore(restored, nil)); err != nil {
+		t.Fatalf("rebuildBrainCacheFromSnapshot() error = %v", err)
+	}
+	brain, err := newLocalCachedBrain(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(brain.Shutdown)
+	interfaces.brain = brain
+	_, value, exists, ret := getDatum("links:links", false)
+	if ret != robot.Ok || !exists || string(*value) != `{"v":2}` {
+		t.Fatalf("getDatum after restore = %q exists=%v ret=%s", stringValue(value), exists, ret)
+	}
+}
+
+func TestInterruptedKeyRotationStaysReadableAndResumes(t *testing.T) {
+	f := newKeyRotationFixture(t)
+	if resumed, err := beginKeyRotation(); err != nil || resumed {
+		t.Fatalf("beginKeyRotation() = %v, %v", resumed, err)
+	}
+	// Rotate one memory, then "crash" and restart as initCrypt would.
+	if err := rekeyDatumDirect("lists:lists"); err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := readWrappedKeyFile(f.keyFile, []byte(testWrappingKey)[:32])
+	if err != nil {
+		t.Fatal(err)
+	}
+	cryptKey.Lock()
+	cryptKey.key, cryptKey.retired = newKey, nil
+	cryptKey.Unlock()
+	loadRetiredEncryptionKey(f.keyFile, []byte(testWrappingKey)[:32])
+
+	for key, want := range map[string]string{"links:links": `{"v":2}`, "lists:lists": `["a"]`} {
+		_, data, exists, ret := getDatum(key, false)
+		if ret != robot.Ok || !exists || string(*data) != want {
+			t.Fatalf("mixed-key getDatum(%s) = %q ret=%s", key, stringValue(data), ret)
+		}
+	}
+	status, err := currentKeyRotationStatus()
+	if err != nil || !status.inProgress() || status.RetiredKeyID != encryptionKeyID(f.oldKey) {
+		t.Fatalf("status = %+v err=%v", status, err)
+	}
+	if pending, unreadable, _ := pendingRekeyCount(f.brain, status.CurrentKeyID); pending != 1 || unreadable != 0 {
+		t.Fatalf("pendingRekeyCount() = %d, %d; want 1 pending", pending, unreadable)
+	}
+
+	report, err := rotateEncryptionKey(rekeyDatumDirect)
+	if err != nil || !report.Resumed || !report.RetiredRemoved || report.Rekeyed != 1 {
+		t.Fatalf("resumed rotation report = %+v err=%v", report, err)
+	}
+	if after, _ := readWrappedKeyFile(f.keyFile, []byte(testWrappingKey)[:32]); !bytes.Equal(after, newKey) {
+		t.Fatal("resuming a rotation must not install yet another key")
+	}
+}
+
+func TestKeyRotationResumesWhenCrashedBeforeInstallingNewKey(t *testing.T) {
+	f := newKeyRotationFixture(t)
+	if err := writeWrappedKeyFile(f.keyFile+retiredKeyFileSuffix, f.oldKey, []byte(testWrappingKey)[:32]); err != nil {
+		t.Fatal(err)
+	}
+	report, err := rotateEncryptionKey(rekeyDatumDirect)
+	if err != nil || !report.Resumed || !report.RetiredRemoved || report.Rekeyed != 2 {
+		t.Fatalf("report = %+v err=%v", report, err)
+	}
+	if current, _ := readWrappedKeyFile(f.keyFile, []byte(testWrappingKey)[:32]); bytes.Equal(current, f.oldKey) {
+		t.Fatal("new key was not installed")
+	}
+}
+
+func TestKeyRotationKeepsRetiredKeyWhenMemoryIsUnreadable(t *testing.T) {
+	f := newKeyRotationFixture(t)
+	garbage := []byte("not encrypted with any key at all")
+	if err := f.brain.Store("broken:datum", &garbage); err != nil {
+		t.Fatal(err)
+	}
+	report, err := rotateEncryptionKey(rekeyDatumDirect)
+	if err != nil {
+		t.Fatalf("rotateEncryptionKey() error = %v", err)
+	}
+	if report.RetiredRemoved || len(report.Unreadable) != 1 || !strings.Contains(report.Unreadable[0], "broken:datum") {
+		t.Fatalf("report = %+v, want retired key kept and broken:datum reported", report)
+	}
+	if _, err := os.Stat(f.keyFile + retiredKeyFileSuffix); err != nil {
+		t.Fatalf("retired key file removed despite failures: %v", err)
+	}
+}
@@ -60,6 +60,13 @@ func (l *pipelineLiveLogger) Line(line string) {
 	}
 }
 
+// SetTask passes the running task name to providers that record it.
+func (l *pipelineLiveLogger) SetTask(name string) {
+	if tl, ok := l.base.(robot.TaskHistoryLogger); ok {
+		tl.SetTask(name)
+	}
+}
+
 func (l *pipelineLiveLogger) Close() {
 	l.mu.Lock()
 	defer l.mu.Unlock()
@@ -938,6 +938,25 @@ func handlePipelineRPCRobotCall(paramsRaw json.RawMessage, base robot.Robot) (ma
 			return nil, err
 		}
 		return map[string]interface{}{"ret_val": int(r.UpdateDatum(key, lockToken, datum))}, nil
+	case "UpdateDatumWithTTL":
+		key, err := pipelineRPCArgString(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		lockToken, err := pipelineRPCArgString(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		datum, err := pipelineRPCArgAny(args, 2)
+		if err != nil {
+			return nil, err
+		}
+		seconds, err := pipelineRPCArgFloat(args, 3)
+		if err != nil {
+			return nil, err
+		}
+		ttl := time.Duration(seconds * float64(time.Second))
+		return map[string]interface{}{"ret_val": int(r.UpdateDatumWithTTL(key, lockToken, datum, ttl))}, nil
 	case "DeleteDatum":
 		key, err := pipelineRPCArgString(args, 0)
 		if err != nil {
@@ -1554,6 +1573,14 @@ func (c *pipelineRPCInterpreterRobotClient) UpdateDatum(key, locktoken string, d
 	return robot.RetVal(pipelineRPCMapInt(res, "ret_val"))
 }
 
+func (c *pipelineRPCInterpreterRobotClient) UpdateDatumWithTTL(key, locktoken string, datum interface{}, ttl time.Duration) (ret robot.RetVal) {
+	res, err := c.call("UpdateDatumWithTTL", key, locktoken, datum, ttl.Seconds())
+	if err != nil {
+		return robot.Failed
+	}
+	return robot.RetVal(pipelineRPCMapInt(res, "ret_val"))
+}
+
 func (c *pipelineRPCInterpreterRobotClient) DeleteDatum(key string) (ret robot.RetVal) {
 	res, err := c.call("DeleteDatum", key)
 	if err != nil {
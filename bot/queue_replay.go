@@ -0,0 +1,317 @@
+package bot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+// queueDedupeKey holds the persisted dedupe window, so redelivered triggers
+// are still discarded after a restart.
+const queueDedupeKey = "bot:_queue-dedupe"
+
+// QueueTriggersConfig is the root QueueTriggers setting, engine-side replay
+// protection shared by all queue providers.
+type QueueTriggersConfig struct {
+	DedupeSeconds int `yaml:"DedupeSeconds"` // how long a <uuid>:<timestamp> pair is remembered; default 140
+	MaxAgeSeconds int `yaml:"MaxAgeSeconds"` // reject timestamps further than this from the current time; 0 disables, except for signed bodies
+}
+
+type queueTriggerSettings struct {
+	dedupe time.Duration
+	maxAge time.Duration
+}
+
+func currentQueueTriggerSettings() queueTriggerSettings {
+	currentCfg.RLock()
+	cfg := currentCfg.queueTriggers
+	currentCfg.RUnlock()
+	settings := queueTriggerSettings{
+		dedupe: time.Duration(cfg.DedupeSeconds) * time.Second,
+		maxAge: time.Duration(cfg.MaxAgeSeconds) * time.Second,
+	}
+	if settings.dedupe <= 0 {
+		settings.dedupe = queueDedupeRetention
+	}
+	if settings.maxAge < 0 {
+		settings.maxAge = 0
+	}
+	return settings
+}
+
+// maxAgeFor returns the timestamp age limit for a body. Signed bodies always
+// have one, defaulting to the dedupe window, so a captured body can't be
+// replayed once its dedupe entry expires.
+func (s queueTriggerSettings) maxAgeFor(signed bool) time.Duration {
+	if signed && s.maxAge == 0 {
+		return s.dedupe
+	}
+	return s.maxAge
+}
+
+// queueTimestampTime interprets a queue timestamp as Unix time with ten
+// digits of seconds, so a 13-digit value is milliseconds and the 14-digit
+// value from queue-job.sh is units of 100µs.
+func queueTimestampTime(timestamp string) (time.Time, bool) {
+	digits := len(timestamp)
+	if digits < queueTimestampMinLen || digits > queueTimestampMaxLen {
+		return time.Time{}, false
+	}
+	v, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	scale := int64(1)
+	for i := digits; i < 19; i++ {
+		scale *= 10
+	}
+	return time.Unix(0, v*scale), true
+}
+
+// checkQueueTimestampAge rejects timestamps more than MaxAgeSeconds from now
+// in either direction, once MaxAgeSeconds is configured; signed bodies are
+// held to the dedupe window when it isn't.
+func checkQueueTimestampAge(timestamp string, now time.Time, signed bool) error {
+	maxAge := currentQueueTriggerSettings().maxAgeFor(signed)
+	if maxAge == 0 {
+		return nil
+	}
+	ts, ok := queueTimestampTime(timestamp)
+	if !ok {
+		return fmt.Errorf("queue timestamp is not a valid time")
+	}
+	age := now.Sub(ts)
+	if age > maxAge {
+		return fmt.Errorf("queue timestamp is %s old, more than the %s limit", age.Round(time.Second), maxAge)
+	}
+	if -age > maxAge {
+		return fmt.Errorf("queue timestamp is %s in the future, more than the %s limit", (-age).Round(time.Second), maxAge)
+	}
+	return nil
+}
+
+// triggerDedupeWindow maps hashed trigger keys to when they may be
+// forgotten. record only marks the window dirty; the brain's tick saves it
+// with save, so a burst of triggers costs one brain write rather than one
+// each, and no trigger waits on the brain.
+type triggerDedupeWindow struct {
+	sync.Mutex
+	key    string
+	seen   map[string]time.Time
+	loaded bool
+	dirty  atomic.Bool
+}
+
+func newTriggerDedupeWindow(key string) *triggerDedupeWindow {
+	return &triggerDedupeWindow{key: key, seen: map[string]time.Time{}}
+}
+
+// record reports whether any of keys was already seen, and otherwise
+// remembers them all until expires. Keys are stored as SHA-256 hashes.
+func (d *triggerDedupeWindow) record(keys []string, now, expires time.Time) bool {
+	hashed := make([]string, 0, len(keys))
+	for _, key := range keys {
+		sum := sha256.Sum256([]byte(key))
+		hashed = append(hashed, hex.EncodeToString(sum[:]))
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	d.load()
+	for seenKey, expiresAt := range d.seen {
+		if !now.Before(expiresAt) {
+			delete(d.seen, seenKey)
+		}
+	}
+	for _, key := range hashed {
+		if expiresAt, ok := d.seen[key]; ok && now.Before(expiresAt) {
+			return true
+		}
+	}
+	for _, key := range hashed {
+		d.seen[key] = expires
+	}
+	d.dirty.Store(true)
+	return false
+}
+
+// load merges the persisted window into memory the first time the brain is
+// available; the caller holds the lock.
+func (d *triggerDedupeWindow) load() {
+	if d.loaded || interfaces.brain == nil {
+		return
+	}
+	cryptKey.RLock()
+	initialized := cryptKey.initialized
+	cryptKey.RUnlock()
+	if !initialized {
+		return
+	}
+	_, data, exists, ret := getDatum(d.key, false)
+	if ret != robot.Ok {
+		return
+	}
+	d.loaded = true
+	if !exists {
+		return
+	}
+	var stored map[string]time.Time
+	if err := json.Unmarshal(*data, &stored); err != nil {
+		Log(robot.Error, "Discarding unreadable trigger dedupe window '%s': %v", d.key, err)
+		return
+	}
+	for key, expiresAt := range stored {
+		if expiresAt.After(d.seen[key]) {
+			d.seen[key] = expiresAt
+		}
+	}
+	Log(robot.Debug, "Restored %d trigger dedupe entries from '%s'", len(stored), d.key)
+}
+
+// save writes the window to the brain if it changed since the last save.
+// The window is copied under the lock and written after releasing it.
+func (d *triggerDedupeWindow) save() {
+	if !d.dirty.Load() {
+		return
+	}
+	d.Lock()
+	d.load()
+	if !d.loaded {
+		d.Unlock()
+		return
+	}
+	d.dirty.Store(false)
+	snapshot := make(map[string]time.Time, len(d.seen))
+	for key, expiresAt := range d.seen {
+		snapshot[key] = expiresAt
+	}
+	d.Unlock()
+
+	var stored json.RawMessage
+	tok, _, ret := checkoutDatum(d.key, &stored, true)
+	if ret != robot.Ok {
+		Log(robot.Error, "Saving trigger dedupe window '%s': error '%s' getting datum", d.key, ret)
+		d.dirty.Store(true)
+		return
+	}
+	if ret := updateDatum(d.key, tok, snapshot); ret != robot.Ok {
+		Log(robot.Error, "Error '%s' updating trigger dedupe window '%s'", ret, d.key)
+		d.dirty.Store(true)
+	}
+}
+
+// triggerDedupeDirty reports whether a trigger dedupe window needs saving;
+// it doesn't lock, so the brain loop can call it.
+func triggerDedupeDirty() bool {
+	return queueDedupe.dirty.Load() || webhookDedupe.dirty.Load()
+}
+
+// saveTriggerDedupe saves every trigger dedupe window that has changed.
+func saveTriggerDedupe() {
+	queueDedupe.save()
+	webhookDedupe.save()
+}
+
+type queueTriggerOutcome int
+
+const (
+	queueTriggerAccepted queueTriggerOutcome = iota
+	queueTriggerDuplicate
+	queueTriggerRejected
+	queueTriggerRetried
+)
+
+// queueTriggerCounts are per-provider totals since the robot started.
+type queueTriggerCounts struct {
+	accepted  uint64
+	duplicate uint64
+	rejected  uint64
+	retried   uint64
+}
+
+var queueTriggerCounters = struct {
+	sync.Mutex
+	byProvider map[string]*queueTriggerCounts
+}{
+	byProvider: map[string]*queueTriggerCounts{},
+}
+
+func countQueueTrigger(provider string, outcome queueTriggerOutcome) {
+	name := normalizeProviderName(provider)
+	queueTriggerCounters.Lock()
+	defer queueTriggerCounters.Unlock()
+	c, ok := queueTriggerCounters.byProvider[name]
+	if !ok {
+		c = &queueTriggerCounts{}
+		queueTriggerCounters.byProvider[name] = c
+	}
+	switch outcome {
+	case queueTriggerAccepted:
+		c.accepted++
+	case queueTriggerDuplicate:
+		c.duplicate++
+	case queueTriggerRejected:
+		c.rejected++
+	case queueTriggerRetried:
+		c.retried++
+	}
+}
+
+type queueProviderStatus struct {
+	provider string
+	state    string
+	err      string
+	counts   queueTriggerCounts
+}
+
+// listQueueProviderStatus reports configured providers, plus any that have
+// counted triggers since being removed from the configuration.
+func listQueueProviderStatus() []queueProviderStatus {
+	names := map[string]bool{}
+	for _, provider := range configuredQueueProviders() {
+		names[normalizeProviderName(provider)] = true
+	}
+	queueTriggerCounters.Lock()
+	counts := make(map[string]queueTriggerCounts, len(queueTriggerCounters.byProvider))
+	for name, c := range queueTriggerCounters.byProvider {
+		counts[name] = *c
+		names[name] = true
+	}
+	queueTriggerCounters.Unlock()
+	keys := make([]string, 0, len(names))
+	for name := range names {
+		if name != "" {
+			keys = append(keys, name)
+		}
+	}
+	sort.Strings(keys)
+
+	runtimeQueueProviders.RLock()
+	defer runtimeQueueProviders.RUnlock()
+	out := make([]queueProviderStatus, 0, len(keys))
+	for _, name := range keys {
+		status := queueProviderStatus{
+			provider: name,
+			state:    "stopped",
+			counts:   counts[name],
+		}
+		if mq, ok := runtimeQueueProviders.runtimes[name]; ok && mq != nil {
+			if mq.running {
+				status.state = "running"
+			} else if mq.lastError != "" {
+				status.state = "failed"
+				status.err = mq.lastError
+			}
+		}
+		out = append(out, status)
+	}
+	return out
+}
@@ -24,6 +24,8 @@ type parsedQueueBody struct {
 	jobUUID   string
 	timestamp string
 	args      []string
+	signed    []byte // the body without its signature suffix
+	signature []byte // hex HMAC from the suffix, nil when unsigned
 }
 
 type queueHandler struct {
@@ -49,12 +51,9 @@ var runtimeQueueProviders = struct {
 	runtimes: map[string]*managedQueueProvider{},
 }
 
-var queueDedupe = struct {
-	sync.Mutex
-	seen map[string]time.Time
-}{
-	seen: map[string]time.Time{},
-}
+// queueDedupe remembers hashed "<uuid>:<timestamp>" keys for the dedupe
+// window; see queue_replay.go.
+var queueDedupe = newTriggerDedupeWindow(queueDedupeKey)
 
 func (h queueHandler) GetQueueConfig(v interface{}) error {
 	cfg := getQueueConfigFor(h.provider)
@@ -269,6 +268,8 @@ func reconcileQueueProviderRuntimes(providers []string) {
 	}
 }
 
+// parseQueueBody parses a body as-is; a trailing signature is only split off
+// by parseSignedQueueBody, once the job is known to require one.
 func parseQueueBody(body []byte) (parsedQueueBody, error) {
 	if len(body) < queueUUIDPrefixLen {
 		return parsedQueueBody{}, fmt.Errorf("queue body too short: %d byte(s)", len(body))
@@ -317,34 +318,43 @@ func parseQueueBody(body []byte) (parsedQueueBody, error) {
 	}, nil
 }
 
-func recordQueueDedupe(jobUUID, timestamp string, now time.Time) bool {
-	key := jobUUID + ":" + timestamp
-	queueDedupe.Lock()
-	defer queueDedupe.Unlock()
-	for seenKey, expiresAt := range queueDedupe.seen {
-		if !now.Before(expiresAt) {
-			delete(queueDedupe.seen, seenKey)
+// recordQueueDedupe reports whether the uuid/timestamp pair was already seen,
+// recording it if not. Pairs are kept for the dedupe window, and when the
+// body has a maximum age, until the timestamp itself goes stale, so a replay
+// is either a duplicate or too old.
+func recordQueueDedupe(jobUUID, timestamp string, now time.Time, signed bool) bool {
+	settings := currentQueueTriggerSettings()
+	expires := now.Add(settings.dedupe)
+	if maxAge := settings.maxAgeFor(signed); maxAge > 0 {
+		if ts, ok := queueTimestampTime(timestamp); ok && ts.Add(maxAge).After(expires) {
+			expires = ts.Add(maxAge)
 		}
 	}
-	if expiresAt, ok := queueDedupe.seen[key]; ok && now.Before(expiresAt) {
-		return true
-	}
-	queueDedupe.seen[key] = now.Add(queueDedupeRetention)
-	return false
+	return queueDedupe.record([]string{jobUUID + ":" + timestamp}, now, expires)
 }
 
 func triggerJobFromQueue(provider string, msg robot.QueueMessage) robot.QueueDisposition {
+	disposition, outcome := startQueueTrigger(provider, msg)
+	countQueueTrigger(provider, outcome)
+	return disposition
+}
+
+func startQueueTrigger(provider string, msg robot.QueueMessage) (robot.QueueDisposition, queueTriggerOutcome) {
 	state.RLock()
 	if state.shuttingDown {
 		state.RUnlock()
-		return robot.QueueRetry
+		return robot.QueueRetry, queueTriggerRetried
 	}
 	state.RUnlock()
 
 	parsed, err := parseQueueBody(msg.Body)
 	if err != nil {
 		Log(robot.Error, "Queue provider '%s' message '%s' rejected: %v (body length %d)", provider, msg.ID, err, len(msg.Body))
-		return robot.QueueAck
+		return robot.QueueReject, queueTriggerRejected
+	}
+	if err := checkQueueTimestampAge(parsed.timestamp, time.Now(), false); err != nil {
+		Log(robot.Error, "Queue provider '%s' message '%s' rejected: %v", provider, msg.ID, err)
+		return robot.QueueReject, queueTriggerRejected
 	}
 
 	currentCfg.RLock()
@@ -359,33 +369,50 @@ func triggerJobFromQueue(provider string, msg robot.QueueMessage) robot.QueueDis
 
 	if taskItem == nil {
 		Log(robot.Error, "Queue provider '%s' message '%s' had no matching job UUID (body length %d)", provider, msg.ID, len(msg.Body))
-		return robot.QueueAck
+		return robot.QueueReject, queueTriggerRejected
 	}
 	task, _, job := getTask(taskItem)
-	if recordQueueDedupe(parsed.jobUUID, parsed.timestamp, time.Now()) {
+	// Check the signature before recording the dedupe key, so forged bodies
+	// can't block a genuine trigger with the same timestamp.
+	signed := job != nil && job.UUIDTriggerKey != ""
+	if signed {
+		if parsed, err = parseSignedQueueBody(msg.Body); err != nil {
+			Log(robot.Error, "Queue provider '%s' message '%s' rejected for job '%s': %v", provider, msg.ID, task.name, err)
+			return robot.QueueReject, queueTriggerRejected
+		}
+		if err := verifyQueueSignature(job.UUIDTriggerKey, parsed); err != nil {
+			Log(robot.Error, "Queue provider '%s' message '%s' rejected for job '%s': %v", provider, msg.ID, task.name, err)
+			return robot.QueueReject, queueTriggerRejected
+		}
+		if err := checkQueueTimestampAge(parsed.timestamp, time.Now(), true); err != nil {
+			Log(robot.Error, "Queue provider '%s' message '%s' rejected for job '%s': %v", provider, msg.ID, task.name, err)
+			return robot.QueueReject, queueTriggerRejected
+		}
+	}
+	if recordQueueDedupe(parsed.jobUUID, parsed.timestamp, time.Now(), signed) {
 		jobName := "<unknown>"
 		if task != nil {
 			jobName = task.name
 		}
 		Log(robot.Info, "Queue provider '%s' message '%s' discarded duplicate queue trigger for job '%s'", provider, msg.ID, jobName)
-		return robot.QueueAck
+		return robot.QueueAck, queueTriggerDuplicate
 	}
 	if job == nil {
 		Log(robot.Error, "Queue provider '%s' message '%s' matched non-job task '%s'", provider, msg.ID, task.name)
-		return robot.QueueAck
+		return robot.QueueReject, queueTriggerRejected
 	}
 	if task.Disabled {
 		Log(robot.Error, "Queue provider '%s' message '%s' matched disabled job '%s'", provider, msg.ID, task.name)
-		return robot.QueueAck
+		return robot.QueueReject, queueTriggerRejected
 	}
 	if len(parsed.args) < len(job.Arguments) {
 		Log(robot.Error, "Queue provider '%s' message '%s' supplied too few arguments for job '%s': %d required but %d given", provider, msg.ID, task.name, len(job.Arguments), len(parsed.args))
-		return robot.QueueAck
+		return robot.QueueReject, queueTriggerRejected
 	}
 	for i, jobarg := range job.Arguments {
 		if !jobarg.re.MatchString(parsed.args[i]) {
 			Log(robot.Error, "Queue provider '%s' message '%s' argument %d for job '%s' did not match configured argument pattern", provider, msg.ID, i+1, task.name)
-			return robot.QueueAck
+			return robot.QueueReject, queueTriggerRejected
 		}
 	}
 
@@ -402,5 +429,5 @@ func triggerJobFromQueue(provider string, msg robot.QueueMessage) robot.QueueDis
 		queueMessageID: msg.ID,
 	}
 	go w.startPipeline(nil, taskItem, queuedJob, "run", parsed.args...)
-	return robot.QueueAck
+	return robot.QueueAck, queueTriggerAccepted
 }
@@ -5,6 +5,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
 )
 
 func TestParseQueueBodyNoArgs(t *testing.T) {
@@ -70,13 +72,13 @@ func TestQueueDedupeRecordsUUIDTimestampPairs(t *testing.T) {
 	queueDedupe.Unlock()
 
 	now := time.Unix(100, 0)
-	if recordQueueDedupe("1104df4c-feeb-43ab-8c85-83663288cea9", "17642656976077", now) {
+	if recordQueueDedupe("1104df4c-feeb-43ab-8c85-83663288cea9", "17642656976077", now, false) {
 		t.Fatal("first UUID/timestamp pair was reported as duplicate")
 	}
-	if !recordQueueDedupe("1104df4c-feeb-43ab-8c85-83663288cea9", "17642656976077", now.Add(time.Second)) {
+	if !recordQueueDedupe("1104df4c-feeb-43ab-8c85-83663288cea9", "17642656976077", now.Add(time.Second), false) {
 		t.Fatal("repeated UUID/timestamp pair was not reported as duplicate")
 	}
-	if recordQueueDedupe("1104df4c-feeb-43ab-8c85-83663288cea9", "17642656976078", now.Add(2*time.Second)) {
+	if recordQueueDedupe("1104df4c-feeb-43ab-8c85-83663288cea9", "17642656976078", now.Add(2*time.Second), false) {
 		t.Fatal("different timestamp was reported as duplicate")
 	}
 }
@@ -87,10 +89,255 @@ func TestQueueDedupeExpiresAfterRetention(t *testing.T) {
 	queueDedupe.Unlock()
 
 	now := time.Unix(200, 0)
-	if recordQueueDedupe("1104df4c-feeb-43ab-8c85-83663288cea9", "17642656976077", now) {
+	if recordQueueDedupe("1104df4c-feeb-43ab-8c85-83663288cea9", "17642656976077", now, false) {
 		t.Fatal("first UUID/timestamp pair was reported as duplicate")
 	}
-	if recordQueueDedupe("1104df4c-feeb-43ab-8c85-83663288cea9", "17642656976077", now.Add(queueDedupeRetention)) {
+	if recordQueueDedupe("1104df4c-feeb-43ab-8c85-83663288cea9", "17642656976077", now.Add(queueDedupeRetention), false) {
 		t.Fatal("expired UUID/timestamp pair was reported as duplicate")
 	}
 }
+
+func setQueueTriggersForTest(t *testing.T, cfg QueueTriggersConfig) {
+	t.Helper()
+	currentCfg.Lock()
+	orig := currentCfg.queueTriggers
+	currentCfg.queueTriggers = cfg
+	currentCfg.Unlock()
+	resetTriggerDedupeWindows()
+	t.Cleanup(func() {
+		currentCfg.Lock()
+		currentCfg.queueTriggers = orig
+		currentCfg.Unlock()
+		resetTriggerDedupeWindows()
+	})
+}
+
+func resetTriggerDedupeWindows() {
+	for _, d := range []*triggerDedupeWindow{queueDedupe, webhookDedupe} {
+		d.Lock()
+		d.seen = map[string]time.Time{}
+		d.loaded = false
+		d.dirty.Store(false)
+		d.Unlock()
+	}
+}
+
+func TestQueueTimestampMaxAge(t *testing.T) {
+	ts, ok := queueTimestampTime("17642656976077")
+	if !ok || !ts.Equal(time.Unix(1764265697, 607700000)) {
+		t.Fatalf("queueTimestampTime(14 digits) = %v, %v", ts, ok)
+	}
+	if ms, _ := queueTimestampTime("1764265697607"); !ms.Equal(time.Unix(1764265697, 607000000)) {
+		t.Fatalf("queueTimestampTime(13 digits) = %v", ms)
+	}
+
+	setQueueTriggersForTest(t, QueueTriggersConfig{})
+	if err := checkQueueTimestampAge("17642656976077", ts.Add(24*time.Hour), false); err != nil {
+		t.Fatalf("age check without MaxAgeSeconds returned %v", err)
+	}
+	setQueueTriggersForTest(t, QueueTriggersConfig{MaxAgeSeconds: 300})
+	if err := checkQueueTimestampAge("17642656976077", ts.Add(time.Minute), false); err != nil {
+		t.Fatalf("recent timestamp rejected: %v", err)
+	}
+	if err := checkQueueTimestampAge("17642656976077", ts.Add(10*time.Minute), false); err == nil || !strings.Contains(err.Error(), "old") {
+		t.Fatalf("stale timestamp error = %v", err)
+	}
+	if err := checkQueueTimestampAge("17642656976077", ts.Add(-10*time.Minute), false); err == nil || !strings.Contains(err.Error(), "future") {
+		t.Fatalf("future timestamp error = %v", err)
+	}
+}
+
+func TestQueueDedupeCoversMaxAge(t *testing.T) {
+	setQueueTriggersForTest(t, QueueTriggersConfig{MaxAgeSeconds: 600})
+	ts, _ := queueTimestampTime("17642656976077")
+	if recordQueueDedupe("1104df4c-feeb-43ab-8c85-83663288cea9", "17642656976077", ts, false) {
+		t.Fatal("first UUID/timestamp pair was reported as duplicate")
+	}
+	// Past the dedupe window, but the timestamp would still pass the age check.
+	if !recordQueueDedupe("1104df4c-feeb-43ab-8c85-83663288cea9", "17642656976077", ts.Add(5*time.Minute), false) {
+		t.Fatal("replay within MaxAgeSeconds was not reported as duplicate")
+	}
+}
+
+func TestQueueDedupePersistsInBrain(t *testing.T) {
+	newKeyRotationFixture(t)
+	setQueueTriggersForTest(t, QueueTriggersConfig{})
+	now := time.Now()
+	if recordQueueDedupe("1104df4c-feeb-43ab-8c85-83663288cea9", "17642656976077", now, false) {
+		t.Fatal("first UUID/timestamp pair was reported as duplicate")
+	}
+	// Recording doesn't write to the brain; the brain loop saves the window.
+	if _, _, exists, _ := getDatum(queueDedupeKey, false); exists || !triggerDedupeDirty() {
+		t.Fatalf("dedupe window written synchronously (exists=%v) or not marked dirty", exists)
+	}
+	done := make(chan struct{})
+	go func() {
+		runBrain()
+		close(done)
+	}()
+	t.Cleanup(func() {
+		brainQuit()
+		<-done
+	})
+	saveTriggerDedupe()
+	_, data, exists, _ := getDatum(queueDedupeKey, false)
+	if !exists || strings.Contains(string(*data), "1104df4c") {
+		t.Fatalf("persisted dedupe window = %v, want hashed keys", exists)
+	}
+
+	// A restart loses the in-memory window but reloads it from the brain.
+	queueDedupe.Lock()
+	queueDedupe.seen = map[string]time.Time{}
+	queueDedupe.loaded = false
+	queueDedupe.Unlock()
+	if !recordQueueDedupe("1104df4c-feeb-43ab-8c85-83663288cea9", "17642656976077", now.Add(time.Second), false) {
+		t.Fatal("redelivered UUID/timestamp pair was not reported as duplicate after reload")
+	}
+}
+
+func TestQueueTriggerCounters(t *testing.T) {
+	queueTriggerCounters.Lock()
+	queueTriggerCounters.byProvider = map[string]*queueTriggerCounts{}
+	queueTriggerCounters.Unlock()
+
+	if d := triggerJobFromQueue("spool", robot.QueueMessage{ID: "m1", Body: []byte("short")}); d != robot.QueueReject {
+		t.Fatalf("malformed body disposition = %v", d)
+	}
+	countQueueTrigger("spool", queueTriggerAccepted)
+	countQueueTrigger("spool", queueTriggerDuplicate)
+
+	var found bool
+	for _, status := range listQueueProviderStatus() {
+		if status.provider != "spool" {
+			continue
+		}
+		found = true
+		if status.counts != (queueTriggerCounts{accepted: 1, duplicate: 1, rejected: 1}) {
+			t.Fatalf("spool counts = %+v", status.counts)
+		}
+	}
+	if !found {
+		t.Fatal("provider with counted triggers missing from queue status")
+	}
+}
+
+func TestSignedQueueBodies(t *testing.T) {
+	const key = "0123456789abcdef-trigger-key"
+	args := []string{"alpha", "two words", "it's"}
+	body := signQueueBody(key, "1104df4c-feeb-43ab-8c85-83663288cea9", "17642656976077", args)
+	parsed, err := parseSignedQueueBody([]byte(body))
+	if err != nil {
+		t.Fatalf("parseQueueBody(signed) returned error: %v", err)
+	}
+	if !reflect.DeepEqual(parsed.args, args) {
+		t.Fatalf("args = %#v, want %#v", parsed.args, args)
+	}
+	if err := verifyQueueSignature(key, parsed); err != nil {
+		t.Fatalf("verifyQueueSignature(valid) = %v", err)
+	}
+	if err := verifyQueueSignature("some-other-key-entirely", parsed); err == nil {
+		t.Fatal("signature verified with the wrong key")
+	}
+
+	tampered := strings.Replace(body, "alpha", "omega", 1)
+	parsed, err = parseSignedQueueBody([]byte(tampered))
+	if err != nil {
+		t.Fatalf("parseQueueBody(tampered) returned error: %v", err)
+	}
+	if err := verifyQueueSignature(key, parsed); err == nil {
+		t.Fatal("tampered arguments passed signature verification")
+	}
+
+	parsed, err = parseSignedQueueBody([]byte("1104df4c-feeb-43ab-8c85-83663288cea9:17642656976077 sig=short"))
+	if err != nil {
+		t.Fatalf("parseQueueBody(unsigned) returned error: %v", err)
+	}
+	if parsed.signature != nil || !reflect.DeepEqual(parsed.args, []string{"sig=short"}) {
+		t.Fatalf("malformed suffix parsed as signature: %+v", parsed)
+	}
+	if err := verifyQueueSignature(key, parsed); err == nil || !strings.Contains(err.Error(), "requires a signed") {
+		t.Fatalf("unsigned body error = %v", err)
+	}
+}
+
+func TestUnsignedQueueBodyKeepsSignatureLikeArg(t *testing.T) {
+	sigArg := "sig=" + strings.Repeat("0123456789abcdef", 4)
+	body := []byte("1104df4c-feeb-43ab-8c85-83663288cea9:17642656976077 alpha " + sigArg)
+	parsed, err := parseQueueBody(body)
+	if err != nil {
+		t.Fatalf("parseQueueBody returned error: %v", err)
+	}
+	if parsed.signature != nil {
+		t.Fatalf("unsigned body parsed with signature %q", parsed.signature)
+	}
+	if want := []string{"alpha", sigArg}; !reflect.DeepEqual(parsed.args, want) {
+		t.Fatalf("args = %#v, want %#v", parsed.args, want)
+	}
+
+	// The same body for a job with a UUIDTriggerKey has its suffix split off.
+	parsed, err = parseSignedQueueBody(body)
+	if err != nil {
+		t.Fatalf("parseSignedQueueBody returned error: %v", err)
+	}
+	if parsed.signature == nil || !reflect.DeepEqual(parsed.args, []string{"alpha"}) {
+		t.Fatalf("signature suffix not split off: %+v", parsed)
+	}
+}
+
+func TestSignedQueueBodyReplayedAfterDedupeWindow(t *testing.T) {
+	const key = "0123456789abcdef-trigger-key"
+	const jobUUID = "1104df4c-feeb-43ab-8c85-83663288cea9"
+	setQueueTriggersForTest(t, QueueTriggersConfig{})
+	job := &Job{Task: &Task{name: "deploy", Disabled: true}, UUIDTriggerKey: key}
+	currentCfg.Lock()
+	origTasks := currentCfg.taskList
+	currentCfg.taskList = &taskList{uuidTriggers: map[string]interface{}{jobUUID: job}}
+	currentCfg.Unlock()
+	t.Cleanup(func() {
+		currentCfg.Lock()
+		currentCfg.taskList = origTasks
+		currentCfg.Unlock()
+	})
+
+	// The body was delivered once, just over a dedupe window ago; its
+	// dedupe entry has expired, and MaxAgeSeconds is unset.
+	sent := time.Now().Add(-queueDedupeRetention - 5*time.Second)
+	timestamp := newQueueTimestamp(sent)
+	body := signQueueBody(key, jobUUID, timestamp, nil)
+	if recordQueueDedupe(jobUUID, timestamp, sent, true) {
+		t.Fatal("first delivery was reported as duplicate")
+	}
+	queueDedupe.Lock()
+	for seenKey, expiresAt := range queueDedupe.seen {
+		if expiresAt.After(time.Now()) {
+			t.Fatalf("dedupe entry %s outlives the window", seenKey)
+		}
+		delete(queueDedupe.seen, seenKey)
+	}
+	queueDedupe.Unlock()
+	if err := checkQueueTimestampAge(timestamp, time.Now(), false); err != nil {
+		t.Fatalf("unsigned age check without MaxAgeSeconds returned %v", err)
+	}
+	if err := checkQueueTimestampAge(timestamp, time.Now(), true); err == nil || !strings.Contains(err.Error(), "old") {
+		t.Fatalf("signed age check error = %v", err)
+	}
+	if _, outcome := startQueueTrigger("spool", robot.QueueMessage{ID: "replay", Body: []byte(body)}); outcome != queueTriggerRejected {
+		t.Fatalf("replayed signed body outcome = %v, want rejected", outcome)
+	}
+	queueDedupe.Lock()
+	remembered := len(queueDedupe.seen)
+	queueDedupe.Unlock()
+	if remembered != 0 {
+		t.Fatalf("replayed body reached dedupe; %d entries remembered", remembered)
+	}
+
+	// A fresh body still gets through the age check to the dedupe window.
+	fresh := signQueueBody(key, jobUUID, newQueueTimestamp(time.Now()), nil)
+	startQueueTrigger("spool", robot.QueueMessage{ID: "fresh", Body: []byte(fresh)})
+	queueDedupe.Lock()
+	remembered = len(queueDedupe.seen)
+	queueDedupe.Unlock()
+	if remembered != 1 {
+		t.Fatalf("fresh signed body left %d dedupe entries, want 1", remembered)
+	}
+}
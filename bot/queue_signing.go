@@ -0,0 +1,103 @@
+package bot
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// queueSignaturePrefix introduces the optional signature suffix on a queue
+// body: "<uuid>:<timestamp> [args] sig=<hex HMAC-SHA256>". The HMAC covers
+// everything before the suffix, timestamp included. Signed bodies are held
+// to QueueTriggers.MaxAgeSeconds, or the dedupe window when that's 0, and
+// their dedupe entries last until the timestamp is too old, so a replay is
+// always either a duplicate or stale.
+const queueSignaturePrefix = " sig="
+
+// minQueueTriggerKeyLen is the shortest UUIDTriggerKey accepted.
+const minQueueTriggerKeyLen = 16
+
+var queueSignatureRe = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+var safeQueueArgRe = regexp.MustCompile(`^[A-Za-z0-9@%+=:,./_-]+$`)
+
+// splitQueueSignature separates a trailing signature from the signed part of
+// a body; bodies without one are returned unchanged with a nil signature.
+// Only bodies for jobs with a UUIDTriggerKey are split, so an unsigned job
+// can take a last argument that happens to look like a signature.
+func splitQueueSignature(body []byte) (signed, signature []byte) {
+	i := bytes.LastIndex(body, []byte(queueSignaturePrefix))
+	if i < 0 {
+		return body, nil
+	}
+	sig := body[i+len(queueSignaturePrefix):]
+	if !queueSignatureRe.Match(sig) {
+		return body, nil
+	}
+	return body[:i], sig
+}
+
+func queueBodySignature(key string, signed []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(signed)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// parseSignedQueueBody parses a body for a job with a UUIDTriggerKey,
+// splitting off the signature for verifyQueueSignature.
+func parseSignedQueueBody(body []byte) (parsedQueueBody, error) {
+	signed, signature := splitQueueSignature(body)
+	parsed, err := parseQueueBody(signed)
+	if err != nil {
+		return parsedQueueBody{}, err
+	}
+	parsed.signed = signed
+	parsed.signature = signature
+	return parsed, nil
+}
+
+// verifyQueueSignature checks a parsed body against a job's UUIDTriggerKey.
+func verifyQueueSignature(key string, parsed parsedQueueBody) error {
+	if parsed.signature == nil {
+		return fmt.Errorf("job requires a signed queue body")
+	}
+	want := queueBodySignature(key, parsed.signed)
+	if !hmac.Equal([]byte(want), parsed.signature) {
+		return fmt.Errorf("queue body signature does not match")
+	}
+	return nil
+}
+
+// newQueueTimestamp returns the 14-digit timestamp queue-job.sh produces:
+// Unix time in units of 100µs.
+func newQueueTimestamp(now time.Time) string {
+	return strconv.FormatInt(now.UnixNano()/100000, 10)
+}
+
+// quoteQueueArg shell-escapes an argument the way parseQueueBody reads it.
+func quoteQueueArg(arg string) string {
+	if safeQueueArgRe.MatchString(arg) {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// signQueueBody builds a signed body for jobUUID and args.
+func signQueueBody(key, jobUUID, timestamp string, args []string) string {
+	var b strings.Builder
+	b.WriteString(jobUUID)
+	b.WriteString(":")
+	b.WriteString(timestamp)
+	for _, arg := range args {
+		b.WriteString(" ")
+		b.WriteString(quoteQueueArg(arg))
+	}
+	signed := b.String()
+	return signed + queueSignaturePrefix + queueBodySignature(key, []byte(signed))
+}
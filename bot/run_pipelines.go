@@ -161,6 +161,23 @@ func (w *worker) startPipeline(parent *worker, t interface{}, ptype pipelineType
 			c.parameters["GOPHER_QUEUE_PROVIDER"] = w.queueProvider
 			c.parameters["GOPHER_QUEUE_MESSAGE_ID"] = w.queueMessageID
 		}
+		if ptype == webhookJob && w.webhook != nil {
+			for name, value := range map[string]string{
+				"GOPHER_WEBHOOK_SOURCE":   w.webhook.source,
+				"GOPHER_WEBHOOK_EVENT":    w.webhook.event,
+				"GOPHER_WEBHOOK_DELIVERY": w.webhook.delivery,
+			} {
+				c.environment[name] = value
+				c.parameters[name] = value
+			}
+			// Configured task parameters win over payload values.
+			for name, value := range w.webhook.parameters {
+				if _, exists := c.environment[name]; !exists {
+					c.environment[name] = value
+					c.parameters[name] = value
+				}
+			}
+		}
 		// To change the channel to the job channel, we need to clear the ProcotolChannel
 		w.Channel = task.Channel
 		w.ProtocolChannel = ""
@@ -222,6 +239,8 @@ func (w *worker) startPipeline(parent *worker, t interface{}, ptype pipelineType
 			r.Say("Starting init job '%s', run %d%s", taskinfo, c.runIndex, logref)
 		case queuedJob:
 			r.Say("Starting queued job '%s', run %d%s - triggered by queue provider '%s'", taskinfo, c.runIndex, logref, w.queueProvider)
+		case webhookJob:
+			r.Say("Starting job '%s', run %d%s - triggered by %s webhook event '%s'", taskinfo, c.runIndex, logref, w.webhook.source, w.webhook.event)
 		default:
 			r.Say("Starting job '%s', run %d%s", taskinfo, c.runIndex, logref)
 		}
@@ -0,0 +1,253 @@
+package bot
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const minutesPerDay = 24 * 60
+
+// nextRunSearchLimit bounds the search for the next tick outside blackouts
+// and holidays.
+const nextRunSearchLimit = 2000
+
+// blackoutSpan is a parsed BlackoutWindow, in minutes from the start of the
+// day, or from Sunday 00:00 for a weekly window.
+type blackoutSpan struct {
+	start, end int
+	weekly     bool
+}
+
+func (b blackoutSpan) contains(t time.Time) bool {
+	pos := t.Hour()*60 + t.Minute()
+	if b.weekly {
+		pos += int(t.Weekday()) * minutesPerDay
+	}
+	if b.start < b.end {
+		return pos >= b.start && pos < b.end
+	}
+	return pos >= b.start || pos < b.end
+}
+
+// scheduleGates are the parsed Blackouts and HolidayCalendar of a
+// ScheduledTask.
+type scheduleGates struct {
+	blackouts []blackoutSpan
+	holidays  *holidayCalendar
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// parseBlackoutTime parses "HH:MM" or "Day HH:MM", returning minutes from
+// the start of the day or week.
+func parseBlackoutTime(spec string) (minutes int, weekly bool, err error) {
+	fields := strings.Fields(spec)
+	clock := ""
+	switch len(fields) {
+	case 1:
+		clock = fields[0]
+	case 2:
+		day := strings.ToLower(fields[0])
+		if len(day) < 3 {
+			return 0, false, fmt.Errorf("unknown day '%s' in '%s'", fields[0], spec)
+		}
+		wd, ok := weekdayNames[day[:3]]
+		if !ok {
+			return 0, false, fmt.Errorf("unknown day '%s' in '%s'", fields[0], spec)
+		}
+		weekly = true
+		minutes = int(wd) * minutesPerDay
+		clock = fields[1]
+	default:
+		return 0, false, fmt.Errorf("'%s' isn't 'HH:MM' or 'Day HH:MM'", spec)
+	}
+	ct, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid time '%s' in '%s'", clock, spec)
+	}
+	return minutes + ct.Hour()*60 + ct.Minute(), weekly, nil
+}
+
+func parseBlackoutWindow(w BlackoutWindow) (blackoutSpan, error) {
+	start, startWeekly, err := parseBlackoutTime(w.Start)
+	if err != nil {
+		return blackoutSpan{}, err
+	}
+	end, endWeekly, err := parseBlackoutTime(w.End)
+	if err != nil {
+		return blackoutSpan{}, err
+	}
+	if startWeekly != endWeekly {
+		return blackoutSpan{}, fmt.Errorf("blackout '%s' - '%s' needs a day on both Start and End, or neither", w.Start, w.End)
+	}
+	if start == end {
+		return blackoutSpan{}, fmt.Errorf("blackout '%s' - '%s' is empty", w.Start, w.End)
+	}
+	return blackoutSpan{start: start, end: end, weekly: startWeekly}, nil
+}
+
+// holidayCalendarPath resolves a HolidayCalendar relative to the config
+// directory.
+func holidayCalendarPath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	base := configFull
+	if base == "" {
+		base = configPath
+	}
+	return filepath.Join(base, path)
+}
+
+// prepareGates validates JitterSeconds, Blackouts and HolidayCalendar,
+// loading the calendar; called at config load.
+func (st *ScheduledTask) prepareGates() error {
+	if st.JitterSeconds < 0 {
+		return fmt.Errorf("negative JitterSeconds %d", st.JitterSeconds)
+	}
+	var gates scheduleGates
+	for _, w := range st.Blackouts {
+		span, err := parseBlackoutWindow(w)
+		if err != nil {
+			return err
+		}
+		gates.blackouts = append(gates.blackouts, span)
+	}
+	if len(st.HolidayCalendar) > 0 {
+		path := holidayCalendarPath(st.HolidayCalendar)
+		cal, warnings, err := loadHolidayCalendar(path)
+		if err != nil {
+			return fmt.Errorf("loading HolidayCalendar: %v", err)
+		}
+		for _, warning := range warnings {
+			Log(robot.Warn, "HolidayCalendar '%s' for ScheduledTask '%s': %s", st.HolidayCalendar, st.Name, warning)
+		}
+		gates.holidays = cal
+	}
+	st.gates = gates
+	return nil
+}
+
+// blockedAt returns why the entry can't start at t, or "" if it can; t
+// should be in the scheduler's time zone.
+func (st ScheduledTask) blockedAt(t time.Time) string {
+	for i, b := range st.gates.blackouts {
+		if b.contains(t) {
+			return fmt.Sprintf("in blackout window %s - %s", st.Blackouts[i].Start, st.Blackouts[i].End)
+		}
+	}
+	if st.gates.holidays != nil {
+		if name, ok := st.gates.holidays.holiday(t); ok {
+			return fmt.Sprintf("%s is a holiday (%s)", t.Format("2006-01-02"), name)
+		}
+	}
+	return ""
+}
+
+func (st ScheduledTask) jitter() time.Duration {
+	if st.JitterSeconds <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(st.JitterSeconds) * int64(time.Second)))
+}
+
+// scheduleLocation is the time zone scheduled jobs run in.
+func scheduleLocation(cfg *configuration) *time.Location {
+	if cfg != nil && cfg.timeZone != nil {
+		return cfg.timeZone
+	}
+	return time.Local
+}
+
+// gatedScheduledRun picks the entry's jittered start time, skips the run if
+// that falls in a blackout window or on a holiday, and otherwise waits out
+// the jitter and runs it.
+func gatedScheduledRun(st ScheduledTask, t interface{}, cfg *configuration, tasks *taskList) {
+	delay := st.jitter()
+	start := time.Now().Add(delay).In(scheduleLocation(cfg))
+	if reason := st.blockedAt(start); reason != "" {
+		Log(robot.Info, "Skipping scheduled run of job '%s', args '%v': %s", st.Name, st.Arguments, reason)
+		return
+	}
+	if delay > 0 {
+		Log(robot.Debug, "Delaying scheduled run of job '%s' by %s of jitter", st.Name, delay.Round(time.Second))
+		time.Sleep(delay)
+	}
+	runScheduledEntry(st, t, cfg, tasks, false)
+}
+
+// nextEffectiveRun finds the next tick of a cron entry after now that isn't
+// in a blackout window or on a holiday, ignoring jitter.
+func nextEffectiveRun(st ScheduledTask, sched scheduleNext, now time.Time) (time.Time, bool) {
+	next := now
+	for i := 0; i < nextRunSearchLimit; i++ {
+		next = sched.Next(next)
+		if next.IsZero() {
+			return time.Time{}, false
+		}
+		if st.blockedAt(next) == "" {
+			return next, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// nextScheduledRuns describes when each job next runs from ScheduledJobs,
+// for 'list jobs'.
+func nextScheduledRuns(scheduled []ScheduledTask, now time.Time) map[string]string {
+	next := make(map[string]time.Time)
+	jitter := make(map[string]int)
+	dependent := make(map[string]string)
+	for _, st := range scheduled {
+		if st.hasDependencies() {
+			var edges []string
+			if len(st.After) > 0 {
+				edges = append(edges, "runs after: "+strings.Join(st.After, ", "))
+			}
+			if len(st.OnFailure) > 0 {
+				edges = append(edges, "runs when failed: "+strings.Join(st.OnFailure, ", "))
+			}
+			dependent[st.Name] = strings.Join(edges, "; ")
+			continue
+		}
+		if st.Schedule == "@init" {
+			continue
+		}
+		sched, err := scheduleParser.Parse(st.Schedule)
+		if err != nil {
+			continue
+		}
+		if t, ok := nextEffectiveRun(st, sched, now); ok {
+			if cur, ok := next[st.Name]; !ok || t.Before(cur) {
+				next[st.Name] = t
+				jitter[st.Name] = st.JitterSeconds
+			}
+		}
+	}
+	out := make(map[string]string, len(next)+len(dependent))
+	for name, desc := range dependent {
+		out[name] = desc
+	}
+	for name, t := range next {
+		desc := "next run: " + t.Format("Mon Jan 2 15:04 MST")
+		if jitter[name] > 0 {
+			desc += " (+ up to " + strconv.Itoa(jitter[name]) + "s)"
+		}
+		out[name] = desc
+	}
+	return out
+}
@@ -0,0 +1,134 @@
+package bot
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testHolidays = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Christmas\r\n" +
+	"DTSTART;VALUE=DATE:20251225\r\n" +
+	"DTEND;VALUE=DATE:20251226\r\n" +
+	"RRULE:FREQ=YEARLY;BYMONTH=12;BYMONTHDAY=25\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Company\r\n" +
+	"  offsite\r\n" +
+	"DTSTART;VALUE=DATE:20260812\r\n" +
+	"DTEND;VALUE=DATE:20260814\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Thanksgiving\r\n" +
+	"DTSTART;VALUE=DATE:20261126\r\n" +
+	"RRULE:FREQ=YEARLY;BYMONTH=11;BYDAY=4TH\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParseHolidayCalendar(t *testing.T) {
+	cal, warnings, err := parseHolidayCalendar([]byte(testHolidays))
+	if err != nil {
+		t.Fatalf("parseHolidayCalendar: %v", err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "Thanksgiving") {
+		t.Fatalf("warnings = %q, want one for Thanksgiving's RRULE", warnings)
+	}
+	for date, want := range map[string]string{
+		"2025-12-25": "Christmas",
+		"2031-12-25": "Christmas",
+		"2026-08-12": "Company offsite",
+		"2026-08-13": "Company offsite",
+		"2026-08-14": "",
+		"2026-11-26": "Thanksgiving",
+		"2027-11-25": "",
+	} {
+		d, _ := time.Parse("2006-01-02", date)
+		name, ok := cal.holiday(d)
+		if ok != (want != "") || name != want {
+			t.Errorf("holiday(%s) = %q, %t; want %q", date, name, ok, want)
+		}
+	}
+}
+
+func TestBlackoutWindows(t *testing.T) {
+	st := ScheduledTask{
+		Blackouts: []BlackoutWindow{
+			{Start: "Friday 16:00", End: "Mon 08:00"},
+			{Start: "23:30", End: "00:30"},
+		},
+		TaskSpec: TaskSpec{Name: "deploy"},
+	}
+	if err := st.prepareGates(); err != nil {
+		t.Fatalf("prepareGates: %v", err)
+	}
+	// 2026-10-16 is a Friday.
+	for when, blocked := range map[string]bool{
+		"2026-10-16 15:59": false,
+		"2026-10-16 16:00": true,
+		"2026-10-18 12:00": true,
+		"2026-10-19 07:59": true,
+		"2026-10-19 08:00": false,
+		"2026-10-20 23:45": true,
+		"2026-10-21 00:15": true,
+		"2026-10-21 00:30": false,
+	} {
+		at, _ := time.Parse("2006-01-02 15:04", when)
+		if got := st.blockedAt(at) != ""; got != blocked {
+			t.Errorf("blockedAt(%s) = %t, want %t", when, got, blocked)
+		}
+	}
+	for _, bad := range []BlackoutWindow{
+		{Start: "Fri 16:00", End: "08:00"},
+		{Start: "Funday 16:00", End: "Mon 08:00"},
+		{Start: "25:00", End: "08:00"},
+		{Start: "08:00", End: "08:00"},
+	} {
+		st := ScheduledTask{Blackouts: []BlackoutWindow{bad}}
+		if err := st.prepareGates(); err == nil {
+			t.Errorf("prepareGates accepted blackout %s - %s", bad.Start, bad.End)
+		}
+	}
+}
+
+func TestNextEffectiveRun(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "holidays.ics")
+	if err := os.WriteFile(path, []byte(testHolidays), 0600); err != nil {
+		t.Fatal(err)
+	}
+	st := ScheduledTask{
+		Schedule:        "0 17 * * *",
+		Blackouts:       []BlackoutWindow{{Start: "Fri 16:00", End: "Mon 08:00"}},
+		HolidayCalendar: path,
+		JitterSeconds:   300,
+		TaskSpec:        TaskSpec{Name: "deploy"},
+	}
+	if err := st.prepareGates(); err != nil {
+		t.Fatalf("prepareGates: %v", err)
+	}
+	sched, err := scheduleParser.Parse(st.Schedule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Thursday 2026-12-24 after 17:00: Fri-Sun are blacked out, and
+	// Christmas is Friday, so the next run is Monday the 28th.
+	now := time.Date(2026, 12, 24, 18, 0, 0, 0, time.UTC)
+	next, ok := nextEffectiveRun(st, sched, now)
+	if !ok || !next.Equal(time.Date(2026, 12, 28, 17, 0, 0, 0, time.UTC)) {
+		t.Fatalf("nextEffectiveRun = %s, %t", next, ok)
+	}
+	runs := nextScheduledRuns([]ScheduledTask{
+		st,
+		{After: []string{"deploy"}, TaskSpec: TaskSpec{Name: "smoke-test"}},
+	}, now)
+	if got := runs["deploy"]; got != "next run: Mon Dec 28 17:00 UTC (+ up to 300s)" {
+		t.Errorf("deploy next run = %q", got)
+	}
+	if got := runs["smoke-test"]; got != "runs after: deploy" {
+		t.Errorf("smoke-test next run = %q", got)
+	}
+}
@@ -2,6 +2,7 @@ package bot
 
 import (
 	"sync"
+	"time"
 
 	"github.com/lnxjedi/gopherbot/robot"
 	"github.com/robfig/cron/v3"
@@ -21,11 +22,11 @@ var scheduleParser = cron.NewParser(
 )
 
 var pausedJobs = struct {
-	jobs map[string]string
+	jobs   map[string]string
+	loaded bool
 	sync.Mutex
 }{
-	make(map[string]string),
-	sync.Mutex{},
+	jobs: make(map[string]string),
 }
 
 func scheduleTasks() {
@@ -48,6 +49,11 @@ func scheduleTasks() {
 	cfg := currentCfg.configuration
 	tasks := currentCfg.taskList
 	currentCfg.RUnlock()
+	pausedJobs.Lock()
+	loadPausedJobs()
+	pausedJobs.Unlock()
+	var dependents []*scheduledJobNode
+	var catchUp []ScheduledTask
 	for _, st := range scheduled {
 		t := tasks.getTaskByName(st.Name)
 		if t == nil {
@@ -68,15 +74,44 @@ func scheduleTasks() {
 			continue
 		}
 		ts := st.TaskSpec
+		if st.hasDependencies() {
+			Log(robot.Info, "Scheduling job '%s', args '%v' after: %v, on failure: %v", ts.Name, ts.Arguments, st.After, st.OnFailure)
+			dependents = append(dependents, &scheduledJobNode{st: st, t: t})
+			continue
+		}
 		if st.Schedule != "@init" {
 			Log(robot.Info, "Scheduling job '%s', args '%v' with schedule: %s", ts.Name, ts.Arguments, st.Schedule)
-			if _, err := taskRunner.AddFunc(st.Schedule, func() { runScheduledTask(t, ts, cfg, tasks, false) }); err != nil {
+			if _, err := taskRunner.AddFunc(st.Schedule, func() {
+				if st.CatchUp {
+					recordScheduledTick(st, time.Now())
+				}
+				gatedScheduledRun(st, t, cfg, tasks)
+			}); err != nil {
 				Log(robot.Error, "Failed scheduling job '%s' with schedule '%s': %v", ts.Name, st.Schedule, err)
+				continue
+			}
+			if st.CatchUp {
+				catchUp = append(catchUp, st)
 			}
 		}
 	}
+	jobGraph.set(dependents, cfg, tasks)
 	taskRunner.Start()
 	schedMutex.Unlock()
+	now := time.Now()
+	if tz != nil {
+		now = now.In(tz)
+	}
+	for _, st := range catchUp {
+		sched, err := scheduleParser.Parse(st.Schedule)
+		if err != nil {
+			continue
+		}
+		if missedScheduledRun(st, sched, now) {
+			Log(robot.Info, "Catching up missed run of job '%s', args '%v' with schedule: %s", st.Name, st.Arguments, st.Schedule)
+			go gatedScheduledRun(st, tasks.getTaskByName(st.Name), cfg, tasks)
+		}
+	}
 }
 
 // initJobs - run init jobs that might be required by external plugins
@@ -87,7 +122,6 @@ func initJobs() {
 	tasks := currentCfg.taskList
 	currentCfg.RUnlock()
 	for _, st := range scheduled {
-		ts := st.TaskSpec
 		if st.Schedule == "@init" {
 			t := tasks.getTaskByName(st.Name)
 			if t == nil {
@@ -103,7 +137,7 @@ func initJobs() {
 				Log(robot.Error, "Ignoring disabled job '%s' while running init jobs; reason: %s", st.Name, task.reason)
 				continue
 			}
-			runScheduledTask(t, ts, cfg, tasks, true)
+			runScheduledEntry(st, t, cfg, tasks, true)
 		}
 	}
 }
@@ -117,6 +151,7 @@ func runScheduledTask(t interface{}, ts TaskSpec, cfg *configuration, tasks *tas
 	}
 	currentCfg.RUnlock()
 	pausedJobs.Lock()
+	loadPausedJobs()
 	if user, ok := pausedJobs.jobs[task.name]; ok {
 		Log(robot.Debug, "Skipping run of job '%s' paused by user '%s'", task.name, user)
 		pausedJobs.Unlock()
@@ -144,5 +179,7 @@ func runScheduledTask(t interface{}, ts TaskSpec, cfg *configuration, tasks *tas
 	if isInitJob {
 		jobtype = initJob
 	}
-	w.startPipeline(nil, t, jobtype, "run", ts.Arguments...)
+	scheduledJobStarting(task.name)
+	ret := w.startPipeline(nil, t, jobtype, "run", ts.Arguments...)
+	scheduledJobFinished(task.name, ret)
 }
@@ -0,0 +1,241 @@
+package bot
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+// scheduledRunsKey records the last cron tick of each CatchUp entry, so a
+// run missed while the robot was down can be made up at startup.
+const scheduledRunsKey = "bot:_scheduled-runs"
+
+// pausedJobsKey persists pausedJobs, so paused jobs stay paused across
+// restarts.
+const pausedJobsKey = "bot:_paused-jobs"
+
+// Overlap policies for a ScheduledJobs entry whose previous run is still
+// going when it's due to start again.
+const (
+	overlapAllow = "allow" // start another copy; the default
+	overlapSkip  = "skip"  // drop the new run
+	overlapQueue = "queue" // start one more run when the current one finishes
+)
+
+func validOverlapPolicy(policy string) bool {
+	switch policy {
+	case "", overlapAllow, overlapSkip, overlapQueue:
+		return true
+	}
+	return false
+}
+
+// runKey identifies an entry for overlap tracking: the same job with the
+// same arguments counts as the same run, whatever started it.
+func (st ScheduledTask) runKey() string {
+	return strings.Join(append([]string{st.Name}, st.Arguments...), " ")
+}
+
+// catchUpKey identifies a CatchUp entry's last tick; it includes the
+// Schedule, since a changed schedule has a different idea of missed.
+func (st ScheduledTask) catchUpKey() string {
+	return st.Schedule + " " + st.runKey()
+}
+
+// internalMemoryReady reports whether the engine can read and write its
+// own bot:_ memories.
+func internalMemoryReady() bool {
+	if interfaces.brain == nil {
+		return false
+	}
+	cryptKey.RLock()
+	defer cryptKey.RUnlock()
+	return cryptKey.initialized
+}
+
+var scheduledRuns = struct {
+	sync.Mutex
+	running map[string]int
+	queued  map[string]bool
+	lastRun map[string]time.Time
+	loaded  bool
+}{
+	running: map[string]int{},
+	queued:  map[string]bool{},
+	lastRun: map[string]time.Time{},
+}
+
+// runScheduledEntry applies the entry's Overlap policy, then runs it.
+func runScheduledEntry(st ScheduledTask, t interface{}, cfg *configuration, tasks *taskList, isInitJob bool) {
+	if !claimScheduledRun(st) {
+		return
+	}
+	for {
+		runScheduledTask(t, st.TaskSpec, cfg, tasks, isInitJob)
+		if !releaseScheduledRun(st) {
+			return
+		}
+		Log(robot.Info, "Starting queued scheduled run of '%s'", st.runKey())
+	}
+}
+
+// claimScheduledRun reports whether a run of st should start now, counting
+// it as running if so.
+func claimScheduledRun(st ScheduledTask) bool {
+	key := st.runKey()
+	scheduledRuns.Lock()
+	defer scheduledRuns.Unlock()
+	if scheduledRuns.running[key] > 0 {
+		switch st.Overlap {
+		case overlapSkip:
+			Log(robot.Warn, "Skipping scheduled run of '%s', previous run still in progress (Overlap: skip)", key)
+			return false
+		case overlapQueue:
+			scheduledRuns.queued[key] = true
+			Log(robot.Info, "Queueing scheduled run of '%s' until the previous run finishes (Overlap: queue)", key)
+			return false
+		}
+	}
+	scheduledRuns.running[key]++
+	return true
+}
+
+// releaseScheduledRun ends a run of st, reporting whether a queued run
+// should start in its place; if so it stays counted as running.
+func releaseScheduledRun(st ScheduledTask) bool {
+	key := st.runKey()
+	scheduledRuns.Lock()
+	defer scheduledRuns.Unlock()
+	if scheduledRuns.queued[key] {
+		delete(scheduledRuns.queued, key)
+		return true
+	}
+	scheduledRuns.running[key]--
+	if scheduledRuns.running[key] <= 0 {
+		delete(scheduledRuns.running, key)
+	}
+	return false
+}
+
+// loadScheduledRuns merges persisted CatchUp ticks into memory the first
+// time the brain is available; the caller holds scheduledRuns.
+func loadScheduledRuns() {
+	if scheduledRuns.loaded || !internalMemoryReady() {
+		return
+	}
+	_, data, exists, ret := getDatum(scheduledRunsKey, false)
+	if ret != robot.Ok {
+		return
+	}
+	scheduledRuns.loaded = true
+	if !exists {
+		return
+	}
+	var stored map[string]time.Time
+	if err := json.Unmarshal(*data, &stored); err != nil {
+		Log(robot.Error, "Discarding unreadable scheduled run times '%s': %v", scheduledRunsKey, err)
+		return
+	}
+	for key, last := range stored {
+		if last.After(scheduledRuns.lastRun[key]) {
+			scheduledRuns.lastRun[key] = last
+		}
+	}
+}
+
+// saveScheduledRuns persists CatchUp ticks; the caller holds scheduledRuns.
+func saveScheduledRuns() {
+	if !scheduledRuns.loaded {
+		return
+	}
+	data, err := json.Marshal(scheduledRuns.lastRun)
+	if err != nil {
+		Log(robot.Error, "Marshalling scheduled run times: %v", err)
+		return
+	}
+	if ret := storeDatum(scheduledRunsKey, &data); ret != robot.Ok {
+		Log(robot.Error, "Storing scheduled run times: %s", ret)
+	}
+}
+
+// recordScheduledTick notes a cron tick for a CatchUp entry.
+func recordScheduledTick(st ScheduledTask, now time.Time) {
+	scheduledRuns.Lock()
+	defer scheduledRuns.Unlock()
+	loadScheduledRuns()
+	scheduledRuns.lastRun[st.catchUpKey()] = now
+	saveScheduledRuns()
+}
+
+// scheduleNext is the part of cron.Schedule catch-up needs.
+type scheduleNext interface {
+	Next(time.Time) time.Time
+}
+
+// missedScheduledRun reports whether a CatchUp entry missed a tick since it
+// last ran, and records now as its last tick when it did, or when there's
+// no record yet. It returns false when the brain isn't available.
+func missedScheduledRun(st ScheduledTask, sched scheduleNext, now time.Time) bool {
+	scheduledRuns.Lock()
+	defer scheduledRuns.Unlock()
+	loadScheduledRuns()
+	if !scheduledRuns.loaded {
+		Log(robot.Warn, "Unable to check '%s' for missed runs, brain not available", st.Name)
+		return false
+	}
+	key := st.catchUpKey()
+	last, ok := scheduledRuns.lastRun[key]
+	missed := ok && !sched.Next(last.In(now.Location())).After(now)
+	if !ok || missed {
+		scheduledRuns.lastRun[key] = now
+		saveScheduledRuns()
+	}
+	return missed
+}
+
+// loadPausedJobs merges persisted paused jobs into pausedJobs the first
+// time the brain is available; the caller holds pausedJobs.
+func loadPausedJobs() {
+	if pausedJobs.loaded || !internalMemoryReady() {
+		return
+	}
+	_, data, exists, ret := getDatum(pausedJobsKey, false)
+	if ret != robot.Ok {
+		return
+	}
+	pausedJobs.loaded = true
+	if !exists {
+		return
+	}
+	var stored map[string]string
+	if err := json.Unmarshal(*data, &stored); err != nil {
+		Log(robot.Error, "Discarding unreadable paused jobs '%s': %v", pausedJobsKey, err)
+		return
+	}
+	for job, user := range stored {
+		if _, ok := pausedJobs.jobs[job]; !ok {
+			pausedJobs.jobs[job] = user
+		}
+	}
+	if len(stored) > 0 {
+		Log(robot.Info, "Restored %d paused job(s) from long-term memory", len(stored))
+	}
+}
+
+// savePausedJobs persists pausedJobs; the caller holds pausedJobs.
+func savePausedJobs() {
+	if !pausedJobs.loaded {
+		return
+	}
+	data, err := json.Marshal(pausedJobs.jobs)
+	if err != nil {
+		Log(robot.Error, "Marshalling paused jobs: %v", err)
+		return
+	}
+	if ret := storeDatum(pausedJobsKey, &data); ret != robot.Ok {
+		Log(robot.Error, "Storing paused jobs: %s", ret)
+	}
+}
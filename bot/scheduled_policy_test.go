@@ -0,0 +1,113 @@
+package bot
+
+import (
+	"testing"
+	"time"
+)
+
+func withInternalMemory(t *testing.T) {
+	t.Helper()
+	oldBrain := interfaces.brain
+	oldKey := cryptKey.key
+	oldInitialized := cryptKey.initialized
+	interfaces.brain = &memBrain{memories: map[string]*[]byte{}}
+	cryptKey.Lock()
+	cryptKey.key = []byte("0123456789abcdef0123456789abcdef")
+	cryptKey.initialized = true
+	cryptKey.Unlock()
+	t.Cleanup(func() {
+		interfaces.brain = oldBrain
+		cryptKey.Lock()
+		cryptKey.key = oldKey
+		cryptKey.initialized = oldInitialized
+		cryptKey.Unlock()
+	})
+}
+
+func TestScheduledRunOverlapPolicies(t *testing.T) {
+	for _, policy := range []string{overlapAllow, overlapSkip, overlapQueue} {
+		st := ScheduledTask{Overlap: policy, TaskSpec: TaskSpec{Name: "nightly", Arguments: []string{policy}}}
+		if !claimScheduledRun(st) {
+			t.Fatalf("%s: first run not started", policy)
+		}
+		second := claimScheduledRun(st)
+		if second != (policy == overlapAllow) {
+			t.Fatalf("%s: overlapping run started = %t", policy, second)
+		}
+		if policy == overlapAllow {
+			releaseScheduledRun(st)
+		}
+		again := releaseScheduledRun(st)
+		if again != (policy == overlapQueue) {
+			t.Fatalf("%s: queued run started = %t", policy, again)
+		}
+		if again && releaseScheduledRun(st) {
+			t.Fatalf("%s: queued run repeated", policy)
+		}
+		scheduledRuns.Lock()
+		running := scheduledRuns.running[st.runKey()]
+		scheduledRuns.Unlock()
+		if running != 0 {
+			t.Fatalf("%s: %d runs still counted after finishing", policy, running)
+		}
+	}
+}
+
+func TestMissedScheduledRun(t *testing.T) {
+	withInternalMemory(t)
+	scheduledRuns.Lock()
+	scheduledRuns.lastRun = map[string]time.Time{}
+	scheduledRuns.loaded = false
+	scheduledRuns.Unlock()
+
+	st := ScheduledTask{Schedule: "0 2 * * *", CatchUp: true, TaskSpec: TaskSpec{Name: "nightly"}}
+	sched, err := scheduleParser.Parse(st.Schedule)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	start := time.Date(2026, 3, 1, 1, 0, 0, 0, time.UTC)
+	if missedScheduledRun(st, sched, start) {
+		t.Fatal("first check reported a missed run without a record")
+	}
+	if missedScheduledRun(st, sched, start.Add(30*time.Minute)) {
+		t.Fatal("reported a missed run before the next tick")
+	}
+	recordScheduledTick(st, start.Add(time.Hour))
+	if missedScheduledRun(st, sched, start.Add(20*time.Hour)) {
+		t.Fatal("reported a missed run after the tick ran")
+	}
+
+	// Forget the in-memory state, as after a restart: the down time
+	// spanned 02:00 on the 2nd.
+	scheduledRuns.Lock()
+	scheduledRuns.lastRun = map[string]time.Time{}
+	scheduledRuns.loaded = false
+	scheduledRuns.Unlock()
+	restart := start.Add(26 * time.Hour)
+	if !missedScheduledRun(st, sched, restart) {
+		t.Fatal("missed run not reported after restart")
+	}
+	if missedScheduledRun(st, sched, restart.Add(time.Minute)) {
+		t.Fatal("missed run reported twice")
+	}
+}
+
+func TestPausedJobsPersist(t *testing.T) {
+	withInternalMemory(t)
+	pausedJobs.Lock()
+	pausedJobs.jobs = map[string]string{}
+	pausedJobs.loaded = false
+	loadPausedJobs()
+	pausedJobs.jobs["nightly"] = "alice"
+	savePausedJobs()
+	pausedJobs.jobs = map[string]string{}
+	pausedJobs.loaded = false
+	loadPausedJobs()
+	user := pausedJobs.jobs["nightly"]
+	pausedJobs.jobs = map[string]string{}
+	pausedJobs.loaded = false
+	pausedJobs.Unlock()
+	if user != "alice" {
+		t.Fatalf("restored pause for nightly by %q, want alice", user)
+	}
+}
@@ -312,11 +312,12 @@ LoadLoop:
 			var sarrval []string
 			var mval []InputMatcher
 			var tval []JobTrigger
+			var wval []WebhookTrigger
 			var timeoutval TimeOutThresholds
 			var val interface{}
 			skip := false
 			switch key {
-			case "Elevator", "Authorizer", "AuthRequire", "NameSpace", "Channel", "UUIDTrigger":
+			case "Elevator", "Authorizer", "AuthRequire", "NameSpace", "Channel", "UUIDTrigger", "UUIDTriggerKey":
 				val = &strval
 			case "KeepLogs":
 				val = &intval
@@ -332,6 +333,8 @@ LoadLoop:
 				val = &mval
 			case "Triggers":
 				val = &tval
+			case "WebhookTriggers":
+				val = &wval
 			case "Config":
 				skip = true
 			case "Privileged":
@@ -410,6 +413,12 @@ LoadLoop:
 				} else {
 					job.UUIDTrigger = strings.TrimSpace(*(val.(*string)))
 				}
+			case "UUIDTriggerKey":
+				if isPlugin {
+					mismatch = true
+				} else {
+					job.UUIDTriggerKey = strings.TrimSpace(*(val.(*string)))
+				}
 			case "Authorizer":
 				task.Authorizer = *(val.(*string))
 			case "AuthRequire":
@@ -506,6 +515,12 @@ LoadLoop:
 				} else {
 					job.Triggers = *(val.(*[]JobTrigger))
 				}
+			case "WebhookTriggers":
+				if isPlugin {
+					mismatch = true
+				} else {
+					job.WebhookTriggers = *(val.(*[]WebhookTrigger))
+				}
 			case "Config":
 				task.Config = value
 			case "TimeOuts":
@@ -659,6 +674,15 @@ LoadLoop:
 					trigger.re = re
 				}
 			}
+			for i := range job.WebhookTriggers {
+				if err := validateWebhookTrigger(&job.WebhookTriggers[i]); err != nil {
+					msg := fmt.Sprintf("Disabling '%s', invalid webhook trigger #%d: %v", task.name, i+1, err)
+					Log(robot.Error, msg)
+					task.Disabled = true
+					task.reason = msg
+					continue LoadLoop
+				}
+			}
 			for i := range job.Arguments {
 				argument := &job.Arguments[i]
 				label := argument.Label
@@ -813,8 +837,17 @@ LoadLoop:
 				task.reason = msg
 				continue
 			}
+			if job.UUIDTriggerKey != "" && len(job.UUIDTriggerKey) < minQueueTriggerKeyLen {
+				msg := fmt.Sprintf("Disabling '%s', UUIDTriggerKey must be at least %d characters", task.name, minQueueTriggerKeyLen)
+				Log(robot.Error, msg)
+				task.Disabled = true
+				task.reason = msg
+				continue
+			}
 			job.UUIDTrigger = normalized
 			newList.uuidTriggers[normalized] = job
+		} else if isJob && job.UUIDTriggerKey != "" {
+			Log(robot.Warn, "Job '%s' has a UUIDTriggerKey but no UUIDTrigger; ignoring it", task.name)
 		}
 
 		Log(robot.Debug, "Configured task '%s'", task.name)
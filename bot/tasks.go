@@ -107,8 +107,27 @@ type TaskSettings struct {
 
 // ScheduledTask items defined in robot.yaml, mostly for scheduled jobs
 type ScheduledTask struct {
-	Schedule string           `yaml:"Schedule"` // Timespec for https://pkg.go.dev/github.com/robfig/cron/v3
-	TaskSpec `yaml:",inline"` // Inlines TaskSpec fields
+	Schedule  string           `yaml:"Schedule"`  // Timespec for https://pkg.go.dev/github.com/robfig/cron/v3
+	After     []string         `yaml:"After"`     // Run once each of these jobs has succeeded, instead of on a Schedule
+	OnFailure []string         `yaml:"OnFailure"` // Run when any of these jobs fails
+	CatchUp   bool             `yaml:"CatchUp"`   // Run once at startup if a scheduled run was missed
+	Overlap   string           `yaml:"Overlap"`   // skip, queue or allow (default) a run while the last is still going
+	TaskSpec  `yaml:",inline"` // Inlines TaskSpec fields
+
+	JitterSeconds   int              `yaml:"JitterSeconds"`   // Delay each start by a random 0-JitterSeconds
+	Blackouts       []BlackoutWindow `yaml:"Blackouts"`       // Windows when scheduled runs are skipped
+	HolidayCalendar string           `yaml:"HolidayCalendar"` // .ics file of dates to skip, relative to the config directory
+
+	gates scheduleGates // parsed Blackouts and HolidayCalendar
+}
+
+// BlackoutWindow is a recurring period when a scheduled job doesn't start.
+// Start and End are "HH:MM" for a daily window, or "Day HH:MM" (e.g.
+// "Fri 16:00", "Mon 08:00") for a weekly one; a window may wrap past
+// midnight or the end of the week.
+type BlackoutWindow struct {
+	Start string `yaml:"Start"`
+	End   string `yaml:"End"`
 }
 
 // InputMatcher specifies the command or message to match for a plugin
@@ -135,6 +154,18 @@ type JobTrigger struct {
 	re      *regexp.Regexp `yaml:"-"`       // The compiled regular expression, logged if compilation fails
 }
 
+// WebhookTrigger starts a job from a signed webhook posted to the engine's
+// WebhookListener.
+type WebhookTrigger struct {
+	Source     string            `yaml:"Source"`     // github, gitea or gitlab
+	Secret     string            `yaml:"Secret"`     // HMAC secret; for gitlab, the X-Gitlab-Token value
+	Events     []string          `yaml:"Events"`     // event types to accept; empty accepts any
+	Repository string            `yaml:"Repository"` // optional "owner/name" the event must come from
+	Branch     string            `yaml:"Branch"`     // optional branch glob, e.g. "main" or "release/*"
+	Arguments  []string          `yaml:"Arguments"`  // dotted payload fields passed as job arguments
+	Parameters map[string]string `yaml:"Parameters"` // parameter name to dotted payload field
+}
+
 // ParameterSet just stores a name, description, and parameters - they cannot be run.
 type ParameterSet struct {
 	name        string      `yaml:"-"`           // Name of the shared namespace
@@ -172,12 +203,14 @@ type Task struct {
 
 // Job - configuration only applicable to jobs. Read in from conf/jobs/<job>.yaml, which can also include anything from a Task.
 type Job struct {
-	Quiet       bool           `yaml:"Quiet"`       // Whether to quash "job started/ended" messages
-	KeepLogs    int            `yaml:"KeepLogs"`    // How many runs of this job/plugin to keep history for
-	UUIDTrigger string         `yaml:"UUIDTrigger"` // Optional UUID for queue-triggered jobs
-	Triggers    []JobTrigger   `yaml:"Triggers"`    // User/regex that triggers a job, e.g., a git-activated webhook or integration
-	Arguments   []InputMatcher `yaml:"Arguments"`   // List of arguments to prompt the user for
-	*Task       `yaml:",inline"`
+	Quiet           bool             `yaml:"Quiet"`           // Whether to quash "job started/ended" messages
+	KeepLogs        int              `yaml:"KeepLogs"`        // How many runs of this job/plugin to keep history for
+	UUIDTrigger     string           `yaml:"UUIDTrigger"`     // Optional UUID for queue-triggered jobs
+	UUIDTriggerKey  string           `yaml:"UUIDTriggerKey"`  // Optional HMAC key; queue bodies for this job must be signed with it
+	WebhookTriggers []WebhookTrigger `yaml:"WebhookTriggers"` // Signed webhooks that start this job
+	Triggers        []JobTrigger     `yaml:"Triggers"`        // User/regex that triggers a job, e.g., a git-activated webhook or integration
+	Arguments       []InputMatcher   `yaml:"Arguments"`       // List of arguments to prompt the user for
+	*Task           `yaml:",inline"`
 }
 
 // Plugin specifies the structure of a plugin configuration. Plugins should include an example/default config.
@@ -110,6 +110,16 @@ func getProtocol(proto string) robot.Protocol {
 		return robot.Rocket
 	case "ssh":
 		return robot.SSH
+	case "matrix":
+		return robot.Matrix
+	case "mattermost":
+		return robot.Mattermost
+	case "teams":
+		return robot.Teams
+	case "discord":
+		return robot.Discord
+	case "irc":
+		return robot.IRC
 	default:
 		return robot.Test
 	}
@@ -0,0 +1,501 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const (
+	defaultWebhookPath        = "/webhooks"
+	defaultWebhookMaxBodySize = 1 << 20
+	defaultWebhookDedupe      = 24 * time.Hour
+	webhookShutdownTimeout    = 5 * time.Second
+)
+
+// WebhookListenerConfig is the root WebhookListener setting; the listener
+// only runs when Address is set.
+type WebhookListenerConfig struct {
+	Address       string `yaml:"Address"`       // host:port to listen on, e.g. ":8088"
+	Path          string `yaml:"Path"`          // URL path webhooks are posted to; default /webhooks
+	MaxBodySize   int    `yaml:"MaxBodySize"`   // largest accepted payload in bytes; default 1MiB
+	DedupeSeconds int    `yaml:"DedupeSeconds"` // how long delivery IDs and payloads are remembered; default 86400
+}
+
+// webhookOrigin is the pipeline metadata for a webhook-triggered job.
+type webhookOrigin struct {
+	source     string
+	event      string
+	delivery   string
+	parameters map[string]string
+}
+
+// webhookDelivery is a request that has been read, but not yet
+// authenticated; the payload is only decoded once a trigger's secret
+// verifies it.
+type webhookDelivery struct {
+	source   string
+	event    string
+	id       string
+	header   http.Header
+	body     []byte
+	payload  interface{}
+	repo     string
+	branch   string
+	received time.Time
+}
+
+// webhookDedupeKey holds the webhook delivery dedupe window. Its entries last
+// a day by default, so it's kept apart from the short queue trigger window.
+const webhookDedupeKey = "bot:_webhook-dedupe"
+
+var webhookDedupe = newTriggerDedupeWindow(webhookDedupeKey)
+
+var webhookSources = map[string]bool{"github": true, "gitea": true, "gitlab": true}
+
+var webhookParameterRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+var webhookListener = struct {
+	sync.Mutex
+	server *http.Server
+	config WebhookListenerConfig
+}{}
+
+func validateWebhookTrigger(t *WebhookTrigger) error {
+	t.Source = strings.ToLower(strings.TrimSpace(t.Source))
+	if !webhookSources[t.Source] {
+		return fmt.Errorf("Source must be github, gitea or gitlab, not '%s'", t.Source)
+	}
+	if t.Secret == "" {
+		return fmt.Errorf("Secret is required")
+	}
+	if t.Branch != "" {
+		if _, err := path.Match(t.Branch, ""); err != nil {
+			return fmt.Errorf("invalid Branch pattern '%s': %v", t.Branch, err)
+		}
+	}
+	for name := range t.Parameters {
+		if !webhookParameterRe.MatchString(name) || strings.HasPrefix(strings.ToUpper(name), "GOPHER_") {
+			return fmt.Errorf("invalid parameter name '%s'", name)
+		}
+	}
+	return nil
+}
+
+func normalizeWebhookListenerConfig(cfg WebhookListenerConfig) WebhookListenerConfig {
+	cfg.Address = strings.TrimSpace(cfg.Address)
+	cfg.Path = strings.TrimSpace(cfg.Path)
+	if cfg.Path == "" {
+		cfg.Path = defaultWebhookPath
+	}
+	if !strings.HasPrefix(cfg.Path, "/") {
+		cfg.Path = "/" + cfg.Path
+	}
+	if cfg.MaxBodySize <= 0 {
+		cfg.MaxBodySize = defaultWebhookMaxBodySize
+	}
+	if cfg.DedupeSeconds <= 0 {
+		cfg.DedupeSeconds = int(defaultWebhookDedupe / time.Second)
+	}
+	return cfg
+}
+
+// startWebhookListener starts the listener when WebhookListener.Address is
+// configured; like queue providers, it starts only after the robot is fully
+// initialized.
+func startWebhookListener() {
+	currentCfg.RLock()
+	cfg := currentCfg.webhookListener
+	currentCfg.RUnlock()
+	if cfg.Address == "" {
+		return
+	}
+	webhookListener.Lock()
+	defer webhookListener.Unlock()
+	if webhookListener.server != nil {
+		return
+	}
+	listener, err := net.Listen("tcp", cfg.Address)
+	if err != nil {
+		Log(robot.Error, "Webhook listener unable to listen on '%s': %v", cfg.Address, err)
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(cfg.Path, serveWebhook)
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+	}
+	webhookListener.server = server
+	webhookListener.config = cfg
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			Log(robot.Error, "Webhook listener on '%s' stopped: %v", cfg.Address, err)
+		}
+	}()
+	Log(robot.Info, "Webhook listener accepting POSTs on http://%s%s", listener.Addr(), cfg.Path)
+}
+
+// shutdownWebhookListener stops accepting webhooks; it is called before
+// waiting for pipelines, like shutdownQueueProviderRuntimes.
+func shutdownWebhookListener() {
+	webhookListener.Lock()
+	server := webhookListener.server
+	webhookListener.server = nil
+	webhookListener.config = WebhookListenerConfig{}
+	webhookListener.Unlock()
+	if server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), webhookShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		Log(robot.Warn, "Stopping webhook listener: %v", err)
+	}
+	Log(robot.Info, "Webhook listener stopped")
+}
+
+// reconcileWebhookListener restarts the listener after a reload that changed
+// WebhookListener. Trigger changes need no restart; each request reads the
+// current job list.
+func reconcileWebhookListener() {
+	currentCfg.RLock()
+	cfg := currentCfg.webhookListener
+	currentCfg.RUnlock()
+	webhookListener.Lock()
+	running := webhookListener.server != nil
+	same := webhookListener.config == cfg
+	webhookListener.Unlock()
+	if running && same {
+		return
+	}
+	shutdownWebhookListener()
+	startWebhookListener()
+}
+
+func serveWebhook(rw http.ResponseWriter, r *http.Request) {
+	webhookListener.Lock()
+	cfg := webhookListener.config
+	webhookListener.Unlock()
+	if r.URL.Path != cfg.Path {
+		http.NotFound(rw, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	state.RLock()
+	shuttingDown := state.shuttingDown
+	state.RUnlock()
+	if shuttingDown {
+		http.Error(rw, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, int64(cfg.MaxBodySize)+1))
+	if err != nil {
+		http.Error(rw, "unable to read body", http.StatusBadRequest)
+		return
+	}
+	if len(body) > cfg.MaxBodySize {
+		Log(robot.Warn, "Webhook from %s rejected: body exceeds MaxBodySize of %d bytes", r.RemoteAddr, cfg.MaxBodySize)
+		http.Error(rw, "payload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	d, err := parseWebhookDelivery(r.Header, body)
+	if err != nil {
+		Log(robot.Warn, "Webhook from %s rejected: %v", r.RemoteAddr, err)
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	status, msg := dispatchWebhook(d, time.Duration(cfg.DedupeSeconds)*time.Second)
+	http.Error(rw, msg, status)
+}
+
+// parseWebhookDelivery identifies the sending forge and delivery from the
+// headers. Gitea also sends GitHub's headers, so it is checked first.
+func parseWebhookDelivery(header http.Header, body []byte) (*webhookDelivery, error) {
+	d := &webhookDelivery{header: header, body: body, received: time.Now()}
+	switch {
+	case header.Get("X-Gitea-Event") != "":
+		d.source, d.event, d.id = "gitea", header.Get("X-Gitea-Event"), header.Get("X-Gitea-Delivery")
+	case header.Get("X-GitHub-Event") != "":
+		d.source, d.event, d.id = "github", header.Get("X-GitHub-Event"), header.Get("X-GitHub-Delivery")
+	case header.Get("X-Gitlab-Event") != "":
+		d.source, d.event, d.id = "gitlab", header.Get("X-Gitlab-Event"), header.Get("X-Gitlab-Event-UUID")
+	default:
+		return nil, fmt.Errorf("unrecognized webhook source")
+	}
+	return d, nil
+}
+
+// decode parses the JSON payload of an authenticated delivery.
+func (d *webhookDelivery) decode() error {
+	dec := json.NewDecoder(bytes.NewReader(d.body))
+	dec.UseNumber()
+	if err := dec.Decode(&d.payload); err != nil {
+		return fmt.Errorf("invalid JSON payload: %v", err)
+	}
+	switch d.source {
+	case "gitlab":
+		// GitLab's header is e.g. "Push Hook"; object_kind is "push".
+		if kind, ok := webhookField(d.payload, "object_kind"); ok && kind != "" {
+			d.event = kind
+		}
+		d.repo, _ = webhookField(d.payload, "project.path_with_namespace")
+		d.branch, _ = webhookField(d.payload, "object_attributes.target_branch")
+	default:
+		d.repo, _ = webhookField(d.payload, "repository.full_name")
+		d.branch, _ = webhookField(d.payload, "pull_request.base.ref")
+	}
+	if ref, ok := webhookField(d.payload, "ref"); ok && strings.HasPrefix(ref, "refs/heads/") {
+		d.branch = strings.TrimPrefix(ref, "refs/heads/")
+	}
+	return nil
+}
+
+// seenBefore records the delivery in the persisted webhook dedupe window,
+// reporting whether it was already there. The delivery ID header isn't
+// covered by the signature, so the payload digest is remembered as well.
+func (d *webhookDelivery) seenBefore(retention time.Duration) bool {
+	sum := sha256.Sum256(d.body)
+	return webhookDedupe.record([]string{
+		"webhook:" + d.source + ":delivery:" + d.id,
+		"webhook:" + d.source + ":payload:" + hex.EncodeToString(sum[:]),
+	}, d.received, d.received.Add(retention))
+}
+
+// authenticated checks the delivery against a trigger's secret.
+func (d *webhookDelivery) authenticated(secret string) bool {
+	switch d.source {
+	case "gitlab":
+		token := d.header.Get("X-Gitlab-Token")
+		return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+	case "gitea":
+		if sig := d.header.Get("X-Gitea-Signature"); sig != "" {
+			return validWebhookHMAC(secret, d.body, sig)
+		}
+	}
+	sig, ok := strings.CutPrefix(d.header.Get("X-Hub-Signature-256"), "sha256=")
+	return ok && validWebhookHMAC(secret, d.body, sig)
+}
+
+func validWebhookHMAC(secret string, body []byte, signature string) bool {
+	got, err := hex.DecodeString(strings.TrimSpace(signature))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// matches applies a trigger's event, repository and branch filters.
+func (d *webhookDelivery) matches(t WebhookTrigger) bool {
+	if len(t.Events) > 0 {
+		found := false
+		for _, event := range t.Events {
+			if strings.EqualFold(event, d.event) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if t.Repository != "" && !strings.EqualFold(t.Repository, d.repo) {
+		return false
+	}
+	if t.Branch != "" {
+		if d.branch == "" {
+			return false
+		}
+		if ok, _ := path.Match(t.Branch, d.branch); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// webhookField follows a dotted path through the payload; numeric segments
+// index arrays. Objects and arrays are returned as compact JSON.
+func webhookField(payload interface{}, field string) (string, bool) {
+	cur := payload
+	for _, part := range strings.Split(field, ".") {
+		switch v := cur.(type) {
+		case map[string]interface{}:
+			next, ok := v[part]
+			if !ok {
+				return "", false
+			}
+			cur = next
+		case []interface{}:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(v) {
+				return "", false
+			}
+			cur = v[i]
+		default:
+			return "", false
+		}
+	}
+	switch v := cur.(type) {
+	case nil:
+		return "", true
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return "", false
+		}
+		return string(raw), true
+	}
+}
+
+// webhookCandidate is an enabled job with the triggers that authenticated a
+// delivery.
+type webhookCandidate struct {
+	item     interface{}
+	task     *Task
+	job      *Job
+	triggers []WebhookTrigger
+}
+
+// dispatchWebhook starts every enabled job with a trigger that authenticates
+// and matches the delivery, returning the HTTP status and message. The
+// payload isn't decoded until a secret verifies it, and repeated deliveries
+// within the dedupe retention are discarded.
+func dispatchWebhook(d *webhookDelivery, retention time.Duration) (int, string) {
+	currentCfg.RLock()
+	cfg := currentCfg.configuration
+	tasks := currentCfg.taskList
+	protocol := currentCfg.defaultProtocol
+	if protocol == "" {
+		protocol = currentCfg.protocol
+	}
+	currentCfg.RUnlock()
+
+	var candidates []webhookCandidate
+	for _, t := range tasks.t[1:] {
+		task, _, job := getTask(t)
+		if job == nil || task.Disabled || len(job.WebhookTriggers) == 0 {
+			continue
+		}
+		c := webhookCandidate{item: t, task: task, job: job}
+		for _, trigger := range job.WebhookTriggers {
+			if trigger.Source == d.source && d.authenticated(trigger.Secret) {
+				c.triggers = append(c.triggers, trigger)
+			}
+		}
+		if len(c.triggers) > 0 {
+			candidates = append(candidates, c)
+		}
+	}
+	if len(candidates) == 0 {
+		Log(robot.Warn, "Webhook %s event '%s' (delivery '%s') matched no trigger secret", d.source, d.event, d.id)
+		return http.StatusUnauthorized, "unauthorized"
+	}
+	if d.id == "" {
+		Log(robot.Warn, "Webhook %s event '%s' rejected: no delivery ID header", d.source, d.event)
+		return http.StatusBadRequest, "missing delivery ID"
+	}
+	if err := d.decode(); err != nil {
+		Log(robot.Warn, "Webhook %s event '%s' (delivery '%s') rejected: %v", d.source, d.event, d.id, err)
+		return http.StatusBadRequest, err.Error()
+	}
+	if d.seenBefore(retention) {
+		Log(robot.Info, "Webhook %s event '%s' (delivery '%s') discarded as a duplicate delivery", d.source, d.event, d.id)
+		return http.StatusOK, "duplicate delivery"
+	}
+
+	started := 0
+	for _, c := range candidates {
+		task, job, t := c.task, c.job, c.item
+		for _, trigger := range c.triggers {
+			if !d.matches(trigger) {
+				continue
+			}
+			args, params, err := webhookJobInputs(d, job, trigger)
+			if err != nil {
+				Log(robot.Error, "Webhook %s event '%s' (delivery '%s') not started for job '%s': %v", d.source, d.event, d.id, task.name, err)
+				break
+			}
+			Log(robot.Info, "Job '%s' triggered by %s webhook event '%s' (delivery '%s')", task.name, d.source, d.event, d.id)
+			w := &worker{
+				Channel:       task.Channel,
+				Protocol:      getProtocol(protocol),
+				Incoming:      &robot.ConnectorMessage{Protocol: protocol},
+				cfg:           cfg,
+				id:            getWorkerID(),
+				tasks:         tasks,
+				automaticTask: true,
+				webhook: &webhookOrigin{
+					source:     d.source,
+					event:      d.event,
+					delivery:   d.id,
+					parameters: params,
+				},
+			}
+			go w.startPipeline(nil, t, webhookJob, "run", args...)
+			started++
+			// One run per job, even if several triggers match.
+			break
+		}
+	}
+	if started == 0 {
+		Log(robot.Debug, "Webhook %s event '%s' (delivery '%s') matched no trigger filters", d.source, d.event, d.id)
+		return http.StatusOK, "no matching jobs"
+	}
+	return http.StatusAccepted, fmt.Sprintf("started %d job(s)", started)
+}
+
+// webhookJobInputs extracts a trigger's arguments and parameters, checking
+// arguments against the job's matchers the same way queue triggers do.
+func webhookJobInputs(d *webhookDelivery, job *Job, trigger WebhookTrigger) ([]string, map[string]string, error) {
+	args := make([]string, 0, len(trigger.Arguments))
+	for _, field := range trigger.Arguments {
+		value, ok := webhookField(d.payload, field)
+		if !ok {
+			return nil, nil, fmt.Errorf("payload has no field '%s'", field)
+		}
+		args = append(args, value)
+	}
+	if len(args) < len(job.Arguments) {
+		return nil, nil, fmt.Errorf("%d argument(s) required but %d configured", len(job.Arguments), len(args))
+	}
+	for i, jobarg := range job.Arguments {
+		if !jobarg.re.MatchString(args[i]) {
+			return nil, nil, fmt.Errorf("argument %d did not match configured argument pattern", i+1)
+		}
+	}
+	params := make(map[string]string, len(trigger.Parameters))
+	for name, field := range trigger.Parameters {
+		params[name], _ = webhookField(d.payload, field)
+	}
+	return args, params, nil
+}
@@ -0,0 +1,265 @@
+package bot
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testWebhookPush = `{
+  "ref": "refs/heads/release/1.2",
+  "after": "0123abcd",
+  "repository": {"full_name": "lnxjedi/gopherbot", "id": 42},
+  "commits": [{"id": "c1", "message": "first"}, {"id": "c2"}],
+  "pusher": {"name": "parsley", "admin": true}
+}`
+
+func signWebhook(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestParseWebhookDeliverySources(t *testing.T) {
+	github := http.Header{}
+	github.Set("X-GitHub-Event", "push")
+	github.Set("X-GitHub-Delivery", "d-1")
+	d, err := parseWebhookDelivery(github, []byte(testWebhookPush))
+	if err != nil {
+		t.Fatalf("parseWebhookDelivery(github) error = %v", err)
+	}
+	if err := d.decode(); err != nil {
+		t.Fatalf("decode(github) error = %v", err)
+	}
+	if d.source != "github" || d.event != "push" || d.id != "d-1" || d.repo != "lnxjedi/gopherbot" || d.branch != "release/1.2" {
+		t.Fatalf("github delivery = %+v", d)
+	}
+
+	// Gitea sends GitHub's headers too.
+	gitea := github.Clone()
+	gitea.Set("X-Gitea-Event", "push")
+	if d, _ := parseWebhookDelivery(gitea, []byte(testWebhookPush)); d.source != "gitea" {
+		t.Fatalf("gitea delivery source = %q", d.source)
+	}
+
+	gitlab := http.Header{}
+	gitlab.Set("X-Gitlab-Event", "Merge Request Hook")
+	body := `{"object_kind": "merge_request", "project": {"path_with_namespace": "group/app"}, "object_attributes": {"target_branch": "main"}}`
+	d, err = parseWebhookDelivery(gitlab, []byte(body))
+	if err != nil {
+		t.Fatalf("parseWebhookDelivery(gitlab) error = %v", err)
+	}
+	if err := d.decode(); err != nil {
+		t.Fatalf("decode(gitlab) error = %v", err)
+	}
+	if d.event != "merge_request" || d.repo != "group/app" || d.branch != "main" {
+		t.Fatalf("gitlab delivery = %+v", d)
+	}
+
+	if _, err := parseWebhookDelivery(http.Header{}, []byte(testWebhookPush)); err == nil {
+		t.Fatal("delivery without forge headers was accepted")
+	}
+	if d, _ := parseWebhookDelivery(github, []byte("not json")); d.decode() == nil {
+		t.Fatal("delivery with invalid JSON was accepted")
+	}
+}
+
+func TestWebhookAuthentication(t *testing.T) {
+	const secret = "webhook-shared-secret"
+	sig := signWebhook(secret, testWebhookPush)
+	tests := []struct {
+		name, event, header, value string
+		want                       bool
+	}{
+		{"github", "X-GitHub-Event", "X-Hub-Signature-256", "sha256=" + sig, true},
+		{"github without prefix", "X-GitHub-Event", "X-Hub-Signature-256", sig, false},
+		{"github wrong secret", "X-GitHub-Event", "X-Hub-Signature-256", "sha256=" + signWebhook("other", testWebhookPush), false},
+		{"gitea", "X-Gitea-Event", "X-Gitea-Signature", sig, true},
+		{"gitlab token", "X-Gitlab-Event", "X-Gitlab-Token", secret, true},
+		{"gitlab wrong token", "X-Gitlab-Event", "X-Gitlab-Token", "nope", false},
+	}
+	for _, tc := range tests {
+		header := http.Header{}
+		header.Set(tc.event, "push")
+		header.Set(tc.header, tc.value)
+		d, err := parseWebhookDelivery(header, []byte(testWebhookPush))
+		if err != nil {
+			t.Fatalf("%s: parseWebhookDelivery error = %v", tc.name, err)
+		}
+		if got := d.authenticated(secret); got != tc.want {
+			t.Fatalf("%s: authenticated = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestWebhookTriggerFiltersAndFields(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-GitHub-Event", "push")
+	d, _ := parseWebhookDelivery(header, []byte(testWebhookPush))
+	if err := d.decode(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		trigger WebhookTrigger
+		want    bool
+	}{
+		{WebhookTrigger{}, true},
+		{WebhookTrigger{Events: []string{"pull_request", "Push"}}, true},
+		{WebhookTrigger{Events: []string{"release"}}, false},
+		{WebhookTrigger{Repository: "LNXJEDI/gopherbot", Branch: "release/*"}, true},
+		{WebhookTrigger{Repository: "lnxjedi/other"}, false},
+		{WebhookTrigger{Branch: "main"}, false},
+	} {
+		if got := d.matches(tc.trigger); got != tc.want {
+			t.Fatalf("matches(%+v) = %v, want %v", tc.trigger, got, tc.want)
+		}
+	}
+
+	for field, want := range map[string]string{
+		"after":              "0123abcd",
+		"repository.id":      "42",
+		"commits.1.id":       "c2",
+		"pusher.admin":       "true",
+		"commits.0":          `{"id":"c1","message":"first"}`,
+		"repository.missing": "",
+	} {
+		got, ok := webhookField(d.payload, field)
+		if field == "repository.missing" {
+			if ok {
+				t.Fatalf("webhookField(%q) found a value", field)
+			}
+			continue
+		}
+		if !ok || got != want {
+			t.Fatalf("webhookField(%q) = %q, %v; want %q", field, got, ok, want)
+		}
+	}
+
+	job := &Job{}
+	args, params, err := webhookJobInputs(d, job, WebhookTrigger{
+		Arguments:  []string{"repository.full_name", "after"},
+		Parameters: map[string]string{"PUSHER": "pusher.name"},
+	})
+	if err != nil || strings.Join(args, " ") != "lnxjedi/gopherbot 0123abcd" || params["PUSHER"] != "parsley" {
+		t.Fatalf("webhookJobInputs = %v, %v, %v", args, params, err)
+	}
+	if _, _, err := webhookJobInputs(d, job, WebhookTrigger{Arguments: []string{"head_commit.id"}}); err == nil {
+		t.Fatal("missing argument field was accepted")
+	}
+}
+
+func TestValidateWebhookTrigger(t *testing.T) {
+	good := WebhookTrigger{Source: " GitHub ", Secret: "s", Branch: "release/*", Parameters: map[string]string{"COMMIT": "after"}}
+	if err := validateWebhookTrigger(&good); err != nil || good.Source != "github" {
+		t.Fatalf("validateWebhookTrigger(good) = %v, source %q", err, good.Source)
+	}
+	for name, trigger := range map[string]WebhookTrigger{
+		"source":    {Source: "bitbucket", Secret: "s"},
+		"secret":    {Source: "gitea"},
+		"branch":    {Source: "gitea", Secret: "s", Branch: "["},
+		"parameter": {Source: "gitea", Secret: "s", Parameters: map[string]string{"GOPHER_USER": "sender.login"}},
+	} {
+		if err := validateWebhookTrigger(&trigger); err == nil {
+			t.Fatalf("%s: invalid trigger accepted", name)
+		}
+	}
+}
+
+func TestServeWebhookStatuses(t *testing.T) {
+	webhookListener.Lock()
+	webhookListener.config = normalizeWebhookListenerConfig(WebhookListenerConfig{})
+	webhookListener.Unlock()
+	t.Cleanup(func() {
+		webhookListener.Lock()
+		webhookListener.config = WebhookListenerConfig{}
+		webhookListener.Unlock()
+	})
+
+	for _, tc := range []struct {
+		method, path string
+		header       map[string]string
+		body         string
+		want         int
+	}{
+		{http.MethodGet, "/webhooks", nil, "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/webhooks", nil, testWebhookPush, http.StatusBadRequest},
+		{http.MethodPost, "/webhooks/extra", nil, testWebhookPush, http.StatusNotFound},
+		// No job has a trigger with this secret.
+		{http.MethodPost, "/webhooks", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + signWebhook("x", testWebhookPush)}, testWebhookPush, http.StatusUnauthorized},
+		{http.MethodPost, "/webhooks", map[string]string{"X-GitHub-Event": "push"}, strings.Repeat(" ", defaultWebhookMaxBodySize+1), http.StatusRequestEntityTooLarge},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		for k, v := range tc.header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		serveWebhook(rec, req)
+		if rec.Code != tc.want {
+			t.Fatalf("%s %s: status = %d, want %d (%s)", tc.method, tc.path, rec.Code, tc.want, rec.Body.String())
+		}
+	}
+}
+
+func TestDispatchWebhookAuthenticatesThenDedupes(t *testing.T) {
+	const secret = "webhook-shared-secret"
+	setQueueTriggersForTest(t, QueueTriggersConfig{})
+	job := &Job{
+		Task:            &Task{name: "deploy"},
+		WebhookTriggers: []WebhookTrigger{{Source: "github", Secret: secret, Events: []string{"release"}}},
+	}
+	currentCfg.Lock()
+	origTasks := currentCfg.taskList
+	currentCfg.taskList = &taskList{t: []interface{}{struct{}{}, job}}
+	currentCfg.Unlock()
+	t.Cleanup(func() {
+		currentCfg.Lock()
+		currentCfg.taskList = origTasks
+		currentCfg.Unlock()
+	})
+
+	deliver := func(id, body, signature string) (int, string) {
+		header := http.Header{}
+		header.Set("X-GitHub-Event", "push")
+		if id != "" {
+			header.Set("X-GitHub-Delivery", id)
+		}
+		header.Set("X-Hub-Signature-256", "sha256="+signature)
+		d, err := parseWebhookDelivery(header, []byte(body))
+		if err != nil {
+			t.Fatalf("parseWebhookDelivery error = %v", err)
+		}
+		return dispatchWebhook(d, time.Hour)
+	}
+	for _, tc := range []struct {
+		name, id, body, signature string
+		status                    int
+		msg                       string
+	}{
+		{"unsigned garbage", "d-0", "not json", signWebhook("wrong", "not json"), http.StatusUnauthorized, "unauthorized"},
+		{"signed garbage", "d-0", "not json", signWebhook(secret, "not json"), http.StatusBadRequest, "invalid JSON"},
+		{"no delivery ID", "", testWebhookPush, signWebhook(secret, testWebhookPush), http.StatusBadRequest, "missing delivery ID"},
+		{"first delivery", "d-1", testWebhookPush, signWebhook(secret, testWebhookPush), http.StatusOK, "no matching jobs"},
+		{"redelivery", "d-1", testWebhookPush, signWebhook(secret, testWebhookPush), http.StatusOK, "duplicate delivery"},
+		{"replay with a new ID", "d-2", testWebhookPush, signWebhook(secret, testWebhookPush), http.StatusOK, "duplicate delivery"},
+		{"next event", "d-3", testWebhookPush + " ", signWebhook(secret, testWebhookPush+" "), http.StatusOK, "no matching jobs"},
+	} {
+		status, msg := deliver(tc.id, tc.body, tc.signature)
+		if status != tc.status || !strings.Contains(msg, tc.msg) {
+			t.Fatalf("%s: dispatchWebhook = %d %q, want %d %q", tc.name, status, msg, tc.status, tc.msg)
+		}
+	}
+	webhookDedupe.Lock()
+	webhookEntries := len(webhookDedupe.seen)
+	webhookDedupe.Unlock()
+	queueDedupe.Lock()
+	queueEntries := len(queueDedupe.seen)
+	queueDedupe.Unlock()
+	if webhookEntries != 4 || queueEntries != 0 {
+		t.Fatalf("dedupe entries: webhook %d, queue %d; want 4 and 0", webhookEntries, queueEntries)
+	}
+}
@@ -41,6 +41,7 @@ type cfKVEnvelope struct {
 	Checksum  string    `json:"checksum"`
 	Deleted   bool      `json:"deleted"`
 	UpdatedAt time.Time `json:"updated_at"`
+	KeyID     string    `json:"key_id,omitempty"`
 }
 
 func remoteProvider(r robot.Handler) robot.RemoteBrainBackend {
@@ -256,6 +257,7 @@ func encodeEnvelope(record robot.RemoteBrainRecord) ([]byte, error) {
 		Checksum:  record.Checksum,
 		Deleted:   record.Deleted,
 		UpdatedAt: record.UpdatedAt,
+		KeyID:     record.KeyID,
 	}
 	if env.UpdatedAt.IsZero() {
 		env.UpdatedAt = time.Now().UTC()
@@ -283,6 +285,7 @@ func decodeEnvelope(key string, data []byte) (robot.RemoteBrainRecord, error) {
 		Checksum:  env.Checksum,
 		Deleted:   env.Deleted,
 		UpdatedAt: env.UpdatedAt,
+		KeyID:     env.KeyID,
 	}, nil
 }
 
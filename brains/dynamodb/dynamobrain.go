@@ -34,6 +34,7 @@ type dynaMemory struct {
 	Checksum  string
 	Deleted   bool
 	UpdatedAt string
+	KeyID     string `dynamodbav:",omitempty"`
 }
 
 var dynamocfg brainConfig
@@ -271,6 +272,7 @@ func (db *dynamoRemoteBrain) Get(ctx context.Context, key string) (robot.RemoteB
 		Checksum:  m.Checksum,
 		Deleted:   m.Deleted,
 		UpdatedAt: updatedAt,
+		KeyID:     m.KeyID,
 	}, true, nil
 }
 
@@ -283,6 +285,7 @@ func (db *dynamoRemoteBrain) Put(ctx context.Context, record robot.RemoteBrainRe
 		Checksum:  record.Checksum,
 		Deleted:   record.Deleted,
 		UpdatedAt: record.UpdatedAt.Format(time.RFC3339Nano),
+		KeyID:     record.KeyID,
 	})
 	if err != nil {
 		return err
@@ -317,6 +320,7 @@ func (db *dynamoRemoteBrain) ListMetadata(ctx context.Context, cursor string, li
 			Checksum:  m.Checksum,
 			Deleted:   m.Deleted,
 			UpdatedAt: updatedAt,
+			KeyID:     m.KeyID,
 		})
 	}
 	return robot.RemoteBrainPage{Records: records}, nil
@@ -334,7 +338,7 @@ func dynamoListKeysScanInput(tableName string) *dynamodb.ScanInput {
 }
 
 func dynamoListMetadataScanInput(tableName string) *dynamodb.ScanInput {
-	expr := "#memory, #format, #version, #checksum, #deleted, #updatedAt"
+	expr := "#memory, #format, #version, #checksum, #deleted, #updatedAt, #keyID"
 	return &dynamodb.ScanInput{
 		ProjectionExpression: &expr,
 		ExpressionAttributeNames: map[string]string{
@@ -344,6 +348,7 @@ func dynamoListMetadataScanInput(tableName string) *dynamodb.ScanInput {
 			"#checksum":  "Checksum",
 			"#deleted":   "Deleted",
 			"#updatedAt": "UpdatedAt",
+			"#keyID":     "KeyID",
 		},
 		TableName: aws.String(tableName),
 	}
@@ -30,6 +30,7 @@ type storedMemory struct {
 	Checksum  string    `firestore:"checksum"`
 	Deleted   bool      `firestore:"deleted"`
 	UpdatedAt time.Time `firestore:"updated_at"`
+	KeyID     string    `firestore:"key_id,omitempty"`
 }
 
 const maxFirestoreBrainVersion = uint64(1<<63 - 1)
@@ -50,6 +51,7 @@ func storedMemoryFromRemoteRecord(record robot.RemoteBrainRecord) (storedMemory,
 		Checksum:  record.Checksum,
 		Deleted:   record.Deleted,
 		UpdatedAt: record.UpdatedAt,
+		KeyID:     record.KeyID,
 	}, nil
 }
 
@@ -65,6 +67,7 @@ func remoteRecordFromStoredMemory(key string, stored storedMemory) (robot.Remote
 		Checksum:  stored.Checksum,
 		Deleted:   stored.Deleted,
 		UpdatedAt: stored.UpdatedAt,
+		KeyID:     stored.KeyID,
 	}, nil
 }
 
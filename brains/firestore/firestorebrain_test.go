@@ -17,6 +17,7 @@ func TestFirestoreBrainVersionConversionUsesSignedInteger(t *testing.T) {
 		Checksum:  "abc123",
 		Deleted:   true,
 		UpdatedAt: updatedAt,
+		KeyID:     "0123abcd",
 	}
 
 	stored, err := storedMemoryFromRemoteRecord(record)
@@ -34,7 +35,7 @@ func TestFirestoreBrainVersionConversionUsesSignedInteger(t *testing.T) {
 	if roundTrip.Version != record.Version {
 		t.Fatalf("round-trip version = %d, want %d", roundTrip.Version, record.Version)
 	}
-	if roundTrip.Checksum != record.Checksum || roundTrip.Deleted != record.Deleted || !roundTrip.UpdatedAt.Equal(updatedAt) {
+	if roundTrip.Checksum != record.Checksum || roundTrip.Deleted != record.Deleted || !roundTrip.UpdatedAt.Equal(updatedAt) || roundTrip.KeyID != record.KeyID {
 		t.Fatalf("round-trip record = %+v, want metadata from %+v", roundTrip, record)
 	}
 }
@@ -0,0 +1,339 @@
+// Package redisbrain implements the v3 robot.RemoteBrainBackend contract on
+// Redis-compatible servers (Redis, Valkey, KeyDB), with optimistic
+// compare-and-set on record versions so two accidental replicas cannot
+// silently clobber each other's memories.
+package redisbrain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const brainCacheFormat = "gopherbot-brain-v3"
+
+// errVersionConflict is wrapped by Put and Delete when the stored version is
+// not older than the record being written, or when another client modified
+// the memory between WATCH and EXEC.
+var errVersionConflict = errors.New("redis brain version conflict")
+
+type brainConfig struct {
+	Address                 string
+	Username                string
+	Password                string
+	Database                int
+	KeyPrefix               string
+	TLS                     bool
+	TLSServerName           string
+	TLSInsecureSkipVerify   bool
+	OperationTimeoutSeconds int
+}
+
+type redisRemoteBrain struct {
+	cfg brainConfig
+
+	mu   sync.Mutex
+	conn *respConn
+}
+
+func defaultedConfig(cfg brainConfig) brainConfig {
+	cfg.Address = strings.TrimSpace(cfg.Address)
+	if cfg.Address == "" {
+		cfg.Address = "127.0.0.1:6379"
+	}
+	if cfg.KeyPrefix == "" {
+		cfg.KeyPrefix = "gopherbot:brain:"
+	}
+	if cfg.OperationTimeoutSeconds <= 0 {
+		cfg.OperationTimeoutSeconds = 15
+	}
+	return cfg
+}
+
+func remoteProvider(r robot.Handler) robot.RemoteBrainBackend {
+	var cfg brainConfig
+	if err := r.GetBrainConfig(&cfg); err != nil {
+		r.Log(robot.Fatal, "Unable to retrieve Redis brain configuration: %v", err)
+	}
+	cfg = defaultedConfig(cfg)
+	b := &redisRemoteBrain{cfg: cfg}
+	if err := b.ping(); err != nil {
+		r.Log(robot.Fatal, "Connecting to Redis brain at '%s': %v", cfg.Address, err)
+	}
+	r.Log(robot.Info, "Initialized Redis brain at '%s', database %d, key prefix '%s'", cfg.Address, cfg.Database, cfg.KeyPrefix)
+	return b
+}
+
+func (b *redisRemoteBrain) memoryKey(key string) string { return b.cfg.KeyPrefix + "mem:" + key }
+func (b *redisRemoteBrain) indexKey() string            { return b.cfg.KeyPrefix + "index" }
+
+// withConn runs fn on the shared connection, dialing if needed. Transactions
+// (WATCH/MULTI/EXEC) need connection affinity, so calls are serialized; any
+// I/O or protocol failure drops the connection for the next caller to redial.
+func (b *redisRemoteBrain) withConn(ctx context.Context, fn func(*respConn) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn == nil {
+		conn, err := dialRESP(b.cfg)
+		if err != nil {
+			return err
+		}
+		b.conn = conn
+	}
+	err := fn(b.conn)
+	var rerr respError
+	if err != nil && !errors.As(err, &rerr) && !errors.Is(err, errVersionConflict) {
+		b.conn.close()
+		b.conn = nil
+	}
+	return err
+}
+
+func (b *redisRemoteBrain) ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(b.cfg.OperationTimeoutSeconds)*time.Second)
+	defer cancel()
+	return b.withConn(ctx, func(c *respConn) error {
+		_, err := c.do("PING")
+		return err
+	})
+}
+
+func (b *redisRemoteBrain) Identity() robot.BrainBackendIdentity {
+	return robot.BrainBackendIdentity{
+		Provider: "redis",
+		Scope:    fmt.Sprintf("%s/%d/%s", b.cfg.Address, b.cfg.Database, b.cfg.KeyPrefix),
+	}
+}
+
+func (b *redisRemoteBrain) SyncPolicy() robot.BrainSyncPolicy {
+	return robot.BrainSyncPolicy{}
+}
+
+func (b *redisRemoteBrain) Get(ctx context.Context, key string) (robot.RemoteBrainRecord, bool, error) {
+	var reply interface{}
+	err := b.withConn(ctx, func(c *respConn) error {
+		var err error
+		reply, err = c.do("HGETALL", b.memoryKey(key))
+		return err
+	})
+	if err != nil {
+		return robot.RemoteBrainRecord{}, false, err
+	}
+	fields, err := hashFromReply(reply)
+	if err != nil {
+		return robot.RemoteBrainRecord{}, false, err
+	}
+	if len(fields) == 0 {
+		return robot.RemoteBrainRecord{}, false, nil
+	}
+	if fields["format"] != brainCacheFormat {
+		return robot.RemoteBrainRecord{Key: key}, true, fmt.Errorf("not a v3 brain record")
+	}
+	record, err := remoteRecordFromHash(key, fields)
+	if err != nil {
+		return robot.RemoteBrainRecord{Key: key}, true, err
+	}
+	record.Payload = []byte(fields["content"])
+	return record, true, nil
+}
+
+// Put writes the record only when it is newer than the stored version.
+// Re-sending the currently stored version and checksum is accepted, so a
+// retry after a lost reply is idempotent.
+func (b *redisRemoteBrain) Put(ctx context.Context, record robot.RemoteBrainRecord) error {
+	record.Format = brainCacheFormat
+	if record.UpdatedAt.IsZero() {
+		record.UpdatedAt = time.Now().UTC()
+	}
+	memKey := b.memoryKey(record.Key)
+	return b.withConn(ctx, func(c *respConn) error {
+		if _, err := c.do("WATCH", memKey); err != nil {
+			return err
+		}
+		reply, err := c.do("HMGET", memKey, "version", "checksum")
+		if err != nil {
+			return err
+		}
+		current, ok := reply.([]interface{})
+		if !ok || len(current) != 2 {
+			_, _ = c.do("UNWATCH")
+			return fmt.Errorf("redis protocol error: unexpected HMGET reply %#v", reply)
+		}
+		if storedVersion, exists := current[0].(string); exists {
+			version, err := strconv.ParseUint(storedVersion, 10, 64)
+			if err != nil {
+				_, _ = c.do("UNWATCH")
+				return fmt.Errorf("redis brain memory %s has invalid version %q", record.Key, storedVersion)
+			}
+			storedChecksum, _ := current[1].(string)
+			if version == record.Version && storedChecksum == record.Checksum {
+				_, err := c.do("UNWATCH")
+				return err
+			}
+			if version >= record.Version {
+				_, _ = c.do("UNWATCH")
+				return fmt.Errorf("%w: memory %s is at version %d, refusing to write version %d",
+					errVersionConflict, record.Key, version, record.Version)
+			}
+		}
+		deleted := "0"
+		content := string(record.Payload)
+		if record.Deleted {
+			deleted = "1"
+			content = ""
+		}
+		replies, err := c.pipeline([][]string{
+			{"MULTI"},
+			{"HSET", memKey,
+				"content", content,
+				"format", brainCacheFormat,
+				"version", strconv.FormatUint(record.Version, 10),
+				"checksum", record.Checksum,
+				"deleted", deleted,
+				"updated_at", record.UpdatedAt.UTC().Format(time.RFC3339Nano),
+				"key_id", record.KeyID,
+			},
+			{"ZADD", b.indexKey(), "0", record.Key},
+			{"EXEC"},
+		})
+		if err != nil {
+			return err
+		}
+		for _, reply := range replies {
+			if rerr, ok := reply.(respError); ok {
+				return rerr
+			}
+		}
+		if replies[len(replies)-1] == nil {
+			return fmt.Errorf("%w: memory %s was modified concurrently", errVersionConflict, record.Key)
+		}
+		return nil
+	})
+}
+
+func (b *redisRemoteBrain) Delete(ctx context.Context, tombstone robot.RemoteBrainRecord) error {
+	tombstone.Deleted = true
+	return b.Put(ctx, tombstone)
+}
+
+// ListMetadata pages through the lexically ordered key index; the cursor is
+// the last key returned on the previous page.
+func (b *redisRemoteBrain) ListMetadata(ctx context.Context, cursor string, limit int) (robot.RemoteBrainPage, error) {
+	if limit <= 0 {
+		limit = 1000
+	}
+	min := "-"
+	if cursor != "" {
+		min = "(" + cursor
+	}
+	var records []robot.RemoteBrainRecord
+	err := b.withConn(ctx, func(c *respConn) error {
+		reply, err := c.do("ZRANGEBYLEX", b.indexKey(), min, "+", "LIMIT", "0", strconv.Itoa(limit))
+		if err != nil {
+			return err
+		}
+		items, ok := reply.([]interface{})
+		if !ok {
+			return fmt.Errorf("redis protocol error: unexpected ZRANGEBYLEX reply %#v", reply)
+		}
+		keys := make([]string, 0, len(items))
+		cmds := make([][]string, 0, len(items))
+		for _, item := range items {
+			key, _ := item.(string)
+			keys = append(keys, key)
+			cmds = append(cmds, append([]string{"HMGET", b.memoryKey(key)}, redisMetadataFields...))
+		}
+		if len(cmds) == 0 {
+			return nil
+		}
+		replies, err := c.pipeline(cmds)
+		if err != nil {
+			return err
+		}
+		records = make([]robot.RemoteBrainRecord, 0, len(keys))
+		for i, reply := range replies {
+			values, ok := reply.([]interface{})
+			if !ok || len(values) != len(redisMetadataFields) {
+				return fmt.Errorf("redis protocol error: unexpected HMGET reply for %s", keys[i])
+			}
+			if values[1] == nil {
+				// Indexed but never written; skip rather than inventing metadata.
+				continue
+			}
+			fields := make(map[string]string, len(redisMetadataFields))
+			for j, name := range redisMetadataFields {
+				if s, ok := values[j].(string); ok {
+					fields[name] = s
+				}
+			}
+			record, err := remoteRecordFromHash(keys[i], fields)
+			if err != nil {
+				return err
+			}
+			records = append(records, record)
+		}
+		if len(items) == limit {
+			cursor = keys[len(keys)-1]
+		} else {
+			cursor = ""
+		}
+		return nil
+	})
+	if err != nil {
+		return robot.RemoteBrainPage{}, err
+	}
+	return robot.RemoteBrainPage{Records: records, NextCursor: cursor}, nil
+}
+
+func (b *redisRemoteBrain) Shutdown() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn != nil {
+		b.conn.close()
+		b.conn = nil
+	}
+}
+
+func hashFromReply(reply interface{}) (map[string]string, error) {
+	items, ok := reply.([]interface{})
+	if !ok || len(items)%2 != 0 {
+		return nil, fmt.Errorf("redis protocol error: unexpected HGETALL reply %#v", reply)
+	}
+	fields := make(map[string]string, len(items)/2)
+	for i := 0; i < len(items); i += 2 {
+		name, _ := items[i].(string)
+		value, _ := items[i+1].(string)
+		fields[name] = value
+	}
+	return fields, nil
+}
+
+// redisMetadataFields are the memory hash fields ListMetadata reads;
+// "version" must stay second, it marks a hash that was never written.
+var redisMetadataFields = []string{"format", "version", "checksum", "deleted", "updated_at", "key_id"}
+
+func remoteRecordFromHash(key string, fields map[string]string) (robot.RemoteBrainRecord, error) {
+	version, err := strconv.ParseUint(fields["version"], 10, 64)
+	if err != nil {
+		return robot.RemoteBrainRecord{Key: key}, fmt.Errorf("redis brain memory %s has invalid version %q", key, fields["version"])
+	}
+	updatedAt, _ := time.Parse(time.RFC3339Nano, fields["updated_at"])
+	return robot.RemoteBrainRecord{
+		Key:       key,
+		Format:    fields["format"],
+		Version:   version,
+		Checksum:  fields["checksum"],
+		Deleted:   fields["deleted"] == "1",
+		UpdatedAt: updatedAt,
+		KeyID:     fields["key_id"],
+	}, nil
+}
@@ -0,0 +1,179 @@
+package redisbrain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+func newTestBrain(t *testing.T, server *respStandIn, password string) *redisRemoteBrain {
+	t.Helper()
+	b := &redisRemoteBrain{cfg: defaultedConfig(brainConfig{
+		Address:  server.addr(),
+		Password: password,
+		Database: 2,
+	})}
+	if err := b.ping(); err != nil {
+		t.Fatalf("ping() error = %v", err)
+	}
+	t.Cleanup(b.Shutdown)
+	return b
+}
+
+func TestRedisBrainPutGetRoundTrip(t *testing.T) {
+	server := newRESPStandIn(t)
+	server.password = "s3cret"
+	b := newTestBrain(t, server, "s3cret")
+	ctx := context.Background()
+	updatedAt := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	payload := []byte{0x00, 0xff, '\r', '\n', 'x'}
+	if err := b.Put(ctx, robot.RemoteBrainRecord{
+		Key:       "links:links",
+		Payload:   payload,
+		Version:   3,
+		Checksum:  "abc",
+		UpdatedAt: updatedAt,
+		KeyID:     "0123abcd",
+	}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	got, exists, err := b.Get(ctx, "links:links")
+	if err != nil || !exists {
+		t.Fatalf("Get() exists=%v err=%v", exists, err)
+	}
+	if string(got.Payload) != string(payload) || got.Version != 3 || got.Checksum != "abc" || got.Deleted {
+		t.Fatalf("Get() = %+v", got)
+	}
+	if got.Format != brainCacheFormat || !got.UpdatedAt.Equal(updatedAt) || got.KeyID != "0123abcd" {
+		t.Fatalf("Get() format=%q updatedAt=%v keyID=%q", got.Format, got.UpdatedAt, got.KeyID)
+	}
+	page, err := b.ListMetadata(ctx, "", 10)
+	if err != nil || len(page.Records) != 1 || page.Records[0].KeyID != "0123abcd" {
+		t.Fatalf("ListMetadata() = %+v err=%v", page, err)
+	}
+	if _, exists, err := b.Get(ctx, "missing"); err != nil || exists {
+		t.Fatalf("Get(missing) exists=%v err=%v", exists, err)
+	}
+}
+
+func TestRedisBrainRejectsStaleVersionFromSecondReplica(t *testing.T) {
+	server := newRESPStandIn(t)
+	first := newTestBrain(t, server, "")
+	second := newTestBrain(t, server, "")
+	ctx := context.Background()
+	if err := first.Put(ctx, robot.RemoteBrainRecord{Key: "alpha", Payload: []byte("new"), Version: 5, Checksum: "new"}); err != nil {
+		t.Fatalf("first Put() error = %v", err)
+	}
+	err := second.Put(ctx, robot.RemoteBrainRecord{Key: "alpha", Payload: []byte("old"), Version: 4, Checksum: "old"})
+	if !errors.Is(err, errVersionConflict) {
+		t.Fatalf("second Put() error = %v, want version conflict", err)
+	}
+	err = second.Put(ctx, robot.RemoteBrainRecord{Key: "alpha", Payload: []byte("other"), Version: 5, Checksum: "other"})
+	if !errors.Is(err, errVersionConflict) {
+		t.Fatalf("same-version different-checksum Put() error = %v, want version conflict", err)
+	}
+	if err := second.Put(ctx, robot.RemoteBrainRecord{Key: "alpha", Payload: []byte("new"), Version: 5, Checksum: "new"}); err != nil {
+		t.Fatalf("idempotent retry Put() error = %v", err)
+	}
+	got, _, err := first.Get(ctx, "alpha")
+	if err != nil || string(got.Payload) != "new" || got.Version != 5 {
+		t.Fatalf("Get() = %+v err=%v, want version 5 payload new", got, err)
+	}
+}
+
+func TestRedisBrainDetectsConcurrentWriteBetweenWatchAndExec(t *testing.T) {
+	server := newRESPStandIn(t)
+	first := newTestBrain(t, server, "")
+	second := newTestBrain(t, server, "")
+	ctx := context.Background()
+	server.mu.Lock()
+	server.beforeExec = func() {
+		server.mu.Lock()
+		server.beforeExec = nil
+		server.mu.Unlock()
+		if err := second.Put(ctx, robot.RemoteBrainRecord{Key: "alpha", Payload: []byte("racer"), Version: 9, Checksum: "racer"}); err != nil {
+			t.Errorf("racing Put() error = %v", err)
+		}
+	}
+	server.mu.Unlock()
+	err := first.Put(ctx, robot.RemoteBrainRecord{Key: "alpha", Payload: []byte("mine"), Version: 8, Checksum: "mine"})
+	if !errors.Is(err, errVersionConflict) {
+		t.Fatalf("Put() error = %v, want concurrent-modification conflict", err)
+	}
+	got, _, err := first.Get(ctx, "alpha")
+	if err != nil || string(got.Payload) != "racer" {
+		t.Fatalf("Get() = %+v err=%v, want racing writer's payload", got, err)
+	}
+}
+
+func TestRedisBrainDeleteWritesTombstone(t *testing.T) {
+	server := newRESPStandIn(t)
+	b := newTestBrain(t, server, "")
+	ctx := context.Background()
+	if err := b.Put(ctx, robot.RemoteBrainRecord{Key: "alpha", Payload: []byte("one"), Version: 1, Checksum: "one"}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := b.Delete(ctx, robot.RemoteBrainRecord{Key: "alpha", Version: 2}); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	got, exists, err := b.Get(ctx, "alpha")
+	if err != nil || !exists || !got.Deleted || got.Version != 2 || len(got.Payload) != 0 {
+		t.Fatalf("tombstone = %+v exists=%v err=%v", got, exists, err)
+	}
+}
+
+func TestRedisBrainListMetadataPagesByKey(t *testing.T) {
+	server := newRESPStandIn(t)
+	b := newTestBrain(t, server, "")
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if err := b.Put(ctx, robot.RemoteBrainRecord{Key: key, Payload: []byte(key), Version: uint64(i + 1), Checksum: key}); err != nil {
+			t.Fatalf("Put(%s) error = %v", key, err)
+		}
+	}
+	var keys []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("ListMetadata() did not terminate")
+		}
+		page, err := b.ListMetadata(ctx, cursor, 2)
+		if err != nil {
+			t.Fatalf("ListMetadata() error = %v", err)
+		}
+		for _, record := range page.Records {
+			if record.Payload != nil || record.Format != brainCacheFormat {
+				t.Fatalf("ListMetadata() record = %+v", record)
+			}
+			keys = append(keys, fmt.Sprintf("%s@%d", record.Key, record.Version))
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	if got := strings.Join(keys, ","); got != "key-0@1,key-1@2,key-2@3,key-3@4,key-4@5" {
+		t.Fatalf("listed = %s", got)
+	}
+}
+
+func TestRedisBrainReconnectsAfterDroppedConnection(t *testing.T) {
+	server := newRESPStandIn(t)
+	b := newTestBrain(t, server, "")
+	b.mu.Lock()
+	b.conn.close()
+	b.mu.Unlock()
+	ctx := context.Background()
+	if _, _, err := b.Get(ctx, "alpha"); err == nil {
+		t.Fatal("Get() on closed connection succeeded")
+	}
+	if _, _, err := b.Get(ctx, "alpha"); err != nil {
+		t.Fatalf("Get() after redial error = %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+package redisbrain
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// respError is an error reply ("-ERR ...") from the server. It is returned
+// as a value so callers can distinguish server errors from I/O failures.
+type respError string
+
+func (e respError) Error() string { return string(e) }
+
+// respConn is a minimal RESP2 client connection; it implements only what the
+// brain backend needs, which keeps Redis, Valkey, KeyDB and Dragonfly all
+// usable without an extra client dependency.
+type respConn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	timeout time.Duration
+}
+
+func dialRESP(cfg brainConfig) (*respConn, error) {
+	timeout := time.Duration(cfg.OperationTimeoutSeconds) * time.Second
+	dialer := &net.Dialer{Timeout: timeout}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if cfg.TLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", cfg.Address, &tls.Config{
+			ServerName:         cfg.TLSServerName,
+			InsecureSkipVerify: cfg.TLSInsecureSkipVerify,
+		})
+	} else {
+		conn, err = dialer.Dial("tcp", cfg.Address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rc := &respConn{
+		conn:    conn,
+		r:       bufio.NewReader(conn),
+		w:       bufio.NewWriter(conn),
+		timeout: timeout,
+	}
+	if cfg.Password != "" {
+		args := []string{"AUTH", cfg.Password}
+		if cfg.Username != "" {
+			args = []string{"AUTH", cfg.Username, cfg.Password}
+		}
+		if _, err := rc.do(args...); err != nil {
+			rc.close()
+			return nil, fmt.Errorf("redis AUTH: %w", err)
+		}
+	}
+	if cfg.Database != 0 {
+		if _, err := rc.do("SELECT", strconv.Itoa(cfg.Database)); err != nil {
+			rc.close()
+			return nil, fmt.Errorf("redis SELECT %d: %w", cfg.Database, err)
+		}
+	}
+	return rc, nil
+}
+
+func (c *respConn) close() {
+	_ = c.conn.Close()
+}
+
+// do sends one command and reads one reply.
+func (c *respConn) do(args ...string) (interface{}, error) {
+	if c.timeout > 0 {
+		_ = c.conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+	if err := writeRESPCommand(c.w, args); err != nil {
+		return nil, err
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	return readRESPReply(c.r)
+}
+
+func writeRESPCommand(w *bufio.Writer, args []string) error {
+	if _, err := fmt.Fprintf(w, "*%d\r\n", len(args)); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if _, err := fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readRESPReply returns string for simple and bulk strings, int64 for
+// integers, []interface{} for arrays, nil for null replies, and respError
+// for error replies.
+func readRESPReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("redis protocol error: empty reply line")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, respError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis protocol error: bad bulk length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis protocol error: bad array length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		out := make([]interface{}, n)
+		for i := range out {
+			item, err := readRESPReply(r)
+			var rerr respError
+			if err != nil && !errors.As(err, &rerr) {
+				return nil, err
+			}
+			if err != nil {
+				out[i] = rerr
+				continue
+			}
+			out[i] = item
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("redis protocol error: unexpected reply %q", line)
+}
+
+func readRESPLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("redis protocol error: malformed line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+// pipeline sends several commands in one write and reads all replies in
+// order. Per-command error replies are returned in place as respError values.
+func (c *respConn) pipeline(cmds [][]string) ([]interface{}, error) {
+	if c.timeout > 0 {
+		_ = c.conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+	for _, cmd := range cmds {
+		if err := writeRESPCommand(c.w, cmd); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	out := make([]interface{}, len(cmds))
+	for i := range cmds {
+		reply, err := readRESPReply(c.r)
+		var rerr respError
+		if err != nil && !errors.As(err, &rerr) {
+			return nil, err
+		}
+		if err != nil {
+			out[i] = rerr
+			continue
+		}
+		out[i] = reply
+	}
+	return out, nil
+}
@@ -0,0 +1,261 @@
+package redisbrain
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// respStandIn is an in-process RESP2 server implementing just the commands
+// the brain backend uses, including WATCH/MULTI/EXEC semantics.
+type respStandIn struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	password string
+	hashes   map[string]map[string]string
+	zsets    map[string]map[string]bool
+	revision map[string]uint64
+	// beforeExec, when set, runs (unlocked) before each EXEC is applied,
+	// letting tests inject a concurrent writer.
+	beforeExec func()
+}
+
+func newRESPStandIn(t *testing.T) *respStandIn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &respStandIn{
+		ln:       ln,
+		hashes:   make(map[string]map[string]string),
+		zsets:    make(map[string]map[string]bool),
+		revision: make(map[string]uint64),
+	}
+	go s.serve()
+	t.Cleanup(func() { _ = ln.Close() })
+	return s
+}
+
+func (s *respStandIn) addr() string { return s.ln.Addr().String() }
+
+func (s *respStandIn) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+type standInSession struct {
+	authed  bool
+	watched map[string]uint64
+	queued  [][]string
+	inMulti bool
+}
+
+func (s *respStandIn) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	sess := &standInSession{watched: make(map[string]uint64)}
+	for {
+		args, err := readStandInCommand(r)
+		if err != nil {
+			return
+		}
+		s.dispatch(sess, args, w)
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func readStandInCommand(r *bufio.Reader) ([]string, error) {
+	reply, err := readRESPReply(r)
+	if err != nil {
+		return nil, err
+	}
+	items, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected array command")
+	}
+	args := make([]string, len(items))
+	for i, item := range items {
+		args[i], _ = item.(string)
+	}
+	return args, nil
+}
+
+func (s *respStandIn) dispatch(sess *standInSession, args []string, w *bufio.Writer) {
+	cmd := strings.ToUpper(args[0])
+	s.mu.Lock()
+	needAuth := s.password != "" && !sess.authed
+	s.mu.Unlock()
+	if needAuth && cmd != "AUTH" {
+		fmt.Fprint(w, "-NOAUTH Authentication required.\r\n")
+		return
+	}
+	if sess.inMulti && cmd != "EXEC" && cmd != "DISCARD" {
+		sess.queued = append(sess.queued, args)
+		fmt.Fprint(w, "+QUEUED\r\n")
+		return
+	}
+	switch cmd {
+	case "AUTH":
+		s.mu.Lock()
+		ok := args[len(args)-1] == s.password
+		s.mu.Unlock()
+		if !ok {
+			fmt.Fprint(w, "-WRONGPASS invalid password\r\n")
+			return
+		}
+		sess.authed = true
+		fmt.Fprint(w, "+OK\r\n")
+	case "WATCH":
+		s.mu.Lock()
+		for _, key := range args[1:] {
+			sess.watched[key] = s.revision[key]
+		}
+		s.mu.Unlock()
+		fmt.Fprint(w, "+OK\r\n")
+	case "UNWATCH":
+		sess.watched = make(map[string]uint64)
+		fmt.Fprint(w, "+OK\r\n")
+	case "MULTI":
+		sess.inMulti = true
+		sess.queued = nil
+		fmt.Fprint(w, "+OK\r\n")
+	case "DISCARD":
+		sess.inMulti = false
+		sess.queued = nil
+		sess.watched = make(map[string]uint64)
+		fmt.Fprint(w, "+OK\r\n")
+	case "EXEC":
+		s.mu.Lock()
+		hook := s.beforeExec
+		s.mu.Unlock()
+		if hook != nil {
+			hook()
+		}
+		s.mu.Lock()
+		dirty := false
+		for key, rev := range sess.watched {
+			if s.revision[key] != rev {
+				dirty = true
+			}
+		}
+		queued := sess.queued
+		sess.inMulti = false
+		sess.queued = nil
+		sess.watched = make(map[string]uint64)
+		if dirty {
+			s.mu.Unlock()
+			fmt.Fprint(w, "*-1\r\n")
+			return
+		}
+		fmt.Fprintf(w, "*%d\r\n", len(queued))
+		for _, q := range queued {
+			s.applyLocked(q, w)
+		}
+		s.mu.Unlock()
+	default:
+		s.mu.Lock()
+		s.applyLocked(args, w)
+		s.mu.Unlock()
+	}
+}
+
+func (s *respStandIn) applyLocked(args []string, w *bufio.Writer) {
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		fmt.Fprint(w, "+PONG\r\n")
+	case "SELECT":
+		fmt.Fprint(w, "+OK\r\n")
+	case "HSET":
+		h := s.hashes[args[1]]
+		if h == nil {
+			h = make(map[string]string)
+			s.hashes[args[1]] = h
+		}
+		added := 0
+		for i := 2; i+1 < len(args); i += 2 {
+			if _, ok := h[args[i]]; !ok {
+				added++
+			}
+			h[args[i]] = args[i+1]
+		}
+		s.revision[args[1]]++
+		fmt.Fprintf(w, ":%d\r\n", added)
+	case "HGETALL":
+		h := s.hashes[args[1]]
+		names := make([]string, 0, len(h))
+		for name := range h {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(w, "*%d\r\n", len(names)*2)
+		for _, name := range names {
+			writeStandInBulk(w, name)
+			writeStandInBulk(w, h[name])
+		}
+	case "HMGET":
+		h := s.hashes[args[1]]
+		fmt.Fprintf(w, "*%d\r\n", len(args)-2)
+		for _, name := range args[2:] {
+			if value, ok := h[name]; ok {
+				writeStandInBulk(w, value)
+			} else {
+				fmt.Fprint(w, "$-1\r\n")
+			}
+		}
+	case "ZADD":
+		z := s.zsets[args[1]]
+		if z == nil {
+			z = make(map[string]bool)
+			s.zsets[args[1]] = z
+		}
+		added := 0
+		for i := 3; i < len(args); i += 2 {
+			if !z[args[i]] {
+				added++
+			}
+			z[args[i]] = true
+		}
+		s.revision[args[1]]++
+		fmt.Fprintf(w, ":%d\r\n", added)
+	case "ZRANGEBYLEX":
+		members := make([]string, 0, len(s.zsets[args[1]]))
+		for member := range s.zsets[args[1]] {
+			if args[2] != "-" && member <= strings.TrimPrefix(args[2], "(") {
+				continue
+			}
+			members = append(members, member)
+		}
+		sort.Strings(members)
+		if len(args) == 7 && strings.ToUpper(args[4]) == "LIMIT" {
+			count, _ := strconv.Atoi(args[6])
+			if count < len(members) {
+				members = members[:count]
+			}
+		}
+		fmt.Fprintf(w, "*%d\r\n", len(members))
+		for _, member := range members {
+			writeStandInBulk(w, member)
+		}
+	default:
+		fmt.Fprintf(w, "-ERR unknown command '%s'\r\n", args[0])
+	}
+}
+
+func writeStandInBulk(w *bufio.Writer, s string) {
+	fmt.Fprintf(w, "$%d\r\n%s\r\n", len(s), s)
+}
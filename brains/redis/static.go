@@ -0,0 +1,7 @@
+package redisbrain
+
+import "github.com/lnxjedi/gopherbot/robot"
+
+func init() {
+	robot.RegisterRemoteBrain("redis", remoteProvider)
+}
@@ -0,0 +1,331 @@
+// Package s3brain implements the v3 robot.RemoteBrainBackend contract on an
+// S3-compatible bucket (AWS S3, MinIO, Ceph RGW, Cloudflare R2), storing one
+// object per memory and using ETag conditional writes so that two replicas
+// cannot silently overwrite each other's newer versions.
+package s3brain
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+	"github.com/lnxjedi/gopherbot/v2/internal/s3compat"
+)
+
+const brainCacheFormat = "gopherbot-brain-v3"
+
+// Record metadata is duplicated into x-amz-meta-* headers so ListMetadata
+// can use HEAD instead of downloading every payload.
+const (
+	metaFormat    = "gopherbot-format"
+	metaVersion   = "gopherbot-version"
+	metaChecksum  = "gopherbot-checksum"
+	metaDeleted   = "gopherbot-deleted"
+	metaUpdatedAt = "gopherbot-updated-at"
+	metaKeyID     = "gopherbot-key-id"
+)
+
+// errVersionConflict is wrapped by Put and Delete when the stored version is
+// not older than the record being written, or when the object changed
+// between the version check and the conditional write.
+var errVersionConflict = errors.New("s3 brain version conflict")
+
+type brainConfig struct {
+	Endpoint                string
+	Region                  string
+	Bucket                  string
+	Prefix                  string
+	VirtualHostStyle        bool
+	AccessKeyID             string
+	SecretAccessKey         string
+	SessionToken            string
+	OperationTimeoutSeconds int
+}
+
+type s3RemoteBrain struct {
+	cfg    brainConfig
+	client *s3compat.Client
+}
+
+// s3Envelope is the object body. The version is part of the body, so every
+// write of a new version changes the ETag even when the payload is unchanged.
+type s3Envelope struct {
+	Format    string    `json:"format"`
+	Payload   string    `json:"payload,omitempty"`
+	Version   uint64    `json:"version"`
+	Checksum  string    `json:"checksum"`
+	Deleted   bool      `json:"deleted"`
+	UpdatedAt time.Time `json:"updated_at"`
+	KeyID     string    `json:"key_id,omitempty"`
+}
+
+func defaultedConfig(cfg brainConfig) brainConfig {
+	cfg.Endpoint = strings.TrimSpace(cfg.Endpoint)
+	cfg.Bucket = strings.TrimSpace(cfg.Bucket)
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = "gopherbot-brain/"
+	}
+	if cfg.OperationTimeoutSeconds <= 0 {
+		cfg.OperationTimeoutSeconds = 15
+	}
+	if cfg.AccessKeyID == "" && cfg.SecretAccessKey == "" {
+		creds := s3compat.CredentialsFromEnv()
+		cfg.AccessKeyID = creds.AccessKeyID
+		cfg.SecretAccessKey = creds.SecretAccessKey
+		cfg.SessionToken = creds.SessionToken
+	}
+	return cfg
+}
+
+func newS3Brain(cfg brainConfig) (*s3RemoteBrain, error) {
+	client, err := s3compat.New(s3compat.Config{
+		Endpoint:         cfg.Endpoint,
+		Region:           cfg.Region,
+		Bucket:           cfg.Bucket,
+		VirtualHostStyle: cfg.VirtualHostStyle,
+		Credentials: s3compat.Credentials{
+			AccessKeyID:     cfg.AccessKeyID,
+			SecretAccessKey: cfg.SecretAccessKey,
+			SessionToken:    cfg.SessionToken,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s3RemoteBrain{cfg: cfg, client: client}, nil
+}
+
+func remoteProvider(r robot.Handler) robot.RemoteBrainBackend {
+	var cfg brainConfig
+	if err := r.GetBrainConfig(&cfg); err != nil {
+		r.Log(robot.Fatal, "Unable to retrieve S3 brain configuration: %v", err)
+	}
+	cfg = defaultedConfig(cfg)
+	b, err := newS3Brain(cfg)
+	if err != nil {
+		r.Log(robot.Fatal, "Invalid S3 brain configuration: %v", err)
+	}
+	ctx, cancel := b.opContext(context.Background())
+	defer cancel()
+	if _, err := b.client.ListObjects(ctx, cfg.Prefix, "", 1); err != nil {
+		r.Log(robot.Fatal, "Checking S3 brain bucket '%s' at '%s': %v", cfg.Bucket, cfg.Endpoint, err)
+	}
+	r.Log(robot.Info, "Initialized S3 brain bucket '%s' at '%s', prefix '%s'", cfg.Bucket, cfg.Endpoint, cfg.Prefix)
+	return b
+}
+
+func (b *s3RemoteBrain) opContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, time.Duration(b.cfg.OperationTimeoutSeconds)*time.Second)
+}
+
+func (b *s3RemoteBrain) objectKey(key string) string { return b.cfg.Prefix + key }
+
+func (b *s3RemoteBrain) Identity() robot.BrainBackendIdentity {
+	return robot.BrainBackendIdentity{
+		Provider: "s3",
+		Scope:    b.client.Endpoint() + "/" + b.cfg.Bucket + "/" + b.cfg.Prefix,
+	}
+}
+
+func (b *s3RemoteBrain) SyncPolicy() robot.BrainSyncPolicy {
+	return robot.BrainSyncPolicy{}
+}
+
+func (b *s3RemoteBrain) Get(ctx context.Context, key string) (robot.RemoteBrainRecord, bool, error) {
+	ctx, cancel := b.opContext(ctx)
+	defer cancel()
+	obj, exists, err := b.client.GetObject(ctx, b.objectKey(key))
+	if err != nil || !exists {
+		return robot.RemoteBrainRecord{}, exists, err
+	}
+	record, err := decodeEnvelope(key, obj.Body)
+	if err != nil {
+		return robot.RemoteBrainRecord{Key: key}, true, err
+	}
+	return record, true, nil
+}
+
+// Put writes the record only when it is newer than the stored version, using
+// If-Match on the ETag that was checked (or If-None-Match: * for a new key).
+// Re-sending the currently stored version and checksum is accepted, so a
+// retry after a lost reply is idempotent.
+func (b *s3RemoteBrain) Put(ctx context.Context, record robot.RemoteBrainRecord) error {
+	ctx, cancel := b.opContext(ctx)
+	defer cancel()
+	record.Format = brainCacheFormat
+	if record.UpdatedAt.IsZero() {
+		record.UpdatedAt = time.Now().UTC()
+	}
+	if record.Deleted {
+		record.Payload = nil
+	}
+	body, err := encodeEnvelope(record)
+	if err != nil {
+		return err
+	}
+	objectKey := b.objectKey(record.Key)
+	opts := s3compat.PutOptions{
+		ContentType: "application/json",
+		IfNoneMatch: "*",
+		Metadata:    recordMetadata(record),
+	}
+	current, exists, err := b.client.HeadObject(ctx, objectKey)
+	if err != nil {
+		return err
+	}
+	if exists {
+		stored, err := b.storedMetadata(ctx, record.Key, current)
+		if err != nil {
+			return err
+		}
+		if stored.Version == record.Version && stored.Checksum == record.Checksum {
+			return nil
+		}
+		if stored.Version >= record.Version {
+			return fmt.Errorf("%w: memory %s is at version %d, refusing to write version %d",
+				errVersionConflict, record.Key, stored.Version, record.Version)
+		}
+		opts.IfNoneMatch = ""
+		opts.IfMatch = current.ETag
+	}
+	if _, err := b.client.PutObject(ctx, objectKey, body, opts); err != nil {
+		if errors.Is(err, s3compat.ErrPreconditionFailed) {
+			return fmt.Errorf("%w: memory %s was modified concurrently", errVersionConflict, record.Key)
+		}
+		return err
+	}
+	return nil
+}
+
+func (b *s3RemoteBrain) Delete(ctx context.Context, tombstone robot.RemoteBrainRecord) error {
+	tombstone.Deleted = true
+	return b.Put(ctx, tombstone)
+}
+
+// ListMetadata pages through a ListObjectsV2 listing of the prefix; the
+// cursor is the service's continuation token.
+func (b *s3RemoteBrain) ListMetadata(ctx context.Context, cursor string, limit int) (robot.RemoteBrainPage, error) {
+	if limit <= 0 {
+		limit = 1000
+	}
+	listCtx, cancel := b.opContext(ctx)
+	page, err := b.client.ListObjects(listCtx, b.cfg.Prefix, cursor, limit)
+	cancel()
+	if err != nil {
+		return robot.RemoteBrainPage{}, err
+	}
+	records := make([]robot.RemoteBrainRecord, 0, len(page.Objects))
+	for _, listed := range page.Objects {
+		key := strings.TrimPrefix(listed.Key, b.cfg.Prefix)
+		if key == "" {
+			continue
+		}
+		headCtx, cancel := b.opContext(ctx)
+		obj, exists, err := b.client.HeadObject(headCtx, listed.Key)
+		if err == nil && exists {
+			var record robot.RemoteBrainRecord
+			record, err = b.storedMetadata(headCtx, key, obj)
+			if err == nil {
+				records = append(records, record)
+			}
+		}
+		cancel()
+		if err != nil {
+			return robot.RemoteBrainPage{}, err
+		}
+	}
+	return robot.RemoteBrainPage{Records: records, NextCursor: page.NextContinuationToken}, nil
+}
+
+func (b *s3RemoteBrain) Shutdown() {}
+
+// storedMetadata reads record metadata from object headers, falling back to
+// the body for objects written without them (e.g. copied by external tools).
+func (b *s3RemoteBrain) storedMetadata(ctx context.Context, key string, obj s3compat.Object) (robot.RemoteBrainRecord, error) {
+	if _, ok := obj.Metadata[metaVersion]; ok {
+		return recordFromMetadata(key, obj.Metadata)
+	}
+	full, exists, err := b.client.GetObject(ctx, b.objectKey(key))
+	if err != nil {
+		return robot.RemoteBrainRecord{Key: key}, err
+	}
+	if !exists {
+		return robot.RemoteBrainRecord{Key: key}, fmt.Errorf("s3 brain memory %s disappeared while reading metadata", key)
+	}
+	record, err := decodeEnvelope(key, full.Body)
+	record.Payload = nil
+	return record, err
+}
+
+func recordMetadata(record robot.RemoteBrainRecord) map[string]string {
+	return map[string]string{
+		metaFormat:    brainCacheFormat,
+		metaVersion:   strconv.FormatUint(record.Version, 10),
+		metaChecksum:  record.Checksum,
+		metaDeleted:   strconv.FormatBool(record.Deleted),
+		metaUpdatedAt: record.UpdatedAt.UTC().Format(time.RFC3339Nano),
+		metaKeyID:     record.KeyID,
+	}
+}
+
+func recordFromMetadata(key string, meta map[string]string) (robot.RemoteBrainRecord, error) {
+	version, err := strconv.ParseUint(meta[metaVersion], 10, 64)
+	if err != nil {
+		return robot.RemoteBrainRecord{Key: key}, fmt.Errorf("s3 brain memory %s has invalid version %q", key, meta[metaVersion])
+	}
+	updatedAt, _ := time.Parse(time.RFC3339Nano, meta[metaUpdatedAt])
+	return robot.RemoteBrainRecord{
+		Key:       key,
+		Format:    meta[metaFormat],
+		Version:   version,
+		Checksum:  meta[metaChecksum],
+		Deleted:   meta[metaDeleted] == "true",
+		UpdatedAt: updatedAt,
+		KeyID:     meta[metaKeyID],
+	}, nil
+}
+
+func encodeEnvelope(record robot.RemoteBrainRecord) ([]byte, error) {
+	return json.Marshal(s3Envelope{
+		Format:    brainCacheFormat,
+		Payload:   base64.StdEncoding.EncodeToString(record.Payload),
+		Version:   record.Version,
+		Checksum:  record.Checksum,
+		Deleted:   record.Deleted,
+		UpdatedAt: record.UpdatedAt,
+		KeyID:     record.KeyID,
+	})
+}
+
+func decodeEnvelope(key string, data []byte) (robot.RemoteBrainRecord, error) {
+	var env s3Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return robot.RemoteBrainRecord{Key: key}, err
+	}
+	if env.Format != brainCacheFormat {
+		return robot.RemoteBrainRecord{Key: key}, fmt.Errorf("not a v3 brain record")
+	}
+	payload, err := base64.StdEncoding.DecodeString(env.Payload)
+	if err != nil {
+		return robot.RemoteBrainRecord{Key: key}, err
+	}
+	return robot.RemoteBrainRecord{
+		Key:       key,
+		Payload:   payload,
+		Format:    env.Format,
+		Version:   env.Version,
+		Checksum:  env.Checksum,
+		Deleted:   env.Deleted,
+		UpdatedAt: env.UpdatedAt,
+		KeyID:     env.KeyID,
+	}, nil
+}
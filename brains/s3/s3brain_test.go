@@ -0,0 +1,214 @@
+package s3brain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+	"github.com/lnxjedi/gopherbot/v2/internal/s3compat/s3test"
+)
+
+const testAccessKey = "minioadmin"
+
+func newTestBrain(t *testing.T, server *s3test.Server) *s3RemoteBrain {
+	t.Helper()
+	b, err := newS3Brain(defaultedConfig(brainConfig{
+		Endpoint:        server.URL,
+		Bucket:          "robot",
+		Prefix:          "brain/",
+		AccessKeyID:     testAccessKey,
+		SecretAccessKey: "minioadmin-secret",
+	}))
+	if err != nil {
+		t.Fatalf("newS3Brain() error = %v", err)
+	}
+	return b
+}
+
+func newTestServer(t *testing.T) *s3test.Server {
+	t.Helper()
+	server := s3test.NewServer(testAccessKey, "robot")
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestS3BrainPutGetRoundTrip(t *testing.T) {
+	server := newTestServer(t)
+	b := newTestBrain(t, server)
+	ctx := context.Background()
+	updatedAt := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	payload := []byte{0x00, 0xff, '\r', '\n', 'x'}
+	if err := b.Put(ctx, robot.RemoteBrainRecord{
+		Key:       "links:links",
+		Payload:   payload,
+		Version:   3,
+		Checksum:  "abc",
+		UpdatedAt: updatedAt,
+		KeyID:     "0123abcd",
+	}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if keys := server.Keys("robot"); len(keys) != 1 || keys[0] != "brain/links:links" {
+		t.Fatalf("stored keys = %v", keys)
+	}
+	got, exists, err := b.Get(ctx, "links:links")
+	if err != nil || !exists {
+		t.Fatalf("Get() exists=%v err=%v", exists, err)
+	}
+	if string(got.Payload) != string(payload) || got.Version != 3 || got.Checksum != "abc" || got.Deleted {
+		t.Fatalf("Get() = %+v", got)
+	}
+	if got.Format != brainCacheFormat || !got.UpdatedAt.Equal(updatedAt) || got.KeyID != "0123abcd" {
+		t.Fatalf("Get() format=%q updatedAt=%v keyID=%q", got.Format, got.UpdatedAt, got.KeyID)
+	}
+	page, err := b.ListMetadata(ctx, "", 10)
+	if err != nil || len(page.Records) != 1 || page.Records[0].KeyID != "0123abcd" {
+		t.Fatalf("ListMetadata() = %+v err=%v", page, err)
+	}
+	if _, exists, err := b.Get(ctx, "missing"); err != nil || exists {
+		t.Fatalf("Get(missing) exists=%v err=%v", exists, err)
+	}
+}
+
+func TestS3BrainRejectsStaleVersionFromSecondReplica(t *testing.T) {
+	server := newTestServer(t)
+	first := newTestBrain(t, server)
+	second := newTestBrain(t, server)
+	ctx := context.Background()
+	if err := first.Put(ctx, robot.RemoteBrainRecord{Key: "alpha", Payload: []byte("new"), Version: 5, Checksum: "new"}); err != nil {
+		t.Fatalf("first Put() error = %v", err)
+	}
+	err := second.Put(ctx, robot.RemoteBrainRecord{Key: "alpha", Payload: []byte("old"), Version: 4, Checksum: "old"})
+	if !errors.Is(err, errVersionConflict) {
+		t.Fatalf("second Put() error = %v, want version conflict", err)
+	}
+	err = second.Put(ctx, robot.RemoteBrainRecord{Key: "alpha", Payload: []byte("other"), Version: 5, Checksum: "other"})
+	if !errors.Is(err, errVersionConflict) {
+		t.Fatalf("same-version different-checksum Put() error = %v, want version conflict", err)
+	}
+	if err := second.Put(ctx, robot.RemoteBrainRecord{Key: "alpha", Payload: []byte("new"), Version: 5, Checksum: "new"}); err != nil {
+		t.Fatalf("idempotent retry Put() error = %v", err)
+	}
+	got, _, err := first.Get(ctx, "alpha")
+	if err != nil || string(got.Payload) != "new" || got.Version != 5 {
+		t.Fatalf("Get() = %+v err=%v, want version 5 payload new", got, err)
+	}
+}
+
+func TestS3BrainDetectsConcurrentWriteBetweenHeadAndPut(t *testing.T) {
+	for _, existing := range []bool{false, true} {
+		t.Run(fmt.Sprintf("existing=%v", existing), func(t *testing.T) {
+			server := newTestServer(t)
+			first := newTestBrain(t, server)
+			second := newTestBrain(t, server)
+			ctx := context.Background()
+			if existing {
+				if err := first.Put(ctx, robot.RemoteBrainRecord{Key: "alpha", Payload: []byte("base"), Version: 1, Checksum: "base"}); err != nil {
+					t.Fatalf("seed Put() error = %v", err)
+				}
+			}
+			raced := false
+			server.BeforePut = func(bucket, key string) {
+				if raced {
+					return
+				}
+				raced = true
+				if err := second.Put(ctx, robot.RemoteBrainRecord{Key: "alpha", Payload: []byte("racer"), Version: 9, Checksum: "racer"}); err != nil {
+					t.Errorf("racing Put() error = %v", err)
+				}
+			}
+			err := first.Put(ctx, robot.RemoteBrainRecord{Key: "alpha", Payload: []byte("mine"), Version: 8, Checksum: "mine"})
+			if !errors.Is(err, errVersionConflict) {
+				t.Fatalf("Put() error = %v, want concurrent-modification conflict", err)
+			}
+			got, _, err := first.Get(ctx, "alpha")
+			if err != nil || string(got.Payload) != "racer" {
+				t.Fatalf("Get() = %+v err=%v, want racing writer's payload", got, err)
+			}
+		})
+	}
+}
+
+func TestS3BrainDeleteWritesTombstone(t *testing.T) {
+	server := newTestServer(t)
+	b := newTestBrain(t, server)
+	ctx := context.Background()
+	if err := b.Put(ctx, robot.RemoteBrainRecord{Key: "alpha", Payload: []byte("one"), Version: 1, Checksum: "one"}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := b.Delete(ctx, robot.RemoteBrainRecord{Key: "alpha", Version: 2}); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	got, exists, err := b.Get(ctx, "alpha")
+	if err != nil || !exists || !got.Deleted || got.Version != 2 || len(got.Payload) != 0 {
+		t.Fatalf("tombstone = %+v exists=%v err=%v", got, exists, err)
+	}
+}
+
+func TestS3BrainListMetadataPagesWithContinuationToken(t *testing.T) {
+	server := newTestServer(t)
+	b := newTestBrain(t, server)
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if err := b.Put(ctx, robot.RemoteBrainRecord{Key: key, Payload: []byte(key), Version: uint64(i + 1), Checksum: key}); err != nil {
+			t.Fatalf("Put(%s) error = %v", key, err)
+		}
+	}
+	if err := b.Delete(ctx, robot.RemoteBrainRecord{Key: "key-2", Version: 10}); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	getsBefore := server.RequestCount("GET")
+	var keys []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("ListMetadata() did not terminate")
+		}
+		page, err := b.ListMetadata(ctx, cursor, 2)
+		if err != nil {
+			t.Fatalf("ListMetadata() error = %v", err)
+		}
+		for _, record := range page.Records {
+			if record.Payload != nil || record.Format != brainCacheFormat {
+				t.Fatalf("ListMetadata() record = %+v", record)
+			}
+			keys = append(keys, fmt.Sprintf("%s@%d/%v", record.Key, record.Version, record.Deleted))
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	if got := strings.Join(keys, ","); got != "key-0@1/false,key-1@2/false,key-2@10/true,key-3@4/false,key-4@5/false" {
+		t.Fatalf("listed = %s", got)
+	}
+	// Only the listing requests should be GETs; metadata comes from HEAD.
+	if gets := server.RequestCount("GET") - getsBefore; gets != 3 {
+		t.Fatalf("ListMetadata() issued %d GETs, want 3 list pages", gets)
+	}
+}
+
+func TestS3BrainConfigValidation(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	for _, cfg := range []brainConfig{
+		{Bucket: "robot", AccessKeyID: "a", SecretAccessKey: "b"},
+		{Endpoint: "http://localhost:9000", AccessKeyID: "a", SecretAccessKey: "b"},
+		{Endpoint: "http://localhost:9000", Bucket: "robot"},
+	} {
+		if _, err := newS3Brain(defaultedConfig(cfg)); err == nil {
+			t.Fatalf("newS3Brain(%+v) succeeded, want error", cfg)
+		}
+	}
+	t.Setenv("AWS_ACCESS_KEY_ID", "env-id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret")
+	cfg := defaultedConfig(brainConfig{Endpoint: "http://localhost:9000", Bucket: "robot"})
+	if cfg.AccessKeyID != "env-id" || cfg.Prefix != "gopherbot-brain/" || cfg.Region != "us-east-1" {
+		t.Fatalf("defaultedConfig() = %+v", cfg)
+	}
+}
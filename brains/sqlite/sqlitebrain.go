@@ -0,0 +1,304 @@
+// Package sqlitebrain is a single-host SQLite implementation of the v3
+// robot.RemoteBrainBackend contract, giving robots a durable, transactional
+// brain without a cloud account.
+package sqlitebrain
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+	_ "modernc.org/sqlite"
+)
+
+const brainCacheFormat = "gopherbot-brain-v3"
+
+const maxSQLiteBrainVersion = uint64(1<<63 - 1)
+
+type brainConfig struct {
+	DatabaseFile      string
+	Table             string
+	BusyTimeoutMillis int
+}
+
+type sqliteRemoteBrain struct {
+	cfg brainConfig
+	db  *sql.DB
+}
+
+var tableNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func defaultedConfig(cfg brainConfig) brainConfig {
+	cfg.DatabaseFile = strings.TrimSpace(cfg.DatabaseFile)
+	cfg.Table = strings.TrimSpace(cfg.Table)
+	if cfg.Table == "" {
+		cfg.Table = "gopherbot_brain"
+	}
+	if cfg.BusyTimeoutMillis <= 0 {
+		cfg.BusyTimeoutMillis = 5000
+	}
+	return cfg
+}
+
+func validateConfig(cfg brainConfig) error {
+	if cfg.DatabaseFile == "" {
+		return errors.New("DatabaseFile is required")
+	}
+	if !tableNameRe.MatchString(cfg.Table) {
+		return fmt.Errorf("Table %q must match %s", cfg.Table, tableNameRe.String())
+	}
+	return nil
+}
+
+func remoteProvider(r robot.Handler) robot.RemoteBrainBackend {
+	var cfg brainConfig
+	if err := r.GetBrainConfig(&cfg); err != nil {
+		r.Log(robot.Fatal, "Unable to retrieve SQLite brain configuration: %v", err)
+	}
+	cfg = defaultedConfig(cfg)
+	if err := validateConfig(cfg); err != nil {
+		r.Log(robot.Fatal, "Invalid SQLite brain configuration: %v", err)
+	}
+	if dir := filepath.Dir(cfg.DatabaseFile); dir != "." {
+		if err := r.GetDirectory(dir); err != nil {
+			r.Log(robot.Fatal, "Checking SQLite brain directory '%s': %v", dir, err)
+		}
+	}
+	b, err := openSQLiteBrain(cfg)
+	if err != nil {
+		r.Log(robot.Fatal, "Opening SQLite brain database '%s': %v", cfg.DatabaseFile, err)
+	}
+	r.Log(robot.Info, "Initialized SQLite brain database '%s', table '%s'", cfg.DatabaseFile, cfg.Table)
+	return b
+}
+
+// openSQLiteBrain opens (creating if needed) the database and memory table.
+// WAL journaling with synchronous=FULL keeps every committed Put durable.
+func openSQLiteBrain(cfg brainConfig) (*sqliteRemoteBrain, error) {
+	if err := validateConfig(cfg); err != nil {
+		return nil, err
+	}
+	params := url.Values{}
+	params.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", cfg.BusyTimeoutMillis))
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Add("_pragma", "synchronous(FULL)")
+	params.Set("_txlock", "immediate")
+	db, err := sql.Open("sqlite", "file:"+cfg.DatabaseFile+"?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer; serializing through one connection
+	// avoids SQLITE_BUSY between the sync loop and CLI-style callers.
+	db.SetMaxOpenConns(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	schema := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	memory TEXT PRIMARY KEY,
+	content BLOB,
+	format TEXT NOT NULL,
+	version INTEGER NOT NULL,
+	checksum TEXT NOT NULL,
+	deleted INTEGER NOT NULL DEFAULT 0,
+	updated_at TEXT NOT NULL,
+	key_id TEXT NOT NULL DEFAULT ''
+)`, cfg.Table)
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	if err := addKeyIDColumn(ctx, db, cfg.Table); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &sqliteRemoteBrain{cfg: cfg, db: db}, nil
+}
+
+// addKeyIDColumn upgrades tables created before records carried the ID of
+// the data key that encrypted them.
+func addKeyIDColumn(ctx context.Context, db *sql.DB, table string) error {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == "key_id" {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN key_id TEXT NOT NULL DEFAULT ''", table))
+	return err
+}
+
+func (b *sqliteRemoteBrain) Identity() robot.BrainBackendIdentity {
+	path := b.cfg.DatabaseFile
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return robot.BrainBackendIdentity{Provider: "sqlite", Scope: path + "/" + b.cfg.Table}
+}
+
+// SyncPolicy returns no write budget or pacing; a local database has no
+// per-write cost, so the cache defaults apply.
+func (b *sqliteRemoteBrain) SyncPolicy() robot.BrainSyncPolicy {
+	return robot.BrainSyncPolicy{}
+}
+
+func (b *sqliteRemoteBrain) Get(ctx context.Context, key string) (robot.RemoteBrainRecord, bool, error) {
+	query := fmt.Sprintf("SELECT content, format, version, checksum, deleted, updated_at, key_id FROM %s WHERE memory = ?", b.cfg.Table)
+	var (
+		content   []byte
+		format    string
+		version   int64
+		checksum  string
+		deleted   bool
+		updatedAt string
+		keyID     string
+	)
+	err := b.db.QueryRowContext(ctx, query, key).Scan(&content, &format, &version, &checksum, &deleted, &updatedAt, &keyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return robot.RemoteBrainRecord{}, false, nil
+	}
+	if err != nil {
+		return robot.RemoteBrainRecord{}, false, err
+	}
+	if format != brainCacheFormat {
+		return robot.RemoteBrainRecord{Key: key}, true, fmt.Errorf("not a v3 brain record")
+	}
+	record, err := remoteRecordFromRow(key, format, version, checksum, deleted, updatedAt, keyID)
+	if err != nil {
+		return robot.RemoteBrainRecord{Key: key}, true, err
+	}
+	record.Payload = content
+	return record, true, nil
+}
+
+func (b *sqliteRemoteBrain) Put(ctx context.Context, record robot.RemoteBrainRecord) error {
+	if record.Version > maxSQLiteBrainVersion {
+		return fmt.Errorf("sqlite brain version %d exceeds signed 64-bit storage limit", record.Version)
+	}
+	updatedAt := record.UpdatedAt
+	if updatedAt.IsZero() {
+		updatedAt = time.Now().UTC()
+	}
+	var content []byte
+	if !record.Deleted {
+		content = record.Payload
+		if content == nil {
+			content = []byte{}
+		}
+	}
+	stmt := fmt.Sprintf(`INSERT INTO %s (memory, content, format, version, checksum, deleted, updated_at, key_id)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(memory) DO UPDATE SET
+	content = excluded.content,
+	format = excluded.format,
+	version = excluded.version,
+	checksum = excluded.checksum,
+	deleted = excluded.deleted,
+	updated_at = excluded.updated_at,
+	key_id = excluded.key_id`, b.cfg.Table)
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, stmt,
+		record.Key,
+		content,
+		brainCacheFormat,
+		int64(record.Version),
+		record.Checksum,
+		record.Deleted,
+		updatedAt.UTC().Format(time.RFC3339Nano),
+		record.KeyID,
+	); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (b *sqliteRemoteBrain) Delete(ctx context.Context, tombstone robot.RemoteBrainRecord) error {
+	tombstone.Format = brainCacheFormat
+	tombstone.Deleted = true
+	return b.Put(ctx, tombstone)
+}
+
+// ListMetadata pages through memories in key order; the cursor is the last
+// key returned on the previous page.
+func (b *sqliteRemoteBrain) ListMetadata(ctx context.Context, cursor string, limit int) (robot.RemoteBrainPage, error) {
+	if limit <= 0 {
+		limit = 1000
+	}
+	query := fmt.Sprintf(`SELECT memory, format, version, checksum, deleted, updated_at, key_id FROM %s
+WHERE memory > ? ORDER BY memory LIMIT ?`, b.cfg.Table)
+	rows, err := b.db.QueryContext(ctx, query, cursor, limit)
+	if err != nil {
+		return robot.RemoteBrainPage{}, err
+	}
+	defer rows.Close()
+	records := make([]robot.RemoteBrainRecord, 0, limit)
+	for rows.Next() {
+		var (
+			key       string
+			format    string
+			version   int64
+			checksum  string
+			deleted   bool
+			updatedAt string
+			keyID     string
+		)
+		if err := rows.Scan(&key, &format, &version, &checksum, &deleted, &updatedAt, &keyID); err != nil {
+			return robot.RemoteBrainPage{}, err
+		}
+		record, err := remoteRecordFromRow(key, format, version, checksum, deleted, updatedAt, keyID)
+		if err != nil {
+			return robot.RemoteBrainPage{}, err
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return robot.RemoteBrainPage{}, err
+	}
+	page := robot.RemoteBrainPage{Records: records}
+	if len(records) == limit {
+		page.NextCursor = records[len(records)-1].Key
+	}
+	return page, nil
+}
+
+func (b *sqliteRemoteBrain) Shutdown() {
+	if b.db != nil {
+		_ = b.db.Close()
+	}
+}
+
+func remoteRecordFromRow(key, format string, version int64, checksum string, deleted bool, updatedAt, keyID string) (robot.RemoteBrainRecord, error) {
+	if version < 0 {
+		return robot.RemoteBrainRecord{Key: key}, fmt.Errorf("sqlite brain memory %s has negative version %d", key, version)
+	}
+	ts, _ := time.Parse(time.RFC3339Nano, updatedAt)
+	return robot.RemoteBrainRecord{
+		Key:       key,
+		Format:    format,
+		Version:   uint64(version),
+		Checksum:  checksum,
+		Deleted:   deleted,
+		UpdatedAt: ts,
+		KeyID:     keyID,
+	}, nil
+}
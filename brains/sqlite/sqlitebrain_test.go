@@ -0,0 +1,170 @@
+package sqlitebrain
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+func openTestBrain(t *testing.T, path string) *sqliteRemoteBrain {
+	t.Helper()
+	b, err := openSQLiteBrain(defaultedConfig(brainConfig{DatabaseFile: path}))
+	if err != nil {
+		t.Fatalf("openSQLiteBrain() error = %v", err)
+	}
+	return b
+}
+
+func TestSQLiteBrainPutGetRoundTripPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "brain.sqlite")
+	b := openTestBrain(t, path)
+	ctx := context.Background()
+	updatedAt := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	record := robot.RemoteBrainRecord{
+		Key:       "links:links",
+		Payload:   []byte("encrypted"),
+		Format:    brainCacheFormat,
+		Version:   42,
+		Checksum:  "abc123",
+		UpdatedAt: updatedAt,
+		KeyID:     "0123abcd",
+	}
+	if err := b.Put(ctx, record); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	b.Shutdown()
+
+	reopened := openTestBrain(t, path)
+	defer reopened.Shutdown()
+	got, exists, err := reopened.Get(ctx, record.Key)
+	if err != nil || !exists {
+		t.Fatalf("Get() exists=%v err=%v", exists, err)
+	}
+	if string(got.Payload) != "encrypted" || got.Version != 42 || got.Checksum != "abc123" || got.Deleted {
+		t.Fatalf("Get() = %+v, want payload/version/checksum from %+v", got, record)
+	}
+	if got.Format != brainCacheFormat || !got.UpdatedAt.Equal(updatedAt) || got.KeyID != "0123abcd" {
+		t.Fatalf("Get() format=%q updatedAt=%v keyID=%q", got.Format, got.UpdatedAt, got.KeyID)
+	}
+	if _, exists, err := reopened.Get(ctx, "missing"); err != nil || exists {
+		t.Fatalf("Get(missing) exists=%v err=%v", exists, err)
+	}
+}
+
+func TestSQLiteBrainAddsKeyIDColumnToExistingTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "brain.sqlite")
+	b := openTestBrain(t, path)
+	ctx := context.Background()
+	for _, stmt := range []string{
+		"DROP TABLE gopherbot_brain",
+		`CREATE TABLE gopherbot_brain (memory TEXT PRIMARY KEY, content BLOB, format TEXT NOT NULL,
+	version INTEGER NOT NULL, checksum TEXT NOT NULL, deleted INTEGER NOT NULL DEFAULT 0, updated_at TEXT NOT NULL)`,
+		"INSERT INTO gopherbot_brain VALUES ('alpha', x'6f6e65', '" + brainCacheFormat + "', 1, 'sum', 0, '2026-06-01T12:00:00Z')",
+	} {
+		if _, err := b.db.ExecContext(ctx, stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	b.Shutdown()
+
+	reopened := openTestBrain(t, path)
+	defer reopened.Shutdown()
+	got, exists, err := reopened.Get(ctx, "alpha")
+	if err != nil || !exists || string(got.Payload) != "one" || got.KeyID != "" {
+		t.Fatalf("Get(alpha) = %+v exists=%v err=%v", got, exists, err)
+	}
+	if err := reopened.Put(ctx, robot.RemoteBrainRecord{Key: "alpha", Payload: []byte("two"), Version: 2, Checksum: "sum2", KeyID: "0123abcd"}); err != nil {
+		t.Fatalf("Put() after upgrade error = %v", err)
+	}
+	page, err := reopened.ListMetadata(ctx, "", 10)
+	if err != nil || len(page.Records) != 1 || page.Records[0].KeyID != "0123abcd" {
+		t.Fatalf("ListMetadata() = %+v err=%v", page, err)
+	}
+}
+
+func TestSQLiteBrainDeleteWritesTombstone(t *testing.T) {
+	b := openTestBrain(t, filepath.Join(t.TempDir(), "brain.sqlite"))
+	defer b.Shutdown()
+	ctx := context.Background()
+	if err := b.Put(ctx, robot.RemoteBrainRecord{Key: "alpha", Payload: []byte("one"), Version: 1, Checksum: "sum"}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := b.Delete(ctx, robot.RemoteBrainRecord{Key: "alpha", Version: 2}); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	got, exists, err := b.Get(ctx, "alpha")
+	if err != nil || !exists {
+		t.Fatalf("Get() exists=%v err=%v, want tombstone", exists, err)
+	}
+	if !got.Deleted || got.Version != 2 || len(got.Payload) != 0 {
+		t.Fatalf("tombstone = %+v, want deleted version 2 without payload", got)
+	}
+}
+
+func TestSQLiteBrainListMetadataPagesByKey(t *testing.T) {
+	b := openTestBrain(t, filepath.Join(t.TempDir(), "brain.sqlite"))
+	defer b.Shutdown()
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if err := b.Put(ctx, robot.RemoteBrainRecord{Key: key, Payload: []byte(key), Version: uint64(i + 1), Checksum: key}); err != nil {
+			t.Fatalf("Put(%s) error = %v", key, err)
+		}
+	}
+	var keys []string
+	cursor := ""
+	pages := 0
+	for {
+		page, err := b.ListMetadata(ctx, cursor, 2)
+		if err != nil {
+			t.Fatalf("ListMetadata() error = %v", err)
+		}
+		pages++
+		for _, record := range page.Records {
+			if record.Payload != nil {
+				t.Fatalf("ListMetadata() returned payload for %s", record.Key)
+			}
+			if record.Format != brainCacheFormat {
+				t.Fatalf("ListMetadata() format = %q", record.Format)
+			}
+			keys = append(keys, record.Key)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	if got := strings.Join(keys, ","); got != "key-0,key-1,key-2,key-3,key-4" {
+		t.Fatalf("listed keys = %s", got)
+	}
+	if pages != 3 {
+		t.Fatalf("pages = %d, want 3", pages)
+	}
+}
+
+func TestSQLiteBrainRejectsVersionOverflow(t *testing.T) {
+	b := openTestBrain(t, filepath.Join(t.TempDir(), "brain.sqlite"))
+	defer b.Shutdown()
+	err := b.Put(context.Background(), robot.RemoteBrainRecord{Key: "alpha", Version: maxSQLiteBrainVersion + 1})
+	if err == nil {
+		t.Fatal("Put() accepted uint64 value larger than SQLite can store")
+	}
+}
+
+func TestSQLiteBrainConfigValidation(t *testing.T) {
+	cfg := defaultedConfig(brainConfig{DatabaseFile: " state/brain.sqlite "})
+	if cfg.DatabaseFile != "state/brain.sqlite" || cfg.Table != "gopherbot_brain" || cfg.BusyTimeoutMillis != 5000 {
+		t.Fatalf("defaultedConfig() = %+v", cfg)
+	}
+	if err := validateConfig(defaultedConfig(brainConfig{})); err == nil {
+		t.Fatal("validateConfig() accepted missing DatabaseFile")
+	}
+	if err := validateConfig(defaultedConfig(brainConfig{DatabaseFile: "x", Table: "brain; DROP TABLE x"})); err == nil {
+		t.Fatal("validateConfig() accepted unsafe table name")
+	}
+}
@@ -0,0 +1,307 @@
+package discord
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	maxErrorBodySize = 4096
+	userAgent        = "DiscordBot (https://github.com/lnxjedi/gopherbot, 2)"
+
+	// JSON error codes
+	codeUnknownChannel       = 10003
+	codeThreadAlreadyCreated = 160004
+)
+
+// apiClient is a minimal client for the parts of the Discord REST API the
+// connector uses.
+type apiClient struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+// apiError is a Discord error response.
+type apiError struct {
+	Status     int     `json:"-"`
+	Code       int     `json:"code"`
+	Message    string  `json:"message"`
+	RetryAfter float64 `json:"retry_after"` // seconds, for 429s
+}
+
+func (e *apiError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("HTTP %d", e.Status)
+	}
+	return fmt.Sprintf("HTTP %d (%d): %s", e.Status, e.Code, e.Message)
+}
+
+func newAPIClient(base, token string) *apiClient {
+	return &apiClient{
+		base:  strings.TrimRight(base, "/"),
+		token: token,
+		http:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (c *apiClient) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bot "+c.token)
+	req.Header.Set("User-Agent", userAgent)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &apiError{}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+		_ = json.Unmarshal(data, apiErr)
+		apiErr.Status = resp.StatusCode
+		return apiErr
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// retryDelay reports how long to wait before retrying a failed request, or
+// false if it shouldn't be retried. Requests that aren't idempotent are only
+// retried when rate limited, since the first attempt was refused.
+func retryDelay(err error, idempotent bool) (time.Duration, bool) {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		var netErr net.Error
+		timedOut := errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+		return sendRetryDelay, idempotent && timedOut
+	}
+	switch {
+	case apiErr.Status == http.StatusTooManyRequests:
+		delay := time.Duration(apiErr.RetryAfter * float64(time.Second))
+		if delay <= 0 {
+			delay = time.Second
+		}
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+		return delay, true
+	case apiErr.Status >= 500:
+		return sendRetryDelay, idempotent
+	default:
+		return 0, false
+	}
+}
+
+func errorCode(err error) int {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr.Code
+	}
+	return 0
+}
+
+func isNotFound(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound
+}
+
+type dcUser struct {
+	ID         string `json:"id"`
+	Username   string `json:"username"`
+	GlobalName string `json:"global_name"`
+	Bot        bool   `json:"bot"`
+}
+
+type dcApplication struct {
+	ID string `json:"id"`
+}
+
+// Channel types the connector distinguishes.
+const (
+	channelGuildText          = 0
+	channelDM                 = 1
+	channelGroupDM            = 3
+	channelAnnouncementThread = 10
+	channelPublicThread       = 11
+	channelPrivateThread      = 12
+)
+
+type dcChannel struct {
+	ID       string `json:"id"`
+	Type     int    `json:"type"`
+	GuildID  string `json:"guild_id"`
+	Name     string `json:"name"`
+	ParentID string `json:"parent_id"`
+}
+
+func (ch dcChannel) isThread() bool {
+	switch ch.Type {
+	case channelAnnouncementThread, channelPublicThread, channelPrivateThread:
+		return true
+	}
+	return false
+}
+
+func (ch dcChannel) isDirect() bool {
+	return ch.Type == channelDM || ch.Type == channelGroupDM
+}
+
+type dcRole struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Tags *struct {
+		BotID string `json:"bot_id"`
+	} `json:"tags"`
+}
+
+// Message types the connector reads; everything else is a system message.
+const (
+	messageDefault          = 0
+	messageReply            = 19
+	messageChatInputCommand = 20
+)
+
+type dcMessage struct {
+	ID        string   `json:"id"`
+	ChannelID string   `json:"channel_id"`
+	GuildID   string   `json:"guild_id"`
+	Author    dcUser   `json:"author"`
+	Content   string   `json:"content"`
+	Type      int      `json:"type"`
+	WebhookID string   `json:"webhook_id"`
+	Mentions  []dcUser `json:"mentions"`
+}
+
+type allowedMentions struct {
+	Parse []string `json:"parse"`
+	Users []string `json:"users,omitempty"`
+}
+
+// dcMessageSend is a new message, or an interaction followup.
+type dcMessageSend struct {
+	Content         string           `json:"content"`
+	Nonce           string           `json:"nonce,omitempty"`
+	EnforceNonce    bool             `json:"enforce_nonce,omitempty"`
+	Flags           int              `json:"flags,omitempty"`
+	AllowedMentions *allowedMentions `json:"allowed_mentions,omitempty"`
+}
+
+func (c *apiClient) getMe(ctx context.Context) (*dcUser, error) {
+	var user dcUser
+	if err := c.do(ctx, http.MethodGet, "/users/@me", nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (c *apiClient) getApplication(ctx context.Context) (*dcApplication, error) {
+	var app dcApplication
+	if err := c.do(ctx, http.MethodGet, "/oauth2/applications/@me", nil, &app); err != nil {
+		return nil, err
+	}
+	return &app, nil
+}
+
+// gatewayURL returns the websocket URL to connect to the gateway with.
+func (c *apiClient) gatewayURL(ctx context.Context) (string, error) {
+	var gw struct {
+		URL string `json:"url"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/gateway/bot", nil, &gw); err != nil {
+		return "", err
+	}
+	if gw.URL == "" {
+		return "", errors.New("no gateway URL")
+	}
+	return gw.URL, nil
+}
+
+func (c *apiClient) getUser(ctx context.Context, userID string) (*dcUser, error) {
+	var user dcUser
+	if err := c.do(ctx, http.MethodGet, "/users/"+url.PathEscape(userID), nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (c *apiClient) getChannel(ctx context.Context, channelID string) (*dcChannel, error) {
+	var channel dcChannel
+	if err := c.do(ctx, http.MethodGet, "/channels/"+url.PathEscape(channelID), nil, &channel); err != nil {
+		return nil, err
+	}
+	return &channel, nil
+}
+
+func (c *apiClient) createMessage(ctx context.Context, channelID string, msg *dcMessageSend) error {
+	return c.do(ctx, http.MethodPost, "/channels/"+url.PathEscape(channelID)+"/messages", msg, nil)
+}
+
+// createDM returns the DM channel with a user; Discord returns the existing
+// one if there is one.
+func (c *apiClient) createDM(ctx context.Context, userID string) (*dcChannel, error) {
+	var channel dcChannel
+	if err := c.do(ctx, http.MethodPost, "/users/@me/channels", map[string]string{"recipient_id": userID}, &channel); err != nil {
+		return nil, err
+	}
+	return &channel, nil
+}
+
+// startThread starts a thread from a message; the thread gets the
+// message's ID.
+func (c *apiClient) startThread(ctx context.Context, channelID, messageID, name string) (*dcChannel, error) {
+	var thread dcChannel
+	path := "/channels/" + url.PathEscape(channelID) + "/messages/" + url.PathEscape(messageID) + "/threads"
+	if err := c.do(ctx, http.MethodPost, path, map[string]string{"name": name}, &thread); err != nil {
+		return nil, err
+	}
+	return &thread, nil
+}
+
+func (c *apiClient) triggerTyping(ctx context.Context, channelID string) error {
+	return c.do(ctx, http.MethodPost, "/channels/"+url.PathEscape(channelID)+"/typing", nil, nil)
+}
+
+func (c *apiClient) createInteractionResponse(ctx context.Context, interactionID, token string, resp *interactionResponse) error {
+	path := "/interactions/" + url.PathEscape(interactionID) + "/" + url.PathEscape(token) + "/callback"
+	return c.do(ctx, http.MethodPost, path, resp, nil)
+}
+
+func (c *apiClient) createFollowup(ctx context.Context, appID, token string, msg *dcMessageSend) error {
+	return c.do(ctx, http.MethodPost, "/webhooks/"+url.PathEscape(appID)+"/"+url.PathEscape(token), msg, nil)
+}
+
+// overwriteCommands replaces the application's commands in a guild, or its
+// global commands when guildID is empty.
+func (c *apiClient) overwriteCommands(ctx context.Context, appID, guildID string, commands []appCommand) error {
+	path := "/applications/" + url.PathEscape(appID) + "/commands"
+	if guildID != "" {
+		path = "/applications/" + url.PathEscape(appID) + "/guilds/" + url.PathEscape(guildID) + "/commands"
+	}
+	return c.do(ctx, http.MethodPut, path, commands, nil)
+}
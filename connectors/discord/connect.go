@@ -0,0 +1,115 @@
+// Package discord implements the robot.Connector interface for Discord,
+// using the gateway for incoming events and the REST API for everything
+// else. Hidden commands arrive as application (slash) commands, and plugin
+// commands can optionally be registered as application commands too.
+package discord
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const (
+	loginTimeout   = 10 * time.Second
+	defaultAPIBase = "https://discord.com/api/v10"
+)
+
+type config struct {
+	Token                  string   // bot token from the Discord developer portal
+	SlashCommand           string   // name of the robot's application command, default the robot's name
+	RegisterPluginCommands bool     // also register each plugin command as an application command
+	CommandGuilds          []string // guild IDs to register commands in; commands are global when empty
+	ThreadResponses        bool     // reply in a thread to messages that didn't start one
+	UserMap                map[string]string
+}
+
+// normalizeID returns a Discord ID, or "" if in isn't one; user, channel
+// and guild IDs are all snowflakes, decimal integers of up to 20 digits.
+func normalizeID(in string) string {
+	in = strings.TrimSpace(in)
+	if len(in) < 15 || len(in) > 20 {
+		return ""
+	}
+	for i := 0; i < len(in); i++ {
+		if in[i] < '0' || in[i] > '9' {
+			return ""
+		}
+	}
+	return in
+}
+
+func normalizeConfiguredUserMap(in map[string]string, h robot.Handler) map[string]string {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(in))
+	for user, id := range in {
+		name := strings.TrimSpace(user)
+		uid := normalizeID(id)
+		if name == "" || uid == "" {
+			h.Log(robot.Warn, "Ignoring invalid Discord UserMap entry (empty username or invalid user ID): %q -> %q", user, id)
+			continue
+		}
+		if strings.ToLower(name) != name {
+			h.Log(robot.Warn, "Ignoring Discord UserMap entry with uppercase username: %q", user)
+			continue
+		}
+		out[name] = uid
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+func normalizeGuilds(in []string, h robot.Handler) []string {
+	var out []string
+	for _, guild := range in {
+		id := normalizeID(guild)
+		if id == "" {
+			h.Log(robot.Warn, "Ignoring invalid Discord CommandGuilds entry: %q", guild)
+			continue
+		}
+		out = append(out, id)
+	}
+	return out
+}
+
+// Initialize validates config, checks the token and returns the connector.
+func Initialize(handler robot.Handler, l *log.Logger) robot.InitializedConnector {
+	var c config
+	if err := handler.GetProtocolConfig(&c); err != nil {
+		handler.Log(robot.Fatal, "Unable to retrieve discord protocol configuration: %v", err)
+	}
+	token := strings.TrimSpace(c.Token)
+	if token == "" {
+		handler.Log(robot.Fatal, "Discord protocol config requires Token")
+	}
+
+	api := newAPIClient(defaultAPIBase, token)
+	ctx, cancel := context.WithTimeout(context.Background(), loginTimeout)
+	defer cancel()
+	me, err := api.getMe(ctx)
+	if err != nil {
+		handler.Log(robot.Fatal, "Unable to log in to Discord: %v", err)
+	}
+	app, err := api.getApplication(ctx)
+	if err != nil {
+		handler.Log(robot.Fatal, "Unable to look up the robot's Discord application: %v", err)
+	}
+
+	connector := newDiscordConnector(handler, api, me, app.ID, c)
+	if connector.slashCommand == "" {
+		handler.Log(robot.Fatal, "Discord SlashCommand %q isn't a valid application command name", c.SlashCommand)
+	}
+	handler.Log(robot.Info, "Discord connector logged in as %s (%s), application command /%s", me.Username, me.ID, connector.slashCommand)
+	handler.SetBotID(me.ID)
+	return robot.InitializedConnector{
+		Connector:    connector,
+		Capabilities: robot.ConnectorCapabilities{HiddenCommands: true},
+	}
+}
@@ -0,0 +1,543 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+	"github.com/lnxjedi/gopherbot/robot"
+	"github.com/lnxjedi/gopherbot/robot/util"
+)
+
+const (
+	sendTimeout     = 10 * time.Second
+	lookupTimeout   = 10 * time.Second
+	maxSendAttempts = 2
+	sendRetryDelay  = 250 * time.Millisecond
+	maxRetryDelay   = 5 * time.Second
+	// Discord's limit for message content, in characters
+	maxMessageSize = 2000
+	// longer messages are split into at most this many messages
+	maxMessageParts = 5
+	// Discord's limit for thread names, in characters
+	maxThreadNameSize = 100
+)
+
+var nonceSeq atomic.Uint64
+
+type discordConnector struct {
+	robot.Handler
+
+	api           *apiClient
+	selfID        string
+	selfName      string // the robot's Discord username
+	botName       string // the robot's gopherbot name
+	appID         string
+	slashCommand  string
+	commandGuilds []string
+	dialer        *websocket.Dialer
+	retrySleep    func(time.Duration)
+
+	// gateway session, only used by the Run goroutine
+	gatewayURL string
+	sessionID  string
+	resumeURL  string
+	seq        atomic.Int64
+	missedACK  atomic.Bool
+	wsMu       sync.Mutex // serializes gateway writes
+
+	registerMu sync.Mutex
+	registered string // the application commands last registered
+
+	mu                     sync.RWMutex
+	threadResponses        bool
+	registerPluginCommands bool
+	botUserMap             map[string]string // username -> Discord user ID
+	configuredUsers        map[string]string // Discord user ID -> username
+	usersByID              map[string]dcUser
+	channels               map[string]dcChannel
+	roles                  map[string]dcRole
+	directByUser           map[string]string // user ID -> DM channel ID
+	pluginCommands         map[string]string // application command name -> command text
+}
+
+func newDiscordConnector(handler robot.Handler, api *apiClient, me *dcUser, appID string, c config) *discordConnector {
+	botName := strings.TrimSpace(handler.GetBotInfo().UserName)
+	if botName == "" {
+		botName = "gopherbot"
+	}
+	slash := c.SlashCommand
+	if strings.TrimSpace(slash) == "" {
+		slash = botName
+	}
+	dc := &discordConnector{
+		Handler:                handler,
+		api:                    api,
+		selfID:                 me.ID,
+		selfName:               strings.ToLower(me.Username),
+		botName:                botName,
+		appID:                  appID,
+		slashCommand:           commandName(strings.TrimPrefix(strings.TrimSpace(slash), "/")),
+		commandGuilds:          normalizeGuilds(c.CommandGuilds, handler),
+		dialer:                 websocket.DefaultDialer,
+		threadResponses:        c.ThreadResponses,
+		registerPluginCommands: c.RegisterPluginCommands,
+		botUserMap:             normalizeConfiguredUserMap(c.UserMap, handler),
+		usersByID:              make(map[string]dcUser),
+		channels:               make(map[string]dcChannel),
+		roles:                  make(map[string]dcRole),
+		directByUser:           make(map[string]string),
+		pluginCommands:         make(map[string]string),
+	}
+	dc.configuredUsers = configuredUsersByID(dc.botUserMap)
+	dc.cacheUser(*me)
+	return dc
+}
+
+func configuredUsersByID(userMap map[string]string) map[string]string {
+	configured := make(map[string]string, len(userMap))
+	for name, id := range userMap {
+		configured[id] = name
+	}
+	return configured
+}
+
+// Reload swaps UserMap, ThreadResponses and RegisterPluginCommands; the
+// engine registers commands again after a reload.
+func (dc *discordConnector) Reload() error {
+	var c config
+	if err := dc.GetProtocolConfig(&c); err != nil {
+		return fmt.Errorf("retrieve Discord protocol configuration: %w", err)
+	}
+	newBotUserMap := normalizeConfiguredUserMap(c.UserMap, dc.Handler)
+	newConfiguredUsers := configuredUsersByID(newBotUserMap)
+
+	dc.mu.Lock()
+	dc.botUserMap = newBotUserMap
+	dc.configuredUsers = newConfiguredUsers
+	dc.threadResponses = c.ThreadResponses
+	dc.registerPluginCommands = c.RegisterPluginCommands
+	dc.mu.Unlock()
+
+	dc.Log(robot.Info, "Discord connector reloaded %d configured user mapping(s)", len(newBotUserMap))
+	return nil
+}
+
+func (dc *discordConnector) cacheUser(user dcUser) {
+	if user.ID == "" {
+		return
+	}
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	dc.usersByID[user.ID] = user
+}
+
+// lookupUser returns a user from the cache, or from Discord.
+func (dc *discordConnector) lookupUser(userID string) (dcUser, bool) {
+	dc.mu.RLock()
+	user, ok := dc.usersByID[userID]
+	dc.mu.RUnlock()
+	if ok {
+		return user, true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	found, err := dc.api.getUser(ctx, userID)
+	if err != nil {
+		dc.Log(robot.Debug, "Discord: unable to look up user %s: %v", userID, err)
+		return dcUser{}, false
+	}
+	dc.cacheUser(*found)
+	return *found, true
+}
+
+func (dc *discordConnector) canonicalName(userID string) string {
+	dc.mu.RLock()
+	defer dc.mu.RUnlock()
+	return dc.configuredUsers[userID]
+}
+
+func (dc *discordConnector) cacheChannel(channel dcChannel) {
+	if channel.ID == "" {
+		return
+	}
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if old, ok := dc.channels[channel.ID]; ok {
+		// partial channels, e.g. in interactions, don't clear what's known
+		if channel.GuildID == "" {
+			channel.GuildID = old.GuildID
+		}
+		if channel.ParentID == "" {
+			channel.ParentID = old.ParentID
+		}
+	}
+	dc.channels[channel.ID] = channel
+}
+
+func (dc *discordConnector) forgetChannel(channelID string) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	delete(dc.channels, channelID)
+}
+
+// lookupChannel returns a channel from the cache, or from Discord.
+func (dc *discordConnector) lookupChannel(channelID string) (dcChannel, bool) {
+	dc.mu.RLock()
+	channel, ok := dc.channels[channelID]
+	dc.mu.RUnlock()
+	if ok {
+		return channel, true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	found, err := dc.api.getChannel(ctx, channelID)
+	if err != nil {
+		if !isNotFound(err) {
+			dc.Log(robot.Debug, "Discord: unable to look up channel %s: %v", channelID, err)
+		}
+		return dcChannel{}, false
+	}
+	dc.cacheChannel(*found)
+	return *found, true
+}
+
+func (dc *discordConnector) noteDirectChannel(userID, channelID string) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	dc.directByUser[userID] = channelID
+}
+
+func (dc *discordConnector) GetProtocolUserAttribute(u, attr string) (string, robot.RetVal) {
+	userID, ok := dc.resolveUserID(u, u)
+	if !ok {
+		return "", robot.UserNotFound
+	}
+	user, ok := dc.lookupUser(userID)
+	if !ok {
+		return "", robot.UserNotFound
+	}
+	canonical := dc.canonicalName(userID)
+	var value string
+	switch strings.ToLower(strings.TrimSpace(attr)) {
+	case "name":
+		value = canonical
+		if value == "" {
+			value = user.Username
+		}
+	case "fullname", "realname":
+		value = user.GlobalName
+		if value == "" {
+			value = user.Username
+		}
+	case "internalid":
+		value = userID
+	}
+	if value == "" {
+		return "", robot.AttributeNotFound
+	}
+	return value, robot.Ok
+}
+
+// MessageHeard sends a typing indicator to the channel.
+func (dc *discordConnector) MessageHeard(user, channel string) {
+	channelID, ok := util.ExtractID(channel)
+	if !ok || channelID == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	if err := dc.api.triggerTyping(ctx, channelID); err != nil {
+		dc.Log(robot.Debug, "Discord typing indicator failed for %s: %v", channelID, err)
+	}
+}
+
+func (dc *discordConnector) DefaultHelp() []string { return nil }
+
+// JoinChannel only checks the channel is known; bots can't join guild
+// channels, they read every channel their roles allow.
+func (dc *discordConnector) JoinChannel(c string) robot.RetVal {
+	if _, ok := dc.resolveChannelID(c); !ok {
+		dc.Log(robot.Error, "Discord channel not found for: %s", c)
+		return robot.ChannelNotFound
+	}
+	return robot.Ok
+}
+
+func (dc *discordConnector) SendProtocolChannelThreadMessage(channelname, threadid, msg string, format robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	channelID, ok := dc.resolveChannelID(channelname)
+	if !ok {
+		dc.Log(robot.Error, "Discord channel not found for: %s", channelname)
+		return robot.ChannelNotFound
+	}
+	threadID := dc.resolveThreadForContext(channelID, "", threadid, msgObject)
+	if in := interactionReply(channelID, "", threadID, msgObject); in != nil {
+		return dc.sendInteractionReply(in, msgObject, "", msg, format)
+	}
+	return dc.sendMessage(channelID, "", threadID, msg, format, msgObject)
+}
+
+func (dc *discordConnector) SendProtocolUserChannelThreadMessage(userid, username, channelname, threadid, msg string, format robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	channelID, ok := dc.resolveChannelID(channelname)
+	if !ok {
+		dc.Log(robot.Error, "Discord channel not found for: %s", channelname)
+		return robot.ChannelNotFound
+	}
+	userID, ok := dc.resolveUserID(userid, username)
+	if !ok {
+		dc.Log(robot.Error, "Discord user not found for: %s", username)
+		return robot.UserNotFound
+	}
+	threadID := dc.resolveThreadForContext(channelID, userID, threadid, msgObject)
+	if in := interactionReply(channelID, userID, threadID, msgObject); in != nil {
+		return dc.sendInteractionReply(in, msgObject, userID, msg, format)
+	}
+	return dc.sendMessage(channelID, userID, threadID, msg, format, msgObject)
+}
+
+func (dc *discordConnector) SendProtocolUserMessage(user, msg string, format robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	userID, ok := dc.resolveUserID(user, user)
+	if !ok {
+		dc.Log(robot.Error, "Discord user not found for DM: %s", user)
+		return robot.UserNotFound
+	}
+	if msgObject != nil && msgObject.DirectMessage {
+		if in := interactionReply("", userID, "", msgObject); in != nil {
+			return dc.sendInteractionReply(in, msgObject, "", msg, format)
+		}
+	}
+	return dc.sendDirect(userID, msg, format)
+}
+
+func (dc *discordConnector) sendDirect(userID, msg string, format robot.MessageFormat) robot.RetVal {
+	channelID, err := dc.directChannel(userID)
+	if err != nil {
+		dc.Log(robot.Error, "Discord: unable to open a DM channel with %s: %v", userID, err)
+		return robot.FailedMessageSend
+	}
+	return dc.sendMessage(channelID, "", "", msg, format, nil)
+}
+
+// directChannel returns the DM channel for a user.
+func (dc *discordConnector) directChannel(userID string) (string, error) {
+	dc.mu.RLock()
+	channelID, ok := dc.directByUser[userID]
+	dc.mu.RUnlock()
+	if ok {
+		return channelID, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	channel, err := dc.api.createDM(ctx, userID)
+	cancel()
+	if err != nil {
+		return "", err
+	}
+	dc.cacheChannel(*channel)
+	dc.noteDirectChannel(userID, channel.ID)
+	return channel.ID, nil
+}
+
+// resolveChannelID accepts a bracketed channel ID or the name of a channel
+// in one of the robot's guilds.
+func (dc *discordConnector) resolveChannelID(channel string) (string, bool) {
+	if id, ok := util.ExtractID(channel); ok {
+		id = normalizeID(id)
+		return id, id != ""
+	}
+	name := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(channel), "#"))
+	if name == "" {
+		return "", false
+	}
+	dc.mu.RLock()
+	defer dc.mu.RUnlock()
+	found := ""
+	for id, ch := range dc.channels {
+		if ch.isThread() || ch.isDirect() || strings.ToLower(ch.Name) != name {
+			continue
+		}
+		if found != "" {
+			dc.Log(robot.Warn, "Discord channel name %q is in more than one guild; address it by ID", name)
+			return "", false
+		}
+		found = id
+	}
+	return found, found != ""
+}
+
+// resolveUserID only treats bracketed IDs and well-formed Discord IDs as
+// transport IDs; usernames are resolved through UserMap.
+func (dc *discordConnector) resolveUserID(uid, username string) (string, bool) {
+	if id, ok := util.ExtractID(uid); ok {
+		id = normalizeID(id)
+		return id, id != ""
+	}
+	if id := normalizeID(uid); id != "" {
+		return id, true
+	}
+	key := strings.ToLower(strings.TrimSpace(username))
+	if key == "" {
+		return "", false
+	}
+	dc.mu.RLock()
+	defer dc.mu.RUnlock()
+	id, ok := dc.botUserMap[key]
+	return id, ok
+}
+
+func (dc *discordConnector) resolveThreadForContext(channelID, userID, explicitThreadID string, msgObject *robot.ConnectorMessage) string {
+	threadID := strings.TrimSpace(explicitThreadID)
+	dc.mu.RLock()
+	threadResponses := dc.threadResponses
+	dc.mu.RUnlock()
+	if threadID != "" || !threadResponses || msgObject == nil {
+		return threadID
+	}
+	if msgObject.DirectMessage || msgObject.ChannelID != channelID {
+		return ""
+	}
+	if userID != "" && msgObject.UserID != userID {
+		return ""
+	}
+	return strings.TrimSpace(msgObject.ThreadID)
+}
+
+// threadChannel returns the channel to post a thread message to. Threads
+// are channels, and a thread started from a message shares the message's
+// ID, so a ThreadID that isn't a thread yet is a message to start one from.
+func (dc *discordConnector) threadChannel(channelID, threadID string, msgObject *robot.ConnectorMessage) (string, error) {
+	if thread, ok := dc.lookupChannel(threadID); ok {
+		if !thread.isThread() {
+			return "", fmt.Errorf("%s isn't a thread", threadID)
+		}
+		return thread.ID, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	thread, err := dc.api.startThread(ctx, channelID, threadID, threadName(threadID, msgObject))
+	if err != nil {
+		if errorCode(err) == codeThreadAlreadyCreated {
+			return threadID, nil
+		}
+		return "", fmt.Errorf("starting a thread from message %s: %w", threadID, err)
+	}
+	dc.cacheChannel(*thread)
+	return thread.ID, nil
+}
+
+// threadName names a new thread after the first line of the message it
+// starts from, when that's the incoming message.
+func threadName(messageID string, msgObject *robot.ConnectorMessage) string {
+	name := ""
+	if msgObject != nil && msgObject.MessageID == messageID {
+		name = strings.TrimSpace(strings.SplitN(msgObject.MessageText, "\n", 2)[0])
+	}
+	if name == "" {
+		return "Thread"
+	}
+	if utf8.RuneCountInString(name) > maxThreadNameSize {
+		name = string([]rune(name)[:maxThreadNameSize-1]) + "…"
+	}
+	return name
+}
+
+func newNonce() string {
+	return strconv.FormatInt(time.Now().UnixMilli(), 36) + "-" + strconv.FormatUint(nonceSeq.Add(1), 36)
+}
+
+func (dc *discordConnector) sendMessage(channelID, userID, threadID, msg string, format robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	parts, mentions, ok := dc.prepareMessage(userID, msg, format)
+	if !ok {
+		return robot.FailedMessageSend
+	}
+	target := channelID
+	if threadID != "" {
+		var err error
+		if target, err = dc.threadChannel(channelID, threadID, msgObject); err != nil {
+			dc.Log(robot.Error, "Discord: unable to send to thread %s in %s: %v", threadID, channelID, err)
+			return robot.FailedMessageSend
+		}
+	}
+	for _, part := range parts {
+		// the nonce makes a retried send idempotent
+		send := &dcMessageSend{
+			Content:         part,
+			Nonce:           newNonce(),
+			EnforceNonce:    true,
+			AllowedMentions: mentions,
+		}
+		if ret := dc.retrySend(target, true, func(ctx context.Context) error {
+			return dc.api.createMessage(ctx, target, send)
+		}); ret != robot.Ok {
+			return ret
+		}
+	}
+	return robot.Ok
+}
+
+// prepareMessage renders a message and splits it to Discord's size limit,
+// prefixing a mention of userID when it's set.
+func (dc *discordConnector) prepareMessage(userID, msg string, format robot.MessageFormat) ([]string, *allowedMentions, bool) {
+	message, mentioned := dc.renderMessage(msg, format)
+	if strings.TrimSpace(message) == "" {
+		dc.Log(robot.Error, "Discord: refusing to send empty message")
+		return nil, nil, false
+	}
+	if userID != "" {
+		message = "<@" + userID + "> " + message
+		mentioned = append([]string{userID}, mentioned...)
+	}
+	parts := splitMessage(message, maxMessageSize)
+	if len(parts) > maxMessageParts {
+		dc.Log(robot.Error, "Discord message exceeds maximum size (%d messages of %d characters)", maxMessageParts, maxMessageSize)
+		return nil, nil, false
+	}
+	// only mentions the robot rendered notify anyone; never @everyone
+	mentions := &allowedMentions{Parse: []string{}, Users: dedupe(mentioned)}
+	return parts, mentions, true
+}
+
+func dedupe(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	var out []string
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+func (dc *discordConnector) retrySend(target string, idempotent bool, send func(context.Context) error) robot.RetVal {
+	var err error
+	for attempt := 1; attempt <= maxSendAttempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		err = send(ctx)
+		cancel()
+		if err == nil {
+			return robot.Ok
+		}
+		delay, retry := retryDelay(err, idempotent)
+		if attempt == maxSendAttempts || !retry {
+			break
+		}
+		dc.Log(robot.Warn, "Discord send attempt %d/%d failed for %s; retrying: %v", attempt, maxSendAttempts, target, err)
+		dc.sleepForRetry(delay)
+	}
+	dc.Log(robot.Error, "Discord send failed to %s: %v", target, err)
+	return robot.FailedMessageSend
+}
+
+func (dc *discordConnector) sleepForRetry(delay time.Duration) {
+	if dc.retrySleep != nil {
+		dc.retrySleep(delay)
+		return
+	}
+	time.Sleep(delay)
+}
@@ -0,0 +1,415 @@
+package discord
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const (
+	botID     = "100000000000000001"
+	aliceID   = "200000000000000002"
+	bobID     = "300000000000000003"
+	guildID   = "400000000000000004"
+	generalID = "500000000000000005"
+	threadID  = "600000000000000006"
+	dmID      = "700000000000000007"
+	appID     = "800000000000000008"
+	botRoleID = "900000000000000009"
+)
+
+type testHandler struct {
+	cfg      config
+	incoming chan *robot.ConnectorMessage
+}
+
+func (h *testHandler) IncomingMessage(msg *robot.ConnectorMessage) { h.incoming <- msg }
+func (h *testHandler) GetProtocolConfig(v interface{}) error {
+	*(v.(*config)) = h.cfg
+	return nil
+}
+func (h *testHandler) GetBrainConfig(interface{}) error                 { return nil }
+func (h *testHandler) GetEventStrings() *[]string                       { return nil }
+func (h *testHandler) GetHistoryConfig(interface{}) error               { return nil }
+func (h *testHandler) GetBotInfo() robot.BotInfo                        { return robot.BotInfo{UserName: "bishop"} }
+func (h *testHandler) SetBotID(string)                                  {}
+func (h *testHandler) SetTerminalWriter(io.Writer)                      {}
+func (h *testHandler) SetBotMention(string)                             {}
+func (h *testHandler) GetLogLevel() robot.LogLevel                      { return robot.Debug }
+func (h *testHandler) GetInstallPath() string                           { return "" }
+func (h *testHandler) GetConfigPath() string                            { return "" }
+func (h *testHandler) ReadEncryptedFile(string) ([]byte, error)         { return nil, nil }
+func (h *testHandler) Log(_ robot.LogLevel, m string, v ...interface{}) {}
+func (h *testHandler) GetDirectory(string) error                        { return nil }
+
+type request struct {
+	Method string
+	Path   string
+	Body   map[string]interface{}
+	Raw    []byte
+}
+
+// fakeServer stands in for the REST API and the gateway, serving canned
+// gateway events and recording REST requests.
+type fakeServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	events   []string
+	identify map[string]interface{}
+	requests []request
+}
+
+func newFakeServer(t *testing.T, events ...string) *fakeServer {
+	fs := &fakeServer{events: events}
+	upgrader := websocket.Upgrader{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /gateway", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrading websocket: %v", err)
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"op":10,"d":{"heartbeat_interval":45000}}`))
+		var identify gatewayPayload
+		if err := conn.ReadJSON(&identify); err != nil || identify.Op != opIdentify {
+			t.Errorf("expected Identify, got %+v (%v)", identify, err)
+			return
+		}
+		fs.mu.Lock()
+		json.Unmarshal(identify.D, &fs.identify)
+		fs.mu.Unlock()
+		for i, ev := range fs.events {
+			conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(`{"op":0,"s":%d,%s}`, i+1, ev)))
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	mux.HandleFunc("GET /api/v10/gateway/bot", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"url":"ws://`+r.Host+`/gateway"}`)
+	})
+	mux.HandleFunc("GET /api/v10/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") != aliceID {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"code":10013,"message":"Unknown User"}`)
+			return
+		}
+		io.WriteString(w, `{"id":"`+aliceID+`","username":"alice.smith","global_name":"Alice Smith"}`)
+	})
+	mux.HandleFunc("GET /api/v10/channels/{id}", func(w http.ResponseWriter, r *http.Request) {
+		switch r.PathValue("id") {
+		case generalID:
+			io.WriteString(w, `{"id":"`+generalID+`","type":0,"guild_id":"`+guildID+`","name":"general"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"code":10003,"message":"Unknown Channel"}`)
+		}
+	})
+	mux.HandleFunc("POST /api/v10/channels/{id}/messages/{message}/threads", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"id":"`+r.PathValue("message")+`","type":11,"guild_id":"`+guildID+`","parent_id":"`+r.PathValue("id")+`","name":"thread"}`)
+	})
+	mux.HandleFunc("POST /api/v10/users/@me/channels", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"id":"`+dmID+`","type":1}`)
+	})
+	ok := func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, `{}`) }
+	noContent := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	mux.HandleFunc("POST /api/v10/channels/{id}/messages", ok)
+	mux.HandleFunc("POST /api/v10/channels/{id}/typing", noContent)
+	mux.HandleFunc("POST /api/v10/interactions/{id}/{token}/callback", noContent)
+	mux.HandleFunc("POST /api/v10/webhooks/{app}/{token}", ok)
+	mux.HandleFunc("PUT /api/v10/applications/{app}/commands", ok)
+	mux.HandleFunc("PUT /api/v10/applications/{app}/guilds/{guild}/commands", ok)
+
+	fs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			if r.Header.Get("Authorization") != "Bot secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				io.WriteString(w, `{"code":0,"message":"401: Unauthorized"}`)
+				return
+			}
+			raw, _ := io.ReadAll(r.Body)
+			req := request{Method: r.Method, Path: strings.TrimPrefix(r.URL.Path, "/api/v10"), Raw: raw}
+			json.Unmarshal(raw, &req.Body)
+			fs.mu.Lock()
+			fs.requests = append(fs.requests, req)
+			fs.mu.Unlock()
+			r.Body = io.NopCloser(strings.NewReader(string(raw)))
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(fs.Close)
+	return fs
+}
+
+// sent returns the recorded requests with the given method and path.
+func (fs *fakeServer) sent(method, path string) []request {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	var out []request
+	for _, req := range fs.requests {
+		if req.Method == method && req.Path == path {
+			out = append(out, req)
+		}
+	}
+	return out
+}
+
+func newTestConnector(t *testing.T, fs *fakeServer, c config) (*discordConnector, *testHandler) {
+	c.UserMap = map[string]string{"alice": aliceID}
+	h := &testHandler{cfg: c, incoming: make(chan *robot.ConnectorMessage, 10)}
+	dc := newDiscordConnector(h, newAPIClient(fs.URL+"/api/v10", "secret"), &dcUser{ID: botID, Username: "bishop-bot", Bot: true}, appID, c)
+	dc.retrySleep = func(time.Duration) {}
+	return dc, h
+}
+
+func dispatch(event, data string) string {
+	return `"t":"` + event + `","d":` + data
+}
+
+func messageEvent(id, channelID, guild, authorID, content string, kind int) string {
+	m := map[string]interface{}{
+		"id": id, "channel_id": channelID, "type": kind, "content": content,
+		"author": map[string]string{"id": authorID, "username": "user" + authorID[:1]},
+	}
+	if guild != "" {
+		m["guild_id"] = guild
+	}
+	data, _ := json.Marshal(m)
+	return dispatch("MESSAGE_CREATE", string(data))
+}
+
+func TestGatewayNormalizesMessages(t *testing.T) {
+	fs := newFakeServer(t,
+		dispatch("READY", `{"session_id":"s1","resume_gateway_url":"ws://resume.invalid","user":{"id":"`+botID+`","username":"bishop-bot"}}`),
+		dispatch("GUILD_CREATE", `{"id":"`+guildID+`",
+			"channels":[{"id":"`+generalID+`","type":0,"name":"general"}],
+			"threads":[{"id":"`+threadID+`","type":11,"name":"deploys","parent_id":"`+generalID+`"}],
+			"roles":[{"id":"`+botRoleID+`","name":"Bishop","tags":{"bot_id":"`+botID+`"}}]}`),
+		messageEvent("m1", generalID, guildID, aliceID, "<@"+botID+"> ping", messageDefault),
+		messageEvent("m2", generalID, guildID, aliceID, "joined", 7),
+		messageEvent("m3", threadID, guildID, bobID, "hi <@!"+aliceID+">, see <#"+generalID+"> <:party:123>", messageReply),
+		messageEvent("m4", dmID, "", aliceID, "<@&"+botRoleID+"> help", messageDefault),
+		messageEvent("m5", generalID, guildID, botID, "pong", messageDefault),
+	)
+	dc, h := newTestConnector(t, fs, config{})
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		dc.Run(stop)
+		close(done)
+	}()
+
+	var msgs []*robot.ConnectorMessage
+	for len(msgs) < 4 {
+		select {
+		case msg := <-h.incoming:
+			msgs = append(msgs, msg)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out with %d messages", len(msgs))
+		}
+	}
+	close(stop)
+	<-done
+
+	channel, threaded, direct, self := msgs[0], msgs[1], msgs[2], msgs[3]
+	if channel.MessageText != "@bishop ping" || channel.UserName != "alice" || !channel.ValidatedUser ||
+		channel.ChannelID != generalID || channel.ChannelName != "general" || channel.ThreadID != "m1" || channel.ThreadedMessage {
+		t.Errorf("channel message = %+v", channel)
+	}
+	if threaded.MessageText != "hi @alice, see #general :party:" || threaded.ValidatedUser ||
+		threaded.ChannelID != generalID || threaded.ThreadID != threadID || !threaded.ThreadedMessage {
+		t.Errorf("thread message = %+v", threaded)
+	}
+	if !direct.DirectMessage || direct.ChannelID != "" || direct.ThreadID != "" || direct.MessageText != "@bishop help" {
+		t.Errorf("direct message = %+v", direct)
+	}
+	if !self.SelfMessage || self.MessageText != "pong" {
+		t.Errorf("self message = %+v", self)
+	}
+	if fs.identify["token"] != "secret" || fs.identify["intents"] != float64(gatewayIntents) {
+		t.Errorf("identify = %v", fs.identify)
+	}
+	if dc.sessionID != "s1" || dc.seq.Load() != 7 {
+		t.Errorf("session = %q seq %d", dc.sessionID, dc.seq.Load())
+	}
+	if id, _ := dc.directChannel(aliceID); id != dmID {
+		t.Errorf("DM channel for alice = %q, want %q", id, dmID)
+	}
+}
+
+func newInteraction(t *testing.T, name, option, value string) *interaction {
+	t.Helper()
+	raw := `{"id":"i-` + name + `","type":2,"token":"tok-` + name + `","guild_id":"` + guildID + `",
+		"channel":{"id":"` + generalID + `","type":0,"name":"general"},
+		"member":{"user":{"id":"` + aliceID + `","username":"alice.smith"}},
+		"data":{"name":"` + name + `","options":[{"name":"` + option + `","type":3,"value":"` + value + `"}]}}`
+	var in interaction
+	if err := json.Unmarshal([]byte(raw), &in); err != nil {
+		t.Fatal(err)
+	}
+	return &in
+}
+
+func TestHiddenCommandRepliesAreEphemeral(t *testing.T) {
+	fs := newFakeServer(t)
+	dc, h := newTestConnector(t, fs, config{})
+
+	dc.handleInteraction(newInteraction(t, "bishop", "command", "ping"))
+	callbacks := fs.sent("POST", "/interactions/i-bishop/tok-bishop/callback")
+	if len(callbacks) != 1 || string(callbacks[0].Raw) != `{"type":5,"data":{"flags":64}}` {
+		t.Fatalf("callbacks = %+v", callbacks)
+	}
+	msg := <-h.incoming
+	if !msg.HiddenMessage || !msg.BotMessage || msg.MessageText != "ping" || msg.ChannelID != generalID ||
+		msg.ThreadID != "" || msg.UserName != "alice" {
+		t.Fatalf("hidden message = %+v", msg)
+	}
+
+	if ret := dc.SendProtocolUserChannelThreadMessage(aliceID, "alice", "<"+generalID+">", "", "pong", robot.Raw, msg); ret != robot.Ok {
+		t.Fatalf("send returned %v", ret)
+	}
+	followups := fs.sent("POST", "/webhooks/"+appID+"/tok-bishop")
+	if len(followups) != 1 || followups[0].Body["content"] != "pong" || followups[0].Body["flags"] != float64(flagEphemeral) {
+		t.Fatalf("followups = %+v", followups)
+	}
+	if posts := fs.sent("POST", "/channels/"+generalID+"/messages"); len(posts) != 0 {
+		t.Errorf("channel posts = %+v", posts)
+	}
+	if got := dc.FormatHiddenCommand("help"); got != "/bishop command:help" {
+		t.Errorf("FormatHiddenCommand = %q", got)
+	}
+}
+
+func TestRegisterPluginCommands(t *testing.T) {
+	fs := newFakeServer(t)
+	dc, h := newTestConnector(t, fs, config{RegisterPluginCommands: true, CommandGuilds: []string{guildID}})
+	catalog := []robot.CommandHelp{
+		{Plugin: "lists", Command: "add", Usage: "(alias) add <item> to list", Summary: "Adds an item to a list."},
+		{Plugin: "admin", Command: "reload", Usage: "reload"},
+		{Plugin: "other", Command: "add", Usage: "(alias) add <n>"},
+		{Plugin: "x", Command: "bishop"},
+	}
+	dc.RegisterCommands(catalog)
+	dc.RegisterCommands(catalog)
+
+	puts := fs.sent("PUT", "/applications/"+appID+"/guilds/"+guildID+"/commands")
+	if len(puts) != 1 {
+		t.Fatalf("got %d command registrations, want 1", len(puts))
+	}
+	var commands []appCommand
+	if err := json.Unmarshal(puts[0].Raw, &commands); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, cmd := range commands {
+		names = append(names, cmd.Name)
+		if cmd.Contexts != nil {
+			t.Errorf("guild command %s has contexts %v", cmd.Name, cmd.Contexts)
+		}
+	}
+	if want := []string{"bishop", "add", "reload", "other-add", "x-bishop"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("registered %v, want %v", names, want)
+	}
+	if commands[1].Description != "Adds an item to a list." || commands[1].Options[0].Description != "(alias) add <item> to list" {
+		t.Errorf("add command = %+v", commands[1])
+	}
+
+	dc.handleInteraction(newInteraction(t, "add", "input", "milk to list"))
+	if callbacks := fs.sent("POST", "/interactions/i-add/tok-add/callback"); len(callbacks) != 1 || string(callbacks[0].Raw) != `{"type":5}` {
+		t.Fatalf("callbacks = %+v", callbacks)
+	}
+	msg := <-h.incoming
+	if msg.HiddenMessage || !msg.BotMessage || msg.MessageText != "add milk to list" {
+		t.Fatalf("plugin command message = %+v", msg)
+	}
+	if ret := dc.SendProtocolChannelThreadMessage("<"+generalID+">", "", "added", robot.Raw, msg); ret != robot.Ok {
+		t.Fatalf("send returned %v", ret)
+	}
+	if followups := fs.sent("POST", "/webhooks/"+appID+"/tok-add"); len(followups) != 1 || followups[0].Body["flags"] != nil {
+		t.Errorf("followups = %+v", followups)
+	}
+
+	dc.handleInteraction(newInteraction(t, "gone", "input", ""))
+	if callbacks := fs.sent("POST", "/interactions/i-gone/tok-gone/callback"); len(callbacks) != 1 || callbacks[0].Body["type"] != float64(responseChannelMessage) {
+		t.Errorf("unknown command callbacks = %+v", callbacks)
+	}
+	select {
+	case msg := <-h.incoming:
+		t.Errorf("unknown command dispatched %+v", msg)
+	default:
+	}
+}
+
+func TestThreadResponsesStartThreads(t *testing.T) {
+	fs := newFakeServer(t)
+	dc, _ := newTestConnector(t, fs, config{ThreadResponses: true})
+	incoming := &robot.ConnectorMessage{UserID: aliceID, ChannelID: generalID, MessageID: "m1", ThreadID: "m1", MessageText: "deploy the thing\nplease"}
+	for i := 0; i < 2; i++ {
+		if ret := dc.SendProtocolChannelThreadMessage("<"+generalID+">", "", "**done**, @alice", robot.BasicMarkdown, incoming); ret != robot.Ok {
+			t.Fatalf("send returned %v", ret)
+		}
+	}
+	threads := fs.sent("POST", "/channels/"+generalID+"/messages/m1/threads")
+	if len(threads) != 1 || threads[0].Body["name"] != "deploy the thing" {
+		t.Fatalf("thread starts = %+v", threads)
+	}
+	posts := fs.sent("POST", "/channels/m1/messages")
+	if len(posts) != 2 {
+		t.Fatalf("thread posts = %+v", posts)
+	}
+	post := posts[0].Body
+	mentions, _ := post["allowed_mentions"].(map[string]interface{})
+	if post["content"] != "**done**, <@"+aliceID+">" || post["enforce_nonce"] != true || post["nonce"] == posts[1].Body["nonce"] ||
+		fmt.Sprint(mentions["parse"]) != "[]" || fmt.Sprint(mentions["users"]) != "["+aliceID+"]" {
+		t.Errorf("post = %v", post)
+	}
+}
+
+func TestSendUserMessageOpensDirectChannel(t *testing.T) {
+	fs := newFakeServer(t)
+	dc, _ := newTestConnector(t, fs, config{})
+	for i := 0; i < 2; i++ {
+		if ret := dc.SendProtocolUserMessage("alice", "hello", robot.Raw, nil); ret != robot.Ok {
+			t.Fatalf("send returned %v", ret)
+		}
+	}
+	if opens := fs.sent("POST", "/users/@me/channels"); len(opens) != 1 || opens[0].Body["recipient_id"] != aliceID {
+		t.Errorf("DM channel requests = %+v", opens)
+	}
+	if posts := fs.sent("POST", "/channels/"+dmID+"/messages"); len(posts) != 2 {
+		t.Errorf("DM posts = %+v", posts)
+	}
+	if ret := dc.SendProtocolUserMessage("carol", "hello", robot.Raw, nil); ret != robot.UserNotFound {
+		t.Errorf("unmapped user = %v, want UserNotFound", ret)
+	}
+}
+
+func TestGetProtocolUserAttribute(t *testing.T) {
+	fs := newFakeServer(t)
+	dc, _ := newTestConnector(t, fs, config{})
+	for attr, want := range map[string]string{
+		"name":       "alice",
+		"fullname":   "Alice Smith",
+		"internalid": aliceID,
+	} {
+		if got, ret := dc.GetProtocolUserAttribute("alice", attr); ret != robot.Ok || got != want {
+			t.Errorf("attribute %s = %q, %v; want %q", attr, got, ret, want)
+		}
+	}
+	if _, ret := dc.GetProtocolUserAttribute("alice", "email"); ret != robot.AttributeNotFound {
+		t.Errorf("email = %v, want AttributeNotFound", ret)
+	}
+}
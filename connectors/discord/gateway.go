@@ -0,0 +1,316 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"runtime"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const (
+	gatewayVersion   = "10"
+	helloTimeout     = 30 * time.Second
+	writeTimeout     = 10 * time.Second
+	minStreamBackoff = time.Second
+	maxStreamBackoff = time.Minute
+)
+
+// Gateway opcodes
+const (
+	opDispatch       = 0
+	opHeartbeat      = 1
+	opIdentify       = 2
+	opResume         = 6
+	opReconnect      = 7
+	opInvalidSession = 9
+	opHello          = 10
+	opHeartbeatACK   = 11
+)
+
+// Gateway intents: guild and channel events, guild and DM messages, and
+// message content, which is privileged and has to be enabled for the bot
+// in the developer portal.
+const (
+	intentGuilds         = 1 << 0
+	intentGuildMessages  = 1 << 9
+	intentDirectMessages = 1 << 12
+	intentMessageContent = 1 << 15
+	gatewayIntents       = intentGuilds | intentGuildMessages | intentDirectMessages | intentMessageContent
+)
+
+var (
+	errReconnect      = errors.New("gateway asked the robot to reconnect")
+	errInvalidSession = errors.New("gateway invalidated the session")
+	errMissedACK      = errors.New("gateway missed a heartbeat ACK")
+)
+
+// gatewayFatalError is a close code that reconnecting won't fix, such as a
+// bad token or disallowed intents.
+type gatewayFatalError struct {
+	err *websocket.CloseError
+}
+
+func (e *gatewayFatalError) Error() string { return e.err.Error() }
+
+type gatewayPayload struct {
+	Op int             `json:"op"`
+	D  json.RawMessage `json:"d"`
+	S  *int64          `json:"s"`
+	T  string          `json:"t"`
+}
+
+type gatewayCommand struct {
+	Op int         `json:"op"`
+	D  interface{} `json:"d"`
+}
+
+// Run connects to the gateway and reads it until stop is closed,
+// reconnecting with backoff when the connection drops, and resuming the
+// session when Discord allows it.
+func (dc *discordConnector) Run(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	backoff := minStreamBackoff
+	for ctx.Err() == nil {
+		connected, err := dc.stream(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		var fatal *gatewayFatalError
+		if errors.As(err, &fatal) {
+			dc.Log(robot.Error, "Discord gateway closed the connection and won't accept a reconnect: %v", err)
+			return
+		}
+		if connected {
+			backoff = minStreamBackoff
+		}
+		dc.Log(robot.Error, "Discord gateway connection failed, reconnecting in %s: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxStreamBackoff {
+			backoff = maxStreamBackoff
+		}
+	}
+}
+
+// stream reads events from one gateway connection until it fails or ctx is
+// cancelled; connected reports whether the handshake succeeded.
+func (dc *discordConnector) stream(ctx context.Context) (connected bool, err error) {
+	base := dc.resumeURL
+	if dc.sessionID == "" || base == "" {
+		if base, err = dc.gatewayBase(ctx); err != nil {
+			return false, err
+		}
+	}
+	conn, _, err := dc.dialer.DialContext(ctx, gatewayDialURL(base), nil)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go dc.closeOnCancel(ctx, conn, done)
+
+	conn.SetReadDeadline(time.Now().Add(helloTimeout))
+	var hello gatewayPayload
+	if err := conn.ReadJSON(&hello); err != nil {
+		return false, closeError(err)
+	}
+	var helloData struct {
+		HeartbeatInterval int64 `json:"heartbeat_interval"`
+	}
+	if hello.Op != opHello || json.Unmarshal(hello.D, &helloData) != nil || helloData.HeartbeatInterval <= 0 {
+		return false, fmt.Errorf("expected Hello, got opcode %d", hello.Op)
+	}
+	interval := time.Duration(helloData.HeartbeatInterval) * time.Millisecond
+
+	if dc.sessionID != "" {
+		err = dc.writeGateway(conn, opResume, map[string]interface{}{
+			"token":      dc.api.token,
+			"session_id": dc.sessionID,
+			"seq":        dc.seq.Load(),
+		})
+	} else {
+		err = dc.writeGateway(conn, opIdentify, map[string]interface{}{
+			"token":   dc.api.token,
+			"intents": gatewayIntents,
+			"properties": map[string]string{
+				"os":      runtime.GOOS,
+				"browser": "gopherbot",
+				"device":  "gopherbot",
+			},
+		})
+	}
+	if err != nil {
+		return false, err
+	}
+
+	acks := make(chan struct{}, 1)
+	go dc.heartbeat(conn, interval, acks, done)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(2*interval + writeTimeout))
+		var p gatewayPayload
+		if err := conn.ReadJSON(&p); err != nil {
+			if dc.missedACK.Swap(false) {
+				return true, errMissedACK
+			}
+			return true, dc.closeSessionError(err)
+		}
+		if p.S != nil {
+			dc.seq.Store(*p.S)
+		}
+		switch p.Op {
+		case opDispatch:
+			dc.handleDispatch(p.T, p.D)
+		case opHeartbeat:
+			if err := dc.writeGateway(conn, opHeartbeat, dc.lastSeq()); err != nil {
+				return true, err
+			}
+		case opHeartbeatACK:
+			select {
+			case acks <- struct{}{}:
+			default:
+			}
+		case opReconnect:
+			return true, errReconnect
+		case opInvalidSession:
+			var resumable bool
+			_ = json.Unmarshal(p.D, &resumable)
+			if !resumable {
+				dc.clearSession()
+			}
+			return true, errInvalidSession
+		}
+	}
+}
+
+// gatewayBase returns the gateway URL, looking it up once.
+func (dc *discordConnector) gatewayBase(ctx context.Context) (string, error) {
+	if dc.gatewayURL != "" {
+		return dc.gatewayURL, nil
+	}
+	lctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+	gw, err := dc.api.gatewayURL(lctx)
+	if err != nil {
+		return "", fmt.Errorf("looking up the gateway URL: %w", err)
+	}
+	dc.gatewayURL = gw
+	return gw, nil
+}
+
+func gatewayDialURL(base string) string {
+	u, err := url.Parse(base)
+	if err != nil {
+		return base
+	}
+	q := u.Query()
+	q.Set("v", gatewayVersion)
+	q.Set("encoding", "json")
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// closeOnCancel closes the connection when ctx is cancelled.
+func (dc *discordConnector) closeOnCancel(ctx context.Context, conn *websocket.Conn, done <-chan struct{}) {
+	select {
+	case <-done:
+	case <-ctx.Done():
+		dc.wsMu.Lock()
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(writeTimeout))
+		dc.wsMu.Unlock()
+		conn.Close()
+	}
+}
+
+// heartbeat sends heartbeats at the interval Discord asked for, closing
+// the connection when one isn't acknowledged (a zombied connection).
+func (dc *discordConnector) heartbeat(conn *websocket.Conn, interval time.Duration, acks <-chan struct{}, done <-chan struct{}) {
+	// the first heartbeat is jittered, as Discord asks
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(interval))))
+	defer timer.Stop()
+	acked := true
+	for {
+		select {
+		case <-done:
+			return
+		case <-acks:
+			acked = true
+		case <-timer.C:
+			if !acked {
+				dc.missedACK.Store(true)
+				conn.Close()
+				return
+			}
+			acked = false
+			if err := dc.writeGateway(conn, opHeartbeat, dc.lastSeq()); err != nil {
+				dc.Log(robot.Debug, "Discord heartbeat failed: %v", err)
+			}
+			timer.Reset(interval)
+		}
+	}
+}
+
+func (dc *discordConnector) writeGateway(conn *websocket.Conn, op int, data interface{}) error {
+	dc.wsMu.Lock()
+	defer dc.wsMu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return conn.WriteJSON(gatewayCommand{Op: op, D: data})
+}
+
+// lastSeq is the sequence number heartbeats carry; null before the first
+// dispatch.
+func (dc *discordConnector) lastSeq() interface{} {
+	if seq := dc.seq.Load(); seq > 0 {
+		return seq
+	}
+	return nil
+}
+
+func (dc *discordConnector) clearSession() {
+	dc.sessionID = ""
+	dc.resumeURL = ""
+	dc.seq.Store(0)
+}
+
+// closeSessionError classifies a read error, dropping the session when the
+// close code means it can't be resumed.
+func (dc *discordConnector) closeSessionError(err error) error {
+	var ce *websocket.CloseError
+	if errors.As(err, &ce) {
+		switch ce.Code {
+		case 4007, 4009: // invalid seq, session timed out
+			dc.clearSession()
+		}
+	}
+	return closeError(err)
+}
+
+// closeError wraps the close codes reconnecting won't fix.
+func closeError(err error) error {
+	var ce *websocket.CloseError
+	if errors.As(err, &ce) {
+		switch ce.Code {
+		case 4004, 4010, 4011, 4012, 4013, 4014: // authentication failed, sharding, API version, intents
+			return &gatewayFatalError{err: ce}
+		}
+	}
+	return err
+}
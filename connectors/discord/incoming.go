@@ -0,0 +1,194 @@
+package discord
+
+import (
+	"encoding/json"
+	"regexp"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+// mentionPattern matches user, role and channel mentions, and custom emoji.
+var mentionPattern = regexp.MustCompile(`<(@!?|@&|#)(\d+)>|<a?:(\w+):\d+>`)
+
+type readyData struct {
+	SessionID        string `json:"session_id"`
+	ResumeGatewayURL string `json:"resume_gateway_url"`
+	User             dcUser `json:"user"`
+}
+
+type guildData struct {
+	ID       string      `json:"id"`
+	Channels []dcChannel `json:"channels"`
+	Threads  []dcChannel `json:"threads"`
+	Roles    []dcRole    `json:"roles"`
+}
+
+func (dc *discordConnector) handleDispatch(event string, data json.RawMessage) {
+	switch event {
+	case "READY":
+		var ready readyData
+		if err := json.Unmarshal(data, &ready); err != nil {
+			dc.Log(robot.Error, "Discord: invalid READY event: %v", err)
+			return
+		}
+		dc.sessionID = ready.SessionID
+		dc.resumeURL = ready.ResumeGatewayURL
+		dc.Log(robot.Info, "Discord gateway connected as %s", ready.User.Username)
+	case "RESUMED":
+		dc.Log(robot.Info, "Discord gateway session resumed")
+	case "GUILD_CREATE":
+		var guild guildData
+		if err := json.Unmarshal(data, &guild); err != nil {
+			dc.Log(robot.Debug, "Ignoring Discord GUILD_CREATE with invalid data: %v", err)
+			return
+		}
+		dc.cacheGuild(&guild)
+	case "GUILD_ROLE_CREATE", "GUILD_ROLE_UPDATE":
+		var ev struct {
+			Role dcRole `json:"role"`
+		}
+		if json.Unmarshal(data, &ev) == nil && ev.Role.ID != "" {
+			dc.mu.Lock()
+			dc.roles[ev.Role.ID] = ev.Role
+			dc.mu.Unlock()
+		}
+	case "CHANNEL_CREATE", "CHANNEL_UPDATE", "THREAD_CREATE", "THREAD_UPDATE":
+		var channel dcChannel
+		if json.Unmarshal(data, &channel) == nil {
+			dc.cacheChannel(channel)
+		}
+	case "CHANNEL_DELETE", "THREAD_DELETE":
+		var channel dcChannel
+		if json.Unmarshal(data, &channel) == nil {
+			dc.forgetChannel(channel.ID)
+		}
+	case "MESSAGE_CREATE":
+		var m dcMessage
+		if err := json.Unmarshal(data, &m); err != nil {
+			dc.Log(robot.Debug, "Ignoring Discord MESSAGE_CREATE with invalid data: %v", err)
+			return
+		}
+		if msg, ok := dc.normalizeIncomingMessage(&m); ok {
+			dc.IncomingMessage(msg)
+		}
+	case "INTERACTION_CREATE":
+		var in interaction
+		if err := json.Unmarshal(data, &in); err != nil {
+			dc.Log(robot.Debug, "Ignoring Discord INTERACTION_CREATE with invalid data: %v", err)
+			return
+		}
+		dc.handleInteraction(&in)
+	}
+}
+
+// cacheGuild caches a guild's channels, active threads and roles; the
+// channels in GUILD_CREATE don't carry their guild ID.
+func (dc *discordConnector) cacheGuild(guild *guildData) {
+	for _, channel := range append(guild.Channels, guild.Threads...) {
+		channel.GuildID = guild.ID
+		dc.cacheChannel(channel)
+	}
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	for _, role := range guild.Roles {
+		dc.roles[role.ID] = role
+	}
+}
+
+func (dc *discordConnector) normalizeIncomingMessage(m *dcMessage) (*robot.ConnectorMessage, bool) {
+	self := m.Author.ID == dc.selfID
+	switch {
+	case m.Type != messageDefault && m.Type != messageReply && !(self && m.Type == messageChatInputCommand):
+		// joins, pins, boosts and other system messages
+		return nil, false
+	case m.WebhookID != "" && !self:
+		return nil, false
+	case m.Author.ID == "":
+		return nil, false
+	}
+	dc.cacheUser(m.Author)
+	for _, user := range m.Mentions {
+		dc.cacheUser(user)
+	}
+
+	msg := &robot.ConnectorMessage{
+		Protocol:      "discord",
+		UserID:        m.Author.ID,
+		MessageID:     m.ID,
+		DirectMessage: m.GuildID == "",
+		MessageText:   dc.normalizeMessageText(m.Content),
+		SelfMessage:   self,
+		MessageObject: m,
+		Client:        dc.api,
+	}
+	if msg.DirectMessage {
+		if !self {
+			dc.noteDirectChannel(m.Author.ID, m.ChannelID)
+		}
+	} else {
+		dc.setChannel(msg, m.ChannelID, m.ID)
+	}
+	if !self {
+		msg.UserName = dc.canonicalName(m.Author.ID)
+		msg.ValidatedUser = msg.UserName != ""
+	}
+	return msg, true
+}
+
+// setChannel sets the channel and thread of a guild message. In a thread,
+// the channel is the thread's parent and ThreadID is the thread; elsewhere
+// ThreadID is messageID, which a reply can start a thread from.
+func (dc *discordConnector) setChannel(msg *robot.ConnectorMessage, channelID, messageID string) {
+	channel, _ := dc.lookupChannel(channelID)
+	if channel.isThread() && channel.ParentID != "" {
+		parent, _ := dc.lookupChannel(channel.ParentID)
+		msg.ChannelID = channel.ParentID
+		msg.ChannelName = parent.Name
+		msg.ThreadID = channel.ID
+		msg.ThreadedMessage = true
+		return
+	}
+	msg.ChannelID = channelID
+	msg.ChannelName = channel.Name
+	msg.ThreadID = messageID
+}
+
+// normalizeMessageText rewrites mentions of the robot and mapped users to
+// plain @username text. Other users become @ their Discord username,
+// channels #name and custom emoji :name:; the robot's managed role counts
+// as a mention of the robot.
+func (dc *discordConnector) normalizeMessageText(text string) string {
+	return mentionPattern.ReplaceAllStringFunc(text, func(match string) string {
+		parts := mentionPattern.FindStringSubmatch(match)
+		if parts[3] != "" {
+			return ":" + parts[3] + ":"
+		}
+		id := parts[2]
+		dc.mu.RLock()
+		defer dc.mu.RUnlock()
+		switch parts[1] {
+		case "@", "@!":
+			if id == dc.selfID {
+				return "@" + dc.botName
+			}
+			if name := dc.configuredUsers[id]; name != "" {
+				return "@" + name
+			}
+			if user, ok := dc.usersByID[id]; ok && user.Username != "" {
+				return "@" + user.Username
+			}
+		case "@&":
+			if role, ok := dc.roles[id]; ok {
+				if role.Tags != nil && role.Tags.BotID == dc.selfID {
+					return "@" + dc.botName
+				}
+				return "@" + role.Name
+			}
+		case "#":
+			if channel, ok := dc.channels[id]; ok && channel.Name != "" {
+				return "#" + channel.Name
+			}
+		}
+		return match
+	})
+}
@@ -0,0 +1,408 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const (
+	interactionApplicationCommand = 2
+	responseChannelMessage        = 4
+	responseDeferredMessage       = 5
+	flagEphemeral                 = 1 << 6
+
+	commandTypeChatInput = 1
+	optionTypeString     = 3
+	contextGuild         = 0
+	contextBotDM         = 1
+
+	maxCommandName        = 32
+	maxCommandDescription = 100
+	// Discord's limit on chat input commands per scope
+	maxApplicationCommands = 100
+	// interaction tokens are good for 15 minutes
+	interactionReplyWindow = 14 * time.Minute
+
+	robotCommandOption  = "command"
+	pluginCommandOption = "input"
+)
+
+// appCommand is an application command definition.
+type appCommand struct {
+	Name        string          `json:"name"`
+	Type        int             `json:"type"`
+	Description string          `json:"description"`
+	Options     []commandOption `json:"options,omitempty"`
+	Contexts    []int           `json:"contexts,omitempty"`
+}
+
+type commandOption struct {
+	Type        int    `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Required    bool   `json:"required,omitempty"`
+}
+
+// interaction is an INTERACTION_CREATE event, and the MessageObject of
+// messages that arrive as application commands.
+type interaction struct {
+	ID      string     `json:"id"`
+	Type    int        `json:"type"`
+	Token   string     `json:"token"`
+	GuildID string     `json:"guild_id"`
+	Channel *dcChannel `json:"channel"`
+	// ChannelID is only reliable when Channel is missing
+	ChannelID string `json:"channel_id"`
+	Member    *struct {
+		User dcUser `json:"user"`
+	} `json:"member"`
+	User *dcUser `json:"user"`
+	Data struct {
+		Name    string `json:"name"`
+		Options []struct {
+			Name  string      `json:"name"`
+			Value interface{} `json:"value"`
+		} `json:"options"`
+	} `json:"data"`
+
+	received time.Time
+}
+
+func (in *interaction) user() *dcUser {
+	if in.Member != nil {
+		return &in.Member.User
+	}
+	return in.User
+}
+
+func (in *interaction) option(name string) string {
+	for _, opt := range in.Data.Options {
+		if opt.Name == name {
+			if value, ok := opt.Value.(string); ok {
+				return strings.TrimSpace(value)
+			}
+		}
+	}
+	return ""
+}
+
+type interactionResponse struct {
+	Type int `json:"type"`
+	Data *struct {
+		Content string `json:"content,omitempty"`
+		Flags   int    `json:"flags,omitempty"`
+	} `json:"data,omitempty"`
+}
+
+func newInteractionResponse(kind int, content string, flags int) *interactionResponse {
+	resp := &interactionResponse{Type: kind}
+	if content != "" || flags != 0 {
+		resp.Data = &struct {
+			Content string `json:"content,omitempty"`
+			Flags   int    `json:"flags,omitempty"`
+		}{content, flags}
+	}
+	return resp
+}
+
+func (dc *discordConnector) FormatHiddenCommand(input string) string {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return "/" + dc.slashCommand
+	}
+	return "/" + dc.slashCommand + " " + robotCommandOption + ":" + input
+}
+
+// commandName converts a name to a valid application command name:
+// lowercase letters, digits, '-' and '_', up to 32 characters.
+func commandName(in string) string {
+	var out strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(strings.TrimSpace(in)) {
+		switch {
+		case (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_':
+			out.WriteRune(r)
+			dash = false
+		case !dash && out.Len() > 0:
+			out.WriteByte('-')
+			dash = true
+		}
+	}
+	name := strings.TrimRight(out.String(), "-")
+	if len(name) > maxCommandName {
+		name = strings.TrimRight(name[:maxCommandName], "-")
+	}
+	return name
+}
+
+// usageStem is the text a plugin command's application command sends before
+// its input: the literal words that start the command's Usage, e.g. "add"
+// for "(alias) add <item> to list", or the command name when the Usage has
+// none.
+func usageStem(usage, command string) string {
+	usage = strings.TrimSpace(usage)
+	usage = strings.TrimSpace(strings.TrimPrefix(usage, "(alias)"))
+	if i := strings.IndexAny(usage, "<([{|"); i >= 0 {
+		usage = usage[:i]
+	}
+	if stem := strings.Join(strings.Fields(usage), " "); stem != "" {
+		return stem
+	}
+	return command
+}
+
+func truncate(s string, max int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	return string([]rune(s)[:max-1]) + "…"
+}
+
+// buildCommands returns the application commands to register: the robot's
+// command, then, when includePlugins is set, one command per plugin
+// command; and the command text each plugin command sends.
+func (dc *discordConnector) buildCommands(catalog []robot.CommandHelp, includePlugins bool, global bool) ([]appCommand, map[string]string) {
+	commands := []appCommand{{
+		Name:        dc.slashCommand,
+		Type:        commandTypeChatInput,
+		Description: truncate("Send a command to "+dc.botName+"; replies are only shown to you", maxCommandDescription),
+		Options: []commandOption{{
+			Type:        optionTypeString,
+			Name:        robotCommandOption,
+			Description: truncate("The command, as you'd type it after mentioning "+dc.botName, maxCommandDescription),
+			Required:    true,
+		}},
+	}}
+	stems := make(map[string]string)
+	if includePlugins {
+		taken := map[string]bool{dc.slashCommand: true}
+		for _, help := range catalog {
+			name := commandName(help.Command)
+			if name == "" || taken[name] {
+				name = commandName(help.Plugin + "-" + help.Command)
+			}
+			if name == "" || taken[name] {
+				dc.Log(robot.Warn, "Discord: not registering command '%s' of plugin '%s'; its name is taken", help.Command, help.Plugin)
+				continue
+			}
+			if len(commands) == maxApplicationCommands {
+				dc.Log(robot.Warn, "Discord: only the first %d plugin commands are registered", maxApplicationCommands-1)
+				break
+			}
+			taken[name] = true
+			description := help.Summary
+			if strings.TrimSpace(description) == "" {
+				description = help.Usage
+			}
+			if strings.TrimSpace(description) == "" {
+				description = help.Plugin + " " + help.Command
+			}
+			inputDescription := help.Usage
+			if strings.TrimSpace(inputDescription) == "" {
+				inputDescription = "arguments for " + help.Command
+			}
+			commands = append(commands, appCommand{
+				Name:        name,
+				Type:        commandTypeChatInput,
+				Description: truncate(description, maxCommandDescription),
+				Options: []commandOption{{
+					Type:        optionTypeString,
+					Name:        pluginCommandOption,
+					Description: truncate(inputDescription, maxCommandDescription),
+				}},
+			})
+			stems[name] = usageStem(help.Usage, help.Command)
+		}
+	}
+	if global {
+		for i := range commands {
+			commands[i].Contexts = []int{contextGuild, contextBotDM}
+		}
+	}
+	return commands, stems
+}
+
+// RegisterCommands implements robot.CommandRegistrar: it registers the
+// robot's application command, and the plugin commands when
+// RegisterPluginCommands is set, skipping the requests when nothing
+// changed since the last registration.
+func (dc *discordConnector) RegisterCommands(catalog []robot.CommandHelp) {
+	dc.mu.RLock()
+	includePlugins := dc.registerPluginCommands
+	dc.mu.RUnlock()
+	global := len(dc.commandGuilds) == 0
+	commands, stems := dc.buildCommands(catalog, includePlugins, global)
+
+	dc.mu.Lock()
+	dc.pluginCommands = stems
+	dc.mu.Unlock()
+
+	key, err := json.Marshal(commands)
+	if err != nil {
+		dc.Log(robot.Error, "Discord: encoding application commands: %v", err)
+		return
+	}
+	dc.registerMu.Lock()
+	defer dc.registerMu.Unlock()
+	if string(key) == dc.registered {
+		return
+	}
+	scopes := dc.commandGuilds
+	if global {
+		scopes = []string{""}
+	}
+	for _, guild := range scopes {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		err := dc.api.overwriteCommands(ctx, dc.appID, guild, commands)
+		cancel()
+		if err != nil {
+			if guild == "" {
+				guild = "global"
+			}
+			dc.Log(robot.Error, "Discord: unable to register application commands (%s): %v", guild, err)
+			return
+		}
+	}
+	dc.registered = string(key)
+	dc.Log(robot.Info, "Discord: registered %d application command(s)", len(commands))
+}
+
+// handleInteraction acknowledges an application command with a deferred
+// response and passes its text to the engine. The robot's own command is a
+// hidden command, answered with ephemeral followups; plugin commands are
+// answered in the channel.
+func (dc *discordConnector) handleInteraction(in *interaction) {
+	if in.Type != interactionApplicationCommand {
+		return
+	}
+	in.received = time.Now()
+	user := in.user()
+	if user == nil || normalizeID(user.ID) == "" {
+		dc.Log(robot.Warn, "Discord: ignoring application command without a user")
+		return
+	}
+	dc.cacheUser(*user)
+
+	var text string
+	hidden := false
+	if in.Data.Name == dc.slashCommand {
+		text, hidden = in.option(robotCommandOption), true
+	} else {
+		dc.mu.RLock()
+		stem, ok := dc.pluginCommands[in.Data.Name]
+		dc.mu.RUnlock()
+		if !ok {
+			dc.Log(robot.Warn, "Discord: ignoring unknown application command /%s", in.Data.Name)
+			dc.respond(in, newInteractionResponse(responseChannelMessage, "That command isn't available any more.", flagEphemeral))
+			return
+		}
+		text = strings.TrimSpace(stem + " " + in.option(pluginCommandOption))
+	}
+	flags := 0
+	if hidden {
+		flags = flagEphemeral
+	}
+	if !dc.respond(in, newInteractionResponse(responseDeferredMessage, "", flags)) {
+		return
+	}
+
+	canonicalUser := dc.canonicalName(user.ID)
+	msg := &robot.ConnectorMessage{
+		Protocol:      "discord",
+		UserID:        user.ID,
+		UserName:      canonicalUser,
+		ValidatedUser: canonicalUser != "",
+		MessageID:     in.ID,
+		BotMessage:    true,
+		HiddenMessage: hidden,
+		MessageText:   dc.normalizeMessageText(text),
+		MessageObject: in,
+		Client:        dc.api,
+	}
+	channelID := in.ChannelID
+	if in.Channel != nil {
+		channelID = in.Channel.ID
+		if in.GuildID != "" {
+			channel := *in.Channel
+			channel.GuildID = in.GuildID
+			dc.cacheChannel(channel)
+		}
+	}
+	if in.GuildID == "" {
+		msg.DirectMessage = true
+		dc.noteDirectChannel(user.ID, channelID)
+	} else {
+		// ThreadID is only set in threads; commands aren't messages, so
+		// there's nothing to start a thread from
+		dc.setChannel(msg, channelID, "")
+	}
+	dc.IncomingMessage(msg)
+}
+
+func (dc *discordConnector) respond(in *interaction, resp *interactionResponse) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	if err := dc.api.createInteractionResponse(ctx, in.ID, in.Token, resp); err != nil {
+		dc.Log(robot.Error, "Discord: unable to respond to application command /%s: %v", in.Data.Name, err)
+		return false
+	}
+	return true
+}
+
+// interactionReply returns the application command a reply answers: one
+// back to the invoking user (or, for plugin commands, to the channel and
+// thread) where the command was used.
+func interactionReply(channelID, userID, threadID string, msgObject *robot.ConnectorMessage) *interaction {
+	if msgObject == nil {
+		return nil
+	}
+	in, ok := msgObject.MessageObject.(*interaction)
+	if !ok || msgObject.ChannelID != channelID {
+		return nil
+	}
+	if userID != "" && userID != msgObject.UserID {
+		return nil
+	}
+	if msgObject.HiddenMessage {
+		return in
+	}
+	if threadID != msgObject.ThreadID || time.Since(in.received) > interactionReplyWindow {
+		return nil
+	}
+	return in
+}
+
+// sendInteractionReply sends a reply as an interaction followup; hidden
+// replies are ephemeral. Once the interaction token expires, hidden replies
+// go to the user by DM instead.
+func (dc *discordConnector) sendInteractionReply(in *interaction, msgObject *robot.ConnectorMessage, userID, msg string, format robot.MessageFormat) robot.RetVal {
+	hidden := msgObject.HiddenMessage
+	if hidden && time.Since(in.received) > interactionReplyWindow {
+		return dc.sendDirect(msgObject.UserID, msg, format)
+	}
+	flags := 0
+	if hidden {
+		flags = flagEphemeral
+		userID = ""
+	}
+	parts, mentions, ok := dc.prepareMessage(userID, msg, format)
+	if !ok {
+		return robot.FailedMessageSend
+	}
+	for _, part := range parts {
+		send := &dcMessageSend{Content: part, Flags: flags, AllowedMentions: mentions}
+		// followups have no nonce, so they're only retried when rate limited
+		if ret := dc.retrySend("/"+in.Data.Name, false, func(ctx context.Context) error {
+			return dc.api.createFollowup(ctx, dc.appID, in.Token, send)
+		}); ret != robot.Ok {
+			return ret
+		}
+	}
+	return robot.Ok
+}
@@ -0,0 +1,210 @@
+package discord
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+// renderMessage converts an outgoing message to Discord markdown, returning
+// the IDs of the users it mentions.
+func (dc *discordConnector) renderMessage(msg string, format robot.MessageFormat) (string, []string) {
+	switch format {
+	case robot.BasicMarkdown:
+		// BasicMarkdown is a subset of Discord's own markdown; only
+		// mentions need translating.
+		return dc.renderBasicMarkdown(msg)
+	case robot.Fixed:
+		if strings.TrimSpace(msg) == "" {
+			return "", nil
+		}
+		fence := "```"
+		if strings.Contains(msg, fence) {
+			fence = "~~~"
+		}
+		return fence + "\n" + strings.TrimSuffix(msg, "\n") + "\n" + fence, nil
+	case robot.Variable:
+		return escapeMarkdown(msg), nil
+	default:
+		return msg, nil
+	}
+}
+
+// renderBasicMarkdown rewrites @username mentions of the robot and mapped
+// users to Discord mentions, leaving code untouched.
+func (dc *discordConnector) renderBasicMarkdown(msg string) (string, []string) {
+	var out strings.Builder
+	var mentioned []string
+	for i, part := range strings.Split(msg, "```") {
+		if i > 0 {
+			out.WriteString("```")
+		}
+		if i%2 == 1 {
+			out.WriteString(part)
+			continue
+		}
+		for j, span := range strings.Split(part, "`") {
+			if j > 0 {
+				out.WriteString("`")
+			}
+			if j%2 == 1 {
+				out.WriteString(span)
+				continue
+			}
+			mentioned = dc.replaceMentions(&out, span, mentioned)
+		}
+	}
+	return out.String(), mentioned
+}
+
+func (dc *discordConnector) replaceMentions(out *strings.Builder, msg string, mentioned []string) []string {
+	for i := 0; i < len(msg); {
+		if msg[i] != '@' || isEmailMention(msg, i) || (i > 0 && msg[i-1] == '\\') {
+			out.WriteByte(msg[i])
+			i++
+			continue
+		}
+		end := findMentionEnd(msg, i+1)
+		id := dc.mentionID(strings.ToLower(msg[i+1 : end]))
+		if end == i+1 || id == "" {
+			out.WriteByte(msg[i])
+			i++
+			continue
+		}
+		out.WriteString("<@" + id + ">")
+		mentioned = append(mentioned, id)
+		i = end
+	}
+	return mentioned
+}
+
+// mentionID returns the Discord user ID of a mapped user or the robot, or
+// "" if it isn't known.
+func (dc *discordConnector) mentionID(name string) string {
+	if name == dc.botName {
+		return dc.selfID
+	}
+	dc.mu.RLock()
+	defer dc.mu.RUnlock()
+	return dc.botUserMap[name]
+}
+
+func isEmailMention(msg string, at int) bool {
+	if at <= 0 {
+		return false
+	}
+	prev := msg[at-1]
+	return (prev >= 'A' && prev <= 'Z') || (prev >= 'a' && prev <= 'z') || (prev >= '0' && prev <= '9') || prev == '.' || prev == '_' || prev == '-'
+}
+
+// findMentionEnd returns the end of the username starting at start; a
+// trailing '.' is punctuation, not part of the name.
+func findMentionEnd(msg string, start int) int {
+	i := start
+	for i < len(msg) {
+		ch := msg[i]
+		if (ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z') || (ch >= '0' && ch <= '9') || ch == '_' || ch == '-' || ch == '.' {
+			i++
+			continue
+		}
+		break
+	}
+	for i > start && msg[i-1] == '.' {
+		i--
+	}
+	return i
+}
+
+// escapeMarkdown keeps Variable text from being rendered as markdown.
+func escapeMarkdown(msg string) string {
+	var out strings.Builder
+	lineStart := true
+	for i := 0; i < len(msg); i++ {
+		ch := msg[i]
+		switch {
+		case strings.IndexByte("\\`*_~|<", ch) >= 0:
+			out.WriteByte('\\')
+		case lineStart && (ch == '#' || ch == '>' || ch == '-'):
+			out.WriteByte('\\')
+		}
+		out.WriteByte(ch)
+		lineStart = ch == '\n'
+	}
+	return out.String()
+}
+
+// splitMessage splits a message into parts of at most limit characters,
+// breaking at line ends where it can. A code block split across parts is
+// closed at the end of one part and reopened at the start of the next.
+func splitMessage(msg string, limit int) []string {
+	if utf8.RuneCountInString(msg) <= limit {
+		return []string{msg}
+	}
+	var parts []string
+	var cur strings.Builder
+	curLen, baseLen := 0, 0
+	open := "" // the fence line of the code block cur ends in, if any
+	closing := func() string {
+		if open == "" {
+			return ""
+		}
+		return "\n" + open[:3]
+	}
+	flush := func() {
+		if text := strings.TrimRight(cur.String(), "\n"); strings.TrimSpace(text) != "" {
+			parts = append(parts, text+closing())
+		}
+		cur.Reset()
+		curLen, baseLen = 0, 0
+		if open != "" {
+			cur.WriteString(open + "\n")
+			curLen = utf8.RuneCountInString(open) + 1
+			baseLen = curLen
+		}
+	}
+	for _, line := range strings.SplitAfter(msg, "\n") {
+		fence := strings.TrimSpace(line)
+		isFence := len(fence) <= 32 && (strings.HasPrefix(fence, "```") || strings.HasPrefix(fence, "~~~"))
+		closes := isFence && open != "" && strings.HasPrefix(fence, open[:3])
+		for line != "" {
+			room := limit - curLen - len(closing())
+			switch {
+			case closes:
+				// the line itself closes the block
+				room = limit - curLen
+			case isFence && open == "":
+				// leave room to close the block the line opens
+				room -= len("\n" + fence[:3])
+			}
+			if n := utf8.RuneCountInString(line); n <= room {
+				cur.WriteString(line)
+				curLen += n
+				break
+			}
+			if curLen > baseLen {
+				flush()
+				continue
+			}
+			// a line longer than a whole part
+			if room < 1 {
+				room = 1
+			}
+			head := string([]rune(line)[:room])
+			cur.WriteString(head)
+			curLen += room
+			line = line[len(head):]
+			flush()
+		}
+		switch {
+		case closes:
+			open = ""
+		case isFence && open == "":
+			open = fence
+		}
+	}
+	if text := strings.TrimRight(cur.String(), "\n"); strings.TrimSpace(text) != "" && curLen > baseLen {
+		parts = append(parts, text)
+	}
+	return parts
+}
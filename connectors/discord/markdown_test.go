@@ -0,0 +1,85 @@
+package discord
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+func TestRenderMessage(t *testing.T) {
+	dc := &discordConnector{
+		botName:    "bishop",
+		selfID:     botID,
+		botUserMap: map[string]string{"alice": aliceID},
+	}
+	for _, tc := range []struct {
+		in       string
+		format   robot.MessageFormat
+		want     string
+		mentions []string
+	}{
+		{"ask @alice or @bishop, not @carol", robot.BasicMarkdown, "ask <@" + aliceID + "> or <@" + botID + ">, not @carol", []string{aliceID, botID}},
+		{"run `@alice` and\n```\n@alice\n```", robot.BasicMarkdown, "run `@alice` and\n```\n@alice\n```", nil},
+		{"mail bob@alice", robot.BasicMarkdown, "mail bob@alice", nil},
+		{"a  b\n", robot.Fixed, "```\na  b\n```", nil},
+		{"has ``` fence", robot.Fixed, "~~~\nhas ``` fence\n~~~", nil},
+		{"# not *a* heading_ <@1>", robot.Variable, `\# not \*a\* heading\_ \<@1>`, nil},
+		{"**raw**", robot.Raw, "**raw**", nil},
+	} {
+		got, mentions := dc.renderMessage(tc.in, tc.format)
+		if got != tc.want || !reflect.DeepEqual(mentions, tc.mentions) {
+			t.Errorf("renderMessage(%q, %v)\n got %q %v\nwant %q %v", tc.in, tc.format, got, mentions, tc.want, tc.mentions)
+		}
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	if got := splitMessage("short", 20); !reflect.DeepEqual(got, []string{"short"}) {
+		t.Errorf("short message split into %q", got)
+	}
+
+	msg := "intro line\n```go\nline one\nline two\nline three\n```\nafter"
+	want := []string{
+		"intro line\n```go\nline one\n```",
+		"```go\nline two\nline three\n```",
+		"after",
+	}
+	if got := splitMessage(msg, 30); !reflect.DeepEqual(got, want) {
+		t.Errorf("splitMessage\n got %q\nwant %q", got, want)
+	}
+
+	long := strings.Repeat("x", 45)
+	if got := splitMessage(long, 20); !reflect.DeepEqual(got, []string{long[:20], long[20:40], long[40:]}) {
+		t.Errorf("long line split into %q", got)
+	}
+}
+
+func TestCommandNames(t *testing.T) {
+	for in, want := range map[string]string{
+		"Add":                    "add",
+		"git-info":               "git-info",
+		"lists add item":         "lists-add-item",
+		"  weird!!name  ":        "weird-name",
+		strings.Repeat("ab", 20): strings.Repeat("ab", 16),
+		"!!!":                    "",
+	} {
+		if got := commandName(in); got != want {
+			t.Errorf("commandName(%q) = %q, want %q", in, got, want)
+		}
+	}
+	for _, tc := range []struct{ usage, command, want string }{
+		{"(alias) add <item> to list", "add", "add"},
+		{"(alias) switch-branch <branch>", "switch", "switch-branch"},
+		{"git-info | show-branch", "info", "git-info"},
+		{"update (configuration)", "update", "update"},
+		{"(alias) show the list [name]", "show", "show the list"},
+		{"", "ping", "ping"},
+		{"<anything>", "echo", "echo"},
+	} {
+		if got := usageStem(tc.usage, tc.command); got != tc.want {
+			t.Errorf("usageStem(%q, %q) = %q, want %q", tc.usage, tc.command, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+package discord
+
+import "github.com/lnxjedi/gopherbot/robot"
+
+func init() {
+	robot.RegisterConnector("discord", Initialize)
+}
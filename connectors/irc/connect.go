@@ -0,0 +1,183 @@
+// Package irc implements the robot.Connector interface for IRC, with TLS,
+// SASL and NickServ authentication, IRCv3 account tracking and flood
+// controlled sending.
+package irc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const (
+	saslPlain    = "plain"
+	saslExternal = "external"
+)
+
+type config struct {
+	Server          string   // host:port of the server
+	TLS             bool     // connect with TLS
+	TLSSkipVerify   bool     // don't verify the server's certificate
+	TLSCAFile       string   // PEM file of CA certificates to verify the server with
+	TLSCertFile     string   // PEM client certificate, for SASL EXTERNAL or CertFP
+	TLSKeyFile      string   // PEM key for TLSCertFile, when it isn't in the same file
+	ServerPassword  string   // sent with PASS
+	Nick            string   // the robot's nick, default the robot's name
+	User            string   // username (ident), default the nick
+	RealName        string   // real name, default the nick
+	SASL            string   // "plain" or "external"; empty disables SASL
+	Account         string   // services account, default the nick
+	AccountPassword string   // services password, for SASL PLAIN and NickServ
+	NickServ        string   // services nick for IDENTIFY, default NickServ
+	Channels        []string // channels to join, with an optional key: "#ops key"
+	UserMap         map[string]string
+}
+
+// normalizeAccount returns a services account name in canonical form, or ""
+// if in isn't one.
+func normalizeAccount(in string) string {
+	in = strings.TrimSpace(in)
+	if in == "" || in == "*" || strings.ContainsAny(in, " !@,\x00\r\n") {
+		return ""
+	}
+	return strings.ToLower(in)
+}
+
+func normalizeConfiguredUserMap(in map[string]string, h robot.Handler) map[string]string {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(in))
+	for user, account := range in {
+		name := strings.TrimSpace(user)
+		acct := normalizeAccount(account)
+		if name == "" || acct == "" {
+			h.Log(robot.Warn, "Ignoring invalid IRC UserMap entry (empty username or invalid account): %q -> %q", user, account)
+			continue
+		}
+		if strings.ToLower(name) != name {
+			h.Log(robot.Warn, "Ignoring IRC UserMap entry with uppercase username: %q", user)
+			continue
+		}
+		out[name] = acct
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// normalizeChannels parses the Channels list into channel name -> key.
+func normalizeChannels(in []string, h robot.Handler) map[string]string {
+	out := make(map[string]string, len(in))
+	for _, entry := range in {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 || len(fields) > 2 {
+			h.Log(robot.Warn, "Ignoring invalid IRC Channels entry: %q", entry)
+			continue
+		}
+		name, ok := channelTarget(fields[0], defaultChanTypes)
+		if !ok {
+			h.Log(robot.Warn, "Ignoring invalid IRC Channels entry: %q", entry)
+			continue
+		}
+		key := ""
+		if len(fields) == 2 {
+			key = fields[1]
+		}
+		out[name] = key
+	}
+	return out
+}
+
+// Initialize validates config and returns the connector; Run makes the
+// connection.
+func Initialize(handler robot.Handler, l *log.Logger) robot.InitializedConnector {
+	var c config
+	if err := handler.GetProtocolConfig(&c); err != nil {
+		handler.Log(robot.Fatal, "Unable to retrieve irc protocol configuration: %v", err)
+	}
+	server := strings.TrimSpace(c.Server)
+	host, port, err := net.SplitHostPort(server)
+	if err != nil || host == "" || port == "" {
+		handler.Log(robot.Fatal, "IRC protocol config requires Server as host:port, got %q", c.Server)
+	}
+	c.SASL = strings.ToLower(strings.TrimSpace(c.SASL))
+	switch c.SASL {
+	case "":
+	case saslPlain:
+		if c.AccountPassword == "" {
+			handler.Log(robot.Fatal, "IRC SASL plain requires AccountPassword")
+		}
+		if !c.TLS {
+			handler.Log(robot.Warn, "IRC SASL plain without TLS sends the account password in the clear")
+		}
+	case saslExternal:
+		if !c.TLS || c.TLSCertFile == "" {
+			handler.Log(robot.Fatal, "IRC SASL external requires TLS and TLSCertFile")
+		}
+	default:
+		handler.Log(robot.Fatal, "IRC protocol config has unsupported SASL mechanism %q; use plain or external", c.SASL)
+	}
+
+	var tc *tls.Config
+	if c.TLS {
+		if tc, err = tlsConfig(c, host); err != nil {
+			handler.Log(robot.Fatal, "IRC TLS configuration: %v", err)
+		}
+	} else if c.TLSCertFile != "" {
+		handler.Log(robot.Warn, "IRC TLSCertFile is ignored without TLS")
+	}
+
+	connector := newIRCConnector(handler, c)
+	connector.dial = func(ctx context.Context) (net.Conn, error) {
+		d := &net.Dialer{Timeout: dialTimeout}
+		if tc == nil {
+			return d.DialContext(ctx, "tcp", server)
+		}
+		return (&tls.Dialer{NetDialer: d, Config: tc}).DialContext(ctx, "tcp", server)
+	}
+	handler.Log(robot.Info, "IRC connector configured for %s as %s (TLS: %t, SASL: %q)", server, connector.wantNick, c.TLS, c.SASL)
+	return robot.InitializedConnector{
+		Connector:    connector,
+		Capabilities: robot.ConnectorCapabilities{HiddenCommands: true},
+	}
+}
+
+func tlsConfig(c config, host string) (*tls.Config, error) {
+	tc := &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: c.TLSSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if c.TLSCAFile != "" {
+		pem, err := os.ReadFile(c.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading TLSCAFile: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in TLSCAFile %s", c.TLSCAFile)
+		}
+		tc.RootCAs = pool
+	}
+	if c.TLSCertFile != "" {
+		keyFile := c.TLSKeyFile
+		if keyFile == "" {
+			keyFile = c.TLSCertFile
+		}
+		cert, err := tls.LoadX509KeyPair(c.TLSCertFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
+}
@@ -0,0 +1,528 @@
+package irc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+	"github.com/lnxjedi/gopherbot/robot/util"
+)
+
+const (
+	dialTimeout  = 30 * time.Second
+	writeTimeout = 10 * time.Second
+
+	defaultChanTypes   = "#&"
+	defaultPrefixModes = "ov"
+	defaultPrefixChars = "@+"
+	defaultNickServ    = "NickServ"
+)
+
+var errNotConnected = errors.New("not connected")
+
+type ircConnector struct {
+	robot.Handler
+
+	dial       func(context.Context) (net.Conn, error)
+	botName    string // the robot's gopherbot name
+	wantNick   string // configured nick
+	user       string
+	realName   string
+	password   string // server password
+	sasl       string
+	account    string
+	accountPW  string
+	nickServ   string
+	floodBurst int
+	floodDelay time.Duration
+
+	writeMu sync.Mutex // serializes writes to conn
+
+	mu              sync.RWMutex
+	conn            net.Conn
+	ready           chan struct{} // closed once conn is registered
+	queue           chan string   // outbound lines, while Run is running
+	nick            string        // current nick
+	selfMask        string        // nick!user@host, once known
+	selfAccount     string        // services account the robot is logged in as
+	typing          bool          // the server accepts client tags, for typing notices
+	caseMap         caseMapping
+	chanTypes       string
+	prefixModes     string // channel membership modes, highest first, e.g. "qaohv"
+	prefixChars     string // matching nick prefixes, e.g. "~&@%+"
+	chanModes       [4]string
+	whox            bool
+	botUserMap      map[string]string // username -> services account
+	configuredUsers map[string]string // services account -> username
+	wanted          map[string]wantedChannel
+	channels        map[string]*ircChannel // folded name -> channel the robot is in
+	users           map[string]*ircUser    // folded nick -> user the robot can see
+}
+
+type wantedChannel struct {
+	name, key string
+}
+
+type ircChannel struct {
+	name    string
+	members map[string]string // folded nick -> membership prefixes, e.g. "@+"
+}
+
+type ircUser struct {
+	nick, user, host string
+	account          string // services account, "" when not logged in
+	realName         string
+}
+
+func newIRCConnector(handler robot.Handler, c config) *ircConnector {
+	botName := strings.TrimSpace(handler.GetBotInfo().UserName)
+	if botName == "" {
+		botName = "gopherbot"
+	}
+	nick := strings.TrimSpace(c.Nick)
+	if nick == "" {
+		nick = botName
+	}
+	ic := &ircConnector{
+		Handler:     handler,
+		botName:     botName,
+		wantNick:    nick,
+		user:        firstNonEmpty(c.User, nick),
+		realName:    firstNonEmpty(c.RealName, nick),
+		password:    c.ServerPassword,
+		sasl:        c.SASL,
+		account:     firstNonEmpty(c.Account, nick),
+		accountPW:   c.AccountPassword,
+		nickServ:    firstNonEmpty(c.NickServ, defaultNickServ),
+		floodBurst:  floodBurst,
+		floodDelay:  floodDelay,
+		ready:       make(chan struct{}),
+		nick:        nick,
+		chanTypes:   defaultChanTypes,
+		prefixModes: defaultPrefixModes,
+		prefixChars: defaultPrefixChars,
+		botUserMap:  normalizeConfiguredUserMap(c.UserMap, handler),
+		wanted:      make(map[string]wantedChannel),
+		channels:    make(map[string]*ircChannel),
+		users:       make(map[string]*ircUser),
+	}
+	ic.configuredUsers = configuredUsersByAccount(ic.botUserMap)
+	for name, key := range normalizeChannels(c.Channels, handler) {
+		ic.wanted[ic.caseMap.fold(name)] = wantedChannel{name, key}
+	}
+	return ic
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func configuredUsersByAccount(userMap map[string]string) map[string]string {
+	configured := make(map[string]string, len(userMap))
+	for name, account := range userMap {
+		configured[account] = name
+	}
+	return configured
+}
+
+// Reload swaps UserMap, and joins any channels newly added to Channels.
+func (ic *ircConnector) Reload() error {
+	var c config
+	if err := ic.GetProtocolConfig(&c); err != nil {
+		return fmt.Errorf("retrieve IRC protocol configuration: %w", err)
+	}
+	newBotUserMap := normalizeConfiguredUserMap(c.UserMap, ic.Handler)
+	newConfiguredUsers := configuredUsersByAccount(newBotUserMap)
+	channels := normalizeChannels(c.Channels, ic.Handler)
+
+	var join []wantedChannel
+	ic.mu.Lock()
+	ic.botUserMap = newBotUserMap
+	ic.configuredUsers = newConfiguredUsers
+	for name, key := range channels {
+		folded := ic.caseMap.fold(name)
+		if _, ok := ic.wanted[folded]; !ok {
+			ic.wanted[folded] = wantedChannel{name, key}
+			join = append(join, ic.wanted[folded])
+		}
+	}
+	ic.mu.Unlock()
+
+	for _, ch := range join {
+		ic.joinChannel(ch)
+	}
+	ic.Log(robot.Info, "IRC connector reloaded %d configured user mapping(s)", len(newBotUserMap))
+	return nil
+}
+
+// userID returns the internal ID for a user: their services account when
+// they're logged in, otherwise nick!user@host.
+func userID(nick, user, host, account string) string {
+	if account != "" {
+		return account
+	}
+	return nick + "!" + user + "@" + host
+}
+
+func (ic *ircConnector) canonicalName(account string) string {
+	if account == "" {
+		return ""
+	}
+	ic.mu.RLock()
+	defer ic.mu.RUnlock()
+	return ic.configuredUsers[account]
+}
+
+func (ic *ircConnector) lookupUser(nick string) (ircUser, bool) {
+	ic.mu.RLock()
+	defer ic.mu.RUnlock()
+	user, ok := ic.users[ic.caseMap.fold(nick)]
+	if !ok {
+		return ircUser{}, false
+	}
+	return *user, true
+}
+
+// nickForAccount returns the nick of a visible user logged in to account.
+func (ic *ircConnector) nickForAccount(account string) (string, bool) {
+	ic.mu.RLock()
+	defer ic.mu.RUnlock()
+	for _, user := range ic.users {
+		if user.account == account {
+			return user.nick, true
+		}
+	}
+	return "", false
+}
+
+// resolveNick returns the current nick for a user. Bracketed IDs are
+// accounts or nick!user@host masks; other names are usernames, resolved
+// through UserMap, or the robot's own name.
+func (ic *ircConnector) resolveNick(uid, username string) (string, bool) {
+	id, ok := util.ExtractID(uid)
+	if !ok && strings.Contains(uid, "!") {
+		id, ok = uid, true
+	}
+	if ok {
+		if nick, _, found := strings.Cut(id, "!"); found {
+			return nick, nick != ""
+		}
+		account := normalizeAccount(id)
+		if account == "" {
+			return "", false
+		}
+		if nick, ok := ic.nickForAccount(account); ok {
+			return nick, true
+		}
+		// Networks that enforce nick ownership usually leave a logged-in
+		// user on their account name.
+		ic.Log(robot.Debug, "IRC: no visible user logged in as %s; trying the account name as a nick", account)
+		return id, true
+	}
+	key := strings.ToLower(strings.TrimSpace(username))
+	if key == "" {
+		return "", false
+	}
+	if key == ic.botName {
+		ic.mu.RLock()
+		defer ic.mu.RUnlock()
+		return ic.nick, true
+	}
+	ic.mu.RLock()
+	account, ok := ic.botUserMap[key]
+	ic.mu.RUnlock()
+	if !ok {
+		return "", false
+	}
+	if nick, ok := ic.nickForAccount(account); ok {
+		return nick, true
+	}
+	return account, true
+}
+
+func (ic *ircConnector) GetProtocolUserAttribute(u, attr string) (string, robot.RetVal) {
+	nick, ok := ic.resolveNick(u, u)
+	if !ok {
+		return "", robot.UserNotFound
+	}
+	ic.mu.RLock()
+	self := ic.caseMap.fold(nick) == ic.caseMap.fold(ic.nick)
+	selfAccount := ic.selfAccount
+	ic.mu.RUnlock()
+	user, ok := ic.lookupUser(nick)
+	if !ok && !self {
+		return "", robot.UserNotFound
+	}
+	if self {
+		user.nick = nick
+		user.account = selfAccount
+	}
+	var value string
+	switch strings.ToLower(strings.TrimSpace(attr)) {
+	case "name":
+		value = ic.canonicalName(user.account)
+		if self {
+			value = ic.botName
+		}
+		if value == "" {
+			value = user.nick
+		}
+	case "nick":
+		value = user.nick
+	case "account":
+		value = user.account
+	case "fullname", "realname":
+		value = user.realName
+	case "hostmask":
+		if user.host != "" {
+			value = user.nick + "!" + user.user + "@" + user.host
+		}
+	case "internalid":
+		if user.account != "" || user.host != "" {
+			value = userID(user.nick, user.user, user.host, user.account)
+		}
+	case "opchannels":
+		value = strings.Join(ic.opChannels(nick), " ")
+	}
+	if value == "" {
+		return "", robot.AttributeNotFound
+	}
+	return value, robot.Ok
+}
+
+// opChannels returns the names of the robot's channels where nick is a
+// channel operator or higher.
+func (ic *ircConnector) opChannels(nick string) []string {
+	ic.mu.RLock()
+	defer ic.mu.RUnlock()
+	folded := ic.caseMap.fold(nick)
+	var out []string
+	for _, ch := range ic.channels {
+		if prefixes, ok := ch.members[folded]; ok && ic.isOpLocked(prefixes) {
+			out = append(out, channelName(ch.name))
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// MessageHeard sends a typing notification to the channel when the server
+// accepts client tags.
+func (ic *ircConnector) MessageHeard(user, channel string) {
+	target, ok := util.ExtractID(channel)
+	if !ok || target == "" {
+		return
+	}
+	ic.mu.RLock()
+	typing := ic.typing
+	ic.mu.RUnlock()
+	if !typing {
+		return
+	}
+	if err := ic.writeLine("@+typing=active " + formatLine("TAGMSG", target)); err != nil {
+		ic.Log(robot.Debug, "IRC typing notification failed for %s: %v", target, err)
+	}
+}
+
+func (ic *ircConnector) DefaultHelp() []string { return nil }
+
+// JoinChannel joins a channel now if the robot is connected, and after every
+// reconnect.
+func (ic *ircConnector) JoinChannel(c string) robot.RetVal {
+	ic.mu.RLock()
+	chanTypes := ic.chanTypes
+	ic.mu.RUnlock()
+	name, ok := channelTarget(c, chanTypes)
+	if !ok {
+		ic.Log(robot.Error, "IRC channel not found for: %s", c)
+		return robot.ChannelNotFound
+	}
+	ic.mu.Lock()
+	folded := ic.caseMap.fold(name)
+	ch, ok := ic.wanted[folded]
+	if !ok {
+		ch = wantedChannel{name: name}
+		ic.wanted[folded] = ch
+	}
+	ic.mu.Unlock()
+	ic.joinChannel(ch)
+	return robot.Ok
+}
+
+// joinChannel sends JOIN when the robot is registered; otherwise the channel
+// is joined on registration.
+func (ic *ircConnector) joinChannel(ch wantedChannel) {
+	params := []string{ch.name}
+	if ch.key != "" {
+		params = append(params, ch.key)
+	}
+	if err := ic.writeLine(formatLine("JOIN", params...)); err != nil && !errors.Is(err, errNotConnected) {
+		ic.Log(robot.Error, "IRC: failed to join %s: %v", ch.name, err)
+	}
+}
+
+// channelTarget turns a channel ID or name into a channel to send to; names
+// without a channel prefix get '#'.
+func channelTarget(c, chanTypes string) (string, bool) {
+	if id, ok := util.ExtractID(c); ok {
+		c = id
+	}
+	c = strings.TrimSpace(c)
+	if c == "" || strings.ContainsAny(c, " ,\x07\x00\r\n") {
+		return "", false
+	}
+	if !strings.ContainsRune(chanTypes, rune(c[0])) {
+		c = "#" + c
+	}
+	return c, len(c) > 1
+}
+
+// channelName is the engine's name for a channel: the channel without a
+// leading '#'.
+func channelName(channel string) string {
+	return strings.TrimPrefix(channel, "#")
+}
+
+func (ic *ircConnector) resolveChannel(c string) (string, bool) {
+	ic.mu.RLock()
+	chanTypes := ic.chanTypes
+	ic.mu.RUnlock()
+	return channelTarget(c, chanTypes)
+}
+
+func (ic *ircConnector) SendProtocolChannelThreadMessage(channelname, threadid, msg string, format robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	channel, ok := ic.resolveChannel(channelname)
+	if !ok {
+		ic.Log(robot.Error, "IRC channel not found for: %s", channelname)
+		return robot.ChannelNotFound
+	}
+	if nick := ic.hiddenReplyNick(channel, "", msgObject); nick != "" {
+		return ic.send("NOTICE", nick, "", msg, format)
+	}
+	return ic.send("PRIVMSG", channel, "", msg, format)
+}
+
+func (ic *ircConnector) SendProtocolUserChannelThreadMessage(userid, username, channelname, threadid, msg string, format robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	channel, ok := ic.resolveChannel(channelname)
+	if !ok {
+		ic.Log(robot.Error, "IRC channel not found for: %s", channelname)
+		return robot.ChannelNotFound
+	}
+	nick, ok := ic.resolveNick(userid, username)
+	if !ok {
+		ic.Log(robot.Error, "IRC user not found for: %s", username)
+		return robot.UserNotFound
+	}
+	if hidden := ic.hiddenReplyNick(channel, nick, msgObject); hidden != "" {
+		return ic.send("NOTICE", hidden, "", msg, format)
+	}
+	return ic.send("PRIVMSG", channel, nick+": ", msg, format)
+}
+
+func (ic *ircConnector) SendProtocolUserMessage(user, msg string, format robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	nick, ok := ic.resolveNick(user, user)
+	if !ok {
+		ic.Log(robot.Error, "IRC user not found for DM: %s", user)
+		return robot.UserNotFound
+	}
+	return ic.send("PRIVMSG", nick, "", msg, format)
+}
+
+// hiddenReplyNick returns the nick a reply should be sent to privately: the
+// sender of a hidden command, as long as the reply is going back to them in
+// the same channel.
+func (ic *ircConnector) hiddenReplyNick(channel, nick string, msgObject *robot.ConnectorMessage) string {
+	if msgObject == nil || !msgObject.HiddenMessage {
+		return ""
+	}
+	ic.mu.RLock()
+	sameChannel := ic.caseMap.fold(channel) == msgObject.ChannelID
+	ic.mu.RUnlock()
+	if !sameChannel {
+		return ""
+	}
+	sender, ok := ic.resolveNick("<"+msgObject.UserID+">", "")
+	if !ok {
+		return ""
+	}
+	if nick != "" && ic.fold(nick) != ic.fold(sender) {
+		return ""
+	}
+	return sender
+}
+
+func (ic *ircConnector) fold(s string) string {
+	ic.mu.RLock()
+	defer ic.mu.RUnlock()
+	return ic.caseMap.fold(s)
+}
+
+// send renders a message and queues it as one or more lines to target;
+// prefix goes before the first line.
+func (ic *ircConnector) send(command, target, prefix, msg string, format robot.MessageFormat) robot.RetVal {
+	lines := ic.renderLines(msg, format)
+	if len(lines) == 0 {
+		ic.Log(robot.Error, "IRC: refusing to send empty message")
+		return robot.Failed
+	}
+	lines[0] = prefix + lines[0]
+	limit := ic.maxText(command, target)
+	for _, line := range lines {
+		for _, part := range splitLine(line, limit) {
+			if !ic.queueLine(formatLine(command, target, part)) {
+				return robot.FailedMessageSend
+			}
+		}
+	}
+	return robot.Ok
+}
+
+// maxText returns how many bytes of text fit in a message to target, after
+// the source the server adds when relaying it.
+func (ic *ircConnector) maxText(command, target string) int {
+	ic.mu.RLock()
+	source := ic.selfMask
+	if source == "" {
+		// the host can be up to 63 bytes, and the username gains a '~'
+		// without ident
+		source = ic.nick + "!~" + ic.user + "@" + strings.Repeat("x", 63)
+	}
+	ic.mu.RUnlock()
+	return 510 - len(":"+source+" ") - len(command+" "+target+" :")
+}
+
+// writeLine writes a line to the current connection.
+func (ic *ircConnector) writeLine(line string) error {
+	ic.mu.RLock()
+	conn := ic.conn
+	ready := ic.ready
+	ic.mu.RUnlock()
+	if conn == nil {
+		return errNotConnected
+	}
+	select {
+	case <-ready:
+	default:
+		return errNotConnected
+	}
+	return ic.write(conn, line)
+}
+
+func (ic *ircConnector) write(conn net.Conn, line string) error {
+	ic.writeMu.Lock()
+	defer ic.writeMu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := conn.Write([]byte(line + "\r\n"))
+	return err
+}
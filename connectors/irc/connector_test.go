@@ -0,0 +1,462 @@
+package irc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+	"github.com/lnxjedi/gopherbot/v2/connectors/irc/ircdtest"
+)
+
+const timeout = 5 * time.Second
+
+type testHandler struct {
+	cfg      config
+	incoming chan *robot.ConnectorMessage
+
+	mu    sync.Mutex
+	botID string
+}
+
+func (h *testHandler) IncomingMessage(msg *robot.ConnectorMessage) { h.incoming <- msg }
+func (h *testHandler) GetProtocolConfig(v interface{}) error {
+	*(v.(*config)) = h.cfg
+	return nil
+}
+func (h *testHandler) GetBrainConfig(interface{}) error                 { return nil }
+func (h *testHandler) GetEventStrings() *[]string                       { return nil }
+func (h *testHandler) GetHistoryConfig(interface{}) error               { return nil }
+func (h *testHandler) GetBotInfo() robot.BotInfo                        { return robot.BotInfo{UserName: "bishop"} }
+func (h *testHandler) SetTerminalWriter(io.Writer)                      {}
+func (h *testHandler) SetBotMention(string)                             {}
+func (h *testHandler) GetLogLevel() robot.LogLevel                      { return robot.Debug }
+func (h *testHandler) GetInstallPath() string                           { return "" }
+func (h *testHandler) GetConfigPath() string                            { return "" }
+func (h *testHandler) ReadEncryptedFile(string) ([]byte, error)         { return nil, nil }
+func (h *testHandler) Log(_ robot.LogLevel, m string, v ...interface{}) {}
+func (h *testHandler) GetDirectory(string) error                        { return nil }
+
+func (h *testHandler) SetBotID(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.botID = id
+}
+
+func (h *testHandler) getBotID() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.botID
+}
+
+// startConnector initializes a connector for srv and runs it until the
+// test ends.
+func startConnector(t *testing.T, srv *ircdtest.Server, c config) (*ircConnector, *testHandler) {
+	t.Helper()
+	c.Server = srv.Addr
+	if c.Nick == "" {
+		c.Nick = "bishop"
+	}
+	if c.UserMap == nil {
+		c.UserMap = map[string]string{"alice": "alice"}
+	}
+	h := &testHandler{cfg: c, incoming: make(chan *robot.ConnectorMessage, 20)}
+	ic := Initialize(h, nil).Connector.(*ircConnector)
+	ic.floodDelay = 10 * time.Millisecond
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		ic.Run(stop)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		<-done
+	})
+	return ic, h
+}
+
+func newServer(t *testing.T, opts ircdtest.Options) *ircdtest.Server {
+	srv := ircdtest.NewServer(opts)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func inChannel(srv *ircdtest.Server, channel, nick string) func() bool {
+	return func() bool {
+		_, ok := srv.Members(channel)[nick]
+		return ok
+	}
+}
+
+func nextMessage(t *testing.T, h *testHandler) *robot.ConnectorMessage {
+	t.Helper()
+	select {
+	case msg := <-h.incoming:
+		return msg
+	case <-time.After(timeout):
+		t.Fatal("timed out waiting for an incoming message")
+		return nil
+	}
+}
+
+// nextLine returns the next message the robot sent a simulated user,
+// skipping other users' messages.
+func nextLine(t *testing.T, u *ircdtest.User) ircdtest.Line {
+	t.Helper()
+	for {
+		l, ok := u.NextMessage(timeout)
+		if !ok {
+			t.Fatal("timed out waiting for a message from the robot")
+		}
+		if strings.HasPrefix(l.Source, "bishop") {
+			return l
+		}
+	}
+}
+
+func TestSASLPlainAndMessages(t *testing.T) {
+	srv := newServer(t, ircdtest.Options{Accounts: map[string]string{"bishop": "s3cret"}})
+	alice := srv.AddUser("alice", "Alice")
+	bob := srv.AddUser("bob", "")
+	alice.Join("#ops")
+	bob.Join("#ops")
+	ic, h := startConnector(t, srv, config{
+		SASL:            "plain",
+		AccountPassword: "s3cret",
+		Channels:        []string{"#ops"},
+	})
+	waitFor(t, "the robot to join #ops", inChannel(srv, "#ops", "bishop"))
+	if srv.Account("bishop") != "bishop" || h.getBotID() != "bishop" {
+		t.Errorf("account %q, bot ID %q; want bishop", srv.Account("bishop"), h.getBotID())
+	}
+	for _, l := range srv.Received("bishop") {
+		if l.Command == "PRIVMSG" && strings.EqualFold(l.Params[0], "NickServ") {
+			t.Errorf("identified with NickServ after SASL: %q", l.Params)
+		}
+	}
+
+	alice.Say("#ops", "Bishop: ping")
+	msg := nextMessage(t, h)
+	if msg.MessageText != "@bishop: ping" || msg.UserName != "alice" || !msg.ValidatedUser || msg.UserID != "alice" ||
+		msg.ChannelID != "#ops" || msg.ChannelName != "ops" || msg.DirectMessage || msg.MessageID == "" {
+		t.Errorf("channel message = %+v", msg)
+	}
+	bob.Say("#OPS", "\x02hello\x02")
+	msg = nextMessage(t, h)
+	if msg.MessageText != "hello" || msg.UserName != "" || msg.ValidatedUser || msg.UserID != "bob!bob@users.test" || msg.ChannelID != "#ops" {
+		t.Errorf("unvalidated channel message = %+v", msg)
+	}
+	alice.Say("bishop", "help")
+	msg = nextMessage(t, h)
+	if !msg.DirectMessage || msg.ChannelID != "" || msg.MessageText != "help" || msg.UserName != "alice" {
+		t.Errorf("direct message = %+v", msg)
+	}
+
+	if ret := ic.SendProtocolChannelThreadMessage("ops", "", "**done**, @alice", robot.BasicMarkdown, nil); ret != robot.Ok {
+		t.Fatalf("channel send returned %v", ret)
+	}
+	for _, u := range []*ircdtest.User{alice, bob} {
+		if l := nextLine(t, u); l.Command != "PRIVMSG" || l.Params[0] != "#ops" || l.Text() != "\x02done\x02, alice" {
+			t.Errorf("channel member got %+v", l)
+		}
+	}
+	msg = nextMessage(t, h)
+	if !msg.SelfMessage || msg.ChannelID != "#ops" || msg.ValidatedUser {
+		t.Errorf("echoed message = %+v", msg)
+	}
+	ic.SendProtocolUserChannelThreadMessage("<bob!bob@users.test>", "", "ops", "", "hi", robot.Raw, nil)
+	for _, u := range []*ircdtest.User{alice, bob} {
+		if l := nextLine(t, u); l.Params[0] != "#ops" || l.Text() != "bob: hi" {
+			t.Errorf("channel member got %+v", l)
+		}
+	}
+	nextMessage(t, h)
+	ic.SendProtocolUserMessage("alice", "psst", robot.Raw, nil)
+	if l := nextLine(t, alice); l.Params[0] != "alice" || l.Text() != "psst" {
+		t.Errorf("alice got %+v", l)
+	}
+	if msg = nextMessage(t, h); !msg.SelfMessage || !msg.DirectMessage {
+		t.Errorf("echoed direct message = %+v", msg)
+	}
+
+	for attr, want := range map[string]string{"nick": "alice", "account": "alice", "fullname": "alice", "hostmask": "alice!alice@users.test", "internalid": "alice", "name": "alice"} {
+		if got, ret := ic.GetProtocolUserAttribute("alice", attr); got != want || ret != robot.Ok {
+			t.Errorf("alice %s = %q (%v), want %q", attr, got, ret, want)
+		}
+	}
+	if got, _ := ic.GetProtocolUserAttribute("<bob!bob@users.test>", "name"); got != "bob" {
+		t.Errorf("bob's name = %q", got)
+	}
+	if got, _ := ic.GetProtocolUserAttribute("bishop", "account"); got != "bishop" {
+		t.Errorf("robot's account = %q", got)
+	}
+}
+
+func TestNickServFallback(t *testing.T) {
+	srv := newServer(t, ircdtest.Options{
+		Accounts: map[string]string{"bishop": "s3cret"},
+		Caps:     []string{"account-tag", "extended-join"},
+	})
+	_, h := startConnector(t, srv, config{
+		SASL:            "plain",
+		AccountPassword: "s3cret",
+	})
+	waitFor(t, "NickServ to log the robot in", func() bool { return h.getBotID() == "bishop" })
+	if srv.Account("bishop") != "bishop" {
+		t.Errorf("account = %q", srv.Account("bishop"))
+	}
+}
+
+func TestSASLExternal(t *testing.T) {
+	dir := t.TempDir()
+	certFile, fingerprint := writeClientCert(t, dir)
+	srv := newServer(t, ircdtest.Options{
+		TLS:          true,
+		CertAccounts: map[string]string{fingerprint: "bishop"},
+	})
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, srv.CertPEM(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, h := startConnector(t, srv, config{
+		TLS:         true,
+		TLSCAFile:   caFile,
+		TLSCertFile: certFile,
+		SASL:        "external",
+	})
+	waitFor(t, "SASL EXTERNAL to log the robot in", func() bool { return h.getBotID() == "bishop" })
+	if srv.Account("bishop") != "bishop" {
+		t.Errorf("account = %q", srv.Account("bishop"))
+	}
+}
+
+// writeClientCert writes a self-signed client certificate and its key to
+// one PEM file, returning the file and the certificate fingerprint.
+func writeClientCert(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "bishop"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "bishop.pem")
+	data := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})...)
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(der)
+	return file, hex.EncodeToString(sum[:])
+}
+
+func TestHiddenCommands(t *testing.T) {
+	srv := newServer(t, ircdtest.Options{})
+	alice := srv.AddUser("alice", "alice")
+	carol := srv.AddUser("carol", "")
+	alice.Join("#ops")
+	ic, h := startConnector(t, srv, config{Channels: []string{"#ops"}})
+	waitFor(t, "the robot to join #ops", inChannel(srv, "#ops", "bishop"))
+
+	alice.Say("bishop", "#ops deploy now")
+	msg := nextMessage(t, h)
+	if !msg.HiddenMessage || !msg.BotMessage || msg.DirectMessage || msg.ChannelID != "#ops" ||
+		msg.ChannelName != "ops" || msg.MessageText != "deploy now" || msg.UserName != "alice" {
+		t.Fatalf("hidden command = %+v", msg)
+	}
+	ic.SendProtocolUserChannelThreadMessage(msg.UserID, msg.UserName, msg.ChannelName, "", "deploying", robot.Raw, msg)
+	if l := nextLine(t, alice); l.Command != "NOTICE" || l.Params[0] != "alice" || l.Text() != "deploying" {
+		t.Errorf("hidden reply = %+v", l)
+	}
+	ic.SendProtocolChannelThreadMessage(msg.ChannelName, "", "done", robot.Raw, msg)
+	if l := nextLine(t, alice); l.Command != "NOTICE" || l.Text() != "done" {
+		t.Errorf("hidden channel reply = %+v", l)
+	}
+	// a reply to someone else isn't private
+	ic.SendProtocolChannelThreadMessage("#dev", "", "elsewhere", robot.Raw, msg)
+	ic.SendProtocolChannelThreadMessage("ops", "", "public", robot.Raw, nil)
+	if l := nextLine(t, alice); l.Command != "PRIVMSG" || l.Params[0] != "#ops" || l.Text() != "public" {
+		t.Errorf("public reply = %+v", l)
+	}
+
+	carol.Say("bishop", "#ops deploy now")
+	for {
+		msg = nextMessage(t, h)
+		if !msg.SelfMessage {
+			break
+		}
+	}
+	if !msg.DirectMessage || msg.HiddenMessage || msg.MessageText != "#ops deploy now" {
+		t.Errorf("hidden command from a non-member = %+v", msg)
+	}
+	if got := ic.FormatHiddenCommand("deploy"); got != "/msg bishop #channel deploy" {
+		t.Errorf("FormatHiddenCommand = %q", got)
+	}
+}
+
+func TestFloodControl(t *testing.T) {
+	srv := newServer(t, ircdtest.Options{})
+	srv.AddUser("alice", "")
+	ic, _ := startConnector(t, srv, config{})
+	ic.floodBurst, ic.floodDelay = 3, 100*time.Millisecond
+	waitFor(t, "the robot to register", func() bool { return srv.Account("bishop") == "" && srv.Received("bishop") != nil })
+
+	var lines []string
+	for i := 1; i <= 8; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	ic.SendProtocolUserMessage("<alice!alice@users.test>", strings.Join(lines, "\n"), robot.Raw, nil)
+	var sent []ircdtest.Line
+	waitFor(t, "all the lines", func() bool {
+		sent = sent[:0]
+		for _, l := range srv.Received("bishop") {
+			if l.Command == "PRIVMSG" {
+				sent = append(sent, l)
+			}
+		}
+		return len(sent) == len(lines)
+	})
+	for i, l := range sent {
+		if l.Text() != lines[i] {
+			t.Errorf("line %d = %q, want %q", i, l.Text(), lines[i])
+		}
+	}
+	if burst := sent[2].Time.Sub(sent[0].Time); burst > 80*time.Millisecond {
+		t.Errorf("burst of 3 lines took %v", burst)
+	}
+	if total := sent[7].Time.Sub(sent[0].Time); total < 450*time.Millisecond {
+		t.Errorf("8 lines sent in %v; flood control should space the last 5 by 100ms", total)
+	}
+}
+
+func TestLongLinesAreSplit(t *testing.T) {
+	srv := newServer(t, ircdtest.Options{})
+	alice := srv.AddUser("alice", "alice")
+	alice.Join("#ops")
+	ic, _ := startConnector(t, srv, config{Channels: []string{"#ops"}})
+	waitFor(t, "the robot to join #ops", inChannel(srv, "#ops", "bishop"))
+
+	var words []string
+	for i := 0; i < 300; i++ {
+		words = append(words, fmt.Sprintf("word%03d", i))
+	}
+	text := strings.Join(words, " ")
+	ic.SendProtocolChannelThreadMessage("ops", "", "**"+text+"**", robot.BasicMarkdown, nil)
+	var parts []string
+	for strings.Join(parts, " ") != "\x02"+text+"\x02" {
+		l := nextLine(t, alice)
+		if len(":bishop!bishop@localhost PRIVMSG #ops :"+l.Text()) > 510 {
+			t.Errorf("relayed line is %d bytes", len(":bishop!bishop@localhost PRIVMSG #ops :"+l.Text()))
+		}
+		parts = append(parts, l.Text())
+		if len(parts) > 10 {
+			t.Fatalf("parts don't add up to the message: %q", parts)
+		}
+	}
+	if len(parts) < 5 {
+		t.Errorf("%d bytes sent in %d lines", len(text), len(parts))
+	}
+}
+
+func TestChannelOperators(t *testing.T) {
+	srv := newServer(t, ircdtest.Options{})
+	alice := srv.AddUser("alice", "alice")
+	alice.Join("#ops")
+	ic, h := startConnector(t, srv, config{Channels: []string{"#ops"}})
+	waitFor(t, "the robot to join #ops", inChannel(srv, "#ops", "bishop"))
+	h.cfg.Channels = []string{"#ops", "#dev"}
+	if err := ic.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the robot to join #dev", inChannel(srv, "#dev", "bishop"))
+
+	opChannels := func(user string) string {
+		got, _ := ic.GetProtocolUserAttribute(user, "opchannels")
+		return got
+	}
+	if got := opChannels("alice"); got != "ops" {
+		t.Errorf("alice's op channels = %q", got)
+	}
+	if got := opChannels("bishop"); got != "dev" {
+		t.Errorf("robot's op channels = %q", got)
+	}
+	srv.Mode("#ops", "+o-o", "bishop", "alice")
+	waitFor(t, "op changes", func() bool { return opChannels("bishop") == "dev ops" && opChannels("alice") == "" })
+	srv.Mode("#ops", "+v", "alice")
+	time.Sleep(50 * time.Millisecond)
+	if got := opChannels("alice"); got != "" {
+		t.Errorf("voice made alice an op in %q", got)
+	}
+}
+
+func TestNickCollisionAndReconnect(t *testing.T) {
+	srv := newServer(t, ircdtest.Options{})
+	holder := srv.AddUser("Bishop", "")
+	holder.Join("#ops")
+	alice := srv.AddUser("alice", "alice")
+	ic, h := startConnector(t, srv, config{Channels: []string{"#ops"}})
+	waitFor(t, "the robot to join as bishop_", inChannel(srv, "#ops", "bishop_"))
+	if got := ic.FormatHiddenCommand("ping"); got != "/msg bishop_ #channel ping" {
+		t.Errorf("FormatHiddenCommand = %q", got)
+	}
+	if h.getBotID() != "bishop_" {
+		t.Errorf("bot ID = %q", h.getBotID())
+	}
+
+	holder.Quit()
+	waitFor(t, "the robot to reclaim its nick", inChannel(srv, "#ops", "bishop"))
+	if got, _ := ic.GetProtocolUserAttribute("bishop", "nick"); got != "bishop" {
+		t.Errorf("robot's nick = %q", got)
+	}
+	waitFor(t, "the bot ID to follow the nick", func() bool { return h.getBotID() == "bishop" })
+
+	if !srv.Disconnect("bishop") {
+		t.Fatal("robot not connected")
+	}
+	waitFor(t, "the robot to drop", func() bool { return !inChannel(srv, "#ops", "bishop")() })
+	// queued while disconnected, sent after reconnecting
+	ic.SendProtocolUserMessage("alice", "back", robot.Raw, nil)
+	waitFor(t, "the robot to rejoin #ops", inChannel(srv, "#ops", "bishop"))
+	if l := nextLine(t, alice); l.Text() != "back" {
+		t.Errorf("alice got %+v", l)
+	}
+}
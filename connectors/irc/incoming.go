@@ -0,0 +1,119 @@
+package irc
+
+import (
+	"strings"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+// handlePrivmsg delivers a channel or private message. A private message
+// that starts with one of the robot's channels, e.g. "#ops deploy", is a
+// hidden command in that channel when the sender is a member of it.
+func (ic *ircConnector) handlePrivmsg(m *message, accountTags bool) {
+	if len(m.Params) < 2 {
+		return
+	}
+	target, text := m.Params[0], m.Params[1]
+	if strings.HasPrefix(text, "\x01") {
+		// CTCP, including ACTION
+		return
+	}
+	nick := m.nick()
+	self := ic.isSelf(nick)
+	user := ic.noteUser(m.Source, m.Tags["account"], accountTags)
+	if user == nil {
+		// from the server itself
+		return
+	}
+	text = strings.TrimSpace(stripFormatting(text))
+
+	msg := &robot.ConnectorMessage{
+		Protocol:      "irc",
+		UserID:        userID(user.nick, user.user, user.host, user.account),
+		MessageID:     m.Tags["msgid"],
+		SelfMessage:   self,
+		MessageObject: m,
+	}
+	switch {
+	case ic.isChannel(target):
+		msg.ChannelID = ic.fold(target)
+		msg.ChannelName = channelName(target)
+		msg.MessageText = ic.normalizeAddress(text)
+	case self:
+		// our own private message, echoed back
+		msg.DirectMessage = true
+		msg.MessageText = text
+	default:
+		channel, command, ok := ic.hiddenCommand(nick, text)
+		if !ok {
+			msg.DirectMessage = true
+			msg.MessageText = text
+			break
+		}
+		msg.ChannelID = ic.fold(channel)
+		msg.ChannelName = channelName(channel)
+		msg.MessageText = command
+		msg.BotMessage = true
+		msg.HiddenMessage = true
+	}
+	if msg.MessageText == "" {
+		return
+	}
+	if !self {
+		msg.UserName = ic.canonicalName(user.account)
+		msg.ValidatedUser = msg.UserName != ""
+	}
+	ic.IncomingMessage(msg)
+}
+
+// hiddenCommand splits "#channel command" sent privately to the robot,
+// when the robot and the sender are both in the channel.
+func (ic *ircConnector) hiddenCommand(nick, text string) (channel, command string, ok bool) {
+	channel, command, _ = strings.Cut(text, " ")
+	command = strings.TrimSpace(command)
+	if command == "" || !ic.isChannel(channel) {
+		return "", "", false
+	}
+	ic.mu.RLock()
+	ch, member := ic.channels[ic.caseMap.fold(channel)]
+	if member {
+		_, member = ch.members[ic.caseMap.fold(nick)]
+	}
+	ic.mu.RUnlock()
+	if !member {
+		ic.Log(robot.Debug, "IRC: %s and the robot aren't both in %s; treating the message as a direct message", nick, channel)
+		return "", "", false
+	}
+	return ch.name, command, true
+}
+
+// normalizeAddress rewrites a message addressed to the robot's nick, e.g.
+// "bishop_: ping", to use the robot's name.
+func (ic *ircConnector) normalizeAddress(text string) string {
+	ic.mu.RLock()
+	nick := ic.nick
+	caseMap := ic.caseMap
+	ic.mu.RUnlock()
+	addressed := strings.TrimPrefix(text, "@")
+	if len(addressed) <= len(nick) || caseMap.fold(addressed[:len(nick)]) != caseMap.fold(nick) {
+		return text
+	}
+	switch addressed[len(nick)] {
+	case ':', ',':
+		return "@" + ic.botName + addressed[len(nick):]
+	}
+	return text
+}
+
+// FormatHiddenCommand shows how to send a hidden command: a private message
+// to the robot naming the channel.
+func (ic *ircConnector) FormatHiddenCommand(input string) string {
+	ic.mu.RLock()
+	nick := ic.nick
+	ic.mu.RUnlock()
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return "/msg " + nick + " #channel"
+	}
+	return "/msg " + nick + " #channel " + input
+}
@@ -0,0 +1,881 @@
+// Package ircdtest provides an in-process IRC server stand-in for tests. It
+// speaks enough of the client protocol for a robot to register (with CAP
+// negotiation, SASL PLAIN/EXTERNAL and a NickServ pseudo-user), join
+// channels and exchange messages with simulated users, and it records when
+// each line arrives so tests can check flood control. It is not a real
+// ircd: channels only have op and voice modes, and nothing is rate limited.
+package ircdtest
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	serverName   = "irc.test"
+	nickServ     = "NickServ"
+	nickServMask = "NickServ!NickServ@services.test"
+)
+
+// AllCaps are the capabilities the stand-in supports.
+var AllCaps = []string{"account-notify", "account-tag", "echo-message", "extended-join", "message-tags", "multi-prefix", "sasl"}
+
+// Options configures a stand-in.
+type Options struct {
+	// Accounts maps services accounts to passwords, for SASL PLAIN and
+	// NickServ IDENTIFY.
+	Accounts map[string]string
+	// CertAccounts maps the hex SHA-256 fingerprint of a client
+	// certificate to an account, for SASL EXTERNAL.
+	CertAccounts map[string]string
+	// Caps lists the capabilities offered; nil offers AllCaps.
+	Caps []string
+	// TLS serves TLS with a self-signed certificate; see CertPEM.
+	TLS bool
+}
+
+// Line is a message the server received from or delivered to a client.
+type Line struct {
+	Time    time.Time
+	Tags    map[string]string
+	Source  string
+	Command string
+	Params  []string
+}
+
+// Text returns the last parameter, the text of a PRIVMSG or NOTICE.
+func (l Line) Text() string {
+	if len(l.Params) == 0 {
+		return ""
+	}
+	return l.Params[len(l.Params)-1]
+}
+
+// Server is a running stand-in. Addr is the host:port to connect to.
+type Server struct {
+	Addr string
+
+	opts    Options
+	ln      net.Listener
+	certPEM []byte
+
+	mu       sync.Mutex
+	conns    map[*client]bool
+	clients  map[string]*client // folded nick -> registered client
+	channels map[string]*channel
+	msgid    int
+}
+
+type channel struct {
+	name    string
+	members map[*client]string // membership prefixes, "@", "+" or "@+"
+}
+
+type client struct {
+	conn  net.Conn
+	wmu   sync.Mutex
+	inbox chan Line // simulated users only
+
+	nick, user, host, realName, account string
+	fingerprint                         string
+	caps                                map[string]bool
+	capping                             bool
+	registered                          bool
+	saslMech                            string
+	received                            []Line
+}
+
+func (c *client) mask() string {
+	return c.nick + "!" + c.user + "@" + c.host
+}
+
+// NewServer starts a stand-in listening on localhost; it panics if it
+// can't.
+func NewServer(opts Options) *Server {
+	if opts.Caps == nil {
+		opts.Caps = AllCaps
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("ircdtest: listening: " + err.Error())
+	}
+	s := &Server{
+		opts:     opts,
+		conns:    make(map[*client]bool),
+		clients:  make(map[string]*client),
+		channels: make(map[string]*channel),
+	}
+	if opts.TLS {
+		cert, certPEM, err := selfSignedCert()
+		if err != nil {
+			panic("ircdtest: creating certificate: " + err.Error())
+		}
+		s.certPEM = certPEM
+		ln = tls.NewListener(ln, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   tls.RequestClientCert,
+		})
+	}
+	s.ln = ln
+	s.Addr = ln.Addr().String()
+	go s.accept()
+	return s
+}
+
+// CertPEM returns the server's self-signed certificate, for clients to
+// trust.
+func (s *Server) CertPEM() []byte {
+	return s.certPEM
+}
+
+// Close stops the server and drops every connection.
+func (s *Server) Close() {
+	s.ln.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.conn.Close()
+	}
+}
+
+func (s *Server) accept() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		c := &client{conn: conn, host: "localhost", caps: make(map[string]bool)}
+		s.mu.Lock()
+		s.conns[c] = true
+		s.mu.Unlock()
+		go s.serve(c)
+	}
+}
+
+func (s *Server) serve(c *client) {
+	defer func() {
+		s.mu.Lock()
+		s.quit(c, "Connection closed")
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.conn.Close()
+	}()
+	if tc, ok := c.conn.(*tls.Conn); ok {
+		if err := tc.Handshake(); err != nil {
+			return
+		}
+		if certs := tc.ConnectionState().PeerCertificates; len(certs) > 0 {
+			sum := sha256.Sum256(certs[0].Raw)
+			c.fingerprint = hex.EncodeToString(sum[:])
+		}
+	}
+	scanner := bufio.NewScanner(c.conn)
+	scanner.Buffer(make([]byte, 0, 4096), 16384)
+	for scanner.Scan() {
+		l, ok := parseLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		s.mu.Lock()
+		c.received = append(c.received, l)
+		s.handle(c, l)
+		s.mu.Unlock()
+	}
+}
+
+func parseLine(raw string) (Line, bool) {
+	l := Line{Time: time.Now()}
+	raw = strings.TrimRight(raw, "\r")
+	if strings.HasPrefix(raw, "@") {
+		tags, rest, _ := strings.Cut(raw[1:], " ")
+		l.Tags = make(map[string]string)
+		for _, tag := range strings.Split(tags, ";") {
+			k, v, _ := strings.Cut(tag, "=")
+			l.Tags[k] = v
+		}
+		raw = rest
+	}
+	if strings.HasPrefix(raw, ":") {
+		_, raw, _ = strings.Cut(raw, " ")
+	}
+	for raw != "" {
+		if strings.HasPrefix(raw, ":") {
+			l.Params = append(l.Params, raw[1:])
+			break
+		}
+		word, rest, _ := strings.Cut(raw, " ")
+		if l.Command == "" {
+			l.Command = strings.ToUpper(word)
+		} else if word != "" {
+			l.Params = append(l.Params, word)
+		}
+		raw = rest
+	}
+	return l, l.Command != ""
+}
+
+func formatLine(l Line) string {
+	var out strings.Builder
+	if len(l.Tags) > 0 {
+		var tags []string
+		for k, v := range l.Tags {
+			tags = append(tags, k+"="+v)
+		}
+		out.WriteString("@" + strings.Join(tags, ";") + " ")
+	}
+	out.WriteString(":" + l.Source + " " + l.Command)
+	for i, p := range l.Params {
+		if i == len(l.Params)-1 && (p == "" || strings.Contains(p, " ") || strings.HasPrefix(p, ":")) {
+			p = ":" + p
+		}
+		out.WriteString(" " + p)
+	}
+	return out.String()
+}
+
+// fold is the rfc1459 casemapping the server advertises.
+func fold(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		case r == '[', r == ']', r == '\\':
+			return r + '{' - '['
+		case r == '~':
+			return '^'
+		}
+		return r
+	}, s)
+}
+
+// deliver sends a line to c, with the tags c negotiated. It's called with
+// s.mu held.
+func (s *Server) deliver(c *client, source, command string, tags map[string]string, params ...string) {
+	l := Line{Time: time.Now(), Source: source, Command: command, Params: params, Tags: make(map[string]string)}
+	if account, ok := tags["account"]; ok && c.caps["account-tag"] {
+		l.Tags["account"] = account
+	}
+	if msgid, ok := tags["msgid"]; ok && c.caps["message-tags"] {
+		l.Tags["msgid"] = msgid
+	}
+	if c.conn == nil {
+		select {
+		case c.inbox <- l:
+		default:
+		}
+		return
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	c.conn.Write([]byte(formatLine(l) + "\r\n"))
+}
+
+func (s *Server) numeric(c *client, numeric string, params ...string) {
+	target := c.nick
+	if !c.registered || target == "" {
+		target = "*"
+	}
+	s.deliver(c, serverName, numeric, nil, append([]string{target}, params...)...)
+}
+
+func (s *Server) handle(c *client, l Line) {
+	param := func(i int) string {
+		if i < len(l.Params) {
+			return l.Params[i]
+		}
+		return ""
+	}
+	switch l.Command {
+	case "CAP":
+		s.handleCap(c, l)
+		return
+	case "AUTHENTICATE":
+		s.handleAuthenticate(c, param(0))
+		return
+	case "PASS", "PONG":
+		return
+	case "PING":
+		s.deliver(c, serverName, "PONG", nil, serverName, param(0))
+		return
+	case "NICK":
+		s.handleNick(c, param(0))
+		return
+	case "USER":
+		c.user, c.realName = param(0), param(3)
+		s.tryRegister(c)
+		return
+	case "QUIT":
+		if c.conn != nil {
+			c.conn.Close()
+		} else {
+			s.quit(c, param(0))
+		}
+		return
+	}
+	if !c.registered {
+		s.numeric(c, "451", "You have not registered")
+		return
+	}
+	switch l.Command {
+	case "JOIN":
+		for _, name := range strings.Split(param(0), ",") {
+			s.join(c, name)
+		}
+	case "PART":
+		s.part(c, param(0), param(1))
+	case "PRIVMSG", "NOTICE":
+		s.message(c, l.Command, param(0), param(1))
+	case "TAGMSG":
+	case "WHO":
+		s.who(c, param(0), param(1))
+	case "MODE":
+		ch, ok := s.channels[fold(param(0))]
+		switch {
+		case !ok:
+			s.numeric(c, "403", param(0), "No such channel")
+		case len(l.Params) == 1:
+			s.numeric(c, "324", ch.name, "+nt")
+		case !strings.Contains(ch.members[c], "@"):
+			s.numeric(c, "482", ch.name, "You're not channel operator")
+		default:
+			s.mode(c.mask(), ch, param(1), l.Params[2:])
+		}
+	default:
+		s.numeric(c, "421", l.Command, "Unknown command")
+	}
+}
+
+func (s *Server) offered(cap string) bool {
+	for _, c := range s.opts.Caps {
+		if c == cap {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) handleCap(c *client, l Line) {
+	sub := ""
+	if len(l.Params) > 0 {
+		sub = strings.ToUpper(l.Params[0])
+	}
+	switch sub {
+	case "LS":
+		c.capping = !c.registered
+		var caps []string
+		for _, cap := range s.opts.Caps {
+			if cap == "sasl" && len(l.Params) > 1 {
+				cap = "sasl=PLAIN,EXTERNAL"
+			}
+			caps = append(caps, cap)
+		}
+		s.numericCap(c, "LS", strings.Join(caps, " "))
+	case "REQ":
+		req := strings.Fields(l.Params[len(l.Params)-1])
+		for _, cap := range req {
+			if !s.offered(strings.TrimPrefix(cap, "-")) {
+				s.numericCap(c, "NAK", strings.Join(req, " "))
+				return
+			}
+		}
+		for _, cap := range req {
+			c.caps[strings.TrimPrefix(cap, "-")] = !strings.HasPrefix(cap, "-")
+		}
+		s.numericCap(c, "ACK", strings.Join(req, " "))
+	case "END":
+		c.capping = false
+		s.tryRegister(c)
+	}
+}
+
+func (s *Server) numericCap(c *client, sub, caps string) {
+	target := c.nick
+	if !c.registered || target == "" {
+		target = "*"
+	}
+	s.deliver(c, serverName, "CAP", nil, target, sub, caps)
+}
+
+func (s *Server) handleAuthenticate(c *client, data string) {
+	if !c.caps["sasl"] {
+		s.numeric(c, "904", "SASL authentication failed")
+		return
+	}
+	if c.saslMech == "" {
+		mech := strings.ToUpper(data)
+		if mech != "PLAIN" && mech != "EXTERNAL" {
+			s.numeric(c, "908", "PLAIN,EXTERNAL", "are available SASL mechanisms")
+			s.numeric(c, "904", "SASL authentication failed")
+			return
+		}
+		c.saslMech = mech
+		s.deliver(c, serverName, "AUTHENTICATE", nil, "+")
+		return
+	}
+	mech := c.saslMech
+	c.saslMech = ""
+	account := ""
+	switch mech {
+	case "PLAIN":
+		if data == "+" {
+			data = ""
+		}
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		parts := strings.Split(string(decoded), "\x00")
+		if err == nil && len(parts) == 3 {
+			if pw, ok := s.opts.Accounts[parts[1]]; ok && pw == parts[2] {
+				account = parts[1]
+			}
+		}
+	case "EXTERNAL":
+		account = s.opts.CertAccounts[c.fingerprint]
+	}
+	if account == "" {
+		s.numeric(c, "904", "SASL authentication failed")
+		return
+	}
+	s.login(c, account)
+	s.numeric(c, "903", "SASL authentication successful")
+}
+
+// login logs c in to account, telling c and, with account-notify, the
+// users who share a channel with it.
+func (s *Server) login(c *client, account string) {
+	c.account = account
+	mask := c.mask()
+	if c.nick == "" {
+		mask = "*"
+	}
+	s.numeric(c, "900", mask, account, "You are now logged in as "+account)
+	for peer := range s.peers(c) {
+		if peer.caps["account-notify"] {
+			s.deliver(peer, c.mask(), "ACCOUNT", nil, account)
+		}
+	}
+}
+
+// peers returns the other clients sharing a channel with c.
+func (s *Server) peers(c *client) map[*client]bool {
+	peers := make(map[*client]bool)
+	for _, ch := range s.channels {
+		if _, ok := ch.members[c]; !ok {
+			continue
+		}
+		for member := range ch.members {
+			if member != c {
+				peers[member] = true
+			}
+		}
+	}
+	return peers
+}
+
+func (s *Server) handleNick(c *client, nick string) {
+	if nick == "" || strings.ContainsAny(nick, " ,*?!@#") || (nick[0] >= '0' && nick[0] <= '9') {
+		s.numeric(c, "432", nick, "Erroneous nickname")
+		return
+	}
+	if other, ok := s.clients[fold(nick)]; ok && other != c {
+		s.numeric(c, "433", nick, "Nickname is already in use")
+		return
+	}
+	if !c.registered {
+		c.nick = nick
+		s.tryRegister(c)
+		return
+	}
+	old := c.mask()
+	delete(s.clients, fold(c.nick))
+	c.nick = nick
+	s.clients[fold(nick)] = c
+	s.deliver(c, old, "NICK", nil, nick)
+	for peer := range s.peers(c) {
+		s.deliver(peer, old, "NICK", nil, nick)
+	}
+}
+
+func (s *Server) tryRegister(c *client) {
+	if c.registered || c.capping || c.nick == "" || c.user == "" {
+		return
+	}
+	if _, ok := s.clients[fold(c.nick)]; ok {
+		s.numeric(c, "433", c.nick, "Nickname is already in use")
+		return
+	}
+	c.registered = true
+	s.clients[fold(c.nick)] = c
+	s.numeric(c, "001", "Welcome to the test network "+c.mask())
+	s.numeric(c, "005", "CASEMAPPING=rfc1459", "CHANTYPES=#", "PREFIX=(ov)@+", "CHANMODES=b,k,l,imnst", "WHOX", "NICKLEN=30", "are supported by this server")
+	s.numeric(c, "422", "MOTD File is missing")
+}
+
+func (s *Server) join(c *client, name string) {
+	if !strings.HasPrefix(name, "#") || len(name) < 2 {
+		s.numeric(c, "403", name, "No such channel")
+		return
+	}
+	ch, ok := s.channels[fold(name)]
+	if !ok {
+		ch = &channel{name: name, members: make(map[*client]string)}
+		s.channels[fold(name)] = ch
+	}
+	if _, ok := ch.members[c]; ok {
+		return
+	}
+	ch.members[c] = ""
+	if len(ch.members) == 1 {
+		ch.members[c] = "@"
+	}
+	account := c.account
+	if account == "" {
+		account = "*"
+	}
+	for member := range ch.members {
+		if member.caps["extended-join"] {
+			s.deliver(member, c.mask(), "JOIN", nil, ch.name, account, c.realName)
+		} else {
+			s.deliver(member, c.mask(), "JOIN", nil, ch.name)
+		}
+	}
+	var names []string
+	for member, prefixes := range ch.members {
+		if !c.caps["multi-prefix"] && len(prefixes) > 1 {
+			prefixes = prefixes[:1]
+		}
+		names = append(names, prefixes+member.nick)
+	}
+	s.numeric(c, "353", "=", ch.name, strings.Join(names, " "))
+	s.numeric(c, "366", ch.name, "End of /NAMES list")
+}
+
+func (s *Server) part(c *client, name, reason string) {
+	ch, ok := s.channels[fold(name)]
+	if !ok {
+		s.numeric(c, "403", name, "No such channel")
+		return
+	}
+	if _, ok := ch.members[c]; !ok {
+		s.numeric(c, "442", ch.name, "You're not on that channel")
+		return
+	}
+	for member := range ch.members {
+		s.deliver(member, c.mask(), "PART", nil, ch.name, reason)
+	}
+	delete(ch.members, c)
+	if len(ch.members) == 0 {
+		delete(s.channels, fold(name))
+	}
+}
+
+// quit removes a client, telling the users who shared a channel with it.
+func (s *Server) quit(c *client, reason string) {
+	if !c.registered {
+		return
+	}
+	for peer := range s.peers(c) {
+		s.deliver(peer, c.mask(), "QUIT", nil, reason)
+	}
+	for key, ch := range s.channels {
+		delete(ch.members, c)
+		if len(ch.members) == 0 {
+			delete(s.channels, key)
+		}
+	}
+	if s.clients[fold(c.nick)] == c {
+		delete(s.clients, fold(c.nick))
+	}
+	c.registered = false
+}
+
+func (s *Server) message(c *client, command, target, text string) {
+	if text == "" {
+		s.numeric(c, "412", "No text to send")
+		return
+	}
+	s.msgid++
+	tags := map[string]string{"msgid": "m" + strconv.Itoa(s.msgid)}
+	if c.account != "" {
+		tags["account"] = c.account
+	}
+	var recipients []*client
+	switch {
+	case strings.HasPrefix(target, "#"):
+		ch, ok := s.channels[fold(target)]
+		if !ok {
+			s.numeric(c, "403", target, "No such channel")
+			return
+		}
+		if _, ok := ch.members[c]; !ok {
+			s.numeric(c, "404", ch.name, "Cannot send to channel")
+			return
+		}
+		for member := range ch.members {
+			if member != c {
+				recipients = append(recipients, member)
+			}
+		}
+	case fold(target) == fold(nickServ):
+		if command == "PRIVMSG" {
+			s.nickServ(c, text)
+		}
+		return
+	default:
+		dst, ok := s.clients[fold(target)]
+		if !ok {
+			s.numeric(c, "401", target, "No such nick/channel")
+			return
+		}
+		recipients = append(recipients, dst)
+	}
+	if c.caps["echo-message"] {
+		recipients = append(recipients, c)
+	}
+	for _, r := range recipients {
+		s.deliver(r, c.mask(), command, tags, target, text)
+	}
+}
+
+func (s *Server) nickServ(c *client, text string) {
+	fields := strings.Fields(text)
+	if len(fields) < 2 || !strings.EqualFold(fields[0], "IDENTIFY") {
+		s.deliver(c, nickServMask, "NOTICE", nil, c.nick, "Unknown command")
+		return
+	}
+	account, password := c.nick, fields[1]
+	if len(fields) > 2 {
+		account, password = fields[1], fields[2]
+	}
+	if pw, ok := s.opts.Accounts[account]; !ok || pw != password {
+		s.deliver(c, nickServMask, "NOTICE", nil, c.nick, "Invalid password for "+account+".")
+		return
+	}
+	s.login(c, account)
+	s.deliver(c, nickServMask, "NOTICE", nil, c.nick, "You are now identified for "+account+".")
+}
+
+// who answers WHOX queries for a channel's members' nicks, accounts and
+// real names.
+func (s *Server) who(c *client, name, fields string) {
+	if ch, ok := s.channels[fold(name)]; ok && strings.HasPrefix(fields, "%") {
+		_, token, _ := strings.Cut(fields, ",")
+		for member := range ch.members {
+			account := member.account
+			if account == "" {
+				account = "0"
+			}
+			reply := []string{token, member.nick, account}
+			if strings.Contains(fields, "r") {
+				reply = append(reply, member.realName)
+			}
+			s.numeric(c, "354", reply...)
+		}
+	}
+	s.numeric(c, "315", name, "End of /WHO list")
+}
+
+func (s *Server) mode(source string, ch *channel, modes string, args []string) {
+	adding := true
+	applied := args[:0:0]
+	for _, m := range modes {
+		switch m {
+		case '+':
+			adding = true
+		case '-':
+			adding = false
+		case 'o', 'v':
+			if len(args) == 0 {
+				continue
+			}
+			nick := args[0]
+			args = args[1:]
+			member, ok := s.clients[fold(nick)]
+			if !ok {
+				continue
+			}
+			prefixes, ok := ch.members[member]
+			if !ok {
+				continue
+			}
+			prefix := "@"
+			if m == 'v' {
+				prefix = "+"
+			}
+			prefixes = strings.ReplaceAll(prefixes, prefix, "")
+			if adding {
+				prefixes += prefix
+			}
+			if strings.HasPrefix(prefixes, "+") && len(prefixes) == 2 {
+				prefixes = "@+"
+			}
+			ch.members[member] = prefixes
+			applied = append(applied, nick)
+		}
+	}
+	params := append([]string{ch.name, modes}, applied...)
+	for member := range ch.members {
+		s.deliver(member, source, "MODE", nil, params...)
+	}
+}
+
+// Mode changes channel op (o) and voice (v) modes as the server, e.g.
+// Mode("#ops", "+o", "alice").
+func (s *Server) Mode(channel, modes string, args ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ch, ok := s.channels[fold(channel)]; ok {
+		s.mode(serverName, ch, modes, args)
+	}
+}
+
+// Members returns a channel's members by nick, with their membership
+// prefixes.
+func (s *Server) Members(channel string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	members := make(map[string]string)
+	if ch, ok := s.channels[fold(channel)]; ok {
+		for c, prefixes := range ch.members {
+			members[c.nick] = prefixes
+		}
+	}
+	return members
+}
+
+// Account returns the account a user is logged in to.
+func (s *Server) Account(nick string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.clients[fold(nick)]; ok {
+		return c.account
+	}
+	return ""
+}
+
+// Received returns the lines the client using nick has sent.
+func (s *Server) Received(nick string) []Line {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.clients[fold(nick)]; ok {
+		return append([]Line(nil), c.received...)
+	}
+	return nil
+}
+
+// Disconnect drops a client's connection, as a network failure would.
+func (s *Server) Disconnect(nick string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.clients[fold(nick)]
+	if !ok || c.conn == nil {
+		return false
+	}
+	c.conn.Close()
+	return true
+}
+
+// User is a simulated user, connected to the server without a network
+// connection.
+type User struct {
+	s *Server
+	c *client
+}
+
+// AddUser registers a simulated user, logged in to account unless it's
+// empty.
+func (s *Server) AddUser(nick, account string) *User {
+	c := &client{
+		inbox:      make(chan Line, 1000),
+		nick:       nick,
+		user:       strings.ToLower(nick),
+		host:       "users.test",
+		realName:   nick,
+		account:    account,
+		caps:       make(map[string]bool),
+		registered: true,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[fold(nick)] = c
+	return &User{s: s, c: c}
+}
+
+func (u *User) send(command string, params ...string) {
+	u.s.mu.Lock()
+	defer u.s.mu.Unlock()
+	u.s.handle(u.c, Line{Time: time.Now(), Command: command, Params: params})
+}
+
+// Join joins a channel.
+func (u *User) Join(channel string) { u.send("JOIN", channel) }
+
+// Part leaves a channel.
+func (u *User) Part(channel string) { u.send("PART", channel, "leaving") }
+
+// Say sends a PRIVMSG to a channel or nick.
+func (u *User) Say(target, text string) { u.send("PRIVMSG", target, text) }
+
+// Notice sends a NOTICE to a channel or nick.
+func (u *User) Notice(target, text string) { u.send("NOTICE", target, text) }
+
+// Nick changes the user's nick.
+func (u *User) Nick(nick string) { u.send("NICK", nick) }
+
+// Quit disconnects the user.
+func (u *User) Quit() { u.send("QUIT", "bye") }
+
+// NextMessage returns the next PRIVMSG or NOTICE delivered to the user.
+func (u *User) NextMessage(timeout time.Duration) (Line, bool) {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case l := <-u.c.inbox:
+			if l.Command == "PRIVMSG" || l.Command == "NOTICE" {
+				return l, true
+			}
+		case <-deadline:
+			return Line{}, false
+		}
+	}
+}
+
+func selfSignedCert() (tls.Certificate, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: serverName},
+		DNSNames:     []string{"localhost", serverName},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, certPEM, nil
+}
@@ -0,0 +1,211 @@
+package irc
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lnxjedi/gopherbot/robot"
+	"github.com/lnxjedi/gopherbot/robot/util"
+)
+
+// mIRC formatting codes used for BasicMarkdown.
+const (
+	ircBold   = "\x02"
+	ircItalic = "\x1D"
+)
+
+// renderLines converts an outgoing message to the lines to send; IRC
+// messages can't contain line breaks, and empty lines can't be sent.
+func (ic *ircConnector) renderLines(msg string, format robot.MessageFormat) []string {
+	switch format {
+	case robot.BasicMarkdown:
+		msg = ic.renderBasicMarkdown(msg)
+	case robot.Variable:
+		msg = stripFormatting(msg)
+	}
+	msg = strings.NewReplacer("\r\n", "\n", "\r", "\n", "\x00", "").Replace(msg)
+	var lines []string
+	for _, line := range strings.Split(msg, "\n") {
+		line = strings.TrimRight(line, " \t")
+		if stripFormatting(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// renderBasicMarkdown renders bold and italics as IRC formatting, links as
+// "label (url)" and emoji shortcodes as Unicode. Code is sent as is without
+// its backticks or fences, and mentions of mapped users become their nicks.
+func (ic *ircConnector) renderBasicMarkdown(msg string) string {
+	var out strings.Builder
+	for i, part := range strings.Split(msg, "```") {
+		if i%2 == 1 {
+			// skip the fence's language hint
+			if nl := strings.IndexByte(part, '\n'); nl >= 0 && !strings.ContainsAny(part[:nl], " \t") {
+				part = part[nl+1:]
+			}
+			out.WriteString(part)
+			continue
+		}
+		for j, span := range splitInlineCode(part) {
+			if j%2 == 1 {
+				out.WriteString(span)
+				continue
+			}
+			out.WriteString(ic.renderMarkdownText(span))
+		}
+	}
+	return out.String()
+}
+
+// splitInlineCode splits text at unescaped backticks; odd elements are
+// code. An unclosed backtick is kept as text.
+func splitInlineCode(text string) []string {
+	var parts []string
+	start := 0
+	open := -1
+	for i := 0; i < len(text); i++ {
+		if text[i] != '`' || isEscapedAt(text, i) {
+			continue
+		}
+		if open < 0 {
+			open = i
+			continue
+		}
+		parts = append(parts, text[start:open], text[open+1:i])
+		start, open = i+1, -1
+	}
+	return append(parts, text[start:])
+}
+
+func isEscapedAt(text string, i int) bool {
+	slashes := 0
+	for j := i - 1; j >= 0 && text[j] == '\\'; j-- {
+		slashes++
+	}
+	return slashes%2 == 1
+}
+
+func (ic *ircConnector) renderMarkdownText(text string) string {
+	var out strings.Builder
+	bold, italic := false, false
+	for i := 0; i < len(text); i++ {
+		ch := text[i]
+		switch {
+		case ch == '\\' && i+1 < len(text) && strings.IndexByte("*`[]()@\\", text[i+1]) >= 0:
+			i++
+			out.WriteByte(text[i])
+		case ch == '*' && i+1 < len(text) && text[i+1] == '*' && (bold || strings.Contains(text[i+2:], "**")):
+			out.WriteString(ircBold)
+			bold = !bold
+			i++
+		case ch == '*' && (italic || strings.Contains(strings.ReplaceAll(text[i+1:], "**", ""), "*")):
+			out.WriteString(ircItalic)
+			italic = !italic
+		case ch == '[':
+			if label, url, end, ok := parseLink(text, i); ok {
+				out.WriteString(label + " (" + url + ")")
+				i = end
+				break
+			}
+			out.WriteByte(ch)
+		case ch == ':':
+			if end := strings.IndexByte(text[i+1:], ':'); end > 0 && isShortcode(text[i+1:i+1+end]) {
+				if emoji := util.EmojiUnicode(text[i+1 : i+1+end]); emoji != "" {
+					out.WriteString(emoji)
+					i += end + 1
+					break
+				}
+			}
+			out.WriteByte(ch)
+		case ch == '@' && (i == 0 || !isNameChar(text[i-1])):
+			end := i + 1
+			for end < len(text) && isNameChar(text[end]) {
+				end++
+			}
+			for end > i+1 && text[end-1] == '.' {
+				end--
+			}
+			if nick, ok := ic.mentionNick(text[i+1 : end]); ok {
+				out.WriteString(nick)
+				i = end - 1
+				break
+			}
+			out.WriteByte(ch)
+		default:
+			out.WriteByte(ch)
+		}
+	}
+	return out.String()
+}
+
+// parseLink parses [label](http(s)://url) starting at i.
+func parseLink(text string, i int) (label, url string, end int, ok bool) {
+	closeLabel := strings.IndexByte(text[i:], ']')
+	if closeLabel < 0 || i+closeLabel+1 >= len(text) || text[i+closeLabel+1] != '(' {
+		return "", "", 0, false
+	}
+	closeLabel += i
+	closeURL := strings.IndexByte(text[closeLabel:], ')')
+	if closeURL < 0 {
+		return "", "", 0, false
+	}
+	closeURL += closeLabel
+	url = text[closeLabel+2 : closeURL]
+	if strings.ContainsAny(url, " \t") || !(strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://")) {
+		return "", "", 0, false
+	}
+	return text[i+1 : closeLabel], url, closeURL, true
+}
+
+func isShortcode(name string) bool {
+	for i := 0; i < len(name); i++ {
+		ch := name[i]
+		if !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || isDigit(ch) || ch == '_' || ch == '-' || ch == '+') {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// isNameChar reports whether ch can be part of a username.
+func isNameChar(ch byte) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || isDigit(ch) || ch == '_' || ch == '-' || ch == '.'
+}
+
+// mentionNick returns the current nick of the robot or a mapped user.
+func (ic *ircConnector) mentionNick(name string) (string, bool) {
+	name = strings.ToLower(name)
+	ic.mu.RLock()
+	_, mapped := ic.botUserMap[name]
+	ic.mu.RUnlock()
+	if !mapped && name != ic.botName {
+		return "", false
+	}
+	return ic.resolveNick("", name)
+}
+
+// splitLine splits a line into parts of at most limit bytes, breaking at a
+// space where it can and never inside a UTF-8 character.
+func splitLine(line string, limit int) []string {
+	var parts []string
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		if cut == 0 {
+			_, cut = utf8.DecodeRuneInString(line)
+		}
+		if space := strings.LastIndexByte(line[:cut], ' '); space > limit/2 {
+			cut = space
+		}
+		parts = append(parts, line[:cut])
+		line = strings.TrimLeft(line[cut:], " ")
+	}
+	if line != "" {
+		parts = append(parts, line)
+	}
+	return parts
+}
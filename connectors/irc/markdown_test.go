@@ -0,0 +1,55 @@
+package irc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+func TestRenderLines(t *testing.T) {
+	ic := &ircConnector{
+		botName:    "bishop",
+		nick:       "bishop_",
+		botUserMap: map[string]string{"alice": "alice"},
+		users:      map[string]*ircUser{"al": {nick: "Al", account: "alice"}},
+	}
+	for _, tc := range []struct {
+		in     string
+		format robot.MessageFormat
+		want   []string
+	}{
+		{"**Deploy** *done*, ask @alice or @bishop, not @carol.", robot.BasicMarkdown, []string{"\x02Deploy\x02 \x1Ddone\x1D, ask Al or bishop_, not @carol."}},
+		{"see [the docs](https://example.com/docs) :thumbsup:", robot.BasicMarkdown, []string{"see the docs (https://example.com/docs) 👍"}},
+		{"run `@alice **x**`\n\n```sh\nmake  all\n```", robot.BasicMarkdown, []string{"run @alice **x**", "make  all"}},
+		{`a \*literal\* star`, robot.BasicMarkdown, []string{"a *literal* star"}},
+		{"\x02bold\x02 \x0304red\x03\r\nnext  ", robot.Variable, []string{"bold red", "next"}},
+		{"**raw**\n\n", robot.Raw, []string{"**raw**"}},
+	} {
+		if got := ic.renderLines(tc.in, tc.format); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("renderLines(%q, %v)\n got %q\nwant %q", tc.in, tc.format, got, tc.want)
+		}
+	}
+}
+
+func TestSplitLine(t *testing.T) {
+	if got := splitLine("short", 20); !reflect.DeepEqual(got, []string{"short"}) {
+		t.Errorf("short line split into %q", got)
+	}
+	if got := splitLine("aaaa bbbb cccc", 10); !reflect.DeepEqual(got, []string{"aaaa bbbb", "cccc"}) {
+		t.Errorf("split at space = %q", got)
+	}
+	if got := splitLine(strings.Repeat("x", 25), 10); !reflect.DeepEqual(got, []string{"xxxxxxxxxx", "xxxxxxxxxx", "xxxxx"}) {
+		t.Errorf("split without spaces = %q", got)
+	}
+	for _, part := range splitLine(strings.Repeat("é", 20), 5) {
+		if len(part) > 5 || !utf8.ValidString(part) {
+			t.Errorf("split inside a character: %q", part)
+		}
+	}
+	if got := splitLine("😀😀", 2); !reflect.DeepEqual(got, []string{"😀", "😀"}) {
+		t.Errorf("split with a limit smaller than a character = %q", got)
+	}
+}
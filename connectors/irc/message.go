@@ -0,0 +1,194 @@
+package irc
+
+import (
+	"errors"
+	"strings"
+)
+
+// maxLineLength is the longest line the server may send: 8191 bytes of
+// tags plus the 512 byte message.
+const maxLineLength = 8191 + 512
+
+var errEmptyLine = errors.New("empty line")
+
+// message is one parsed IRC protocol line.
+type message struct {
+	Tags    map[string]string
+	Source  string // nick!user@host, or a server name
+	Command string // upper-cased command or three-digit numeric
+	Params  []string
+}
+
+func (m *message) param(i int) string {
+	if i < len(m.Params) {
+		return m.Params[i]
+	}
+	return ""
+}
+
+// nick returns the nick from the message source.
+func (m *message) nick() string {
+	nick, _, _ := splitSource(m.Source)
+	return nick
+}
+
+// parseMessage parses a line without its trailing CRLF.
+func parseMessage(line string) (*message, error) {
+	line = strings.TrimRight(line, "\r\n")
+	m := &message{}
+	if strings.HasPrefix(line, "@") {
+		tags, rest, _ := strings.Cut(line[1:], " ")
+		m.Tags = parseTags(tags)
+		line = strings.TrimLeft(rest, " ")
+	}
+	if strings.HasPrefix(line, ":") {
+		source, rest, _ := strings.Cut(line[1:], " ")
+		m.Source = source
+		line = strings.TrimLeft(rest, " ")
+	}
+	for line != "" {
+		if strings.HasPrefix(line, ":") {
+			m.Params = append(m.Params, line[1:])
+			break
+		}
+		param, rest, _ := strings.Cut(line, " ")
+		if m.Command == "" {
+			m.Command = strings.ToUpper(param)
+		} else {
+			m.Params = append(m.Params, param)
+		}
+		line = strings.TrimLeft(rest, " ")
+	}
+	if m.Command == "" {
+		return nil, errEmptyLine
+	}
+	return m, nil
+}
+
+func parseTags(raw string) map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(raw, ";") {
+		key, value, _ := strings.Cut(tag, "=")
+		if key != "" {
+			tags[key] = unescapeTagValue(value)
+		}
+	}
+	return tags
+}
+
+var tagUnescaper = strings.NewReplacer(`\:`, ";", `\s`, " ", `\\`, `\`, `\r`, "\r", `\n`, "\n")
+
+func unescapeTagValue(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+	value = strings.TrimSuffix(value, `\`)
+	return tagUnescaper.Replace(value)
+}
+
+// formatLine builds a protocol line from a command and its parameters; the
+// last parameter is sent as a trailing parameter when it needs to be.
+func formatLine(command string, params ...string) string {
+	var out strings.Builder
+	out.WriteString(command)
+	for i, param := range params {
+		out.WriteByte(' ')
+		if i == len(params)-1 && (param == "" || strings.Contains(param, " ") || strings.HasPrefix(param, ":")) {
+			out.WriteByte(':')
+		}
+		out.WriteString(param)
+	}
+	return out.String()
+}
+
+// splitSource splits nick!user@host.
+func splitSource(source string) (nick, user, host string) {
+	nick, rest, _ := strings.Cut(source, "!")
+	user, host, _ = strings.Cut(rest, "@")
+	return nick, user, host
+}
+
+// caseMapping is the server's rule for comparing nicks and channel names,
+// from the CASEMAPPING ISUPPORT token.
+type caseMapping int
+
+const (
+	caseRFC1459 caseMapping = iota
+	caseStrictRFC1459
+	caseASCII
+)
+
+func parseCaseMapping(value string) caseMapping {
+	switch strings.ToLower(value) {
+	case "ascii":
+		return caseASCII
+	case "strict-rfc1459":
+		return caseStrictRFC1459
+	default:
+		return caseRFC1459
+	}
+}
+
+// fold returns the canonical form of a nick or channel name.
+func (cm caseMapping) fold(s string) string {
+	b := []byte(s)
+	for i, ch := range b {
+		switch {
+		case ch >= 'A' && ch <= 'Z':
+			b[i] = ch + 'a' - 'A'
+		case cm == caseASCII:
+		case ch == '[' || ch == ']' || ch == '\\':
+			b[i] = ch + '{' - '['
+		case ch == '~' && cm == caseRFC1459:
+			b[i] = '^'
+		}
+	}
+	return string(b)
+}
+
+// stripFormatting removes mIRC formatting codes: bold, italics, underline,
+// strikethrough, monospace, reverse, reset and colors.
+func stripFormatting(text string) string {
+	if strings.IndexFunc(text, func(r rune) bool { return r < ' ' }) < 0 {
+		return text
+	}
+	var out strings.Builder
+	for i := 0; i < len(text); i++ {
+		switch ch := text[i]; ch {
+		case '\x02', '\x0F', '\x11', '\x16', '\x1D', '\x1E', '\x1F':
+		case '\x03':
+			i = skipColor(text, i, 2, isDigit)
+		case '\x04':
+			i = skipColor(text, i, 6, isHexDigit)
+		default:
+			out.WriteByte(ch)
+		}
+	}
+	return out.String()
+}
+
+// skipColor skips the foreground[,background] digits after a color code at
+// i, returning the index of the last byte of the code.
+func skipColor(text string, i, width int, valid func(byte) bool) int {
+	digits := func(j int) int {
+		n := 0
+		for n < width && j+n < len(text) && valid(text[j+n]) {
+			n++
+		}
+		return n
+	}
+	n := digits(i + 1)
+	i += n
+	if n > 0 && i+2 < len(text) && text[i+1] == ',' {
+		if m := digits(i + 2); m > 0 {
+			i += 1 + m
+		}
+	}
+	return i
+}
+
+func isDigit(ch byte) bool { return ch >= '0' && ch <= '9' }
+
+func isHexDigit(ch byte) bool {
+	return isDigit(ch) || (ch >= 'a' && ch <= 'f') || (ch >= 'A' && ch <= 'F')
+}
@@ -0,0 +1,76 @@
+package irc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMessage(t *testing.T) {
+	m, err := parseMessage("@account=alice;msgid=a\\sb\\:c;+typing :alice!al@example.com PRIVMSG #ops :hello there\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &message{
+		Tags:    map[string]string{"account": "alice", "msgid": "a b;c", "+typing": ""},
+		Source:  "alice!al@example.com",
+		Command: "PRIVMSG",
+		Params:  []string{"#ops", "hello there"},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("parseMessage = %+v, want %+v", m, want)
+	}
+	if m.nick() != "alice" || m.param(2) != "" {
+		t.Errorf("nick %q, param(2) %q", m.nick(), m.param(2))
+	}
+	if m, _ := parseMessage("ping  server\n"); m.Command != "PING" || !reflect.DeepEqual(m.Params, []string{"server"}) {
+		t.Errorf("PING parsed as %+v", m)
+	}
+	if _, err := parseMessage("\r\n"); err == nil {
+		t.Error("empty line parsed")
+	}
+}
+
+func TestFormatLine(t *testing.T) {
+	for _, tc := range []struct {
+		params []string
+		want   string
+	}{
+		{[]string{"#ops"}, "JOIN #ops"},
+		{[]string{"#ops", "hello there"}, "JOIN #ops :hello there"},
+		{[]string{"#ops", ":)"}, "JOIN #ops ::)"},
+		{[]string{"#ops", ""}, "JOIN #ops :"},
+	} {
+		if got := formatLine("JOIN", tc.params...); got != tc.want {
+			t.Errorf("formatLine(%q) = %q, want %q", tc.params, got, tc.want)
+		}
+	}
+}
+
+func TestCaseMapping(t *testing.T) {
+	for _, tc := range []struct {
+		mapping, in, want string
+	}{
+		{"rfc1459", "Bishop[m]~", "bishop{m}^"},
+		{"strict-rfc1459", "Bishop[m]~", "bishop{m}~"},
+		{"ascii", "Bishop[m]~", "bishop[m]~"},
+		{"", "Bishop[m]", "bishop{m}"},
+	} {
+		if got := parseCaseMapping(tc.mapping).fold(tc.in); got != tc.want {
+			t.Errorf("%q fold(%q) = %q, want %q", tc.mapping, tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestStripFormatting(t *testing.T) {
+	for in, want := range map[string]string{
+		"\x02bold\x02 \x1Ditalic\x0F":    "bold italic",
+		"\x0304,12red on blue\x03 plain": "red on blue plain",
+		"\x03,5comma stays":              ",5comma stays",
+		"\x04ff0000hex\x04":              "hex",
+		"100\x0399 percent":              "100 percent",
+	} {
+		if got := stripFormatting(in); got != want {
+			t.Errorf("stripFormatting(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
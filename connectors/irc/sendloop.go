@@ -0,0 +1,83 @@
+package irc
+
+import (
+	"errors"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const sendQueueSize = 512
+
+// Flood control: the robot may send floodBurst lines at once, after which
+// lines go out one per floodDelay. This is the same penalty scheme most
+// ircds use to decide when a client is flooding, so a robot that follows it
+// isn't disconnected for "Excess Flood".
+const (
+	floodBurst = 5
+	floodDelay = 2 * time.Second
+)
+
+func (ic *ircConnector) queueLine(line string) bool {
+	ic.mu.RLock()
+	q := ic.queue
+	ic.mu.RUnlock()
+	if q == nil {
+		ic.Log(robot.Warn, "Dropping IRC outbound message while connector is stopped")
+		return false
+	}
+	select {
+	case q <- line:
+		return true
+	default:
+		ic.Log(robot.Warn, "IRC outbound queue is full; dropping message")
+		return false
+	}
+}
+
+// sendLoop writes queued lines at the rate flood control allows; while the
+// robot is disconnected, lines wait in the queue.
+func (ic *ircConnector) sendLoop(stop <-chan struct{}, q <-chan string) {
+	penalty := time.Now()
+	for {
+		var line string
+		select {
+		case <-stop:
+			return
+		case line = <-q:
+		}
+		now := time.Now()
+		if penalty.Before(now) {
+			penalty = now
+		}
+		if wait := penalty.Sub(now) - time.Duration(ic.floodBurst-1)*ic.floodDelay; wait > 0 {
+			ic.Log(robot.Trace, "IRC flood control delaying next line by %v", wait)
+			select {
+			case <-stop:
+				return
+			case <-time.After(wait):
+			}
+		}
+		penalty = penalty.Add(ic.floodDelay)
+		for {
+			ic.mu.RLock()
+			ready := ic.ready
+			ic.mu.RUnlock()
+			select {
+			case <-stop:
+				return
+			case <-ready:
+			}
+			err := ic.writeLine(line)
+			if errors.Is(err, errNotConnected) {
+				// the connection dropped after ready; wait for the next one
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			if err != nil {
+				ic.Log(robot.Error, "IRC send failed: %v", err)
+			}
+			break
+		}
+	}
+}
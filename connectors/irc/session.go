@@ -0,0 +1,455 @@
+package irc
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const (
+	registerTimeout  = time.Minute
+	pingInterval     = 2 * time.Minute
+	pongTimeout      = time.Minute
+	keepaliveTick    = 15 * time.Second
+	minStreamBackoff = time.Second
+	maxStreamBackoff = time.Minute
+	maxNickRetries   = 5
+	// SASL payloads are sent base64 encoded in chunks of this size.
+	saslChunkSize = 400
+)
+
+// wantedCaps are the IRCv3 capabilities the connector uses when the server
+// offers them; sasl is requested separately.
+var wantedCaps = []string{"account-notify", "account-tag", "echo-message", "extended-join", "message-tags", "multi-prefix"}
+
+// Run connects to the server and reads from it until stop is closed,
+// reconnecting with backoff when the connection drops.
+func (ic *ircConnector) Run(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	q := make(chan string, sendQueueSize)
+	ic.mu.Lock()
+	ic.queue = q
+	ic.mu.Unlock()
+	sendStop := make(chan struct{})
+	go ic.sendLoop(sendStop, q)
+	defer func() {
+		close(sendStop)
+		ic.mu.Lock()
+		ic.queue = nil
+		ic.mu.Unlock()
+	}()
+
+	backoff := minStreamBackoff
+	for ctx.Err() == nil {
+		registered, err := ic.stream(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if registered {
+			backoff = minStreamBackoff
+		}
+		ic.Log(robot.Error, "IRC connection failed, reconnecting in %s: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxStreamBackoff {
+			backoff = maxStreamBackoff
+		}
+	}
+}
+
+// session is the state of one connection, used only by the goroutine
+// reading it, apart from the atomics the keepalive checks.
+type session struct {
+	ic         *ircConnector
+	conn       net.Conn
+	started    time.Time
+	lastRead   atomic.Int64
+	lastPing   atomic.Int64
+	registered atomic.Bool
+
+	offered   map[string]string // capabilities the server offers
+	enabled   map[string]bool
+	capEnded  bool
+	saslOK    bool
+	nickTries int
+}
+
+// stream registers on one connection and reads from it until it fails or
+// ctx is cancelled; registered reports whether registration completed.
+func (ic *ircConnector) stream(ctx context.Context) (registered bool, err error) {
+	conn, err := ic.dial(ctx)
+	if err != nil {
+		return false, err
+	}
+	s := &session{
+		ic:      ic,
+		conn:    conn,
+		started: time.Now(),
+		offered: make(map[string]string),
+		enabled: make(map[string]bool),
+	}
+	s.lastRead.Store(time.Now().UnixNano())
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		conn.Close()
+	}()
+	go s.keepalive(done)
+
+	ic.resetState()
+	ic.mu.Lock()
+	ic.conn = conn
+	ic.mu.Unlock()
+	defer func() {
+		ic.mu.Lock()
+		ic.conn = nil
+		if s.registered.Load() {
+			ic.ready = make(chan struct{})
+		}
+		ic.mu.Unlock()
+	}()
+
+	if err := s.register(); err != nil {
+		return false, err
+	}
+	r := bufio.NewReaderSize(conn, maxLineLength)
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return s.registered.Load(), err
+		}
+		s.lastRead.Store(time.Now().UnixNano())
+		m, err := parseMessage(line)
+		if err != nil {
+			continue
+		}
+		if err := s.handle(m); err != nil {
+			return s.registered.Load(), err
+		}
+	}
+}
+
+// readLine reads one line, discarding any that are too long.
+func readLine(r *bufio.Reader) (string, error) {
+	for {
+		line, err := r.ReadSlice('\n')
+		if err == nil {
+			return string(line), nil
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return "", err
+		}
+		for errors.Is(err, bufio.ErrBufferFull) {
+			_, err = r.ReadSlice('\n')
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// keepalive pings the server when the connection has been idle, and drops
+// connections that stop responding or don't finish registering.
+func (s *session) keepalive(done <-chan struct{}) {
+	ticker := time.NewTicker(keepaliveTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		lastRead := time.Unix(0, s.lastRead.Load())
+		idle := time.Since(lastRead)
+		switch {
+		case !s.registered.Load() && time.Since(s.started) > registerTimeout:
+			s.ic.Log(robot.Error, "IRC registration timed out after %s", registerTimeout)
+			s.conn.Close()
+			return
+		case idle > pingInterval+pongTimeout:
+			s.ic.Log(robot.Warn, "IRC server stopped responding; reconnecting")
+			s.conn.Close()
+			return
+		case idle > pingInterval && s.lastPing.Load() < lastRead.UnixNano():
+			s.lastPing.Store(time.Now().UnixNano())
+			s.write("PING", "keepalive")
+		}
+	}
+}
+
+func (s *session) write(command string, params ...string) error {
+	return s.ic.write(s.conn, formatLine(command, params...))
+}
+
+// register starts registration, negotiating capabilities first.
+func (s *session) register() error {
+	ic := s.ic
+	if err := s.write("CAP", "LS", "302"); err != nil {
+		return err
+	}
+	if ic.password != "" {
+		if err := s.write("PASS", ic.password); err != nil {
+			return err
+		}
+	}
+	if err := s.write("NICK", ic.wantNick); err != nil {
+		return err
+	}
+	return s.write("USER", ic.user, "0", "*", ic.realName)
+}
+
+func (s *session) handle(m *message) error {
+	ic := s.ic
+	switch m.Command {
+	case "PING":
+		return s.write("PONG", m.Params...)
+	case "ERROR":
+		return fmt.Errorf("server closed the connection: %s", m.param(0))
+	case "CAP":
+		return s.handleCap(m)
+	case "AUTHENTICATE":
+		if m.param(0) == "+" {
+			return s.sendSASLResponse()
+		}
+	case "900": // RPL_LOGGEDIN <nick> <nick!user@host> <account> :text
+		ic.mu.Lock()
+		ic.selfMask = m.param(1)
+		ic.selfAccount = normalizeAccount(m.param(2))
+		ic.mu.Unlock()
+		ic.Log(robot.Info, "IRC: logged in as %s", m.param(2))
+		ic.SetBotID(normalizeAccount(m.param(2)))
+	case "903": // RPL_SASLSUCCESS
+		s.saslOK = true
+		return s.endCap()
+	case "902", "904", "905", "906": // SASL failed or aborted
+		ic.Log(robot.Error, "IRC SASL %s authentication failed: %s", s.ic.sasl, m.param(len(m.Params)-1))
+		return s.endCap()
+	case "001":
+		return s.welcome(m)
+	case "005":
+		if len(m.Params) > 2 {
+			ic.applyISupport(m.Params[1 : len(m.Params)-1])
+		}
+	case "432", "433", "436", "437": // nick unusable or in use
+		if s.registered.Load() {
+			ic.Log(robot.Debug, "IRC: nick %s is unavailable: %s", m.param(1), m.param(len(m.Params)-1))
+			return nil
+		}
+		s.nickTries++
+		if s.nickTries > maxNickRetries {
+			return fmt.Errorf("no usable nick after %d tries", s.nickTries)
+		}
+		nick := ic.wantNick + strings.Repeat("_", s.nickTries)
+		ic.Log(robot.Warn, "IRC: nick %s is unavailable, trying %s", m.param(1), nick)
+		ic.mu.Lock()
+		ic.nick = nick
+		ic.mu.Unlock()
+		return s.write("NICK", nick)
+	case "JOIN":
+		ic.noteUser(m.Source, m.Tags["account"], s.enabled["account-tag"])
+		ic.handleJoin(m)
+	case "PART":
+		ic.handlePart(m.param(0), m.nick())
+	case "KICK":
+		if ic.isSelf(m.param(1)) {
+			ic.Log(robot.Warn, "IRC: kicked from %s by %s: %s", m.param(0), m.nick(), m.param(2))
+		}
+		ic.handlePart(m.param(0), m.param(1))
+	case "QUIT":
+		ic.handleQuit(m.nick())
+		s.reclaimNick(m.nick())
+	case "NICK":
+		if ic.handleNick(m.nick(), m.param(0)) {
+			ic.Log(robot.Info, "IRC: the robot's nick is now %s", m.param(0))
+			ic.mu.RLock()
+			loggedIn := ic.selfAccount != ""
+			ic.mu.RUnlock()
+			if !loggedIn {
+				ic.SetBotID(m.param(0))
+			}
+		} else {
+			s.reclaimNick(m.nick())
+		}
+	case "MODE":
+		if ic.isChannel(m.param(0)) && len(m.Params) > 1 {
+			ic.handleMode(m.param(0), m.param(1), m.Params[2:])
+		}
+	case "ACCOUNT":
+		ic.noteUser(m.Source, m.param(0), true)
+	case "353": // RPL_NAMREPLY <nick> <symbol> <channel> :names
+		ic.handleNames(m.param(2), m.param(3))
+	case "354": // RPL_WHOSPCRPL <nick> <token> <nick> <account> :<real name>
+		if m.param(1) == whoToken {
+			ic.handleWhox(m.param(2), m.param(3), m.param(4))
+		}
+	case "PRIVMSG":
+		ic.handlePrivmsg(m, s.enabled["account-tag"])
+	case "NOTICE":
+		if strings.EqualFold(m.nick(), ic.nickServ) {
+			ic.Log(robot.Debug, "IRC: %s says: %s", ic.nickServ, m.param(1))
+		}
+	case "401", "403", "404", "405", "471", "473", "474", "475", "477":
+		// no such nick/channel, can't send, can't join
+		ic.Log(robot.Warn, "IRC: %s: %s", m.param(1), m.param(len(m.Params)-1))
+	case "464", "465":
+		ic.Log(robot.Error, "IRC server refused the connection: %s", m.param(len(m.Params)-1))
+	}
+	return nil
+}
+
+// handleCap handles capability negotiation replies.
+func (s *session) handleCap(m *message) error {
+	switch strings.ToUpper(m.param(1)) {
+	case "LS":
+		// CAP * LS [*] :caps; the "*" marks a continued list
+		more := len(m.Params) > 3 && m.param(2) == "*"
+		for _, c := range strings.Fields(m.param(len(m.Params) - 1)) {
+			name, value, _ := strings.Cut(c, "=")
+			s.offered[name] = value
+		}
+		if more || s.capEnded {
+			return nil
+		}
+		var req []string
+		for _, c := range wantedCaps {
+			if _, ok := s.offered[c]; ok {
+				req = append(req, c)
+			}
+		}
+		if s.ic.sasl != "" {
+			if mechs, ok := s.offered["sasl"]; ok && (mechs == "" || containsFold(strings.Split(mechs, ","), s.ic.sasl)) {
+				req = append(req, "sasl")
+			} else {
+				s.ic.Log(robot.Warn, "IRC server doesn't offer SASL %s", s.ic.sasl)
+			}
+		}
+		if len(req) == 0 {
+			return s.endCap()
+		}
+		return s.write("CAP", "REQ", strings.Join(req, " "))
+	case "ACK":
+		for _, c := range strings.Fields(m.param(len(m.Params) - 1)) {
+			s.enabled[strings.TrimPrefix(c, "-")] = !strings.HasPrefix(c, "-")
+		}
+		s.ic.mu.Lock()
+		s.ic.typing = s.enabled["message-tags"]
+		s.ic.mu.Unlock()
+		if s.enabled["sasl"] && !s.capEnded {
+			return s.write("AUTHENTICATE", strings.ToUpper(s.ic.sasl))
+		}
+		return s.endCap()
+	case "NAK":
+		s.ic.Log(robot.Warn, "IRC server refused capabilities: %s", m.param(len(m.Params)-1))
+		return s.endCap()
+	}
+	return nil
+}
+
+func (s *session) endCap() error {
+	if s.capEnded {
+		return nil
+	}
+	s.capEnded = true
+	return s.write("CAP", "END")
+}
+
+// sendSASLResponse answers the server's AUTHENTICATE challenge.
+func (s *session) sendSASLResponse() error {
+	ic := s.ic
+	if ic.sasl == saslExternal {
+		// the certificate identifies the account
+		return s.write("AUTHENTICATE", "+")
+	}
+	payload := base64.StdEncoding.EncodeToString([]byte(ic.account + "\x00" + ic.account + "\x00" + ic.accountPW))
+	for len(payload) >= saslChunkSize {
+		if err := s.write("AUTHENTICATE", payload[:saslChunkSize]); err != nil {
+			return err
+		}
+		payload = payload[saslChunkSize:]
+	}
+	if payload == "" {
+		payload = "+"
+	}
+	return s.write("AUTHENTICATE", payload)
+}
+
+// welcome completes registration: it identifies with NickServ when SASL
+// didn't log the robot in, and joins the robot's channels.
+func (s *session) welcome(m *message) error {
+	ic := s.ic
+	s.capEnded = true
+	if s.registered.Load() {
+		return nil
+	}
+	ic.mu.Lock()
+	ic.nick = m.param(0)
+	close(ic.ready)
+	s.registered.Store(true)
+	selfAccount := ic.selfAccount
+	var join []wantedChannel
+	for _, ch := range ic.wanted {
+		join = append(join, ch)
+	}
+	ic.mu.Unlock()
+	ic.Log(robot.Info, "IRC: registered as %s", m.param(0))
+
+	if selfAccount == "" {
+		ic.SetBotID(m.param(0))
+		if ic.accountPW != "" && !s.saslOK && ic.sasl != saslExternal {
+			if ic.sasl != "" {
+				ic.Log(robot.Warn, "IRC: SASL didn't log in; identifying with %s", ic.nickServ)
+			}
+			if err := s.write("PRIVMSG", ic.nickServ, "IDENTIFY "+ic.account+" "+ic.accountPW); err != nil {
+				return err
+			}
+		}
+	}
+	for _, ch := range join {
+		ic.joinChannel(ch)
+	}
+	return nil
+}
+
+// reclaimNick takes the configured nick back when the user holding it
+// leaves or changes nick.
+func (s *session) reclaimNick(gone string) {
+	ic := s.ic
+	ic.mu.RLock()
+	reclaim := ic.caseMap.fold(gone) == ic.caseMap.fold(ic.wantNick) && ic.caseMap.fold(ic.nick) != ic.caseMap.fold(ic.wantNick)
+	ic.mu.RUnlock()
+	if reclaim {
+		ic.Log(robot.Info, "IRC: nick %s is free again; reclaiming it", ic.wantNick)
+		s.write("NICK", ic.wantNick)
+	}
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(strings.TrimSpace(item), s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,320 @@
+package irc
+
+import (
+	"strings"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+// whoToken tags the robot's WHOX queries, so replies to anyone else's WHO
+// aren't mistaken for them.
+const whoToken = "152"
+
+// applyISupport records the RPL_ISUPPORT tokens the connector uses.
+func (ic *ircConnector) applyISupport(tokens []string) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	for _, token := range tokens {
+		key, value, _ := strings.Cut(token, "=")
+		switch strings.ToUpper(key) {
+		case "CASEMAPPING":
+			ic.caseMap = parseCaseMapping(value)
+		case "CHANTYPES":
+			if value != "" {
+				ic.chanTypes = value
+			}
+		case "PREFIX":
+			// e.g. (qaohv)~&@%+
+			modes, chars, ok := strings.Cut(strings.TrimPrefix(value, "("), ")")
+			if ok && len(modes) == len(chars) {
+				ic.prefixModes, ic.prefixChars = modes, chars
+			}
+		case "CHANMODES":
+			copy(ic.chanModes[:], strings.SplitN(value, ",", 4))
+		case "WHOX":
+			ic.whox = true
+		}
+	}
+	// Anything folded before CASEMAPPING arrived is refolded.
+	wanted := make(map[string]wantedChannel, len(ic.wanted))
+	for _, ch := range ic.wanted {
+		wanted[ic.caseMap.fold(ch.name)] = ch
+	}
+	ic.wanted = wanted
+}
+
+func (ic *ircConnector) isChannel(target string) bool {
+	ic.mu.RLock()
+	defer ic.mu.RUnlock()
+	return target != "" && strings.ContainsRune(ic.chanTypes, rune(target[0]))
+}
+
+func (ic *ircConnector) isSelf(nick string) bool {
+	ic.mu.RLock()
+	defer ic.mu.RUnlock()
+	return ic.caseMap.fold(nick) == ic.caseMap.fold(ic.nick)
+}
+
+// isOpLocked reports whether membership prefixes include channel operator
+// or higher.
+func (ic *ircConnector) isOpLocked(prefixes string) bool {
+	rank := strings.IndexByte(ic.prefixModes, 'o')
+	for i := 0; i < len(prefixes); i++ {
+		if r := strings.IndexByte(ic.prefixChars, prefixes[i]); r >= 0 && r <= rank {
+			return true
+		}
+	}
+	return false
+}
+
+// noteUser records what a message says about its sender; account is only
+// updated when known is true.
+func (ic *ircConnector) noteUser(source, account string, known bool) *ircUser {
+	nick, user, host := splitSource(source)
+	if nick == "" || user == "" {
+		return nil
+	}
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	u := ic.userLocked(nick)
+	u.user, u.host = user, host
+	if known {
+		u.account = normalizeAccount(account)
+	}
+	if ic.caseMap.fold(nick) == ic.caseMap.fold(ic.nick) {
+		ic.selfMask = source
+	}
+	copied := *u
+	return &copied
+}
+
+func (ic *ircConnector) userLocked(nick string) *ircUser {
+	folded := ic.caseMap.fold(nick)
+	u, ok := ic.users[folded]
+	if !ok {
+		u = &ircUser{nick: nick}
+		ic.users[folded] = u
+	}
+	return u
+}
+
+// forgetUserLocked drops a user who no longer shares a channel with the
+// robot.
+func (ic *ircConnector) forgetUserLocked(folded string) {
+	for _, ch := range ic.channels {
+		if _, ok := ch.members[folded]; ok {
+			return
+		}
+	}
+	delete(ic.users, folded)
+}
+
+// handleJoin handles JOIN; with extended-join it carries the account and
+// real name.
+func (ic *ircConnector) handleJoin(m *message) {
+	name := m.param(0)
+	nick, user, host := splitSource(m.Source)
+	ic.mu.Lock()
+	folded := ic.caseMap.fold(name)
+	self := ic.caseMap.fold(nick) == ic.caseMap.fold(ic.nick)
+	if self {
+		ic.channels[folded] = &ircChannel{name: name, members: make(map[string]string)}
+		ic.selfMask = m.Source
+	}
+	ch, ok := ic.channels[folded]
+	if !ok {
+		ic.mu.Unlock()
+		return
+	}
+	ch.members[ic.caseMap.fold(nick)] = ""
+	u := ic.userLocked(nick)
+	u.user, u.host = user, host
+	if len(m.Params) >= 3 {
+		u.account = normalizeAccount(m.Params[1])
+		u.realName = m.Params[2]
+	}
+	whox := ic.whox
+	ic.mu.Unlock()
+	if self {
+		ic.Log(robot.Info, "IRC: joined %s", name)
+		if whox {
+			// learn the accounts and real names of the users already there
+			ic.writeLine(formatLine("WHO", name, "%tnar,"+whoToken))
+		}
+	}
+}
+
+// handlePart handles the robot or another user leaving a channel, by PART
+// or KICK.
+func (ic *ircConnector) handlePart(name, nick string) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	folded := ic.caseMap.fold(name)
+	ch, ok := ic.channels[folded]
+	if !ok {
+		return
+	}
+	foldedNick := ic.caseMap.fold(nick)
+	if foldedNick == ic.caseMap.fold(ic.nick) {
+		delete(ic.channels, folded)
+		for member := range ch.members {
+			ic.forgetUserLocked(member)
+		}
+		return
+	}
+	delete(ch.members, foldedNick)
+	ic.forgetUserLocked(foldedNick)
+}
+
+func (ic *ircConnector) handleQuit(nick string) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	folded := ic.caseMap.fold(nick)
+	for _, ch := range ic.channels {
+		delete(ch.members, folded)
+	}
+	delete(ic.users, folded)
+}
+
+// handleNick renames a user, returning true when the robot's own nick
+// changed.
+func (ic *ircConnector) handleNick(oldNick, newNick string) bool {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	oldFolded, newFolded := ic.caseMap.fold(oldNick), ic.caseMap.fold(newNick)
+	for _, ch := range ic.channels {
+		if prefixes, ok := ch.members[oldFolded]; ok {
+			delete(ch.members, oldFolded)
+			ch.members[newFolded] = prefixes
+		}
+	}
+	if u, ok := ic.users[oldFolded]; ok {
+		delete(ic.users, oldFolded)
+		u.nick = newNick
+		ic.users[newFolded] = u
+	}
+	if oldFolded != ic.caseMap.fold(ic.nick) {
+		return false
+	}
+	ic.nick = newNick
+	if _, mask, ok := strings.Cut(ic.selfMask, "!"); ok {
+		ic.selfMask = newNick + "!" + mask
+	}
+	return true
+}
+
+// handleNames handles an RPL_NAMREPLY line of a channel's members.
+func (ic *ircConnector) handleNames(name, names string) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	ch, ok := ic.channels[ic.caseMap.fold(name)]
+	if !ok {
+		return
+	}
+	for _, entry := range strings.Fields(names) {
+		n := 0
+		for n < len(entry) && strings.IndexByte(ic.prefixChars, entry[n]) >= 0 {
+			n++
+		}
+		nick, _, _ := strings.Cut(entry[n:], "!")
+		if nick == "" {
+			continue
+		}
+		ch.members[ic.caseMap.fold(nick)] = entry[:n]
+		ic.userLocked(nick)
+	}
+}
+
+// handleWhox handles a reply to the robot's "WHO <channel> %tnar" query.
+func (ic *ircConnector) handleWhox(nick, account, realName string) {
+	if account == "0" {
+		account = ""
+	}
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	if u, ok := ic.users[ic.caseMap.fold(nick)]; ok {
+		u.account = normalizeAccount(account)
+		u.realName = realName
+	}
+}
+
+// handleMode applies channel membership mode changes, e.g. "+o-v alice bob".
+func (ic *ircConnector) handleMode(name, modes string, args []string) {
+	ic.mu.Lock()
+	ch, ok := ic.channels[ic.caseMap.fold(name)]
+	if !ok {
+		ic.mu.Unlock()
+		return
+	}
+	selfFolded := ic.caseMap.fold(ic.nick)
+	wasOp := ic.isOpLocked(ch.members[selfFolded])
+	adding := true
+	next := func() string {
+		if len(args) == 0 {
+			return ""
+		}
+		arg := args[0]
+		args = args[1:]
+		return arg
+	}
+	for i := 0; i < len(modes); i++ {
+		mode := modes[i]
+		switch {
+		case mode == '+':
+			adding = true
+		case mode == '-':
+			adding = false
+		case strings.IndexByte(ic.prefixModes, mode) >= 0:
+			nick := ic.caseMap.fold(next())
+			prefixes, member := ch.members[nick]
+			if !member {
+				continue
+			}
+			ch.members[nick] = ic.setPrefixLocked(prefixes, ic.prefixChars[strings.IndexByte(ic.prefixModes, mode)], adding)
+		case strings.IndexByte(ic.chanModes[0], mode) >= 0, strings.IndexByte(ic.chanModes[1], mode) >= 0:
+			next()
+		case adding && strings.IndexByte(ic.chanModes[2], mode) >= 0:
+			next()
+		}
+	}
+	isOp := ic.isOpLocked(ch.members[selfFolded])
+	ic.mu.Unlock()
+	if isOp != wasOp {
+		if isOp {
+			ic.Log(robot.Info, "IRC: the robot is now a channel operator in %s", name)
+		} else {
+			ic.Log(robot.Info, "IRC: the robot is no longer a channel operator in %s", name)
+		}
+	}
+}
+
+// setPrefixLocked adds or removes a membership prefix, keeping prefixes in
+// rank order.
+func (ic *ircConnector) setPrefixLocked(prefixes string, prefix byte, set bool) string {
+	var out strings.Builder
+	for i := 0; i < len(ic.prefixChars); i++ {
+		ch := ic.prefixChars[i]
+		has := strings.IndexByte(prefixes, ch) >= 0
+		if ch == prefix {
+			has = set
+		}
+		if has {
+			out.WriteByte(ch)
+		}
+	}
+	return out.String()
+}
+
+// resetState forgets channels and users when the connection drops; they're
+// relearned when the robot rejoins.
+func (ic *ircConnector) resetState() {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	ic.channels = make(map[string]*ircChannel)
+	ic.users = make(map[string]*ircUser)
+	ic.selfMask = ""
+	ic.selfAccount = ""
+	ic.typing = false
+	ic.whox = false
+	ic.nick = ic.wantNick
+}
@@ -0,0 +1,7 @@
+package irc
+
+import "github.com/lnxjedi/gopherbot/robot"
+
+func init() {
+	robot.RegisterConnector("irc", Initialize)
+}
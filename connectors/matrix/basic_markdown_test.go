@@ -0,0 +1,41 @@
+package matrix
+
+import (
+	"testing"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+func TestRenderBasicMarkdown(t *testing.T) {
+	mc := &matrixConnector{botUserMap: map[string]string{"alice": "@alice:example.org"}}
+	for in, want := range map[string]string{
+		"**bold** and *italic*":                 "<strong>bold</strong> and <em>italic</em>",
+		"run `ls <dir>` now":                    "run <code>ls &lt;dir&gt;</code> now",
+		"see [the docs](https://example.com/a)": `see <a href="https://example.com/a">the docs</a>`,
+		"[bad](javascript:alert(1))":            "[bad](javascript:alert(1))",
+		"line one\nline two :smile:":            "line one<br>line two 😄",
+		`literal \*stars\* & <tags>`:            "literal *stars* &amp; &lt;tags&gt;",
+		"ping @alice, not bob@alice.com":        `ping <a href="https://matrix.to/#/@alice:example.org">@alice</a>, not bob@alice.com`,
+		"```go\nfmt.Println(\"<hi>\")\n```":     `<pre><code class="language-go">fmt.Println(&#34;&lt;hi&gt;&#34;)</code></pre>`,
+	} {
+		if got, _ := mc.renderBasicMarkdown(in); got != want {
+			t.Errorf("renderBasicMarkdown(%q)\n got %q\nwant %q", in, got, want)
+		}
+	}
+}
+
+func TestRenderMessageFormats(t *testing.T) {
+	mc := &matrixConnector{}
+	body, formatted, _ := mc.renderMessage("a <b>", robot.Fixed)
+	if body != "a <b>" || formatted != "<pre><code>a &lt;b&gt;</code></pre>" {
+		t.Errorf("Fixed = %q, %q", body, formatted)
+	}
+	body, formatted, _ = mc.renderMessage("**hi**", robot.BasicMarkdown)
+	if body != "hi" || formatted != "<strong>hi</strong>" {
+		t.Errorf("BasicMarkdown = %q, %q", body, formatted)
+	}
+	body, formatted, _ = mc.renderMessage("**hi**", robot.Variable)
+	if body != "**hi**" || formatted != "" {
+		t.Errorf("Variable = %q, %q", body, formatted)
+	}
+}
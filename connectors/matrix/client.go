@@ -0,0 +1,241 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	clientAPIPrefix  = "/_matrix/client/v3"
+	maxErrorBodySize = 4096
+)
+
+// apiClient is a minimal client for the parts of the Matrix client-server
+// API the connector uses.
+type apiClient struct {
+	homeserver  string
+	accessToken string
+	http        *http.Client
+}
+
+// apiError is a standard Matrix error response.
+type apiError struct {
+	Status       int    `json:"-"`
+	ErrCode      string `json:"errcode"`
+	Message      string `json:"error"`
+	RetryAfterMS int64  `json:"retry_after_ms"`
+}
+
+func (e *apiError) Error() string {
+	if e.ErrCode == "" {
+		return fmt.Sprintf("HTTP %d", e.Status)
+	}
+	return fmt.Sprintf("HTTP %d %s: %s", e.Status, e.ErrCode, e.Message)
+}
+
+func newAPIClient(homeserver, accessToken string) *apiClient {
+	return &apiClient{
+		homeserver:  strings.TrimRight(homeserver, "/"),
+		accessToken: accessToken,
+		http:        &http.Client{Timeout: syncTimeout + 30*time.Second},
+	}
+}
+
+// do sends a request to a client-server API path and decodes a JSON
+// response into out when it's non-nil.
+func (c *apiClient) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	u := c.homeserver + clientAPIPrefix + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.accessToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &apiError{Status: resp.StatusCode}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+		_ = json.Unmarshal(data, apiErr)
+		return apiErr
+	}
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func pathEscape(parts ...string) string {
+	var b strings.Builder
+	for _, part := range parts {
+		b.WriteByte('/')
+		b.WriteString(url.PathEscape(part))
+	}
+	return b.String()
+}
+
+// retryAfter reports how long to wait before retrying a rate-limited
+// request, or false if err isn't a rate limit.
+func retryAfter(err error) (time.Duration, bool) {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		return 0, false
+	}
+	if apiErr.Status != http.StatusTooManyRequests && apiErr.ErrCode != "M_LIMIT_EXCEEDED" {
+		return 0, false
+	}
+	delay := time.Duration(apiErr.RetryAfterMS) * time.Millisecond
+	if delay <= 0 {
+		delay = time.Second
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay, true
+}
+
+type whoamiResponse struct {
+	UserID   string `json:"user_id"`
+	DeviceID string `json:"device_id"`
+}
+
+func (c *apiClient) whoami(ctx context.Context) (string, error) {
+	var resp whoamiResponse
+	if err := c.do(ctx, http.MethodGet, "/account/whoami", nil, nil, &resp); err != nil {
+		return "", err
+	}
+	if resp.UserID == "" {
+		return "", errors.New("whoami returned no user_id")
+	}
+	return resp.UserID, nil
+}
+
+type event struct {
+	Type           string          `json:"type"`
+	EventID        string          `json:"event_id"`
+	Sender         string          `json:"sender"`
+	StateKey       *string         `json:"state_key,omitempty"`
+	OriginServerTS int64           `json:"origin_server_ts"`
+	Content        json.RawMessage `json:"content"`
+}
+
+type eventList struct {
+	Events []event `json:"events"`
+}
+
+type joinedRoom struct {
+	State       eventList `json:"state"`
+	Timeline    eventList `json:"timeline"`
+	AccountData eventList `json:"account_data"`
+}
+
+type invitedRoom struct {
+	InviteState eventList `json:"invite_state"`
+}
+
+type syncResponse struct {
+	NextBatch   string    `json:"next_batch"`
+	AccountData eventList `json:"account_data"`
+	Rooms       struct {
+		Join   map[string]joinedRoom  `json:"join"`
+		Invite map[string]invitedRoom `json:"invite"`
+		Leave  map[string]joinedRoom  `json:"leave"`
+	} `json:"rooms"`
+}
+
+// Sync filters drop presence and ephemeral events. The initial sync only
+// needs room state and account data to build the room and DM maps, so it
+// skips the backlog of timeline events.
+const (
+	initialSyncFilter = `{"room":{"timeline":{"limit":1},"ephemeral":{"not_types":["*"]}},"presence":{"not_types":["*"]}}`
+	syncFilter        = `{"room":{"timeline":{"limit":100},"ephemeral":{"not_types":["*"]}},"presence":{"not_types":["*"]}}`
+)
+
+func (c *apiClient) sync(ctx context.Context, since string, timeout time.Duration) (*syncResponse, error) {
+	query := url.Values{}
+	query.Set("timeout", fmt.Sprintf("%d", timeout.Milliseconds()))
+	if since == "" {
+		query.Set("filter", initialSyncFilter)
+	} else {
+		query.Set("filter", syncFilter)
+		query.Set("since", since)
+	}
+	var resp syncResponse
+	if err := c.do(ctx, http.MethodGet, "/sync", query, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *apiClient) sendMessage(ctx context.Context, roomID, txnID string, content interface{}) (string, error) {
+	var resp struct {
+		EventID string `json:"event_id"`
+	}
+	path := pathEscape("rooms", roomID, "send", "m.room.message", txnID)
+	if err := c.do(ctx, http.MethodPut, path, nil, content, &resp); err != nil {
+		return "", err
+	}
+	return resp.EventID, nil
+}
+
+func (c *apiClient) joinRoom(ctx context.Context, roomIDOrAlias string) (string, error) {
+	var resp struct {
+		RoomID string `json:"room_id"`
+	}
+	if err := c.do(ctx, http.MethodPost, pathEscape("join", roomIDOrAlias), nil, struct{}{}, &resp); err != nil {
+		return "", err
+	}
+	return resp.RoomID, nil
+}
+
+func (c *apiClient) createDirectRoom(ctx context.Context, userID string) (string, error) {
+	req := map[string]interface{}{
+		"is_direct": true,
+		"invite":    []string{userID},
+		"preset":    "trusted_private_chat",
+	}
+	var resp struct {
+		RoomID string `json:"room_id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/createRoom", nil, req, &resp); err != nil {
+		return "", err
+	}
+	return resp.RoomID, nil
+}
+
+func (c *apiClient) setAccountData(ctx context.Context, userID, dataType string, content interface{}) error {
+	return c.do(ctx, http.MethodPut, pathEscape("user", userID, "account_data", dataType), nil, content, nil)
+}
+
+func (c *apiClient) setTyping(ctx context.Context, roomID, userID string, typing bool, timeout time.Duration) error {
+	req := map[string]interface{}{"typing": typing}
+	if typing {
+		req["timeout"] = timeout.Milliseconds()
+	}
+	return c.do(ctx, http.MethodPut, pathEscape("rooms", roomID, "typing", userID), nil, req, nil)
+}
@@ -0,0 +1,106 @@
+// Package matrix implements the robot.Connector interface over the Matrix
+// client-server API.
+package matrix
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const whoamiTimeout = 10 * time.Second
+
+// Invite policies for AcceptInvites.
+const (
+	acceptInvitesMapped = "mapped"
+	acceptInvitesAll    = "all"
+	acceptInvitesNone   = "none"
+)
+
+type config struct {
+	Homeserver      string // base URL of the homeserver, e.g. https://matrix.example.com
+	AccessToken     string // access token for the robot's Matrix account
+	AcceptInvites   string // "mapped" (default), "all" or "none"
+	ThreadResponses bool   // reply in a thread to messages that didn't start one
+	UserMap         map[string]string
+}
+
+// normalizeUserID returns the canonical form of a Matrix user ID, or "" if
+// it isn't one.
+func normalizeUserID(in string) string {
+	in = strings.TrimSpace(in)
+	if !strings.HasPrefix(in, "@") || !strings.Contains(in, ":") {
+		return ""
+	}
+	return in
+}
+
+func normalizeConfiguredUserMap(in map[string]string, h robot.Handler) map[string]string {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(in))
+	for user, id := range in {
+		name := strings.TrimSpace(user)
+		uid := normalizeUserID(id)
+		if name == "" || uid == "" {
+			h.Log(robot.Warn, "Ignoring invalid Matrix UserMap entry (empty username or invalid user ID): %q -> %q", user, id)
+			continue
+		}
+		if strings.ToLower(name) != name {
+			h.Log(robot.Warn, "Ignoring Matrix UserMap entry with uppercase username: %q", user)
+			continue
+		}
+		out[name] = uid
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+func normalizeAcceptInvites(in string, h robot.Handler) string {
+	switch policy := strings.ToLower(strings.TrimSpace(in)); policy {
+	case "":
+		return acceptInvitesMapped
+	case acceptInvitesMapped, acceptInvitesAll, acceptInvitesNone:
+		return policy
+	default:
+		h.Log(robot.Warn, "Unknown Matrix AcceptInvites value %q, using %q", in, acceptInvitesMapped)
+		return acceptInvitesMapped
+	}
+}
+
+// Initialize validates config, checks the access token with the homeserver,
+// and returns the connector.
+func Initialize(handler robot.Handler, l *log.Logger) robot.InitializedConnector {
+	var c config
+	if err := handler.GetProtocolConfig(&c); err != nil {
+		handler.Log(robot.Fatal, "Unable to retrieve matrix protocol configuration: %v", err)
+	}
+	homeserver := strings.TrimSpace(c.Homeserver)
+	if u, err := url.Parse(homeserver); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		handler.Log(robot.Fatal, "Matrix protocol config requires Homeserver as an http(s) URL, got %q", c.Homeserver)
+	}
+	token := strings.TrimSpace(c.AccessToken)
+	if token == "" {
+		handler.Log(robot.Fatal, "Matrix protocol config requires AccessToken")
+	}
+
+	api := newAPIClient(homeserver, token)
+	ctx, cancel := context.WithTimeout(context.Background(), whoamiTimeout)
+	selfID, err := api.whoami(ctx)
+	cancel()
+	if err != nil {
+		handler.Log(robot.Fatal, "Unable to verify Matrix access token with %s: %v", homeserver, err)
+	}
+
+	connector := newMatrixConnector(handler, api, selfID, c)
+	handler.Log(robot.Info, "Matrix connector logged in to %s as %s", homeserver, selfID)
+	handler.SetBotID(selfID)
+	return robot.InitializedConnector{Connector: connector}
+}
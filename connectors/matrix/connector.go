@@ -0,0 +1,643 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+	"github.com/lnxjedi/gopherbot/robot/util"
+)
+
+const (
+	syncTimeout     = 30 * time.Second
+	sendTimeout     = 10 * time.Second
+	joinTimeout     = 10 * time.Second
+	typingTimeout   = 10 * time.Second
+	maxSendAttempts = 2
+	sendRetryDelay  = 250 * time.Millisecond
+	maxRetryDelay   = 5 * time.Second
+	minSyncBackoff  = time.Second
+	maxSyncBackoff  = time.Minute
+	// Matrix caps events at 65536 bytes including the envelope.
+	maxMessageSize = 60000
+)
+
+var matrixTxnSeq atomic.Uint64
+
+type matrixUserRecord struct {
+	UserID        string
+	DisplayName   string
+	CanonicalName string
+}
+
+type matrixRoomRecord struct {
+	RoomID    string
+	Name      string
+	Alias     string
+	Encrypted bool
+	warned    bool
+}
+
+// channelName is the name the engine sees for a room: the local part of its
+// canonical alias, or else its display name.
+func (r matrixRoomRecord) channelName() string {
+	if alias := strings.TrimPrefix(r.Alias, "#"); alias != "" {
+		local, _, _ := strings.Cut(alias, ":")
+		return local
+	}
+	return r.Name
+}
+
+type matrixConnector struct {
+	robot.Handler
+
+	api        *apiClient
+	selfID     string
+	serverName string
+	botName    string
+	retrySleep func(time.Duration)
+
+	mu              sync.RWMutex
+	acceptInvites   string
+	threadResponses bool
+	botUserMap      map[string]string // username -> Matrix user ID
+	configuredUsers map[string]string // Matrix user ID -> username
+	usersByID       map[string]matrixUserRecord
+	rooms           map[string]*matrixRoomRecord
+	directContent   map[string][]string // the robot's m.direct account data
+	directRooms     map[string]string   // room ID -> other user, for DM rooms
+	directByUser    map[string]string   // user -> preferred DM room ID
+	invites         map[string]bool
+}
+
+func newMatrixConnector(handler robot.Handler, api *apiClient, selfID string, c config) *matrixConnector {
+	botName := strings.TrimSpace(handler.GetBotInfo().UserName)
+	if botName == "" {
+		botName = "gopherbot"
+	}
+	_, serverName, _ := strings.Cut(selfID, ":")
+	mc := &matrixConnector{
+		Handler:         handler,
+		api:             api,
+		selfID:          selfID,
+		serverName:      serverName,
+		botName:         botName,
+		acceptInvites:   normalizeAcceptInvites(c.AcceptInvites, handler),
+		threadResponses: c.ThreadResponses,
+		botUserMap:      normalizeConfiguredUserMap(c.UserMap, handler),
+		usersByID:       make(map[string]matrixUserRecord),
+		rooms:           make(map[string]*matrixRoomRecord),
+		directContent:   make(map[string][]string),
+		directRooms:     make(map[string]string),
+		directByUser:    make(map[string]string),
+		invites:         make(map[string]bool),
+	}
+	mc.configuredUsers = configuredUsersByID(mc.botUserMap)
+	return mc
+}
+
+func configuredUsersByID(userMap map[string]string) map[string]string {
+	configured := make(map[string]string, len(userMap))
+	for name, id := range userMap {
+		configured[id] = name
+	}
+	return configured
+}
+
+func (mc *matrixConnector) Reload() error {
+	var c config
+	if err := mc.GetProtocolConfig(&c); err != nil {
+		return fmt.Errorf("retrieve Matrix protocol configuration: %w", err)
+	}
+	newBotUserMap := normalizeConfiguredUserMap(c.UserMap, mc.Handler)
+	newConfiguredUsers := configuredUsersByID(newBotUserMap)
+	acceptInvites := normalizeAcceptInvites(c.AcceptInvites, mc.Handler)
+
+	mc.mu.Lock()
+	for id, record := range mc.usersByID {
+		record.CanonicalName = newConfiguredUsers[id]
+		mc.usersByID[id] = record
+	}
+	mc.botUserMap = newBotUserMap
+	mc.configuredUsers = newConfiguredUsers
+	mc.acceptInvites = acceptInvites
+	mc.threadResponses = c.ThreadResponses
+	mc.mu.Unlock()
+
+	mc.Log(robot.Info, "Matrix connector reloaded %d configured user mapping(s)", len(newBotUserMap))
+	return nil
+}
+
+// Run is the sync loop; it long-polls the homeserver until stop is closed,
+// backing off on errors.
+func (mc *matrixConnector) Run(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	since := ""
+	backoff := minSyncBackoff
+	for ctx.Err() == nil {
+		resp, err := mc.api.sync(ctx, since, syncTimeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			mc.Log(robot.Error, "Matrix sync failed, retrying in %s: %v", backoff, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > maxSyncBackoff {
+				backoff = maxSyncBackoff
+			}
+			continue
+		}
+		backoff = minSyncBackoff
+		mc.processSync(resp, since == "")
+		since = resp.NextBatch
+	}
+}
+
+func (mc *matrixConnector) ensureRoom(roomID string) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if _, ok := mc.rooms[roomID]; !ok {
+		mc.rooms[roomID] = &matrixRoomRecord{RoomID: roomID}
+	}
+}
+
+func (mc *matrixConnector) updateRoom(roomID string, update func(*matrixRoomRecord)) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	room, ok := mc.rooms[roomID]
+	if !ok {
+		room = &matrixRoomRecord{RoomID: roomID}
+		mc.rooms[roomID] = room
+	}
+	update(room)
+}
+
+func (mc *matrixConnector) forgetRoom(roomID string) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	delete(mc.rooms, roomID)
+	delete(mc.invites, roomID)
+	if user, ok := mc.directRooms[roomID]; ok {
+		delete(mc.directRooms, roomID)
+		if mc.directByUser[user] == roomID {
+			delete(mc.directByUser, user)
+		}
+	}
+}
+
+func (mc *matrixConnector) roomRecord(roomID string) matrixRoomRecord {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	if room, ok := mc.rooms[roomID]; ok {
+		return *room
+	}
+	return matrixRoomRecord{RoomID: roomID}
+}
+
+func (mc *matrixConnector) warnEncrypted(roomID string) {
+	mc.mu.Lock()
+	room, ok := mc.rooms[roomID]
+	warn := ok && !room.warned
+	if warn {
+		room.warned = true
+	}
+	mc.mu.Unlock()
+	if warn {
+		mc.Log(robot.Warn, "Matrix room %s is end-to-end encrypted, which the connector doesn't support; ignoring its messages", roomID)
+	}
+}
+
+// firstInvite reports whether this is the first time the invite to roomID
+// has been seen; invites stay in every sync until they're answered.
+func (mc *matrixConnector) firstInvite(roomID string) bool {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if mc.invites[roomID] {
+		return false
+	}
+	mc.invites[roomID] = true
+	return true
+}
+
+func (mc *matrixConnector) cacheUser(userID, displayName string) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	record := mc.usersByID[userID]
+	record.UserID = userID
+	if displayName = strings.TrimSpace(displayName); displayName != "" {
+		record.DisplayName = displayName
+	}
+	record.CanonicalName = mc.configuredUsers[userID]
+	mc.usersByID[userID] = record
+}
+
+func (mc *matrixConnector) canonicalName(userID string) string {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	return mc.configuredUsers[userID]
+}
+
+// setDirectRooms replaces the DM map from the robot's m.direct account data.
+func (mc *matrixConnector) setDirectRooms(raw json.RawMessage) {
+	content := make(map[string][]string)
+	if err := json.Unmarshal(raw, &content); err != nil {
+		mc.Log(robot.Warn, "Ignoring invalid Matrix m.direct account data: %v", err)
+		return
+	}
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.directContent = content
+	mc.directRooms = make(map[string]string)
+	mc.directByUser = make(map[string]string)
+	for user, rooms := range content {
+		for _, roomID := range rooms {
+			mc.directRooms[roomID] = user
+			mc.directByUser[user] = roomID
+		}
+	}
+}
+
+func (mc *matrixConnector) isDirectRoom(roomID string) bool {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	_, ok := mc.directRooms[roomID]
+	return ok
+}
+
+// noteDirectRoom makes roomID the preferred DM room for user, since that's
+// where they last talked to the robot.
+func (mc *matrixConnector) noteDirectRoom(user, roomID string) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if mc.directRooms[roomID] == user {
+		mc.directByUser[user] = roomID
+	}
+}
+
+// addDirectRoom records a new DM room in the robot's m.direct account data.
+func (mc *matrixConnector) addDirectRoom(user, roomID string) {
+	mc.mu.Lock()
+	content := make(map[string][]string, len(mc.directContent)+1)
+	for u, rooms := range mc.directContent {
+		content[u] = append([]string(nil), rooms...)
+	}
+	content[user] = append(content[user], roomID)
+	mc.directContent = content
+	mc.directRooms[roomID] = user
+	mc.directByUser[user] = roomID
+	mc.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	if err := mc.api.setAccountData(ctx, mc.selfID, "m.direct", content); err != nil {
+		mc.Log(robot.Warn, "Matrix: failed to update m.direct account data for %s: %v", user, err)
+	}
+}
+
+func (mc *matrixConnector) join(roomIDOrAlias string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), joinTimeout)
+	defer cancel()
+	roomID, err := mc.api.joinRoom(ctx, roomIDOrAlias)
+	if err != nil {
+		return "", err
+	}
+	mc.ensureRoom(roomID)
+	return roomID, nil
+}
+
+func (mc *matrixConnector) GetProtocolUserAttribute(u, attr string) (string, robot.RetVal) {
+	userID, ok := mc.resolveUserID(u, u)
+	if !ok {
+		return "", robot.UserNotFound
+	}
+	mc.mu.RLock()
+	record, known := mc.usersByID[userID]
+	canonical := mc.configuredUsers[userID]
+	mc.mu.RUnlock()
+	if !known && canonical == "" {
+		return "", robot.UserNotFound
+	}
+	switch strings.ToLower(strings.TrimSpace(attr)) {
+	case "name":
+		if canonical != "" {
+			return canonical, robot.Ok
+		}
+		if record.DisplayName != "" {
+			return record.DisplayName, robot.Ok
+		}
+	case "fullname", "realname":
+		if record.DisplayName != "" {
+			return record.DisplayName, robot.Ok
+		}
+	case "internalid":
+		return userID, robot.Ok
+	}
+	return "", robot.AttributeNotFound
+}
+
+// MessageHeard sends a typing notification to the room.
+func (mc *matrixConnector) MessageHeard(user, channel string) {
+	roomID, ok := util.ExtractID(channel)
+	if !ok || roomID == "" {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		defer cancel()
+		if err := mc.api.setTyping(ctx, roomID, mc.selfID, true, typingTimeout); err != nil {
+			mc.Log(robot.Debug, "Matrix typing notification failed for %s: %v", roomID, err)
+		}
+	}()
+}
+
+func (mc *matrixConnector) DefaultHelp() []string { return nil }
+
+// JoinChannel joins a room by ID, alias, or the local part of an alias on
+// the robot's homeserver.
+func (mc *matrixConnector) JoinChannel(c string) robot.RetVal {
+	if roomID, ok := mc.resolveRoomID(c); ok {
+		mc.mu.RLock()
+		_, joined := mc.rooms[roomID]
+		mc.mu.RUnlock()
+		if joined {
+			return robot.Ok
+		}
+	}
+	target := strings.TrimSpace(c)
+	if id, ok := util.ExtractID(target); ok {
+		target = id
+	}
+	if !strings.HasPrefix(target, "!") && !strings.HasPrefix(target, "#") {
+		target = "#" + target
+	}
+	if strings.HasPrefix(target, "#") && !strings.Contains(target, ":") {
+		target += ":" + mc.serverName
+	}
+	roomID, err := mc.join(target)
+	if err != nil {
+		mc.Log(robot.Error, "Matrix: failed to join %s: %v", target, err)
+		return robot.FailedChannelJoin
+	}
+	mc.Log(robot.Info, "Matrix: joined %s (%s)", target, roomID)
+	return robot.Ok
+}
+
+func (mc *matrixConnector) SendProtocolChannelThreadMessage(channelname, threadid, msg string, format robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	roomID, ok := mc.resolveRoomID(channelname)
+	if !ok {
+		mc.Log(robot.Error, "Matrix room not found for: %s", channelname)
+		return robot.ChannelNotFound
+	}
+	threadID := mc.resolveThreadForContext(roomID, "", threadid, msgObject)
+	return mc.sendMessage(roomID, "", threadID, msg, format)
+}
+
+func (mc *matrixConnector) SendProtocolUserChannelThreadMessage(userid, username, channelname, threadid, msg string, format robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	roomID, ok := mc.resolveRoomID(channelname)
+	if !ok {
+		mc.Log(robot.Error, "Matrix room not found for: %s", channelname)
+		return robot.ChannelNotFound
+	}
+	userID, ok := mc.resolveUserID(userid, username)
+	if !ok {
+		mc.Log(robot.Error, "Matrix user not found for: %s", username)
+		return robot.UserNotFound
+	}
+	threadID := mc.resolveThreadForContext(roomID, userID, threadid, msgObject)
+	return mc.sendMessage(roomID, userID, threadID, msg, format)
+}
+
+func (mc *matrixConnector) SendProtocolUserMessage(user, msg string, format robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	userID, ok := mc.resolveUserID(user, user)
+	if !ok {
+		mc.Log(robot.Error, "Matrix user not found for DM: %s", user)
+		return robot.UserNotFound
+	}
+	roomID, err := mc.directRoom(userID)
+	if err != nil {
+		mc.Log(robot.Error, "Matrix: unable to open a direct room with %s: %v", userID, err)
+		return robot.FailedMessageSend
+	}
+	return mc.sendMessage(roomID, "", "", msg, format)
+}
+
+// directRoom returns the DM room for a user, creating one if needed.
+func (mc *matrixConnector) directRoom(userID string) (string, error) {
+	mc.mu.RLock()
+	roomID, ok := mc.directByUser[userID]
+	if ok {
+		_, ok = mc.rooms[roomID]
+	}
+	mc.mu.RUnlock()
+	if ok {
+		return roomID, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), joinTimeout)
+	roomID, err := mc.api.createDirectRoom(ctx, userID)
+	cancel()
+	if err != nil {
+		return "", err
+	}
+	mc.ensureRoom(roomID)
+	mc.addDirectRoom(userID, roomID)
+	return roomID, nil
+}
+
+func (mc *matrixConnector) resolveRoomID(channel string) (string, bool) {
+	if id, ok := util.ExtractID(channel); ok {
+		id = strings.TrimSpace(id)
+		return id, id != ""
+	}
+	channel = strings.TrimSpace(channel)
+	if channel == "" {
+		return "", false
+	}
+	if strings.HasPrefix(channel, "!") {
+		return channel, true
+	}
+	key := strings.ToLower(channel)
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	for id, room := range mc.rooms {
+		if strings.ToLower(room.Alias) == key || strings.ToLower(room.channelName()) == key {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// resolveUserID only treats bracketed IDs and full Matrix user IDs as
+// transport IDs; usernames are resolved through UserMap.
+func (mc *matrixConnector) resolveUserID(uid, username string) (string, bool) {
+	if id, ok := util.ExtractID(uid); ok {
+		id = normalizeUserID(id)
+		return id, id != ""
+	}
+	if id := normalizeUserID(uid); id != "" {
+		return id, true
+	}
+	key := strings.ToLower(strings.TrimSpace(username))
+	if key == "" {
+		return "", false
+	}
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	id, ok := mc.botUserMap[key]
+	return id, ok
+}
+
+func (mc *matrixConnector) resolveThreadForContext(roomID, userID, explicitThreadID string, msgObject *robot.ConnectorMessage) string {
+	threadID := strings.TrimSpace(explicitThreadID)
+	mc.mu.RLock()
+	threadResponses := mc.threadResponses
+	mc.mu.RUnlock()
+	if threadID != "" || !threadResponses || msgObject == nil {
+		return threadID
+	}
+	if msgObject.DirectMessage || msgObject.ChannelID != roomID {
+		return ""
+	}
+	if userID != "" && msgObject.UserID != userID {
+		return ""
+	}
+	return strings.TrimSpace(msgObject.ThreadID)
+}
+
+func newTxnID() string {
+	return fmt.Sprintf("gopherbot-%d-%d", time.Now().UnixNano(), matrixTxnSeq.Add(1))
+}
+
+func (mc *matrixConnector) sendMessage(roomID, userID, threadID, msg string, format robot.MessageFormat) robot.RetVal {
+	content := mc.buildMessageContent(userID, threadID, msg, format)
+	if content == nil {
+		mc.Log(robot.Error, "Matrix: refusing to send empty message")
+		return robot.Failed
+	}
+	if size := len(content.Body) + len(content.FormattedBody); size > maxMessageSize {
+		mc.Log(robot.Error, "Matrix message exceeds maximum size (%d bytes)", maxMessageSize)
+		return robot.FailedMessageSend
+	}
+	// Reusing the transaction ID on retry keeps the homeserver from posting
+	// the message twice if the first attempt landed.
+	txnID := newTxnID()
+	var err error
+	for attempt := 1; attempt <= maxSendAttempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		_, err = mc.api.sendMessage(ctx, roomID, txnID, content)
+		cancel()
+		if err == nil {
+			return robot.Ok
+		}
+		if attempt == maxSendAttempts {
+			break
+		}
+		delay, retry := retryAfter(err)
+		if !retry {
+			if !isTransientError(err) {
+				break
+			}
+			delay = sendRetryDelay
+		}
+		mc.Log(robot.Warn, "Matrix send attempt %d/%d failed for %s; retrying: %v", attempt, maxSendAttempts, roomID, err)
+		mc.sleepForRetry(delay)
+	}
+	mc.Log(robot.Error, "Matrix send failed to %s: %v", roomID, err)
+	return robot.FailedMessageSend
+}
+
+func isTransientError(err error) bool {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr.Status >= 500
+	}
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+func (mc *matrixConnector) sleepForRetry(delay time.Duration) {
+	if mc.retrySleep != nil {
+		mc.retrySleep(delay)
+		return
+	}
+	time.Sleep(delay)
+}
+
+type outgoingContent struct {
+	MsgType       string                 `json:"msgtype"`
+	Body          string                 `json:"body"`
+	Format        string                 `json:"format,omitempty"`
+	FormattedBody string                 `json:"formatted_body,omitempty"`
+	Mentions      *outgoingMentions      `json:"m.mentions,omitempty"`
+	RelatesTo     map[string]interface{} `json:"m.relates_to,omitempty"`
+}
+
+type outgoingMentions struct {
+	UserIDs []string `json:"user_ids,omitempty"`
+}
+
+func (mc *matrixConnector) buildMessageContent(userID, threadID, msg string, format robot.MessageFormat) *outgoingContent {
+	body, formatted, mentions := mc.renderMessage(msg, format)
+	if strings.TrimSpace(body) == "" {
+		return nil
+	}
+	content := &outgoingContent{MsgType: "m.text", Body: body, Mentions: &outgoingMentions{UserIDs: mentions}}
+	if userID != "" {
+		label := mc.mentionLabel(userID)
+		if formatted == "" {
+			formatted = html.EscapeString(body)
+		}
+		content.Body = label + ": " + body
+		formatted = mentionPill(userID, label) + ": " + formatted
+		content.Mentions.UserIDs = append([]string{userID}, content.Mentions.UserIDs...)
+	}
+	if formatted != "" {
+		content.Format = "org.matrix.custom.html"
+		content.FormattedBody = formatted
+	}
+	if threadID != "" {
+		content.RelatesTo = map[string]interface{}{
+			"rel_type":        "m.thread",
+			"event_id":        threadID,
+			"is_falling_back": true,
+			"m.in_reply_to":   map[string]string{"event_id": threadID},
+		}
+	}
+	return content
+}
+
+// mentionLabel is the visible text of a mention; clients highlight the
+// mentioned user when it matches their display name.
+func (mc *matrixConnector) mentionLabel(userID string) string {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	if record, ok := mc.usersByID[userID]; ok && record.DisplayName != "" {
+		return record.DisplayName
+	}
+	if name, ok := mc.configuredUsers[userID]; ok {
+		return name
+	}
+	return userID
+}
+
+func mentionPill(userID, label string) string {
+	return `<a href="https://matrix.to/#/` + html.EscapeString(userID) + `">` + html.EscapeString(label) + `</a>`
+}
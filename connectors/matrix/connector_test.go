@@ -0,0 +1,329 @@
+package matrix
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+type testHandler struct {
+	cfg      config
+	botID    string
+	incoming chan *robot.ConnectorMessage
+}
+
+func (h *testHandler) IncomingMessage(msg *robot.ConnectorMessage) { h.incoming <- msg }
+func (h *testHandler) GetProtocolConfig(v interface{}) error {
+	*(v.(*config)) = h.cfg
+	return nil
+}
+func (h *testHandler) GetBrainConfig(interface{}) error                 { return nil }
+func (h *testHandler) GetEventStrings() *[]string                       { return nil }
+func (h *testHandler) GetHistoryConfig(interface{}) error               { return nil }
+func (h *testHandler) GetBotInfo() robot.BotInfo                        { return robot.BotInfo{UserName: "bishop"} }
+func (h *testHandler) SetBotID(id string)                               { h.botID = id }
+func (h *testHandler) SetTerminalWriter(io.Writer)                      {}
+func (h *testHandler) SetBotMention(string)                             {}
+func (h *testHandler) GetLogLevel() robot.LogLevel                      { return robot.Debug }
+func (h *testHandler) GetInstallPath() string                           { return "" }
+func (h *testHandler) GetConfigPath() string                            { return "" }
+func (h *testHandler) ReadEncryptedFile(string) ([]byte, error)         { return nil, nil }
+func (h *testHandler) Log(_ robot.LogLevel, m string, v ...interface{}) {}
+func (h *testHandler) GetDirectory(string) error                        { return nil }
+
+type sentEvent struct {
+	roomID  string
+	content map[string]interface{}
+}
+
+// fakeHomeserver serves canned sync responses and records what the
+// connector sends.
+type fakeHomeserver struct {
+	*httptest.Server
+	mu          sync.Mutex
+	syncs       []string
+	sent        []sentEvent
+	joined      []string
+	accountData map[string]interface{}
+	created     []map[string]interface{}
+}
+
+func newFakeHomeserver(t *testing.T, syncs ...string) *fakeHomeserver {
+	fs := &fakeHomeserver{syncs: syncs}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /_matrix/client/v3/account/whoami", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, `{"errcode":"M_UNKNOWN_TOKEN","error":"bad token"}`)
+			return
+		}
+		io.WriteString(w, `{"user_id":"@bishop:example.org"}`)
+	})
+	mux.HandleFunc("GET /_matrix/client/v3/sync", func(w http.ResponseWriter, r *http.Request) {
+		fs.mu.Lock()
+		if len(fs.syncs) == 0 {
+			fs.mu.Unlock()
+			<-r.Context().Done()
+			return
+		}
+		resp := fs.syncs[0]
+		fs.syncs = fs.syncs[1:]
+		fs.mu.Unlock()
+		io.WriteString(w, resp)
+	})
+	mux.HandleFunc("PUT /_matrix/client/v3/rooms/{room}/send/m.room.message/{txn}", func(w http.ResponseWriter, r *http.Request) {
+		var content map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&content); err != nil {
+			t.Errorf("decoding sent message: %v", err)
+		}
+		fs.mu.Lock()
+		fs.sent = append(fs.sent, sentEvent{roomID: r.PathValue("room"), content: content})
+		fs.mu.Unlock()
+		io.WriteString(w, `{"event_id":"$sent"}`)
+	})
+	mux.HandleFunc("POST /_matrix/client/v3/join/{room}", func(w http.ResponseWriter, r *http.Request) {
+		fs.mu.Lock()
+		fs.joined = append(fs.joined, r.PathValue("room"))
+		fs.mu.Unlock()
+		io.WriteString(w, `{"room_id":"`+r.PathValue("room")+`"}`)
+	})
+	mux.HandleFunc("POST /_matrix/client/v3/createRoom", func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&req)
+		fs.mu.Lock()
+		fs.created = append(fs.created, req)
+		fs.mu.Unlock()
+		io.WriteString(w, `{"room_id":"!newdm:example.org"}`)
+	})
+	mux.HandleFunc("PUT /_matrix/client/v3/user/{user}/account_data/{type}", func(w http.ResponseWriter, r *http.Request) {
+		var content map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&content)
+		fs.mu.Lock()
+		fs.accountData = content
+		fs.mu.Unlock()
+		io.WriteString(w, `{}`)
+	})
+	fs.Server = httptest.NewServer(mux)
+	t.Cleanup(fs.Close)
+	return fs
+}
+
+const initialSync = `{
+  "next_batch": "s1",
+  "account_data": {"events": [
+    {"type": "m.direct", "content": {"@bob:example.org": ["!dm:example.org"]}}
+  ]},
+  "rooms": {"join": {
+    "!ops:example.org": {
+      "state": {"events": [
+        {"type": "m.room.canonical_alias", "state_key": "", "content": {"alias": "#ops:example.org"}},
+        {"type": "m.room.name", "state_key": "", "content": {"name": "Operations"}},
+        {"type": "m.room.member", "state_key": "@alice:example.org", "content": {"membership": "join", "displayname": "Alice A"}}
+      ]},
+      "timeline": {"events": [
+        {"type": "m.room.message", "event_id": "$old", "sender": "@alice:example.org", "content": {"msgtype": "m.text", "body": "old history"}}
+      ]}
+    },
+    "!dm:example.org": {}
+  }}
+}`
+
+const messageSync = `{
+  "next_batch": "s2",
+  "rooms": {
+    "join": {
+      "!ops:example.org": {"timeline": {"events": [
+        {"type": "m.room.message", "event_id": "$reply", "sender": "@alice:example.org", "content": {
+          "msgtype": "m.text", "body": "Bishop: ping",
+          "format": "org.matrix.custom.html",
+          "formatted_body": "<a href=\"https://matrix.to/#/%40bishop%3Aexample.org\">Bishop</a>: ping",
+          "m.relates_to": {"rel_type": "m.thread", "event_id": "$root", "is_falling_back": true, "m.in_reply_to": {"event_id": "$root"}}
+        }},
+        {"type": "m.room.message", "event_id": "$notice", "sender": "@otherbot:example.org", "content": {"msgtype": "m.notice", "body": "beep"}},
+        {"type": "m.room.message", "event_id": "$edit", "sender": "@alice:example.org", "content": {
+          "msgtype": "m.text", "body": "* pong", "m.relates_to": {"rel_type": "m.replace", "event_id": "$reply"}
+        }},
+        {"type": "m.room.message", "event_id": "$self", "sender": "@bishop:example.org", "content": {"msgtype": "m.text", "body": "pong"}}
+      ]}},
+      "!dm:example.org": {"timeline": {"events": [
+        {"type": "m.room.message", "event_id": "$dm", "sender": "@bob:example.org", "content": {"msgtype": "m.text", "body": "help"}}
+      ]}}
+    },
+    "invite": {
+      "!new:example.org": {"invite_state": {"events": [
+        {"type": "m.room.member", "state_key": "@bishop:example.org", "sender": "@alice:example.org", "content": {"membership": "invite", "is_direct": true}}
+      ]}},
+      "!spam:example.org": {"invite_state": {"events": [
+        {"type": "m.room.member", "state_key": "@bishop:example.org", "sender": "@stranger:example.org", "content": {"membership": "invite"}}
+      ]}}
+    }
+  }
+}`
+
+func TestSyncLoopNormalizesMessages(t *testing.T) {
+	fs := newFakeHomeserver(t, initialSync, messageSync)
+	h := &testHandler{
+		cfg: config{
+			Homeserver:  fs.URL,
+			AccessToken: "secret",
+			UserMap:     map[string]string{"alice": "@alice:example.org"},
+		},
+		incoming: make(chan *robot.ConnectorMessage, 10),
+	}
+	ic := Initialize(h, nil)
+	if h.botID != "@bishop:example.org" {
+		t.Fatalf("bot ID = %q", h.botID)
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		ic.Connector.Run(stop)
+		close(done)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	var got []*robot.ConnectorMessage
+	for len(got) < 3 {
+		select {
+		case msg := <-h.incoming:
+			got = append(got, msg)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out after %d messages", len(got))
+		}
+	}
+	byID := map[string]*robot.ConnectorMessage{}
+	for _, msg := range got {
+		byID[msg.MessageID] = msg
+	}
+	if len(byID) != 3 || byID["$old"] != nil {
+		t.Fatalf("got messages %v, want $reply, $self and $dm", byID)
+	}
+
+	reply := byID["$reply"]
+	if reply.MessageText != "@bishop: ping" || reply.UserName != "alice" || !reply.ValidatedUser {
+		t.Errorf("thread reply = %+v", reply)
+	}
+	if reply.ChannelID != "!ops:example.org" || reply.ChannelName != "ops" || reply.ThreadID != "$root" || !reply.ThreadedMessage || reply.DirectMessage {
+		t.Errorf("thread reply context = %+v", reply)
+	}
+	if self := byID["$self"]; !self.SelfMessage {
+		t.Errorf("self message = %+v", self)
+	}
+	dm := byID["$dm"]
+	if !dm.DirectMessage || dm.ChannelID != "" || dm.ValidatedUser || dm.MessageText != "help" {
+		t.Errorf("direct message = %+v", dm)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		fs.mu.Lock()
+		joined := append([]string(nil), fs.joined...)
+		accountData := fs.accountData
+		fs.mu.Unlock()
+		if len(joined) > 0 && accountData != nil {
+			if len(joined) != 1 || joined[0] != "!new:example.org" {
+				t.Fatalf("joined %v, want only the mapped user's invite", joined)
+			}
+			if _, ok := accountData["@alice:example.org"]; !ok {
+				t.Fatalf("m.direct = %v, want the new DM with alice", accountData)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("invite not accepted: joined %v", joined)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWhoamiReportsBadToken(t *testing.T) {
+	fs := newFakeHomeserver(t)
+	api := newAPIClient(fs.URL, "wrong")
+	if _, err := api.whoami(t.Context()); err == nil || !strings.Contains(err.Error(), "M_UNKNOWN_TOKEN") {
+		t.Fatalf("whoami error = %v, want M_UNKNOWN_TOKEN", err)
+	}
+}
+
+func newTestConnector(t *testing.T, fs *fakeHomeserver) *matrixConnector {
+	h := &testHandler{cfg: config{
+		ThreadResponses: true,
+		UserMap: map[string]string{
+			"alice": "@alice:example.org",
+			"carol": "@carol:example.org",
+		},
+	}}
+	mc := newMatrixConnector(h, newAPIClient(fs.URL, "secret"), "@bishop:example.org", h.cfg)
+	var resp syncResponse
+	if err := json.Unmarshal([]byte(initialSync), &resp); err != nil {
+		t.Fatal(err)
+	}
+	mc.processSync(&resp, true)
+	return mc
+}
+
+func TestSendDirectedThreadMessage(t *testing.T) {
+	fs := newFakeHomeserver(t)
+	mc := newTestConnector(t, fs)
+	incoming := &robot.ConnectorMessage{
+		UserID:    "@alice:example.org",
+		ChannelID: "!ops:example.org",
+		ThreadID:  "$root",
+	}
+	if ret := mc.SendProtocolUserChannelThreadMessage("<@alice:example.org>", "alice", "ops", "", "**done**, @carol", robot.BasicMarkdown, incoming); ret != robot.Ok {
+		t.Fatalf("send = %v", ret)
+	}
+	if len(fs.sent) != 1 || fs.sent[0].roomID != "!ops:example.org" {
+		t.Fatalf("sent = %+v", fs.sent)
+	}
+	content := fs.sent[0].content
+	if content["body"] != "Alice A: done, @carol" {
+		t.Errorf("body = %q", content["body"])
+	}
+	wantHTML := `<a href="https://matrix.to/#/@alice:example.org">Alice A</a>: <strong>done</strong>, <a href="https://matrix.to/#/@carol:example.org">@carol</a>`
+	if content["format"] != "org.matrix.custom.html" || content["formatted_body"] != wantHTML {
+		t.Errorf("formatted_body = %q", content["formatted_body"])
+	}
+	mentions, _ := json.Marshal(content["m.mentions"])
+	if string(mentions) != `{"user_ids":["@alice:example.org","@carol:example.org"]}` {
+		t.Errorf("m.mentions = %s", mentions)
+	}
+	relates, _ := content["m.relates_to"].(map[string]interface{})
+	if relates["rel_type"] != "m.thread" || relates["event_id"] != "$root" {
+		t.Errorf("m.relates_to = %v", relates)
+	}
+}
+
+func TestSendUserMessageOpensDirectRoom(t *testing.T) {
+	fs := newFakeHomeserver(t)
+	mc := newTestConnector(t, fs)
+	if ret := mc.SendProtocolUserMessage("carol", "hello", robot.Raw, nil); ret != robot.Ok {
+		t.Fatalf("send = %v", ret)
+	}
+	if len(fs.created) != 1 || fs.sent[0].roomID != "!newdm:example.org" {
+		t.Fatalf("created %v, sent %+v", fs.created, fs.sent)
+	}
+	if rooms, _ := fs.accountData["@carol:example.org"].([]interface{}); len(rooms) != 1 || fs.accountData["@bob:example.org"] == nil {
+		t.Fatalf("m.direct = %v", fs.accountData)
+	}
+	if _, ok := fs.sent[0].content["formatted_body"]; ok {
+		t.Errorf("raw message has formatted_body: %v", fs.sent[0].content)
+	}
+
+	// The DM room is reused for the next message.
+	if ret := mc.SendProtocolUserMessage("carol", "again", robot.Raw, nil); ret != robot.Ok || len(fs.created) != 1 {
+		t.Fatalf("second send = %v, created %d rooms", ret, len(fs.created))
+	}
+	if ret := mc.SendProtocolUserMessage("mallory", "hi", robot.Raw, nil); ret != robot.UserNotFound {
+		t.Fatalf("send to unmapped user = %v", ret)
+	}
+}
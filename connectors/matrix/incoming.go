@@ -0,0 +1,285 @@
+package matrix
+
+import (
+	"encoding/json"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+type messageContent struct {
+	MsgType       string     `json:"msgtype"`
+	Body          string     `json:"body"`
+	Format        string     `json:"format"`
+	FormattedBody string     `json:"formatted_body"`
+	RelatesTo     *relatesTo `json:"m.relates_to"`
+}
+
+type relatesTo struct {
+	RelType   string     `json:"rel_type"`
+	EventID   string     `json:"event_id"`
+	InReplyTo *inReplyTo `json:"m.in_reply_to"`
+}
+
+type inReplyTo struct {
+	EventID string `json:"event_id"`
+}
+
+type memberContent struct {
+	Membership  string `json:"membership"`
+	DisplayName string `json:"displayname"`
+	IsDirect    bool   `json:"is_direct"`
+}
+
+// pillRe matches the user mention links clients put in formatted bodies.
+var pillRe = regexp.MustCompile(`<a href="https://matrix\.to/#/([^"?]+)[^"]*">([^<]*)</a>`)
+
+// processSync applies one sync response. Timeline messages from the initial
+// sync are history, and aren't delivered to the engine.
+func (mc *matrixConnector) processSync(resp *syncResponse, initial bool) {
+	for _, ev := range resp.AccountData.Events {
+		if ev.Type == "m.direct" {
+			mc.setDirectRooms(ev.Content)
+		}
+	}
+	for roomID := range resp.Rooms.Leave {
+		mc.forgetRoom(roomID)
+	}
+	for roomID, room := range resp.Rooms.Join {
+		mc.ensureRoom(roomID)
+		for _, ev := range room.State.Events {
+			mc.applyStateEvent(roomID, ev)
+		}
+		for _, ev := range room.Timeline.Events {
+			if ev.StateKey != nil {
+				mc.applyStateEvent(roomID, ev)
+				continue
+			}
+			if initial {
+				continue
+			}
+			mc.handleTimelineEvent(roomID, ev)
+		}
+	}
+	for roomID, room := range resp.Rooms.Invite {
+		mc.handleInvite(roomID, room)
+	}
+}
+
+func (mc *matrixConnector) applyStateEvent(roomID string, ev event) {
+	stateKey := ""
+	if ev.StateKey != nil {
+		stateKey = *ev.StateKey
+	}
+	switch ev.Type {
+	case "m.room.name":
+		var content struct {
+			Name string `json:"name"`
+		}
+		if json.Unmarshal(ev.Content, &content) == nil {
+			mc.updateRoom(roomID, func(r *matrixRoomRecord) { r.Name = strings.TrimSpace(content.Name) })
+		}
+	case "m.room.canonical_alias":
+		var content struct {
+			Alias string `json:"alias"`
+		}
+		if json.Unmarshal(ev.Content, &content) == nil {
+			mc.updateRoom(roomID, func(r *matrixRoomRecord) { r.Alias = strings.TrimSpace(content.Alias) })
+		}
+	case "m.room.encryption":
+		mc.updateRoom(roomID, func(r *matrixRoomRecord) { r.Encrypted = true })
+	case "m.room.member":
+		var content memberContent
+		if json.Unmarshal(ev.Content, &content) != nil {
+			return
+		}
+		if stateKey == mc.selfID {
+			if content.Membership == "leave" || content.Membership == "ban" {
+				mc.forgetRoom(roomID)
+			}
+			return
+		}
+		if content.Membership == "join" {
+			mc.cacheUser(stateKey, content.DisplayName)
+		}
+	}
+}
+
+func (mc *matrixConnector) handleTimelineEvent(roomID string, ev event) {
+	switch ev.Type {
+	case "m.room.message":
+	case "m.room.encrypted":
+		mc.warnEncrypted(roomID)
+		return
+	default:
+		return
+	}
+	var content messageContent
+	if err := json.Unmarshal(ev.Content, &content); err != nil {
+		mc.Log(robot.Debug, "Ignoring Matrix message %s with invalid content: %v", ev.EventID, err)
+		return
+	}
+	msg, ok := mc.normalizeIncomingMessage(roomID, ev, &content)
+	if !ok {
+		return
+	}
+	mc.IncomingMessage(msg)
+}
+
+func (mc *matrixConnector) normalizeIncomingMessage(roomID string, ev event, content *messageContent) (*robot.ConnectorMessage, bool) {
+	if content.RelatesTo != nil && content.RelatesTo.RelType == "m.replace" {
+		mc.Log(robot.Debug, "Ignoring Matrix message edit %s in %s", ev.EventID, roomID)
+		return nil, false
+	}
+	room := mc.roomRecord(roomID)
+	direct := mc.isDirectRoom(roomID)
+	if ev.Sender == mc.selfID {
+		msg := &robot.ConnectorMessage{
+			Protocol:      "matrix",
+			UserID:        ev.Sender,
+			MessageID:     ev.EventID,
+			DirectMessage: direct,
+			MessageText:   content.Body,
+			SelfMessage:   true,
+			MessageObject: ev,
+			Client:        mc.api,
+		}
+		if !direct {
+			msg.ChannelID = roomID
+			msg.ChannelName = room.channelName()
+		}
+		return msg, true
+	}
+	switch content.MsgType {
+	case "m.text", "m.emote":
+	default:
+		// Clients aren't supposed to answer m.notice, which is how other bots
+		// avoid reply loops; media messages have no text to match.
+		return nil, false
+	}
+
+	threadID, threaded := "", false
+	if !direct {
+		threadID = ev.EventID
+		if content.RelatesTo != nil && content.RelatesTo.RelType == "m.thread" && content.RelatesTo.EventID != "" {
+			threadID, threaded = content.RelatesTo.EventID, true
+		}
+	}
+
+	canonicalUser := mc.canonicalName(ev.Sender)
+	msg := &robot.ConnectorMessage{
+		Protocol:        "matrix",
+		UserID:          ev.Sender,
+		UserName:        canonicalUser,
+		ValidatedUser:   canonicalUser != "",
+		MessageID:       ev.EventID,
+		ThreadID:        threadID,
+		ThreadedMessage: threaded,
+		DirectMessage:   direct,
+		MessageText:     mc.normalizeMessageText(content),
+		MessageObject:   ev,
+		Client:          mc.api,
+	}
+	if direct {
+		mc.noteDirectRoom(ev.Sender, roomID)
+	} else {
+		msg.ChannelID = roomID
+		msg.ChannelName = room.channelName()
+	}
+	return msg, true
+}
+
+// normalizeMessageText strips reply fallbacks and rewrites mentions of the
+// robot and mapped users to plain @username text.
+func (mc *matrixConnector) normalizeMessageText(content *messageContent) string {
+	text := content.Body
+	if content.RelatesTo != nil && content.RelatesTo.InReplyTo != nil {
+		text = stripReplyFallback(text)
+	}
+	if content.Format == "org.matrix.custom.html" {
+		for _, m := range pillRe.FindAllStringSubmatch(content.FormattedBody, -1) {
+			userID, err := url.PathUnescape(m[1])
+			if err != nil {
+				continue
+			}
+			name := mc.mentionName(userID)
+			label := html.UnescapeString(m[2])
+			if name == "" || label == "" {
+				continue
+			}
+			text = strings.Replace(text, label, "@"+name, 1)
+		}
+	}
+	for _, field := range strings.Fields(text) {
+		userID := strings.TrimRight(field, ":,.!?")
+		if !strings.HasPrefix(userID, "@") || !strings.Contains(userID, ":") {
+			continue
+		}
+		if name := mc.mentionName(userID); name != "" {
+			text = strings.Replace(text, userID, "@"+name, 1)
+		}
+	}
+	return text
+}
+
+// mentionName is the username a mention of userID is rewritten to, or "".
+func (mc *matrixConnector) mentionName(userID string) string {
+	if userID == mc.selfID {
+		return mc.botName
+	}
+	return mc.canonicalName(userID)
+}
+
+// stripReplyFallback removes the quoted "> <@user> ..." lines clients prepend
+// to replies.
+func stripReplyFallback(body string) string {
+	lines := strings.Split(body, "\n")
+	i := 0
+	for i < len(lines) && strings.HasPrefix(lines[i], ">") {
+		i++
+	}
+	if i == 0 {
+		return body
+	}
+	if i < len(lines) && lines[i] == "" {
+		i++
+	}
+	return strings.Join(lines[i:], "\n")
+}
+
+func (mc *matrixConnector) handleInvite(roomID string, room invitedRoom) {
+	inviter, direct := "", false
+	for _, ev := range room.InviteState.Events {
+		if ev.Type != "m.room.member" || ev.StateKey == nil || *ev.StateKey != mc.selfID {
+			continue
+		}
+		var content memberContent
+		if json.Unmarshal(ev.Content, &content) == nil && content.Membership == "invite" {
+			inviter, direct = ev.Sender, content.IsDirect
+		}
+	}
+	if inviter == "" || !mc.firstInvite(roomID) {
+		return
+	}
+	mc.mu.RLock()
+	policy := mc.acceptInvites
+	_, mapped := mc.configuredUsers[inviter]
+	mc.mu.RUnlock()
+	switch {
+	case policy == acceptInvitesAll, policy == acceptInvitesMapped && mapped:
+	default:
+		mc.Log(robot.Info, "Ignoring Matrix invite to %s from %s (AcceptInvites: %s)", roomID, inviter, policy)
+		return
+	}
+	if _, err := mc.join(roomID); err != nil {
+		mc.Log(robot.Error, "Matrix: failed to accept invite to %s from %s: %v", roomID, inviter, err)
+		return
+	}
+	mc.Log(robot.Info, "Matrix: accepted invite to %s from %s", roomID, inviter)
+	if direct {
+		mc.addDirectRoom(inviter, roomID)
+	}
+}
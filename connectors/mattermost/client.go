@@ -0,0 +1,250 @@
+package mattermost
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	apiPrefix        = "/api/v4"
+	maxErrorBodySize = 4096
+)
+
+// apiClient is a minimal client for the parts of the Mattermost REST API
+// the connector uses.
+type apiClient struct {
+	server string
+	token  string
+	http   *http.Client
+}
+
+// apiError is a Mattermost AppError response.
+type apiError struct {
+	Status     int           `json:"status_code"`
+	ID         string        `json:"id"`
+	Message    string        `json:"message"`
+	RetryAfter time.Duration `json:"-"`
+}
+
+func (e *apiError) Error() string {
+	if e.ID == "" {
+		return fmt.Sprintf("HTTP %d", e.Status)
+	}
+	return fmt.Sprintf("HTTP %d %s: %s", e.Status, e.ID, e.Message)
+}
+
+func newAPIClient(server, token string) *apiClient {
+	return &apiClient{
+		server: strings.TrimRight(server, "/"),
+		token:  token,
+		http:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (c *apiClient) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.server+apiPrefix+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &apiError{}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+		_ = json.Unmarshal(data, apiErr)
+		apiErr.Status = resp.StatusCode
+		if resp.StatusCode == http.StatusTooManyRequests {
+			if reset, err := strconv.Atoi(resp.Header.Get("X-Ratelimit-Reset")); err == nil {
+				apiErr.RetryAfter = time.Duration(reset) * time.Second
+			}
+		}
+		return apiErr
+	}
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// retryDelay reports how long to wait before retrying a failed request, or
+// false if it shouldn't be retried.
+func retryDelay(err error) (time.Duration, bool) {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		var netErr net.Error
+		return sendRetryDelay, errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+	}
+	switch {
+	case apiErr.Status == http.StatusTooManyRequests:
+		delay := apiErr.RetryAfter
+		if delay <= 0 {
+			delay = time.Second
+		}
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+		return delay, true
+	case apiErr.Status >= 500:
+		return sendRetryDelay, true
+	default:
+		return 0, false
+	}
+}
+
+func isNotFound(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound
+}
+
+type mmUser struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Nickname  string `json:"nickname"`
+	IsBot     bool   `json:"is_bot"`
+}
+
+type mmTeam struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type mmChannel struct {
+	ID          string `json:"id"`
+	TeamID      string `json:"team_id"`
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+}
+
+type mmPost struct {
+	ID        string `json:"id,omitempty"`
+	UserID    string `json:"user_id,omitempty"`
+	ChannelID string `json:"channel_id"`
+	RootID    string `json:"root_id,omitempty"`
+	Message   string `json:"message"`
+	Type      string `json:"type,omitempty"`
+	// PendingPostID lets the server drop a retried post that already landed.
+	PendingPostID string `json:"pending_post_id,omitempty"`
+}
+
+func (c *apiClient) getMe(ctx context.Context) (*mmUser, error) {
+	var user mmUser
+	if err := c.do(ctx, http.MethodGet, "/users/me", nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (c *apiClient) getUser(ctx context.Context, id string) (*mmUser, error) {
+	var user mmUser
+	if err := c.do(ctx, http.MethodGet, "/users/"+url.PathEscape(id), nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (c *apiClient) getUsersByIDs(ctx context.Context, ids []string) ([]mmUser, error) {
+	var users []mmUser
+	if err := c.do(ctx, http.MethodPost, "/users/ids", ids, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (c *apiClient) getTeamByName(ctx context.Context, name string) (*mmTeam, error) {
+	var team mmTeam
+	if err := c.do(ctx, http.MethodGet, "/teams/name/"+url.PathEscape(name), nil, &team); err != nil {
+		return nil, err
+	}
+	return &team, nil
+}
+
+func (c *apiClient) getMyTeams(ctx context.Context) ([]mmTeam, error) {
+	var teams []mmTeam
+	if err := c.do(ctx, http.MethodGet, "/users/me/teams", nil, &teams); err != nil {
+		return nil, err
+	}
+	return teams, nil
+}
+
+func (c *apiClient) getChannel(ctx context.Context, id string) (*mmChannel, error) {
+	var channel mmChannel
+	if err := c.do(ctx, http.MethodGet, "/channels/"+url.PathEscape(id), nil, &channel); err != nil {
+		return nil, err
+	}
+	return &channel, nil
+}
+
+func (c *apiClient) getChannelByName(ctx context.Context, teamID, name string) (*mmChannel, error) {
+	var channel mmChannel
+	path := "/teams/" + url.PathEscape(teamID) + "/channels/name/" + url.PathEscape(name)
+	if err := c.do(ctx, http.MethodGet, path, nil, &channel); err != nil {
+		return nil, err
+	}
+	return &channel, nil
+}
+
+func (c *apiClient) addChannelMember(ctx context.Context, channelID, userID string) error {
+	return c.do(ctx, http.MethodPost, "/channels/"+url.PathEscape(channelID)+"/members", map[string]string{"user_id": userID}, nil)
+}
+
+// directChannel returns the DM channel between two users, creating it if
+// needed.
+func (c *apiClient) directChannel(ctx context.Context, userID, otherID string) (*mmChannel, error) {
+	var channel mmChannel
+	if err := c.do(ctx, http.MethodPost, "/channels/direct", []string{userID, otherID}, &channel); err != nil {
+		return nil, err
+	}
+	return &channel, nil
+}
+
+func (c *apiClient) createPost(ctx context.Context, post *mmPost) error {
+	return c.do(ctx, http.MethodPost, "/posts", post, nil)
+}
+
+// createEphemeralPost posts a message only userID can see.
+func (c *apiClient) createEphemeralPost(ctx context.Context, userID string, post *mmPost) error {
+	req := map[string]interface{}{"user_id": userID, "post": post}
+	return c.do(ctx, http.MethodPost, "/posts/ephemeral", req, nil)
+}
+
+// websocketURL is the event stream endpoint for the server.
+func (c *apiClient) websocketURL() string {
+	u := c.server + apiPrefix + "/websocket"
+	switch {
+	case strings.HasPrefix(u, "https://"):
+		return "wss://" + strings.TrimPrefix(u, "https://")
+	case strings.HasPrefix(u, "http://"):
+		return "ws://" + strings.TrimPrefix(u, "http://")
+	}
+	return u
+}
@@ -0,0 +1,139 @@
+// Package mattermost implements the robot.Connector interface for
+// Mattermost, using the websocket event stream for incoming messages and the
+// REST API for everything else.
+package mattermost
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const (
+	loginTimeout            = 10 * time.Second
+	defaultSlashCommandPath = "/mattermost/command"
+)
+
+type config struct {
+	ServerURL          string // base URL of the server, e.g. https://mattermost.example.com
+	Token              string // bot account or personal access token
+	Team               string // team name for channel lookups; optional when the robot is on one team
+	ThreadResponses    bool   // reply in a thread to messages that didn't start one
+	SlashCommand       string // trigger word of the custom slash command, enables hidden commands
+	SlashCommandListen string // address for the slash command listener, e.g. ":8065"
+	SlashCommandPath   string // request path for the slash command, default /mattermost/command
+	SlashCommandToken  string // token Mattermost generated for the slash command
+	UserMap            map[string]string
+}
+
+// normalizeUserID returns a Mattermost user ID, or "" if in isn't one; IDs
+// are 26 lowercase letters and digits.
+func normalizeUserID(in string) string {
+	in = strings.TrimSpace(in)
+	if len(in) != 26 {
+		return ""
+	}
+	for i := 0; i < len(in); i++ {
+		ch := in[i]
+		if (ch < 'a' || ch > 'z') && (ch < '0' || ch > '9') {
+			return ""
+		}
+	}
+	return in
+}
+
+func normalizeConfiguredUserMap(in map[string]string, h robot.Handler) map[string]string {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(in))
+	for user, id := range in {
+		name := strings.TrimSpace(user)
+		uid := normalizeUserID(id)
+		if name == "" || uid == "" {
+			h.Log(robot.Warn, "Ignoring invalid Mattermost UserMap entry (empty username or invalid user ID): %q -> %q", user, id)
+			continue
+		}
+		if strings.ToLower(name) != name {
+			h.Log(robot.Warn, "Ignoring Mattermost UserMap entry with uppercase username: %q", user)
+			continue
+		}
+		out[name] = uid
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+func normalizeSlashCommand(in string) string {
+	in = strings.TrimSpace(in)
+	in = strings.TrimPrefix(in, "/")
+	return strings.ToLower(strings.TrimSpace(in))
+}
+
+// Initialize validates config, logs in to the server and returns the
+// connector.
+func Initialize(handler robot.Handler, l *log.Logger) robot.InitializedConnector {
+	var c config
+	if err := handler.GetProtocolConfig(&c); err != nil {
+		handler.Log(robot.Fatal, "Unable to retrieve mattermost protocol configuration: %v", err)
+	}
+	server := strings.TrimSpace(c.ServerURL)
+	if u, err := url.Parse(server); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		handler.Log(robot.Fatal, "Mattermost protocol config requires ServerURL as an http(s) URL, got %q", c.ServerURL)
+	}
+	token := strings.TrimSpace(c.Token)
+	if token == "" {
+		handler.Log(robot.Fatal, "Mattermost protocol config requires Token")
+	}
+	slash := newSlashConfig(c)
+	if slash.command != "" && (slash.listen == "" || slash.token == "") {
+		handler.Log(robot.Fatal, "Mattermost protocol config sets SlashCommand, but SlashCommandListen and SlashCommandToken are both required")
+	}
+
+	api := newAPIClient(server, token)
+	ctx, cancel := context.WithTimeout(context.Background(), loginTimeout)
+	defer cancel()
+	me, err := api.getMe(ctx)
+	if err != nil {
+		handler.Log(robot.Fatal, "Unable to log in to Mattermost at %s: %v", server, err)
+	}
+	team, err := resolveTeam(ctx, api, c.Team)
+	if err != nil {
+		handler.Log(robot.Warn, "Mattermost: %v; channels can only be addressed by ID", err)
+	}
+
+	connector := newMattermostConnector(handler, api, me, team, c)
+	handler.Log(robot.Info, "Mattermost connector logged in to %s as %s (%s)", server, me.Username, me.ID)
+	handler.SetBotID(me.ID)
+	return robot.InitializedConnector{
+		Connector:    connector,
+		Capabilities: robot.ConnectorCapabilities{HiddenCommands: slash.command != ""},
+	}
+}
+
+// resolveTeam finds the configured team, or the robot's only team when none
+// is configured.
+func resolveTeam(ctx context.Context, api *apiClient, name string) (*mmTeam, error) {
+	if name = strings.TrimSpace(name); name != "" {
+		team, err := api.getTeamByName(ctx, strings.ToLower(name))
+		if err != nil {
+			return nil, fmt.Errorf("looking up team %q: %w", name, err)
+		}
+		return team, nil
+	}
+	teams, err := api.getMyTeams(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing the robot's teams: %w", err)
+	}
+	if len(teams) != 1 {
+		return nil, fmt.Errorf("the robot is on %d teams and Team isn't configured", len(teams))
+	}
+	return &teams[0], nil
+}
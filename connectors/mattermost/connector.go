@@ -0,0 +1,496 @@
+package mattermost
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+	"github.com/lnxjedi/gopherbot/robot"
+	"github.com/lnxjedi/gopherbot/robot/util"
+)
+
+const (
+	sendTimeout     = 10 * time.Second
+	lookupTimeout   = 10 * time.Second
+	maxSendAttempts = 2
+	sendRetryDelay  = 250 * time.Millisecond
+	maxRetryDelay   = 5 * time.Second
+	// Mattermost's default MaxPostSize, counted in characters.
+	maxMessageSize = 16383
+)
+
+var pendingPostSeq atomic.Uint64
+
+type mattermostConnector struct {
+	robot.Handler
+
+	api        *apiClient
+	selfID     string
+	selfName   string // the robot's Mattermost username
+	botName    string // the robot's gopherbot name
+	teamID     string
+	slash      slashConfig
+	dialer     *websocket.Dialer
+	retrySleep func(time.Duration)
+
+	wsMu  sync.Mutex // serializes websocket writes
+	ws    *websocket.Conn
+	wsSeq int64
+
+	mu              sync.RWMutex
+	threadResponses bool
+	botUserMap      map[string]string // username -> Mattermost user ID
+	configuredUsers map[string]string // Mattermost user ID -> username
+	usersByID       map[string]mmUser
+	usersByName     map[string]string // Mattermost username -> user ID
+	channels        map[string]mmChannel
+	directByUser    map[string]string // user ID -> DM channel ID
+}
+
+func newMattermostConnector(handler robot.Handler, api *apiClient, me *mmUser, team *mmTeam, c config) *mattermostConnector {
+	botName := strings.TrimSpace(handler.GetBotInfo().UserName)
+	if botName == "" {
+		botName = "gopherbot"
+	}
+	mc := &mattermostConnector{
+		Handler:         handler,
+		api:             api,
+		selfID:          me.ID,
+		selfName:        strings.ToLower(me.Username),
+		botName:         botName,
+		slash:           newSlashConfig(c),
+		dialer:          websocket.DefaultDialer,
+		threadResponses: c.ThreadResponses,
+		botUserMap:      normalizeConfiguredUserMap(c.UserMap, handler),
+		usersByID:       make(map[string]mmUser),
+		usersByName:     make(map[string]string),
+		channels:        make(map[string]mmChannel),
+		directByUser:    make(map[string]string),
+	}
+	if team != nil {
+		mc.teamID = team.ID
+	}
+	mc.configuredUsers = configuredUsersByID(mc.botUserMap)
+	mc.cacheUser(*me)
+	return mc
+}
+
+func configuredUsersByID(userMap map[string]string) map[string]string {
+	configured := make(map[string]string, len(userMap))
+	for name, id := range userMap {
+		configured[id] = name
+	}
+	return configured
+}
+
+func (mc *mattermostConnector) Reload() error {
+	var c config
+	if err := mc.GetProtocolConfig(&c); err != nil {
+		return fmt.Errorf("retrieve Mattermost protocol configuration: %w", err)
+	}
+	newBotUserMap := normalizeConfiguredUserMap(c.UserMap, mc.Handler)
+	newConfiguredUsers := configuredUsersByID(newBotUserMap)
+
+	mc.mu.Lock()
+	mc.botUserMap = newBotUserMap
+	mc.configuredUsers = newConfiguredUsers
+	mc.threadResponses = c.ThreadResponses
+	mc.mu.Unlock()
+
+	mc.loadMappedUsers()
+	mc.Log(robot.Info, "Mattermost connector reloaded %d configured user mapping(s)", len(newBotUserMap))
+	return nil
+}
+
+// loadMappedUsers caches the Mattermost usernames of mapped users, which
+// mention rewriting needs in both directions.
+func (mc *mattermostConnector) loadMappedUsers() {
+	mc.mu.RLock()
+	ids := make([]string, 0, len(mc.configuredUsers))
+	for id := range mc.configuredUsers {
+		ids = append(ids, id)
+	}
+	mc.mu.RUnlock()
+	if len(ids) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	users, err := mc.api.getUsersByIDs(ctx, ids)
+	if err != nil {
+		mc.Log(robot.Warn, "Mattermost: unable to look up mapped users: %v", err)
+		return
+	}
+	for _, user := range users {
+		mc.cacheUser(user)
+	}
+}
+
+func (mc *mattermostConnector) cacheUser(user mmUser) {
+	if user.ID == "" {
+		return
+	}
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if old, ok := mc.usersByID[user.ID]; ok && old.Username != user.Username {
+		delete(mc.usersByName, strings.ToLower(old.Username))
+	}
+	mc.usersByID[user.ID] = user
+	if user.Username != "" {
+		mc.usersByName[strings.ToLower(user.Username)] = user.ID
+	}
+}
+
+// lookupUser returns a user from the cache, or from the server.
+func (mc *mattermostConnector) lookupUser(userID string) (mmUser, bool) {
+	mc.mu.RLock()
+	user, ok := mc.usersByID[userID]
+	mc.mu.RUnlock()
+	if ok {
+		return user, true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	found, err := mc.api.getUser(ctx, userID)
+	if err != nil {
+		mc.Log(robot.Debug, "Mattermost: unable to look up user %s: %v", userID, err)
+		return mmUser{}, false
+	}
+	mc.cacheUser(*found)
+	return *found, true
+}
+
+func (mc *mattermostConnector) canonicalName(userID string) string {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	return mc.configuredUsers[userID]
+}
+
+func (mc *mattermostConnector) cacheChannel(channel mmChannel) {
+	if channel.ID == "" {
+		return
+	}
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.channels[channel.ID] = channel
+}
+
+// lookupChannel returns a channel from the cache, or from the server.
+func (mc *mattermostConnector) lookupChannel(channelID string) (mmChannel, bool) {
+	mc.mu.RLock()
+	channel, ok := mc.channels[channelID]
+	mc.mu.RUnlock()
+	if ok {
+		return channel, true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	found, err := mc.api.getChannel(ctx, channelID)
+	if err != nil {
+		mc.Log(robot.Debug, "Mattermost: unable to look up channel %s: %v", channelID, err)
+		return mmChannel{}, false
+	}
+	mc.cacheChannel(*found)
+	return *found, true
+}
+
+func (mc *mattermostConnector) noteDirectChannel(userID, channelID string) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.directByUser[userID] = channelID
+}
+
+func (mc *mattermostConnector) GetProtocolUserAttribute(u, attr string) (string, robot.RetVal) {
+	userID, ok := mc.resolveUserID(u, u)
+	if !ok {
+		return "", robot.UserNotFound
+	}
+	user, ok := mc.lookupUser(userID)
+	if !ok {
+		return "", robot.UserNotFound
+	}
+	canonical := mc.canonicalName(userID)
+	var value string
+	switch strings.ToLower(strings.TrimSpace(attr)) {
+	case "name":
+		value = canonical
+		if value == "" {
+			value = user.Username
+		}
+	case "email":
+		value = user.Email
+	case "firstname":
+		value = user.FirstName
+	case "lastname":
+		value = user.LastName
+	case "fullname", "realname":
+		value = strings.TrimSpace(user.FirstName + " " + user.LastName)
+	case "internalid":
+		value = userID
+	}
+	if value == "" {
+		return "", robot.AttributeNotFound
+	}
+	return value, robot.Ok
+}
+
+// MessageHeard sends a typing notification to the channel.
+func (mc *mattermostConnector) MessageHeard(user, channel string) {
+	channelID, ok := util.ExtractID(channel)
+	if !ok || channelID == "" {
+		return
+	}
+	if err := mc.sendAction("user_typing", map[string]string{"channel_id": channelID}); err != nil {
+		mc.Log(robot.Debug, "Mattermost typing notification failed for %s: %v", channelID, err)
+	}
+}
+
+func (mc *mattermostConnector) DefaultHelp() []string { return nil }
+
+// JoinChannel adds the robot to a public channel on its team.
+func (mc *mattermostConnector) JoinChannel(c string) robot.RetVal {
+	channelID, ok := mc.resolveChannelID(c)
+	if !ok {
+		mc.Log(robot.Error, "Mattermost channel not found for: %s", c)
+		return robot.ChannelNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	if err := mc.api.addChannelMember(ctx, channelID, mc.selfID); err != nil {
+		mc.Log(robot.Error, "Mattermost: failed to join %s: %v", c, err)
+		return robot.FailedChannelJoin
+	}
+	mc.Log(robot.Info, "Mattermost: joined %s (%s)", c, channelID)
+	return robot.Ok
+}
+
+func (mc *mattermostConnector) SendProtocolChannelThreadMessage(channelname, threadid, msg string, format robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	channelID, ok := mc.resolveChannelID(channelname)
+	if !ok {
+		mc.Log(robot.Error, "Mattermost channel not found for: %s", channelname)
+		return robot.ChannelNotFound
+	}
+	if hiddenFor := hiddenReplyUser(channelID, "", msgObject); hiddenFor != "" {
+		return mc.sendEphemeral(channelID, hiddenFor, msg, format)
+	}
+	threadID := mc.resolveThreadForContext(channelID, "", threadid, msgObject)
+	return mc.sendPost(channelID, "", threadID, msg, format)
+}
+
+func (mc *mattermostConnector) SendProtocolUserChannelThreadMessage(userid, username, channelname, threadid, msg string, format robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	channelID, ok := mc.resolveChannelID(channelname)
+	if !ok {
+		mc.Log(robot.Error, "Mattermost channel not found for: %s", channelname)
+		return robot.ChannelNotFound
+	}
+	userID, ok := mc.resolveUserID(userid, username)
+	if !ok {
+		mc.Log(robot.Error, "Mattermost user not found for: %s", username)
+		return robot.UserNotFound
+	}
+	if hiddenFor := hiddenReplyUser(channelID, userID, msgObject); hiddenFor != "" {
+		return mc.sendEphemeral(channelID, hiddenFor, msg, format)
+	}
+	threadID := mc.resolveThreadForContext(channelID, userID, threadid, msgObject)
+	return mc.sendPost(channelID, userID, threadID, msg, format)
+}
+
+func (mc *mattermostConnector) SendProtocolUserMessage(user, msg string, format robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	userID, ok := mc.resolveUserID(user, user)
+	if !ok {
+		mc.Log(robot.Error, "Mattermost user not found for DM: %s", user)
+		return robot.UserNotFound
+	}
+	channelID, err := mc.directChannel(userID)
+	if err != nil {
+		mc.Log(robot.Error, "Mattermost: unable to open a direct channel with %s: %v", userID, err)
+		return robot.FailedMessageSend
+	}
+	return mc.sendPost(channelID, "", "", msg, format)
+}
+
+// directChannel returns the DM channel for a user; the server creates it on
+// first use.
+func (mc *mattermostConnector) directChannel(userID string) (string, error) {
+	mc.mu.RLock()
+	channelID, ok := mc.directByUser[userID]
+	mc.mu.RUnlock()
+	if ok {
+		return channelID, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	channel, err := mc.api.directChannel(ctx, mc.selfID, userID)
+	cancel()
+	if err != nil {
+		return "", err
+	}
+	mc.cacheChannel(*channel)
+	mc.noteDirectChannel(userID, channel.ID)
+	return channel.ID, nil
+}
+
+// resolveChannelID accepts a bracketed channel ID or a channel name on the
+// robot's team.
+func (mc *mattermostConnector) resolveChannelID(channel string) (string, bool) {
+	if id, ok := util.ExtractID(channel); ok {
+		id = strings.TrimSpace(id)
+		return id, id != ""
+	}
+	name := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(channel), "~"))
+	if name == "" {
+		return "", false
+	}
+	mc.mu.RLock()
+	for id, ch := range mc.channels {
+		if ch.Type != "D" && ch.Name == name && (mc.teamID == "" || ch.TeamID == mc.teamID) {
+			mc.mu.RUnlock()
+			return id, true
+		}
+	}
+	mc.mu.RUnlock()
+	if mc.teamID == "" {
+		return "", false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	found, err := mc.api.getChannelByName(ctx, mc.teamID, name)
+	if err != nil {
+		if !isNotFound(err) {
+			mc.Log(robot.Warn, "Mattermost: unable to look up channel %s: %v", name, err)
+		}
+		return "", false
+	}
+	mc.cacheChannel(*found)
+	return found.ID, true
+}
+
+// resolveUserID only treats bracketed IDs and well-formed Mattermost user
+// IDs as transport IDs; usernames are resolved through UserMap.
+func (mc *mattermostConnector) resolveUserID(uid, username string) (string, bool) {
+	if id, ok := util.ExtractID(uid); ok {
+		id = normalizeUserID(id)
+		return id, id != ""
+	}
+	if id := normalizeUserID(uid); id != "" {
+		return id, true
+	}
+	key := strings.ToLower(strings.TrimSpace(username))
+	if key == "" {
+		return "", false
+	}
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	id, ok := mc.botUserMap[key]
+	return id, ok
+}
+
+func (mc *mattermostConnector) resolveThreadForContext(channelID, userID, explicitThreadID string, msgObject *robot.ConnectorMessage) string {
+	threadID := strings.TrimSpace(explicitThreadID)
+	mc.mu.RLock()
+	threadResponses := mc.threadResponses
+	mc.mu.RUnlock()
+	if threadID != "" || !threadResponses || msgObject == nil {
+		return threadID
+	}
+	if msgObject.DirectMessage || msgObject.ChannelID != channelID {
+		return ""
+	}
+	if userID != "" && msgObject.UserID != userID {
+		return ""
+	}
+	return strings.TrimSpace(msgObject.ThreadID)
+}
+
+// hiddenReplyUser returns the user a reply should be shown to privately:
+// the invoking user of a slash command, as long as the reply is going back
+// to them in the same channel.
+func hiddenReplyUser(channelID, userID string, msgObject *robot.ConnectorMessage) string {
+	if msgObject == nil || !msgObject.HiddenMessage || msgObject.ChannelID != channelID {
+		return ""
+	}
+	if userID != "" && userID != msgObject.UserID {
+		return ""
+	}
+	return msgObject.UserID
+}
+
+func newPendingPostID(selfID string) string {
+	return fmt.Sprintf("%s:%d-%d", selfID, time.Now().UnixMilli(), pendingPostSeq.Add(1))
+}
+
+func (mc *mattermostConnector) sendPost(channelID, userID, threadID, msg string, format robot.MessageFormat) robot.RetVal {
+	message := mc.renderMessage(msg, format)
+	if strings.TrimSpace(message) == "" {
+		mc.Log(robot.Error, "Mattermost: refusing to send empty message")
+		return robot.Failed
+	}
+	if userID != "" {
+		if user, ok := mc.lookupUser(userID); ok && user.Username != "" {
+			message = "@" + user.Username + " " + message
+		}
+	}
+	if utf8.RuneCountInString(message) > maxMessageSize {
+		mc.Log(robot.Error, "Mattermost message exceeds maximum size (%d characters)", maxMessageSize)
+		return robot.FailedMessageSend
+	}
+	post := &mmPost{
+		ChannelID:     channelID,
+		RootID:        threadID,
+		Message:       message,
+		PendingPostID: newPendingPostID(mc.selfID),
+	}
+	return mc.retrySend(channelID, func(ctx context.Context) error {
+		return mc.api.createPost(ctx, post)
+	})
+}
+
+// sendEphemeral posts a message in channelID that only userID can see; the
+// robot's account needs permission to create ephemeral posts.
+func (mc *mattermostConnector) sendEphemeral(channelID, userID, msg string, format robot.MessageFormat) robot.RetVal {
+	message := mc.renderMessage(msg, format)
+	if strings.TrimSpace(message) == "" {
+		mc.Log(robot.Error, "Mattermost: refusing to send empty message")
+		return robot.Failed
+	}
+	if utf8.RuneCountInString(message) > maxMessageSize {
+		mc.Log(robot.Error, "Mattermost message exceeds maximum size (%d characters)", maxMessageSize)
+		return robot.FailedMessageSend
+	}
+	post := &mmPost{ChannelID: channelID, Message: message}
+	return mc.retrySend(channelID, func(ctx context.Context) error {
+		return mc.api.createEphemeralPost(ctx, userID, post)
+	})
+}
+
+func (mc *mattermostConnector) retrySend(channelID string, send func(context.Context) error) robot.RetVal {
+	var err error
+	for attempt := 1; attempt <= maxSendAttempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		err = send(ctx)
+		cancel()
+		if err == nil {
+			return robot.Ok
+		}
+		delay, retry := retryDelay(err)
+		if attempt == maxSendAttempts || !retry {
+			break
+		}
+		mc.Log(robot.Warn, "Mattermost send attempt %d/%d failed for %s; retrying: %v", attempt, maxSendAttempts, channelID, err)
+		mc.sleepForRetry(delay)
+	}
+	mc.Log(robot.Error, "Mattermost send failed to %s: %v", channelID, err)
+	return robot.FailedMessageSend
+}
+
+func (mc *mattermostConnector) sleepForRetry(delay time.Duration) {
+	if mc.retrySleep != nil {
+		mc.retrySleep(delay)
+		return
+	}
+	time.Sleep(delay)
+}
@@ -0,0 +1,294 @@
+package mattermost
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const (
+	botID   = "b0000000000000000000000000"
+	aliceID = "a0000000000000000000000000"
+	bobID   = "c0000000000000000000000000"
+	townID  = "t0000000000000000000000000"
+	dmID    = "d0000000000000000000000000"
+)
+
+type testHandler struct {
+	cfg      config
+	incoming chan *robot.ConnectorMessage
+}
+
+func (h *testHandler) IncomingMessage(msg *robot.ConnectorMessage) { h.incoming <- msg }
+func (h *testHandler) GetProtocolConfig(v interface{}) error {
+	*(v.(*config)) = h.cfg
+	return nil
+}
+func (h *testHandler) GetBrainConfig(interface{}) error                 { return nil }
+func (h *testHandler) GetEventStrings() *[]string                       { return nil }
+func (h *testHandler) GetHistoryConfig(interface{}) error               { return nil }
+func (h *testHandler) GetBotInfo() robot.BotInfo                        { return robot.BotInfo{UserName: "bishop"} }
+func (h *testHandler) SetBotID(string)                                  {}
+func (h *testHandler) SetTerminalWriter(io.Writer)                      {}
+func (h *testHandler) SetBotMention(string)                             {}
+func (h *testHandler) GetLogLevel() robot.LogLevel                      { return robot.Debug }
+func (h *testHandler) GetInstallPath() string                           { return "" }
+func (h *testHandler) GetConfigPath() string                            { return "" }
+func (h *testHandler) ReadEncryptedFile(string) ([]byte, error)         { return nil, nil }
+func (h *testHandler) Log(_ robot.LogLevel, m string, v ...interface{}) {}
+func (h *testHandler) GetDirectory(string) error                        { return nil }
+
+// fakeServer serves canned websocket events and records what the connector
+// posts.
+type fakeServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	events    []string
+	posts     []map[string]interface{}
+	ephemeral []map[string]interface{}
+	direct    [][]string
+}
+
+func newFakeServer(t *testing.T, events ...string) *fakeServer {
+	fs := &fakeServer{events: events}
+	upgrader := websocket.Upgrader{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/websocket", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrading websocket: %v", err)
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"event":"hello","data":{},"seq":0}`))
+		for _, ev := range fs.events {
+			conn.WriteMessage(websocket.TextMessage, []byte(ev))
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	mux.HandleFunc("POST /api/v4/users/ids", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `[{"id":"`+aliceID+`","username":"alice.smith","first_name":"Alice","last_name":"Smith"}]`)
+	})
+	mux.HandleFunc("GET /api/v4/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		switch r.PathValue("id") {
+		case aliceID:
+			io.WriteString(w, `{"id":"`+aliceID+`","username":"alice.smith","email":"alice@example.com","first_name":"Alice","last_name":"Smith"}`)
+		case bobID:
+			io.WriteString(w, `{"id":"`+bobID+`","username":"bob"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"id":"app.user.missing_account.const","message":"not found","status_code":404}`)
+		}
+	})
+	mux.HandleFunc("GET /api/v4/channels/{id}", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"id":"`+r.PathValue("id")+`","type":"O","name":"town-square"}`)
+	})
+	mux.HandleFunc("POST /api/v4/posts", func(w http.ResponseWriter, r *http.Request) {
+		var post map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
+			t.Errorf("decoding post: %v", err)
+		}
+		fs.mu.Lock()
+		fs.posts = append(fs.posts, post)
+		fs.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, `{"id":"newpost"}`)
+	})
+	mux.HandleFunc("POST /api/v4/posts/ephemeral", func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&req)
+		fs.mu.Lock()
+		fs.ephemeral = append(fs.ephemeral, req)
+		fs.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, `{}`)
+	})
+	mux.HandleFunc("POST /api/v4/channels/direct", func(w http.ResponseWriter, r *http.Request) {
+		var ids []string
+		json.NewDecoder(r.Body).Decode(&ids)
+		fs.mu.Lock()
+		fs.direct = append(fs.direct, ids)
+		fs.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, `{"id":"`+dmID+`","type":"D","name":"`+ids[0]+`__`+ids[1]+`"}`)
+	})
+	fs.Server = httptest.NewServer(mux)
+	t.Cleanup(fs.Close)
+	return fs
+}
+
+func newTestConnector(t *testing.T, fs *fakeServer, c config) (*mattermostConnector, *testHandler) {
+	c.UserMap = map[string]string{"alice": aliceID}
+	h := &testHandler{cfg: c, incoming: make(chan *robot.ConnectorMessage, 10)}
+	mc := newMattermostConnector(h, newAPIClient(fs.URL, "secret"), &mmUser{ID: botID, Username: "bishop-bot"}, &mmTeam{ID: "team1"}, c)
+	mc.retrySleep = func(time.Duration) {}
+	return mc, h
+}
+
+func postedEvent(channelType, channelName string, post mmPost) string {
+	raw, _ := json.Marshal(post)
+	data, _ := json.Marshal(postedData{ChannelType: channelType, ChannelName: channelName, TeamID: "team1", Post: string(raw)})
+	return `{"event":"posted","data":` + string(data) + `,"seq":1}`
+}
+
+func TestStreamNormalizesPosts(t *testing.T) {
+	fs := newFakeServer(t,
+		postedEvent("O", "town-square", mmPost{ID: "p1", UserID: aliceID, ChannelID: townID, Message: "@bishop-bot ping"}),
+		postedEvent("O", "town-square", mmPost{ID: "p2", UserID: aliceID, ChannelID: townID, Type: "system_join_channel", Message: "joined"}),
+		postedEvent("O", "town-square", mmPost{ID: "p3", UserID: bobID, ChannelID: townID, RootID: "p1", Message: "hi @alice.smith, mail bob@alice.smith"}),
+		postedEvent("D", botID+"__"+aliceID, mmPost{ID: "p4", UserID: aliceID, ChannelID: dmID, Message: "help"}),
+		postedEvent("O", "town-square", mmPost{ID: "p5", UserID: botID, ChannelID: townID, Message: "pong"}),
+	)
+	mc, h := newTestConnector(t, fs, config{})
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		mc.Run(stop)
+		close(done)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	var msgs []*robot.ConnectorMessage
+	for len(msgs) < 4 {
+		select {
+		case msg := <-h.incoming:
+			msgs = append(msgs, msg)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out with %d messages", len(msgs))
+		}
+	}
+
+	channel, threaded, direct, self := msgs[0], msgs[1], msgs[2], msgs[3]
+	if channel.MessageText != "@bishop ping" || channel.UserName != "alice" || !channel.ValidatedUser ||
+		channel.ChannelID != townID || channel.ChannelName != "town-square" || channel.ThreadID != "p1" || channel.ThreadedMessage {
+		t.Errorf("channel message = %+v", channel)
+	}
+	if threaded.MessageText != "hi @alice, mail bob@alice.smith" || threaded.ValidatedUser || threaded.ThreadID != "p1" || !threaded.ThreadedMessage {
+		t.Errorf("thread reply = %+v", threaded)
+	}
+	if !direct.DirectMessage || direct.ChannelID != "" || direct.ThreadID != "" {
+		t.Errorf("direct message = %+v", direct)
+	}
+	if !self.SelfMessage || self.MessageText != "pong" {
+		t.Errorf("self message = %+v", self)
+	}
+	if id, _ := mc.directChannel(aliceID); id != dmID {
+		t.Errorf("DM channel for alice = %q, want %q", id, dmID)
+	}
+}
+
+func TestSendDirectedThreadMessage(t *testing.T) {
+	fs := newFakeServer(t)
+	mc, _ := newTestConnector(t, fs, config{ThreadResponses: true})
+	mc.loadMappedUsers()
+	incoming := &robot.ConnectorMessage{UserID: aliceID, ChannelID: townID, ThreadID: "p1"}
+	ret := mc.SendProtocolUserChannelThreadMessage(aliceID, "alice", "<"+townID+">", "", "**done**, @alice", robot.BasicMarkdown, incoming)
+	if ret != robot.Ok {
+		t.Fatalf("send returned %v", ret)
+	}
+	if len(fs.posts) != 1 {
+		t.Fatalf("got %d posts", len(fs.posts))
+	}
+	post := fs.posts[0]
+	if post["channel_id"] != townID || post["root_id"] != "p1" || post["message"] != "@alice.smith **done**, @alice.smith" {
+		t.Errorf("post = %v", post)
+	}
+	if id, _ := post["pending_post_id"].(string); !strings.HasPrefix(id, botID+":") {
+		t.Errorf("pending_post_id = %q", id)
+	}
+}
+
+func TestSendUserMessageOpensDirectChannel(t *testing.T) {
+	fs := newFakeServer(t)
+	mc, _ := newTestConnector(t, fs, config{})
+	for i := 0; i < 2; i++ {
+		if ret := mc.SendProtocolUserMessage("alice", "hello", robot.Raw, nil); ret != robot.Ok {
+			t.Fatalf("send returned %v", ret)
+		}
+	}
+	if len(fs.direct) != 1 || fs.direct[0][0] != botID || fs.direct[0][1] != aliceID {
+		t.Errorf("direct channel requests = %v", fs.direct)
+	}
+	if len(fs.posts) != 2 || fs.posts[1]["channel_id"] != dmID {
+		t.Errorf("posts = %v", fs.posts)
+	}
+}
+
+func TestSlashCommandRepliesAreEphemeral(t *testing.T) {
+	fs := newFakeServer(t)
+	mc, h := newTestConnector(t, fs, config{SlashCommand: "/Bishop", SlashCommandToken: "slashtoken"})
+
+	form := url.Values{"token": {"wrong"}, "command": {"/bishop"}, "text": {"ping"}, "user_id": {aliceID}, "channel_id": {townID}}
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/mattermost/command", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		mc.serveSlashCommand(rec, req)
+		return rec
+	}
+	if rec := post(); rec.Code != http.StatusForbidden {
+		t.Fatalf("bad token status = %d", rec.Code)
+	}
+
+	form.Set("token", "slashtoken")
+	rec := post()
+	if rec.Code != http.StatusOK || rec.Body.String() != "{}" {
+		t.Fatalf("response = %d %q", rec.Code, rec.Body.String())
+	}
+	msg := <-h.incoming
+	if !msg.HiddenMessage || !msg.BotMessage || msg.MessageText != "ping" || msg.ChannelID != townID || msg.UserName != "alice" {
+		t.Fatalf("slash message = %+v", msg)
+	}
+
+	if ret := mc.SendProtocolUserChannelThreadMessage(aliceID, "alice", "<"+townID+">", "", "pong", robot.Raw, msg); ret != robot.Ok {
+		t.Fatalf("send returned %v", ret)
+	}
+	if len(fs.ephemeral) != 1 || len(fs.posts) != 0 {
+		t.Fatalf("ephemeral = %v, posts = %v", fs.ephemeral, fs.posts)
+	}
+	sent := fs.ephemeral[0]["post"].(map[string]interface{})
+	if fs.ephemeral[0]["user_id"] != aliceID || sent["channel_id"] != townID || sent["message"] != "pong" {
+		t.Errorf("ephemeral post = %v", fs.ephemeral[0])
+	}
+	if got := mc.FormatHiddenCommand("help"); got != "/bishop help" {
+		t.Errorf("FormatHiddenCommand = %q", got)
+	}
+}
+
+func TestGetProtocolUserAttribute(t *testing.T) {
+	fs := newFakeServer(t)
+	mc, _ := newTestConnector(t, fs, config{})
+	for attr, want := range map[string]string{
+		"name":       "alice",
+		"email":      "alice@example.com",
+		"fullname":   "Alice Smith",
+		"internalid": aliceID,
+	} {
+		if got, ret := mc.GetProtocolUserAttribute("alice", attr); ret != robot.Ok || got != want {
+			t.Errorf("attribute %s = %q, %v; want %q", attr, got, ret, want)
+		}
+	}
+	if _, ret := mc.GetProtocolUserAttribute("carol", "name"); ret != robot.UserNotFound {
+		t.Errorf("unmapped user = %v, want UserNotFound", ret)
+	}
+}
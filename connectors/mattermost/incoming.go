@@ -0,0 +1,171 @@
+package mattermost
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+type postedData struct {
+	ChannelType        string `json:"channel_type"`
+	ChannelName        string `json:"channel_name"`
+	ChannelDisplayName string `json:"channel_display_name"`
+	TeamID             string `json:"team_id"`
+	Post               string `json:"post"` // the post, itself JSON-encoded
+}
+
+func (mc *mattermostConnector) handleEvent(ev *wsEvent) {
+	switch ev.Event {
+	case "":
+		if ev.Status != "" && ev.Status != "OK" {
+			mc.Log(robot.Debug, "Mattermost websocket action %d failed: %s", ev.SeqReply, ev.Status)
+		}
+	case "hello":
+		mc.Log(robot.Info, "Mattermost event stream connected")
+	case "posted":
+		var data postedData
+		if err := json.Unmarshal(ev.Data, &data); err != nil {
+			mc.Log(robot.Debug, "Ignoring Mattermost posted event with invalid data: %v", err)
+			return
+		}
+		var post mmPost
+		if err := json.Unmarshal([]byte(data.Post), &post); err != nil {
+			mc.Log(robot.Debug, "Ignoring Mattermost posted event with invalid post: %v", err)
+			return
+		}
+		msg, ok := mc.normalizeIncomingPost(&data, &post)
+		if !ok {
+			return
+		}
+		mc.IncomingMessage(msg)
+	case "user_updated":
+		var data struct {
+			User mmUser `json:"user"`
+		}
+		if json.Unmarshal(ev.Data, &data) == nil {
+			mc.cacheUser(data.User)
+		}
+	}
+}
+
+func (mc *mattermostConnector) normalizeIncomingPost(data *postedData, post *mmPost) (*robot.ConnectorMessage, bool) {
+	if post.Type != "" {
+		// join/leave, header changes and other system messages
+		return nil, false
+	}
+	direct := data.ChannelType == "D"
+	mc.cacheChannel(mmChannel{
+		ID:          post.ChannelID,
+		TeamID:      data.TeamID,
+		Type:        data.ChannelType,
+		Name:        data.ChannelName,
+		DisplayName: data.ChannelDisplayName,
+	})
+	if post.UserID == mc.selfID {
+		msg := &robot.ConnectorMessage{
+			Protocol:      "mattermost",
+			UserID:        post.UserID,
+			MessageID:     post.ID,
+			DirectMessage: direct,
+			MessageText:   post.Message,
+			SelfMessage:   true,
+			MessageObject: post,
+			Client:        mc.api,
+		}
+		if !direct {
+			msg.ChannelID = post.ChannelID
+			msg.ChannelName = data.ChannelName
+		}
+		return msg, true
+	}
+
+	threadID, threaded := "", false
+	if !direct {
+		threadID = post.ID
+		if post.RootID != "" {
+			threadID, threaded = post.RootID, true
+		}
+	}
+
+	canonicalUser := mc.canonicalName(post.UserID)
+	msg := &robot.ConnectorMessage{
+		Protocol:        "mattermost",
+		UserID:          post.UserID,
+		UserName:        canonicalUser,
+		ValidatedUser:   canonicalUser != "",
+		MessageID:       post.ID,
+		ThreadID:        threadID,
+		ThreadedMessage: threaded,
+		DirectMessage:   direct,
+		MessageText:     mc.normalizeMessageText(post.Message),
+		MessageObject:   post,
+		Client:          mc.api,
+	}
+	if direct {
+		mc.noteDirectChannel(post.UserID, post.ChannelID)
+	} else {
+		msg.ChannelID = post.ChannelID
+		msg.ChannelName = data.ChannelName
+	}
+	return msg, true
+}
+
+// normalizeMessageText rewrites @mentions of the robot and mapped users from
+// their Mattermost usernames to plain @username text.
+func (mc *mattermostConnector) normalizeMessageText(text string) string {
+	var out strings.Builder
+	for i := 0; i < len(text); {
+		if text[i] != '@' || isEmailMention(text, i) {
+			out.WriteByte(text[i])
+			i++
+			continue
+		}
+		end := findMentionEnd(text, i+1)
+		name := mc.mentionName(strings.ToLower(text[i+1 : end]))
+		if end == i+1 || name == "" {
+			out.WriteByte(text[i])
+			i++
+			continue
+		}
+		out.WriteString("@" + name)
+		i = end
+	}
+	return out.String()
+}
+
+// mentionName is the username a mention of mmName is rewritten to, or "".
+func (mc *mattermostConnector) mentionName(mmName string) string {
+	if mmName == mc.selfName {
+		return mc.botName
+	}
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	return mc.configuredUsers[mc.usersByName[mmName]]
+}
+
+func isEmailMention(msg string, at int) bool {
+	if at <= 0 {
+		return false
+	}
+	prev := msg[at-1]
+	return (prev >= 'A' && prev <= 'Z') || (prev >= 'a' && prev <= 'z') || (prev >= '0' && prev <= '9') || prev == '.' || prev == '_' || prev == '-'
+}
+
+// findMentionEnd returns the end of the username starting at start; a
+// trailing '.' is punctuation, not part of the name.
+func findMentionEnd(msg string, start int) int {
+	i := start
+	for i < len(msg) {
+		ch := msg[i]
+		if (ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z') || (ch >= '0' && ch <= '9') || ch == '_' || ch == '-' || ch == '.' {
+			i++
+			continue
+		}
+		break
+	}
+	for i > start && msg[i-1] == '.' {
+		i--
+	}
+	return i
+}
@@ -0,0 +1,110 @@
+package mattermost
+
+import (
+	"strings"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+// renderMessage converts an outgoing message to Mattermost markdown.
+func (mc *mattermostConnector) renderMessage(msg string, format robot.MessageFormat) string {
+	switch format {
+	case robot.BasicMarkdown:
+		// BasicMarkdown is a subset of Mattermost's own markdown; only
+		// mentions need translating.
+		return mc.renderBasicMarkdown(msg)
+	case robot.Fixed:
+		if strings.TrimSpace(msg) == "" {
+			return ""
+		}
+		fence := "```"
+		if strings.Contains(msg, fence) {
+			fence = "~~~"
+		}
+		return fence + "\n" + strings.TrimSuffix(msg, "\n") + "\n" + fence
+	case robot.Variable:
+		return escapeMarkdown(msg)
+	default:
+		return msg
+	}
+}
+
+// renderBasicMarkdown rewrites @username mentions of mapped users to their
+// Mattermost usernames, leaving code untouched.
+func (mc *mattermostConnector) renderBasicMarkdown(msg string) string {
+	var out strings.Builder
+	for i, part := range strings.Split(msg, "```") {
+		if i > 0 {
+			out.WriteString("```")
+		}
+		if i%2 == 1 {
+			out.WriteString(part)
+			continue
+		}
+		for j, span := range strings.Split(part, "`") {
+			if j > 0 {
+				out.WriteString("`")
+			}
+			if j%2 == 1 {
+				out.WriteString(span)
+				continue
+			}
+			out.WriteString(mc.replaceMentions(span))
+		}
+	}
+	return out.String()
+}
+
+func (mc *mattermostConnector) replaceMentions(msg string) string {
+	var out strings.Builder
+	for i := 0; i < len(msg); {
+		if msg[i] != '@' || isEmailMention(msg, i) || (i > 0 && msg[i-1] == '\\') {
+			out.WriteByte(msg[i])
+			i++
+			continue
+		}
+		end := findMentionEnd(msg, i+1)
+		mmName := mc.mattermostUsername(strings.ToLower(msg[i+1 : end]))
+		if end == i+1 || mmName == "" {
+			out.WriteByte(msg[i])
+			i++
+			continue
+		}
+		out.WriteString("@" + mmName)
+		i = end
+	}
+	return out.String()
+}
+
+// mattermostUsername returns the Mattermost username of a mapped user or the
+// robot, or "" if it isn't known.
+func (mc *mattermostConnector) mattermostUsername(name string) string {
+	if name == mc.botName {
+		return mc.selfName
+	}
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	id, ok := mc.botUserMap[name]
+	if !ok {
+		return ""
+	}
+	return mc.usersByID[id].Username
+}
+
+// escapeMarkdown keeps Variable text from being rendered as markdown.
+func escapeMarkdown(msg string) string {
+	var out strings.Builder
+	lineStart := true
+	for i := 0; i < len(msg); i++ {
+		ch := msg[i]
+		switch {
+		case strings.IndexByte("\\`*_~[]|", ch) >= 0:
+			out.WriteByte('\\')
+		case lineStart && (ch == '#' || ch == '>' || ch == '-' || ch == '+'):
+			out.WriteByte('\\')
+		}
+		out.WriteByte(ch)
+		lineStart = ch == '\n'
+	}
+	return out.String()
+}
@@ -0,0 +1,33 @@
+package mattermost
+
+import (
+	"testing"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+func TestRenderMessage(t *testing.T) {
+	mc := &mattermostConnector{
+		botName:    "bishop",
+		selfName:   "bishop-bot",
+		botUserMap: map[string]string{"alice": aliceID},
+		usersByID:  map[string]mmUser{aliceID: {ID: aliceID, Username: "alice.smith"}},
+	}
+	for _, tc := range []struct {
+		in     string
+		format robot.MessageFormat
+		want   string
+	}{
+		{"ask @alice or @bishop, not @carol", robot.BasicMarkdown, "ask @alice.smith or @bishop-bot, not @carol"},
+		{"run `@alice` and\n```\n@alice\n```", robot.BasicMarkdown, "run `@alice` and\n```\n@alice\n```"},
+		{"mail bob@alice", robot.BasicMarkdown, "mail bob@alice"},
+		{"a  b\n", robot.Fixed, "```\na  b\n```"},
+		{"has ``` fence", robot.Fixed, "~~~\nhas ``` fence\n~~~"},
+		{"# not *a* heading_", robot.Variable, `\# not \*a\* heading\_`},
+		{"**raw**", robot.Raw, "**raw**"},
+	} {
+		if got := mc.renderMessage(tc.in, tc.format); got != tc.want {
+			t.Errorf("renderMessage(%q, %v)\n got %q\nwant %q", tc.in, tc.format, got, tc.want)
+		}
+	}
+}
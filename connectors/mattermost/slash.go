@@ -0,0 +1,173 @@
+package mattermost
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const (
+	maxSlashBodySize       = 64 << 10
+	slashShutdownTimeout   = 5 * time.Second
+	slashReadTimeout       = 30 * time.Second
+	slashReadHeaderTimeout = 10 * time.Second
+)
+
+// slashConfig is the custom slash command the robot answers; hidden
+// commands arrive as slash commands, and replies go out as ephemeral posts.
+type slashConfig struct {
+	command string
+	listen  string
+	path    string
+	token   string
+}
+
+func newSlashConfig(c config) slashConfig {
+	sc := slashConfig{
+		command: normalizeSlashCommand(c.SlashCommand),
+		listen:  strings.TrimSpace(c.SlashCommandListen),
+		path:    strings.TrimSpace(c.SlashCommandPath),
+		token:   strings.TrimSpace(c.SlashCommandToken),
+	}
+	if sc.path == "" {
+		sc.path = defaultSlashCommandPath
+	}
+	if !strings.HasPrefix(sc.path, "/") {
+		sc.path = "/" + sc.path
+	}
+	return sc
+}
+
+// slashCommand is the MessageObject of a hidden command.
+type slashCommand struct {
+	Command   string
+	Text      string
+	UserID    string
+	ChannelID string
+}
+
+func formatHiddenCommand(command, input string) string {
+	name := normalizeSlashCommand(command)
+	if name == "" {
+		return ""
+	}
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return "/" + name
+	}
+	return "/" + name + " " + input
+}
+
+func (mc *mattermostConnector) FormatHiddenCommand(input string) string {
+	return formatHiddenCommand(mc.slash.command, input)
+}
+
+// startSlashListener starts the HTTP listener Mattermost posts slash
+// commands to, returning a function that stops it.
+func (mc *mattermostConnector) startSlashListener() (func(), error) {
+	listener, err := net.Listen("tcp", mc.slash.listen)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(mc.slash.path, mc.serveSlashCommand)
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: slashReadHeaderTimeout,
+		ReadTimeout:       slashReadTimeout,
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			mc.Log(robot.Error, "Mattermost slash command listener on '%s' stopped: %v", mc.slash.listen, err)
+		}
+	}()
+	mc.Log(robot.Info, "Mattermost slash command /%s listening on http://%s%s", mc.slash.command, listener.Addr(), mc.slash.path)
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), slashShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			mc.Log(robot.Warn, "Stopping Mattermost slash command listener: %v", err)
+		}
+	}, nil
+}
+
+// serveSlashCommand handles a custom slash command request. The reply is an
+// empty response; the robot answers with ephemeral posts.
+func (mc *mattermostConnector) serveSlashCommand(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxSlashBodySize)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	token := r.PostForm.Get("token")
+	if token == "" {
+		token = strings.TrimPrefix(r.Header.Get("Authorization"), "Token ")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(mc.slash.token)) != 1 {
+		mc.Log(robot.Warn, "Mattermost slash command request from %s with invalid token", r.RemoteAddr)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	msg, ok := mc.slashMessage(&slashCommand{
+		Command:   r.PostForm.Get("command"),
+		Text:      r.PostForm.Get("text"),
+		UserID:    r.PostForm.Get("user_id"),
+		ChannelID: r.PostForm.Get("channel_id"),
+	})
+	if !ok {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, "{}")
+	mc.IncomingMessage(msg)
+}
+
+func (mc *mattermostConnector) slashMessage(cmd *slashCommand) (*robot.ConnectorMessage, bool) {
+	if normalizeSlashCommand(cmd.Command) != mc.slash.command {
+		mc.Log(robot.Warn, "Mattermost: ignoring unexpected slash command %q", cmd.Command)
+		return nil, false
+	}
+	userID := normalizeUserID(cmd.UserID)
+	channelID := strings.TrimSpace(cmd.ChannelID)
+	if userID == "" || channelID == "" {
+		mc.Log(robot.Warn, "Mattermost: ignoring slash command without a user or channel")
+		return nil, false
+	}
+	channel, _ := mc.lookupChannel(channelID)
+	direct := channel.Type == "D"
+	canonicalUser := mc.canonicalName(userID)
+	msg := &robot.ConnectorMessage{
+		Protocol: "mattermost",
+		UserID:   userID,
+		UserName: canonicalUser,
+		// ThreadID is empty; slash commands aren't posts, so there's nothing
+		// to thread from
+		ValidatedUser: canonicalUser != "",
+		DirectMessage: direct,
+		BotMessage:    true,
+		HiddenMessage: true,
+		MessageText:   mc.normalizeMessageText(cmd.Text),
+		MessageObject: cmd,
+		Client:        mc.api,
+	}
+	if direct {
+		mc.noteDirectChannel(userID, channelID)
+	} else {
+		msg.ChannelID = channelID
+		msg.ChannelName = channel.Name
+	}
+	return msg, true
+}
@@ -0,0 +1,7 @@
+package mattermost
+
+import "github.com/lnxjedi/gopherbot/robot"
+
+func init() {
+	robot.RegisterConnector("mattermost", Initialize)
+}
@@ -0,0 +1,146 @@
+package mattermost
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const (
+	pingInterval     = 30 * time.Second
+	pongTimeout      = 2 * pingInterval
+	writeTimeout     = 10 * time.Second
+	minStreamBackoff = time.Second
+	maxStreamBackoff = time.Minute
+)
+
+var errNotConnected = errors.New("websocket not connected")
+
+// wsEvent is a server event, or the reply to an action the connector sent.
+type wsEvent struct {
+	Event    string          `json:"event"`
+	Data     json.RawMessage `json:"data"`
+	Seq      int64           `json:"seq"`
+	Status   string          `json:"status"`
+	SeqReply int64           `json:"seq_reply"`
+}
+
+// Run connects to the event stream and reads it until stop is closed,
+// reconnecting with backoff when the connection drops.
+func (mc *mattermostConnector) Run(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	if mc.slash.command != "" {
+		shutdown, err := mc.startSlashListener()
+		if err != nil {
+			mc.Log(robot.Error, "Mattermost slash command listener unable to listen on '%s': %v", mc.slash.listen, err)
+		} else {
+			defer shutdown()
+		}
+	}
+	mc.loadMappedUsers()
+
+	backoff := minStreamBackoff
+	for ctx.Err() == nil {
+		connected, err := mc.stream(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			backoff = minStreamBackoff
+		}
+		mc.Log(robot.Error, "Mattermost event stream failed, reconnecting in %s: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxStreamBackoff {
+			backoff = maxStreamBackoff
+		}
+	}
+}
+
+// stream reads events from one websocket connection until it fails or ctx
+// is cancelled; connected reports whether the dial succeeded.
+func (mc *mattermostConnector) stream(ctx context.Context) (connected bool, err error) {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+mc.api.token)
+	conn, _, err := mc.dialer.DialContext(ctx, mc.api.websocketURL(), header)
+	if err != nil {
+		return false, err
+	}
+	mc.setConn(conn)
+	defer mc.setConn(nil)
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go mc.keepalive(ctx, conn, done)
+
+	conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	})
+	for {
+		var ev wsEvent
+		if err := conn.ReadJSON(&ev); err != nil {
+			return true, err
+		}
+		conn.SetReadDeadline(time.Now().Add(pongTimeout))
+		mc.handleEvent(&ev)
+	}
+}
+
+// keepalive pings the server so a dead connection fails the read deadline,
+// and closes the connection when ctx is cancelled.
+func (mc *mattermostConnector) keepalive(ctx context.Context, conn *websocket.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			conn.Close()
+			return
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+				mc.Log(robot.Debug, "Mattermost websocket ping failed: %v", err)
+			}
+		}
+	}
+}
+
+func (mc *mattermostConnector) setConn(conn *websocket.Conn) {
+	mc.wsMu.Lock()
+	defer mc.wsMu.Unlock()
+	mc.ws = conn
+}
+
+// sendAction sends a websocket action such as user_typing.
+func (mc *mattermostConnector) sendAction(action string, data interface{}) error {
+	mc.wsMu.Lock()
+	defer mc.wsMu.Unlock()
+	if mc.ws == nil {
+		return errNotConnected
+	}
+	mc.wsSeq++
+	mc.ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return mc.ws.WriteJSON(map[string]interface{}{
+		"action": action,
+		"seq":    mc.wsSeq,
+		"data":   data,
+	})
+}
@@ -0,0 +1,414 @@
+package teams
+
+import (
+	"html"
+	"strconv"
+	"strings"
+
+	"github.com/lnxjedi/gopherbot/robot"
+	"github.com/lnxjedi/gopherbot/robot/util"
+)
+
+// mentionList collects the mentions in an outgoing message; Teams needs
+// an entry for each <at> tag in the body.
+type mentionList struct {
+	tc       *teamsConnector
+	mentions []mention
+}
+
+// add records a mention of userID and returns its <at> tag.
+func (ml *mentionList) add(userID, fallback string) string {
+	name := fallback
+	if user, ok := ml.tc.lookupUser(userID); ok && user.DisplayName != "" {
+		name = user.DisplayName
+	}
+	id := len(ml.mentions)
+	ml.mentions = append(ml.mentions, mention{
+		ID:          id,
+		MentionText: name,
+		Mentioned:   &identitySet{User: &identity{ID: userID, DisplayName: name, UserIdentityType: "aadUser"}},
+	})
+	return `<at id="` + strconv.Itoa(id) + `">` + html.EscapeString(name) + "</at>"
+}
+
+// renderMessage converts an outgoing message to a Teams HTML body. Raw
+// messages are sent as HTML unchanged.
+func (tc *teamsConnector) renderMessage(msg string, format robot.MessageFormat) *outgoingMessage {
+	ml := &mentionList{tc: tc}
+	var content string
+	switch format {
+	case robot.BasicMarkdown:
+		content = tc.renderBasicMarkdown(msg, ml)
+	case robot.Fixed:
+		if strings.TrimSpace(msg) != "" {
+			content = "<pre>" + html.EscapeString(strings.TrimSuffix(msg, "\n")) + "</pre>"
+		}
+	case robot.Variable:
+		content = strings.ReplaceAll(html.EscapeString(msg), "\n", "<br>")
+	default:
+		content = msg
+	}
+	return &outgoingMessage{Body: itemBody{ContentType: "html", Content: content}, Mentions: ml.mentions}
+}
+
+// prependMention directs a rendered message at a user.
+func (tc *teamsConnector) prependMention(out *outgoingMessage, userID string) {
+	ml := &mentionList{tc: tc}
+	tag := ml.add(userID, tc.canonicalName(userID))
+	for _, m := range out.Mentions {
+		m.ID++
+		ml.mentions = append(ml.mentions, m)
+	}
+	out.Body.Content = tag + " " + shiftMentionIDs(out.Body.Content, len(out.Mentions))
+	out.Mentions = ml.mentions
+}
+
+// shiftMentionIDs renumbers the <at> tags of a body rendered with n
+// mentions by one.
+func shiftMentionIDs(content string, n int) string {
+	for id := n - 1; id >= 0; id-- {
+		content = strings.ReplaceAll(content, `<at id="`+strconv.Itoa(id)+`">`, `<at id="`+strconv.Itoa(id+1)+`">`)
+	}
+	return content
+}
+
+// renderBasicMarkdown renders BasicMarkdown as the HTML subset Teams
+// accepts in message bodies.
+func (tc *teamsConnector) renderBasicMarkdown(msg string, ml *mentionList) string {
+	var out strings.Builder
+	inFence := false
+	lang := ""
+
+	for {
+		idx := strings.Index(msg, "```")
+		if idx == -1 {
+			if inFence {
+				out.WriteString(renderCodeBlock(msg, lang))
+			} else {
+				out.WriteString(tc.renderBasicMarkdownInline(msg, ml))
+			}
+			break
+		}
+
+		chunk := msg[:idx]
+		if inFence {
+			out.WriteString(renderCodeBlock(chunk, lang))
+		} else {
+			out.WriteString(tc.renderBasicMarkdownInline(chunk, ml))
+		}
+
+		inFence = !inFence
+		msg = msg[idx+3:]
+		if inFence {
+			lang, msg = splitFenceLanguage(msg)
+		}
+	}
+
+	return out.String()
+}
+
+// splitFenceLanguage separates an opening fence's language tag from the
+// code that follows it.
+func splitFenceLanguage(msg string) (string, string) {
+	if msg == "" || msg[0] == '\n' {
+		return "", msg
+	}
+	lineEnd := strings.IndexByte(msg, '\n')
+	if lineEnd == -1 {
+		return strings.TrimSpace(msg), ""
+	}
+	return strings.TrimSpace(msg[:lineEnd]), msg[lineEnd:]
+}
+
+func renderCodeBlock(code, lang string) string {
+	code = strings.TrimPrefix(code, "\n")
+	code = strings.TrimSuffix(code, "\n")
+	open := "<pre><code>"
+	if lang != "" && !strings.ContainsAny(lang, " \t\"<>&") {
+		open = `<pre><code class="language-` + lang + `">`
+	}
+	return open + html.EscapeString(code) + "</code></pre>"
+}
+
+func (tc *teamsConnector) renderBasicMarkdownInline(msg string, ml *mentionList) string {
+	var out strings.Builder
+	for len(msg) > 0 {
+		start := findNextUnescapedBacktick(msg, 0)
+		if start == -1 {
+			out.WriteString(tc.renderBasicMarkdownChunk(msg, ml))
+			break
+		}
+		out.WriteString(tc.renderBasicMarkdownChunk(msg[:start], ml))
+
+		end := findNextUnescapedBacktick(msg, start+1)
+		if end == -1 {
+			out.WriteString(tc.renderBasicMarkdownChunk(msg[start:], ml))
+			break
+		}
+		out.WriteString("<code>" + html.EscapeString(msg[start+1:end]) + "</code>")
+		msg = msg[end+1:]
+	}
+	return out.String()
+}
+
+func findNextUnescapedBacktick(msg string, start int) int {
+	for i := start; i < len(msg); i++ {
+		if msg[i] == '`' && !isEscapedAt(msg, i) {
+			return i
+		}
+	}
+	return -1
+}
+
+func isEscapedAt(msg string, idx int) bool {
+	if idx <= 0 || idx > len(msg)-1 {
+		return false
+	}
+	slashes := 0
+	for i := idx - 1; i >= 0 && msg[i] == '\\'; i-- {
+		slashes++
+	}
+	return slashes%2 == 1
+}
+
+func (tc *teamsConnector) renderBasicMarkdownChunk(msg string, ml *mentionList) string {
+	msg, escapedLiterals := protectBasicMarkdownEscapes(msg)
+	msg = html.EscapeString(msg)
+	msg = replaceBasicMarkdownLinks(msg)
+	msg = replaceBasicMarkdownEmoji(msg)
+	msg = replaceBasicMarkdownEmphasis(msg, "**", "strong")
+	msg = replaceBasicMarkdownEmphasis(msg, "*", "em")
+	msg = tc.replaceBasicMarkdownMentions(msg, ml)
+	msg = strings.ReplaceAll(msg, "\n", "<br>")
+	return restoreEscapedLiterals(msg, escapedLiterals)
+}
+
+func protectBasicMarkdownEscapes(msg string) (string, []string) {
+	var escaped []string
+	var out strings.Builder
+	for i := 0; i < len(msg); i++ {
+		ch := msg[i]
+		if ch != '\\' || i+1 >= len(msg) || !isBasicMarkdownEscapable(msg[i+1]) {
+			out.WriteByte(ch)
+			continue
+		}
+		escaped = append(escaped, html.EscapeString(string(msg[i+1])))
+		out.WriteString(escapedPlaceholder(len(escaped) - 1))
+		i++
+	}
+	return out.String(), escaped
+}
+
+func isBasicMarkdownEscapable(ch byte) bool {
+	switch ch {
+	case '*', '`', '[', ']', '(', ')', '@', '\\':
+		return true
+	default:
+		return false
+	}
+}
+
+func escapedPlaceholder(idx int) string {
+	return "\x00GBESC" + strconv.Itoa(idx) + "\x00"
+}
+
+func restoreEscapedLiterals(msg string, escaped []string) string {
+	for i, literal := range escaped {
+		msg = strings.ReplaceAll(msg, escapedPlaceholder(i), literal)
+	}
+	return msg
+}
+
+// replaceBasicMarkdownLinks renders [label](url) links; msg is already
+// HTML-escaped, so label and url are safe to embed.
+func replaceBasicMarkdownLinks(msg string) string {
+	var out strings.Builder
+	for i := 0; i < len(msg); {
+		open := strings.IndexByte(msg[i:], '[')
+		if open == -1 {
+			out.WriteString(msg[i:])
+			break
+		}
+		open += i
+		out.WriteString(msg[i:open])
+
+		close := strings.IndexByte(msg[open+1:], ']')
+		if close == -1 {
+			out.WriteString(msg[open:])
+			break
+		}
+		close += open + 1
+		if close+1 >= len(msg) || msg[close+1] != '(' {
+			out.WriteString(msg[open : close+1])
+			i = close + 1
+			continue
+		}
+		end := strings.IndexByte(msg[close+2:], ')')
+		if end == -1 {
+			out.WriteString(msg[open:])
+			break
+		}
+		end += close + 2
+
+		label := msg[open+1 : close]
+		url := msg[close+2 : end]
+		if !isBasicMarkdownLinkURL(url) {
+			out.WriteString(msg[open : end+1])
+		} else {
+			out.WriteString(`<a href="` + url + `">` + label + "</a>")
+		}
+		i = end + 1
+	}
+	return out.String()
+}
+
+func isBasicMarkdownLinkURL(url string) bool {
+	if strings.ContainsAny(url, " \t\r\n") {
+		return false
+	}
+	return strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://")
+}
+
+func replaceBasicMarkdownEmoji(msg string) string {
+	var out strings.Builder
+	for i := 0; i < len(msg); {
+		if msg[i] != ':' {
+			out.WriteByte(msg[i])
+			i++
+			continue
+		}
+		end := findBasicMarkdownEmojiEnd(msg, i)
+		if end == -1 {
+			out.WriteByte(msg[i])
+			i++
+			continue
+		}
+		if emoji := util.EmojiUnicode(msg[i+1 : end]); emoji != "" {
+			out.WriteString(emoji)
+		} else {
+			out.WriteString(msg[i : end+1])
+		}
+		i = end + 1
+	}
+	return out.String()
+}
+
+func findBasicMarkdownEmojiEnd(msg string, start int) int {
+	if start > 0 && isBasicMarkdownEmojiNameChar(msg[start-1]) {
+		return -1
+	}
+	nameStart := start + 1
+	if nameStart >= len(msg) || !isBasicMarkdownEmojiNameChar(msg[nameStart]) {
+		return -1
+	}
+	for i := nameStart; i < len(msg); i++ {
+		switch {
+		case msg[i] == ':':
+			if i+1 < len(msg) && isBasicMarkdownEmojiNameChar(msg[i+1]) {
+				return -1
+			}
+			return i
+		case isBasicMarkdownEmojiNameChar(msg[i]):
+		default:
+			return -1
+		}
+	}
+	return -1
+}
+
+func isBasicMarkdownEmojiNameChar(ch byte) bool {
+	switch {
+	case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9':
+		return true
+	case ch == '_' || ch == '+' || ch == '-':
+		return true
+	default:
+		return false
+	}
+}
+
+// replaceBasicMarkdownEmphasis wraps text between pairs of marker in tag;
+// a single "*" marker doesn't match inside "**".
+func replaceBasicMarkdownEmphasis(msg, marker, tag string) string {
+	var out strings.Builder
+	for {
+		start := findEmphasisMarker(msg, 0, marker)
+		if start == -1 {
+			out.WriteString(msg)
+			break
+		}
+		end := findEmphasisMarker(msg, start+len(marker), marker)
+		if end == -1 || end == start+len(marker) {
+			out.WriteString(msg[:start+len(marker)])
+			msg = msg[start+len(marker):]
+			continue
+		}
+		out.WriteString(msg[:start])
+		out.WriteString("<" + tag + ">" + msg[start+len(marker):end] + "</" + tag + ">")
+		msg = msg[end+len(marker):]
+	}
+	return out.String()
+}
+
+func findEmphasisMarker(msg string, from int, marker string) int {
+	for i := from; i+len(marker) <= len(msg); i++ {
+		if msg[i:i+len(marker)] != marker {
+			continue
+		}
+		if marker == "*" && ((i > 0 && msg[i-1] == '*') || (i+1 < len(msg) && msg[i+1] == '*')) {
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+// replaceBasicMarkdownMentions turns @username for users in UserMap into
+// Teams mentions.
+func (tc *teamsConnector) replaceBasicMarkdownMentions(msg string, ml *mentionList) string {
+	var out strings.Builder
+	for i := 0; i < len(msg); {
+		if msg[i] != '@' || isEmailMention(msg, i) || (i > 0 && msg[i-1] == '/') {
+			out.WriteByte(msg[i])
+			i++
+			continue
+		}
+		end := findMentionEnd(msg, i+1)
+		name := strings.ToLower(msg[i+1 : end])
+		tc.mu.RLock()
+		userID, ok := tc.botUserMap[name]
+		tc.mu.RUnlock()
+		if end == i+1 || !ok {
+			out.WriteByte(msg[i])
+			i++
+			continue
+		}
+		out.WriteString(ml.add(userID, name))
+		i = end
+	}
+	return out.String()
+}
+
+func isEmailMention(msg string, at int) bool {
+	if at <= 0 {
+		return false
+	}
+	prev := msg[at-1]
+	return (prev >= 'A' && prev <= 'Z') || (prev >= 'a' && prev <= 'z') || (prev >= '0' && prev <= '9') || prev == '.' || prev == '_' || prev == '-'
+}
+
+func findMentionEnd(msg string, start int) int {
+	i := start
+	for i < len(msg) {
+		ch := msg[i]
+		if (ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z') || (ch >= '0' && ch <= '9') || ch == '_' || ch == '-' || ch == '.' {
+			i++
+			continue
+		}
+		break
+	}
+	for i > start && msg[i-1] == '.' {
+		i--
+	}
+	return i
+}
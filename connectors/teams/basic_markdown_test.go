@@ -0,0 +1,35 @@
+package teams
+
+import (
+	"testing"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+func TestRenderBasicMarkdown(t *testing.T) {
+	tc, _ := newTestConnector(t, newFakeGraph(), &fakeStream{})
+	for in, want := range map[string]string{
+		"**bold** and *italic*":                 "<strong>bold</strong> and <em>italic</em>",
+		"run `ls <dir>` now":                    "run <code>ls &lt;dir&gt;</code> now",
+		"see [the docs](https://example.com/a)": `see <a href="https://example.com/a">the docs</a>`,
+		"line one\nline two :smile:":            "line one<br>line two 😄",
+		"ping @alice, not bob@alice.com":        `ping <at id="0">Alice Smith</at>, not bob@alice.com`,
+		"```go\nfmt.Println(\"<hi>\")\n```":     `<pre><code class="language-go">fmt.Println(&#34;&lt;hi&gt;&#34;)</code></pre>`,
+	} {
+		if got := tc.renderMessage(in, robot.BasicMarkdown).Body.Content; got != want {
+			t.Errorf("renderMessage(%q)\n got %q\nwant %q", in, got, want)
+		}
+	}
+}
+
+func TestPrependMentionRenumbers(t *testing.T) {
+	tc, _ := newTestConnector(t, newFakeGraph(), &fakeStream{})
+	out := tc.renderMessage("thanks @alice", robot.BasicMarkdown)
+	tc.prependMention(out, bobID)
+	if want := `<at id="0">Bob Jones</at> thanks <at id="1">Alice Smith</at>`; out.Body.Content != want {
+		t.Errorf("content = %q, want %q", out.Body.Content, want)
+	}
+	if len(out.Mentions) != 2 || out.Mentions[0].Mentioned.User.ID != bobID || out.Mentions[1].ID != 1 || out.Mentions[1].Mentioned.User.ID != aliceID {
+		t.Errorf("mentions = %+v", out.Mentions)
+	}
+}
@@ -0,0 +1,152 @@
+// Package teams implements the robot.Connector interface for Microsoft
+// Teams. Graph change notifications for new messages are delivered to an
+// Azure event hub, which the connector reads over an outbound connection;
+// everything else uses the Graph REST API with the app's Entra ID
+// credentials.
+package teams
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const (
+	loginTimeout         = 10 * time.Second
+	defaultConsumerGroup = "$Default"
+	cursorFile           = "cursors.json"
+)
+
+type config struct {
+	TenantID                 string   // Entra ID tenant (directory) ID
+	ClientID                 string   // application (client) ID of the app registration
+	ClientSecret             string   // client secret of the app registration
+	BotUserID                string   // Entra object ID of the account the robot posts as
+	EventHubConnectionString string   // connection string with Listen rights on the event hub
+	EventHub                 string   // event hub Graph delivers change notifications to
+	ConsumerGroup            string   // consumer group the robot reads with, default $Default
+	NotificationURL          string   // overrides the notificationUrl derived from the connection string
+	ClientState              string   // secret Graph returns with each notification
+	Teams                    []string // IDs of the teams whose channels the robot reads
+	DirectMessages           bool     // also read the robot account's 1:1 and group chats
+	CursorDirectory          string   // where event hub cursors are kept
+	ThreadResponses          bool     // reply in a thread to messages that didn't start one
+	UserMap                  map[string]string
+}
+
+// normalizeUserID returns a lowercase Entra object ID, or "" if in isn't
+// one.
+func normalizeUserID(in string) string {
+	in = strings.ToLower(strings.TrimSpace(in))
+	if len(in) != 36 {
+		return ""
+	}
+	for i := 0; i < len(in); i++ {
+		ch := in[i]
+		switch i {
+		case 8, 13, 18, 23:
+			if ch != '-' {
+				return ""
+			}
+		default:
+			if (ch < 'a' || ch > 'f') && (ch < '0' || ch > '9') {
+				return ""
+			}
+		}
+	}
+	return in
+}
+
+func normalizeConfiguredUserMap(in map[string]string, h robot.Handler) map[string]string {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(in))
+	for user, id := range in {
+		name := strings.TrimSpace(user)
+		uid := normalizeUserID(id)
+		if name == "" || uid == "" {
+			h.Log(robot.Warn, "Ignoring invalid Teams UserMap entry (empty username or invalid object ID): %q -> %q", user, id)
+			continue
+		}
+		if strings.ToLower(name) != name {
+			h.Log(robot.Warn, "Ignoring Teams UserMap entry with uppercase username: %q", user)
+			continue
+		}
+		out[name] = uid
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// Initialize validates config, checks the app's credentials against the
+// robot's account and returns the connector.
+func Initialize(handler robot.Handler, l *log.Logger) robot.InitializedConnector {
+	var c config
+	if err := handler.GetProtocolConfig(&c); err != nil {
+		handler.Log(robot.Fatal, "Unable to retrieve teams protocol configuration: %v", err)
+	}
+	for _, required := range []struct{ name, value string }{
+		{"TenantID", c.TenantID},
+		{"ClientID", c.ClientID},
+		{"ClientSecret", c.ClientSecret},
+		{"EventHubConnectionString", c.EventHubConnectionString},
+		{"EventHub", c.EventHub},
+		{"ClientState", c.ClientState},
+		{"CursorDirectory", c.CursorDirectory},
+	} {
+		if strings.TrimSpace(required.value) == "" {
+			handler.Log(robot.Fatal, "Teams protocol config requires %s", required.name)
+		}
+	}
+	selfID := normalizeUserID(c.BotUserID)
+	if selfID == "" {
+		handler.Log(robot.Fatal, "Teams protocol config requires BotUserID as an Entra object ID, got %q", c.BotUserID)
+	}
+	if len(c.Teams) == 0 && !c.DirectMessages {
+		handler.Log(robot.Fatal, "Teams protocol config needs at least one of Teams or DirectMessages")
+	}
+	notificationURL := strings.TrimSpace(c.NotificationURL)
+	if notificationURL == "" {
+		var err error
+		notificationURL, err = eventHubNotificationURL(c.EventHubConnectionString, c.EventHub, c.TenantID)
+		if err != nil {
+			handler.Log(robot.Fatal, "Teams: %v; set NotificationURL", err)
+		}
+	}
+	consumerGroup := strings.TrimSpace(c.ConsumerGroup)
+	if consumerGroup == "" {
+		consumerGroup = defaultConsumerGroup
+	}
+
+	if err := handler.GetDirectory(c.CursorDirectory); err != nil {
+		handler.Log(robot.Fatal, "Teams: unable to create cursor directory %s: %v", c.CursorDirectory, err)
+	}
+	cursors, err := loadCursors(filepath.Join(c.CursorDirectory, cursorFile))
+	if err != nil {
+		handler.Log(robot.Fatal, "Teams: unable to read event hub cursors: %v", err)
+	}
+	stream, err := newEventHubStream(c.EventHubConnectionString, c.EventHub, consumerGroup)
+	if err != nil {
+		handler.Log(robot.Fatal, "Teams: unable to connect to event hub %s: %v", c.EventHub, err)
+	}
+
+	graph := newGraphClient(c.TenantID, c.ClientID, c.ClientSecret)
+	ctx, cancel := context.WithTimeout(context.Background(), loginTimeout)
+	defer cancel()
+	me, err := graph.getUser(ctx, selfID)
+	if err != nil {
+		handler.Log(robot.Fatal, "Unable to look up the robot's Teams account %s with the app's credentials: %v", selfID, err)
+	}
+
+	connector := newTeamsConnector(handler, graph, stream, cursors, me, notificationURL, c)
+	handler.Log(robot.Info, "Teams connector initialized as %s (%s), reading event hub %s", me.DisplayName, selfID, c.EventHub)
+	handler.SetBotID(selfID)
+	return robot.InitializedConnector{Connector: connector}
+}
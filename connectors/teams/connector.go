@@ -0,0 +1,521 @@
+package teams
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lnxjedi/gopherbot/robot"
+	"github.com/lnxjedi/gopherbot/robot/util"
+)
+
+const (
+	sendTimeout     = 10 * time.Second
+	lookupTimeout   = 10 * time.Second
+	maxSendAttempts = 2
+	sendRetryDelay  = 250 * time.Millisecond
+	maxRetryDelay   = 5 * time.Second
+	// Teams rejects message bodies over about 28KB; leave room for the
+	// request envelope and mentions.
+	maxMessageSize = 24000
+)
+
+// channelInfo is a channel the robot has seen, with the team it belongs to.
+type channelInfo struct {
+	teamID string
+	name   string
+}
+
+type teamsConnector struct {
+	robot.Handler
+
+	graph           graphAPI
+	stream          eventStream
+	cursors         *cursorStore
+	selfID          string // the robot account's Entra object ID
+	appID           string // the app registration's client ID
+	botName         string // the robot's gopherbot name
+	notificationURL string
+	clientState     string
+	teams           []string
+	directMessages  bool
+	retrySleep      func(time.Duration)
+
+	subMu         sync.Mutex
+	subscriptions map[string]subscription // resource -> subscription
+
+	seenMu    sync.Mutex
+	seen      map[string]struct{}
+	seenOrder []string
+
+	mu              sync.RWMutex
+	threadResponses bool
+	botUserMap      map[string]string // username -> Entra object ID
+	configuredUsers map[string]string // Entra object ID -> username
+	usersByID       map[string]graphUser
+	channels        map[string]channelInfo
+	chats           map[string]string // chat ID -> chatType
+	directByUser    map[string]string // user ID -> 1:1 chat ID
+}
+
+func newTeamsConnector(handler robot.Handler, graph graphAPI, stream eventStream, cursors *cursorStore, me *graphUser, notificationURL string, c config) *teamsConnector {
+	botName := strings.TrimSpace(handler.GetBotInfo().UserName)
+	if botName == "" {
+		botName = "gopherbot"
+	}
+	var teams []string
+	for _, team := range c.Teams {
+		if team = strings.TrimSpace(team); team != "" {
+			teams = append(teams, team)
+		}
+	}
+	tc := &teamsConnector{
+		Handler:         handler,
+		graph:           graph,
+		stream:          stream,
+		cursors:         cursors,
+		selfID:          normalizeUserID(me.ID),
+		appID:           strings.ToLower(strings.TrimSpace(c.ClientID)),
+		botName:         botName,
+		notificationURL: notificationURL,
+		clientState:     c.ClientState,
+		teams:           teams,
+		directMessages:  c.DirectMessages,
+		subscriptions:   make(map[string]subscription),
+		seen:            make(map[string]struct{}),
+		threadResponses: c.ThreadResponses,
+		botUserMap:      normalizeConfiguredUserMap(c.UserMap, handler),
+		usersByID:       make(map[string]graphUser),
+		channels:        make(map[string]channelInfo),
+		chats:           make(map[string]string),
+		directByUser:    make(map[string]string),
+	}
+	tc.configuredUsers = configuredUsersByID(tc.botUserMap)
+	tc.cacheUser(*me)
+	return tc
+}
+
+func configuredUsersByID(userMap map[string]string) map[string]string {
+	configured := make(map[string]string, len(userMap))
+	for name, id := range userMap {
+		configured[id] = name
+	}
+	return configured
+}
+
+func (tc *teamsConnector) Reload() error {
+	var c config
+	if err := tc.GetProtocolConfig(&c); err != nil {
+		return fmt.Errorf("retrieve Teams protocol configuration: %w", err)
+	}
+	newBotUserMap := normalizeConfiguredUserMap(c.UserMap, tc.Handler)
+	newConfiguredUsers := configuredUsersByID(newBotUserMap)
+
+	tc.mu.Lock()
+	tc.botUserMap = newBotUserMap
+	tc.configuredUsers = newConfiguredUsers
+	tc.threadResponses = c.ThreadResponses
+	tc.mu.Unlock()
+
+	tc.Log(robot.Info, "Teams connector reloaded %d configured user mapping(s)", len(newBotUserMap))
+	return nil
+}
+
+func (tc *teamsConnector) cacheUser(user graphUser) {
+	id := normalizeUserID(user.ID)
+	if id == "" {
+		return
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.usersByID[id] = user
+}
+
+// lookupUser returns a user from the cache, or from Graph.
+func (tc *teamsConnector) lookupUser(userID string) (graphUser, bool) {
+	tc.mu.RLock()
+	user, ok := tc.usersByID[userID]
+	tc.mu.RUnlock()
+	if ok {
+		return user, true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	found, err := tc.graph.getUser(ctx, userID)
+	if err != nil {
+		tc.Log(robot.Debug, "Teams: unable to look up user %s: %v", userID, err)
+		return graphUser{}, false
+	}
+	tc.cacheUser(*found)
+	return *found, true
+}
+
+func (tc *teamsConnector) canonicalName(userID string) string {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	return tc.configuredUsers[userID]
+}
+
+// loadChannels caches the channels of a team, so they can be addressed by
+// name.
+func (tc *teamsConnector) loadChannels(teamID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	channels, err := tc.graph.listChannels(ctx, teamID)
+	if err != nil {
+		tc.Log(robot.Warn, "Teams: unable to list the channels of team %s: %v", teamID, err)
+		return
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	for _, ch := range channels {
+		tc.channels[ch.ID] = channelInfo{teamID: teamID, name: ch.DisplayName}
+	}
+}
+
+// lookupChannel returns a known channel, listing the channels of its team
+// if it hasn't been seen yet.
+func (tc *teamsConnector) lookupChannel(teamID, channelID string) (channelInfo, bool) {
+	tc.mu.RLock()
+	info, ok := tc.channels[channelID]
+	tc.mu.RUnlock()
+	if ok || teamID == "" {
+		return info, ok
+	}
+	tc.loadChannels(teamID)
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if info, ok = tc.channels[channelID]; !ok {
+		info = channelInfo{teamID: teamID}
+		tc.channels[channelID] = info
+	}
+	return info, true
+}
+
+// chatType returns the type of a chat (oneOnOne, group or meeting) from the
+// cache, or from Graph.
+func (tc *teamsConnector) chatType(chatID string) (string, bool) {
+	tc.mu.RLock()
+	chatType, ok := tc.chats[chatID]
+	tc.mu.RUnlock()
+	if ok {
+		return chatType, true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	chat, err := tc.graph.getChat(ctx, chatID)
+	if err != nil {
+		tc.Log(robot.Warn, "Teams: unable to look up chat %s: %v", chatID, err)
+		return "", false
+	}
+	tc.cacheChat(chatID, chat.ChatType)
+	return chat.ChatType, true
+}
+
+func (tc *teamsConnector) cacheChat(chatID, chatType string) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.chats[chatID] = chatType
+}
+
+func (tc *teamsConnector) noteDirectChat(userID, chatID string) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.chats[chatID] = "oneOnOne"
+	tc.directByUser[userID] = chatID
+}
+
+func (tc *teamsConnector) GetProtocolUserAttribute(u, attr string) (string, robot.RetVal) {
+	userID, ok := tc.resolveUserID(u, u)
+	if !ok {
+		return "", robot.UserNotFound
+	}
+	user, ok := tc.lookupUser(userID)
+	if !ok {
+		return "", robot.UserNotFound
+	}
+	var value string
+	switch strings.ToLower(strings.TrimSpace(attr)) {
+	case "name":
+		value = tc.canonicalName(userID)
+		if value == "" {
+			value = user.UserPrincipalName
+		}
+	case "email":
+		value = user.Mail
+		if value == "" {
+			value = user.UserPrincipalName
+		}
+	case "firstname":
+		value = user.GivenName
+	case "lastname":
+		value = user.Surname
+	case "fullname", "realname":
+		value = user.DisplayName
+	case "jobtitle":
+		value = user.JobTitle
+	case "internalid":
+		value = userID
+	}
+	if value == "" {
+		return "", robot.AttributeNotFound
+	}
+	return value, robot.Ok
+}
+
+// MessageHeard is a no-op; Graph has no typing indicator.
+func (tc *teamsConnector) MessageHeard(user, channel string) {}
+
+func (tc *teamsConnector) DefaultHelp() []string { return nil }
+
+// JoinChannel only checks the channel is known; the robot reads every
+// channel of the teams in its config, and Graph can't add it to others.
+func (tc *teamsConnector) JoinChannel(c string) robot.RetVal {
+	if _, ok := tc.resolveConversation(c); !ok {
+		tc.Log(robot.Error, "Teams channel not found for: %s", c)
+		return robot.ChannelNotFound
+	}
+	return robot.Ok
+}
+
+// conversation is where a message is sent: a channel in a team, or a chat.
+type conversation struct {
+	teamID    string
+	channelID string
+	chatID    string
+}
+
+func (c conversation) id() string {
+	if c.chatID != "" {
+		return c.chatID
+	}
+	return c.channelID
+}
+
+func (tc *teamsConnector) SendProtocolChannelThreadMessage(channelname, threadid, msg string, format robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	conv, ok := tc.resolveConversation(channelname)
+	if !ok {
+		tc.Log(robot.Error, "Teams channel not found for: %s", channelname)
+		return robot.ChannelNotFound
+	}
+	hiddenFor := hiddenReplyUser(conv.id(), "", msgObject)
+	threadID := tc.resolveThreadForContext(conv.id(), "", threadid, msgObject)
+	return tc.sendMessage(conv, "", hiddenFor, threadID, msg, format)
+}
+
+func (tc *teamsConnector) SendProtocolUserChannelThreadMessage(userid, username, channelname, threadid, msg string, format robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	conv, ok := tc.resolveConversation(channelname)
+	if !ok {
+		tc.Log(robot.Error, "Teams channel not found for: %s", channelname)
+		return robot.ChannelNotFound
+	}
+	userID, ok := tc.resolveUserID(userid, username)
+	if !ok {
+		tc.Log(robot.Error, "Teams user not found for: %s", username)
+		return robot.UserNotFound
+	}
+	hiddenFor := hiddenReplyUser(conv.id(), userID, msgObject)
+	threadID := tc.resolveThreadForContext(conv.id(), userID, threadid, msgObject)
+	return tc.sendMessage(conv, userID, hiddenFor, threadID, msg, format)
+}
+
+func (tc *teamsConnector) SendProtocolUserMessage(user, msg string, format robot.MessageFormat, msgObject *robot.ConnectorMessage) robot.RetVal {
+	userID, ok := tc.resolveUserID(user, user)
+	if !ok {
+		tc.Log(robot.Error, "Teams user not found for DM: %s", user)
+		return robot.UserNotFound
+	}
+	chatID, err := tc.directChat(userID)
+	if err != nil {
+		tc.Log(robot.Error, "Teams: unable to open a 1:1 chat with %s: %v", userID, err)
+		return robot.FailedMessageSend
+	}
+	return tc.sendMessage(conversation{chatID: chatID}, "", "", "", msg, format)
+}
+
+// directChat returns the 1:1 chat between the robot and a user; Graph
+// creates it on first use.
+func (tc *teamsConnector) directChat(userID string) (string, error) {
+	tc.mu.RLock()
+	chatID, ok := tc.directByUser[userID]
+	tc.mu.RUnlock()
+	if ok {
+		return chatID, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	chat, err := tc.graph.createOneOnOneChat(ctx, tc.selfID, userID)
+	cancel()
+	if err != nil {
+		return "", err
+	}
+	tc.noteDirectChat(userID, chat.ID)
+	return chat.ID, nil
+}
+
+// resolveConversation accepts a bracketed channel or chat ID, or the name
+// of a channel in one of the robot's teams.
+func (tc *teamsConnector) resolveConversation(channel string) (conversation, bool) {
+	if id, ok := util.ExtractID(channel); ok {
+		return tc.conversationByID(strings.TrimSpace(id))
+	}
+	name := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(channel), "#"))
+	if name == "" {
+		return conversation{}, false
+	}
+	if conv, ok := tc.channelByName(name); ok {
+		return conv, true
+	}
+	for _, team := range tc.teams {
+		tc.loadChannels(team)
+	}
+	return tc.channelByName(name)
+}
+
+func (tc *teamsConnector) conversationByID(id string) (conversation, bool) {
+	if id == "" {
+		return conversation{}, false
+	}
+	tc.mu.RLock()
+	info, isChannel := tc.channels[id]
+	_, isChat := tc.chats[id]
+	tc.mu.RUnlock()
+	switch {
+	case isChannel:
+		return conversation{teamID: info.teamID, channelID: id}, true
+	case isChat:
+		return conversation{chatID: id}, true
+	}
+	for _, team := range tc.teams {
+		tc.loadChannels(team)
+	}
+	tc.mu.RLock()
+	info, isChannel = tc.channels[id]
+	tc.mu.RUnlock()
+	if isChannel {
+		return conversation{teamID: info.teamID, channelID: id}, true
+	}
+	if _, ok := tc.chatType(id); ok {
+		return conversation{chatID: id}, true
+	}
+	return conversation{}, false
+}
+
+func (tc *teamsConnector) channelByName(name string) (conversation, bool) {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	for id, info := range tc.channels {
+		if strings.EqualFold(info.name, name) {
+			return conversation{teamID: info.teamID, channelID: id}, true
+		}
+	}
+	return conversation{}, false
+}
+
+// resolveUserID only treats bracketed IDs and well-formed object IDs as
+// transport IDs; usernames are resolved through UserMap.
+func (tc *teamsConnector) resolveUserID(uid, username string) (string, bool) {
+	if id, ok := util.ExtractID(uid); ok {
+		id = normalizeUserID(id)
+		return id, id != ""
+	}
+	if id := normalizeUserID(uid); id != "" {
+		return id, true
+	}
+	key := strings.ToLower(strings.TrimSpace(username))
+	if key == "" {
+		return "", false
+	}
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	id, ok := tc.botUserMap[key]
+	return id, ok
+}
+
+func (tc *teamsConnector) resolveThreadForContext(conversationID, userID, explicitThreadID string, msgObject *robot.ConnectorMessage) string {
+	threadID := strings.TrimSpace(explicitThreadID)
+	tc.mu.RLock()
+	threadResponses := tc.threadResponses
+	tc.mu.RUnlock()
+	if threadID != "" || !threadResponses || msgObject == nil {
+		return threadID
+	}
+	if msgObject.DirectMessage || msgObject.ChannelID != conversationID {
+		return ""
+	}
+	if userID != "" && msgObject.UserID != userID {
+		return ""
+	}
+	return strings.TrimSpace(msgObject.ThreadID)
+}
+
+// hiddenReplyUser returns the user a reply should be shown to privately:
+// the sender of a hidden message, as long as the reply is going back to
+// them in the same conversation.
+func hiddenReplyUser(conversationID, userID string, msgObject *robot.ConnectorMessage) string {
+	if msgObject == nil || !msgObject.HiddenMessage || msgObject.ChannelID != conversationID {
+		return ""
+	}
+	if userID != "" && userID != msgObject.UserID {
+		return ""
+	}
+	return msgObject.UserID
+}
+
+// sendMessage renders and posts a message. A userID directs it at that user
+// with a mention; hiddenFor sends it as a targeted message only that user
+// sees. Chats have no threads, so threadID only applies to channels.
+func (tc *teamsConnector) sendMessage(conv conversation, userID, hiddenFor, threadID, msg string, format robot.MessageFormat) robot.RetVal {
+	out := tc.renderMessage(msg, format)
+	if strings.TrimSpace(out.Body.Content) == "" {
+		tc.Log(robot.Error, "Teams: refusing to send empty message")
+		return robot.Failed
+	}
+	if userID != "" && hiddenFor == "" {
+		tc.prependMention(out, userID)
+	}
+	if utf8.RuneCountInString(out.Body.Content) > maxMessageSize {
+		tc.Log(robot.Error, "Teams message exceeds maximum size (%d characters)", maxMessageSize)
+		return robot.FailedMessageSend
+	}
+	if hiddenFor != "" {
+		out.IsTargetedActivity = true
+		out.TargetedUserIDs = []string{hiddenFor}
+	}
+	return tc.retrySend(conv.id(), func(ctx context.Context) error {
+		if conv.chatID != "" {
+			return tc.graph.sendChatMessage(ctx, conv.chatID, out)
+		}
+		return tc.graph.sendChannelMessage(ctx, conv.teamID, conv.channelID, threadID, out)
+	})
+}
+
+func (tc *teamsConnector) retrySend(conversationID string, send func(context.Context) error) robot.RetVal {
+	var err error
+	for attempt := 1; attempt <= maxSendAttempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		err = send(ctx)
+		cancel()
+		if err == nil {
+			return robot.Ok
+		}
+		delay, retry := retryDelay(err)
+		if attempt == maxSendAttempts || !retry {
+			break
+		}
+		tc.Log(robot.Warn, "Teams send attempt %d/%d failed for %s; retrying: %v", attempt, maxSendAttempts, conversationID, err)
+		tc.sleepForRetry(delay)
+	}
+	tc.Log(robot.Error, "Teams send failed to %s: %v", conversationID, err)
+	return robot.FailedMessageSend
+}
+
+func (tc *teamsConnector) sleepForRetry(delay time.Duration) {
+	if tc.retrySleep != nil {
+		tc.retrySleep(delay)
+		return
+	}
+	time.Sleep(delay)
+}
@@ -0,0 +1,518 @@
+package teams
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const (
+	botID    = "b0000000-0000-0000-0000-000000000000"
+	aliceID  = "a0000000-0000-0000-0000-000000000000"
+	bobID    = "c0000000-0000-0000-0000-000000000000"
+	appID    = "e0000000-0000-0000-0000-000000000000"
+	teamID   = "t-engineering"
+	generalC = "19:general@thread.tacv2"
+	groupC   = "19:group@thread.v2"
+	dmC      = "19:dm@unq.gbl.spaces"
+	hubURL   = "EventHub:https://ns.servicebus.windows.net/eventhubname/robot?tenantId=tenant"
+)
+
+type testHandler struct {
+	cfg      config
+	incoming chan *robot.ConnectorMessage
+}
+
+func (h *testHandler) IncomingMessage(msg *robot.ConnectorMessage) { h.incoming <- msg }
+func (h *testHandler) GetProtocolConfig(v interface{}) error {
+	*(v.(*config)) = h.cfg
+	return nil
+}
+func (h *testHandler) GetBrainConfig(interface{}) error                 { return nil }
+func (h *testHandler) GetEventStrings() *[]string                       { return nil }
+func (h *testHandler) GetHistoryConfig(interface{}) error               { return nil }
+func (h *testHandler) GetBotInfo() robot.BotInfo                        { return robot.BotInfo{UserName: "bishop"} }
+func (h *testHandler) SetBotID(string)                                  {}
+func (h *testHandler) SetTerminalWriter(io.Writer)                      {}
+func (h *testHandler) SetBotMention(string)                             {}
+func (h *testHandler) GetLogLevel() robot.LogLevel                      { return robot.Debug }
+func (h *testHandler) GetInstallPath() string                           { return "" }
+func (h *testHandler) GetConfigPath() string                            { return "" }
+func (h *testHandler) ReadEncryptedFile(string) ([]byte, error)         { return nil, nil }
+func (h *testHandler) Log(_ robot.LogLevel, m string, v ...interface{}) {}
+func (h *testHandler) GetDirectory(string) error                        { return nil }
+
+type channelSend struct {
+	teamID, channelID, replyTo string
+	msg                        *outgoingMessage
+}
+
+type chatSend struct {
+	chatID string
+	msg    *outgoingMessage
+}
+
+// fakeGraph serves canned Graph data and records what the connector sends.
+type fakeGraph struct {
+	mu           sync.Mutex
+	messages     map[string]*chatMessage
+	subs         []subscription
+	created      []subscription
+	renewed      []string
+	renewErr     error
+	chatsCreated []string
+	channelSends []channelSend
+	chatSends    []chatSend
+	sendErrs     []error
+}
+
+func newFakeGraph() *fakeGraph {
+	return &fakeGraph{messages: make(map[string]*chatMessage)}
+}
+
+func (g *fakeGraph) listSubscriptions(context.Context) ([]subscription, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]subscription(nil), g.subs...), nil
+}
+
+func (g *fakeGraph) createSubscription(_ context.Context, sub *subscription) (*subscription, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	created := *sub
+	created.ID = "sub-" + sub.Resource
+	g.created = append(g.created, created)
+	return &created, nil
+}
+
+func (g *fakeGraph) renewSubscription(_ context.Context, id string, _ time.Time) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.renewed = append(g.renewed, id)
+	return g.renewErr
+}
+
+func (g *fakeGraph) getMessage(_ context.Context, resource string) (*chatMessage, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	msg, ok := g.messages[resource]
+	if !ok {
+		return nil, &graphError{Status: http.StatusNotFound}
+	}
+	return msg, nil
+}
+
+func (g *fakeGraph) getUser(_ context.Context, id string) (*graphUser, error) {
+	switch id {
+	case aliceID:
+		return &graphUser{ID: aliceID, DisplayName: "Alice Smith", GivenName: "Alice", Surname: "Smith", Mail: "alice@example.com", JobTitle: "Engineer"}, nil
+	case bobID:
+		return &graphUser{ID: bobID, DisplayName: "Bob Jones"}, nil
+	}
+	return nil, &graphError{Status: http.StatusNotFound}
+}
+
+func (g *fakeGraph) listChannels(_ context.Context, team string) ([]graphChannel, error) {
+	return []graphChannel{{ID: generalC, DisplayName: "General"}}, nil
+}
+
+func (g *fakeGraph) getChat(_ context.Context, chatID string) (*graphChat, error) {
+	switch chatID {
+	case groupC:
+		return &graphChat{ID: chatID, ChatType: "group"}, nil
+	case dmC:
+		return &graphChat{ID: chatID, ChatType: "oneOnOne"}, nil
+	}
+	return nil, &graphError{Status: http.StatusNotFound}
+}
+
+func (g *fakeGraph) createOneOnOneChat(_ context.Context, userID, otherID string) (*graphChat, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.chatsCreated = append(g.chatsCreated, userID+"/"+otherID)
+	return &graphChat{ID: dmC, ChatType: "oneOnOne"}, nil
+}
+
+func (g *fakeGraph) nextSendErr() error {
+	if len(g.sendErrs) == 0 {
+		return nil
+	}
+	err := g.sendErrs[0]
+	g.sendErrs = g.sendErrs[1:]
+	return err
+}
+
+func (g *fakeGraph) sendChannelMessage(_ context.Context, team, channel, replyTo string, msg *outgoingMessage) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.nextSendErr(); err != nil {
+		return err
+	}
+	g.channelSends = append(g.channelSends, channelSend{team, channel, replyTo, msg})
+	return nil
+}
+
+func (g *fakeGraph) sendChatMessage(_ context.Context, chatID string, msg *outgoingMessage) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.nextSendErr(); err != nil {
+		return err
+	}
+	g.chatSends = append(g.chatSends, chatSend{chatID, msg})
+	return nil
+}
+
+// fakeStream is a one-partition event hub fed from a channel.
+type fakeStream struct {
+	events chan streamEvent
+	mu     sync.Mutex
+	afters []*int64
+}
+
+func (s *fakeStream) partitionIDs(context.Context) ([]string, error) { return []string{"0"}, nil }
+
+func (s *fakeStream) receive(ctx context.Context, _ string, after *int64, max int) ([]streamEvent, error) {
+	s.mu.Lock()
+	s.afters = append(s.afters, after)
+	s.mu.Unlock()
+	select {
+	case ev := <-s.events:
+		return []streamEvent{ev}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *fakeStream) close(context.Context) error { return nil }
+
+func testConfig() config {
+	return config{
+		ClientID:       appID,
+		ClientState:    "state-secret",
+		Teams:          []string{teamID},
+		DirectMessages: true,
+		UserMap:        map[string]string{"alice": strings.ToUpper(aliceID), "Carol": bobID},
+	}
+}
+
+func newTestConnector(t *testing.T, graph *fakeGraph, stream *fakeStream) (*teamsConnector, *testHandler) {
+	t.Helper()
+	h := &testHandler{cfg: testConfig(), incoming: make(chan *robot.ConnectorMessage, 10)}
+	cursors, err := loadCursors(filepath.Join(t.TempDir(), cursorFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := newTeamsConnector(h, graph, stream, cursors, &graphUser{ID: botID, DisplayName: "Bishop"}, hubURL, h.cfg)
+	tc.retrySleep = func(time.Duration) {}
+	return tc, h
+}
+
+func notificationEvent(seq int64, clientState string, resources ...string) streamEvent {
+	var batch struct {
+		Value []notification `json:"value"`
+	}
+	for _, res := range resources {
+		batch.Value = append(batch.Value, notification{SubscriptionID: "sub", ClientState: clientState, ChangeType: "created", Resource: res})
+	}
+	body, _ := json.Marshal(batch)
+	return streamEvent{SequenceNumber: seq, Body: body}
+}
+
+func channelMessage(id, replyTo, from, content string, mentions ...mention) *chatMessage {
+	return &chatMessage{
+		ID:              id,
+		ReplyToID:       replyTo,
+		MessageType:     "message",
+		ChannelIdentity: &channelIdentity{TeamID: teamID, ChannelID: generalC},
+		From:            &identitySet{User: &identity{ID: from}},
+		Body:            itemBody{ContentType: "html", Content: content},
+		Mentions:        mentions,
+	}
+}
+
+func receive(t *testing.T, h *testHandler) *robot.ConnectorMessage {
+	t.Helper()
+	select {
+	case msg := <-h.incoming:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an incoming message")
+		return nil
+	}
+}
+
+func TestRunDeliversNotifications(t *testing.T) {
+	graph := newFakeGraph()
+	graph.subs = []subscription{{ID: "existing", Resource: "teams/" + teamID + "/channels/getAllMessages", NotificationURL: hubURL}}
+	botMention := mention{ID: 0, MentionText: "Bishop", Mentioned: &identitySet{User: &identity{ID: botID}}}
+	aliceFirst := mention{ID: 1, MentionText: "Alice", Mentioned: &identitySet{User: &identity{ID: aliceID}}}
+	aliceLast := mention{ID: 2, MentionText: "Smith", Mentioned: &identitySet{User: &identity{ID: aliceID}}}
+	graph.messages["teams('t')/channels('c')/messages('1')"] = channelMessage("1", "", aliceID,
+		`<p><at id="0">Bishop</at>&nbsp;ping <at id="1">Alice</at> <at id="2">Smith</at> &amp; <a href="https://example.com">docs</a></p><p>next</p>`,
+		botMention, aliceFirst, aliceLast)
+	graph.messages["teams('t')/channels('c')/messages('1')/replies('2')"] = channelMessage("2", "1", bobID, "in a thread")
+	stream := &fakeStream{events: make(chan streamEvent, 4)}
+	tc, h := newTestConnector(t, graph, stream)
+
+	stream.events <- notificationEvent(7, "wrong", "teams('t')/channels('c')/messages('9')")
+	stream.events <- notificationEvent(8, "state-secret", "teams('t')/channels('c')/messages('1')", "teams('t')/channels('c')/messages('1')")
+	stream.events <- notificationEvent(9, "state-secret", "teams('t')/channels('c')/messages('1')/replies('2')")
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		tc.Run(stop)
+		close(done)
+	}()
+
+	msg := receive(t, h)
+	if msg.Protocol != "teams" || msg.UserID != aliceID || msg.UserName != "alice" || !msg.ValidatedUser {
+		t.Errorf("identity = %q %q %q %v", msg.Protocol, msg.UserID, msg.UserName, msg.ValidatedUser)
+	}
+	if msg.ChannelID != generalC || msg.ChannelName != "General" || msg.ThreadID != "1" || msg.ThreadedMessage || msg.DirectMessage {
+		t.Errorf("channel = %+v", msg)
+	}
+	if msg.BotMessage || msg.HiddenMessage {
+		t.Errorf("BotMessage/HiddenMessage set for an untargeted channel message")
+	}
+	if want := "@bishop ping @alice & docs\nnext"; msg.MessageText != want {
+		t.Errorf("MessageText = %q, want %q", msg.MessageText, want)
+	}
+
+	reply := receive(t, h)
+	if reply.ThreadID != "1" || !reply.ThreadedMessage || reply.UserName != "" || reply.ValidatedUser {
+		t.Errorf("reply = %+v", reply)
+	}
+	select {
+	case extra := <-h.incoming:
+		t.Errorf("unexpected duplicate message %q", extra.MessageText)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(stop)
+	<-done
+	stream.mu.Lock()
+	if len(stream.afters) == 0 || stream.afters[0] != nil {
+		t.Error("first receive didn't start from the latest event")
+	}
+	stream.mu.Unlock()
+	if seq := tc.cursors.get("0"); seq == nil || *seq != 9 {
+		t.Errorf("cursor = %v, want 9", seq)
+	}
+	reloaded, err := loadCursors(tc.cursors.path)
+	if err != nil || reloaded.get("0") == nil || *reloaded.get("0") != 9 {
+		t.Errorf("saved cursor not reloaded: %v", err)
+	}
+
+	graph.mu.Lock()
+	defer graph.mu.Unlock()
+	if len(graph.renewed) != 1 || graph.renewed[0] != "existing" {
+		t.Errorf("renewed = %v, want the existing subscription", graph.renewed)
+	}
+	if len(graph.created) != 1 || graph.created[0].Resource != "/users/"+botID+"/chats/getAllMessages" {
+		t.Fatalf("created = %+v", graph.created)
+	}
+	if sub := graph.created[0]; sub.ChangeType != "created" || sub.ClientState != "state-secret" || sub.NotificationURL != hubURL || sub.LifecycleNotificationURL != hubURL {
+		t.Errorf("subscription = %+v", sub)
+	}
+}
+
+func TestNormalizeChatsAndSelf(t *testing.T) {
+	tc, _ := newTestConnector(t, newFakeGraph(), &fakeStream{})
+
+	dm, ok := tc.normalizeIncomingMessage(&chatMessage{ID: "5", MessageType: "message", ChatID: dmC, From: &identitySet{User: &identity{ID: aliceID}}, Body: itemBody{ContentType: "text", Content: "hi"}})
+	if !ok || !dm.DirectMessage || dm.ChannelID != "" || dm.ThreadID != "" || dm.MessageText != "hi" {
+		t.Errorf("DM = %+v", dm)
+	}
+	if chatID, err := tc.directChat(aliceID); err != nil || chatID != dmC {
+		t.Errorf("directChat = %q, %v; want the chat the DM came from", chatID, err)
+	}
+
+	group, ok := tc.normalizeIncomingMessage(&chatMessage{ID: "6", MessageType: "message", ChatID: groupC, From: &identitySet{User: &identity{ID: aliceID}}})
+	if !ok || group.DirectMessage || group.ChannelID != groupC || group.ThreadID != "" {
+		t.Errorf("group chat = %+v", group)
+	}
+
+	self, ok := tc.normalizeIncomingMessage(channelMessage("7", "", botID, "hello"))
+	if !ok || !self.SelfMessage || self.UserID != botID {
+		t.Errorf("self = %+v", self)
+	}
+	app := channelMessage("8", "", "", "from the app")
+	app.From = &identitySet{Application: &identity{ID: appID}}
+	if msg, ok := tc.normalizeIncomingMessage(app); !ok || !msg.SelfMessage {
+		t.Errorf("app message = %+v", msg)
+	}
+
+	system := channelMessage("9", "", aliceID, "")
+	system.MessageType = "systemEventMessage"
+	if _, ok := tc.normalizeIncomingMessage(system); ok {
+		t.Error("system message delivered")
+	}
+}
+
+func TestSendMessages(t *testing.T) {
+	graph := newFakeGraph()
+	tc, _ := newTestConnector(t, graph, &fakeStream{})
+	tc.loadChannels(teamID)
+
+	if ret := tc.SendProtocolChannelThreadMessage("General", "1", "hi @alice", robot.BasicMarkdown, nil); ret != robot.Ok {
+		t.Fatalf("channel send = %v", ret)
+	}
+	if ret := tc.SendProtocolUserChannelThreadMessage("", "alice", "<"+generalC+">", "", "done", robot.Variable, nil); ret != robot.Ok {
+		t.Fatalf("user channel send = %v", ret)
+	}
+	graph.sendErrs = []error{&graphError{Status: http.StatusTooManyRequests}}
+	if ret := tc.SendProtocolUserMessage("alice", "secret", robot.Fixed, nil); ret != robot.Ok {
+		t.Fatalf("DM = %v", ret)
+	}
+	if ret := tc.SendProtocolUserMessage("<"+aliceID+">", "again", robot.Raw, nil); ret != robot.Ok {
+		t.Fatalf("second DM = %v", ret)
+	}
+	if ret := tc.SendProtocolUserMessage("nobody", "x", robot.Raw, nil); ret != robot.UserNotFound {
+		t.Errorf("unmapped user = %v, want UserNotFound", ret)
+	}
+	if ret := tc.SendProtocolChannelThreadMessage("missing", "", "x", robot.Raw, nil); ret != robot.ChannelNotFound {
+		t.Errorf("unknown channel = %v, want ChannelNotFound", ret)
+	}
+
+	if len(graph.channelSends) != 2 {
+		t.Fatalf("channel sends = %+v", graph.channelSends)
+	}
+	first := graph.channelSends[0]
+	if first.teamID != teamID || first.channelID != generalC || first.replyTo != "1" {
+		t.Errorf("first send went to %+v", first)
+	}
+	if want := `hi <at id="0">Alice Smith</at>`; first.msg.Body.Content != want || first.msg.Body.ContentType != "html" {
+		t.Errorf("body = %q, want %q", first.msg.Body.Content, want)
+	}
+	if len(first.msg.Mentions) != 1 || first.msg.Mentions[0].Mentioned.User.ID != aliceID || first.msg.Mentions[0].MentionText != "Alice Smith" {
+		t.Errorf("mentions = %+v", first.msg.Mentions)
+	}
+	if second := graph.channelSends[1]; second.replyTo != "" || second.msg.Body.Content != `<at id="0">Alice Smith</at> done` {
+		t.Errorf("directed send = %+v %q", second, second.msg.Body.Content)
+	}
+
+	if len(graph.chatsCreated) != 1 || graph.chatsCreated[0] != botID+"/"+aliceID {
+		t.Errorf("chats created = %v, want one", graph.chatsCreated)
+	}
+	if len(graph.chatSends) != 2 || graph.chatSends[0].chatID != dmC || graph.chatSends[0].msg.Body.Content != "<pre>secret</pre>" {
+		t.Errorf("chat sends = %+v", graph.chatSends)
+	}
+}
+
+func TestTargetedMessageGetsHiddenReply(t *testing.T) {
+	graph := newFakeGraph()
+	tc, _ := newTestConnector(t, graph, &fakeStream{})
+	tc.loadChannels(teamID)
+	botMention := mention{ID: 0, MentionText: "Bishop", Mentioned: &identitySet{User: &identity{ID: botID}}}
+
+	targeted := channelMessage("1", "", aliceID, `<p><at id="0">Bishop</at> whoami</p>`, botMention)
+	targeted.IsTargetedActivity, targeted.TargetedUserIDs = true, []string{botID}
+	incoming, ok := tc.normalizeIncomingMessage(targeted)
+	if !ok || !incoming.HiddenMessage || !incoming.BotMessage || incoming.MessageText != "whoami" || incoming.ChannelID != generalC {
+		t.Fatalf("targeted message = %+v", incoming)
+	}
+	other := channelMessage("2", "", aliceID, "psst")
+	other.IsTargetedActivity, other.TargetedUserIDs = true, []string{bobID}
+	if msg, ok := tc.normalizeIncomingMessage(other); ok {
+		t.Errorf("message targeted at another user delivered: %+v", msg)
+	}
+
+	tc.SendProtocolUserChannelThreadMessage(aliceID, "alice", "<"+generalC+">", "", "only you", robot.Raw, incoming)
+	tc.SendProtocolUserChannelThreadMessage(bobID, "", "<"+generalC+">", "", "everyone", robot.Raw, incoming)
+
+	if len(graph.channelSends) != 2 {
+		t.Fatalf("sends = %+v", graph.channelSends)
+	}
+	if msg := graph.channelSends[0].msg; !msg.IsTargetedActivity || len(msg.TargetedUserIDs) != 1 || msg.TargetedUserIDs[0] != aliceID || msg.Body.Content != "only you" {
+		t.Errorf("hidden reply = %+v", msg)
+	}
+	if msg := graph.channelSends[1].msg; msg.IsTargetedActivity {
+		t.Errorf("reply to another user was targeted: %+v", msg)
+	}
+}
+
+func TestSubscriptionRecovery(t *testing.T) {
+	graph := newFakeGraph()
+	tc, _ := newTestConnector(t, graph, &fakeStream{})
+	ctx := context.Background()
+	if err := tc.ensureSubscriptions(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(graph.created) != 2 {
+		t.Fatalf("created = %+v", graph.created)
+	}
+
+	graph.renewErr = &graphError{Status: http.StatusNotFound}
+	if err := tc.renewSubscriptions(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(graph.created) != 4 {
+		t.Errorf("missing subscriptions weren't re-created: %+v", graph.created)
+	}
+
+	graph.renewErr = nil
+	resource := "/teams/" + teamID + "/channels/getAllMessages"
+	tc.handleLifecycle(ctx, &notification{SubscriptionID: "sub-" + resource, LifecycleEvent: "subscriptionRemoved"})
+	if len(graph.created) != 5 || graph.created[4].Resource != resource {
+		t.Errorf("removed subscription wasn't replaced: %+v", graph.created)
+	}
+	tc.handleLifecycle(ctx, &notification{SubscriptionID: "sub-" + resource, LifecycleEvent: "reauthorizationRequired"})
+	if n := len(graph.renewed); n == 0 || graph.renewed[n-1] != "sub-"+resource {
+		t.Errorf("reauthorization didn't renew: %v", graph.renewed)
+	}
+}
+
+func TestReloadAndAttributes(t *testing.T) {
+	tc, h := newTestConnector(t, newFakeGraph(), &fakeStream{})
+	if got := tc.canonicalName(aliceID); got != "alice" {
+		t.Errorf("canonicalName = %q", got)
+	}
+	if _, ok := tc.resolveUserID("carol", "carol"); ok {
+		t.Error("uppercase UserMap entry was accepted")
+	}
+	for attr, want := range map[string]string{
+		"name":       "alice",
+		"email":      "alice@example.com",
+		"firstname":  "Alice",
+		"fullname":   "Alice Smith",
+		"jobtitle":   "Engineer",
+		"internalid": aliceID,
+	} {
+		if got, ret := tc.GetProtocolUserAttribute("alice", attr); ret != robot.Ok || got != want {
+			t.Errorf("attribute %s = %q, %v; want %q", attr, got, ret, want)
+		}
+	}
+
+	h.cfg.UserMap = map[string]string{"bob": bobID}
+	if err := tc.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if tc.canonicalName(aliceID) != "" || tc.canonicalName(bobID) != "bob" {
+		t.Errorf("reload didn't swap UserMap")
+	}
+}
+
+func TestValidMessageResource(t *testing.T) {
+	for resource, want := range map[string]bool{
+		"teams('t')/channels('c')/messages('1')":              true,
+		"chats('19:x@thread.v2')/messages('1')":               true,
+		"teams('t')/channels('c')/messages('1')/replies('2')": true,
+		"users('x')":          false,
+		"teams('t')/../users": false,
+		"teams('t')/channels('c')/messages('1')?$x=1":  false,
+		"https://example.com/teams('t')/messages('1')": false,
+	} {
+		if got := validMessageResource(resource); got != want {
+			t.Errorf("validMessageResource(%q) = %v", resource, got)
+		}
+	}
+}
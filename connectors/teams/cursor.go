@@ -0,0 +1,70 @@
+package teams
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// cursorStore keeps the last processed sequence number of each event hub
+// partition, so a restarted robot picks up where it left off instead of
+// missing or replaying notifications.
+type cursorStore struct {
+	path string
+
+	mu      sync.Mutex
+	cursors map[string]int64
+}
+
+// loadCursors reads the cursor file; a missing file means no cursors yet.
+func loadCursors(path string) (*cursorStore, error) {
+	cs := &cursorStore{path: path, cursors: make(map[string]int64)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &cs.cursors); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// get returns the cursor for a partition, or nil if it has none.
+func (cs *cursorStore) get(partitionID string) *int64 {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	seq, ok := cs.cursors[partitionID]
+	if !ok {
+		return nil
+	}
+	return &seq
+}
+
+// set records a partition's cursor and writes the file.
+func (cs *cursorStore) set(partitionID string, seq int64) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.cursors[partitionID] = seq
+	data, err := json.Marshal(cs.cursors)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(cs.path), ".cursor-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), cs.path)
+}
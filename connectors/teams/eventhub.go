@@ -0,0 +1,142 @@
+package teams
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azeventhubs"
+)
+
+// receiveWait is how long a receive waits for events before returning an
+// empty batch, so the caller can persist its cursor and notice shutdown.
+const receiveWait = 30 * time.Second
+
+// streamEvent is one event from the hub; Graph puts a batch of change
+// notifications in each event body.
+type streamEvent struct {
+	SequenceNumber int64
+	Body           []byte
+}
+
+// eventStream is the part of Event Hubs the connector reads from.
+type eventStream interface {
+	// partitionIDs lists the hub's partitions.
+	partitionIDs(ctx context.Context) ([]string, error)
+	// receive returns up to max events from a partition, starting after
+	// sequence number after, or with new events only when after is nil. It
+	// returns an empty batch when nothing arrives within receiveWait.
+	receive(ctx context.Context, partitionID string, after *int64, max int) ([]streamEvent, error)
+	close(ctx context.Context) error
+}
+
+// eventHubStream reads an event hub over AMQP.
+type eventHubStream struct {
+	consumer *azeventhubs.ConsumerClient
+
+	mu         sync.Mutex
+	partitions map[string]*azeventhubs.PartitionClient
+}
+
+func newEventHubStream(connectionString, hub, consumerGroup string) (*eventHubStream, error) {
+	consumer, err := azeventhubs.NewConsumerClientFromConnectionString(connectionString, hub, consumerGroup, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &eventHubStream{consumer: consumer, partitions: make(map[string]*azeventhubs.PartitionClient)}, nil
+}
+
+func (s *eventHubStream) partitionIDs(ctx context.Context) ([]string, error) {
+	props, err := s.consumer.GetEventHubProperties(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return props.PartitionIDs, nil
+}
+
+// receive opens the partition at the cursor on first use; later calls
+// continue from where the partition client left off.
+func (s *eventHubStream) receive(ctx context.Context, partitionID string, after *int64, max int) ([]streamEvent, error) {
+	pc, err := s.partitionClient(partitionID, after)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, receiveWait)
+	defer cancel()
+	received, err := pc.ReceiveEvents(ctx, max, nil)
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		s.dropPartition(partitionID)
+		return nil, err
+	}
+	events := make([]streamEvent, 0, len(received))
+	for _, ev := range received {
+		events = append(events, streamEvent{SequenceNumber: ev.SequenceNumber, Body: ev.Body})
+	}
+	return events, nil
+}
+
+func (s *eventHubStream) partitionClient(partitionID string, after *int64) (*azeventhubs.PartitionClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if pc, ok := s.partitions[partitionID]; ok {
+		return pc, nil
+	}
+	start := azeventhubs.StartPosition{}
+	if after != nil {
+		seq := *after
+		start.SequenceNumber = &seq
+	} else {
+		latest := true
+		start.Latest = &latest
+	}
+	pc, err := s.consumer.NewPartitionClient(partitionID, &azeventhubs.PartitionClientOptions{StartPosition: start})
+	if err != nil {
+		return nil, err
+	}
+	s.partitions[partitionID] = pc
+	return pc, nil
+}
+
+// dropPartition closes a failed partition client; the next receive reopens
+// it at the caller's cursor.
+func (s *eventHubStream) dropPartition(partitionID string) {
+	s.mu.Lock()
+	pc, ok := s.partitions[partitionID]
+	delete(s.partitions, partitionID)
+	s.mu.Unlock()
+	if ok {
+		pc.Close(context.Background())
+	}
+}
+
+func (s *eventHubStream) close(ctx context.Context) error {
+	s.mu.Lock()
+	partitions := s.partitions
+	s.partitions = make(map[string]*azeventhubs.PartitionClient)
+	s.mu.Unlock()
+	for _, pc := range partitions {
+		pc.Close(ctx)
+	}
+	return s.consumer.Close(ctx)
+}
+
+// eventHubNotificationURL is the notificationUrl Graph needs to deliver to
+// an event hub, built from the namespace in the connection string.
+func eventHubNotificationURL(connectionString, hub, tenantID string) (string, error) {
+	var endpoint string
+	for _, part := range strings.Split(connectionString, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		if ok && strings.EqualFold(strings.TrimSpace(key), "Endpoint") {
+			endpoint = strings.TrimSpace(value)
+		}
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("no Endpoint in the event hub connection string")
+	}
+	return "EventHub:https://" + u.Host + "/eventhubname/" + url.PathEscape(hub) + "?tenantId=" + url.QueryEscape(tenantID), nil
+}
@@ -0,0 +1,122 @@
+package teams
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const (
+	receiveBatch     = 50
+	closeTimeout     = 5 * time.Second
+	minStreamBackoff = time.Second
+	maxStreamBackoff = time.Minute
+)
+
+// Run keeps the robot's Graph subscriptions alive and reads each event hub
+// partition until stop is closed.
+func (tc *teamsConnector) Run(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+	defer func() {
+		cctx, ccancel := context.WithTimeout(context.Background(), closeTimeout)
+		defer ccancel()
+		if err := tc.stream.close(cctx); err != nil {
+			tc.Log(robot.Debug, "Teams: closing the event hub connection: %v", err)
+		}
+	}()
+
+	for _, team := range tc.teams {
+		tc.loadChannels(team)
+	}
+	go tc.manageSubscriptions(ctx)
+
+	partitions, ok := tc.waitForPartitions(ctx)
+	if !ok {
+		return
+	}
+	var wg sync.WaitGroup
+	for _, id := range partitions {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			tc.readPartition(ctx, id)
+		}(id)
+	}
+	wg.Wait()
+}
+
+// waitForPartitions lists the event hub's partitions, retrying with backoff
+// until it succeeds or ctx is cancelled.
+func (tc *teamsConnector) waitForPartitions(ctx context.Context) ([]string, bool) {
+	backoff := minStreamBackoff
+	for {
+		lctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+		partitions, err := tc.stream.partitionIDs(lctx)
+		cancel()
+		if err == nil {
+			return partitions, true
+		}
+		if ctx.Err() != nil {
+			return nil, false
+		}
+		tc.Log(robot.Error, "Teams: unable to read event hub partitions, retrying in %s: %v", backoff, err)
+		if !sleepContext(ctx, backoff) {
+			return nil, false
+		}
+		backoff = nextBackoff(backoff)
+	}
+}
+
+// readPartition handles the events in one partition, saving its cursor
+// after each batch.
+func (tc *teamsConnector) readPartition(ctx context.Context, partitionID string) {
+	backoff := minStreamBackoff
+	for ctx.Err() == nil {
+		events, err := tc.stream.receive(ctx, partitionID, tc.cursors.get(partitionID), receiveBatch)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			tc.Log(robot.Error, "Teams: reading event hub partition %s failed, retrying in %s: %v", partitionID, backoff, err)
+			if !sleepContext(ctx, backoff) {
+				return
+			}
+			backoff = nextBackoff(backoff)
+			continue
+		}
+		backoff = minStreamBackoff
+		if len(events) == 0 {
+			continue
+		}
+		for _, ev := range events {
+			tc.handleEvent(ctx, ev.Body)
+		}
+		if err := tc.cursors.set(partitionID, events[len(events)-1].SequenceNumber); err != nil {
+			tc.Log(robot.Warn, "Teams: unable to save the cursor for partition %s: %v", partitionID, err)
+		}
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+func nextBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > maxStreamBackoff {
+		backoff = maxStreamBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,341 @@
+package teams
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+const (
+	graphBaseURL     = "https://graph.microsoft.com/v1.0"
+	graphScope       = "https://graph.microsoft.com/.default"
+	maxErrorBodySize = 4096
+)
+
+// graphAPI is the part of Microsoft Graph the connector uses.
+type graphAPI interface {
+	listSubscriptions(ctx context.Context) ([]subscription, error)
+	createSubscription(ctx context.Context, sub *subscription) (*subscription, error)
+	renewSubscription(ctx context.Context, id string, expires time.Time) error
+	// getMessage fetches the message a change notification refers to.
+	getMessage(ctx context.Context, resource string) (*chatMessage, error)
+	getUser(ctx context.Context, id string) (*graphUser, error)
+	listChannels(ctx context.Context, teamID string) ([]graphChannel, error)
+	getChat(ctx context.Context, chatID string) (*graphChat, error)
+	createOneOnOneChat(ctx context.Context, userID, otherID string) (*graphChat, error)
+	// sendChannelMessage posts to a channel, as a reply when replyToID is set.
+	sendChannelMessage(ctx context.Context, teamID, channelID, replyToID string, msg *outgoingMessage) error
+	sendChatMessage(ctx context.Context, chatID string, msg *outgoingMessage) error
+}
+
+type identity struct {
+	ID               string `json:"id"`
+	DisplayName      string `json:"displayName,omitempty"`
+	UserIdentityType string `json:"userIdentityType,omitempty"`
+}
+
+type identitySet struct {
+	User        *identity `json:"user,omitempty"`
+	Application *identity `json:"application,omitempty"`
+}
+
+type itemBody struct {
+	ContentType string `json:"contentType"`
+	Content     string `json:"content"`
+}
+
+type channelIdentity struct {
+	TeamID    string `json:"teamId"`
+	ChannelID string `json:"channelId"`
+}
+
+type mention struct {
+	ID          int          `json:"id"`
+	MentionText string       `json:"mentionText"`
+	Mentioned   *identitySet `json:"mentioned"`
+}
+
+type chatMessage struct {
+	ID              string           `json:"id"`
+	ReplyToID       string           `json:"replyToId"`
+	MessageType     string           `json:"messageType"`
+	ChatID          string           `json:"chatId"`
+	ChannelIdentity *channelIdentity `json:"channelIdentity"`
+	From            *identitySet     `json:"from"`
+	Body            itemBody         `json:"body"`
+	Mentions        []mention        `json:"mentions"`
+	DeletedDateTime string           `json:"deletedDateTime"`
+	// A targeted message is only shown to its sender and TargetedUserIDs.
+	IsTargetedActivity bool     `json:"isTargetedActivity"`
+	TargetedUserIDs    []string `json:"targetedUserIds"`
+}
+
+type outgoingMessage struct {
+	Body     itemBody  `json:"body"`
+	Mentions []mention `json:"mentions,omitempty"`
+	// Targeted messages are only shown to TargetedUserIDs.
+	IsTargetedActivity bool     `json:"isTargetedActivity,omitempty"`
+	TargetedUserIDs    []string `json:"targetedUserIds,omitempty"`
+}
+
+type graphUser struct {
+	ID                string `json:"id"`
+	DisplayName       string `json:"displayName"`
+	GivenName         string `json:"givenName"`
+	Surname           string `json:"surname"`
+	Mail              string `json:"mail"`
+	UserPrincipalName string `json:"userPrincipalName"`
+	JobTitle          string `json:"jobTitle"`
+}
+
+type graphChannel struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"displayName"`
+}
+
+type graphChat struct {
+	ID       string `json:"id"`
+	ChatType string `json:"chatType"`
+}
+
+type subscription struct {
+	ID                       string    `json:"id,omitempty"`
+	Resource                 string    `json:"resource"`
+	ChangeType               string    `json:"changeType"`
+	NotificationURL          string    `json:"notificationUrl"`
+	LifecycleNotificationURL string    `json:"lifecycleNotificationUrl,omitempty"`
+	ClientState              string    `json:"clientState,omitempty"`
+	ExpirationDateTime       time.Time `json:"expirationDateTime"`
+}
+
+// graphError is a Graph error response.
+type graphError struct {
+	Status     int
+	Code       string
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *graphError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("HTTP %d", e.Status)
+	}
+	return fmt.Sprintf("HTTP %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// retryDelay reports how long to wait before retrying a failed request, or
+// false if it shouldn't be retried. Graph has no idempotency key for new
+// messages, so only throttled requests, which Graph didn't process, are
+// retried.
+func retryDelay(err error) (time.Duration, bool) {
+	var gErr *graphError
+	if !errors.As(err, &gErr) {
+		return 0, false
+	}
+	if gErr.Status != http.StatusTooManyRequests && gErr.Status != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	delay := gErr.RetryAfter
+	if delay <= 0 {
+		delay = sendRetryDelay
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay, true
+}
+
+func isNotFound(err error) bool {
+	var gErr *graphError
+	return errors.As(err, &gErr) && gErr.Status == http.StatusNotFound
+}
+
+// graphClient calls Graph with app-only tokens from the Entra ID client
+// credentials flow.
+type graphClient struct {
+	base string
+	http *http.Client
+}
+
+func newGraphClient(tenantID, clientID, clientSecret string) *graphClient {
+	cc := &clientcredentials.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		TokenURL:     "https://login.microsoftonline.com/" + url.PathEscape(tenantID) + "/oauth2/v2.0/token",
+		Scopes:       []string{graphScope},
+	}
+	client := cc.Client(context.Background())
+	client.Timeout = 30 * time.Second
+	return &graphClient{base: graphBaseURL, http: client}
+}
+
+func (c *graphClient) do(ctx context.Context, method, path string, body, out interface{}) error {
+	target := path
+	if !strings.HasPrefix(path, "https://") {
+		target = c.base + path
+	}
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var errResp struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+		_ = json.Unmarshal(data, &errResp)
+		gErr := &graphError{Status: resp.StatusCode, Code: errResp.Error.Code, Message: errResp.Error.Message}
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			gErr.RetryAfter = time.Duration(secs) * time.Second
+		}
+		return gErr
+	}
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *graphClient) listSubscriptions(ctx context.Context) ([]subscription, error) {
+	var subs []subscription
+	next := "/subscriptions"
+	for next != "" {
+		var page struct {
+			Value    []subscription `json:"value"`
+			NextLink string         `json:"@odata.nextLink"`
+		}
+		if err := c.do(ctx, http.MethodGet, next, nil, &page); err != nil {
+			return nil, err
+		}
+		subs = append(subs, page.Value...)
+		if page.NextLink != "" && !strings.HasPrefix(page.NextLink, c.base+"/") {
+			return nil, fmt.Errorf("unexpected subscription page link %q", page.NextLink)
+		}
+		next = page.NextLink
+	}
+	return subs, nil
+}
+
+func (c *graphClient) createSubscription(ctx context.Context, sub *subscription) (*subscription, error) {
+	var created subscription
+	if err := c.do(ctx, http.MethodPost, "/subscriptions", sub, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (c *graphClient) renewSubscription(ctx context.Context, id string, expires time.Time) error {
+	body := map[string]time.Time{"expirationDateTime": expires}
+	return c.do(ctx, http.MethodPatch, "/subscriptions/"+url.PathEscape(id), body, nil)
+}
+
+// getMessage only follows resource paths that name a channel or chat
+// message, since the path comes from the event stream.
+func (c *graphClient) getMessage(ctx context.Context, resource string) (*chatMessage, error) {
+	resource = strings.TrimPrefix(resource, "/")
+	if !validMessageResource(resource) {
+		return nil, fmt.Errorf("unexpected message resource %q", resource)
+	}
+	var msg chatMessage
+	if err := c.do(ctx, http.MethodGet, "/"+resource, nil, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func validMessageResource(resource string) bool {
+	if !strings.HasPrefix(resource, "teams(") && !strings.HasPrefix(resource, "chats(") {
+		return false
+	}
+	return !strings.ContainsAny(resource, "?#\\") && !strings.Contains(resource, "..") && strings.Contains(resource, "/messages(")
+}
+
+func (c *graphClient) getUser(ctx context.Context, id string) (*graphUser, error) {
+	var user graphUser
+	path := "/users/" + url.PathEscape(id) + "?$select=id,displayName,givenName,surname,mail,userPrincipalName,jobTitle"
+	if err := c.do(ctx, http.MethodGet, path, nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (c *graphClient) listChannels(ctx context.Context, teamID string) ([]graphChannel, error) {
+	var resp struct {
+		Value []graphChannel `json:"value"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/teams/"+url.PathEscape(teamID)+"/channels?$select=id,displayName", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Value, nil
+}
+
+func (c *graphClient) getChat(ctx context.Context, chatID string) (*graphChat, error) {
+	var chat graphChat
+	if err := c.do(ctx, http.MethodGet, "/chats/"+url.PathEscape(chatID), nil, &chat); err != nil {
+		return nil, err
+	}
+	return &chat, nil
+}
+
+// createOneOnOneChat returns the 1:1 chat between two users; Graph returns
+// the existing chat if there is one.
+func (c *graphClient) createOneOnOneChat(ctx context.Context, userID, otherID string) (*graphChat, error) {
+	member := func(id string) map[string]interface{} {
+		return map[string]interface{}{
+			"@odata.type":     "#microsoft.graph.aadUserConversationMember",
+			"roles":           []string{"owner"},
+			"user@odata.bind": graphBaseURL + "/users('" + id + "')",
+		}
+	}
+	body := map[string]interface{}{
+		"chatType": "oneOnOne",
+		"members":  []interface{}{member(userID), member(otherID)},
+	}
+	var chat graphChat
+	if err := c.do(ctx, http.MethodPost, "/chats", body, &chat); err != nil {
+		return nil, err
+	}
+	return &chat, nil
+}
+
+func (c *graphClient) sendChannelMessage(ctx context.Context, teamID, channelID, replyToID string, msg *outgoingMessage) error {
+	path := "/teams/" + url.PathEscape(teamID) + "/channels/" + url.PathEscape(channelID) + "/messages"
+	if replyToID != "" {
+		path += "/" + url.PathEscape(replyToID) + "/replies"
+	}
+	return c.do(ctx, http.MethodPost, path, msg, nil)
+}
+
+func (c *graphClient) sendChatMessage(ctx context.Context, chatID string, msg *outgoingMessage) error {
+	return c.do(ctx, http.MethodPost, "/chats/"+url.PathEscape(chatID)+"/messages", msg, nil)
+}
@@ -0,0 +1,307 @@
+package teams
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/lnxjedi/gopherbot/robot"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// maxSeenMessages bounds the set of recent messages used to drop duplicate
+// notifications; Graph delivers at least once.
+const maxSeenMessages = 1000
+
+// notification is a Graph change or lifecycle notification.
+type notification struct {
+	SubscriptionID string `json:"subscriptionId"`
+	ClientState    string `json:"clientState"`
+	ChangeType     string `json:"changeType"`
+	Resource       string `json:"resource"`
+	LifecycleEvent string `json:"lifecycleEvent"`
+}
+
+// handleEvent handles the batch of notifications in one event hub event.
+func (tc *teamsConnector) handleEvent(ctx context.Context, body []byte) {
+	var batch struct {
+		Value []notification `json:"value"`
+	}
+	if err := json.Unmarshal(body, &batch); err != nil {
+		tc.Log(robot.Debug, "Ignoring Teams event with invalid notifications: %v", err)
+		return
+	}
+	for i := range batch.Value {
+		tc.handleNotification(ctx, &batch.Value[i])
+	}
+}
+
+func (tc *teamsConnector) handleNotification(ctx context.Context, n *notification) {
+	if subtle.ConstantTimeCompare([]byte(n.ClientState), []byte(tc.clientState)) != 1 {
+		tc.Log(robot.Warn, "Ignoring Teams notification for subscription %s with the wrong clientState", n.SubscriptionID)
+		return
+	}
+	if n.LifecycleEvent != "" {
+		tc.handleLifecycle(ctx, n)
+		return
+	}
+	if n.ChangeType != "created" || !tc.markSeen(resourceKey(n.Resource)) {
+		return
+	}
+	gctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	msg, err := tc.graph.getMessage(gctx, n.Resource)
+	cancel()
+	if err != nil {
+		tc.Log(robot.Warn, "Teams: unable to fetch message %s: %v", n.Resource, err)
+		return
+	}
+	if cm, ok := tc.normalizeIncomingMessage(msg); ok {
+		tc.IncomingMessage(cm)
+	}
+}
+
+// markSeen records a message, reporting false if it was already seen.
+func (tc *teamsConnector) markSeen(key string) bool {
+	tc.seenMu.Lock()
+	defer tc.seenMu.Unlock()
+	if _, ok := tc.seen[key]; ok {
+		return false
+	}
+	tc.seen[key] = struct{}{}
+	tc.seenOrder = append(tc.seenOrder, key)
+	if len(tc.seenOrder) > maxSeenMessages {
+		delete(tc.seen, tc.seenOrder[0])
+		tc.seenOrder = tc.seenOrder[1:]
+	}
+	return true
+}
+
+func (tc *teamsConnector) normalizeIncomingMessage(msg *chatMessage) (*robot.ConnectorMessage, bool) {
+	if msg.MessageType != "message" || msg.DeletedDateTime != "" || msg.From == nil {
+		// system events, and deleted messages
+		return nil, false
+	}
+	var userID string
+	self := false
+	if msg.From.User != nil {
+		userID = normalizeUserID(msg.From.User.ID)
+		self = userID == tc.selfID
+	}
+	if msg.From.Application != nil && strings.EqualFold(msg.From.Application.ID, tc.appID) {
+		userID, self = tc.selfID, true
+	}
+	if userID == "" {
+		// other apps and bots
+		return nil, false
+	}
+
+	cm := &robot.ConnectorMessage{
+		Protocol:      "teams",
+		UserID:        userID,
+		MessageID:     msg.ID,
+		MessageText:   tc.normalizeMessageText(msg),
+		SelfMessage:   self,
+		MessageObject: msg,
+		Client:        tc.graph,
+	}
+	switch {
+	case msg.ChannelIdentity != nil && msg.ChannelIdentity.ChannelID != "":
+		info, _ := tc.lookupChannel(msg.ChannelIdentity.TeamID, msg.ChannelIdentity.ChannelID)
+		cm.ChannelID = msg.ChannelIdentity.ChannelID
+		cm.ChannelName = info.name
+		cm.ThreadID = msg.ID
+		if msg.ReplyToID != "" {
+			cm.ThreadID, cm.ThreadedMessage = msg.ReplyToID, true
+		}
+	case msg.ChatID != "":
+		chatType, ok := tc.chatType(msg.ChatID)
+		if !ok {
+			return nil, false
+		}
+		if chatType == "oneOnOne" {
+			cm.DirectMessage = true
+			if !self {
+				tc.noteDirectChat(userID, msg.ChatID)
+			}
+		} else {
+			// group and meeting chats have no threads
+			cm.ChannelID = msg.ChatID
+		}
+	default:
+		return nil, false
+	}
+	if msg.IsTargetedActivity && !self {
+		if !tc.targetsRobot(msg) {
+			// private to other users
+			return nil, false
+		}
+		// only the sender and the robot see a message targeted at it
+		cm.HiddenMessage, cm.BotMessage = true, true
+		cm.MessageText = tc.trimRobotMention(cm.MessageText)
+	}
+	if !self {
+		cm.UserName = tc.canonicalName(userID)
+		cm.ValidatedUser = cm.UserName != ""
+	}
+	return cm, true
+}
+
+// targetsRobot reports whether a targeted message is shown to the robot.
+func (tc *teamsConnector) targetsRobot(msg *chatMessage) bool {
+	for _, id := range msg.TargetedUserIDs {
+		if normalizeUserID(id) == tc.selfID {
+			return true
+		}
+	}
+	return false
+}
+
+// trimRobotMention drops a leading @mention of the robot, so a targeted
+// message reads as the bare command BotMessage implies.
+func (tc *teamsConnector) trimRobotMention(text string) string {
+	rest, ok := strings.CutPrefix(text, "@"+tc.botName)
+	if !ok || (rest != "" && !unicode.IsSpace(rune(rest[0]))) {
+		return text
+	}
+	return strings.TrimSpace(rest)
+}
+
+// normalizeMessageText converts a Teams HTML body to plain text, rewriting
+// mentions of the robot and mapped users to plain @username text.
+func (tc *teamsConnector) normalizeMessageText(msg *chatMessage) string {
+	if !strings.EqualFold(msg.Body.ContentType, "html") {
+		return strings.TrimSpace(msg.Body.Content)
+	}
+	// mentions of the same user share an identitySet
+	mentions := make(map[int]*identitySet, len(msg.Mentions))
+	byUser := make(map[string]*identitySet)
+	for _, m := range msg.Mentions {
+		mentioned := m.Mentioned
+		if mentioned != nil && mentioned.User != nil {
+			id := normalizeUserID(mentioned.User.ID)
+			if first, ok := byUser[id]; ok {
+				mentioned = first
+			} else {
+				byUser[id] = mentioned
+			}
+		}
+		mentions[m.ID] = mentioned
+	}
+
+	var out strings.Builder
+	newline := func() {
+		if s := out.String(); s != "" && !strings.HasSuffix(s, "\n") {
+			out.WriteByte('\n')
+		}
+	}
+	z := html.NewTokenizer(strings.NewReader(msg.Body.Content))
+	var mentionID = -1
+	var mentionText, linkText strings.Builder
+	var linkHref string
+	inLink := false
+	// Teams splits a mention of "Alice Smith" into one <at> per name;
+	// consecutive parts for the same user become one mention.
+	var lastMentioned *identitySet
+	lastMentionEnd := -1
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		tok := z.Token()
+		switch tt {
+		case html.TextToken:
+			text := strings.ReplaceAll(tok.Data, "\u00a0", " ")
+			switch {
+			case mentionID >= 0:
+				mentionText.WriteString(text)
+			case inLink:
+				linkText.WriteString(text)
+			default:
+				out.WriteString(text)
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			switch {
+			case tok.Data == "at":
+				mentionID = -1
+				if id, err := strconv.Atoi(attr(tok, "id")); err == nil && id >= 0 {
+					mentionID = id
+				}
+				mentionText.Reset()
+			case tok.DataAtom == atom.A:
+				inLink, linkHref = true, attr(tok, "href")
+				linkText.Reset()
+			case tok.DataAtom == atom.Br:
+				out.WriteByte('\n')
+			case tok.Data == "emoji":
+				out.WriteString(attr(tok, "alt"))
+			case isBlock(tok):
+				newline()
+			}
+		case html.EndTagToken:
+			switch {
+			case tok.Data == "at" && mentionID >= 0:
+				mentioned := mentions[mentionID]
+				mentionID = -1
+				if text := out.String(); mentioned != nil && mentioned == lastMentioned && strings.TrimSpace(text[lastMentionEnd:]) == "" {
+					out.Reset()
+					out.WriteString(text[:lastMentionEnd])
+					continue
+				}
+				out.WriteString(tc.mentionText(mentioned, mentionText.String()))
+				lastMentioned, lastMentionEnd = mentioned, out.Len()
+			case tok.DataAtom == atom.A && inLink:
+				text := linkText.String()
+				if strings.TrimSpace(text) == "" {
+					text = linkHref
+				}
+				out.WriteString(text)
+				inLink = false
+			case isBlock(tok):
+				newline()
+			}
+		}
+	}
+	return strings.TrimSpace(out.String())
+}
+
+// mentionText is the text a Teams mention becomes: @username for the robot
+// and mapped users, otherwise the display text of the mention.
+func (tc *teamsConnector) mentionText(mentioned *identitySet, text string) string {
+	if mentioned != nil {
+		if mentioned.User != nil {
+			id := normalizeUserID(mentioned.User.ID)
+			if id == tc.selfID {
+				return "@" + tc.botName
+			}
+			if name := tc.canonicalName(id); name != "" {
+				return "@" + name
+			}
+		}
+		if mentioned.Application != nil && strings.EqualFold(mentioned.Application.ID, tc.appID) {
+			return "@" + tc.botName
+		}
+	}
+	return text
+}
+
+func attr(tok html.Token, key string) string {
+	for _, a := range tok.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func isBlock(tok html.Token) bool {
+	switch tok.DataAtom {
+	case atom.P, atom.Div, atom.Pre, atom.Li, atom.Ul, atom.Ol, atom.Blockquote, atom.Tr, atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		return true
+	}
+	return tok.Data == "codeblock"
+}
@@ -0,0 +1,169 @@
+package teams
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const (
+	// Graph caps chat message subscriptions at an hour.
+	subscriptionLifetime = 60 * time.Minute
+	renewInterval        = 45 * time.Minute
+	subscriptionRetry    = time.Minute
+)
+
+// subscriptionResources lists the Graph resources the robot subscribes to:
+// the channel messages of each configured team, and optionally every chat
+// the robot's account is in.
+func (tc *teamsConnector) subscriptionResources() []string {
+	resources := make([]string, 0, len(tc.teams)+1)
+	for _, team := range tc.teams {
+		resources = append(resources, "/teams/"+team+"/channels/getAllMessages")
+	}
+	if tc.directMessages {
+		resources = append(resources, "/users/"+tc.selfID+"/chats/getAllMessages")
+	}
+	return resources
+}
+
+func resourceKey(resource string) string {
+	return strings.ToLower(strings.TrimPrefix(resource, "/"))
+}
+
+// manageSubscriptions keeps the robot's subscriptions alive until ctx is
+// cancelled.
+func (tc *teamsConnector) manageSubscriptions(ctx context.Context) {
+	err := tc.ensureSubscriptions(ctx)
+	for {
+		wait := renewInterval
+		if err != nil {
+			tc.Log(robot.Error, "Teams: unable to set up Graph subscriptions, retrying in %s: %v", subscriptionRetry, err)
+			wait = subscriptionRetry
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		err = tc.renewSubscriptions(ctx)
+	}
+}
+
+// ensureSubscriptions reuses the app's existing subscriptions that deliver
+// to the robot's event hub, renewing them, and creates any that are
+// missing.
+func (tc *teamsConnector) ensureSubscriptions(ctx context.Context) error {
+	lctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	existing, err := tc.graph.listSubscriptions(lctx)
+	cancel()
+	if err != nil {
+		return err
+	}
+	tc.subMu.Lock()
+	for _, sub := range existing {
+		if sub.NotificationURL != tc.notificationURL {
+			continue
+		}
+		for _, resource := range tc.subscriptionResources() {
+			if resourceKey(sub.Resource) == resourceKey(resource) {
+				sub.Resource = resource
+				tc.subscriptions[resource] = sub
+			}
+		}
+	}
+	tc.subMu.Unlock()
+	return tc.renewSubscriptions(ctx)
+}
+
+// renewSubscriptions extends each subscription, creating any the robot
+// doesn't have.
+func (tc *teamsConnector) renewSubscriptions(ctx context.Context) error {
+	var errs []error
+	for _, resource := range tc.subscriptionResources() {
+		if err := tc.renewSubscription(ctx, resource); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// renewSubscription extends the subscription to a resource, creating it if
+// there isn't one or Graph has removed it.
+func (tc *teamsConnector) renewSubscription(ctx context.Context, resource string) error {
+	tc.subMu.Lock()
+	sub, ok := tc.subscriptions[resource]
+	tc.subMu.Unlock()
+	if !ok {
+		return tc.createSubscription(ctx, resource)
+	}
+	expires := time.Now().Add(subscriptionLifetime).UTC()
+	rctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	err := tc.graph.renewSubscription(rctx, sub.ID, expires)
+	cancel()
+	if isNotFound(err) {
+		tc.Log(robot.Warn, "Teams: subscription %s to %s is gone, creating a new one", sub.ID, resource)
+		return tc.createSubscription(ctx, resource)
+	}
+	if err != nil {
+		return err
+	}
+	sub.ExpirationDateTime = expires
+	tc.subMu.Lock()
+	tc.subscriptions[resource] = sub
+	tc.subMu.Unlock()
+	return nil
+}
+
+func (tc *teamsConnector) createSubscription(ctx context.Context, resource string) error {
+	cctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+	created, err := tc.graph.createSubscription(cctx, &subscription{
+		Resource:                 resource,
+		ChangeType:               "created",
+		NotificationURL:          tc.notificationURL,
+		LifecycleNotificationURL: tc.notificationURL,
+		ClientState:              tc.clientState,
+		ExpirationDateTime:       time.Now().Add(subscriptionLifetime).UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	created.Resource = resource
+	tc.subMu.Lock()
+	tc.subscriptions[resource] = *created
+	tc.subMu.Unlock()
+	tc.Log(robot.Info, "Teams: subscribed to %s (%s)", resource, created.ID)
+	return nil
+}
+
+// handleLifecycle responds to Graph's lifecycle notifications: renewing a
+// subscription that needs reauthorizing, and replacing one Graph removed.
+func (tc *teamsConnector) handleLifecycle(ctx context.Context, n *notification) {
+	tc.subMu.Lock()
+	resource := ""
+	for res, sub := range tc.subscriptions {
+		if sub.ID == n.SubscriptionID {
+			resource = res
+		}
+	}
+	if resource != "" && n.LifecycleEvent == "subscriptionRemoved" {
+		delete(tc.subscriptions, resource)
+	}
+	tc.subMu.Unlock()
+	if resource == "" {
+		tc.Log(robot.Debug, "Teams: ignoring %s for unknown subscription %s", n.LifecycleEvent, n.SubscriptionID)
+		return
+	}
+	switch n.LifecycleEvent {
+	case "reauthorizationRequired", "subscriptionRemoved":
+		if err := tc.renewSubscription(ctx, resource); err != nil {
+			tc.Log(robot.Error, "Teams: unable to restore subscription to %s after %s: %v", resource, n.LifecycleEvent, err)
+		}
+	case "missed":
+		tc.Log(robot.Warn, "Teams: Graph reports missed notifications for %s", resource)
+	}
+}
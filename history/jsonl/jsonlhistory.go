@@ -0,0 +1,362 @@
+// Package jsonlhistory is a file-backed history provider that writes each
+// run as JSON lines, so histories can be searched across runs.
+package jsonlhistory
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+var handler robot.Handler
+
+// textTimeFormat matches the log.LstdFlags timestamps of the file provider,
+// for GetLog
+const textTimeFormat = "2006/01/02 15:04:05"
+
+// maxLineSize bounds a single JSON line when reading logs back
+const maxLineSize = 1024 * 1024
+
+type historyConfig struct {
+	Directory string `yaml:"Directory"` // path to histories
+	URLPrefix string `yaml:"URLPrefix"` // Optional URL prefix corresponding to the Directory
+	// If LocalPort set, passed to http.ListenAndServe to serve static files
+	LocalPort string `yaml:"LocalPort"`
+}
+
+type histref struct {
+	name string
+	idx  int
+}
+
+var current = struct {
+	running map[histref]bool
+	sync.Mutex
+}{
+	make(map[histref]bool),
+	sync.Mutex{},
+}
+
+type historyFile struct {
+	sync.Mutex
+	enc      *json.Encoder
+	f        *os.File
+	pipeline string
+	name     string
+	idx      int
+	task     string
+	path     string
+	keep     bool
+	closed   bool
+}
+
+// splitLine separates the stream and level prefixes the engine adds:
+// "OUT <line>", "ERR <line>" or "LOG <Level>: <msg>".
+func splitLine(line string) (stream, level, text string) {
+	switch {
+	case strings.HasPrefix(line, "OUT "), strings.HasPrefix(line, "ERR "):
+		return line[:3], "", line[4:]
+	case line == "OUT", line == "ERR":
+		return line, "", ""
+	case strings.HasPrefix(line, "LOG "):
+		rest := line[4:]
+		if i := strings.Index(rest, ": "); i > 0 && !strings.Contains(rest[:i], " ") {
+			return "LOG", rest[:i], rest[i+2:]
+		}
+		return "LOG", "", rest
+	}
+	return "", "", line
+}
+
+func (hf *historyFile) write(stream, level, text string) {
+	hf.Lock()
+	defer hf.Unlock()
+	if hf.closed {
+		return
+	}
+	entry := robot.HistoryEntry{
+		Time:     time.Now(),
+		Stream:   stream,
+		Level:    level,
+		Task:     hf.task,
+		Pipeline: hf.pipeline,
+		Run:      hf.idx,
+		Line:     text,
+	}
+	if err := hf.enc.Encode(&entry); err != nil {
+		handler.Log(robot.Error, "Writing history '%s': %v", hf.path, err)
+	}
+}
+
+// Log records a line of task output or a robot log message
+func (hf *historyFile) Log(line string) {
+	stream, level, text := splitLine(line)
+	if stream == "" {
+		stream = "OUT"
+	}
+	hf.write(stream, level, text)
+}
+
+// Line records a section line, which has no stream
+func (hf *historyFile) Line(line string) {
+	hf.write("", "", line)
+}
+
+// SetTask sets the task recorded with subsequent lines
+func (hf *historyFile) SetTask(name string) {
+	hf.Lock()
+	hf.task = name
+	hf.Unlock()
+}
+
+// Close closes the log file against further writes
+func (hf *historyFile) Close() {
+	hf.Lock()
+	defer hf.Unlock()
+	if hf.closed {
+		return
+	}
+	hf.closed = true
+	hf.f.Close()
+}
+
+// Finalize removes the log if needed
+func (hf *historyFile) Finalize() {
+	hr := histref{hf.name, hf.idx}
+	current.Lock()
+	delete(current.running, hr)
+	current.Unlock()
+	if hf.keep {
+		return
+	}
+	if rerr := os.Remove(hf.path); rerr != nil {
+		handler.Log(robot.Error, "Removing %s: %v", hf.path, rerr)
+	}
+}
+
+var jhc historyConfig
+
+func tagDir(tag string) string {
+	tag = strings.Replace(tag, `\`, ":", -1)
+	return strings.Replace(tag, `/`, ":", -1)
+}
+
+func runFile(index int) string {
+	return fmt.Sprintf("run-%d.jsonl", index)
+}
+
+// NewLog initializes and returns a historyFile, as well as cleaning up old
+// logs.
+func (jhc *historyConfig) NewLog(tag string, index, maxHistories int) (robot.HistoryLogger, error) {
+	name := tagDir(tag)
+	dirPath := path.Join(jhc.Directory, name)
+	filePath := path.Join(dirPath, runFile(index))
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		return nil, fmt.Errorf("Error creating history directory '%s': %v", dirPath, err)
+	}
+	file, err := os.Create(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating history file '%s': %v", filePath, err)
+	}
+	keep := maxHistories != 0
+	hr := histref{name, index}
+	current.Lock()
+	current.running[hr] = keep
+	current.Unlock()
+	hf := &historyFile{
+		enc:      json.NewEncoder(file),
+		f:        file,
+		pipeline: tag,
+		name:     name,
+		idx:      index,
+		path:     filePath,
+		keep:     keep,
+	}
+	if maxHistories > 0 {
+		for i := index - maxHistories; i >= 0; i-- {
+			rmPath := path.Join(dirPath, runFile(i))
+			_, err := os.Stat(rmPath)
+			if err != nil {
+				break
+			}
+			rerr := os.Remove(rmPath)
+			if rerr != nil {
+				handler.Log(robot.Error, "Error removing old log file '%s': %v", rmPath, rerr)
+				// assume it's pointless to keep trying to delete files
+				break
+			}
+		}
+	}
+	return hf, nil
+}
+
+// readEntries calls fn for each readable entry in a log file; a partially
+// written last line of a running log is skipped.
+func readEntries(filePath string, fn func(robot.HistoryEntry)) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		var entry robot.HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		fn(entry)
+	}
+	return scanner.Err()
+}
+
+// formatEntry renders an entry the way the file provider would have
+// written it.
+func formatEntry(e robot.HistoryEntry) string {
+	switch e.Stream {
+	case "":
+		return e.Line
+	case "LOG":
+		if e.Level != "" {
+			return fmt.Sprintf("%s LOG %s: %s", e.Time.Format(textTimeFormat), e.Level, e.Line)
+		}
+	}
+	return fmt.Sprintf("%s %s %s", e.Time.Format(textTimeFormat), e.Stream, e.Line)
+}
+
+// GetLog returns an io.Reader with the log rendered as plain text
+func (jhc *historyConfig) GetLog(tag string, index int) (io.Reader, error) {
+	filePath := path.Join(jhc.Directory, tagDir(tag), runFile(index))
+	var buf bytes.Buffer
+	err := readEntries(filePath, func(e robot.HistoryEntry) {
+		buf.WriteString(formatEntry(e))
+		buf.WriteByte('\n')
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// GetLogURL returns the permanent link to the history
+func (jhc *historyConfig) GetLogURL(tag string, index int) (string, bool) {
+	if len(jhc.URLPrefix) == 0 {
+		return "", false
+	}
+	hr := histref{tagDir(tag), index}
+	current.Lock()
+	keep, ok := current.running[hr]
+	current.Unlock()
+	if ok && !keep {
+		return "", false
+	}
+	prefix := strings.TrimRight(jhc.URLPrefix, "/")
+	return fmt.Sprintf("%s/%s/%s", prefix, tagDir(tag), runFile(index)), true
+}
+
+// MakeLogURL publishes a history to a URL and returns the URL
+func (jhc *historyConfig) MakeLogURL(tag string, index int) (string, bool) {
+	return "", false
+}
+
+func entryMatches(e robot.HistoryEntry, q robot.HistoryQuery, contains string) bool {
+	if q.Task != "" && e.Task != q.Task {
+		return false
+	}
+	if q.Stream != "" && !strings.EqualFold(e.Stream, q.Stream) {
+		return false
+	}
+	if q.Level != "" && !strings.EqualFold(e.Level, q.Level) {
+		return false
+	}
+	if !q.Since.IsZero() && e.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && e.Time.After(q.Until) {
+		return false
+	}
+	if contains != "" && !strings.Contains(strings.ToLower(e.Line), contains) {
+		return false
+	}
+	return true
+}
+
+// SearchLogs scans retained logs for matching entries, skipping files last
+// written before q.Since.
+func (jhc *historyConfig) SearchLogs(q robot.HistoryQuery) ([]robot.HistoryEntry, error) {
+	dirs, err := os.ReadDir(jhc.Directory)
+	if err != nil {
+		return nil, err
+	}
+	pipeline := tagDir(q.Pipeline)
+	contains := strings.ToLower(q.Contains)
+	var found []robot.HistoryEntry
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		if pipeline != "" && d.Name() != pipeline && !strings.HasPrefix(d.Name(), pipeline+":") {
+			continue
+		}
+		files, err := filepath.Glob(filepath.Join(jhc.Directory, d.Name(), "run-*.jsonl"))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if !q.Since.IsZero() {
+				if info, err := os.Stat(file); err != nil || info.ModTime().Before(q.Since) {
+					continue
+				}
+			}
+			err := readEntries(file, func(e robot.HistoryEntry) {
+				if entryMatches(e, q, contains) {
+					found = append(found, e)
+				}
+			})
+			if err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].Time.Before(found[j].Time) })
+	if q.Limit > 0 && len(found) > q.Limit {
+		found = found[len(found)-q.Limit:]
+	}
+	return found, nil
+}
+
+func provider(r robot.Handler) robot.HistoryProvider {
+	handler = r
+	handler.GetHistoryConfig(&jhc)
+	if len(jhc.Directory) == 0 {
+		handler.Log(robot.Error, "HistoryConfig missing value for Directory required by 'jsonl' history provider")
+		return nil
+	}
+	if err := r.GetDirectory(jhc.Directory); err != nil {
+		handler.Log(robot.Error, "Checking history directory '%s': %v", jhc.Directory, err)
+		return nil
+	}
+	if len(jhc.LocalPort) > 0 {
+		go func() {
+			handler.Log(robot.Info, "Starting fileserver listener for jsonl history provider")
+			log.Fatal(http.ListenAndServe(jhc.LocalPort, http.FileServer(http.Dir(jhc.Directory))))
+		}()
+		handler.Log(robot.Info, "Initialized jsonl history provider with directory: '%s'; serving on: '%s'", jhc.Directory, jhc.LocalPort)
+	} else {
+		handler.Log(robot.Info, "Initialized jsonl history provider with directory: '%s'", jhc.Directory)
+	}
+	return &jhc
+}
@@ -0,0 +1,95 @@
+package jsonlhistory
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+func TestSplitLine(t *testing.T) {
+	for _, tc := range []struct{ in, stream, level, text string }{
+		{"OUT hello world", "OUT", "", "hello world"},
+		{"ERR", "ERR", "", ""},
+		{"LOG Warn: (deploy) disk nearly full", "LOG", "Warn", "(deploy) disk nearly full"},
+		{"*** deploy - Starting task 'deploy'", "", "", "*** deploy - Starting task 'deploy'"},
+	} {
+		stream, level, text := splitLine(tc.in)
+		if stream != tc.stream || level != tc.level || text != tc.text {
+			t.Errorf("splitLine(%q) = %q, %q, %q", tc.in, stream, level, text)
+		}
+	}
+}
+
+func writeRun(t *testing.T, c *historyConfig, tag string, idx int, task string, lines ...string) {
+	t.Helper()
+	hl, err := c.NewLog(tag, idx, 10)
+	if err != nil {
+		t.Fatalf("NewLog(%s, %d): %v", tag, idx, err)
+	}
+	hl.(robot.TaskHistoryLogger).SetTask(task)
+	hl.Line("*** " + task + " - Starting task '" + task + "'")
+	for _, line := range lines {
+		hl.Log(line)
+	}
+	hl.Close()
+	hl.Finalize()
+}
+
+func TestSearchLogsAcrossRuns(t *testing.T) {
+	c := &historyConfig{Directory: t.TempDir()}
+	writeRun(t, c, "backup", 0, "backup", "OUT starting", "ERR disk full")
+	writeRun(t, c, "backup", 1, "upload", "ERR Timeout talking to s3", "LOG Error: upload failed")
+	writeRun(t, c, "backup:main", 0, "backup", "ERR branch error")
+	writeRun(t, c, "backupother", 0, "backup", "ERR not this job")
+
+	errs, err := c.SearchLogs(robot.HistoryQuery{Pipeline: "backup", Stream: "ERR"})
+	if err != nil {
+		t.Fatalf("SearchLogs: %v", err)
+	}
+	if len(errs) != 3 {
+		t.Fatalf("found %d ERR lines, want 3: %+v", len(errs), errs)
+	}
+	for _, e := range errs {
+		if e.Line == "not this job" {
+			t.Fatal("bare job name matched a different job")
+		}
+	}
+	if errs[1].Task != "upload" || errs[1].Run != 1 || errs[1].Pipeline != "backup" {
+		t.Fatalf("entry = %+v", errs[1])
+	}
+
+	found, _ := c.SearchLogs(robot.HistoryQuery{Pipeline: "backup", Contains: "timeout"})
+	if len(found) != 1 || found[0].Stream != "ERR" {
+		t.Fatalf("Contains search = %+v", found)
+	}
+	found, _ = c.SearchLogs(robot.HistoryQuery{Level: "error"})
+	if len(found) != 1 || found[0].Line != "upload failed" {
+		t.Fatalf("Level search = %+v", found)
+	}
+	found, _ = c.SearchLogs(robot.HistoryQuery{Pipeline: "backup", Stream: "err", Limit: 2})
+	if len(found) != 2 || found[0].Line != "Timeout talking to s3" || found[1].Line != "branch error" {
+		t.Fatalf("Limit should keep the most recent entries: %+v", found)
+	}
+	found, _ = c.SearchLogs(robot.HistoryQuery{Since: time.Now().Add(time.Hour)})
+	if len(found) != 0 {
+		t.Fatalf("Since in the future matched %d entries", len(found))
+	}
+}
+
+func TestGetLogRendersText(t *testing.T) {
+	c := &historyConfig{Directory: t.TempDir()}
+	writeRun(t, c, "deploy", 3, "deploy", "OUT ok", "LOG Info: done")
+	r, err := c.GetLog("deploy", 3)
+	if err != nil {
+		t.Fatalf("GetLog: %v", err)
+	}
+	out, _ := io.ReadAll(r)
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) != 3 || lines[0] != "*** deploy - Starting task 'deploy'" ||
+		!strings.HasSuffix(lines[1], " OUT ok") || !strings.HasSuffix(lines[2], " LOG Info: done") {
+		t.Fatalf("GetLog output:\n%s", out)
+	}
+}
@@ -0,0 +1,7 @@
+package jsonlhistory
+
+import "github.com/lnxjedi/gopherbot/robot"
+
+func init() {
+	robot.RegisterHistoryProvider("jsonl", provider)
+}
@@ -0,0 +1,335 @@
+// Package s3history stores job and plugin histories in an S3-compatible
+// bucket. Logs are written to a local staging file while the pipeline runs
+// and uploaded when it finishes; links are presigned URLs, so no local web
+// server is needed.
+package s3history
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+	"github.com/lnxjedi/gopherbot/v2/internal/s3compat"
+)
+
+var handler robot.Handler
+
+const logFlags = log.LstdFlags
+
+// maxPresignExpiry is the SigV4 limit for presigned URLs.
+const maxPresignExpiry = 7 * 24 * time.Hour
+
+type historyConfig struct {
+	Endpoint         string
+	Region           string
+	Bucket           string
+	Prefix           string
+	VirtualHostStyle bool
+	AccessKeyID      string
+	SecretAccessKey  string
+	SessionToken     string
+	// Directory holds logs for running pipelines until they're uploaded
+	Directory               string
+	OperationTimeoutSeconds int
+	// LinkExpiryHours is the lifetime of the link given when a job starts
+	LinkExpiryHours int
+	// URLExpirySeconds is the lifetime of links made on request, e.g. 'link log'
+	URLExpirySeconds int
+}
+
+type s3History struct {
+	cfg    historyConfig
+	client *s3compat.Client
+}
+
+type histref struct {
+	name string
+	idx  int
+}
+
+var current = struct {
+	running map[histref]bool
+	sync.Mutex
+}{
+	make(map[histref]bool),
+	sync.Mutex{},
+}
+
+type historyFile struct {
+	h    *s3History
+	l    *log.Logger
+	f    *os.File
+	name string
+	idx  int
+	path string
+	keep bool
+	max  int
+}
+
+// Log takes a line of text and stores it in the staging file
+func (hf *historyFile) Log(line string) {
+	hf.l.Println(line)
+}
+
+// Line adds a line without a timestamp
+func (hf *historyFile) Line(line string) {
+	hf.l.SetFlags(0)
+	hf.l.Println(line)
+	hf.l.SetFlags(logFlags)
+}
+
+// Close sets the logger output to discard and closes the staging file
+func (hf *historyFile) Close() {
+	hf.l.SetOutput(io.Discard)
+	hf.f.Close()
+}
+
+// Finalize uploads a log that should be kept, deletes stored runs beyond
+// maxHistories, then removes the staging file. If the upload fails the
+// staging file is left in place for the operator.
+func (hf *historyFile) Finalize() {
+	current.Lock()
+	delete(current.running, histref{hf.name, hf.idx})
+	current.Unlock()
+	if hf.keep {
+		if err := hf.h.upload(hf.name, hf.idx, hf.path); err != nil {
+			handler.Log(robot.Error, "Uploading history '%s' to bucket '%s': %v; leaving local copy", hf.path, hf.h.cfg.Bucket, err)
+			return
+		}
+		if hf.max > 0 {
+			if err := hf.h.prune(hf.name, hf.max); err != nil {
+				handler.Log(robot.Error, "Removing old histories for '%s' from bucket '%s': %v", hf.name, hf.h.cfg.Bucket, err)
+			}
+		}
+	}
+	if rerr := os.Remove(hf.path); rerr != nil {
+		handler.Log(robot.Error, "Removing %s: %v", hf.path, rerr)
+	}
+}
+
+func defaultedConfig(cfg historyConfig) historyConfig {
+	cfg.Endpoint = strings.TrimSpace(cfg.Endpoint)
+	cfg.Bucket = strings.TrimSpace(cfg.Bucket)
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = "gopherbot-history/"
+	}
+	if cfg.Directory == "" {
+		cfg.Directory = "history"
+	}
+	if cfg.OperationTimeoutSeconds <= 0 {
+		cfg.OperationTimeoutSeconds = 30
+	}
+	if cfg.LinkExpiryHours <= 0 || time.Duration(cfg.LinkExpiryHours)*time.Hour > maxPresignExpiry {
+		cfg.LinkExpiryHours = int(maxPresignExpiry / time.Hour)
+	}
+	if cfg.URLExpirySeconds <= 0 {
+		cfg.URLExpirySeconds = 600
+	}
+	if cfg.AccessKeyID == "" && cfg.SecretAccessKey == "" {
+		creds := s3compat.CredentialsFromEnv()
+		cfg.AccessKeyID = creds.AccessKeyID
+		cfg.SecretAccessKey = creds.SecretAccessKey
+		cfg.SessionToken = creds.SessionToken
+	}
+	return cfg
+}
+
+func newS3History(cfg historyConfig) (*s3History, error) {
+	client, err := s3compat.New(s3compat.Config{
+		Endpoint:         cfg.Endpoint,
+		Region:           cfg.Region,
+		Bucket:           cfg.Bucket,
+		VirtualHostStyle: cfg.VirtualHostStyle,
+		Credentials: s3compat.Credentials{
+			AccessKeyID:     cfg.AccessKeyID,
+			SecretAccessKey: cfg.SecretAccessKey,
+			SessionToken:    cfg.SessionToken,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s3History{cfg: cfg, client: client}, nil
+}
+
+func (h *s3History) opContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), time.Duration(h.cfg.OperationTimeoutSeconds)*time.Second)
+}
+
+func tagName(tag string) string {
+	tag = strings.Replace(tag, `\`, ":", -1)
+	return strings.Replace(tag, `/`, ":", -1)
+}
+
+func runFile(index int) string {
+	return fmt.Sprintf("run-%d.log", index)
+}
+
+func (h *s3History) objectKey(name string, index int) string {
+	return h.cfg.Prefix + name + "/" + runFile(index)
+}
+
+func (h *s3History) stagingPath(name string, index int) string {
+	return path.Join(h.cfg.Directory, name, runFile(index))
+}
+
+func (h *s3History) upload(name string, index int, filePath string) error {
+	body, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := h.opContext()
+	defer cancel()
+	_, err = h.client.PutObject(ctx, h.objectKey(name, index), body, s3compat.PutOptions{
+		ContentType: "text/plain; charset=utf-8",
+	})
+	return err
+}
+
+// prune deletes all but the newest keep stored runs for a tag. Runs are
+// ordered by upload time rather than index, since run indexes wrap.
+func (h *s3History) prune(name string, keep int) error {
+	ctx, cancel := h.opContext()
+	defer cancel()
+	prefix := h.cfg.Prefix + name + "/run-"
+	var objects []s3compat.Object
+	token := ""
+	for {
+		page, err := h.client.ListObjects(ctx, prefix, token, 1000)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, page.Objects...)
+		if page.NextContinuationToken == "" {
+			break
+		}
+		token = page.NextContinuationToken
+	}
+	if len(objects) <= keep {
+		return nil
+	}
+	sort.SliceStable(objects, func(i, j int) bool {
+		return objects[i].LastModified.After(objects[j].LastModified)
+	})
+	for _, obj := range objects[keep:] {
+		if err := h.client.DeleteObject(ctx, obj.Key); err != nil {
+			return fmt.Errorf("deleting '%s': %w", obj.Key, err)
+		}
+	}
+	return nil
+}
+
+// NewLog starts a staging file for the run. It doesn't touch the bucket, so
+// starting a pipeline stays cheap; old runs are pruned in Finalize.
+func (h *s3History) NewLog(tag string, index, maxHistories int) (robot.HistoryLogger, error) {
+	name := tagName(tag)
+	filePath := h.stagingPath(name, index)
+	dirPath := path.Dir(filePath)
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		return nil, fmt.Errorf("Error creating history staging directory '%s': %v", dirPath, err)
+	}
+	file, err := os.Create(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating history staging file '%s': %v", filePath, err)
+	}
+	keep := maxHistories != 0
+	current.Lock()
+	current.running[histref{name, index}] = keep
+	current.Unlock()
+	return &historyFile{
+		h:    h,
+		l:    log.New(file, "", logFlags),
+		f:    file,
+		name: name,
+		idx:  index,
+		path: filePath,
+		keep: keep,
+		max:  maxHistories,
+	}, nil
+}
+
+// GetLog returns the staging file for a running pipeline, or the stored
+// object once it has been uploaded.
+func (h *s3History) GetLog(tag string, index int) (io.Reader, error) {
+	name := tagName(tag)
+	if f, err := os.Open(h.stagingPath(name, index)); err == nil {
+		return f, nil
+	}
+	ctx, cancel := h.opContext()
+	defer cancel()
+	obj, exists, err := h.client.GetObject(ctx, h.objectKey(name, index))
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("history '%s' run %d not found", tag, index)
+	}
+	return bytes.NewReader(obj.Body), nil
+}
+
+func (h *s3History) presign(tag string, index int, expires time.Duration) (string, bool) {
+	name := tagName(tag)
+	current.Lock()
+	keep, running := current.running[histref{name, index}]
+	current.Unlock()
+	if running && !keep {
+		return "", false
+	}
+	url, err := h.client.PresignGetObject(h.objectKey(name, index), expires)
+	if err != nil {
+		handler.Log(robot.Error, "Presigning history URL for '%s', run %d: %v", tag, index, err)
+		return "", false
+	}
+	return url, true
+}
+
+// GetLogURL returns a presigned link that lasts LinkExpiryHours. It's
+// handed out when a run starts, and works once the run has finished and
+// its log has been uploaded.
+func (h *s3History) GetLogURL(tag string, index int) (string, bool) {
+	return h.presign(tag, index, time.Duration(h.cfg.LinkExpiryHours)*time.Hour)
+}
+
+// MakeLogURL returns a presigned link that lasts URLExpirySeconds.
+func (h *s3History) MakeLogURL(tag string, index int) (string, bool) {
+	return h.presign(tag, index, time.Duration(h.cfg.URLExpirySeconds)*time.Second)
+}
+
+func provider(r robot.Handler) robot.HistoryProvider {
+	handler = r
+	var cfg historyConfig
+	if err := r.GetHistoryConfig(&cfg); err != nil {
+		r.Log(robot.Error, "Unable to retrieve S3 history configuration: %v", err)
+		return nil
+	}
+	cfg = defaultedConfig(cfg)
+	h, err := newS3History(cfg)
+	if err != nil {
+		r.Log(robot.Error, "Invalid S3 history configuration: %v", err)
+		return nil
+	}
+	if err := r.GetDirectory(cfg.Directory); err != nil {
+		r.Log(robot.Error, "Checking history staging directory '%s': %v", cfg.Directory, err)
+		return nil
+	}
+	ctx, cancel := h.opContext()
+	defer cancel()
+	if _, err := h.client.ListObjects(ctx, cfg.Prefix, "", 1); err != nil {
+		r.Log(robot.Error, "Checking S3 history bucket '%s' at '%s': %v", cfg.Bucket, cfg.Endpoint, err)
+		return nil
+	}
+	r.Log(robot.Info, "Initialized S3 history provider with bucket '%s' at '%s', prefix '%s'", cfg.Bucket, cfg.Endpoint, cfg.Prefix)
+	return h
+}
@@ -0,0 +1,133 @@
+package s3history
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/v2/internal/s3compat/s3test"
+)
+
+const testAccessKey = "minioadmin"
+
+func newTestHistory(t *testing.T) (*s3History, *s3test.Server) {
+	t.Helper()
+	server := s3test.NewServer(testAccessKey, "robot")
+	t.Cleanup(server.Close)
+	h, err := newS3History(defaultedConfig(historyConfig{
+		Endpoint:        server.URL,
+		Bucket:          "robot",
+		Prefix:          "logs/",
+		AccessKeyID:     testAccessKey,
+		SecretAccessKey: "minioadmin-secret",
+		Directory:       t.TempDir(),
+	}))
+	if err != nil {
+		t.Fatalf("newS3History() error = %v", err)
+	}
+	return h, server
+}
+
+func runJob(t *testing.T, h *s3History, tag string, index, keep int, line string) {
+	t.Helper()
+	hl, err := h.NewLog(tag, index, keep)
+	if err != nil {
+		t.Fatalf("NewLog(%s, %d) error = %v", tag, index, err)
+	}
+	hl.Line("*** " + tag)
+	hl.Log(line)
+	hl.Close()
+	hl.Finalize()
+}
+
+func TestS3HistoryUploadsFinalizedLogs(t *testing.T) {
+	h, server := newTestHistory(t)
+	hl, err := h.NewLog("deploy/main", 4, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hl.Log("OUT deploying")
+	if keys := server.Keys("robot"); len(keys) != 0 {
+		t.Fatalf("log uploaded before the run finished: %v", keys)
+	}
+	if n := server.RequestCount(http.MethodGet); n != 0 {
+		t.Fatalf("NewLog() made %d bucket request(s); pruning belongs in Finalize", n)
+	}
+	r, err := h.GetLog("deploy/main", 4)
+	if err != nil {
+		t.Fatalf("GetLog() of running pipeline error = %v", err)
+	}
+	if out, _ := io.ReadAll(r); !strings.Contains(string(out), "OUT deploying") {
+		t.Fatalf("running log = %q", out)
+	}
+	hl.Close()
+	hl.Finalize()
+
+	if keys := server.Keys("robot"); len(keys) != 1 || keys[0] != "logs/deploy:main/run-4.log" {
+		t.Fatalf("stored keys = %v", keys)
+	}
+	if _, err := os.Stat(h.stagingPath("deploy:main", 4)); !os.IsNotExist(err) {
+		t.Fatalf("staging file not removed: %v", err)
+	}
+	r, err = h.GetLog("deploy/main", 4)
+	if err != nil {
+		t.Fatalf("GetLog() from bucket error = %v", err)
+	}
+	if out, _ := io.ReadAll(r); !strings.HasSuffix(string(out), "OUT deploying\n") {
+		t.Fatalf("stored log = %q", out)
+	}
+
+	url, ok := h.MakeLogURL("deploy/main", 4)
+	if !ok || !strings.Contains(url, "/robot/logs/deploy%3Amain/run-4.log?") || !strings.Contains(url, "X-Amz-Expires=600") {
+		t.Fatalf("MakeLogURL() = %q, %v", url, ok)
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "OUT deploying") {
+		t.Fatalf("GET presigned URL = %d %q", resp.StatusCode, body)
+	}
+	if url, ok := h.GetLogURL("deploy/main", 4); !ok || !strings.Contains(url, "X-Amz-Expires=604800") {
+		t.Fatalf("GetLogURL() = %q, %v", url, ok)
+	}
+}
+
+func TestS3HistoryKeepLogsRetention(t *testing.T) {
+	h, server := newTestHistory(t)
+	base := time.Now().Add(-time.Hour)
+	for i := 0; i < 3; i++ {
+		runJob(t, h, "backup", i, 5, "OUT run")
+		server.SetModified("robot", h.objectKey("backup", i), base.Add(time.Duration(i)*time.Minute))
+	}
+	runJob(t, h, "other", 0, 5, "OUT other")
+	// Index 0 wraps around; retention goes by age, not index.
+	runJob(t, h, "backup", 0, 2, "OUT wrapped")
+	want := []string{"logs/backup/run-0.log", "logs/backup/run-2.log", "logs/other/run-0.log"}
+	if keys := server.Keys("robot"); strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Fatalf("stored keys = %v, want %v", keys, want)
+	}
+}
+
+func TestS3HistoryDiscardsUnkeptLogs(t *testing.T) {
+	h, server := newTestHistory(t)
+	hl, err := h.NewLog("chatops", 7, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := h.GetLogURL("chatops", 7); ok {
+		t.Fatal("GetLogURL() returned a link for a log that won't be kept")
+	}
+	hl.Close()
+	hl.Finalize()
+	if keys := server.Keys("robot"); len(keys) != 0 {
+		t.Fatalf("unkept log uploaded: %v", keys)
+	}
+	if _, err := os.Stat(h.stagingPath("chatops", 7)); !os.IsNotExist(err) {
+		t.Fatalf("staging file not removed: %v", err)
+	}
+}
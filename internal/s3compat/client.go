@@ -0,0 +1,350 @@
+// Package s3compat is a small S3 API client covering the object operations
+// gopherbot needs (get, head, conditional put, delete, ListObjectsV2 and
+// presigned GETs). It signs with SigV4 and works against AWS S3 and
+// S3-compatible stores such as MinIO, Ceph RGW and Cloudflare R2.
+package s3compat
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrPreconditionFailed is returned by PutObject when an If-Match or
+// If-None-Match condition does not hold.
+var ErrPreconditionFailed = errors.New("s3 precondition failed")
+
+// Credentials are static SigV4 credentials.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// Config describes a bucket endpoint. Endpoint is the service base URL, e.g.
+// "https://s3.us-east-1.amazonaws.com" or "http://minio.local:9000".
+// Path-style addressing is used unless VirtualHostStyle is set.
+type Config struct {
+	Endpoint         string
+	Region           string
+	Bucket           string
+	VirtualHostStyle bool
+	Credentials      Credentials
+	HTTPClient       *http.Client
+}
+
+// Client issues signed requests against one bucket.
+type Client struct {
+	endpoint *url.URL
+	cfg      Config
+	http     *http.Client
+	now      func() time.Time
+}
+
+// Object describes a stored object. Metadata holds x-amz-meta-* headers with
+// the prefix removed and names lower-cased.
+type Object struct {
+	Key          string
+	ETag         string
+	Size         int64
+	LastModified time.Time
+	Metadata     map[string]string
+	Body         []byte
+}
+
+// PutOptions carries conditional-write and metadata settings for PutObject.
+// IfNoneMatch is normally "*" to create an object only if it is absent.
+type PutOptions struct {
+	ContentType string
+	IfMatch     string
+	IfNoneMatch string
+	Metadata    map[string]string
+}
+
+// ListPage is one page of a ListObjectsV2 listing.
+type ListPage struct {
+	Objects               []Object
+	NextContinuationToken string
+}
+
+// Error is a non-success response from the service.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("s3 error %d %s: %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("s3 error %d", e.StatusCode)
+}
+
+// CredentialsFromEnv returns credentials from the standard AWS_* variables.
+func CredentialsFromEnv() Credentials {
+	return Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+}
+
+// New validates cfg and returns a client.
+func New(cfg Config) (*Client, error) {
+	if strings.TrimSpace(cfg.Bucket) == "" {
+		return nil, fmt.Errorf("s3 bucket is required")
+	}
+	if strings.TrimSpace(cfg.Endpoint) == "" {
+		return nil, fmt.Errorf("s3 endpoint is required")
+	}
+	endpoint, err := url.Parse(strings.TrimRight(cfg.Endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("parsing s3 endpoint %q: %w", cfg.Endpoint, err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" || endpoint.Host == "" {
+		return nil, fmt.Errorf("s3 endpoint %q must be an http or https URL", cfg.Endpoint)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Credentials.AccessKeyID == "" || cfg.Credentials.SecretAccessKey == "" {
+		return nil, fmt.Errorf("s3 access key id and secret access key are required")
+	}
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 60 * time.Second}
+	}
+	return &Client{endpoint: endpoint, cfg: cfg, http: httpClient, now: time.Now}, nil
+}
+
+// Bucket returns the configured bucket name.
+func (c *Client) Bucket() string { return c.cfg.Bucket }
+
+// Endpoint returns the configured endpoint URL.
+func (c *Client) Endpoint() string { return c.endpoint.String() }
+
+func (c *Client) objectURL(key string) *url.URL {
+	u := *c.endpoint
+	if c.cfg.VirtualHostStyle {
+		u.Host = c.cfg.Bucket + "." + u.Host
+		u.Path = strings.TrimRight(u.Path, "/") + "/" + key
+	} else {
+		u.Path = strings.TrimRight(u.Path, "/") + "/" + c.cfg.Bucket + "/" + key
+	}
+	u.RawPath = uriEncode(u.Path, false)
+	return &u
+}
+
+func (c *Client) do(ctx context.Context, method string, u *url.URL, header http.Header, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		payloadHash = hashHex(body)
+	}
+	req.ContentLength = int64(len(body))
+	signRequest(req, c.cfg.Credentials, c.cfg.Region, payloadHash, c.now())
+	return c.http.Do(req)
+}
+
+// GetObject returns the object body and metadata; exists is false on 404.
+func (c *Client) GetObject(ctx context.Context, key string) (Object, bool, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.objectURL(key), nil, nil)
+	if err != nil {
+		return Object{}, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return Object{}, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Object{}, false, responseError(resp)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Object{}, false, err
+	}
+	obj := objectFromHeader(key, resp.Header)
+	obj.Body = body
+	obj.Size = int64(len(body))
+	return obj, true, nil
+}
+
+// HeadObject returns object metadata without the body; exists is false on 404.
+func (c *Client) HeadObject(ctx context.Context, key string) (Object, bool, error) {
+	resp, err := c.do(ctx, http.MethodHead, c.objectURL(key), nil, nil)
+	if err != nil {
+		return Object{}, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return Object{}, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Object{}, false, &Error{StatusCode: resp.StatusCode}
+	}
+	obj := objectFromHeader(key, resp.Header)
+	obj.Size = resp.ContentLength
+	return obj, true, nil
+}
+
+// PutObject stores body under key and returns the new ETag. Failed
+// conditions are reported as ErrPreconditionFailed.
+func (c *Client) PutObject(ctx context.Context, key string, body []byte, opts PutOptions) (string, error) {
+	header := make(http.Header)
+	contentType := opts.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header.Set("Content-Type", contentType)
+	if opts.IfMatch != "" {
+		header.Set("If-Match", opts.IfMatch)
+	}
+	if opts.IfNoneMatch != "" {
+		header.Set("If-None-Match", opts.IfNoneMatch)
+	}
+	for name, value := range opts.Metadata {
+		header.Set("X-Amz-Meta-"+name, value)
+	}
+	resp, err := c.do(ctx, http.MethodPut, c.objectURL(key), header, body)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return resp.Header.Get("ETag"), nil
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		// Some S3-compatible stores answer a lost conditional-write race
+		// with 409 ConditionalRequestConflict rather than 412.
+		serr := responseError(resp)
+		return "", fmt.Errorf("%w: %v", ErrPreconditionFailed, serr)
+	}
+	return "", responseError(resp)
+}
+
+// DeleteObject removes key; deleting a missing object is not an error.
+func (c *Client) DeleteObject(ctx context.Context, key string) error {
+	resp, err := c.do(ctx, http.MethodDelete, c.objectURL(key), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return responseError(resp)
+}
+
+type listBucketResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string `xml:"Key"`
+		ETag         string `xml:"ETag"`
+		Size         int64  `xml:"Size"`
+		LastModified string `xml:"LastModified"`
+	} `xml:"Contents"`
+}
+
+// ListObjects returns one ListObjectsV2 page of keys under prefix, in
+// lexical order. Pass the previous page's NextContinuationToken to continue.
+func (c *Client) ListObjects(ctx context.Context, prefix, continuationToken string, maxKeys int) (ListPage, error) {
+	u := c.objectURL("")
+	query := url.Values{}
+	query.Set("list-type", "2")
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	if continuationToken != "" {
+		query.Set("continuation-token", continuationToken)
+	}
+	if maxKeys > 0 {
+		query.Set("max-keys", strconv.Itoa(maxKeys))
+	}
+	u.RawQuery = canonicalQuery(query)
+	resp, err := c.do(ctx, http.MethodGet, u, nil, nil)
+	if err != nil {
+		return ListPage{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ListPage{}, responseError(resp)
+	}
+	var result listBucketResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return ListPage{}, fmt.Errorf("decoding s3 list response: %w", err)
+	}
+	page := ListPage{Objects: make([]Object, 0, len(result.Contents))}
+	for _, item := range result.Contents {
+		modified, _ := time.Parse(time.RFC3339Nano, item.LastModified)
+		page.Objects = append(page.Objects, Object{
+			Key:          item.Key,
+			ETag:         item.ETag,
+			Size:         item.Size,
+			LastModified: modified,
+		})
+	}
+	if result.IsTruncated {
+		page.NextContinuationToken = result.NextContinuationToken
+	}
+	return page, nil
+}
+
+// PresignGetObject returns a URL that allows an unauthenticated GET of key
+// until expires has elapsed. SigV4 limits expiry to seven days.
+func (c *Client) PresignGetObject(key string, expires time.Duration) (string, error) {
+	if expires <= 0 || expires > 7*24*time.Hour {
+		return "", fmt.Errorf("presigned URL expiry must be between 1s and 7 days, got %s", expires)
+	}
+	return presignURL(c.objectURL(key), c.cfg.Credentials, c.cfg.Region, expires, c.now()), nil
+}
+
+func objectFromHeader(key string, header http.Header) Object {
+	obj := Object{Key: key, ETag: header.Get("ETag"), Metadata: make(map[string]string)}
+	if modified, err := http.ParseTime(header.Get("Last-Modified")); err == nil {
+		obj.LastModified = modified
+	}
+	for name, values := range header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-meta-") && len(values) > 0 {
+			obj.Metadata[strings.TrimPrefix(lower, "x-amz-meta-")] = values[0]
+		}
+	}
+	return obj
+}
+
+type errorResponse struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	serr := &Error{StatusCode: resp.StatusCode}
+	var parsed errorResponse
+	if xml.Unmarshal(body, &parsed) == nil {
+		serr.Code = parsed.Code
+		serr.Message = parsed.Message
+	}
+	return serr
+}
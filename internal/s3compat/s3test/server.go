@@ -0,0 +1,285 @@
+// Package s3test provides an in-memory, MinIO-style S3 stand-in server for
+// tests. It implements path-style GetObject, HeadObject, PutObject (with
+// If-Match / If-None-Match), DeleteObject and ListObjectsV2, and checks that
+// each request carries SigV4 credentials for the expected access key. It
+// does not verify signatures.
+package s3test
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type object struct {
+	body     []byte
+	etag     string
+	modified time.Time
+	meta     http.Header
+}
+
+// Server is a running stand-in. URL is the endpoint to configure clients with.
+type Server struct {
+	URL         string
+	AccessKeyID string
+
+	srv *httptest.Server
+
+	mu      sync.Mutex
+	buckets map[string]map[string]*object
+	// BeforePut, when set, runs before each PutObject evaluates its
+	// conditions, letting tests inject a concurrent writer. It is called
+	// without the server lock held.
+	BeforePut func(bucket, key string)
+	requests  map[string]int
+}
+
+// NewServer starts a stand-in with the given (empty) buckets.
+func NewServer(accessKeyID string, buckets ...string) *Server {
+	s := &Server{
+		AccessKeyID: accessKeyID,
+		buckets:     make(map[string]map[string]*object),
+		requests:    make(map[string]int),
+	}
+	for _, bucket := range buckets {
+		s.buckets[bucket] = make(map[string]*object)
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close shuts the server down.
+func (s *Server) Close() { s.srv.Close() }
+
+// Keys returns the sorted object keys stored in bucket.
+func (s *Server) Keys(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.buckets[bucket]))
+	for key := range s.buckets[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Object returns a copy of the stored body for bucket/key.
+func (s *Server) Object(bucket, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.buckets[bucket][key]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), obj.body...), true
+}
+
+// RequestCount returns how many requests with method have been handled.
+func (s *Server) RequestCount(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[method]
+}
+
+// SetModified overrides an object's LastModified time.
+func (s *Server) SetModified(bucket, key string, modified time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if obj, ok := s.buckets[bucket][key]; ok {
+		obj.modified = modified
+	}
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if cred := r.URL.Query().Get("X-Amz-Credential"); cred != "" {
+		return strings.HasPrefix(cred, s.AccessKeyID+"/") && r.URL.Query().Get("X-Amz-Signature") != ""
+	}
+	return strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential="+s.AccessKeyID+"/")
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests[r.Method]++
+	s.mu.Unlock()
+	if !s.authorized(r) {
+		writeError(w, http.StatusForbidden, "AccessDenied", "Access Denied")
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key, _ := strings.Cut(path, "/")
+	s.mu.Lock()
+	objects, ok := s.buckets[bucket]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+	if key == "" {
+		if r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2" {
+			s.list(w, r, objects)
+			return
+		}
+		writeError(w, http.StatusNotImplemented, "NotImplemented", "unsupported bucket operation")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		s.mu.Lock()
+		obj, ok := objects[key]
+		var modified time.Time
+		if ok {
+			modified = obj.modified
+		}
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+			return
+		}
+		for name, values := range obj.meta {
+			w.Header()[name] = values
+		}
+		w.Header().Set("ETag", obj.etag)
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.body)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(obj.body)
+		}
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+			return
+		}
+		if hook := s.beforePut(); hook != nil {
+			hook(bucket, key)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		current, exists := objects[key]
+		if match := r.Header.Get("If-Match"); match != "" && (!exists || match != current.etag) {
+			writeError(w, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+			return
+		}
+		if r.Header.Get("If-None-Match") == "*" && exists {
+			writeError(w, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+			return
+		}
+		sum := md5.Sum(body)
+		meta := make(http.Header)
+		for name, values := range r.Header {
+			if strings.HasPrefix(strings.ToLower(name), "x-amz-meta-") {
+				meta[name] = values
+			}
+		}
+		obj := &object{
+			body:     body,
+			etag:     `"` + hex.EncodeToString(sum[:]) + `"`,
+			modified: time.Now().UTC(),
+			meta:     meta,
+		}
+		objects[key] = obj
+		w.Header().Set("ETag", obj.etag)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		s.mu.Lock()
+		delete(objects, key)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported method")
+	}
+}
+
+func (s *Server) beforePut() func(string, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.BeforePut
+}
+
+type listContents struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int    `xml:"Size"`
+}
+
+type listResult struct {
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Prefix                string         `xml:"Prefix"`
+	KeyCount              int            `xml:"KeyCount"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	Contents              []listContents `xml:"Contents"`
+}
+
+// list implements ListObjectsV2. Like MinIO, the continuation token is
+// opaque to clients; here it encodes the last key returned.
+func (s *Server) list(w http.ResponseWriter, r *http.Request, objects map[string]*object) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	maxKeys := 1000
+	if raw := query.Get("max-keys"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid max-keys")
+			return
+		}
+		maxKeys = n
+	}
+	after := ""
+	if token := query.Get("continuation-token"); token != "" {
+		decoded, err := hex.DecodeString(token)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "InvalidArgument", "The continuation token provided is incorrect")
+			return
+		}
+		after = string(decoded)
+	}
+	s.mu.Lock()
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	result := listResult{Prefix: prefix, MaxKeys: maxKeys, ContinuationToken: query.Get("continuation-token")}
+	if maxKeys > 0 && len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+		result.IsTruncated = true
+		result.NextContinuationToken = hex.EncodeToString([]byte(keys[len(keys)-1]))
+	}
+	for _, key := range keys {
+		obj := objects[key]
+		result.Contents = append(result.Contents, listContents{
+			Key:          key,
+			LastModified: obj.modified.UTC().Format(time.RFC3339Nano),
+			ETag:         obj.etag,
+			Size:         len(obj.body),
+		})
+	}
+	s.mu.Unlock()
+	result.KeyCount = len(result.Contents)
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = io.WriteString(w, xml.Header)
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s<Error><Code>%s</Code><Message>%s</Message></Error>", xml.Header, code, message)
+}
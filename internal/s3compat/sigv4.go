@@ -0,0 +1,191 @@
+package s3compat
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm   = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat  = "20060102T150405Z"
+	sigV4DateFormat  = "20060102"
+	unsignedPayload  = "UNSIGNED-PAYLOAD"
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// signRequest adds AWS Signature Version 4 headers to req. payloadHash is the
+// hex SHA-256 of the request body.
+func signRequest(req *http.Request, creds Credentials, region, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(sigV4TimeFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+	signed, canonicalHeaders := canonicalRequestHeaders(req)
+	canonical := strings.Join([]string{
+		req.Method,
+		canonicalURI(req.URL),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signed,
+		payloadHash,
+	}, "\n")
+	scope := credentialScope(now, region)
+	signature := sigV4Signature(creds.SecretAccessKey, now, region, stringToSign(amzDate, scope, canonical))
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, creds.AccessKeyID, scope, signed, signature))
+}
+
+// presignURL returns u with SigV4 query authentication for a GET that is
+// valid for expires.
+func presignURL(u *url.URL, creds Credentials, region string, expires time.Duration, now time.Time) string {
+	now = now.UTC()
+	amzDate := now.Format(sigV4TimeFormat)
+	scope := credentialScope(now, region)
+	query := u.Query()
+	query.Set("X-Amz-Algorithm", sigV4Algorithm)
+	query.Set("X-Amz-Credential", creds.AccessKeyID+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", fmt.Sprintf("%d", int64(expires/time.Second)))
+	query.Set("X-Amz-SignedHeaders", "host")
+	if creds.SessionToken != "" {
+		query.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+	canonical := strings.Join([]string{
+		http.MethodGet,
+		canonicalURI(u),
+		canonicalQuery(query),
+		"host:" + u.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+	signature := sigV4Signature(creds.SecretAccessKey, now, region, stringToSign(amzDate, scope, canonical))
+	out := *u
+	out.RawQuery = canonicalQuery(query) + "&X-Amz-Signature=" + signature
+	return out.String()
+}
+
+func credentialScope(now time.Time, region string) string {
+	return now.Format(sigV4DateFormat) + "/" + region + "/s3/aws4_request"
+}
+
+func stringToSign(amzDate, scope, canonicalRequest string) string {
+	return strings.Join([]string{sigV4Algorithm, amzDate, scope, hashHex([]byte(canonicalRequest))}, "\n")
+}
+
+func sigV4Signature(secret string, now time.Time, region, toSign string) string {
+	key := hmacSHA256([]byte("AWS4"+secret), now.Format(sigV4DateFormat))
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+func canonicalRequestHeaders(req *http.Request) (signed string, canonical string) {
+	values := map[string]string{"host": req.URL.Host}
+	if req.Host != "" {
+		values["host"] = req.Host
+	}
+	for name, vals := range req.Header {
+		lower := strings.ToLower(name)
+		if !signedHeader(lower) {
+			continue
+		}
+		trimmed := make([]string, len(vals))
+		for i, v := range vals {
+			trimmed[i] = strings.Join(strings.Fields(v), " ")
+		}
+		values[lower] = strings.Join(trimmed, ",")
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteString(":")
+		b.WriteString(values[name])
+		b.WriteString("\n")
+	}
+	return strings.Join(names, ";"), b.String()
+}
+
+func signedHeader(name string) bool {
+	switch name {
+	case "content-type", "content-md5", "if-match", "if-none-match", "range":
+		return true
+	}
+	return strings.HasPrefix(name, "x-amz-")
+}
+
+func canonicalURI(u *url.URL) string {
+	path := u.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+	// Re-encode from the decoded path so that every segment uses the
+	// strict RFC 3986 set that S3 expects.
+	decoded, err := url.PathUnescape(path)
+	if err != nil {
+		return path
+	}
+	return uriEncode(decoded, false)
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		vals := append([]string(nil), query[key]...)
+		sort.Strings(vals)
+		for _, val := range vals {
+			parts = append(parts, uriEncode(key, true)+"="+uriEncode(val, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything except RFC 3986 unreserved
+// characters; '/' is preserved unless encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -920,6 +920,22 @@ Robot.prototype.UpdateDatum = function (memoryObj) {
   return this.gbot.UpdateDatum(memoryObj);
 };
 
+/**
+ * Like UpdateDatum, but the datum expires and is deleted after `ttl` seconds.
+ * Each call resets the expiry; a ttl <= 0 makes the datum permanent again.
+ *
+ * @param {{ key: string, token: string, datum: any }} memoryObj
+ * @param {number} ttl - Seconds until the datum expires.
+ * @returns {number} The return value constant (ret.*).
+ *
+ * @example
+ * const out = bot.CheckoutDatum("conversation", true);
+ * bot.UpdateDatumWithTTL({ key: "conversation", token: out.token, datum: out.datum }, 7 * 86400);
+ */
+Robot.prototype.UpdateDatumWithTTL = function (memoryObj, ttl) {
+  return this.gbot.UpdateDatumWithTTL(memoryObj, ttl);
+};
+
 /**
  * Checks in a previously checked-out datum to long-term memory. indicating the memory wasn't modified
  * and freeing it up for another requester.
@@ -677,6 +677,18 @@ function Robot:UpdateDatum(memory)
     return retVal
 end
 
+---Update a checked-out datum that expires after ttl seconds; a ttl <= 0
+---makes it permanent again.
+---@param memory MemoryObject
+---@param ttl number
+---@return number retVal
+function Robot:UpdateDatumWithTTL(memory, ttl)
+    if not memory or not memory.key or not memory.token then
+        error("UpdateDatumWithTTL requires a table with 'key' and 'token'")
+    end
+    return self.gbot:UpdateDatumWithTTL(memory.key, memory.token, memory.datum, ttl)
+end
+
 ---Check in a previously checked-out datum.
 ---@param memory MemoryObject
 function Robot:CheckinDatum(memory)
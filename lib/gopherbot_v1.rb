@@ -211,6 +211,13 @@ class BaseBot
 		return ret["RetVal"]
 	end
 
+	# Like UpdateDatum, but the datum expires after ttl seconds
+	def UpdateDatumWithTTL(m, ttl)
+		args = { "Key" => m.key, "Token" => m.lock_token, "Datum" => m.datum, "TTL" => ttl }
+		ret = callBotFunc(__method__, args)
+		return ret["RetVal"]
+	end
+
 	def DeleteDatum(key)
 		ret = callBotFunc(__method__, { "Key" => key })
 		return ret["RetVal"]
@@ -218,6 +218,12 @@ class Robot:
         "Datum": m.datum })
         return ret["RetVal"]
 
+    def UpdateDatumWithTTL(self, m, ttl):
+        "Like UpdateDatum, but the datum expires after ttl seconds"
+        ret = self.Call(sys._getframe().f_code.co_name, { "Key": m.key, "Token": m.lock_token,
+        "Datum": m.datum, "TTL": ttl })
+        return ret["RetVal"]
+
     def DeleteDatum(self, key):
         ret = self.Call(sys._getframe().f_code.co_name, { "Key": key })
         return ret["RetVal"]
@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/lnxjedi/gopherbot/robot"
 )
@@ -235,6 +236,9 @@ func (r *onboardingTestRobot) CheckinDatum(string, string) {}
 func (r *onboardingTestRobot) UpdateDatum(string, string, interface{}) robot.RetVal {
 	return robot.Failed
 }
+func (r *onboardingTestRobot) UpdateDatumWithTTL(string, string, interface{}, time.Duration) robot.RetVal {
+	return robot.Failed
+}
 func (r *onboardingTestRobot) DeleteDatum(string) robot.RetVal             { return robot.Ok }
 func (r *onboardingTestRobot) Remember(string, string, bool)               {}
 func (r *onboardingTestRobot) RememberThread(string, string, bool)         {}
@@ -30,17 +30,35 @@ import (
 	_ "github.com/lnxjedi/gopherbot/v2/connectors/googlechat"
 	// *** Default SSH connector
 	_ "github.com/lnxjedi/gopherbot/v2/connectors/ssh"
+	// *** Matrix connector
+	_ "github.com/lnxjedi/gopherbot/v2/connectors/matrix"
+	// *** Mattermost connector
+	_ "github.com/lnxjedi/gopherbot/v2/connectors/mattermost"
+	// *** Microsoft Teams connector
+	_ "github.com/lnxjedi/gopherbot/v2/connectors/teams"
+	// *** Discord connector
+	_ "github.com/lnxjedi/gopherbot/v2/connectors/discord"
+	// *** IRC connector
+	_ "github.com/lnxjedi/gopherbot/v2/connectors/irc"
 
 	// *** Default queue providers
+	_ "github.com/lnxjedi/gopherbot/v2/queues/amqp"
 	_ "github.com/lnxjedi/gopherbot/v2/queues/gcloud"
+	_ "github.com/lnxjedi/gopherbot/v2/queues/nats"
+	_ "github.com/lnxjedi/gopherbot/v2/queues/spool"
 
 	// *** Default file history
 	_ "github.com/lnxjedi/gopherbot/v2/history/file"
+	_ "github.com/lnxjedi/gopherbot/v2/history/jsonl"
+	_ "github.com/lnxjedi/gopherbot/v2/history/s3"
 
 	// *** A couple of fantastic brains
 	_ "github.com/lnxjedi/gopherbot/v2/brains/cloudflarekv"
 	_ "github.com/lnxjedi/gopherbot/v2/brains/dynamodb"
 	_ "github.com/lnxjedi/gopherbot/v2/brains/firestore"
+	_ "github.com/lnxjedi/gopherbot/v2/brains/redis"
+	_ "github.com/lnxjedi/gopherbot/v2/brains/s3"
+	_ "github.com/lnxjedi/gopherbot/v2/brains/sqlite"
 )
 
 /* Uncomment under Profiling above to enable profiling. This inflates
@@ -80,6 +80,11 @@ func (c *shellContext) commandMap() map[string]commandHandler {
 		"remembercontextthread":           c.cmdRememberContextThread,
 		"recall":                          c.cmdRecall,
 		"deletememory":                    c.cmdDeleteMemory,
+		"checkoutdatum":                   c.cmdCheckoutDatum,
+		"checkindatum":                    c.cmdCheckinDatum,
+		"updatedatum":                     c.cmdUpdateDatum,
+		"updatedatumwithttl":              c.cmdUpdateDatumWithTTL,
+		"deletedatum":                     c.cmdDeleteDatum,
 		"getparameter":                    c.cmdGetParameter,
 		"getidentitycredential":           c.cmdGetIdentityCredential,
 		"linkoauth2identity":              c.cmdLinkOAuth2Identity,
@@ -370,6 +375,86 @@ func (c *shellContext) cmdDeleteMemory(ctx context.Context, args []string) error
 	return nil
 }
 
+// cmdCheckoutDatum prints {"exists":..., "token":..., "datum":...} as JSON
+// for use with jq; the exit status is the RetVal.
+func (c *shellContext) cmdCheckoutDatum(ctx context.Context, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return usageError(ctx, "CheckoutDatum requires key and optional rw flag")
+	}
+	var datum interface{}
+	token, exists, ret := c.bot.CheckoutDatum(args[0], &datum, len(args) > 1 && parseTruthy(args[1]))
+	if ret != robot.Ok {
+		return retValToExitStatus(ret)
+	}
+	if !exists {
+		datum = map[string]interface{}{}
+	}
+	return writeJSON(ctx, map[string]interface{}{"exists": exists, "token": token, "datum": datum})
+}
+
+func (c *shellContext) cmdCheckinDatum(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return usageError(ctx, "CheckinDatum requires key and token")
+	}
+	c.bot.CheckinDatum(args[0], args[1])
+	return nil
+}
+
+// readDatumArg parses the JSON datum from args[idx], or from stdin when the
+// argument is omitted.
+func readDatumArg(ctx context.Context, args []string, idx int) (interface{}, error) {
+	var data []byte
+	if len(args) > idx {
+		data = []byte(args[idx])
+	} else {
+		var err error
+		if data, err = io.ReadAll(interp.HandlerCtx(ctx).Stdin); err != nil {
+			return nil, err
+		}
+	}
+	var datum interface{}
+	if err := json.Unmarshal(data, &datum); err != nil {
+		return nil, err
+	}
+	return datum, nil
+}
+
+func (c *shellContext) cmdUpdateDatum(ctx context.Context, args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return usageError(ctx, "UpdateDatum requires key, token and JSON datum (or datum on stdin)")
+	}
+	datum, err := readDatumArg(ctx, args, 2)
+	if err != nil {
+		fmt.Fprintf(interp.HandlerCtx(ctx).Stderr, "UpdateDatum: invalid JSON datum: %v\n", err)
+		return retValToExitStatus(robot.DataFormatError)
+	}
+	return retCodeError(c.bot.UpdateDatum(args[0], args[1], datum))
+}
+
+func (c *shellContext) cmdUpdateDatumWithTTL(ctx context.Context, args []string) error {
+	if len(args) < 3 || len(args) > 4 {
+		return usageError(ctx, "UpdateDatumWithTTL requires key, token, ttl seconds and JSON datum (or datum on stdin)")
+	}
+	seconds, err := strconv.ParseFloat(args[2], 64)
+	if err != nil {
+		return usageError(ctx, "UpdateDatumWithTTL ttl must be a number of seconds")
+	}
+	datum, err := readDatumArg(ctx, args, 3)
+	if err != nil {
+		fmt.Fprintf(interp.HandlerCtx(ctx).Stderr, "UpdateDatumWithTTL: invalid JSON datum: %v\n", err)
+		return retValToExitStatus(robot.DataFormatError)
+	}
+	ttl := time.Duration(seconds * float64(time.Second))
+	return retCodeError(c.bot.UpdateDatumWithTTL(args[0], args[1], datum, ttl))
+}
+
+func (c *shellContext) cmdDeleteDatum(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return usageError(ctx, "DeleteDatum requires key")
+	}
+	return retCodeError(c.bot.DeleteDatum(args[0]))
+}
+
 func (c *shellContext) cmdGetParameter(ctx context.Context, args []string) error {
 	if len(args) != 1 {
 		return usageError(ctx, "GetParameter requires parameter name")
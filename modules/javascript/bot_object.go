@@ -52,6 +52,7 @@ func (jr *jsBot) createBotObject() *goja.Object {
 	botObj.Set("MessageFormat", jr.botMessageFormat)
 	botObj.Set("CheckoutDatum", jr.botCheckoutDatum)
 	botObj.Set("UpdateDatum", jr.botUpdateDatum)
+	botObj.Set("UpdateDatumWithTTL", jr.botUpdateDatumWithTTL)
 	botObj.Set("CheckinDatum", jr.botCheckinDatum)
 	botObj.Set("DeleteDatum", jr.botDeleteDatum)
 	botObj.Set("EncryptSecret", jr.botEncryptSecret)
@@ -2,6 +2,7 @@ package javascript
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/dop251/goja"
 	"github.com/lnxjedi/gopherbot/robot"
@@ -125,6 +126,39 @@ func (jr *jsBot) botUpdateDatum(call goja.FunctionCall) goja.Value {
 	return jr.ctx.vm.ToValue(int(retVal))
 }
 
+// botUpdateDatumWithTTL(bot:UpdateDatumWithTTL(memoryObj, ttlSeconds))
+//
+// JavaScript usage example:
+//
+//	let out = bot.CheckoutDatum("conversation", true);
+//	let retVal = bot.UpdateDatumWithTTL({ key: "conversation", token: out.token, datum: out.datum }, 3600);
+func (jr *jsBot) botUpdateDatumWithTTL(call goja.FunctionCall) goja.Value {
+	const methodName = "UpdateDatumWithTTL"
+
+	if len(call.Arguments) < 2 {
+		panic(jr.ctx.vm.ToValue(fmt.Sprintf("%s: requires a memory object and ttl seconds", methodName)))
+	}
+	memObj := call.Arguments[0].ToObject(jr.ctx.vm)
+
+	keyStr, okKey := memObj.Get("key").Export().(string)
+	tokenStr, okTok := memObj.Get("token").Export().(string)
+	if !okKey || !okTok || keyStr == "" || tokenStr == "" {
+		panic(jr.ctx.vm.ToValue(
+			"UpdateDatumWithTTL requires a memory object with non-empty 'key' and 'token' fields",
+		))
+	}
+	ttl := time.Duration(call.Arguments[1].ToFloat() * float64(time.Second))
+
+	goDatum, err := parseJSValueToGo(memObj.Get("datum"))
+	if err != nil {
+		jr.ctx.l.Log(robot.Error, fmt.Sprintf("Error serializing JS object for key '%s': %v", keyStr, err))
+		return jr.ctx.vm.ToValue(int(robot.DataFormatError))
+	}
+
+	retVal := jr.r.UpdateDatumWithTTL(keyStr, tokenStr, goDatum, ttl)
+	return jr.ctx.vm.ToValue(int(retVal))
+}
+
 // botCheckinDatum(bot:CheckinDatum(memoryObj))
 //
 // JavaScript usage example:
@@ -1,6 +1,10 @@
 package lua
 
-import "github.com/lnxjedi/gopherbot/robot"
+import (
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
 
 // BotAPI defines the robot methods used by the Lua runtime bridge.
 // It is intentionally narrower than robot.Robot.
@@ -43,6 +47,7 @@ type BotAPI interface {
 	CheckoutDatum(key string, datum interface{}, rw bool) (locktoken string, exists bool, ret robot.RetVal)
 	CheckinDatum(key, locktoken string)
 	UpdateDatum(key, locktoken string, datum interface{}) (ret robot.RetVal)
+	UpdateDatumWithTTL(key, locktoken string, datum interface{}, ttl time.Duration) (ret robot.RetVal)
 	DeleteDatum(key string) (ret robot.RetVal)
 	Remember(key, value string, shared bool)
 	RememberThread(key, value string, shared bool)
@@ -2,19 +2,21 @@ package lua
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/lnxjedi/gopherbot/robot"
 	glua "github.com/yuin/gopher-lua"
 )
 
-// RegisterLongTermMemoryMethods adds CheckoutDatum, UpdateDatum, CheckinDatum,
-// and DeleteDatum to the bot's metatable.
+// RegisterLongTermMemoryMethods adds CheckoutDatum, UpdateDatum,
+// UpdateDatumWithTTL, CheckinDatum, and DeleteDatum to the bot's metatable.
 func (lctx *luaContext) RegisterLongTermMemoryMethods(L *glua.LState) {
 	methods := map[string]glua.LGFunction{
-		"CheckoutDatum": lctx.botCheckoutDatum,
-		"UpdateDatum":   lctx.botUpdateDatum,
-		"CheckinDatum":  lctx.botCheckinDatum,
-		"DeleteDatum":   lctx.botDeleteDatum,
+		"CheckoutDatum":      lctx.botCheckoutDatum,
+		"UpdateDatum":        lctx.botUpdateDatum,
+		"UpdateDatumWithTTL": lctx.botUpdateDatumWithTTL,
+		"CheckinDatum":       lctx.botCheckinDatum,
+		"DeleteDatum":        lctx.botDeleteDatum,
 	}
 
 	mt := registerBotMetatableIfNeeded(L)
@@ -94,6 +96,28 @@ func (lctx *luaContext) botUpdateDatum(L *glua.LState) int {
 	return 1
 }
 
+// botUpdateDatumWithTTL is botUpdateDatum with a fifth argument, the number
+// of seconds until the datum expires.
+func (lctx *luaContext) botUpdateDatumWithTTL(L *glua.LState) int {
+	r := lctx.getRobot(L, "UpdateDatumWithTTL")
+	key := L.CheckString(2)
+	lockToken := L.CheckString(3)
+	luaDataVal := L.Get(4)
+	ttl := time.Duration(float64(L.CheckNumber(5)) * float64(time.Second))
+
+	visited := make(map[*glua.LTable]bool)
+	goDatum, err := parseLuaValueToGo(luaDataVal, visited)
+	if err != nil {
+		lctx.Log(robot.Error, fmt.Sprintf("Error serializing Lua object for key '%s': %v", key, err))
+		L.Push(glua.LNumber(robot.DataFormatError))
+		return 1
+	}
+
+	retVal := r.UpdateDatumWithTTL(key, lockToken, goDatum, ttl)
+	L.Push(glua.LNumber(retVal))
+	return 1
+}
+
 // botCheckinDatum allows Lua scripts to checkin a datum by key and lockToken.
 func (lctx *luaContext) botCheckinDatum(L *glua.LState) int {
 	r := lctx.getRobot(L, "CheckinDatum")
@@ -9,6 +9,7 @@ import (
 	"go/token"
 	"io"
 	"reflect"
+	"time"
 )
 
 var Symbols = map[string]map[string]reflect.Value{}
@@ -336,6 +337,7 @@ type _github_com_lnxjedi_gopherbot_robot_Robot struct {
 	WThreaded                        func() robot.Robot
 	WUnlinkIdentity                  func(provider string, user string) robot.RetVal
 	WUpdateDatum                     func(key string, locktoken string, datum interface{}) (ret robot.RetVal)
+	WUpdateDatumWithTTL              func(key string, locktoken string, datum interface{}, ttl time.Duration) (ret robot.RetVal)
 }
 
 func (W _github_com_lnxjedi_gopherbot_robot_Robot) AddCommand(a0 string, a1 string) robot.RetVal {
@@ -524,6 +526,9 @@ func (W _github_com_lnxjedi_gopherbot_robot_Robot) UnlinkIdentity(provider strin
 func (W _github_com_lnxjedi_gopherbot_robot_Robot) UpdateDatum(key string, locktoken string, datum interface{}) (ret robot.RetVal) {
 	return W.WUpdateDatum(key, locktoken, datum)
 }
+func (W _github_com_lnxjedi_gopherbot_robot_Robot) UpdateDatumWithTTL(key string, locktoken string, datum interface{}, ttl time.Duration) (ret robot.RetVal) {
+	return W.WUpdateDatumWithTTL(key, locktoken, datum, ttl)
+}
 
 // _github_com_lnxjedi_gopherbot_robot_SimpleBrain is an interface wrapper for SimpleBrain type
 type _github_com_lnxjedi_gopherbot_robot_SimpleBrain struct {
@@ -0,0 +1,329 @@
+package amqp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+	amqp091 "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	defaultCredentialsEncryptedFile = "amqp-credentials.json.enc"
+	defaultQueue                    = "job-triggers"
+	defaultMaxBodySize              = 4096
+	defaultMaxRedeliveries          = 5
+	defaultRetryDelaySeconds        = 2
+	defaultReconnectSeconds         = 10
+	consumerTag                     = "gopherbot"
+	publishTimeout                  = 10 * time.Second
+)
+
+// redeliveryHeader counts how many times the provider has put a message back
+// on the queue for QueueRetry; classic queues don't track redeliveries.
+const redeliveryHeader = "x-gopherbot-redeliveries"
+
+type config struct {
+	URL                      string
+	CredentialsEncryptedFile string
+	Queue                    string
+	DeclareQueue             bool
+	DeadLetterExchange       string
+	MaxOutstandingMessages   int
+	MaxRedeliveries          int
+	RetryDelaySeconds        int
+	MaxBodySize              int
+}
+
+// credentials is the JSON held in CredentialsEncryptedFile.
+type credentials struct {
+	Username string
+	Password string
+}
+
+type queueProvider struct {
+	robot.QueueHandler
+	url                string
+	displayURL         string
+	queue              string
+	declareQueue       bool
+	deadLetterExchange string
+	prefetch           int
+	maxRedeliveries    int
+	retryDelay         time.Duration
+	maxBodySize        int
+}
+
+// publisher is the part of *amqp091.Channel used to requeue retried
+// messages.
+type publisher interface {
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp091.Publishing) error
+}
+
+func Initialize(handler robot.QueueHandler, _ *log.Logger) (robot.InitializedQueueProvider, error) {
+	var c config
+	if err := handler.GetQueueConfig(&c); err != nil {
+		return robot.InitializedQueueProvider{}, fmt.Errorf("retrieve amqp queue configuration: %w", err)
+	}
+	u, err := url.Parse(strings.TrimSpace(c.URL))
+	if err != nil || (u.Scheme != "amqp" && u.Scheme != "amqps") || u.Host == "" {
+		return robot.InitializedQueueProvider{}, fmt.Errorf("amqp queue URL must be an amqp:// or amqps:// URL")
+	}
+	// Credentials come from the encrypted file unless the URL already has
+	// them and no file was configured.
+	credentialsPath := strings.TrimSpace(c.CredentialsEncryptedFile)
+	if credentialsPath != "" || u.User == nil {
+		if credentialsPath == "" {
+			credentialsPath = defaultCredentialsEncryptedFile
+		}
+		creds, err := loadCredentials(handler.ReadEncryptedFile, credentialsPath)
+		if err != nil {
+			return robot.InitializedQueueProvider{}, fmt.Errorf("load amqp credentials: %w", err)
+		}
+		u.User = url.UserPassword(creds.Username, creds.Password)
+	}
+	display := *u
+	display.User = url.User(u.User.Username())
+
+	qp := &queueProvider{
+		QueueHandler:       handler,
+		url:                u.String(),
+		displayURL:         display.String(),
+		queue:              strings.TrimSpace(c.Queue),
+		declareQueue:       c.DeclareQueue,
+		deadLetterExchange: strings.TrimSpace(c.DeadLetterExchange),
+		prefetch:           positiveOr(c.MaxOutstandingMessages, 1),
+		maxRedeliveries:    c.MaxRedeliveries,
+		retryDelay:         time.Duration(positiveOr(c.RetryDelaySeconds, defaultRetryDelaySeconds)) * time.Second,
+		maxBodySize:        positiveOr(c.MaxBodySize, defaultMaxBodySize),
+	}
+	if qp.queue == "" {
+		qp.queue = defaultQueue
+	}
+	if qp.maxRedeliveries == 0 {
+		qp.maxRedeliveries = defaultMaxRedeliveries
+	}
+	return robot.InitializedQueueProvider{Provider: qp}, nil
+}
+
+func loadCredentials(readEncryptedFile func(string) ([]byte, error), path string) (credentials, error) {
+	var creds credentials
+	raw, err := readEncryptedFile(path)
+	if err != nil {
+		return creds, err
+	}
+	if err := json.Unmarshal(raw, &creds); err != nil {
+		return creds, fmt.Errorf("parse '%s': %w", path, err)
+	}
+	if creds.Username == "" {
+		return creds, fmt.Errorf("'%s' has no Username", path)
+	}
+	return creds, nil
+}
+
+func positiveOr(in, def int) int {
+	if in > 0 {
+		return in
+	}
+	return def
+}
+
+// Run consumes until stop is closed, reconnecting after connection loss.
+func (q *queueProvider) Run(stop <-chan struct{}) {
+	q.Log(robot.Info, "AMQP queue provider receiving from queue '%s' at %s", q.queue, q.displayURL)
+	for {
+		err := q.consume(stop)
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		q.Log(robot.Error, "AMQP queue consumer stopped: %v; reconnecting in %ds", err, defaultReconnectSeconds)
+		select {
+		case <-stop:
+			return
+		case <-time.After(defaultReconnectSeconds * time.Second):
+		}
+	}
+}
+
+func (q *queueProvider) consume(stop <-chan struct{}) error {
+	conn, err := amqp091.Dial(q.url)
+	if err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	defer conn.Close()
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("open channel: %w", err)
+	}
+	defer ch.Close()
+	if q.declareQueue {
+		if err := q.declare(ch); err != nil {
+			return err
+		}
+	}
+	if err := ch.Qos(q.prefetch, 0, false); err != nil {
+		return fmt.Errorf("set prefetch: %w", err)
+	}
+	deliveries, err := ch.Consume(q.queue, consumerTag, false, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("consume '%s': %w", q.queue, err)
+	}
+	closed := conn.NotifyClose(make(chan *amqp091.Error, 1))
+
+	// One handler per prefetched message, so MaxOutstandingMessages also
+	// bounds concurrency.
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	slots := make(chan struct{}, q.prefetch)
+	for {
+		select {
+		case <-stop:
+			return nil
+		case err := <-closed:
+			if err == nil {
+				return fmt.Errorf("connection closed")
+			}
+			return err
+		case d, ok := <-deliveries:
+			if !ok {
+				return fmt.Errorf("delivery channel closed")
+			}
+			slots <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-slots }()
+				q.handle(ch, d)
+			}()
+		}
+	}
+}
+
+// declare creates the queue, and when DeadLetterExchange is set, a fanout
+// dead-letter exchange with a "<queue>.dead" queue bound to it.
+func (q *queueProvider) declare(ch *amqp091.Channel) error {
+	var args amqp091.Table
+	if q.deadLetterExchange != "" {
+		if err := ch.ExchangeDeclare(q.deadLetterExchange, "fanout", true, false, false, false, nil); err != nil {
+			return fmt.Errorf("declare dead-letter exchange '%s': %w", q.deadLetterExchange, err)
+		}
+		dead := q.queue + ".dead"
+		if _, err := ch.QueueDeclare(dead, true, false, false, false, nil); err != nil {
+			return fmt.Errorf("declare dead-letter queue '%s': %w", dead, err)
+		}
+		if err := ch.QueueBind(dead, "", q.deadLetterExchange, false, nil); err != nil {
+			return fmt.Errorf("bind dead-letter queue '%s': %w", dead, err)
+		}
+		args = amqp091.Table{"x-dead-letter-exchange": q.deadLetterExchange}
+	}
+	if _, err := ch.QueueDeclare(q.queue, true, false, false, false, args); err != nil {
+		return fmt.Errorf("declare queue '%s': %w", q.queue, err)
+	}
+	return nil
+}
+
+func messageID(d amqp091.Delivery) string {
+	if d.MessageId != "" {
+		return d.MessageId
+	}
+	return strconv.FormatUint(d.DeliveryTag, 10)
+}
+
+// redeliveries reads the provider's retry count, falling back to the
+// x-delivery-count that quorum queues maintain.
+func redeliveries(headers amqp091.Table) int {
+	for _, name := range []string{redeliveryHeader, "x-delivery-count"} {
+		switch v := headers[name].(type) {
+		case int32:
+			return int(v)
+		case int64:
+			return int(v)
+		case int:
+			return v
+		}
+	}
+	return 0
+}
+
+func attributes(d amqp091.Delivery) map[string]string {
+	attrs := map[string]string{}
+	if d.RoutingKey != "" {
+		attrs["routing_key"] = d.RoutingKey
+	}
+	if d.Exchange != "" {
+		attrs["exchange"] = d.Exchange
+	}
+	for name, value := range d.Headers {
+		if s, ok := value.(string); ok {
+			attrs[name] = s
+		}
+	}
+	return attrs
+}
+
+// handle maps the engine's disposition onto the delivery. QueueRetry puts a
+// copy back on the queue with an incremented redelivery count, after
+// RetryDelaySeconds; once MaxRedeliveries is exceeded the message is
+// rejected without requeue, so the broker routes it to the queue's
+// dead-letter exchange (or drops it if there is none).
+func (q *queueProvider) handle(pub publisher, d amqp091.Delivery) {
+	id := messageID(d)
+	if len(d.Body) > q.maxBodySize {
+		q.Log(robot.Error, "AMQP queue message '%s' exceeded MaxBodySize: %d > %d; dead-lettering", id, len(d.Body), q.maxBodySize)
+		q.settle(d, d.Nack(false, false))
+		return
+	}
+	disposition := q.HandleQueueMessage(robot.QueueMessage{
+		ID:         id,
+		Body:       d.Body,
+		Attributes: attributes(d),
+	})
+	if disposition != robot.QueueRetry {
+		q.settle(d, d.Ack(false))
+		return
+	}
+	count := redeliveries(d.Headers) + 1
+	if q.maxRedeliveries > 0 && count > q.maxRedeliveries {
+		q.Log(robot.Warn, "AMQP queue message '%s' retried %d times; dead-lettering", id, q.maxRedeliveries)
+		q.settle(d, d.Nack(false, false))
+		return
+	}
+	time.Sleep(q.retryDelay)
+	headers := amqp091.Table{}
+	for name, value := range d.Headers {
+		headers[name] = value
+	}
+	headers[redeliveryHeader] = int32(count)
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	err := pub.PublishWithContext(ctx, "", q.queue, false, false, amqp091.Publishing{
+		Headers:       headers,
+		ContentType:   d.ContentType,
+		DeliveryMode:  amqp091.Persistent,
+		MessageId:     d.MessageId,
+		CorrelationId: d.CorrelationId,
+		Timestamp:     d.Timestamp,
+		Body:          d.Body,
+	})
+	if err != nil {
+		q.Log(robot.Error, "Requeueing AMQP queue message '%s': %v; returning it to the broker", id, err)
+		q.settle(d, d.Nack(false, true))
+		return
+	}
+	q.settle(d, d.Ack(false))
+}
+
+func (q *queueProvider) settle(d amqp091.Delivery, err error) {
+	if err != nil {
+		q.Log(robot.Error, "Acknowledging AMQP queue message '%s': %v", messageID(d), err)
+	}
+}
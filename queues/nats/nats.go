@@ -0,0 +1,293 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nkeys"
+)
+
+const (
+	defaultURL               = "nats://127.0.0.1:4222"
+	defaultStream            = "JOB_TRIGGERS"
+	defaultConsumer          = "gopherbot"
+	defaultMaxBodySize       = 4096
+	defaultMaxDeliver        = 5
+	defaultRetryDelaySeconds = 30
+	defaultAckWaitSeconds    = 60
+	defaultReconnectSeconds  = 10
+	setupTimeout             = 15 * time.Second
+)
+
+type config struct {
+	URL                      string
+	CredentialsEncryptedFile string
+	Stream                   string
+	Consumer                 string
+	ManageConsumer           bool
+	FilterSubject            string
+	MaxOutstandingMessages   int
+	MaxDeliver               int
+	AckWaitSeconds           int
+	RetryDelaySeconds        int
+	MaxBodySize              int
+}
+
+// userPassword is the JSON alternative to a .creds file.
+type userPassword struct {
+	Username string
+	Password string
+	Token    string
+}
+
+type queueProvider struct {
+	robot.QueueHandler
+	url            string
+	auth           natsgo.Option
+	stream         string
+	consumer       string
+	manageConsumer bool
+	filterSubject  string
+	maxOutstanding int
+	maxDeliver     int
+	ackWait        time.Duration
+	retryDelay     time.Duration
+	maxBodySize    int
+}
+
+func Initialize(handler robot.QueueHandler, _ *log.Logger) (robot.InitializedQueueProvider, error) {
+	var c config
+	if err := handler.GetQueueConfig(&c); err != nil {
+		return robot.InitializedQueueProvider{}, fmt.Errorf("retrieve nats queue configuration: %w", err)
+	}
+	qp := &queueProvider{
+		QueueHandler:   handler,
+		url:            strings.TrimSpace(c.URL),
+		stream:         strings.TrimSpace(c.Stream),
+		consumer:       strings.TrimSpace(c.Consumer),
+		manageConsumer: c.ManageConsumer,
+		filterSubject:  strings.TrimSpace(c.FilterSubject),
+		maxOutstanding: positiveOr(c.MaxOutstandingMessages, 1),
+		maxDeliver:     c.MaxDeliver,
+		ackWait:        time.Duration(positiveOr(c.AckWaitSeconds, defaultAckWaitSeconds)) * time.Second,
+		retryDelay:     time.Duration(positiveOr(c.RetryDelaySeconds, defaultRetryDelaySeconds)) * time.Second,
+		maxBodySize:    positiveOr(c.MaxBodySize, defaultMaxBodySize),
+	}
+	if qp.url == "" {
+		qp.url = defaultURL
+	}
+	if qp.stream == "" {
+		qp.stream = defaultStream
+	}
+	if qp.consumer == "" {
+		qp.consumer = defaultConsumer
+	}
+	if qp.maxDeliver == 0 {
+		qp.maxDeliver = defaultMaxDeliver
+	}
+	if credentialsPath := strings.TrimSpace(c.CredentialsEncryptedFile); credentialsPath != "" {
+		auth, err := loadCredentials(handler.ReadEncryptedFile, credentialsPath)
+		if err != nil {
+			return robot.InitializedQueueProvider{}, fmt.Errorf("load nats credentials: %w", err)
+		}
+		qp.auth = auth
+	}
+	return robot.InitializedQueueProvider{Provider: qp}, nil
+}
+
+// loadCredentials accepts either a NATS .creds file (user JWT and nkey seed)
+// or JSON with Username/Password or Token.
+func loadCredentials(readEncryptedFile func(string) ([]byte, error), path string) (natsgo.Option, error) {
+	raw, err := readEncryptedFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(string(raw), "BEGIN NATS USER JWT") {
+		jwt, err := nkeys.ParseDecoratedJWT(raw)
+		if err != nil {
+			return nil, fmt.Errorf("parse user JWT in '%s': %w", path, err)
+		}
+		kp, err := nkeys.ParseDecoratedNKey(raw)
+		if err != nil {
+			return nil, fmt.Errorf("parse nkey seed in '%s': %w", path, err)
+		}
+		return natsgo.UserJWT(
+			func() (string, error) { return jwt, nil },
+			func(nonce []byte) ([]byte, error) { return kp.Sign(nonce) },
+		), nil
+	}
+	var up userPassword
+	if err := json.Unmarshal(raw, &up); err != nil {
+		return nil, fmt.Errorf("'%s' is neither a .creds file nor JSON: %w", path, err)
+	}
+	switch {
+	case up.Token != "":
+		return natsgo.Token(up.Token), nil
+	case up.Username != "":
+		return natsgo.UserInfo(up.Username, up.Password), nil
+	}
+	return nil, fmt.Errorf("'%s' has no Username or Token", path)
+}
+
+func positiveOr(in, def int) int {
+	if in > 0 {
+		return in
+	}
+	return def
+}
+
+// Run consumes until stop is closed. The NATS client reconnects on its own;
+// the outer loop only covers failures to connect or bind the consumer.
+func (q *queueProvider) Run(stop <-chan struct{}) {
+	q.Log(robot.Info, "NATS JetStream queue provider receiving from stream '%s', consumer '%s' at %s", q.stream, q.consumer, q.url)
+	for {
+		err := q.consume(stop)
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		q.Log(robot.Error, "NATS JetStream consumer stopped: %v; retrying in %ds", err, defaultReconnectSeconds)
+		select {
+		case <-stop:
+			return
+		case <-time.After(defaultReconnectSeconds * time.Second):
+		}
+	}
+}
+
+func (q *queueProvider) consumerConfig() jetstream.ConsumerConfig {
+	return jetstream.ConsumerConfig{
+		Durable:       q.consumer,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       q.ackWait,
+		MaxDeliver:    q.maxDeliver,
+		MaxAckPending: q.maxOutstanding,
+		FilterSubject: q.filterSubject,
+	}
+}
+
+func (q *queueProvider) consume(stop <-chan struct{}) error {
+	opts := []natsgo.Option{natsgo.Name("gopherbot"), natsgo.MaxReconnects(-1)}
+	if q.auth != nil {
+		opts = append(opts, q.auth)
+	}
+	nc, err := natsgo.Connect(q.url, opts...)
+	if err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	defer nc.Close()
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return fmt.Errorf("jetstream: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), setupTimeout)
+	var cons jetstream.Consumer
+	if q.manageConsumer {
+		cons, err = js.CreateOrUpdateConsumer(ctx, q.stream, q.consumerConfig())
+	} else {
+		cons, err = js.Consumer(ctx, q.stream, q.consumer)
+	}
+	cancel()
+	if err != nil {
+		return fmt.Errorf("bind consumer '%s' on stream '%s': %w", q.consumer, q.stream, err)
+	}
+
+	// The client buffers at most MaxOutstandingMessages, and handlers run
+	// in goroutines bounded by the same number.
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, q.maxOutstanding)
+	failed := make(chan error, 1)
+	cc, err := cons.Consume(func(msg jetstream.Msg) {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			q.handle(msg)
+		}()
+	},
+		jetstream.PullMaxMessages(q.maxOutstanding),
+		jetstream.ConsumeErrHandler(func(_ jetstream.ConsumeContext, err error) {
+			q.Log(robot.Warn, "NATS JetStream consumer: %v", err)
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("consume: %w", err)
+	}
+	select {
+	case <-stop:
+		cc.Stop()
+	case <-cc.Closed():
+		failed <- fmt.Errorf("consumer closed")
+	}
+	wg.Wait()
+	select {
+	case err := <-failed:
+		return err
+	default:
+		return nil
+	}
+}
+
+func messageID(msg jetstream.Msg) string {
+	if id := msg.Headers().Get(natsgo.MsgIdHdr); id != "" {
+		return id
+	}
+	if meta, err := msg.Metadata(); err == nil {
+		return fmt.Sprintf("%s:%d", meta.Stream, meta.Sequence.Stream)
+	}
+	return ""
+}
+
+func attributes(msg jetstream.Msg) map[string]string {
+	attrs := map[string]string{"subject": msg.Subject()}
+	for name, values := range msg.Headers() {
+		if len(values) == 1 {
+			attrs[name] = values[0]
+		}
+	}
+	return attrs
+}
+
+// handle maps the engine's disposition onto the message. QueueRetry is a
+// NAK delayed by RetryDelaySeconds; the last allowed delivery, and bodies
+// over MaxBodySize, are terminated instead so the server emits an advisory
+// rather than silently dropping them.
+func (q *queueProvider) handle(msg jetstream.Msg) {
+	id := messageID(msg)
+	if len(msg.Data()) > q.maxBodySize {
+		q.Log(robot.Error, "NATS JetStream queue message '%s' exceeded MaxBodySize: %d > %d", id, len(msg.Data()), q.maxBodySize)
+		q.settle(id, msg.TermWithReason("body exceeds MaxBodySize"))
+		return
+	}
+	disposition := q.HandleQueueMessage(robot.QueueMessage{
+		ID:         id,
+		Body:       msg.Data(),
+		Attributes: attributes(msg),
+	})
+	if disposition != robot.QueueRetry {
+		q.settle(id, msg.Ack())
+		return
+	}
+	if meta, err := msg.Metadata(); err == nil && q.maxDeliver > 0 && meta.NumDelivered >= uint64(q.maxDeliver) {
+		q.Log(robot.Warn, "NATS JetStream queue message '%s' delivered %d times; terminating", id, meta.NumDelivered)
+		q.settle(id, msg.TermWithReason("retries exhausted"))
+		return
+	}
+	q.settle(id, msg.NakWithDelay(q.retryDelay))
+}
+
+func (q *queueProvider) settle(id string, err error) {
+	if err != nil {
+		q.Log(robot.Error, "Acknowledging NATS JetStream queue message '%s': %v", id, err)
+	}
+}
@@ -0,0 +1,310 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+	"github.com/nats-io/nats-server/v2/server"
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nkeys"
+)
+
+type fakeHandler struct {
+	config      config
+	files       map[string][]byte
+	disposition robot.QueueDisposition
+	handled     []robot.QueueMessage
+}
+
+func (h *fakeHandler) GetQueueConfig(v interface{}) error {
+	data, _ := json.Marshal(h.config)
+	return json.Unmarshal(data, v)
+}
+
+func (h *fakeHandler) HandleQueueMessage(msg robot.QueueMessage) robot.QueueDisposition {
+	h.handled = append(h.handled, msg)
+	return h.disposition
+}
+
+func (h *fakeHandler) ReadEncryptedFile(path string) ([]byte, error) {
+	if data, ok := h.files[path]; ok {
+		return data, nil
+	}
+	return nil, fmt.Errorf("%s: not found", path)
+}
+
+func (h *fakeHandler) Log(robot.LogLevel, string, ...interface{}) {}
+func (h *fakeHandler) GetInstallPath() string                     { return "" }
+func (h *fakeHandler) GetConfigPath() string                      { return "" }
+
+// fakeMsg implements jetstream.Msg and records how it was settled.
+type fakeMsg struct {
+	data      []byte
+	headers   natsgo.Header
+	delivered uint64
+	result    string
+}
+
+func (m *fakeMsg) Metadata() (*jetstream.MsgMetadata, error) {
+	return &jetstream.MsgMetadata{
+		Stream:       "JOB_TRIGGERS",
+		Sequence:     jetstream.SequencePair{Stream: 42},
+		NumDelivered: m.delivered,
+	}, nil
+}
+func (m *fakeMsg) Data() []byte                       { return m.data }
+func (m *fakeMsg) Headers() natsgo.Header             { return m.headers }
+func (m *fakeMsg) Subject() string                    { return "jobs.trigger" }
+func (m *fakeMsg) Reply() string                      { return "" }
+func (m *fakeMsg) Ack() error                         { m.result = "ack"; return nil }
+func (m *fakeMsg) DoubleAck(context.Context) error    { m.result = "ack"; return nil }
+func (m *fakeMsg) Nak() error                         { m.result = "nak"; return nil }
+func (m *fakeMsg) InProgress() error                  { return nil }
+func (m *fakeMsg) Term() error                        { m.result = "term"; return nil }
+func (m *fakeMsg) TermWithReason(reason string) error { m.result = "term"; return nil }
+func (m *fakeMsg) NakWithDelay(delay time.Duration) error {
+	m.result = "nak " + delay.String()
+	return nil
+}
+
+func TestHandleMapsDispositions(t *testing.T) {
+	h := &fakeHandler{config: config{MaxDeliver: 3, RetryDelaySeconds: 45, MaxBodySize: 16}}
+	ip, err := Initialize(h, nil)
+	if err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	qp := ip.Provider.(*queueProvider)
+	if qp.url != defaultURL || qp.stream != defaultStream || qp.consumer != defaultConsumer || qp.maxOutstanding != 1 {
+		t.Fatalf("defaults not applied: %+v", qp)
+	}
+
+	msg := &fakeMsg{data: []byte("uuid:123"), headers: natsgo.Header{natsgo.MsgIdHdr: []string{"abc"}}, delivered: 1}
+	qp.handle(msg)
+	if msg.result != "ack" || h.handled[0].ID != "abc" || h.handled[0].Attributes["subject"] != "jobs.trigger" {
+		t.Fatalf("QueueAck: result=%q handled=%+v", msg.result, h.handled)
+	}
+
+	msg = &fakeMsg{data: []byte("this body is far too long")}
+	qp.handle(msg)
+	if msg.result != "term" || len(h.handled) != 1 {
+		t.Fatalf("oversized body: result=%q handled=%d", msg.result, len(h.handled))
+	}
+
+	h.disposition = robot.QueueRetry
+	msg = &fakeMsg{data: []byte("retry"), delivered: 2}
+	qp.handle(msg)
+	if msg.result != "nak 45s" || h.handled[1].ID != "JOB_TRIGGERS:42" {
+		t.Fatalf("retry: result=%q id=%q", msg.result, h.handled[1].ID)
+	}
+	msg = &fakeMsg{data: []byte("retry"), delivered: 3}
+	qp.handle(msg)
+	if msg.result != "term" {
+		t.Fatalf("last delivery should be terminated: result=%q", msg.result)
+	}
+
+	cc := qp.consumerConfig()
+	if cc.Durable != defaultConsumer || cc.MaxDeliver != 3 || cc.MaxAckPending != 1 || cc.AckPolicy != jetstream.AckExplicitPolicy {
+		t.Fatalf("consumerConfig() = %+v", cc)
+	}
+}
+
+// deliveryHandler reports each delivery on a channel, for tests where the
+// provider runs against a server and handles messages in goroutines.
+type deliveryHandler struct {
+	fakeHandler
+	deliveries chan robot.QueueMessage
+}
+
+func (h *deliveryHandler) HandleQueueMessage(msg robot.QueueMessage) robot.QueueDisposition {
+	h.deliveries <- msg
+	if string(msg.Body) == "retry" {
+		return robot.QueueRetry
+	}
+	return robot.QueueAck
+}
+
+// runJetStream starts an in-process nats-server with JetStream and a
+// JOB_TRIGGERS stream on jobs.>.
+func runJetStream(t *testing.T) (*server.Server, jetstream.JetStream) {
+	t.Helper()
+	srv, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	go srv.Start()
+	t.Cleanup(func() {
+		srv.Shutdown()
+		srv.WaitForShutdown()
+	})
+	if !srv.ReadyForConnections(10 * time.Second) {
+		t.Fatal("nats-server not ready")
+	}
+	nc, err := natsgo.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	t.Cleanup(nc.Close)
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatalf("jetstream.New() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := js.CreateStream(ctx, jetstream.StreamConfig{Name: defaultStream, Subjects: []string{"jobs.>"}}); err != nil {
+		t.Fatalf("CreateStream() error = %v", err)
+	}
+	return srv, js
+}
+
+func TestJetStreamRedeliversThenTerminates(t *testing.T) {
+	srv, js := runJetStream(t)
+	h := &deliveryHandler{
+		fakeHandler: fakeHandler{config: config{URL: srv.ClientURL(), ManageConsumer: true, MaxDeliver: 3}},
+		deliveries:  make(chan robot.QueueMessage, 10),
+	}
+	ip, err := Initialize(h, nil)
+	if err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	qp := ip.Provider.(*queueProvider)
+	qp.retryDelay = 200 * time.Millisecond
+
+	nc, err := natsgo.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer nc.Close()
+	terminated, err := nc.SubscribeSync(server.JSAdvisoryConsumerMsgTerminatedPre + "." + defaultStream + "." + defaultConsumer)
+	if err != nil {
+		t.Fatalf("SubscribeSync() error = %v", err)
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		qp.Run(stop)
+		close(done)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := js.Publish(ctx, "jobs.trigger", []byte("uuid:123"), jetstream.WithMsgID("ack-me")); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if _, err := js.Publish(ctx, "jobs.trigger", []byte("retry"), jetstream.WithMsgID("retry-me")); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	next := func() (robot.QueueMessage, time.Time) {
+		t.Helper()
+		select {
+		case msg := <-h.deliveries:
+			return msg, time.Now()
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a delivery")
+		}
+		return robot.QueueMessage{}, time.Time{}
+	}
+	if msg, _ := next(); msg.ID != "ack-me" || msg.Attributes["subject"] != "jobs.trigger" {
+		t.Fatalf("first delivery = %+v", msg)
+	}
+	var last time.Time
+	for i := 1; i <= 3; i++ {
+		msg, at := next()
+		if msg.ID != "retry-me" {
+			t.Fatalf("delivery %d = %+v, want retry-me", i, msg)
+		}
+		if i > 1 && at.Sub(last) < qp.retryDelay {
+			t.Fatalf("delivery %d came %v after the last, before the %v NAK delay", i, at.Sub(last), qp.retryDelay)
+		}
+		last = at
+	}
+	if _, err := terminated.NextMsg(5 * time.Second); err != nil {
+		t.Fatalf("no termination advisory after MaxDeliver: %v", err)
+	}
+	select {
+	case msg := <-h.deliveries:
+		t.Fatalf("delivered again after termination: %+v", msg)
+	case <-time.After(3 * qp.retryDelay):
+	}
+
+	cons, err := js.Consumer(ctx, defaultStream, defaultConsumer)
+	if err != nil {
+		t.Fatalf("Consumer() error = %v", err)
+	}
+	info, err := cons.Info(ctx)
+	if err != nil {
+		t.Fatalf("Info() error = %v", err)
+	}
+	if info.Config.MaxDeliver != 3 || info.Config.AckPolicy != jetstream.AckExplicitPolicy || info.Config.MaxAckPending != 1 {
+		t.Fatalf("consumer config = %+v", info.Config)
+	}
+	if info.NumAckPending != 0 || info.NumPending != 0 {
+		t.Fatalf("consumer has unsettled messages: ack pending %d, pending %d", info.NumAckPending, info.NumPending)
+	}
+}
+
+func TestLoadCredentials(t *testing.T) {
+	user, err := nkeys.CreateUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed, _ := user.Seed()
+	creds := "-----BEGIN NATS USER JWT-----\neyJhbGciOiJlZDI1NTE5In0.e30.sig\n------END NATS USER JWT------\n\n" +
+		"-----BEGIN USER NKEY SEED-----\n" + string(seed) + "\n------END USER NKEY SEED------\n"
+	files := map[string][]byte{
+		"bot.creds":  []byte(creds),
+		"user.json":  []byte(`{"Username":"bot","Password":"pw"}`),
+		"token.json": []byte(`{"Token":"t0k"}`),
+		"empty.json": []byte(`{}`),
+	}
+	read := func(path string) ([]byte, error) { return files[path], nil }
+
+	opt, err := loadCredentials(read, "bot.creds")
+	if err != nil {
+		t.Fatalf("loadCredentials(.creds) error = %v", err)
+	}
+	o := natsgo.GetDefaultOptions()
+	if err := opt(&o); err != nil {
+		t.Fatal(err)
+	}
+	if jwt, _ := o.UserJWT(); jwt != "eyJhbGciOiJlZDI1NTE5In0.e30.sig" {
+		t.Fatalf("user JWT = %q", jwt)
+	}
+	sig, err := o.SignatureCB([]byte("nonce"))
+	if err != nil || user.Verify([]byte("nonce"), sig) != nil {
+		t.Fatalf("signature callback did not sign with the seed: %v", err)
+	}
+
+	opt, _ = loadCredentials(read, "user.json")
+	o = natsgo.GetDefaultOptions()
+	opt(&o)
+	if o.User != "bot" || o.Password != "pw" {
+		t.Fatalf("user/password = %q/%q", o.User, o.Password)
+	}
+	opt, _ = loadCredentials(read, "token.json")
+	o = natsgo.GetDefaultOptions()
+	opt(&o)
+	if o.Token != "t0k" {
+		t.Fatalf("token = %q", o.Token)
+	}
+	if _, err := loadCredentials(read, "empty.json"); err == nil {
+		t.Fatal("loadCredentials() accepted JSON without credentials")
+	}
+}
@@ -0,0 +1,7 @@
+package nats
+
+import "github.com/lnxjedi/gopherbot/robot"
+
+func init() {
+	robot.RegisterQueueProvider("nats", Initialize)
+}
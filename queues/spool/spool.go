@@ -0,0 +1,242 @@
+package spool
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+const (
+	defaultPollIntervalSeconds = 5
+	defaultSettleSeconds       = 2
+	defaultMaxBodySize         = 4096
+	defaultMaxRetries          = 5
+	doneDir                    = "done"
+	failedDir                  = "failed"
+)
+
+type config struct {
+	Directory           string
+	PollIntervalSeconds int
+	SettleSeconds       int
+	MaxBodySize         int
+	MaxRetries          int
+}
+
+type queueProvider struct {
+	robot.QueueHandler
+	dir          string
+	pollInterval time.Duration
+	settle       time.Duration
+	maxBodySize  int
+	maxRetries   int
+	// retries counts QueueRetry dispositions per file name; files stay in
+	// the spool until they are accepted or MaxRetries is exceeded.
+	retries map[string]int
+}
+
+// spoolFile is a candidate trigger file found by a scan.
+type spoolFile struct {
+	name    string
+	modTime time.Time
+}
+
+func Initialize(handler robot.QueueHandler, _ *log.Logger) (robot.InitializedQueueProvider, error) {
+	var c config
+	if err := handler.GetQueueConfig(&c); err != nil {
+		return robot.InitializedQueueProvider{}, fmt.Errorf("retrieve spool queue configuration: %w", err)
+	}
+	dir := strings.TrimSpace(c.Directory)
+	if dir == "" {
+		return robot.InitializedQueueProvider{}, fmt.Errorf("spool queue configuration is missing Directory")
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return robot.InitializedQueueProvider{}, fmt.Errorf("resolve spool directory: %w", err)
+	}
+	for _, sub := range []string{"", doneDir, failedDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0750); err != nil {
+			return robot.InitializedQueueProvider{}, fmt.Errorf("create spool directory: %w", err)
+		}
+	}
+	qp := &queueProvider{
+		QueueHandler: handler,
+		dir:          dir,
+		pollInterval: time.Duration(positiveOr(c.PollIntervalSeconds, defaultPollIntervalSeconds)) * time.Second,
+		settle:       time.Duration(c.SettleSeconds) * time.Second,
+		maxBodySize:  positiveOr(c.MaxBodySize, defaultMaxBodySize),
+		maxRetries:   positiveOr(c.MaxRetries, defaultMaxRetries),
+		retries:      map[string]int{},
+	}
+	if c.SettleSeconds == 0 {
+		qp.settle = defaultSettleSeconds * time.Second
+	}
+	if qp.settle < 0 {
+		qp.settle = 0
+	}
+	return robot.InitializedQueueProvider{Provider: qp}, nil
+}
+
+func positiveOr(in, def int) int {
+	if in > 0 {
+		return in
+	}
+	return def
+}
+
+// Run scans the spool directory every PollIntervalSeconds until stop is
+// closed.
+func (q *queueProvider) Run(stop <-chan struct{}) {
+	q.Log(robot.Info, "Spool queue provider watching '%s'", q.dir)
+	ticker := time.NewTicker(q.pollInterval)
+	defer ticker.Stop()
+	for {
+		q.scan(stop, time.Now())
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scan handles each settled trigger file, oldest first.
+func (q *queueProvider) scan(stop <-chan struct{}, now time.Time) {
+	files, err := q.pending(now)
+	if err != nil {
+		q.Log(robot.Error, "Reading spool directory '%s': %v", q.dir, err)
+		return
+	}
+	for _, f := range files {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if !q.handle(f.name) {
+			// The engine is not accepting work; try again next scan.
+			return
+		}
+	}
+}
+
+// pending lists regular files in the spool directory, skipping dotfiles,
+// "*.tmp" files and anything modified within SettleSeconds, so writers can
+// create the file under a temporary name and rename it into place.
+func (q *queueProvider) pending(now time.Time) ([]spoolFile, error) {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []spoolFile
+	seen := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		name := e.Name()
+		if !e.Type().IsRegular() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		seen[name] = true
+		if now.Sub(info.ModTime()) < q.settle {
+			continue
+		}
+		files = append(files, spoolFile{name: name, modTime: info.ModTime()})
+	}
+	for name := range q.retries {
+		if !seen[name] {
+			delete(q.retries, name)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].modTime.Equal(files[j].modTime) {
+			return files[i].name < files[j].name
+		}
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	return files, nil
+}
+
+// handle passes one file to the engine and files it under done/, or failed/
+// when the engine rejected it.
+// It returns false when the engine asked for a retry, so the scan stops
+// instead of working through the rest of the spool.
+func (q *queueProvider) handle(name string) bool {
+	body, err := readBody(filepath.Join(q.dir, name), q.maxBodySize)
+	if err != nil {
+		q.Log(robot.Error, "Spool queue file '%s' rejected: %v", name, err)
+		q.move(name, failedDir)
+		return true
+	}
+	disposition := q.HandleQueueMessage(robot.QueueMessage{
+		ID:         name,
+		Body:       body,
+		Attributes: map[string]string{"file": name},
+	})
+	switch disposition {
+	case robot.QueueAck:
+		delete(q.retries, name)
+		q.move(name, doneDir)
+		return true
+	case robot.QueueReject:
+		delete(q.retries, name)
+		q.move(name, failedDir)
+		return true
+	}
+	q.retries[name]++
+	if q.retries[name] > q.maxRetries {
+		q.Log(robot.Warn, "Spool queue file '%s' retried %d times; moving it to %s/", name, q.maxRetries, failedDir)
+		delete(q.retries, name)
+		q.move(name, failedDir)
+	}
+	return false
+}
+
+// readBody reads at most maxBodySize bytes, trimming the trailing newline
+// that shell redirection usually leaves.
+func readBody(path string, maxBodySize int) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	body, err := io.ReadAll(io.LimitReader(f, int64(maxBodySize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxBodySize {
+		return nil, fmt.Errorf("exceeded MaxBodySize of %d bytes", maxBodySize)
+	}
+	return bytes.TrimRight(body, "\r\n"), nil
+}
+
+// move renames a spool file into sub, adding a numeric suffix rather than
+// replacing an earlier file with the same name.
+func (q *queueProvider) move(name, sub string) {
+	src := filepath.Join(q.dir, name)
+	dst := filepath.Join(q.dir, sub, name)
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(dst); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		dst = filepath.Join(q.dir, sub, name+"."+strconv.Itoa(i))
+	}
+	if err := os.Rename(src, dst); err != nil {
+		q.Log(robot.Error, "Moving spool queue file '%s' to %s/: %v; removing it", name, sub, err)
+		if err := os.Remove(src); err != nil && !errors.Is(err, os.ErrNotExist) {
+			q.Log(robot.Error, "Removing spool queue file '%s': %v", name, err)
+		}
+	}
+}
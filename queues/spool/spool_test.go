@@ -0,0 +1,144 @@
+package spool
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/robot"
+)
+
+type fakeHandler struct {
+	config      config
+	disposition robot.QueueDisposition
+	handled     []robot.QueueMessage
+}
+
+func (h *fakeHandler) GetQueueConfig(v interface{}) error {
+	data, _ := json.Marshal(h.config)
+	return json.Unmarshal(data, v)
+}
+
+func (h *fakeHandler) HandleQueueMessage(msg robot.QueueMessage) robot.QueueDisposition {
+	h.handled = append(h.handled, msg)
+	return h.disposition
+}
+
+func (h *fakeHandler) ReadEncryptedFile(string) ([]byte, error)   { return nil, os.ErrNotExist }
+func (h *fakeHandler) Log(robot.LogLevel, string, ...interface{}) {}
+func (h *fakeHandler) GetInstallPath() string                     { return "" }
+func (h *fakeHandler) GetConfigPath() string                      { return "" }
+
+func newProvider(t *testing.T, h *fakeHandler) *queueProvider {
+	t.Helper()
+	h.config.Directory = t.TempDir()
+	ip, err := Initialize(h, nil)
+	if err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	return ip.Provider.(*queueProvider)
+}
+
+func writeFile(t *testing.T, dir, name, body string, mtime time.Time) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(body), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exists(dir string, parts ...string) bool {
+	_, err := os.Stat(filepath.Join(append([]string{dir}, parts...)...))
+	return err == nil
+}
+
+func TestScanFilesByDisposition(t *testing.T) {
+	h := &fakeHandler{config: config{MaxBodySize: 64}}
+	q := newProvider(t, h)
+	now := time.Now()
+	old := now.Add(-time.Minute)
+	body := "f0e1d2c3-b4a5-4697-8899-aabbccddeeff:20261018120000 'one arg'\n"
+	writeFile(t, q.dir, "second", body, old)
+	writeFile(t, q.dir, "first", body, old.Add(-time.Second))
+	writeFile(t, q.dir, "big", body+body, old)
+	writeFile(t, q.dir, "partial.tmp", body, old)
+	writeFile(t, q.dir, ".hidden", body, old)
+	writeFile(t, q.dir, "fresh", body, now)
+	// An earlier trigger with the same name is kept.
+	writeFile(t, q.dir, filepath.Join(doneDir, "first"), body, old)
+
+	q.scan(make(chan struct{}), now)
+
+	if len(h.handled) != 2 || h.handled[0].ID != "first" || h.handled[1].ID != "second" {
+		t.Fatalf("handled = %+v, want first then second", h.handled)
+	}
+	if got := string(h.handled[0].Body); got != body[:len(body)-1] {
+		t.Fatalf("body = %q, want trailing newline trimmed", got)
+	}
+	if h.handled[0].Attributes["file"] != "first" {
+		t.Fatalf("attributes = %v", h.handled[0].Attributes)
+	}
+	for _, want := range [][]string{
+		{doneDir, "first"}, {doneDir, "first.1"}, {doneDir, "second"},
+		{failedDir, "big"}, {"partial.tmp"}, {".hidden"}, {"fresh"},
+	} {
+		if !exists(q.dir, want...) {
+			t.Errorf("%s missing after scan", filepath.Join(want...))
+		}
+	}
+}
+
+func TestScanRetriesThenFails(t *testing.T) {
+	h := &fakeHandler{config: config{MaxRetries: 2}, disposition: robot.QueueRetry}
+	q := newProvider(t, h)
+	now := time.Now()
+	old := now.Add(-time.Minute)
+	writeFile(t, q.dir, "a", "body", old)
+	writeFile(t, q.dir, "b", "body", old.Add(time.Second))
+
+	for i := 0; i < 2; i++ {
+		q.scan(make(chan struct{}), now)
+		if !exists(q.dir, "a") {
+			t.Fatalf("scan %d: retried file left the spool", i+1)
+		}
+	}
+	// A retry stops the scan, so "b" hasn't been offered yet.
+	if len(h.handled) != 2 || h.handled[1].ID != "a" {
+		t.Fatalf("handled = %+v, want only retries of a", h.handled)
+	}
+	q.scan(make(chan struct{}), now)
+	if !exists(q.dir, failedDir, "a") || !exists(q.dir, "b") {
+		t.Fatal("file past MaxRetries was not moved to failed/")
+	}
+
+	h.disposition = robot.QueueAck
+	q.scan(make(chan struct{}), now)
+	if !exists(q.dir, doneDir, "b") {
+		t.Fatal("accepted file was not moved to done/")
+	}
+}
+
+func TestScanMovesRejectedFilesToFailed(t *testing.T) {
+	h := &fakeHandler{disposition: robot.QueueReject}
+	q := newProvider(t, h)
+	now := time.Now()
+	old := now.Add(-time.Minute)
+	writeFile(t, q.dir, "unknown-uuid", "body", old)
+	writeFile(t, q.dir, "malformed", "body", old.Add(time.Second))
+
+	q.scan(make(chan struct{}), now)
+	// A rejection doesn't stop the scan.
+	if len(h.handled) != 2 {
+		t.Fatalf("handled = %+v, want both files", h.handled)
+	}
+	for _, name := range []string{"unknown-uuid", "malformed"} {
+		if !exists(q.dir, failedDir, name) || exists(q.dir, doneDir, name) {
+			t.Errorf("rejected file %s was not moved to failed/", name)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+package spool
+
+import "github.com/lnxjedi/gopherbot/robot"
+
+func init() {
+	robot.RegisterQueueProvider("spool", Initialize)
+}
@@ -25,6 +25,13 @@ else
   PAYLOAD="${JOB_UUID}:${TIMESTAMP}"
 fi
 
+# Jobs with a UUIDTriggerKey require a signed body; 'gopherbot sign-trigger'
+# produces the same suffix.
+if [[ -n "${JOB_TRIGGER_KEY:-}" ]]; then
+  SIGNATURE=$(printf '%s' "${PAYLOAD}" | openssl dgst -sha256 -hmac "${JOB_TRIGGER_KEY}" | sed 's/^.*= //')
+  PAYLOAD="${PAYLOAD} sig=${SIGNATURE}"
+fi
+
 HTTP_STATUS=$(curl -s -o /dev/null -w "%{http_code}" -X POST "${WEBHOOK_URL}" \
   -H "Content-Type: text/plain" \
   --data-binary "${PAYLOAD}")
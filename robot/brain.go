@@ -55,6 +55,9 @@ type RemoteBrainRecord struct {
 	Checksum  string
 	Deleted   bool
 	UpdatedAt time.Time
+	// KeyID identifies the robot data key that encrypted Payload; empty for
+	// records written before key rotation support.
+	KeyID string
 }
 
 type RemoteBrainPage struct {
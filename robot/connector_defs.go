@@ -20,6 +20,16 @@ const (
 	Null
 	// SSH connector for local development
 	SSH
+	// Matrix connector for the Matrix client-server API
+	Matrix
+	// Mattermost connector using the websocket event stream and REST API
+	Mattermost
+	// Teams connector for Microsoft Teams, via Graph and Azure Event Hubs
+	Teams
+	// Discord connector using the gateway and REST API
+	Discord
+	// IRC connector with TLS and SASL/NickServ authentication
+	IRC
 )
 
 // ConnectorMessage is passed in to the robot for every incoming message seen.
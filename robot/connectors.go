@@ -25,6 +25,25 @@ type HiddenCommandFormatter interface {
 	FormatHiddenCommand(string) string
 }
 
+// CommandHelp describes one plugin command, from the same Usage and Summary
+// the help metadata uses.
+type CommandHelp struct {
+	Plugin  string
+	Command string
+	Usage   string
+	Summary string
+}
+
+// CommandRegistrar is an optional connector contract for connectors that
+// can publish plugin commands to the transport, e.g. as native slash
+// commands. The engine calls RegisterCommands with the commands every user
+// can run in every channel when the connector starts and after each
+// configuration reload; matching still happens in the engine, so
+// registration is only a convenience for users.
+type CommandRegistrar interface {
+	RegisterCommands([]CommandHelp)
+}
+
 var connectorRegistry = struct {
 	sync.RWMutex
 	registrations map[string]ConnectorRegistration
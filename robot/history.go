@@ -1,6 +1,9 @@
 package robot
 
-import "io"
+import (
+	"io"
+	"time"
+)
 
 // HistoryLogger is provided by a HistoryProvider for each job / plugin run
 // where it's requested
@@ -15,6 +18,13 @@ type HistoryLogger interface {
 	Finalize()
 }
 
+// TaskHistoryLogger is optionally implemented by a HistoryLogger that records
+// which task produced each line; the engine calls SetTask as each task in the
+// pipeline starts.
+type TaskHistoryLogger interface {
+	SetTask(name string)
+}
+
 // HistoryProvider is responsible for storing and retrieving job histories
 type HistoryProvider interface {
 	// NewLog provides a HistoryLogger for the given tag / index, and
@@ -28,3 +38,33 @@ type HistoryProvider interface {
 	// URL need only be available for a short timespan, e.g. 42 seconds
 	MakeLogURL(tag string, index int) (URL string, exists bool)
 }
+
+// HistoryEntry is a single structured line from a history log.
+type HistoryEntry struct {
+	Time     time.Time `json:"time"`
+	Stream   string    `json:"stream"`          // OUT, ERR, LOG, or "" for section lines
+	Level    string    `json:"level,omitempty"` // log level for LOG lines
+	Task     string    `json:"task,omitempty"`
+	Pipeline string    `json:"pipeline"` // history tag, normally the job name
+	Run      int       `json:"run"`
+	Line     string    `json:"line"`
+}
+
+// HistoryQuery selects entries for HistorySearcher.SearchLogs; zero values
+// match everything.
+type HistoryQuery struct {
+	Pipeline string // matches the tag or "<Pipeline>:..." tags
+	Task     string
+	Stream   string
+	Level    string
+	Since    time.Time
+	Until    time.Time
+	Contains string // case-insensitive substring of Line
+	Limit    int    // keep only the most recent Limit matches
+}
+
+// HistorySearcher is optionally implemented by a HistoryProvider that can
+// query across runs. Entries are returned oldest first.
+type HistorySearcher interface {
+	SearchLogs(q HistoryQuery) ([]HistoryEntry, error)
+}
@@ -15,11 +15,16 @@ func _() {
 	_ = x[Test-4]
 	_ = x[Null-5]
 	_ = x[SSH-6]
+	_ = x[Matrix-7]
+	_ = x[Mattermost-8]
+	_ = x[Teams-9]
+	_ = x[Discord-10]
+	_ = x[IRC-11]
 }
 
-const _Protocol_name = "SlackGoogleChatRocketTerminalTestNullSSH"
+const _Protocol_name = "SlackGoogleChatRocketTerminalTestNullSSHMatrixMattermostTeamsDiscordIRC"
 
-var _Protocol_index = [...]uint8{0, 5, 15, 21, 29, 33, 37, 40}
+var _Protocol_index = [...]uint8{0, 5, 15, 21, 29, 33, 37, 40, 46, 56, 61, 68, 71}
 
 func (i Protocol) String() string {
 	if i < 0 || i >= Protocol(len(_Protocol_index)-1) {
@@ -16,6 +16,10 @@ type QueueDisposition int
 const (
 	QueueAck QueueDisposition = iota
 	QueueRetry
+	// QueueReject is a message the engine refused (malformed, unknown UUID,
+	// bad signature). Providers without a place for rejected messages
+	// treat it like QueueAck.
+	QueueReject
 )
 
 type QueueProvider interface {
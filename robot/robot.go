@@ -1,6 +1,9 @@
 package robot
 
-import "bytes"
+import (
+	"bytes"
+	"time"
+)
 
 // AttrRet implements Stringer so it can be interpolated with fmt if
 // the plugin author is ok with ignoring the RetVal.
@@ -239,6 +242,11 @@ type Robot interface {
 	// a struct to marshall and a (hopefully good) lock token. If err != nil, the
 	// update failed.
 	UpdateDatum(key, locktoken string, datum interface{}) (ret RetVal)
+	// UpdateDatumWithTTL is UpdateDatum for memories that should expire; the
+	// datum is deleted from the brain once ttl has elapsed. Each call resets
+	// the expiry, a ttl <= 0 makes the datum permanent again, and a plain
+	// UpdateDatum leaves an existing expiry unchanged.
+	UpdateDatumWithTTL(key, locktoken string, datum interface{}, ttl time.Duration) (ret RetVal)
 	// DeleteDatum deletes a long-term memory datum from the robot's brain.
 	// This is idempotent: deleting a non-existent key should still return Ok.
 	DeleteDatum(key string) (ret RetVal)
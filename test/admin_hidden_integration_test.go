@@ -20,6 +20,8 @@ func TestHiddenAdminInspectCommands(t *testing.T) {
 		{aliceID, general, "/bender: dump plugin echo", false, []TestMessage{{null, general, "ALLCHANNELS.*", false}}, []Event{AdminCheckPassed, CommandTaskRan, GoPluginRan}, 0},
 		{aliceID, general, "/bender: dump plugin default echo", false, []TestMessage{{null, general, "HERE'S.*", false}}, []Event{AdminCheckPassed, CommandTaskRan, GoPluginRan}, 0},
 		{aliceID, general, "/bender: dump plugin junk", false, []TestMessage{{null, general, "Didn't find .* junk", false}}, []Event{AdminCheckPassed, CommandTaskRan, GoPluginRan}, 0},
+		{aliceID, general, ";brain show bot:encryptionKey", false, []TestMessage{{null, general, "This command is only available in a private context.", false}}, []Event{AdminCheckPassed}, 0},
+		{aliceID, general, "/bender: brain show bot:encryptionKey", false, []TestMessage{{null, general, "'bot:encryptionKey' is internal.*", false}}, []Event{AdminCheckPassed, CommandTaskRan, GoPluginRan}, 0},
 	}
 	testcases(t, conn, tests)
 